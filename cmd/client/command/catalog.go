@@ -0,0 +1,46 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"net/http"
+
+	"github.com/spf13/cobra"
+)
+
+// CatalogCmd defines catalog command.
+func CatalogCmd() *cobra.Command {
+	var openAPI bool
+
+	cmd := &cobra.Command{
+		Use:     "catalog",
+		Short:   "List the APIs published by HTTPServers",
+		Example: "egctl catalog --openapi",
+		Run: func(cmd *cobra.Command, args []string) {
+			url := makeURL(catalogURL)
+			if openAPI {
+				url += "?openapi=true"
+			}
+			handleRequest(http.MethodGet, url, nil, cmd)
+		},
+	}
+
+	cmd.Flags().BoolVar(&openAPI, "openapi", false, "Merge the metadata of OpenAPI documents of OpenAPIValidator filters")
+
+	return cmd
+}
@@ -64,6 +64,12 @@ const (
 	wasmCodeURL = apiURL + "/wasm/code"
 	wasmDataURL = apiURL + "/wasm/data/%s/%s"
 
+	drainingServersURL = apiURL + "/proxy/drainingservers"
+
+	maintenanceURL = apiURL + "/httpservers/maintenance"
+
+	catalogURL = apiURL + "/catalog"
+
 	// MeshTenantsURL is the mesh tenant prefix.
 	MeshTenantsURL = apiURL + "/mesh/tenants"
 
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+// HTTPServerCmd defines httpserver command.
+func HTTPServerCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "httpserver",
+		Short: "Manage the maintenance mode of HTTPServers",
+	}
+
+	cmd.AddCommand(httpServerMaintainCmd())
+	cmd.AddCommand(httpServerResumeCmd())
+	cmd.AddCommand(httpServerListMaintenanceCmd())
+	return cmd
+}
+
+func httpServerMaintainCmd() *cobra.Command {
+	var reason string
+
+	cmd := &cobra.Command{
+		Use:     "maintain",
+		Short:   "Put an HTTPServer in maintenance, it answers all requests with 503",
+		Example: "egctl httpserver maintain <server name> [--reason <reason>]",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				return nil
+			}
+			return fmt.Errorf("requires server name")
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			body := fmt.Sprintf("server: %q\nreason: %q\n", args[0], reason)
+			handleRequest(http.MethodPost, makeURL(maintenanceURL), []byte(body), cmd)
+		},
+	}
+	cmd.Flags().StringVarP(&reason, "reason", "", "", "The reason of the maintenance.")
+
+	return cmd
+}
+
+func httpServerResumeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "resume",
+		Short:   "Take an HTTPServer out of the maintenance turned on by maintain",
+		Example: "egctl httpserver resume <server name>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				return nil
+			}
+			return fmt.Errorf("requires server name")
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodDelete, makeURL(maintenanceURL)+"?server="+url.QueryEscape(args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func httpServerListMaintenanceCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list-maintenance",
+		Short:   "List HTTPServers in maintenance turned on by maintain",
+		Example: "egctl httpserver list-maintenance",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(maintenanceURL), nil, cmd)
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package command
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/spf13/cobra"
+)
+
+// ProxyCmd defines proxy command.
+func ProxyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "proxy",
+		Short: "Manage upstream servers of proxies",
+	}
+
+	cmd.AddCommand(proxyDrainCmd())
+	cmd.AddCommand(proxyUndrainCmd())
+	cmd.AddCommand(proxyListDrainingCmd())
+	return cmd
+}
+
+func proxyDrainCmd() *cobra.Command {
+	var keepSticky bool
+
+	cmd := &cobra.Command{
+		Use:     "drain",
+		Short:   "Mark an upstream server as draining, it stops receiving new requests",
+		Example: "egctl proxy drain <server url> [--keep-sticky]",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				return nil
+			}
+			return fmt.Errorf("requires server url")
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			body := fmt.Sprintf("url: %q\nkeepSticky: %v\n", args[0], keepSticky)
+			handleRequest(http.MethodPost, makeURL(drainingServersURL), []byte(body), cmd)
+		},
+	}
+	cmd.Flags().BoolVarP(&keepSticky, "keep-sticky", "", false, "Keep routing sticky sessions (ipHash and headerHash) to the server.")
+
+	return cmd
+}
+
+func proxyUndrainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "undrain",
+		Short:   "Mark an upstream server as not draining",
+		Example: "egctl proxy undrain <server url>",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				return nil
+			}
+			return fmt.Errorf("requires server url")
+		},
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodDelete, makeURL(drainingServersURL)+"?url="+url.QueryEscape(args[0]), nil, cmd)
+		},
+	}
+
+	return cmd
+}
+
+func proxyListDrainingCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "list-draining",
+		Short:   "List draining upstream servers",
+		Example: "egctl proxy list-draining",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			handleRequest(http.MethodGet, makeURL(drainingServersURL), nil, cmd)
+		},
+	}
+
+	return cmd
+}
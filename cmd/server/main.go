@@ -33,6 +33,9 @@ import (
 	"github.com/megaease/easegress/pkg/profile"
 	_ "github.com/megaease/easegress/pkg/registry"
 	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/dnsresolver"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+	"github.com/megaease/easegress/pkg/util/fips"
 	"github.com/megaease/easegress/pkg/version"
 )
 
@@ -56,6 +59,29 @@ func main() {
 	defer logger.Sync()
 	logger.Infof("%s", version.Long)
 
+	if opt.FIPS {
+		fips.Enable()
+	}
+	if fips.Enabled() {
+		logger.Infof("FIPS mode enabled, BoringCrypto: %v", fips.BoringCrypto())
+	}
+
+	if len(opt.DNSResolvers) != 0 {
+		if err := dnsresolver.Enable(opt.DNSResolvers); err != nil {
+			logger.Errorf("invalid dns-resolvers: %v", err)
+			os.Exit(1)
+		}
+		logger.Infof("DNS resolvers: %v", opt.DNSResolvers)
+	}
+
+	if spec := opt.EgressProxySpec(); spec != nil {
+		if err := egressproxy.SetGlobal(spec); err != nil {
+			logger.Errorf("invalid egress-proxy: %v", err)
+			os.Exit(1)
+		}
+		logger.Infof("egress proxy enabled, no proxy: %v", spec.NoProxy)
+	}
+
 	if opt.SignalUpgrade {
 		pid, err := pidfile.Read(opt)
 
@@ -99,6 +99,7 @@ func (s *Server) registerAPIs() {
 	group.Entries = append(group.Entries, s.memberAPIEntries()...)
 	group.Entries = append(group.Entries, s.objectAPIEntries()...)
 	group.Entries = append(group.Entries, s.metadataAPIEntries()...)
+	group.Entries = append(group.Entries, s.catalogAPIEntries()...)
 	group.Entries = append(group.Entries, s.healthAPIEntries()...)
 	group.Entries = append(group.Entries, s.aboutAPIEntries()...)
 
@@ -0,0 +1,237 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/openapivalidator"
+	"github.com/megaease/easegress/pkg/filter/ratelimiter"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/urlrule"
+)
+
+// CatalogPrefix is the prefix of the API catalog.
+const CatalogPrefix = "/catalog"
+
+type (
+	// CatalogEntry is a route published by an HTTPServer.
+	CatalogEntry struct {
+		Server      string   `yaml:"server"`
+		Host        string   `yaml:"host,omitempty"`
+		HostRegexp  string   `yaml:"hostRegexp,omitempty"`
+		Path        string   `yaml:"path,omitempty"`
+		PathPrefix  string   `yaml:"pathPrefix,omitempty"`
+		PathRegexp  string   `yaml:"pathRegexp,omitempty"`
+		Methods     []string `yaml:"methods,omitempty"`
+		Backend     string   `yaml:"backend"`
+		RequireAuth bool     `yaml:"requireAuth"`
+
+		*CatalogBackend `yaml:",inline"`
+	}
+
+	// CatalogBackend is the API related information of a pipeline.
+	CatalogBackend struct {
+		// Authenticators are the filters authenticating requests.
+		Authenticators []string            `yaml:"authenticators,omitempty"`
+		RateLimits     []*CatalogRateLimit `yaml:"rateLimits,omitempty"`
+
+		// Operations are merged from the OpenAPI documents of the
+		// OpenAPIValidator filters.
+		Operations   []*openapivalidator.OperationInfo `yaml:"operations,omitempty"`
+		OpenAPIError string                            `yaml:"openAPIError,omitempty"`
+	}
+
+	// CatalogRateLimit is a rate limit of a pipeline.
+	CatalogRateLimit struct {
+		Filter  string   `yaml:"filter"`
+		Methods []string `yaml:"methods,omitempty"`
+		URL     string   `yaml:"url"`
+		// Limit is in format <limitForPeriod>/<limitRefreshPeriod>.
+		Limit string `yaml:"limit"`
+		Key   string `yaml:"key,omitempty"`
+	}
+)
+
+func (s *Server) catalogAPIEntries() []*Entry {
+	return []*Entry{
+		{
+			Path:    CatalogPrefix,
+			Method:  "GET",
+			Handler: s.listCatalog,
+		},
+	}
+}
+
+// listCatalog lists the routes of all HTTPServers, the OpenAPI metadata is
+// merged if query parameter openapi is true.
+func (s *Server) listCatalog(w http.ResponseWriter, r *http.Request) {
+	withOpenAPI, _ := strconv.ParseBool(r.URL.Query().Get("openapi"))
+
+	catalog := buildCatalog(s.super, s._listObjects(), withOpenAPI)
+
+	buff, err := yaml.Marshal(catalog)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", catalog, err))
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func buildCatalog(super *supervisor.Supervisor, specs []*supervisor.Spec, withOpenAPI bool) []*CatalogEntry {
+	pipelines := map[string]*httppipeline.Spec{}
+	var servers []*supervisor.Spec
+	for _, spec := range specs {
+		switch spec.Kind() {
+		case httppipeline.Kind:
+			pipelines[spec.Name()] = spec.ObjectSpec().(*httppipeline.Spec)
+		case httpserver.Kind:
+			servers = append(servers, spec)
+		}
+	}
+
+	backends := map[string]*CatalogBackend{}
+	getBackend := func(name string) *CatalogBackend {
+		if b, exists := backends[name]; exists {
+			return b
+		}
+		b := &CatalogBackend{}
+		if p := pipelines[name]; p != nil {
+			b = newCatalogBackend(super, p, withOpenAPI)
+		}
+		backends[name] = b
+		return b
+	}
+
+	catalog := []*CatalogEntry{}
+	for _, server := range servers {
+		spec := server.ObjectSpec().(*httpserver.Spec)
+		for _, rule := range spec.Rules {
+			for _, path := range rule.Paths {
+				catalog = append(catalog, &CatalogEntry{
+					Server:         server.Name(),
+					Host:           rule.Host,
+					HostRegexp:     rule.HostRegexp,
+					Path:           path.Path,
+					PathPrefix:     path.PathPrefix,
+					PathRegexp:     path.PathRegexp,
+					Methods:        path.Methods,
+					Backend:        path.Backend,
+					RequireAuth:    path.RequireAuth || (spec.RequireAuth && !path.AllowAnonymous),
+					CatalogBackend: getBackend(path.Backend),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(catalog, func(i, j int) bool {
+		return catalog[i].Server < catalog[j].Server
+	})
+	return catalog
+}
+
+func newCatalogBackend(super *supervisor.Supervisor, spec *httppipeline.Spec, withOpenAPI bool) *CatalogBackend {
+	b := &CatalogBackend{}
+	filterRegistry := httppipeline.GetFilterRegistry()
+
+	for _, rawSpec := range spec.Filters {
+		filterSpec, err := httppipeline.NewFilterSpec(rawSpec, super)
+		if err != nil {
+			// NOTE: The spec has been validated when it was created.
+			continue
+		}
+
+		if _, ok := filterRegistry[filterSpec.Kind()].(httppipeline.AuthFilter); ok {
+			b.Authenticators = append(b.Authenticators, filterSpec.Name())
+		}
+
+		switch fs := filterSpec.FilterSpec().(type) {
+		case *ratelimiter.Spec:
+			b.RateLimits = append(b.RateLimits, catalogRateLimits(filterSpec.Name(), fs)...)
+		case *openapivalidator.Spec:
+			if !withOpenAPI {
+				continue
+			}
+			ops, err := fs.Operations()
+			if err != nil {
+				b.OpenAPIError = fmt.Sprintf("%s: %v", filterSpec.Name(), err)
+				continue
+			}
+			b.Operations = append(b.Operations, ops...)
+		}
+	}
+
+	return b
+}
+
+func catalogRateLimits(name string, spec *ratelimiter.Spec) []*CatalogRateLimit {
+	policies := map[string]*ratelimiter.Policy{}
+	for _, p := range spec.Policies {
+		policies[p.Name] = p
+	}
+
+	var limits []*CatalogRateLimit
+	for _, u := range spec.URLs {
+		ref := u.PolicyRef
+		if ref == "" {
+			ref = spec.DefaultPolicyRef
+		}
+		policy := policies[ref]
+		if policy == nil {
+			continue
+		}
+
+		// NOTE: Keep the defaults consistent with RateLimiter.
+		limit, period := policy.LimitForPeriod, policy.LimitRefreshPeriod
+		if limit == 0 {
+			limit = 50
+		}
+		if period == "" {
+			period = "10ms"
+		}
+		limits = append(limits, &CatalogRateLimit{
+			Filter:  name,
+			Methods: u.Methods,
+			URL:     catalogURL(&u.URL),
+			Limit:   fmt.Sprintf("%d/%s", limit, period),
+			Key:     u.Key,
+		})
+	}
+	return limits
+}
+
+func catalogURL(sm *urlrule.StringMatch) string {
+	switch {
+	case sm.Exact != "":
+		return sm.Exact
+	case sm.Prefix != "":
+		return sm.Prefix + "*"
+	case sm.RegEx != "":
+		return "~" + sm.RegEx
+	default:
+		return "*"
+	}
+}
@@ -102,8 +102,12 @@ func (s *Server) _listObjects() []*supervisor.Spec {
 }
 
 func (s *Server) _putObject(spec *supervisor.Spec) {
-	err := s.cluster.Put(s.cluster.Layout().ConfigObjectKey(spec.Name()),
-		spec.YAMLConfig())
+	config, err := s.super.SealSecrets(spec.YAMLConfig())
+	if err != nil {
+		panic(fmt.Errorf("seal secrets of %s failed: %v", spec.Name(), err))
+	}
+
+	err = s.cluster.Put(s.cluster.Layout().ConfigObjectKey(spec.Name()), config)
 	if err != nil {
 		ClusterPanic(err)
 	}
@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/object/httpserver"
+)
+
+const maintenancePath = "/httpservers/maintenance"
+
+func (s *Server) listMaintenance(w http.ResponseWriter, r *http.Request) {
+	kvs, err := s.cluster.GetPrefix(s.cluster.Layout().MaintenancePrefix())
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	servers := []*httpserver.Maintenance{}
+	for k, v := range kvs {
+		m := &httpserver.Maintenance{}
+		if err := yaml.Unmarshal([]byte(v), m); err != nil {
+			panic(fmt.Errorf("unmarshal %s to yaml failed: %v", k, err))
+		}
+		servers = append(servers, m)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].Server < servers[j].Server
+	})
+
+	buff, err := yaml.Marshal(servers)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", servers, err))
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func (s *Server) startMaintenance(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	m := &httpserver.Maintenance{}
+	if err = yaml.Unmarshal(body, m); err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err = m.Validate(); err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	spec := s._getObject(m.Server)
+	if spec == nil || spec.Kind() != httpserver.Kind {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("httpserver %s not found", m.Server))
+		return
+	}
+	m.Since = time.Now()
+
+	buff, err := yaml.Marshal(m)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", m, err))
+	}
+
+	if err = s.cluster.Put(s.cluster.Layout().MaintenanceKey(m.Server), string(buff)); err != nil {
+		ClusterPanic(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) stopMaintenance(w http.ResponseWriter, r *http.Request) {
+	server := r.URL.Query().Get("server")
+	if server == "" {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("server is required"))
+		return
+	}
+
+	key := s.cluster.Layout().MaintenanceKey(server)
+	value, err := s.cluster.Get(key)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if value == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("httpserver %s is not in maintenance", server))
+		return
+	}
+
+	if err = s.cluster.Delete(key); err != nil {
+		ClusterPanic(err)
+	}
+}
+
+func appendMaintenanceAPI(s *Server, group *Group) {
+	group.Entries = append(group.Entries,
+		&Entry{
+			Path:    maintenancePath,
+			Method:  http.MethodGet,
+			Handler: s.listMaintenance,
+		},
+		&Entry{
+			Path:    maintenancePath,
+			Method:  http.MethodPost,
+			Handler: s.startMaintenance,
+		},
+		&Entry{
+			Path:    maintenancePath,
+			Method:  http.MethodDelete,
+			Handler: s.stopMaintenance,
+		},
+	)
+}
+
+func init() {
+	appendAddonAPIs = append(appendAddonAPIs, appendMaintenanceAPI)
+}
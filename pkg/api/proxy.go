@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package api
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/filter/proxy"
+)
+
+const drainingServersPath = "/proxy/drainingservers"
+
+func (s *Server) listDrainingServers(w http.ResponseWriter, r *http.Request) {
+	kvs, err := s.cluster.GetPrefix(s.cluster.Layout().DrainingServerPrefix())
+	if err != nil {
+		ClusterPanic(err)
+	}
+
+	servers := []*proxy.DrainingServer{}
+	for k, v := range kvs {
+		server := &proxy.DrainingServer{}
+		if err := yaml.Unmarshal([]byte(v), server); err != nil {
+			panic(fmt.Errorf("unmarshal %s to yaml failed: %v", k, err))
+		}
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		return servers[i].URL < servers[j].URL
+	})
+
+	buff, err := yaml.Marshal(servers)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", servers, err))
+	}
+
+	w.Header().Set("Content-Type", "text/vnd.yaml")
+	w.Write(buff)
+}
+
+func (s *Server) drainServer(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	server := &proxy.DrainingServer{}
+	if err = yaml.Unmarshal(body, server); err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err = server.Validate(); err != nil {
+		HandleAPIError(w, r, http.StatusBadRequest, err)
+		return
+	}
+	server.Since = time.Now()
+
+	buff, err := yaml.Marshal(server)
+	if err != nil {
+		panic(fmt.Errorf("marshal %#v to yaml failed: %v", server, err))
+	}
+
+	if err = s.cluster.Put(s.cluster.Layout().DrainingServerKey(server.URL), string(buff)); err != nil {
+		ClusterPanic(err)
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *Server) undrainServer(w http.ResponseWriter, r *http.Request) {
+	url := r.URL.Query().Get("url")
+	if url == "" {
+		HandleAPIError(w, r, http.StatusBadRequest, fmt.Errorf("url is required"))
+		return
+	}
+
+	key := s.cluster.Layout().DrainingServerKey(url)
+	value, err := s.cluster.Get(key)
+	if err != nil {
+		ClusterPanic(err)
+	}
+	if value == nil {
+		HandleAPIError(w, r, http.StatusNotFound, fmt.Errorf("server %s is not draining", url))
+		return
+	}
+
+	if err = s.cluster.Delete(key); err != nil {
+		ClusterPanic(err)
+	}
+}
+
+func appendProxyAPI(s *Server, group *Group) {
+	group.Entries = append(group.Entries,
+		&Entry{
+			Path:    drainingServersPath,
+			Method:  http.MethodGet,
+			Handler: s.listDrainingServers,
+		},
+		&Entry{
+			Path:    drainingServersPath,
+			Method:  http.MethodPost,
+			Handler: s.drainServer,
+		},
+		&Entry{
+			Path:    drainingServersPath,
+			Method:  http.MethodDelete,
+			Handler: s.undrainServer,
+		},
+	)
+}
+
+func init() {
+	appendAddonAPIs = append(appendAddonAPIs, appendProxyAPI)
+}
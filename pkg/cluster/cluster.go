@@ -70,6 +70,10 @@ type (
 
 		// Etcd is non-nil only if it's cluster status is primary.
 		Etcd *EtcdStatus `yaml:"etcd,omitempty"`
+
+		// DroppedLogs is the number of traffic logs dropped by every log
+		// file as its buffer is full.
+		DroppedLogs map[string]uint64 `yaml:"droppedLogs,omitempty"`
 	}
 
 	// EtcdStatus is the etcd status,
@@ -792,6 +796,7 @@ func (c *cluster) syncStatus() error {
 	}
 
 	status.LastHeartbeatTime = time.Now().Format(time.RFC3339)
+	status.DroppedLogs = logger.DroppedLogs()
 
 	buff, err := yaml.Marshal(status)
 	if err != nil {
@@ -34,6 +34,12 @@ const (
 	configVersion            = "/config/version"
 	wasmCodeEvent            = "/wasm/code"
 	wasmDataPrefixFormat     = "/wasm/data/%s/%s/"
+	drainingServerPrefix     = "/draining/servers/"
+	drainingServerFormat     = "/draining/servers/%s" // +serverURL
+	maintenancePrefix        = "/maintenance/httpservers/"
+	maintenanceFormat        = "/maintenance/httpservers/%s" // +serverName
+	integrityKeyFormat       = "/integrity/keys/%s/%s"       // +pipeline +filter
+	secretDataKey            = "/secrets/datakey"
 
 	// the cluster name of this eg group will be registered under this path in etcd
 	// any new member(primary or secondary ) will be rejected if it is configured a different cluster name
@@ -128,3 +134,35 @@ func (l *Layout) WasmCodeEvent() string {
 func (l *Layout) WasmDataPrefix(pipeline string, name string) string {
 	return fmt.Sprintf(wasmDataPrefixFormat, pipeline, name)
 }
+
+// DrainingServerPrefix returns the prefix of draining servers.
+func (l *Layout) DrainingServerPrefix() string {
+	return drainingServerPrefix
+}
+
+// DrainingServerKey returns the key of a draining server.
+func (l *Layout) DrainingServerKey(url string) string {
+	return fmt.Sprintf(drainingServerFormat, url)
+}
+
+// MaintenancePrefix returns the prefix of HTTPServers in maintenance.
+func (l *Layout) MaintenancePrefix() string {
+	return maintenancePrefix
+}
+
+// MaintenanceKey returns the key of an HTTPServer in maintenance.
+func (l *Layout) MaintenanceKey(serverName string) string {
+	return fmt.Sprintf(maintenanceFormat, serverName)
+}
+
+// IntegrityKey returns the key of the signing key of the response integrity
+// filter.
+func (l *Layout) IntegrityKey(pipeline, name string) string {
+	return fmt.Sprintf(integrityKeyFormat, pipeline, name)
+}
+
+// SecretDataKey returns the key of the wrapped data key to encrypt secrets
+// of objects.
+func (l *Layout) SecretDataKey() string {
+	return secretDataKey
+}
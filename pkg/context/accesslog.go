@@ -0,0 +1,33 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+// KeyNoAccessLog is the key of the flag which skips the access log of
+// the request, the value is of type bool.
+const KeyNoAccessLog = "noAccessLog"
+
+// SetNoAccessLog marks the request not to be written to the access log.
+func SetNoAccessLog(ctx HTTPContext) {
+	ctx.SetKV(KeyNoAccessLog, true)
+}
+
+// NoAccessLog reports whether the access log of the request is skipped.
+func NoAccessLog(ctx HTTPContext) bool {
+	skipped, _ := ctx.GetKV(KeyNoAccessLog).(bool)
+	return skipped
+}
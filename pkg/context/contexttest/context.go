@@ -31,6 +31,7 @@ import (
 type MockedHTTPContext struct {
 	lock                     sync.Mutex
 	finishFuncs              []func()
+	kv                       map[string]interface{}
 	MockedLock               func()
 	MockedUnlock             func()
 	MockedSpan               func() tracing.Span
@@ -54,6 +55,8 @@ type MockedHTTPContext struct {
 	MockedSaveRspToTemplate  func(filterName string) error
 	MockedCallNextHandler    func(lastResult string) string
 	MockedSetHandlerCaller   func(caller context.HandlerCaller)
+	MockedSetKV              func(key string, value interface{})
+	MockedGetKV              func(key string) interface{}
 }
 
 // Protocol return protocol of MockedHTTPContext
@@ -242,6 +245,26 @@ func (c *MockedHTTPContext) CallNextHandler(lastResult string) string {
 // SetHandlerCaller mocks the SetHandlerCaller function of HTTPContext
 func (c *MockedHTTPContext) SetHandlerCaller(caller context.HandlerCaller) {
 	if c.MockedSetHandlerCaller != nil {
-		c.SetHandlerCaller(caller)
+		c.MockedSetHandlerCaller(caller)
 	}
 }
+
+// SetKV mocks the SetKV function of HTTPContext
+func (c *MockedHTTPContext) SetKV(key string, value interface{}) {
+	if c.MockedSetKV != nil {
+		c.MockedSetKV(key, value)
+		return
+	}
+	if c.kv == nil {
+		c.kv = make(map[string]interface{})
+	}
+	c.kv[key] = value
+}
+
+// GetKV mocks the GetKV function of HTTPContext
+func (c *MockedHTTPContext) GetKV(key string) interface{} {
+	if c.MockedGetKV != nil {
+		return c.MockedGetKV(key)
+	}
+	return c.kv[key]
+}
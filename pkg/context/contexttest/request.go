@@ -22,11 +22,13 @@ import (
 	"net/http"
 
 	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/tlsfingerprint"
 )
 
 // MockedHTTPRequest is the mocked HTTP request
 type MockedHTTPRequest struct {
 	MockedRealIP      func() string
+	MockedSetRealIP   func(ip string)
 	MockedMethod      func() string
 	MockedSetMethod   func(method string)
 	MockedScheme      func() string
@@ -47,6 +49,8 @@ type MockedHTTPRequest struct {
 	MockedSetBody     func(io.Reader)
 	MockedStd         func() *http.Request
 	MockedSize        func() uint64
+
+	MockedTLSFingerprint func() *tlsfingerprint.Fingerprint
 }
 
 // RealIP mocks the RealIP function of HTTPRequest
@@ -57,6 +61,13 @@ func (r *MockedHTTPRequest) RealIP() string {
 	return ""
 }
 
+// SetRealIP mocks the SetRealIP function of HTTPRequest
+func (r *MockedHTTPRequest) SetRealIP(ip string) {
+	if r.MockedSetRealIP != nil {
+		r.MockedSetRealIP(ip)
+	}
+}
+
 // Method mocks the Method function of HTTPRequest
 func (r *MockedHTTPRequest) Method() string {
 	if r.MockedMethod != nil {
@@ -210,3 +221,11 @@ func (r *MockedHTTPRequest) Size() uint64 {
 	}
 	return 0
 }
+
+// TLSFingerprint mocks the TLSFingerprint function of HTTPRequest
+func (r *MockedHTTPRequest) TLSFingerprint() *tlsfingerprint.Fingerprint {
+	if r.MockedTLSFingerprint != nil {
+		return r.MockedTLSFingerprint()
+	}
+	return nil
+}
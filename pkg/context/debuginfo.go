@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	"strings"
+	"sync"
+)
+
+// KeyDebugInfo is the key of the debug information of requests in the
+// debug mode, the value is of type *DebugInfo.
+const KeyDebugInfo = "debug"
+
+// DebugInfo collects the routing decisions of a request in the debug
+// mode, they are sent back to the client in response headers.
+type DebugInfo struct {
+	mutex sync.Mutex
+
+	route     string
+	backend   string
+	pipelines []string
+	upstreams []string
+}
+
+// GetDebugInfo returns the debug information of the request, nil is
+// returned if the request is not in the debug mode.
+func GetDebugInfo(ctx HTTPContext) *DebugInfo {
+	info, _ := ctx.GetKV(KeyDebugInfo).(*DebugInfo)
+	return info
+}
+
+// SetRoute sets the matched route and the backend.
+func (di *DebugInfo) SetRoute(route, backend string) {
+	di.mutex.Lock()
+	defer di.mutex.Unlock()
+	di.route, di.backend = route, backend
+}
+
+// AddPipeline adds the filters ran by a pipeline with their results
+// and timings.
+func (di *DebugInfo) AddPipeline(name, filters string) {
+	di.mutex.Lock()
+	defer di.mutex.Unlock()
+	di.pipelines = append(di.pipelines, name+": "+filters)
+}
+
+// AddUpstream adds an upstream chosen by a proxy.
+func (di *DebugInfo) AddUpstream(name, server string) {
+	di.mutex.Lock()
+	defer di.mutex.Unlock()
+	di.upstreams = append(di.upstreams, name+": "+server)
+}
+
+// Route returns the matched route and the backend.
+func (di *DebugInfo) Route() (route, backend string) {
+	di.mutex.Lock()
+	defer di.mutex.Unlock()
+	return di.route, di.backend
+}
+
+// Pipelines returns the filters ran by pipelines, separated by "; ".
+func (di *DebugInfo) Pipelines() string {
+	di.mutex.Lock()
+	defer di.mutex.Unlock()
+	return strings.Join(di.pipelines, "; ")
+}
+
+// Upstreams returns the chosen upstreams, separated by ", ".
+func (di *DebugInfo) Upstreams() string {
+	di.mutex.Lock()
+	defer di.mutex.Unlock()
+	return strings.Join(di.upstreams, ", ")
+}
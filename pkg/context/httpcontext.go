@@ -34,6 +34,7 @@ import (
 	"github.com/megaease/easegress/pkg/util/httpstat"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 	"github.com/megaease/easegress/pkg/util/texttemplate"
+	"github.com/megaease/easegress/pkg/util/tlsfingerprint"
 )
 
 type (
@@ -72,11 +73,19 @@ type (
 
 		CallNextHandler(lastResult string) string
 		SetHandlerCaller(caller HandlerCaller)
+
+		// SetKV and GetKV share values between filters, e.g. the JWT
+		// claims verified by Validator are used by RateLimiter.
+		SetKV(key string, value interface{})
+		GetKV(key string) interface{}
 	}
 
 	// HTTPRequest is all operations for HTTP request.
 	HTTPRequest interface {
 		RealIP() string
+		// SetRealIP overrides the client address resolved from the
+		// request, e.g. by the client IP policy of the server.
+		SetRealIP(ip string)
 
 		Method() string
 		SetMethod(method string)
@@ -105,6 +114,10 @@ type (
 		Std() *http.Request
 
 		Size() uint64 // bytes
+
+		// TLSFingerprint returns the fingerprint of the TLS client, it is nil
+		// if the fingerprint is not available.
+		TLSFingerprint() *tlsfingerprint.Fingerprint
 	}
 
 	// HTTPResponse is all operations for HTTP response.
@@ -160,9 +173,15 @@ type (
 		err            error
 
 		metric httpstat.Metric
+
+		kv map[string]interface{}
 	}
 )
 
+// KeyJWTClaims is the key of the verified JWT claims, the value is of
+// type map[string]interface{}.
+const KeyJWTClaims = "jwt"
+
 // New creates an HTTPContext.
 // NOTE: We can't use sync.Pool to recycle context.
 // Reference: https://github.com/gin-gonic/gin/issues/1731
@@ -177,14 +196,15 @@ func New(stdw http.ResponseWriter, stdr *http.Request,
 		span.SetTag("http.method", stdr.Method)
 		span.SetTag("http.path", stdr.URL.Path)
 	}
+	reqHeader, respHeader := httpheader.NewPair(stdr.Header, stdw.Header())
 	ctx := &httpContext{
 		startTime:      startTime,
 		span:           span,
 		originalReqCtx: originalReqCtx,
 		stdctx:         stdctx,
 		cancelFunc:     cancelFunc,
-		r:              newHTTPRequest(stdr),
-		w:              newHTTPResponse(stdw, stdr),
+		r:              newHTTPRequest(stdr, reqHeader),
+		w:              newHTTPResponse(stdw, stdr, respHeader),
 		lazyTags:       make([]LazyTagFunc, 0, 5),
 		finishFuncs:    make([]FinishFunc, 0, 1),
 	}
@@ -303,6 +323,8 @@ func (ctx *httpContext) Finish() {
 	ctx.metric.Duration = fasttime.Now().Sub(ctx.startTime)
 	ctx.metric.ReqSize = ctx.Request().Size()
 	ctx.metric.RespSize = ctx.Response().Size()
+	// NOTE: Check it again, as the client may disconnect while flushing the body.
+	ctx.metric.ClientGone = ctx.ClientDisconnected()
 
 	for _, fn := range ctx.finishFuncs {
 		func() {
@@ -317,6 +339,10 @@ func (ctx *httpContext) Finish() {
 		}()
 	}
 
+	if NoAccessLog(ctx) {
+		return
+	}
+
 	logger.LazyHTTPAccess(func() string {
 		stdr := ctx.r.std
 		tags := strings.Join(ctx.getTags(), " | ")
@@ -326,16 +352,18 @@ func (ctx *httpContext) Finish() {
 		// [requestInfo]
 		// [contextStatistics]
 		// [tags]
+		// [identity]
 		//
 		// [$startTime]
 		// [$remoteAddr $realIP $method $requestURL $proto $statusCode]
 		// [$contextDuration $readBytes $writeBytes]
 		// [$tags]
-		return fmt.Sprintf("[%s] [%s %s %s %s %s %d] [%v rx:%dB tx:%dB] [%s]",
+		// [$consumer $subject $tenant], "-" for absent fields
+		return fmt.Sprintf("[%s] [%s %s %s %s %s %d] [%v rx:%dB tx:%dB] [%s] [%s]",
 			fasttime.Format(ctx.startTime, fasttime.RFC3339Milli),
 			stdr.RemoteAddr, ctx.r.RealIP(), stdr.Method, stdr.RequestURI, stdr.Proto, ctx.w.code,
 			ctx.metric.Duration, ctx.r.Size(), ctx.w.Size(),
-			tags)
+			tags, identityLogFields(GetIdentity(ctx)))
 	})
 }
 
@@ -362,3 +390,16 @@ func (ctx *httpContext) SaveReqToTemplate(filterName string) error {
 func (ctx *httpContext) SaveRspToTemplate(filterName string) error {
 	return ctx.ht.SaveResponse(filterName, ctx)
 }
+
+// SetKV sets a value shared between filters.
+func (ctx *httpContext) SetKV(key string, value interface{}) {
+	if ctx.kv == nil {
+		ctx.kv = make(map[string]interface{})
+	}
+	ctx.kv[key] = value
+}
+
+// GetKV returns the value set by SetKV, nil is returned if not exists.
+func (ctx *httpContext) GetKV(key string) interface{} {
+	return ctx.kv[key]
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/util/callbackreader"
 	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/tlsfingerprint"
 )
 
 type (
@@ -40,7 +41,7 @@ type (
 	}
 )
 
-func newHTTPRequest(stdr *http.Request) *httpRequest {
+func newHTTPRequest(stdr *http.Request, header *httpheader.HTTPHeader) *httpRequest {
 	// Reference: https://golang.org/pkg/net/http/#Request
 	//
 	// For incoming requests, the Host header is promoted to the
@@ -53,7 +54,7 @@ func newHTTPRequest(stdr *http.Request) *httpRequest {
 		std:    stdr,
 		method: stdr.Method,
 		path:   stdr.URL.Path,
-		header: httpheader.New(stdr.Header),
+		header: header,
 		body:   callbackreader.New(stdr.Body),
 		realIP: realip.FromRequest(stdr),
 	}
@@ -87,6 +88,10 @@ func (r *httpRequest) RealIP() string {
 	return r.realIP
 }
 
+func (r *httpRequest) SetRealIP(ip string) {
+	r.realIP = ip
+}
+
 func (r *httpRequest) Method() string {
 	return r.method
 }
@@ -176,3 +181,7 @@ func (r *httpRequest) finish() {
 func (r *httpRequest) Std() *http.Request {
 	return r.std
 }
+
+func (r *httpRequest) TLSFingerprint() *tlsfingerprint.Fingerprint {
+	return tlsfingerprint.FromContext(r.std.Context())
+}
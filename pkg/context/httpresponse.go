@@ -23,6 +23,7 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/util/httpheader"
@@ -42,14 +43,26 @@ type (
 		bodyWritten    uint64
 		bodyFlushFuncs []BodyFlushFunc
 	}
+
+	// flushWriter flushes every write to the client immediately.
+	flushWriter struct {
+		w io.Writer
+		f http.Flusher
+	}
 )
 
-func newHTTPResponse(stdw http.ResponseWriter, stdr *http.Request) *httpResponse {
+func (fw *flushWriter) Write(p []byte) (int, error) {
+	n, err := fw.w.Write(p)
+	fw.f.Flush()
+	return n, err
+}
+
+func newHTTPResponse(stdw http.ResponseWriter, stdr *http.Request, header *httpheader.HTTPHeader) *httpResponse {
 	return &httpResponse{
 		stdr:   stdr,
 		std:    stdw,
 		code:   http.StatusOK,
-		header: httpheader.New(stdw.Header()),
+		header: header,
 	}
 }
 
@@ -82,6 +95,14 @@ func (w *httpResponse) OnFlushBody(fn BodyFlushFunc) {
 	w.bodyFlushFuncs = append(w.bodyFlushFuncs, fn)
 }
 
+// isStreaming reports whether the body is an event stream, whose
+// events should be delivered to the client as soon as they arrive.
+func (w *httpResponse) isStreaming() bool {
+	contentType := strings.ToLower(w.header.Get(httpheader.KeyContentType))
+	return strings.HasPrefix(contentType, "text/event-stream") ||
+		strings.HasPrefix(contentType, "application/x-ndjson")
+}
+
 func (w *httpResponse) flushBody() {
 	if w.body == nil {
 		return
@@ -98,8 +119,13 @@ func (w *httpResponse) flushBody() {
 		}
 	}()
 
+	dst := io.Writer(w.std)
+	if flusher, ok := w.std.(http.Flusher); ok && w.isStreaming() {
+		dst = &flushWriter{w: w.std, f: flusher}
+	}
+
 	copyToClient := func(src io.Reader) (succeed bool) {
-		written, err := io.Copy(w.std, src)
+		written, err := io.Copy(dst, src)
 		if err != nil {
 			logger.Warnf("copy body failed: %v", err)
 			return false
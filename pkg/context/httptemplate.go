@@ -36,6 +36,7 @@ const (
 	filterReqProto      = "filter.%s.req.proto"
 	filterReqhost       = "filter.%s.req.host"
 	filterReqheader     = "filter.%s.req.header.%s"
+	filterReqParam      = "filter.%s.req.param.%s"
 	filterRspStatusCode = "filter.%s.rsp.statuscode"
 	filterRspBody       = "filter.%s.rsp.body"
 
@@ -85,6 +86,7 @@ var (
 		"filter.{}.req.host",
 		"filter.{}.req.body.{gjson}",
 		"filter.{}.req.header.{}",
+		"filter.{}.req.param.{}",
 		"filter.{}.rsp.statuscode",
 		"filter.{}.rsp.body.{gjson}",
 	}
@@ -97,6 +99,7 @@ var (
 		"req.proto":      saveReqProto,
 		"req.host":       saveReqHost,
 		"req.header":     saveReqHeader,
+		"req.param":      saveReqParam,
 		"rsp.statuscode": saveRspStatuscode,
 		"rsp.body":       saveRspBody,
 	}
@@ -353,6 +356,14 @@ func saveReqHeader(e *HTTPTemplate, filterName string, ctx HTTPContext) error {
 	return nil
 }
 
+func saveReqParam(e *HTTPTemplate, filterName string, ctx HTTPContext) error {
+	// Set the parameters captured from the request path by the route
+	for k, v := range GetPathParams(ctx) {
+		e.Engine.SetDict(fmt.Sprintf(filterReqParam, filterName, k), v)
+	}
+	return nil
+}
+
 func saveRspBody(e *HTTPTemplate, filterName string, ctx HTTPContext) error {
 	bodyBuff, err := readBody(ctx.Response().Body(), defaultMaxBodySize)
 	if err != nil {
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	"strings"
+
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// KeyIdentity is the key of the authenticated identity of the request,
+	// the value is of type *Identity, it is set by authentication filters.
+	KeyIdentity = "identity"
+
+	// KeyAuthRequired is the key marking the request requires an
+	// authenticated identity, the value is of type bool.
+	KeyAuthRequired = "authRequired"
+)
+
+// Identity is the authenticated identity of a request.
+type Identity struct {
+	// Method is the authentication method, e.g. jwt, oauth2.
+	Method string
+	// Subject is the authenticated subject, it could be empty if the
+	// method doesn't tell.
+	Subject string
+	// Consumer is the application or customer consuming the API, and
+	// Tenant is the tenant of the subject, they're optional.
+	Consumer string
+	Tenant   string
+}
+
+// SetIdentity sets the authenticated identity of the request.
+func SetIdentity(ctx HTTPContext, method, subject string) *Identity {
+	identity := &Identity{Method: method, Subject: subject}
+	ctx.SetKV(KeyIdentity, identity)
+	return identity
+}
+
+// GetIdentity returns the authenticated identity of the request, nil is
+// returned if the request is not authenticated.
+func GetIdentity(ctx HTTPContext) *Identity {
+	identity, _ := ctx.GetKV(KeyIdentity).(*Identity)
+	return identity
+}
+
+// SetAuthRequired marks the request requires an authenticated identity.
+func SetAuthRequired(ctx HTTPContext) {
+	ctx.SetKV(KeyAuthRequired, true)
+}
+
+// Unauthenticated reports whether the request requires an authenticated
+// identity but doesn't have one.
+func Unauthenticated(ctx HTTPContext) bool {
+	required, _ := ctx.GetKV(KeyAuthRequired).(bool)
+	return required && GetIdentity(ctx) == nil
+}
+
+// Name returns the name of the identity for statistics, which is the
+// consumer if it is set, or the subject otherwise, and it is prefixed
+// with the tenant if there's one.
+func (i *Identity) Name() string {
+	name := i.Consumer
+	if name == "" {
+		name = i.Subject
+	}
+	if i.Tenant != "" {
+		name = i.Tenant + "/" + name
+	}
+	return name
+}
+
+var identityLogEscaper = strings.NewReplacer(" ", "%20", "]", "%5D", "\n", "%0A")
+
+// identityLogFields returns the identity fields of the access log.
+func identityLogFields(i *Identity) string {
+	if i == nil {
+		return "- - -"
+	}
+
+	field := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		// NOTE: The fields come from clients, they're escaped to keep
+		// the log parsable.
+		return identityLogEscaper.Replace(s)
+	}
+	return stringtool.Cat(field(i.Consumer), " ", field(i.Subject), " ", field(i.Tenant))
+}
@@ -0,0 +1,41 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+// KeyPathParams is the key of the parameters captured from the request
+// path by the route, e.g. id of /users/{id}, the value is of type
+// map[string]string.
+const KeyPathParams = "pathParams"
+
+// SetPathParams sets the parameters captured from the request path.
+func SetPathParams(ctx HTTPContext, params map[string]string) {
+	ctx.SetKV(KeyPathParams, params)
+}
+
+// GetPathParams returns the parameters captured from the request path,
+// it's nil if the route has no parameters.
+func GetPathParams(ctx HTTPContext) map[string]string {
+	params, _ := ctx.GetKV(KeyPathParams).(map[string]string)
+	return params
+}
+
+// GetPathParam returns the value of the path parameter of the name, it's
+// empty if the parameter is absent.
+func GetPathParam(ctx HTTPContext, name string) string {
+	return GetPathParams(ctx)[name]
+}
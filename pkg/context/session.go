@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	// KeySession is the key of the server-side session of the request,
+	// the value is of type *Session.
+	KeySession = "session"
+
+	// InternalKeyPrefix is the prefix of the session keys private to the
+	// filters, e.g. the login state, they are never sent to or changed
+	// by the backends.
+	InternalKeyPrefix = "eg-"
+)
+
+// Session is the server-side session of the request managed by the
+// Session filter, the changes of filters are saved after the pipeline.
+type Session struct {
+	// ID is the ID of the session, it's empty for the new session which
+	// hasn't been saved.
+	ID string
+
+	mutex     sync.Mutex
+	data      map[string]string
+	modified  bool
+	destroyed bool
+	renewed   bool
+}
+
+// NewSession creates a session with the ID and the data.
+func NewSession(id string, data map[string]string) *Session {
+	if data == nil {
+		data = map[string]string{}
+	}
+	return &Session{ID: id, data: data}
+}
+
+// SetSession sets the server-side session of the request.
+func SetSession(ctx HTTPContext, session *Session) {
+	ctx.SetKV(KeySession, session)
+}
+
+// GetSession returns the server-side session of the request, it's nil
+// if the request doesn't go through the Session filter.
+func GetSession(ctx HTTPContext) *Session {
+	session, _ := ctx.GetKV(KeySession).(*Session)
+	return session
+}
+
+// IsInternalKey reports whether the session key is private to the filters.
+func IsInternalKey(key string) bool {
+	return strings.HasPrefix(key, InternalKeyPrefix)
+}
+
+// Get returns the value of the key, it's empty if the key is absent.
+func (s *Session) Get(key string) string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.data[key]
+}
+
+// Set sets the value of the key.
+func (s *Session) Set(key, value string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.data[key] = value
+	s.modified = true
+}
+
+// Delete deletes the key.
+func (s *Session) Delete(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, exists := s.data[key]; exists {
+		delete(s.data, key)
+		s.modified = true
+	}
+}
+
+// Data returns a copy of the data of the session.
+func (s *Session) Data() map[string]string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	data := make(map[string]string, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	return data
+}
+
+// Destroy destroys the session, e.g. on logout.
+func (s *Session) Destroy() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.destroyed = true
+}
+
+// Renew renews the ID of the session while keeping its data, it should
+// be called when the privilege of the session changes, e.g. on login, to
+// prevent session fixation.
+func (s *Session) Renew() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.renewed = true
+	s.modified = true
+}
+
+// Modified reports whether the data of the session has been changed.
+func (s *Session) Modified() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.modified
+}
+
+// Destroyed reports whether the session has been destroyed.
+func (s *Session) Destroyed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.destroyed
+}
+
+// Renewed reports whether the ID of the session should be renewed.
+func (s *Session) Renewed() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.renewed
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package context
+
+import (
+	stdcontext "context"
+	"time"
+)
+
+// KeyUpstreamTimeout is the key of the max time waiting for the response
+// headers of every upstream request, the value is of type time.Duration.
+const KeyUpstreamTimeout = "upstreamTimeout"
+
+// SetUpstreamTimeout sets the max time waiting for the response headers
+// of every upstream request of the request.
+func SetUpstreamTimeout(ctx HTTPContext, timeout time.Duration) {
+	ctx.SetKV(KeyUpstreamTimeout, timeout)
+}
+
+// GetUpstreamTimeout returns the max time waiting for the response
+// headers of upstream requests, zero means no limit.
+func GetUpstreamTimeout(ctx HTTPContext) time.Duration {
+	timeout, _ := ctx.GetKV(KeyUpstreamTimeout).(time.Duration)
+	return timeout
+}
+
+// SetTimeout bounds the handling of the request, the context is done
+// once the timeout elapses, so that the upstream requests are aborted
+// and the rest filters are skipped.
+func SetTimeout(ctx HTTPContext, timeout time.Duration) {
+	if c, ok := ctx.(*httpContext); ok {
+		c.setTimeout(timeout)
+	}
+}
+
+// TimedOut reports whether the request exceeds the timeout set by
+// SetTimeout.
+func TimedOut(ctx HTTPContext) bool {
+	if c, ok := ctx.(*httpContext); ok {
+		return c.err == nil && c.stdctx.Err() == stdcontext.DeadlineExceeded &&
+			c.originalReqCtx.Err() == nil
+	}
+	return false
+}
+
+func (ctx *httpContext) setTimeout(timeout time.Duration) {
+	stdctx, cancelFunc := stdcontext.WithTimeout(ctx.stdctx, timeout)
+	parentCancel := ctx.cancelFunc
+	ctx.stdctx = stdctx
+	ctx.cancelFunc = func() {
+		cancelFunc()
+		parentCancel()
+	}
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package awssigner
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/signer"
+)
+
+const (
+	// Kind is the kind of AWSSigner.
+	Kind = "AWSSigner"
+
+	resultSignFailed = "signFailed"
+
+	headerDate          = "X-Amz-Date"
+	headerSecurityToken = "X-Amz-Security-Token"
+	headerContentSHA256 = "X-Amz-Content-Sha256"
+
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var results = []string{resultSignFailed}
+
+func init() {
+	httppipeline.Register(&AWSSigner{})
+}
+
+type (
+	// AWSSigner signs the requests to AWS services with AWS Signature
+	// Version 4, so the gateway could proxy requests to them directly.
+	AWSSigner struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		provider      credentialProvider
+		signedHeaders map[string]bool
+	}
+
+	// Spec describes the AWSSigner.
+	Spec struct {
+		Service string `yaml:"service" jsonschema:"required"`
+		Region  string `yaml:"region" jsonschema:"required"`
+		// Host is the host of the AWS service endpoint, it replaces the
+		// host of requests, which is signed.
+		Host string `yaml:"host" jsonschema:"omitempty"`
+
+		// The static credentials, the credentials are retrieved from the
+		// environment if they are absent.
+		AccessKeyID     string `yaml:"accessKeyId" jsonschema:"omitempty"`
+		SecretAccessKey string `yaml:"secretAccessKey" jsonschema:"omitempty"`
+		SessionToken    string `yaml:"sessionToken" jsonschema:"omitempty"`
+
+		// UnsignedPayload doesn't sign the body, so it is streamed to the
+		// service instead of being buffered, only S3 supports it.
+		UnsignedPayload bool `yaml:"unsignedPayload" jsonschema:"omitempty"`
+		// SignedHeaders are the extra headers to sign, besides Host,
+		// Content-Type, Content-MD5 and the X-Amz-* headers.
+		SignedHeaders []string `yaml:"signedHeaders" jsonschema:"omitempty,uniqueItems=true"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if (spec.AccessKeyID == "") != (spec.SecretAccessKey == "") {
+		return fmt.Errorf("accessKeyId and secretAccessKey must be specified together")
+	}
+	if spec.SessionToken != "" && spec.AccessKeyID == "" {
+		return fmt.Errorf("sessionToken requires accessKeyId and secretAccessKey")
+	}
+	if spec.UnsignedPayload && spec.Service != "s3" {
+		return fmt.Errorf("unsignedPayload is only supported by s3")
+	}
+	return nil
+}
+
+// Kind returns the kind of AWSSigner.
+func (s *AWSSigner) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of AWSSigner.
+func (s *AWSSigner) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of AWSSigner.
+func (s *AWSSigner) Description() string {
+	return "AWSSigner signs requests to AWS services with AWS Signature Version 4."
+}
+
+// Results returns the results of AWSSigner.
+func (s *AWSSigner) Results() []string {
+	return results
+}
+
+// Init initializes AWSSigner.
+func (s *AWSSigner) Init(filterSpec *httppipeline.FilterSpec) {
+	s.filterSpec, s.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	s.reload()
+}
+
+// Inherit inherits previous generation of AWSSigner.
+func (s *AWSSigner) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	s.Init(filterSpec)
+}
+
+func (s *AWSSigner) reload() {
+	s.provider = newCredentialProvider(s.spec)
+
+	s.signedHeaders = map[string]bool{
+		httpheader.KeyContentType: true,
+		"Content-Md5":             true,
+	}
+	for _, h := range s.spec.SignedHeaders {
+		s.signedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+}
+
+// Handle signs the request.
+func (s *AWSSigner) Handle(ctx context.HTTPContext) string {
+	result := s.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (s *AWSSigner) handle(ctx context.HTTPContext) string {
+	if err := s.sign(ctx); err != nil {
+		logger.Errorf("%s: sign request failed: %v", s.filterSpec.Name(), err)
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		ctx.AddTag(fmt.Sprintf("awsSigner: %v", err))
+		return resultSignFailed
+	}
+	return ""
+}
+
+func (s *AWSSigner) sign(ctx context.HTTPContext) error {
+	creds, err := s.provider.retrieve()
+	if err != nil {
+		return err
+	}
+
+	r := ctx.Request()
+	h := r.Header()
+	if s.spec.Host != "" {
+		r.SetHost(s.spec.Host)
+	}
+
+	// The signature related headers of clients are replaced.
+	h.Del("Authorization")
+	h.Del(headerDate)
+	h.Del(headerSecurityToken)
+	h.Del(headerContentSHA256)
+
+	var body io.Reader
+	payloadHash := ""
+	switch {
+	case s.spec.UnsignedPayload:
+		payloadHash = unsignedPayload
+	case r.Body() != nil:
+		data, err := io.ReadAll(r.Body())
+		if err != nil {
+			return fmt.Errorf("read body failed: %v", err)
+		}
+		r.SetBody(bytes.NewReader(data))
+		body = bytes.NewReader(data)
+		if s.spec.Service == "s3" {
+			sum := sha256.Sum256(data)
+			payloadHash = hex.EncodeToString(sum[:])
+		}
+	}
+	// S3 requires the hash of the payload in the header.
+	if s.spec.Service == "s3" && payloadHash == "" {
+		sum := sha256.Sum256(nil)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+
+	// NOTE: The proxy sends requests to the path of the request, so the
+	// escaped path sent is the same as the one signed.
+	u, err := url.Parse("https://" + r.Host() + r.Path())
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	u.RawQuery = r.Query()
+
+	req, err := http.NewRequest(r.Method(), u.String(), body)
+	if err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+
+	// Only the stable headers are signed, as the others may be changed
+	// by the following filters or the proxy.
+	for k, v := range h.Std() {
+		if s.signedHeaders[k] || strings.HasPrefix(k, "X-Amz-") {
+			req.Header[k] = v
+		}
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set(headerSecurityToken, creds.SessionToken)
+	}
+	if payloadHash != "" {
+		req.Header.Set(headerContentSHA256, payloadHash)
+	}
+
+	sig := signer.New().
+		SetLiteral(signer.AWSLiteral()).
+		SingleEscapePath(s.spec.Service == "s3").
+		SetCredential(creds.AccessKeyID, creds.SecretAccessKey)
+	if err := sig.NewContext(time.Now(), s.spec.Region, s.spec.Service).Sign(req); err != nil {
+		return err
+	}
+
+	for _, k := range []string{"Authorization", headerDate, headerSecurityToken, headerContentSHA256} {
+		if v := req.Header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+	return nil
+}
+
+// Status returns status.
+func (s *AWSSigner) Status() interface{} {
+	return nil
+}
+
+// Close closes AWSSigner.
+func (s *AWSSigner) Close() {}
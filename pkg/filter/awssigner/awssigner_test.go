@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package awssigner
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/signer"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newAWSSigner(t *testing.T, yamlSpec string) *AWSSigner {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := &AWSSigner{}
+	s.Init(spec)
+	return s
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	host       string
+	header     *httpheader.HTTPHeader
+	body       io.Reader
+	statusCode int
+}
+
+func newContext(method, path, query, body string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		host:              "gateway.example.com",
+		header:            httpheader.New(http.Header{}),
+	}
+	if body != "" {
+		ctx.body = strings.NewReader(body)
+	}
+
+	ctx.MockedRequest.MockedMethod = func() string { return method }
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedRequest.MockedQuery = func() string { return query }
+	ctx.MockedRequest.MockedHost = func() string { return ctx.host }
+	ctx.MockedRequest.MockedSetHost = func(host string) { ctx.host = host }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return ctx.header }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return ctx.body }
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) { ctx.body = body }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	return ctx
+}
+
+// stdRequest creates the request as the proxy sends it.
+func (ctx *mockedContext) stdRequest(t *testing.T) *http.Request {
+	r := ctx.Request()
+	url := "https://" + ctx.host + r.Path()
+	if r.Query() != "" {
+		url += "?" + r.Query()
+	}
+
+	var body io.Reader
+	if ctx.body != nil {
+		data, _ := ioutil.ReadAll(ctx.body)
+		body = strings.NewReader(string(data))
+	}
+
+	req, err := http.NewRequest(r.Method(), url, body)
+	if err != nil {
+		t.Fatalf("new request failed: %v", err)
+	}
+	req.Header = ctx.header.Std()
+	req.Host = ctx.host
+	return req
+}
+
+type keyStore map[string]string
+
+func (ks keyStore) GetSecret(id string) (string, bool) {
+	secret, ok := ks[id]
+	return secret, ok
+}
+
+func newVerifier(singleEscape bool) *signer.Signer {
+	return signer.New().
+		SetLiteral(signer.AWSLiteral()).
+		SingleEscapePath(singleEscape).
+		SetAccessKeyStore(keyStore{"AKIDEXAMPLE": "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"})
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec  Spec
+		valid bool
+	}{
+		{Spec{Service: "es", Region: "us-east-1"}, true},
+		{Spec{Service: "es", Region: "us-east-1", AccessKeyID: "id"}, false},
+		{Spec{Service: "es", Region: "us-east-1", SessionToken: "token"}, false},
+		{Spec{Service: "es", Region: "us-east-1", UnsignedPayload: true}, false},
+		{Spec{Service: "s3", Region: "us-east-1", UnsignedPayload: true}, true},
+	}
+
+	for i, c := range cases {
+		err := c.spec.Validate()
+		if c.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("case %d: should be invalid", i)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	s := newAWSSigner(t, `
+kind: AWSSigner
+name: signer
+service: es
+region: us-west-2
+host: search-test.us-west-2.es.amazonaws.com
+accessKeyId: AKIDEXAMPLE
+secretAccessKey: wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY
+sessionToken: session-token
+signedHeaders: [x-tenant]
+`)
+
+	ctx := newContext(http.MethodPost, "/logs/_search", "q=a b&size=10", `{"query":{"match_all":{}}}`)
+	ctx.header.Set(httpheader.KeyContentType, "application/json")
+	ctx.header.Set("X-Tenant", "acme")
+	ctx.header.Set("Authorization", "Bearer client-token")
+	ctx.header.Set("User-Agent", "curl")
+
+	if result := s.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if ctx.host != "search-test.us-west-2.es.amazonaws.com" {
+		t.Errorf("host should be replaced, got %s", ctx.host)
+	}
+
+	auth := ctx.header.Get("Authorization")
+	if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") {
+		t.Fatalf("unexpected authorization %s", auth)
+	}
+	if !strings.Contains(auth, "SignedHeaders=content-type;host;x-amz-date;x-amz-security-token;x-tenant,") {
+		t.Errorf("unexpected signed headers: %s", auth)
+	}
+	if ctx.header.Get(headerSecurityToken) != "session-token" {
+		t.Errorf("security token should be set")
+	}
+	if ctx.header.Get(headerContentSHA256) != "" {
+		t.Errorf("payload hash should be set for s3 only")
+	}
+
+	// Headers which are not signed could be changed.
+	ctx.header.Set("User-Agent", "easegress")
+	if err := newVerifier(false).Verify(ctx.stdRequest(t)); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
+
+func TestSignS3(t *testing.T) {
+	s := newAWSSigner(t, `
+kind: AWSSigner
+name: signer
+service: s3
+region: us-east-1
+accessKeyId: AKIDEXAMPLE
+secretAccessKey: wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY
+`)
+
+	ctx := newContext(http.MethodPut, "/bucket/a b/c.txt", "", "hello")
+	if result := s.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if ctx.host != "gateway.example.com" {
+		t.Errorf("host should be kept, got %s", ctx.host)
+	}
+	// sha256 of "hello".
+	if h := ctx.header.Get(headerContentSHA256); h != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("unexpected payload hash %s", h)
+	}
+	if err := newVerifier(true).Verify(ctx.stdRequest(t)); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	ctx = newContext(http.MethodGet, "/bucket/c.txt", "", "")
+	s.Handle(ctx)
+	if err := newVerifier(true).Verify(ctx.stdRequest(t)); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
+
+func TestSignFailed(t *testing.T) {
+	s := newAWSSigner(t, `
+kind: AWSSigner
+name: signer
+service: s3
+region: us-east-1
+`)
+	s.provider = &countingProvider{err: io.EOF}
+
+	ctx := newContext(http.MethodGet, "/bucket/c.txt", "", "")
+	if result := s.Handle(ctx); result != resultSignFailed {
+		t.Errorf("unexpected result %s", result)
+	}
+	if ctx.statusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected status code %d", ctx.statusCode)
+	}
+}
@@ -0,0 +1,360 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package awssigner
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshWindow is the time before the expiration to refresh the
+	// temporary credentials.
+	refreshWindow = 5 * time.Minute
+
+	providerTimeout = 5 * time.Second
+
+	defaultIMDSEndpoint = "http://169.254.169.254"
+	defaultECSEndpoint  = "http://169.254.170.2"
+
+	imdsTokenTTL = "21600"
+)
+
+type (
+	// credentials are the AWS credentials, Expiration is zero for the
+	// long-term ones.
+	credentials struct {
+		AccessKeyID     string
+		SecretAccessKey string
+		SessionToken    string
+		Expiration      time.Time
+	}
+
+	// credentialProvider retrieves credentials from a source.
+	credentialProvider interface {
+		name() string
+		retrieve() (*credentials, error)
+	}
+
+	// cachedProvider caches the credentials of the provider until they
+	// are about to expire.
+	cachedProvider struct {
+		provider credentialProvider
+
+		mutex sync.Mutex
+		creds *credentials
+	}
+
+	staticProvider struct {
+		creds *credentials
+	}
+
+	// envProvider reads the credentials from the environment variables.
+	envProvider struct{}
+
+	// webIdentityProvider assumes the role with the web identity token,
+	// e.g. the service account token of IRSA (IAM Roles for Service
+	// Accounts) on EKS.
+	webIdentityProvider struct {
+		client    *http.Client
+		endpoint  string
+		roleARN   string
+		tokenFile string
+		session   string
+	}
+
+	// ecsProvider gets the credentials of the task role of ECS.
+	ecsProvider struct {
+		client *http.Client
+		url    string
+		token  string
+	}
+
+	// imdsProvider gets the credentials of the instance role from the
+	// EC2 instance metadata service (IMDSv2).
+	imdsProvider struct {
+		client   *http.Client
+		endpoint string
+	}
+
+	// metadataCredentials is the credentials format of IMDS and ECS.
+	metadataCredentials struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+
+	assumeRoleWithWebIdentityResponse struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+)
+
+// newCredentialProvider returns the provider of static credentials if
+// they're specified, or the first available one of the environment
+// variables, the web identity, ECS and EC2 instance metadata.
+func newCredentialProvider(spec *Spec) credentialProvider {
+	if spec.AccessKeyID != "" {
+		return &cachedProvider{provider: &staticProvider{creds: &credentials{
+			AccessKeyID:     spec.AccessKeyID,
+			SecretAccessKey: spec.SecretAccessKey,
+			SessionToken:    spec.SessionToken,
+		}}}
+	}
+
+	client := &http.Client{Timeout: providerTimeout}
+
+	var p credentialProvider
+	switch {
+	case os.Getenv("AWS_ACCESS_KEY_ID") != "":
+		p = &envProvider{}
+	case os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && os.Getenv("AWS_ROLE_ARN") != "":
+		session := os.Getenv("AWS_ROLE_SESSION_NAME")
+		if session == "" {
+			session = fmt.Sprintf("easegress-%d", time.Now().UnixNano())
+		}
+		p = &webIdentityProvider{
+			client:    client,
+			endpoint:  "https://sts." + spec.Region + ".amazonaws.com",
+			roleARN:   os.Getenv("AWS_ROLE_ARN"),
+			tokenFile: os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"),
+			session:   session,
+		}
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI") != "":
+		p = &ecsProvider{
+			client: client,
+			url:    defaultECSEndpoint + os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"),
+			token:  os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"),
+		}
+	case os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		p = &ecsProvider{
+			client: client,
+			url:    os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"),
+			token:  os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"),
+		}
+	default:
+		p = &imdsProvider{client: client, endpoint: defaultIMDSEndpoint}
+	}
+
+	return &cachedProvider{provider: p}
+}
+
+func (p *cachedProvider) name() string {
+	return p.provider.name()
+}
+
+// retrieve returns the cached credentials, or retrieves new ones if they
+// are about to expire, the cached ones are returned if the retrieval
+// fails but they have not expired yet.
+func (p *cachedProvider) retrieve() (*credentials, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	if p.creds != nil && (p.creds.Expiration.IsZero() || now.Before(p.creds.Expiration.Add(-refreshWindow))) {
+		return p.creds, nil
+	}
+
+	creds, err := p.provider.retrieve()
+	if err != nil {
+		if p.creds != nil && now.Before(p.creds.Expiration) {
+			return p.creds, nil
+		}
+		return nil, fmt.Errorf("retrieve credentials from %s failed: %v", p.provider.name(), err)
+	}
+
+	p.creds = creds
+	return creds, nil
+}
+
+func (p *staticProvider) name() string {
+	return "spec"
+}
+
+func (p *staticProvider) retrieve() (*credentials, error) {
+	return p.creds, nil
+}
+
+func (p *envProvider) name() string {
+	return "environment variables"
+}
+
+func (p *envProvider) retrieve() (*credentials, error) {
+	creds := &credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID or AWS_SECRET_ACCESS_KEY is empty")
+	}
+	return creds, nil
+}
+
+func (p *webIdentityProvider) name() string {
+	return "web identity"
+}
+
+// retrieve calls AssumeRoleWithWebIdentity of STS, the token file is
+// read every time as it is rotated by Kubernetes.
+func (p *webIdentityProvider) retrieve() (*credentials, error) {
+	token, err := os.ReadFile(p.tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("read token file failed: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", "2011-06-15")
+	form.Set("RoleArn", p.roleARN)
+	form.Set("RoleSessionName", p.session)
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	resp, err := p.client.PostForm(p.endpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+
+	result := &assumeRoleWithWebIdentityResponse{}
+	if err := xml.Unmarshal(body, result); err != nil {
+		return nil, fmt.Errorf("invalid response: %v", err)
+	}
+	c := &result.Credentials
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return nil, fmt.Errorf("invalid response: credentials are empty")
+	}
+
+	return &credentials{
+		AccessKeyID:     c.AccessKeyID,
+		SecretAccessKey: c.SecretAccessKey,
+		SessionToken:    c.SessionToken,
+		Expiration:      c.Expiration,
+	}, nil
+}
+
+func (p *ecsProvider) name() string {
+	return "ECS task role"
+}
+
+func (p *ecsProvider) retrieve() (*credentials, error) {
+	req, err := http.NewRequest(http.MethodGet, p.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.token != "" {
+		req.Header.Set("Authorization", p.token)
+	}
+
+	body, err := doRequest(p.client, req)
+	if err != nil {
+		return nil, err
+	}
+	return parseMetadataCredentials(body)
+}
+
+func (p *imdsProvider) name() string {
+	return "EC2 instance metadata"
+}
+
+func (p *imdsProvider) retrieve() (*credentials, error) {
+	req, _ := http.NewRequest(http.MethodPut, p.endpoint+"/latest/api/token", nil)
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", imdsTokenTTL)
+	token, err := doRequest(p.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("get token failed: %v", err)
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, _ := http.NewRequest(http.MethodGet, p.endpoint+path, nil)
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		return doRequest(p.client, req)
+	}
+
+	const path = "/latest/meta-data/iam/security-credentials/"
+	role, err := get(path)
+	if err != nil {
+		return nil, fmt.Errorf("get role failed: %v", err)
+	}
+	// NOTE: An instance has one role at most.
+	name := strings.TrimSpace(strings.SplitN(string(role), "\n", 2)[0])
+	if name == "" {
+		return nil, fmt.Errorf("no role is attached to the instance")
+	}
+
+	body, err := get(path + name)
+	if err != nil {
+		return nil, fmt.Errorf("get credentials of role %s failed: %v", name, err)
+	}
+	return parseMetadataCredentials(body)
+}
+
+func doRequest(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
+
+func parseMetadataCredentials(body []byte) (*credentials, error) {
+	mc := &metadataCredentials{}
+	if err := json.Unmarshal(body, mc); err != nil {
+		return nil, fmt.Errorf("invalid credentials: %v", err)
+	}
+	if mc.AccessKeyID == "" || mc.SecretAccessKey == "" {
+		return nil, fmt.Errorf("invalid credentials: credentials are empty")
+	}
+
+	return &credentials{
+		AccessKeyID:     mc.AccessKeyID,
+		SecretAccessKey: mc.SecretAccessKey,
+		SessionToken:    mc.Token,
+		Expiration:      mc.Expiration,
+	}, nil
+}
@@ -0,0 +1,207 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package awssigner
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	count int
+	creds *credentials
+	err   error
+}
+
+func (p *countingProvider) name() string {
+	return "counting"
+}
+
+func (p *countingProvider) retrieve() (*credentials, error) {
+	p.count++
+	return p.creds, p.err
+}
+
+func TestCachedProvider(t *testing.T) {
+	p := &countingProvider{creds: &credentials{
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+		Expiration:      time.Now().Add(time.Hour),
+	}}
+	cp := &cachedProvider{provider: p}
+
+	for i := 0; i < 3; i++ {
+		if _, err := cp.retrieve(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if p.count != 1 {
+		t.Errorf("credentials should be cached, retrieved %d times", p.count)
+	}
+
+	// Refresh the credentials about to expire, the cached ones are used
+	// if the refresh fails.
+	p.creds = &credentials{AccessKeyID: "id2", Expiration: time.Now().Add(time.Minute)}
+	cp.creds.Expiration = time.Now().Add(time.Minute)
+	p.err = fmt.Errorf("failed")
+	creds, err := cp.retrieve()
+	if err != nil || creds.AccessKeyID != "id" || p.count != 2 {
+		t.Errorf("cached credentials should be used: %v", err)
+	}
+
+	cp.creds.Expiration = time.Now().Add(-time.Minute)
+	if _, err = cp.retrieve(); err == nil {
+		t.Errorf("expired credentials should not be used")
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	os.Setenv("AWS_SESSION_TOKEN", "token")
+	defer func() {
+		os.Unsetenv("AWS_ACCESS_KEY_ID")
+		os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+		os.Unsetenv("AWS_SESSION_TOKEN")
+	}()
+
+	p := newCredentialProvider(&Spec{Region: "us-east-1"})
+	if p.name() != "environment variables" {
+		t.Fatalf("unexpected provider %s", p.name())
+	}
+	creds, err := p.retrieve()
+	if err != nil || creds.AccessKeyID != "id" || creds.SecretAccessKey != "secret" || creds.SessionToken != "token" {
+		t.Errorf("unexpected credentials %+v: %v", creds, err)
+	}
+
+	// The static credentials take precedence.
+	p = newCredentialProvider(&Spec{AccessKeyID: "static", SecretAccessKey: "secret"})
+	if creds, _ := p.retrieve(); creds.AccessKeyID != "static" {
+		t.Errorf("static credentials should be used")
+	}
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("WebIdentityToken") != "jwt" ||
+			r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/test" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <SessionToken>token</SessionToken>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <Expiration>2030-01-01T00:00:00Z</Expiration>
+      <AccessKeyId>id</AccessKeyId>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`)
+	}))
+	defer server.Close()
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	os.WriteFile(tokenFile, []byte("jwt\n"), 0o600)
+
+	p := &webIdentityProvider{
+		client:    server.Client(),
+		endpoint:  server.URL,
+		roleARN:   "arn:aws:iam::123456789012:role/test",
+		tokenFile: tokenFile,
+		session:   "test",
+	}
+	creds, err := p.retrieve()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "id" || creds.SecretAccessKey != "secret" || creds.SessionToken != "token" ||
+		creds.Expiration.Year() != 2030 {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+
+	p.roleARN = "arn:aws:iam::123456789012:role/other"
+	if _, err = p.retrieve(); err == nil {
+		t.Errorf("expect an error")
+	}
+}
+
+func TestIMDSProvider(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/latest/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, "imds-token")
+	})
+	mux.HandleFunc("/latest/meta-data/iam/security-credentials/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Aws-Ec2-Metadata-Token") != "imds-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Path == "/latest/meta-data/iam/security-credentials/" {
+			fmt.Fprint(w, "test-role")
+			return
+		}
+		if r.URL.Path != "/latest/meta-data/iam/security-credentials/test-role" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprint(w, `{"Code":"Success","AccessKeyId":"id","SecretAccessKey":"secret","Token":"token","Expiration":"2030-01-01T00:00:00Z"}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := &imdsProvider{client: server.Client(), endpoint: server.URL}
+	creds, err := p.retrieve()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if creds.AccessKeyID != "id" || creds.SessionToken != "token" || creds.Expiration.Year() != 2030 {
+		t.Errorf("unexpected credentials %+v", creds)
+	}
+}
+
+func TestECSProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/credentials/abc" || r.Header.Get("Authorization") != "ecs-token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"AccessKeyId":"id","SecretAccessKey":"secret","Token":"token","Expiration":"2030-01-01T00:00:00Z"}`)
+	}))
+	defer server.Close()
+
+	p := &ecsProvider{client: server.Client(), url: server.URL + "/v2/credentials/abc", token: "ecs-token"}
+	creds, err := p.retrieve()
+	if err != nil || creds.AccessKeyID != "id" || creds.SessionToken != "token" {
+		t.Errorf("unexpected credentials %+v: %v", creds, err)
+	}
+
+	p.token = ""
+	if _, err = p.retrieve(); err == nil {
+		t.Errorf("expect an error")
+	}
+}
@@ -0,0 +1,299 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bandwidthlimiter
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/bandwidth"
+)
+
+const (
+	// Kind is the kind of BandwidthLimiter.
+	Kind = "BandwidthLimiter"
+
+	// minIdle is the min duration after which an unused keyed limiter is
+	// removed.
+	minIdle = time.Minute
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&BandwidthLimiter{})
+}
+
+type (
+	// Spec is the spec of BandwidthLimiter.
+	Spec struct {
+		// Upload limits the request bodies, Download limits the response
+		// bodies.
+		Upload   *LimitSpec `yaml:"upload,omitempty" jsonschema:"omitempty"`
+		Download *LimitSpec `yaml:"download,omitempty" jsonschema:"omitempty"`
+	}
+
+	// LimitSpec describes the limits of a direction in bytes per second,
+	// a request is limited by all of them.
+	LimitSpec struct {
+		// PerConnection is shared by the requests of a client connection.
+		PerConnection int64 `yaml:"perConnection" jsonschema:"omitempty,minimum=1"`
+		// PerConsumer is shared by the requests of an authenticated
+		// consumer, the requests without identities are limited by their
+		// client IPs.
+		PerConsumer int64 `yaml:"perConsumer" jsonschema:"omitempty,minimum=1"`
+		// Total is shared by all requests of the filter.
+		Total int64 `yaml:"total" jsonschema:"omitempty,minimum=1"`
+		// Burst is the max bytes allowed at once after being idle, it is
+		// the same as the rate of every limit by default.
+		Burst int64 `yaml:"burst" jsonschema:"omitempty,minimum=1"`
+	}
+
+	// BandwidthLimiter limits the bandwidth of request and response
+	// bodies, so bulk transfers don't starve interactive traffic.
+	BandwidthLimiter struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		upload   *limiterSet
+		download *limiterSet
+	}
+
+	// limiterSet is the limiters of a direction.
+	limiterSet struct {
+		perConnection *keyedLimiters
+		perConsumer   *keyedLimiters
+		total         *bandwidth.Limiter
+	}
+
+	// keyedLimiters are the limiters of keys, the unused ones are removed
+	// after being idle long enough to be full again.
+	keyedLimiters struct {
+		rate  int64
+		burst int64
+		idle  time.Duration
+
+		mutex     sync.Mutex
+		limiters  map[string]*keyedEntry
+		lastSweep time.Time
+	}
+
+	keyedEntry struct {
+		limiter  *bandwidth.Limiter
+		lastUsed time.Time
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Upload == nil && spec.Download == nil {
+		return fmt.Errorf("none of upload and download is specified")
+	}
+	if spec.Upload != nil {
+		if err := spec.Upload.Validate(); err != nil {
+			return fmt.Errorf("upload: %v", err)
+		}
+	}
+	if spec.Download != nil {
+		if err := spec.Download.Validate(); err != nil {
+			return fmt.Errorf("download: %v", err)
+		}
+	}
+	return nil
+}
+
+// Validate validates LimitSpec.
+func (spec *LimitSpec) Validate() error {
+	if spec.PerConnection == 0 && spec.PerConsumer == 0 && spec.Total == 0 {
+		return fmt.Errorf("none of perConnection, perConsumer and total is specified")
+	}
+	return nil
+}
+
+func newKeyedLimiters(rate, burst int64) *keyedLimiters {
+	if rate <= 0 {
+		return nil
+	}
+
+	if burst <= 0 {
+		burst = rate
+	}
+	idle := time.Duration(2 * burst * int64(time.Second) / rate)
+	if idle < minIdle {
+		idle = minIdle
+	}
+
+	return &keyedLimiters{
+		rate:      rate,
+		burst:     burst,
+		idle:      idle,
+		limiters:  map[string]*keyedEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the limiter of the key, creates one if not exists.
+func (k *keyedLimiters) get(key string) *bandwidth.Limiter {
+	now := time.Now()
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if now.Sub(k.lastSweep) > k.idle {
+		k.sweep(now)
+	}
+
+	entry := k.limiters[key]
+	if entry == nil {
+		entry = &keyedEntry{limiter: bandwidth.NewLimiter(k.rate, k.burst)}
+		k.limiters[key] = entry
+	}
+	entry.lastUsed = now
+
+	return entry.limiter
+}
+
+func (k *keyedLimiters) sweep(now time.Time) {
+	for key, entry := range k.limiters {
+		if now.Sub(entry.lastUsed) > k.idle {
+			delete(k.limiters, key)
+		}
+	}
+	k.lastSweep = now
+}
+
+func newLimiterSet(spec *LimitSpec) *limiterSet {
+	if spec == nil {
+		return nil
+	}
+
+	ls := &limiterSet{
+		perConnection: newKeyedLimiters(spec.PerConnection, spec.Burst),
+		perConsumer:   newKeyedLimiters(spec.PerConsumer, spec.Burst),
+	}
+	if spec.Total > 0 {
+		ls.total = bandwidth.NewLimiter(spec.Total, spec.Burst)
+	}
+	return ls
+}
+
+// limiters returns the limiters of the request.
+func (ls *limiterSet) limiters(ctx context.HTTPContext) []*bandwidth.Limiter {
+	var limiters []*bandwidth.Limiter
+
+	if ls.perConnection != nil {
+		// NOTE: The remote address is unique among the connections,
+		// and the streams of an HTTP/2 connection share it.
+		limiters = append(limiters, ls.perConnection.get(ctx.Request().Std().RemoteAddr))
+	}
+	if ls.perConsumer != nil {
+		key := ctx.Request().RealIP()
+		if identity := context.GetIdentity(ctx); identity != nil {
+			key = "identity:" + identity.Name()
+		}
+		limiters = append(limiters, ls.perConsumer.get(key))
+	}
+	if ls.total != nil {
+		limiters = append(limiters, ls.total)
+	}
+
+	return limiters
+}
+
+// Kind returns the kind of BandwidthLimiter.
+func (bl *BandwidthLimiter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of BandwidthLimiter.
+func (bl *BandwidthLimiter) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of BandwidthLimiter.
+func (bl *BandwidthLimiter) Description() string {
+	return "BandwidthLimiter limits the bandwidth of request and response bodies."
+}
+
+// Results returns the results of BandwidthLimiter.
+func (bl *BandwidthLimiter) Results() []string {
+	return results
+}
+
+// Init initializes BandwidthLimiter.
+func (bl *BandwidthLimiter) Init(filterSpec *httppipeline.FilterSpec) {
+	bl.filterSpec, bl.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	bl.upload = newLimiterSet(bl.spec.Upload)
+	bl.download = newLimiterSet(bl.spec.Download)
+}
+
+// Inherit inherits previous generation of BandwidthLimiter, the limiters
+// of unchanged directions are kept, so the transfers in progress are not
+// given a new burst.
+func (bl *BandwidthLimiter) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	bl.Init(filterSpec)
+
+	prev := previousGeneration.(*BandwidthLimiter)
+	if reflect.DeepEqual(bl.spec.Upload, prev.spec.Upload) {
+		bl.upload = prev.upload
+	}
+	if reflect.DeepEqual(bl.spec.Download, prev.spec.Download) {
+		bl.download = prev.download
+	}
+}
+
+// Handle handles HTTP request.
+func (bl *BandwidthLimiter) Handle(ctx context.HTTPContext) string {
+	result := bl.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (bl *BandwidthLimiter) handle(ctx context.HTTPContext) string {
+	if bl.upload != nil {
+		if limiters := bl.upload.limiters(ctx); len(limiters) > 0 {
+			r := ctx.Request()
+			r.SetBody(bandwidth.NewReader(ctx, r.Body(), limiters...))
+		}
+	}
+
+	if bl.download != nil {
+		if limiters := bl.download.limiters(ctx); len(limiters) > 0 {
+			// NOTE: The response body is flushed to the client chunk by
+			// chunk after all filters, every chunk waits for the limiters.
+			ctx.Response().OnFlushBody(func(body []byte, complete bool) []byte {
+				bandwidth.WaitAll(ctx, len(body), limiters...)
+				return body
+			})
+		}
+	}
+
+	return ""
+}
+
+// Status returns Status generated by Runtime.
+func (bl *BandwidthLimiter) Status() interface{} {
+	return nil
+}
+
+// Close closes BandwidthLimiter.
+func (bl *BandwidthLimiter) Close() {
+}
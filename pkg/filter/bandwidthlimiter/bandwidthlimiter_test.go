@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bandwidthlimiter
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newBandwidthLimiter(t *testing.T, yamlSpec string) *BandwidthLimiter {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bl := &BandwidthLimiter{}
+	bl.Init(spec)
+	return bl
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	reqBody    io.Reader
+	flushFuncs []context.BodyFlushFunc
+	kv         map[string]interface{}
+}
+
+func newContext(remoteAddr, realIP string, body []byte) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		reqBody:           bytes.NewReader(body),
+		kv:                map[string]interface{}{},
+	}
+
+	stdr := &http.Request{RemoteAddr: remoteAddr}
+	ctx.MockedRequest.MockedStd = func() *http.Request { return stdr }
+	ctx.MockedRequest.MockedRealIP = func() string { return realIP }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return ctx.reqBody }
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) { ctx.reqBody = body }
+	ctx.MockedResponse.MockedOnFlushBody = func(fn context.BodyFlushFunc) {
+		ctx.flushFuncs = append(ctx.flushFuncs, fn)
+	}
+	ctx.MockedGetKV = func(key string) interface{} { return ctx.kv[key] }
+	ctx.MockedSetKV = func(key string, value interface{}) { ctx.kv[key] = value }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	return ctx
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec *Spec
+		ok   bool
+	}{
+		{spec: &Spec{}},
+		{spec: &Spec{Upload: &LimitSpec{}}},
+		{spec: &Spec{Upload: &LimitSpec{Total: 1024}}, ok: true},
+		{spec: &Spec{Download: &LimitSpec{PerConnection: 1024}}, ok: true},
+		{spec: &Spec{Upload: &LimitSpec{PerConsumer: 1024}, Download: &LimitSpec{Burst: 1024}}},
+	}
+
+	for i, c := range cases {
+		if err := c.spec.Validate(); (err == nil) != c.ok {
+			t.Errorf("case %d: ok should be %v, err: %v", i, c.ok, err)
+		}
+	}
+}
+
+func TestUpload(t *testing.T) {
+	bl := newBandwidthLimiter(t, `
+kind: BandwidthLimiter
+name: bl
+upload:
+  perConnection: 102400
+  burst: 16384
+`)
+
+	ctx := newContext("10.0.0.1:1234", "10.0.0.1", make([]byte, 36*1024))
+	start := time.Now()
+	if result := bl.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	data, err := ioutil.ReadAll(ctx.reqBody)
+	if err != nil || len(data) != 36*1024 {
+		t.Fatalf("read body failed: %d, %v", len(data), err)
+	}
+
+	// 16KiB burst, the other 20KiB takes about 200ms.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected elapsed time %v", elapsed)
+	}
+	if len(ctx.flushFuncs) != 0 {
+		t.Errorf("download should not be limited")
+	}
+}
+
+func TestDownloadPerConsumer(t *testing.T) {
+	bl := newBandwidthLimiter(t, `
+kind: BandwidthLimiter
+name: bl
+download:
+  perConsumer: 102400
+`)
+
+	// Requests of the same consumer share the limiter, while the others
+	// are limited separately.
+	ctx1 := newContext("10.0.0.1:1234", "10.0.0.1", nil)
+	context.SetIdentity(ctx1, "jwt", "alice").Consumer = "app"
+	ctx2 := newContext("10.0.0.2:1234", "10.0.0.2", nil)
+	context.SetIdentity(ctx2, "jwt", "bob").Consumer = "app"
+	ctx3 := newContext("10.0.0.3:1234", "10.0.0.3", nil)
+
+	for _, ctx := range []*mockedContext{ctx1, ctx2, ctx3} {
+		bl.Handle(ctx)
+		if len(ctx.flushFuncs) != 1 {
+			t.Fatalf("download should be limited")
+		}
+	}
+
+	chunk := make([]byte, 100*1024)
+	start := time.Now()
+	ctx1.flushFuncs[0](chunk, false)
+	ctx3.flushFuncs[0](chunk, false)
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("burst should be allowed, elapsed %v", elapsed)
+	}
+
+	start = time.Now()
+	if body := ctx2.flushFuncs[0](chunk[:20*1024], true); len(body) != 20*1024 {
+		t.Errorf("body should be kept")
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected elapsed time %v", elapsed)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	yamlSpec := `
+kind: BandwidthLimiter
+name: bl
+upload:
+  total: 1024
+download:
+  total: 1024
+`
+	prev := newBandwidthLimiter(t, yamlSpec)
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec+"  burst: 2048\n"), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bl := &BandwidthLimiter{}
+	bl.Inherit(spec, prev)
+	if bl.upload != prev.upload {
+		t.Errorf("upload limiters should be inherited")
+	}
+	if bl.download == prev.download {
+		t.Errorf("download limiters should be recreated")
+	}
+}
+
+func TestKeyedLimitersSweep(t *testing.T) {
+	k := newKeyedLimiters(1024, 0)
+	if k.idle != minIdle {
+		t.Errorf("idle should be %v, but is %v", minIdle, k.idle)
+	}
+
+	l := k.get("a")
+	if k.get("a") != l {
+		t.Errorf("limiter of the same key should be reused")
+	}
+
+	k.limiters["a"].lastUsed = time.Now().Add(-2 * minIdle)
+	k.lastSweep = time.Now().Add(-2 * minIdle)
+	k.get("b")
+	if _, ok := k.limiters["a"]; ok {
+		t.Errorf("idle limiter should be removed")
+	}
+
+	if newKeyedLimiters(0, 0) != nil {
+		t.Errorf("keyed limiters should be nil without rate")
+	}
+}
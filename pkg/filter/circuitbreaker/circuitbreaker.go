@@ -27,6 +27,7 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/protocol"
 	libcb "github.com/megaease/easegress/pkg/util/circuitbreaker"
 	"github.com/megaease/easegress/pkg/util/fasttime"
 	"github.com/megaease/easegress/pkg/util/urlrule"
@@ -64,8 +65,10 @@ type (
 	// URLRule defines the circuit breaker rule for a URL pattern
 	URLRule struct {
 		urlrule.URLRule `yaml:",inline"`
+		Fallback        *Fallback `yaml:"fallback" jsonschema:"omitempty"`
 		policy          *Policy
 		cb              *libcb.CircuitBreaker
+		lastGood        *lastGoodCache
 	}
 
 	// Spec is the configuration of a circuit breaker
@@ -79,6 +82,7 @@ type (
 	CircuitBreaker struct {
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
+		muxMapper  protocol.MuxMapper
 	}
 
 	// Status is the status of CircuitBreaker.
@@ -218,6 +222,9 @@ func (cb *CircuitBreaker) createCircuitBreakerForURL(u *URLRule) {
 	cb.bindPolicyToURL(u)
 	u.createCircuitBreaker()
 	cb.setStateListenerForURL(u)
+	if u.Fallback != nil && u.Fallback.LastGood {
+		u.lastGood = newLastGoodCache(u.Fallback)
+	}
 }
 
 func isSamePolicy(spec1, spec2 *Spec, policyName string) bool {
@@ -269,6 +276,13 @@ OuterLoop:
 			url.cb = prev.cb
 			prev.cb = nil
 			cb.setStateListenerForURL(url)
+			if url.Fallback != nil && url.Fallback.LastGood {
+				if prev.lastGood != nil && reflect.DeepEqual(url.Fallback, prev.Fallback) {
+					url.lastGood = prev.lastGood
+				} else {
+					url.lastGood = newLastGoodCache(url.Fallback)
+				}
+			}
 			continue OuterLoop
 		}
 		cb.createCircuitBreakerForURL(url)
@@ -291,8 +305,15 @@ func (cb *CircuitBreaker) handle(ctx context.HTTPContext, u *URLRule) string {
 	permitted, stateID := u.cb.AcquirePermission()
 	if !permitted {
 		ctx.AddTag("circuitBreaker: circuit is broken")
-		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
 		ctx.Response().Std().Header().Set("X-EG-Circuit-Breaker", "circurit-is-broken")
+		switch cb.handleFallback(ctx, u) {
+		case fallbackNone:
+			ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		case fallbackPipeline:
+			// NOTE: The fallback pipeline replaces the handler caller of
+			// the context, so the rest of this pipeline is skipped.
+			return resultShortCircuited
+		}
 		return ctx.CallNextHandler(resultShortCircuited)
 	}
 
@@ -319,6 +340,9 @@ func (cb *CircuitBreaker) handle(ctx context.HTTPContext, u *URLRule) string {
 		}
 	}
 	u.cb.RecordResult(stateID, hasErr, d)
+	if !hasErr && u.lastGood != nil {
+		u.lastGood.store(ctx)
+	}
 
 	return result
 }
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	defaultLastGoodMaxBytes = 1024 * 1024
+
+	// lastGoodMaxEntries is the max number of last good responses kept
+	// for a URL rule, responses of new requests are not kept after it.
+	lastGoodMaxEntries = 1024
+
+	fallbackNone     = ""
+	fallbackLastGood = "lastGood"
+	fallbackPipeline = "pipeline"
+	fallbackStatic   = "static"
+)
+
+type (
+	// Fallback defines the response served when the circuit is broken.
+	// The last good response is preferred, then the pipeline, and the
+	// static response is served if neither of them is available.
+	Fallback struct {
+		// LastGood serves the last successful response of the same method and path.
+		LastGood bool `yaml:"lastGood" jsonschema:"omitempty"`
+		// LastGoodMaxBytes is the max body size of the last good response to keep.
+		LastGoodMaxBytes uint32 `yaml:"lastGoodMaxBytes" jsonschema:"omitempty"`
+		// Pipeline is the name of the pipeline to handle the request.
+		Pipeline string `yaml:"pipeline" jsonschema:"omitempty"`
+
+		StatusCode int               `yaml:"statusCode" jsonschema:"omitempty,format=httpcode"`
+		Headers    map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Body       string            `yaml:"body" jsonschema:"omitempty"`
+	}
+
+	lastGoodCache struct {
+		maxBytes int
+
+		mutex   sync.RWMutex
+		entries map[string]*lastGoodEntry
+	}
+
+	lastGoodEntry struct {
+		statusCode int
+		header     *httpheader.HTTPHeader
+		body       []byte
+	}
+)
+
+func newLastGoodCache(fb *Fallback) *lastGoodCache {
+	maxBytes := int(fb.LastGoodMaxBytes)
+	if maxBytes == 0 {
+		maxBytes = defaultLastGoodMaxBytes
+	}
+
+	return &lastGoodCache{
+		maxBytes: maxBytes,
+		entries:  map[string]*lastGoodEntry{},
+	}
+}
+
+func lastGoodKey(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	return stringtool.Cat(r.Method(), " ", r.Path())
+}
+
+func (c *lastGoodCache) load(ctx context.HTTPContext) bool {
+	c.mutex.RLock()
+	entry := c.entries[lastGoodKey(ctx)]
+	c.mutex.RUnlock()
+
+	if entry == nil {
+		return false
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(entry.statusCode)
+	w.Header().AddFrom(entry.header)
+	w.SetBody(bytes.NewReader(entry.body))
+	return true
+}
+
+// store keeps the response if it is successful.
+func (c *lastGoodCache) store(ctx context.HTTPContext) {
+	w := ctx.Response()
+	if w.StatusCode() < 200 || w.StatusCode() >= 300 {
+		return
+	}
+
+	key := lastGoodKey(ctx)
+	entry := &lastGoodEntry{
+		statusCode: w.StatusCode(),
+		header:     w.Header().Copy(),
+	}
+
+	bodyLength := 0
+	w.OnFlushBody(func(body []byte, complete bool) []byte {
+		bodyLength += len(body)
+		if bodyLength > c.maxBytes {
+			return body
+		}
+
+		entry.body = append(entry.body, body...)
+		if !complete {
+			return body
+		}
+
+		c.mutex.Lock()
+		if _, exists := c.entries[key]; exists || len(c.entries) < lastGoodMaxEntries {
+			c.entries[key] = entry
+		}
+		c.mutex.Unlock()
+
+		return body
+	})
+}
+
+// handleFallback serves the fallback response, it returns the kind of
+// the served fallback, or fallbackNone if no fallback is available.
+func (cb *CircuitBreaker) handleFallback(ctx context.HTTPContext, u *URLRule) string {
+	fb := u.Fallback
+	if fb == nil {
+		return fallbackNone
+	}
+
+	if u.lastGood != nil && u.lastGood.load(ctx) {
+		ctx.AddTag("circuitBreaker: fallback to last good response")
+		return fallbackLastGood
+	}
+
+	if fb.Pipeline != "" {
+		if handler, exists := cb.getHandler(fb.Pipeline); exists {
+			ctx.AddTag(stringtool.Cat("circuitBreaker: fallback to pipeline ", fb.Pipeline))
+			handler.Handle(ctx)
+			return fallbackPipeline
+		}
+		logger.Errorf("circuit breaker %s: fallback pipeline %s not found",
+			cb.filterSpec.Name(), fb.Pipeline)
+	}
+
+	if fb.StatusCode == 0 && fb.Body == "" && len(fb.Headers) == 0 {
+		return fallbackNone
+	}
+
+	w := ctx.Response()
+	if fb.StatusCode != 0 {
+		w.SetStatusCode(fb.StatusCode)
+	} else {
+		w.SetStatusCode(http.StatusServiceUnavailable)
+	}
+	for k, v := range fb.Headers {
+		w.Header().Set(k, v)
+	}
+	w.SetBody(strings.NewReader(fb.Body))
+	ctx.AddTag("circuitBreaker: fallback to static response")
+
+	return fallbackStatic
+}
+
+func (cb *CircuitBreaker) getHandler(name string) (protocol.HTTPHandler, bool) {
+	if cb.muxMapper == nil {
+		return nil, false
+	}
+	return cb.muxMapper.GetHandler(name)
+}
+
+// InjectMuxMapper injects mux mapper into CircuitBreaker.
+func (cb *CircuitBreaker) InjectMuxMapper(mapper protocol.MuxMapper) {
+	cb.muxMapper = mapper
+}
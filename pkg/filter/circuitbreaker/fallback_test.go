@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package circuitbreaker
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+type (
+	mockedMuxMapper map[string]protocol.HTTPHandler
+
+	handlerFunc func(ctx context.HTTPContext) string
+)
+
+func (m mockedMuxMapper) GetHandler(name string) (protocol.HTTPHandler, bool) {
+	h, ok := m[name]
+	return h, ok
+}
+
+func (f handlerFunc) Handle(ctx context.HTTPContext) string {
+	return f(ctx)
+}
+
+type fallbackContext struct {
+	*contexttest.MockedHTTPContext
+	statusCode int
+	body       io.Reader
+	flush      context.BodyFlushFunc
+	next       int
+}
+
+func newFallbackContext(path string) *fallbackContext {
+	ctx := &fallbackContext{MockedHTTPContext: &contexttest.MockedHTTPContext{}}
+	header := httpheader.New(http.Header{})
+	std := httptest.NewRecorder()
+
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedResponse.MockedStd = func() http.ResponseWriter { return std }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedResponse.MockedStatusCode = func() int { return ctx.statusCode }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { ctx.body = body }
+	ctx.MockedResponse.MockedOnFlushBody = func(fn context.BodyFlushFunc) { ctx.flush = fn }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		ctx.next++
+		return lastResult
+	}
+
+	return ctx
+}
+
+func (ctx *fallbackContext) bodyString(t *testing.T) string {
+	if ctx.body == nil {
+		return ""
+	}
+	data, err := ioutil.ReadAll(ctx.body)
+	if err != nil {
+		t.Fatalf("read body failed: %v", err)
+	}
+	return string(data)
+}
+
+func newFallbackCircuitBreaker(t *testing.T, fallback string) *CircuitBreaker {
+	yamlSpec := `
+kind: CircuitBreaker
+name: circuitbreaker
+policies:
+- name: default
+  failureRateThreshold: 50
+  slidingWindowType: COUNT_BASED
+  slidingWindowSize: 10
+  minimumNumberOfCalls: 2
+  failureStatusCodes: [500]
+defaultPolicyRef: default
+urls:
+- url:
+    prefix: /
+  fallback:
+` + fallback
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cb := &CircuitBreaker{}
+	cb.InjectMuxMapper(mockedMuxMapper{
+		"fallback-pipeline": handlerFunc(func(ctx context.HTTPContext) string {
+			ctx.Response().SetStatusCode(http.StatusAccepted)
+			return ""
+		}),
+	})
+	cb.Init(spec)
+	return cb
+}
+
+// breakCircuit makes requests to the path fail until the circuit is broken.
+func breakCircuit(cb *CircuitBreaker, path string) {
+	for i := 0; i < 2; i++ {
+		ctx := newFallbackContext(path)
+		ctx.statusCode = http.StatusInternalServerError
+		cb.Handle(ctx)
+	}
+}
+
+func TestStaticFallback(t *testing.T) {
+	cb := newFallbackCircuitBreaker(t, `
+    statusCode: 200
+    headers:
+      Content-Type: application/json
+    body: '{"items": []}'
+`)
+	breakCircuit(cb, "/items")
+
+	ctx := newFallbackContext("/items")
+	if result := cb.Handle(ctx); result != resultShortCircuited {
+		t.Fatalf("should be short circuited")
+	}
+	if ctx.statusCode != http.StatusOK {
+		t.Errorf("want status code 200, got %d", ctx.statusCode)
+	}
+	if ctx.Response().Header().Get("Content-Type") != "application/json" {
+		t.Errorf("header of the fallback response is not set")
+	}
+	if body := ctx.bodyString(t); body != `{"items": []}` {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if ctx.next != 1 {
+		t.Errorf("next handler should be called")
+	}
+}
+
+func TestLastGoodFallback(t *testing.T) {
+	cb := newFallbackCircuitBreaker(t, `
+    lastGood: true
+`)
+
+	ctx := newFallbackContext("/good")
+	ctx.statusCode = http.StatusOK
+	ctx.Response().Header().Set("X-Good", "yes")
+	cb.Handle(ctx)
+	ctx.flush([]byte("good "), false)
+	ctx.flush([]byte("response"), true)
+
+	breakCircuit(cb, "/good")
+
+	ctx = newFallbackContext("/good")
+	cb.Handle(ctx)
+	if ctx.statusCode != http.StatusOK {
+		t.Errorf("want status code 200, got %d", ctx.statusCode)
+	}
+	if ctx.Response().Header().Get("X-Good") != "yes" {
+		t.Errorf("header of the last good response is not set")
+	}
+	if body := ctx.bodyString(t); body != "good response" {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	// No last good response of the path, falls back to the default behavior.
+	ctx = newFallbackContext("/other")
+	cb.Handle(ctx)
+	if ctx.statusCode != http.StatusServiceUnavailable {
+		t.Errorf("want status code 503, got %d", ctx.statusCode)
+	}
+}
+
+func TestPipelineFallback(t *testing.T) {
+	cb := newFallbackCircuitBreaker(t, `
+    pipeline: fallback-pipeline
+`)
+	breakCircuit(cb, "/items")
+
+	ctx := newFallbackContext("/items")
+	if result := cb.Handle(ctx); result != resultShortCircuited {
+		t.Fatalf("should be short circuited")
+	}
+	if ctx.statusCode != http.StatusAccepted {
+		t.Errorf("want status code 202, got %d", ctx.statusCode)
+	}
+	if ctx.next != 0 {
+		t.Errorf("next handler should not be called")
+	}
+
+	cb = newFallbackCircuitBreaker(t, `
+    pipeline: not-exist
+    statusCode: 418
+`)
+	breakCircuit(cb, "/items")
+	ctx = newFallbackContext("/items")
+	cb.Handle(ctx)
+	if ctx.statusCode != http.StatusTeapot {
+		t.Errorf("want status code 418, got %d", ctx.statusCode)
+	}
+}
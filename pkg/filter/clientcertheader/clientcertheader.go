@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clientcertheader
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of ClientCertHeader.
+	Kind = "ClientCertHeader"
+
+	resultNoCert = "noCert"
+)
+
+// Fields of client certificate could be mapped to headers.
+const (
+	FieldSubject     = "subject"
+	FieldSubjectCN   = "subjectCN"
+	FieldIssuer      = "issuer"
+	FieldSerial      = "serial"
+	FieldFingerprint = "fingerprint"
+	FieldNotBefore   = "notBefore"
+	FieldNotAfter    = "notAfter"
+	FieldSANDNS      = "sanDNS"
+	FieldSANURI      = "sanURI"
+	FieldSANEmail    = "sanEmail"
+	FieldSANIP       = "sanIP"
+	FieldCert        = "cert"
+	FieldXFCC        = "xfcc"
+)
+
+var results = []string{resultNoCert}
+
+func init() {
+	httppipeline.Register(&ClientCertHeader{})
+}
+
+type (
+	// ClientCertHeader is filter to put the fields of the verified client
+	// certificate into request headers.
+	ClientCertHeader struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+	}
+
+	// Spec describes the ClientCertHeader.
+	Spec struct {
+		RequireCert bool      `yaml:"requireCert" jsonschema:"omitempty"`
+		Headers     []*Header `yaml:"headers" jsonschema:"required"`
+	}
+
+	// Header maps a field of the client certificate to a request header.
+	Header struct {
+		Field string `yaml:"field" jsonschema:"required,enum=subject,enum=subjectCN,enum=issuer,enum=serial,enum=fingerprint,enum=notBefore,enum=notAfter,enum=sanDNS,enum=sanURI,enum=sanEmail,enum=sanIP,enum=cert,enum=xfcc"`
+		Name  string `yaml:"name" jsonschema:"required"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	names := map[string]struct{}{}
+	for _, h := range spec.Headers {
+		name := http.CanonicalHeaderKey(h.Name)
+		if _, exists := names[name]; exists {
+			return fmt.Errorf("header %s is duplicated", h.Name)
+		}
+		names[name] = struct{}{}
+	}
+	return nil
+}
+
+// Kind returns the kind of ClientCertHeader.
+func (cch *ClientCertHeader) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ClientCertHeader.
+func (cch *ClientCertHeader) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of ClientCertHeader.
+func (cch *ClientCertHeader) Description() string {
+	return "ClientCertHeader puts fields of the verified client certificate into request headers."
+}
+
+// Results returns the results of ClientCertHeader.
+func (cch *ClientCertHeader) Results() []string {
+	return results
+}
+
+// Init initializes ClientCertHeader.
+func (cch *ClientCertHeader) Init(filterSpec *httppipeline.FilterSpec) {
+	cch.filterSpec, cch.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+}
+
+// Inherit inherits previous generation of ClientCertHeader.
+func (cch *ClientCertHeader) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	cch.Init(filterSpec)
+}
+
+// Handle handles HTTPContext.
+func (cch *ClientCertHeader) Handle(ctx context.HTTPContext) string {
+	result := cch.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (cch *ClientCertHeader) handle(ctx context.HTTPContext) string {
+	header := ctx.Request().Header()
+
+	// NOTE: Always remove the headers sent by the client, otherwise
+	// backends may trust a forged identity.
+	for _, h := range cch.spec.Headers {
+		header.Del(h.Name)
+	}
+
+	cert := verifiedCert(ctx.Request().Std())
+	if cert == nil {
+		if cch.spec.RequireCert {
+			ctx.Response().SetStatusCode(http.StatusUnauthorized)
+			ctx.AddTag("clientCertHeader: no verified client certificate")
+			return resultNoCert
+		}
+		return ""
+	}
+
+	context.SetIdentity(ctx, "clientCert", cert.Subject.CommonName)
+
+	for _, h := range cch.spec.Headers {
+		if v := fieldValue(cert, h.Field); v != "" {
+			header.Set(h.Name, v)
+		}
+	}
+
+	return ""
+}
+
+// verifiedCert returns the leaf certificate of the first verified chain,
+// certificates not verified against the CA of the server are ignored.
+func verifiedCert(r *http.Request) *x509.Certificate {
+	if r == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	if len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func encodedPEM(cert *x509.Certificate) string {
+	p := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	return url.QueryEscape(string(p))
+}
+
+func sanIPs(cert *x509.Certificate) []string {
+	ips := make([]string, 0, len(cert.IPAddresses))
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	return ips
+}
+
+func sanURIs(cert *x509.Certificate) []string {
+	uris := make([]string, 0, len(cert.URIs))
+	for _, u := range cert.URIs {
+		uris = append(uris, u.String())
+	}
+	return uris
+}
+
+// xfcc returns the value in the format of x-forwarded-client-cert of Envoy.
+func xfcc(cert *x509.Certificate) string {
+	elems := []string{
+		"Hash=" + fingerprint(cert),
+		"Cert=\"" + encodedPEM(cert) + "\"",
+		"Subject=\"" + strings.ReplaceAll(cert.Subject.String(), `"`, `\"`) + "\"",
+	}
+	for _, u := range sanURIs(cert) {
+		elems = append(elems, "URI="+u)
+	}
+	for _, name := range cert.DNSNames {
+		elems = append(elems, "DNS="+name)
+	}
+	return strings.Join(elems, ";")
+}
+
+func fieldValue(cert *x509.Certificate, field string) string {
+	switch field {
+	case FieldSubject:
+		return cert.Subject.String()
+	case FieldSubjectCN:
+		return cert.Subject.CommonName
+	case FieldIssuer:
+		return cert.Issuer.String()
+	case FieldSerial:
+		return cert.SerialNumber.Text(16)
+	case FieldFingerprint:
+		return fingerprint(cert)
+	case FieldNotBefore:
+		return cert.NotBefore.UTC().Format(time.RFC3339)
+	case FieldNotAfter:
+		return cert.NotAfter.UTC().Format(time.RFC3339)
+	case FieldSANDNS:
+		return strings.Join(cert.DNSNames, ",")
+	case FieldSANURI:
+		return strings.Join(sanURIs(cert), ",")
+	case FieldSANEmail:
+		return strings.Join(cert.EmailAddresses, ",")
+	case FieldSANIP:
+		return strings.Join(sanIPs(cert), ",")
+	case FieldCert:
+		return encodedPEM(cert)
+	case FieldXFCC:
+		return xfcc(cert)
+	}
+	return ""
+}
+
+// Status returns status.
+func (cch *ClientCertHeader) Status() interface{} {
+	return nil
+}
+
+// Authenticates marks ClientCertHeader as a filter authenticating requests.
+func (cch *ClientCertHeader) Authenticates() {}
+
+// Close closes ClientCertHeader.
+func (cch *ClientCertHeader) Close() {}
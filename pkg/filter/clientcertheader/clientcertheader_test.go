@@ -0,0 +1,179 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clientcertheader
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func createClientCertHeader(yamlSpec string) *ClientCertHeader {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		panic(err)
+	}
+	cch := &ClientCertHeader{}
+	cch.Init(spec)
+	return cch
+}
+
+func newCert(t *testing.T) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spiffe, _ := url.Parse("spiffe://megaease.com/ns/default/sa/order")
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0x1234),
+		Subject:        pkix.Name{CommonName: "order", Organization: []string{"MegaEase"}},
+		NotBefore:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:       time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:       []string{"order.megaease.com", "order"},
+		EmailAddresses: []string{"order@megaease.com"},
+		IPAddresses:    []net.IP{net.ParseIP("10.0.0.1")},
+		URIs:           []*url.URL{spiffe},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestFieldValue(t *testing.T) {
+	cert := newCert(t)
+
+	cases := map[string]string{
+		FieldSubjectCN: "order",
+		FieldSerial:    "1234",
+		FieldNotBefore: "2021-01-01T00:00:00Z",
+		FieldNotAfter:  "2031-01-01T00:00:00Z",
+		FieldSANDNS:    "order.megaease.com,order",
+		FieldSANURI:    "spiffe://megaease.com/ns/default/sa/order",
+		FieldSANEmail:  "order@megaease.com",
+		FieldSANIP:     "10.0.0.1",
+	}
+	for field, want := range cases {
+		if got := fieldValue(cert, field); got != want {
+			t.Errorf("field %s: want %q, got %q", field, want, got)
+		}
+	}
+
+	if v := fieldValue(cert, FieldSubject); !strings.Contains(v, "CN=order") || !strings.Contains(v, "O=MegaEase") {
+		t.Errorf("unexpected subject %q", v)
+	}
+	if v := fieldValue(cert, FieldFingerprint); len(v) != 64 {
+		t.Errorf("fingerprint should be 64 hex characters, but got %q", v)
+	}
+
+	v := fieldValue(cert, FieldXFCC)
+	for _, s := range []string{"Hash=" + fieldValue(cert, FieldFingerprint), `Subject="`, "URI=spiffe://", "DNS=order.megaease.com", `Cert="-----BEGIN`} {
+		if !strings.Contains(v, s) {
+			t.Errorf("xfcc %q should contain %q", v, s)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	cch := createClientCertHeader(`
+kind: ClientCertHeader
+name: cch
+requireCert: true
+headers:
+- field: subjectCN
+  name: X-Client-CN
+- field: sanDNS
+  name: X-Client-DNS
+`)
+
+	header := http.Header{}
+	stdr := &http.Request{}
+	statusCode := 0
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	ctx.MockedRequest.MockedStd = func() *http.Request {
+		return stdr
+	}
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) {
+		statusCode = code
+	}
+
+	// forged headers must be removed
+	header.Set("X-Client-CN", "admin")
+	if result := cch.Handle(ctx); result != resultNoCert {
+		t.Errorf("result should be %s, but got %q", resultNoCert, result)
+	}
+	if statusCode != http.StatusUnauthorized {
+		t.Errorf("status code should be 401, but got %d", statusCode)
+	}
+	if header.Get("X-Client-CN") != "" {
+		t.Errorf("forged header should be removed")
+	}
+
+	// unverified certificates are ignored
+	cert := newCert(t)
+	stdr.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	if result := cch.Handle(ctx); result != resultNoCert {
+		t.Errorf("unverified certificate should be ignored")
+	}
+
+	stdr.TLS.VerifiedChains = [][]*x509.Certificate{{cert}}
+	header.Set("X-Client-CN", "admin")
+	if result := cch.Handle(ctx); result != "" {
+		t.Errorf("result should be empty, but got %q", result)
+	}
+	if v := header.Get("X-Client-CN"); v != "order" {
+		t.Errorf("X-Client-CN should be order, but got %q", v)
+	}
+	if v := header.Get("X-Client-DNS"); v != "order.megaease.com,order" {
+		t.Errorf("X-Client-DNS should be order.megaease.com,order, but got %q", v)
+	}
+}
@@ -0,0 +1,373 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cloudevents
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of CloudEvents.
+	Kind = "CloudEvents"
+
+	resultInvalid = "invalid"
+
+	modeWrap   = "wrap"
+	modeUnwrap = "unwrap"
+
+	formatBinary     = "binary"
+	formatStructured = "structured"
+
+	defaultMaxBodySize = 4 * 1024 * 1024
+
+	noValue = "<no value>"
+)
+
+var results = []string{resultInvalid}
+
+// extensionName is the naming rule of the attributes.
+var extensionName = regexp.MustCompile(`^[a-z0-9]{1,20}$`)
+
+func init() {
+	httppipeline.Register(&CloudEvents{})
+}
+
+type (
+	// CloudEvents wraps request payloads in CloudEvents envelopes, or
+	// unwraps payloads from them.
+	CloudEvents struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		templates map[string]*template.Template
+	}
+
+	// Spec describes the CloudEvents.
+	Spec struct {
+		Mode   string `yaml:"mode" jsonschema:"required,enum=wrap,enum=unwrap"`
+		Format string `yaml:"format" jsonschema:"omitempty,enum=,enum=binary,enum=structured"`
+
+		// The templates of attributes, only used by the wrap mode.
+		Type       string            `yaml:"type" jsonschema:"omitempty"`
+		Source     string            `yaml:"source" jsonschema:"omitempty"`
+		ID         string            `yaml:"id" jsonschema:"omitempty"`
+		Subject    string            `yaml:"subject" jsonschema:"omitempty"`
+		DataSchema string            `yaml:"dataSchema" jsonschema:"omitempty,format=uri"`
+		Extensions map[string]string `yaml:"extensions" jsonschema:"omitempty"`
+
+		// MaxBodySize is the max size of the request bodies to be
+		// wrapped or unwrapped in the structured mode, the default
+		// is 4MiB.
+		MaxBodySize uint32 `yaml:"maxBodySize" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Mode == modeUnwrap {
+		return nil
+	}
+
+	if spec.Type == "" || spec.Source == "" {
+		return fmt.Errorf("type and source are required in wrap mode")
+	}
+
+	for name, tmpl := range spec.attributeTemplates() {
+		if _, err := newTemplate(name, tmpl); err != nil {
+			return fmt.Errorf("invalid template of %s: %v", name, err)
+		}
+	}
+
+	for name := range spec.Extensions {
+		if !extensionName.MatchString(name) {
+			return fmt.Errorf("invalid extension name %s: only lower-case letters and digits are allowed", name)
+		}
+		switch name {
+		case "id", "source", "specversion", "type", "subject", "time",
+			"dataschema", "datacontenttype", "data", "data_base64":
+			return fmt.Errorf("extension %s conflicts with the context attribute", name)
+		}
+	}
+
+	return nil
+}
+
+// attributeTemplates returns the templates of attributes indexed by
+// the attribute names.
+func (spec *Spec) attributeTemplates() map[string]string {
+	m := map[string]string{
+		"type":   spec.Type,
+		"source": spec.Source,
+	}
+	if spec.ID != "" {
+		m["id"] = spec.ID
+	}
+	if spec.Subject != "" {
+		m["subject"] = spec.Subject
+	}
+	for name, tmpl := range spec.Extensions {
+		m[name] = tmpl
+	}
+	return m
+}
+
+func newTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=zero").Parse(text)
+}
+
+// Kind returns the kind of CloudEvents.
+func (ce *CloudEvents) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of CloudEvents.
+func (ce *CloudEvents) DefaultSpec() interface{} {
+	return &Spec{Format: formatBinary}
+}
+
+// Description returns the description of CloudEvents.
+func (ce *CloudEvents) Description() string {
+	return "CloudEvents wraps or unwraps request payloads in CloudEvents envelopes."
+}
+
+// Results returns the results of CloudEvents.
+func (ce *CloudEvents) Results() []string {
+	return results
+}
+
+// Init initializes CloudEvents.
+func (ce *CloudEvents) Init(filterSpec *httppipeline.FilterSpec) {
+	ce.filterSpec, ce.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ce.reload()
+}
+
+// Inherit inherits previous generation of CloudEvents.
+func (ce *CloudEvents) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ce.Init(filterSpec)
+}
+
+func (ce *CloudEvents) reload() {
+	ce.templates = map[string]*template.Template{}
+	if ce.spec.Mode != modeWrap {
+		return
+	}
+
+	for name, text := range ce.spec.attributeTemplates() {
+		// NOTE: The templates have been checked in Validate.
+		tmpl, _ := newTemplate(name, text)
+		ce.templates[name] = tmpl
+	}
+}
+
+// Handle wraps or unwraps the request.
+func (ce *CloudEvents) Handle(ctx context.HTTPContext) string {
+	result := ce.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ce *CloudEvents) handle(ctx context.HTTPContext) string {
+	var err error
+	if ce.spec.Mode == modeUnwrap {
+		err = ce.unwrap(ctx)
+	} else {
+		err = ce.wrap(ctx)
+	}
+
+	if err != nil {
+		ctx.Response().SetStatusCode(http.StatusBadRequest)
+		ctx.AddTag(fmt.Sprintf("cloudEvents: %v", err))
+		return resultInvalid
+	}
+	return ""
+}
+
+func (ce *CloudEvents) maxBodySize() int64 {
+	if ce.spec.MaxBodySize == 0 {
+		return defaultMaxBodySize
+	}
+	return int64(ce.spec.MaxBodySize)
+}
+
+// readBody reads the whole request body, it fails if the body is too
+// large.
+func (ce *CloudEvents) readBody(ctx context.HTTPContext) ([]byte, error) {
+	body := ctx.Request().Body()
+	if body == nil {
+		return nil, nil
+	}
+
+	maxBodySize := ce.maxBodySize()
+	data, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+	if int64(len(data)) > maxBodySize {
+		return nil, fmt.Errorf("body exceeds %d bytes", maxBodySize)
+	}
+	return data, nil
+}
+
+// isEvent reports whether the request is already a CloudEvent.
+func isEvent(h *httpheader.HTTPHeader) bool {
+	return h.Get(headerPrefix+"specversion") != "" || isStructured(h.Get(httpheader.KeyContentType))
+}
+
+// renderAttributes renders the attributes of the wrap mode.
+func (ce *CloudEvents) renderAttributes(ctx context.HTTPContext) (map[string]string, error) {
+	r := ctx.Request()
+	data := map[string]interface{}{
+		"jwt":    ctx.GetKV(context.KeyJWTClaims),
+		"realIP": r.RealIP(),
+		"method": r.Method(),
+		"host":   r.Host(),
+		"path":   r.Path(),
+		"header": r.Header().Std(),
+	}
+
+	attrs := make(map[string]string, len(ce.templates))
+	for name, tmpl := range ce.templates {
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, data); err != nil {
+			return nil, fmt.Errorf("render %s failed: %v", name, err)
+		}
+		if v := strings.ReplaceAll(sb.String(), noValue, ""); v != "" {
+			attrs[name] = v
+		}
+	}
+	return attrs, nil
+}
+
+func (ce *CloudEvents) wrap(ctx context.HTTPContext) error {
+	r := ctx.Request()
+	if isEvent(r.Header()) {
+		ctx.AddTag("cloudEvents: already an event")
+		return nil
+	}
+
+	attrs, err := ce.renderAttributes(ctx)
+	if err != nil {
+		return err
+	}
+
+	e := newEvent()
+	for k, v := range attrs {
+		e.attrs[k] = v
+	}
+	if e.attrs["id"] == "" {
+		e.attrs["id"] = uuid.NewString()
+	}
+	e.attrs["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+	if ce.spec.DataSchema != "" {
+		e.attrs["dataschema"] = ce.spec.DataSchema
+	}
+	if ct := r.Header().Get(httpheader.KeyContentType); ct != "" {
+		e.attrs["datacontenttype"] = ct
+	}
+
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	if ce.spec.Format != formatStructured {
+		for k, v := range e.binaryHeaders() {
+			r.Header().Set(k, v)
+		}
+		return nil
+	}
+
+	e.data, err = ce.readBody(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := e.marshalStructured()
+	if err != nil {
+		logger.Errorf("BUG: %s: marshal event failed: %v", ce.filterSpec.Name(), err)
+		return fmt.Errorf("marshal event failed: %v", err)
+	}
+
+	r.SetBody(bytes.NewReader(body))
+	r.Header().Set(httpheader.KeyContentType, structuredContentType)
+	r.Header().Del(httpheader.KeyContentLength)
+	return nil
+}
+
+// unwrap converts the event in the request to the binary content mode,
+// so the body is the payload, and the attributes are kept in the
+// Ce- headers.
+func (ce *CloudEvents) unwrap(ctx context.HTTPContext) error {
+	r := ctx.Request()
+	h := r.Header()
+
+	if !isStructured(h.Get(httpheader.KeyContentType)) {
+		e := &event{attrs: map[string]string{}}
+		for k, values := range h.Std() {
+			if len(values) > 0 && strings.HasPrefix(k, headerPrefix) {
+				name := strings.ToLower(strings.TrimPrefix(k, headerPrefix))
+				e.attrs[name] = decodeHeaderValue(values[0])
+			}
+		}
+		return e.validate()
+	}
+
+	data, err := ce.readBody(ctx)
+	if err != nil {
+		return err
+	}
+	e, err := unmarshalStructured(data)
+	if err != nil {
+		return err
+	}
+	if err := e.validate(); err != nil {
+		return err
+	}
+
+	for k, v := range e.binaryHeaders() {
+		h.Set(k, v)
+	}
+	if ct := e.attrs["datacontenttype"]; ct != "" {
+		h.Set(httpheader.KeyContentType, ct)
+	} else {
+		h.Set(httpheader.KeyContentType, "application/json")
+	}
+	r.SetBody(bytes.NewReader(e.data))
+	h.Del(httpheader.KeyContentLength)
+	return nil
+}
+
+// Status returns status.
+func (ce *CloudEvents) Status() interface{} {
+	return nil
+}
+
+// Close closes CloudEvents.
+func (ce *CloudEvents) Close() {}
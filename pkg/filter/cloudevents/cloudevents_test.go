@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cloudevents
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newCloudEvents(t *testing.T, yamlSpec string) *CloudEvents {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ce := &CloudEvents{}
+	ce.Init(spec)
+	return ce
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	header     *httpheader.HTTPHeader
+	body       io.Reader
+	statusCode int
+}
+
+func newContext(header map[string]string, body string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		header:            httpheader.New(http.Header{}),
+		body:              strings.NewReader(body),
+		statusCode:        http.StatusOK,
+	}
+	for k, v := range header {
+		ctx.header.Set(k, v)
+	}
+
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodPost }
+	ctx.MockedRequest.MockedPath = func() string { return "/orders" }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return ctx.header }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return ctx.body }
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) { ctx.body = body }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	return ctx
+}
+
+func (ctx *mockedContext) bodyString(t *testing.T) string {
+	data, err := ioutil.ReadAll(ctx.body)
+	if err != nil {
+		t.Fatalf("read body failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec  Spec
+		valid bool
+	}{
+		{Spec{Mode: modeUnwrap}, true},
+		{Spec{Mode: modeWrap, Type: "t"}, false},
+		{Spec{Mode: modeWrap, Type: "t", Source: "{{.path"}, false},
+		{Spec{Mode: modeWrap, Type: "t", Source: "s", Extensions: map[string]string{"Tenant": "a"}}, false},
+		{Spec{Mode: modeWrap, Type: "t", Source: "s", Extensions: map[string]string{"subject": "a"}}, false},
+		{Spec{Mode: modeWrap, Type: "t", Source: "{{.path}}", Extensions: map[string]string{"tenant": "a"}}, true},
+	}
+
+	for i, c := range cases {
+		err := c.spec.Validate()
+		if c.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("case %d: should be invalid", i)
+		}
+	}
+}
+
+func TestWrapBinary(t *testing.T) {
+	ce := newCloudEvents(t, `
+kind: CloudEvents
+name: cloudevents
+mode: wrap
+type: com.example.order.created
+source: "{{.path}}"
+subject: "{{index .header \"X-Tenant\" 0}}"
+extensions:
+  tenant: "{{index .header \"X-Tenant\" 0}}"
+`)
+
+	ctx := newContext(map[string]string{
+		httpheader.KeyContentType: "application/json",
+		"X-Tenant":                "acme corp",
+	}, `{"id":1}`)
+
+	if result := ce.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+
+	h := ctx.header
+	if h.Get("Ce-Specversion") != "1.0" || h.Get("Ce-Type") != "com.example.order.created" ||
+		h.Get("Ce-Source") != "/orders" || h.Get("Ce-Id") == "" || h.Get("Ce-Time") == "" {
+		t.Errorf("unexpected headers: %v", h.Std())
+	}
+	if h.Get("Ce-Subject") != "acme%20corp" || h.Get("Ce-Tenant") != "acme%20corp" {
+		t.Errorf("attributes should be percent-encoded: %v", h.Std())
+	}
+	if h.Get(httpheader.KeyContentType) != "application/json" {
+		t.Errorf("content type should be kept")
+	}
+	if body := ctx.bodyString(t); body != `{"id":1}` {
+		t.Errorf("body should be kept, got %s", body)
+	}
+
+	// Events are passed through.
+	ctx = newContext(map[string]string{"Ce-Specversion": "1.0", "Ce-Id": "abc"}, "")
+	ce.Handle(ctx)
+	if ctx.header.Get("Ce-Id") != "abc" {
+		t.Errorf("events should be passed through")
+	}
+}
+
+func TestWrapStructured(t *testing.T) {
+	ce := newCloudEvents(t, `
+kind: CloudEvents
+name: cloudevents
+mode: wrap
+format: structured
+type: com.example.upload
+source: /uploads
+id: "{{index .header \"X-Request-Id\" 0}}"
+`)
+
+	cases := []struct {
+		contentType string
+		body        string
+		key         string
+		value       string
+	}{
+		{"application/json", `{"a":1}`, "data", `{"a":1}`},
+		{"text/plain", `hello`, "data", `"hello"`},
+		{"application/octet-stream", "\x00\x01", "data_base64", `"AAE="`},
+	}
+
+	for _, c := range cases {
+		ctx := newContext(map[string]string{
+			httpheader.KeyContentType: c.contentType,
+			"X-Request-Id":            "req-1",
+		}, c.body)
+
+		if result := ce.Handle(ctx); result != "" {
+			t.Fatalf("unexpected result %s", result)
+		}
+		if ct := ctx.header.Get(httpheader.KeyContentType); ct != structuredContentType {
+			t.Errorf("unexpected content type %s", ct)
+		}
+
+		m := map[string]json.RawMessage{}
+		if err := json.Unmarshal([]byte(ctx.bodyString(t)), &m); err != nil {
+			t.Fatalf("invalid envelope: %v", err)
+		}
+		if string(m["id"]) != `"req-1"` || string(m["datacontenttype"]) != `"`+c.contentType+`"` {
+			t.Errorf("unexpected attributes: %v", m)
+		}
+		if string(m[c.key]) != c.value {
+			t.Errorf("%s: expected %s, got %s", c.key, c.value, m[c.key])
+		}
+	}
+}
+
+func TestWrapInvalid(t *testing.T) {
+	ce := newCloudEvents(t, `
+kind: CloudEvents
+name: cloudevents
+mode: wrap
+type: com.example
+source: "{{.jwt.iss}}"
+`)
+
+	ctx := newContext(nil, "")
+	if result := ce.Handle(ctx); result != resultInvalid {
+		t.Errorf("empty source should be invalid, got %s", result)
+	}
+	if ctx.statusCode != http.StatusBadRequest {
+		t.Errorf("unexpected status code %d", ctx.statusCode)
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	ce := newCloudEvents(t, `
+kind: CloudEvents
+name: cloudevents
+mode: unwrap
+`)
+
+	body := `{"specversion":"1.0","type":"t","source":"/s","id":"1",
+"subject":"a b","count":3,"datacontenttype":"text/plain","data":"hello"}`
+	ctx := newContext(map[string]string{httpheader.KeyContentType: structuredContentType}, body)
+	if result := ce.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	h := ctx.header
+	if h.Get("Ce-Type") != "t" || h.Get("Ce-Subject") != "a%20b" || h.Get("Ce-Count") != "3" {
+		t.Errorf("unexpected headers: %v", h.Std())
+	}
+	if h.Get(httpheader.KeyContentType) != "text/plain" {
+		t.Errorf("unexpected content type %s", h.Get(httpheader.KeyContentType))
+	}
+	if body := ctx.bodyString(t); body != "hello" {
+		t.Errorf("unexpected body %s", body)
+	}
+
+	body = `{"specversion":"1.0","type":"t","source":"/s","id":"1","data_base64":"AAE="}`
+	ctx = newContext(map[string]string{httpheader.KeyContentType: structuredContentType}, body)
+	ce.Handle(ctx)
+	if body := ctx.bodyString(t); body != "\x00\x01" {
+		t.Errorf("unexpected body %q", body)
+	}
+
+	// Binary events are validated only.
+	ctx = newContext(map[string]string{
+		"Ce-Specversion": "1.0",
+		"Ce-Type":        "t",
+		"Ce-Source":      "/s",
+		"Ce-Id":          "1",
+	}, "payload")
+	if result := ce.Handle(ctx); result != "" {
+		t.Errorf("unexpected result %s", result)
+	}
+
+	ctx = newContext(map[string]string{httpheader.KeyContentType: structuredContentType},
+		`{"specversion":"1.0","type":"t"}`)
+	if result := ce.Handle(ctx); result != resultInvalid {
+		t.Errorf("event without id should be invalid")
+	}
+
+	ctx = newContext(nil, "payload")
+	if result := ce.Handle(ctx); result != resultInvalid {
+		t.Errorf("non-event should be invalid")
+	}
+}
+
+func TestHeaderValue(t *testing.T) {
+	v := "a b\"c%dé"
+	encoded := encodeHeaderValue(v)
+	if encoded != "a%20b%22c%25d%C3%A9" {
+		t.Errorf("unexpected encoded value %s", encoded)
+	}
+	if decodeHeaderValue(encoded) != v {
+		t.Errorf("decode failed")
+	}
+	if decodeHeaderValue("100%") != "100%" {
+		t.Errorf("invalid encoding should be kept")
+	}
+}
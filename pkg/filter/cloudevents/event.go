@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cloudevents
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+const (
+	specVersion = "1.0"
+
+	// headerPrefix is the prefix of the headers carrying attributes in
+	// the binary content mode.
+	headerPrefix = "Ce-"
+
+	// structuredContentType is the media type of structured events
+	// in the JSON format.
+	structuredContentType = "application/cloudevents+json"
+)
+
+type (
+	// event is a CloudEvent, all attributes are kept in their string
+	// forms as the HTTP binding does.
+	// Reference: https://github.com/cloudevents/spec/blob/v1.0.1/spec.md
+	event struct {
+		attrs map[string]string
+
+		data []byte
+	}
+)
+
+// requiredAttributes are the attributes every event must have.
+var requiredAttributes = []string{"id", "source", "specversion", "type"}
+
+func newEvent() *event {
+	return &event{attrs: map[string]string{"specversion": specVersion}}
+}
+
+// validate checks the required attributes of the event.
+func (e *event) validate() error {
+	for _, name := range requiredAttributes {
+		if e.attrs[name] == "" {
+			return fmt.Errorf("attribute %s is required", name)
+		}
+	}
+	if e.attrs["specversion"] != specVersion {
+		return fmt.Errorf("unsupported specversion %s", e.attrs["specversion"])
+	}
+	return nil
+}
+
+// isStructured reports whether the content type is a structured event.
+func isStructured(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == structuredContentType
+}
+
+// isJSON reports whether the content type is JSON, an empty content
+// type is treated as JSON by the specification.
+func isJSON(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || mediaType == "text/json" ||
+		strings.HasSuffix(mediaType, "+json")
+}
+
+// isText reports whether the content type is a textual one.
+func isText(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mediaType, "text/") || mediaType == "application/xml"
+}
+
+// marshalStructured encodes the event in the structured content mode,
+// the data is embedded as JSON if it is valid JSON, as a string if it
+// is text, and as data_base64 otherwise.
+func (e *event) marshalStructured() ([]byte, error) {
+	m := make(map[string]interface{}, len(e.attrs)+1)
+	for k, v := range e.attrs {
+		m[k] = v
+	}
+
+	contentType := e.attrs["datacontenttype"]
+	switch {
+	case len(e.data) == 0:
+	case isJSON(contentType) && json.Valid(e.data):
+		m["data"] = json.RawMessage(e.data)
+	case isText(contentType):
+		m["data"] = string(e.data)
+	default:
+		m["data_base64"] = base64.StdEncoding.EncodeToString(e.data)
+	}
+
+	return json.Marshal(m)
+}
+
+// unmarshalStructured decodes an event in the structured content mode.
+func unmarshalStructured(body []byte) (*event, error) {
+	m := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("invalid structured event: %v", err)
+	}
+
+	e := &event{attrs: map[string]string{}}
+	for k, v := range m {
+		if k == "data" || k == "data_base64" {
+			continue
+		}
+
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			e.attrs[k] = s
+			continue
+		}
+		// Extensions may be integers or booleans, keep their JSON
+		// representations, which are also their canonical strings.
+		if string(v) != "null" {
+			e.attrs[k] = string(v)
+		}
+	}
+
+	if raw, ok := m["data_base64"]; ok {
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, fmt.Errorf("invalid data_base64: %v", err)
+		}
+		data, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data_base64: %v", err)
+		}
+		e.data = data
+	} else if raw, ok := m["data"]; ok && string(raw) != "null" {
+		var s string
+		if !isJSON(e.attrs["datacontenttype"]) && json.Unmarshal(raw, &s) == nil {
+			e.data = []byte(s)
+		} else {
+			e.data = raw
+		}
+	}
+
+	return e, nil
+}
+
+// binaryHeaders returns the headers carrying the attributes in the
+// binary content mode, datacontenttype is carried by Content-Type.
+func (e *event) binaryHeaders() map[string]string {
+	headers := make(map[string]string, len(e.attrs))
+	for k, v := range e.attrs {
+		if k == "datacontenttype" {
+			continue
+		}
+		headers[headerPrefix+k] = encodeHeaderValue(v)
+	}
+	return headers
+}
+
+// encodeHeaderValue percent-encodes the characters which are not
+// printable ASCII, along with the space, '"' and '%'.
+// Reference: https://github.com/cloudevents/spec/blob/v1.0.1/http-protocol-binding.md#3132-http-header-values
+func encodeHeaderValue(v string) string {
+	var sb strings.Builder
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c <= ' ' || c >= 0x7f || c == '"' || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
+
+// decodeHeaderValue decodes the percent-encoded header value, the value
+// is returned as it is if it is not well encoded.
+func decodeHeaderValue(v string) string {
+	if !strings.Contains(v, "%") {
+		return v
+	}
+	s, err := url.PathUnescape(v)
+	if err != nil {
+		return v
+	}
+	return s
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package encodingadaptor
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+const compressChunkSize = 32 * 1024
+
+type (
+	// gzipReader compresses the source lazily when it is read.
+	gzipReader struct {
+		src  io.Reader
+		buff bytes.Buffer
+		gw   *gzip.Writer
+		err  error
+	}
+
+	// gunzipReader decompresses the source lazily when it is read, so
+	// that the error of an invalid header is returned by Read.
+	gunzipReader struct {
+		src io.Reader
+		zr  *gzip.Reader
+		err error
+	}
+)
+
+// convert converts the body from one encoding to another, both of them
+// must be identity or gzip.
+func convert(body io.Reader, from, to string) io.Reader {
+	if from == encodingGzip {
+		body = &gunzipReader{src: body}
+	}
+	if to == encodingGzip {
+		body = newGzipReader(body)
+	}
+	return body
+}
+
+func newGzipReader(src io.Reader) *gzipReader {
+	r := &gzipReader{src: src}
+	r.gw = gzip.NewWriter(&r.buff)
+	return r
+}
+
+func (r *gzipReader) Read(p []byte) (int, error) {
+	for r.buff.Len() == 0 && r.err == nil {
+		r.pull()
+	}
+
+	if r.buff.Len() == 0 {
+		return 0, r.err
+	}
+
+	return r.buff.Read(p)
+}
+
+func (r *gzipReader) pull() {
+	_, err := io.CopyN(r.gw, r.src, compressChunkSize)
+	if err == nil {
+		return
+	}
+
+	if err == io.EOF {
+		err = r.gw.Close()
+		if err == nil {
+			err = io.EOF
+		}
+	}
+	r.err = err
+}
+
+func (r *gunzipReader) Read(p []byte) (int, error) {
+	if r.zr == nil && r.err == nil {
+		r.zr, r.err = gzip.NewReader(r.src)
+	}
+
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	return r.zr.Read(p)
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package encodingadaptor
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/gzip"
+)
+
+func gzipString(s string) []byte {
+	buff := &bytes.Buffer{}
+	gw := gzip.NewWriter(buff)
+	gw.Write([]byte(s))
+	gw.Close()
+	return buff.Bytes()
+}
+
+func gunzipBytes(t *testing.T, p []byte) string {
+	zr, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		t.Fatalf("new gzip reader failed: %v", err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("read gzip failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestConvert(t *testing.T) {
+	text := strings.Repeat("easegress ", 10000)
+
+	data, err := ioutil.ReadAll(convert(strings.NewReader(text), encodingIdentity, encodingGzip))
+	if err != nil {
+		t.Fatalf("compress failed: %v", err)
+	}
+	if gunzipBytes(t, data) != text {
+		t.Errorf("compressed data mismatch")
+	}
+
+	data, err = ioutil.ReadAll(convert(bytes.NewReader(gzipString(text)), encodingGzip, encodingIdentity))
+	if err != nil {
+		t.Fatalf("decompress failed: %v", err)
+	}
+	if string(data) != text {
+		t.Errorf("decompressed data mismatch")
+	}
+
+	data, err = ioutil.ReadAll(convert(strings.NewReader(""), encodingIdentity, encodingGzip))
+	if err != nil || gunzipBytes(t, data) != "" {
+		t.Errorf("compress empty body failed: %v", err)
+	}
+
+	_, err = ioutil.ReadAll(convert(strings.NewReader("not gzip"), encodingGzip, encodingIdentity))
+	if err == nil {
+		t.Errorf("decompress invalid data should fail")
+	}
+}
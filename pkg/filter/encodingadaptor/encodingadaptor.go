@@ -0,0 +1,255 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package encodingadaptor
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of EncodingAdaptor.
+	Kind = "EncodingAdaptor"
+
+	resultUnsupportedEncoding = "unsupportedEncoding"
+
+	encodingIdentity = "identity"
+	encodingGzip     = "gzip"
+	encodingAuto     = "auto"
+)
+
+var results = []string{resultUnsupportedEncoding}
+
+func init() {
+	httppipeline.Register(&EncodingAdaptor{})
+}
+
+type (
+	// EncodingAdaptor adapts the content encoding between clients and
+	// upstreams independently.
+	EncodingAdaptor struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+	}
+
+	// Spec describes the EncodingAdaptor.
+	Spec struct {
+		Request  *RequestSpec  `yaml:"request,omitempty" jsonschema:"omitempty"`
+		Response *ResponseSpec `yaml:"response,omitempty" jsonschema:"omitempty"`
+	}
+
+	// RequestSpec describes the encoding of request bodies.
+	RequestSpec struct {
+		// UpstreamEncoding is the encoding of request bodies sent to
+		// upstreams, empty means keeping the encoding of clients.
+		UpstreamEncoding string `yaml:"upstreamEncoding" jsonschema:"omitempty,enum=,enum=identity,enum=gzip"`
+	}
+
+	// ResponseSpec describes the encoding of response bodies.
+	ResponseSpec struct {
+		// UpstreamAcceptEncoding replaces the Accept-Encoding sent to
+		// upstreams, empty means keeping the one of clients.
+		UpstreamAcceptEncoding string `yaml:"upstreamAcceptEncoding" jsonschema:"omitempty,enum=,enum=identity,enum=gzip"`
+		// ClientEncoding is the encoding of response bodies sent to
+		// clients, auto means gzip if clients accept it, otherwise
+		// identity, empty means keeping the encoding of upstreams.
+		ClientEncoding string `yaml:"clientEncoding" jsonschema:"omitempty,enum=,enum=auto,enum=identity,enum=gzip"`
+		// MinLength is the minimum length of response bodies to compress.
+		MinLength uint32 `yaml:"minLength" jsonschema:"omitempty"`
+	}
+)
+
+// Kind returns the kind of EncodingAdaptor.
+func (ea *EncodingAdaptor) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of EncodingAdaptor.
+func (ea *EncodingAdaptor) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of EncodingAdaptor.
+func (ea *EncodingAdaptor) Description() string {
+	return "EncodingAdaptor adapts the content encoding between clients and upstreams."
+}
+
+// Results returns the results of EncodingAdaptor.
+func (ea *EncodingAdaptor) Results() []string {
+	return results
+}
+
+// Init initializes EncodingAdaptor.
+func (ea *EncodingAdaptor) Init(filterSpec *httppipeline.FilterSpec) {
+	ea.filterSpec, ea.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+}
+
+// Inherit inherits previous generation of EncodingAdaptor.
+func (ea *EncodingAdaptor) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ea.Init(filterSpec)
+}
+
+// Handle adapts the encoding of the request, calls the next handler and
+// then adapts the encoding of the response.
+func (ea *EncodingAdaptor) Handle(ctx context.HTTPContext) string {
+	if result := ea.handleRequest(ctx); result != "" {
+		return ctx.CallNextHandler(result)
+	}
+
+	r := ctx.Request()
+	clientAcceptEncodings := r.Header().GetAll(httpheader.KeyAcceptEncoding)
+	if ea.spec.Response != nil && ea.spec.Response.UpstreamAcceptEncoding != "" {
+		r.Header().Set(httpheader.KeyAcceptEncoding, ea.spec.Response.UpstreamAcceptEncoding)
+	}
+
+	result := ctx.CallNextHandler("")
+
+	if ea.spec.Response != nil {
+		ea.handleResponse(ctx, clientAcceptEncodings)
+	}
+
+	return result
+}
+
+// contentEncoding returns the content encoding in lower case, identity
+// is returned if the header is empty.
+func contentEncoding(h *httpheader.HTTPHeader) string {
+	ce := strings.ToLower(strings.TrimSpace(h.Get(httpheader.KeyContentEncoding)))
+	if ce == "" {
+		return encodingIdentity
+	}
+	return ce
+}
+
+func supported(encoding string) bool {
+	return encoding == encodingIdentity || encoding == encodingGzip
+}
+
+func (ea *EncodingAdaptor) handleRequest(ctx context.HTTPContext) string {
+	if ea.spec.Request == nil || ea.spec.Request.UpstreamEncoding == "" {
+		return ""
+	}
+
+	r := ctx.Request()
+	from, to := contentEncoding(r.Header()), ea.spec.Request.UpstreamEncoding
+	if from == to {
+		return ""
+	}
+
+	if !supported(from) {
+		ctx.Response().SetStatusCode(http.StatusUnsupportedMediaType)
+		ctx.AddTag("encodingAdaptor: unsupported request encoding " + from)
+		return resultUnsupportedEncoding
+	}
+
+	if r.Body() == nil {
+		return ""
+	}
+
+	r.SetBody(convert(r.Body(), from, to))
+	r.Header().Del(httpheader.KeyContentLength)
+	setContentEncoding(r.Header(), to)
+
+	return ""
+}
+
+func (ea *EncodingAdaptor) handleResponse(ctx context.HTTPContext, clientAcceptEncodings []string) {
+	spec := ea.spec.Response
+	if spec.ClientEncoding == "" {
+		return
+	}
+
+	w := ctx.Response()
+	if spec.ClientEncoding == encodingAuto {
+		w.Header().Add(httpheader.KeyVary, httpheader.KeyAcceptEncoding)
+	}
+
+	if w.Body() == nil {
+		return
+	}
+
+	from, to := contentEncoding(w.Header()), spec.ClientEncoding
+	if to == encodingAuto {
+		to = encodingIdentity
+		if acceptGzip(clientAcceptEncodings) {
+			to = encodingGzip
+		}
+	}
+
+	if from == to {
+		return
+	}
+
+	// NOTE: There's nothing to do with encodings we can't decode, just
+	// pass them through.
+	if !supported(from) {
+		ctx.AddTag("encodingAdaptor: unsupported response encoding " + from)
+		return
+	}
+
+	if to == encodingGzip && spec.MinLength > 0 {
+		cl, err := strconv.ParseInt(w.Header().Get(httpheader.KeyContentLength), 10, 64)
+		if err == nil && cl < int64(spec.MinLength) {
+			return
+		}
+	}
+
+	w.SetBody(convert(w.Body(), from, to))
+	w.Header().Del(httpheader.KeyContentLength)
+	setContentEncoding(w.Header(), to)
+}
+
+func setContentEncoding(h *httpheader.HTTPHeader, encoding string) {
+	if encoding == encodingIdentity {
+		h.Del(httpheader.KeyContentEncoding)
+	} else {
+		h.Set(httpheader.KeyContentEncoding, encoding)
+	}
+}
+
+// acceptGzip reports whether clients accept gzip, qvalue is not parsed
+// except for explicit rejection of 'gzip;q=0'.
+func acceptGzip(acceptEncodings []string) bool {
+	for _, ae := range acceptEncodings {
+		for _, coding := range strings.Split(ae, ",") {
+			coding = strings.ToLower(strings.ReplaceAll(coding, " ", ""))
+			if strings.HasSuffix(coding, ";q=0") || strings.HasSuffix(coding, ";q=0.0") {
+				continue
+			}
+			name := strings.SplitN(coding, ";", 2)[0]
+			if name == encodingGzip || name == "*" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Status returns status.
+func (ea *EncodingAdaptor) Status() interface{} {
+	return nil
+}
+
+// Close closes EncodingAdaptor.
+func (ea *EncodingAdaptor) Close() {}
@@ -0,0 +1,200 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package encodingadaptor
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func createEncodingAdaptor(yamlSpec string) *EncodingAdaptor {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		panic(err)
+	}
+	ea := &EncodingAdaptor{}
+	ea.Init(spec)
+	return ea
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	reqHeader, respHeader http.Header
+	reqBody, respBody     io.Reader
+	upstreamAccept        string
+	statusCode            int
+}
+
+func newMockedContext(upstreamResp func(c *mockedContext)) *mockedContext {
+	c := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		reqHeader:         http.Header{},
+		respHeader:        http.Header{},
+	}
+
+	c.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(c.reqHeader) }
+	c.MockedRequest.MockedBody = func() io.Reader { return c.reqBody }
+	c.MockedRequest.MockedSetBody = func(body io.Reader) { c.reqBody = body }
+	c.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return httpheader.New(c.respHeader) }
+	c.MockedResponse.MockedBody = func() io.Reader { return c.respBody }
+	c.MockedResponse.MockedSetBody = func(body io.Reader) { c.respBody = body }
+	c.MockedResponse.MockedSetStatusCode = func(code int) { c.statusCode = code }
+	c.MockedCallNextHandler = func(lastResult string) string {
+		c.upstreamAccept = c.reqHeader.Get(httpheader.KeyAcceptEncoding)
+		if lastResult == "" && upstreamResp != nil {
+			upstreamResp(c)
+		}
+		return lastResult
+	}
+
+	return c
+}
+
+func TestRequestEncoding(t *testing.T) {
+	ea := createEncodingAdaptor(`
+kind: EncodingAdaptor
+name: ea
+request:
+  upstreamEncoding: identity
+`)
+
+	ctx := newMockedContext(nil)
+	ctx.reqHeader.Set(httpheader.KeyContentEncoding, "gzip")
+	ctx.reqHeader.Set(httpheader.KeyContentLength, "100")
+	ctx.reqBody = bytes.NewReader(gzipString("hello"))
+
+	if result := ea.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %q", result)
+	}
+	if ctx.reqHeader.Get(httpheader.KeyContentEncoding) != "" || ctx.reqHeader.Get(httpheader.KeyContentLength) != "" {
+		t.Errorf("content encoding and length should be removed")
+	}
+	if data, _ := ioutil.ReadAll(ctx.reqBody); string(data) != "hello" {
+		t.Errorf("request body should be decompressed, but got %q", data)
+	}
+
+	ctx = newMockedContext(nil)
+	ctx.reqHeader.Set(httpheader.KeyContentEncoding, "br")
+	ctx.reqBody = bytes.NewReader([]byte("data"))
+	if result := ea.Handle(ctx); result != resultUnsupportedEncoding {
+		t.Errorf("result should be %s, but got %q", resultUnsupportedEncoding, result)
+	}
+	if ctx.statusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("status code should be 415, but got %d", ctx.statusCode)
+	}
+
+	ea = createEncodingAdaptor(`
+kind: EncodingAdaptor
+name: ea
+request:
+  upstreamEncoding: gzip
+`)
+	ctx = newMockedContext(nil)
+	ctx.reqBody = bytes.NewReader([]byte("hello"))
+	ea.Handle(ctx)
+	if ctx.reqHeader.Get(httpheader.KeyContentEncoding) != "gzip" {
+		t.Errorf("content encoding should be gzip")
+	}
+	data, _ := ioutil.ReadAll(ctx.reqBody)
+	if gunzipBytes(t, data) != "hello" {
+		t.Errorf("request body should be compressed")
+	}
+}
+
+func TestResponseEncoding(t *testing.T) {
+	ea := createEncodingAdaptor(`
+kind: EncodingAdaptor
+name: ea
+response:
+  upstreamAcceptEncoding: identity
+  clientEncoding: auto
+  minLength: 3
+`)
+
+	upstream := func(body string) func(c *mockedContext) {
+		return func(c *mockedContext) {
+			c.respBody = bytes.NewReader([]byte(body))
+		}
+	}
+
+	ctx := newMockedContext(upstream("hello"))
+	ctx.reqHeader.Set(httpheader.KeyAcceptEncoding, "br, gzip;q=0.8")
+	ea.Handle(ctx)
+	if ctx.upstreamAccept != "identity" {
+		t.Errorf("upstream accept encoding should be identity, but got %q", ctx.upstreamAccept)
+	}
+	if ctx.respHeader.Get(httpheader.KeyContentEncoding) != "gzip" {
+		t.Errorf("response should be compressed")
+	}
+	if ctx.respHeader.Get(httpheader.KeyVary) != httpheader.KeyAcceptEncoding {
+		t.Errorf("vary should be set")
+	}
+	data, _ := ioutil.ReadAll(ctx.respBody)
+	if gunzipBytes(t, data) != "hello" {
+		t.Errorf("response body should be compressed")
+	}
+
+	ctx = newMockedContext(upstream("hello"))
+	ctx.reqHeader.Set(httpheader.KeyAcceptEncoding, "gzip;q=0")
+	ea.Handle(ctx)
+	if ctx.respHeader.Get(httpheader.KeyContentEncoding) != "" {
+		t.Errorf("response should not be compressed")
+	}
+
+	// upstream ignores the accept encoding and responses gzip.
+	ctx = newMockedContext(func(c *mockedContext) {
+		c.respHeader.Set(httpheader.KeyContentEncoding, "gzip")
+		c.respBody = bytes.NewReader(gzipString("hello"))
+	})
+	ea.Handle(ctx)
+	if ctx.respHeader.Get(httpheader.KeyContentEncoding) != "" {
+		t.Errorf("response should be decompressed")
+	}
+	if data, _ := ioutil.ReadAll(ctx.respBody); string(data) != "hello" {
+		t.Errorf("response body should be decompressed, but got %q", data)
+	}
+
+	// short response is not compressed.
+	ctx = newMockedContext(func(c *mockedContext) {
+		c.respHeader.Set(httpheader.KeyContentLength, "2")
+		c.respBody = bytes.NewReader([]byte("hi"))
+	})
+	ctx.reqHeader.Set(httpheader.KeyAcceptEncoding, "gzip")
+	ea.Handle(ctx)
+	if ctx.respHeader.Get(httpheader.KeyContentEncoding) != "" {
+		t.Errorf("short response should not be compressed")
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etag
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of ETag.
+	Kind = "ETag"
+
+	defaultMaxBodySize = 1024 * 1024
+
+	// hashLength is the length of the hex encoded hash in ETags.
+	hashLength = 32
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&ETag{})
+}
+
+type (
+	// ETag generates ETags for responses lacking them, and handles the
+	// conditional requests with If-None-Match.
+	ETag struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+	}
+
+	// Spec describes the ETag.
+	Spec struct {
+		// Weak generates weak ETags instead of strong ones.
+		Weak bool `yaml:"weak" jsonschema:"omitempty"`
+		// MaxBodySize is the max size of response bodies to generate
+		// ETags, the default is 1MiB.
+		MaxBodySize uint32 `yaml:"maxBodySize" jsonschema:"omitempty"`
+	}
+
+	// bodyWithCloser reads from the reader, and closes the closer.
+	bodyWithCloser struct {
+		io.Reader
+		closer io.Closer
+	}
+)
+
+// Kind returns the kind of ETag.
+func (e *ETag) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ETag.
+func (e *ETag) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of ETag.
+func (e *ETag) Description() string {
+	return "ETag generates ETags for responses and handles conditional requests."
+}
+
+// Results returns the results of ETag.
+func (e *ETag) Results() []string {
+	return results
+}
+
+// Init initializes ETag.
+func (e *ETag) Init(filterSpec *httppipeline.FilterSpec) {
+	e.filterSpec, e.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+}
+
+// Inherit inherits previous generation of ETag.
+func (e *ETag) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	e.Init(filterSpec)
+}
+
+// Handle calls the next handler, and then generates the ETag of the
+// response and handles the conditional request.
+func (e *ETag) Handle(ctx context.HTTPContext) string {
+	result := ctx.CallNextHandler("")
+	if result == "" {
+		e.handle(ctx)
+	}
+	return result
+}
+
+func (e *ETag) handle(ctx context.HTTPContext) {
+	r, w := ctx.Request(), ctx.Response()
+	if r.Method() != http.MethodGet && r.Method() != http.MethodHead {
+		return
+	}
+	if w.StatusCode() != http.StatusOK {
+		return
+	}
+
+	etag := w.Header().Get(httpheader.KeyETag)
+	if etag == "" {
+		etag = e.generate(ctx)
+		if etag == "" {
+			return
+		}
+		w.Header().Set(httpheader.KeyETag, etag)
+	}
+
+	if !matchAny(r.Header().GetAll(httpheader.KeyIfNoneMatch), etag) {
+		return
+	}
+
+	// Reference: https://tools.ietf.org/html/rfc7232#section-4.1
+	w.SetStatusCode(http.StatusNotModified)
+	w.Header().Del(httpheader.KeyContentLength)
+	w.Header().Del(httpheader.KeyContentType)
+	if closer, ok := w.Body().(io.Closer); ok {
+		w.SetBody(&bodyWithCloser{Reader: http.NoBody, closer: closer})
+	} else {
+		w.SetBody(nil)
+	}
+	ctx.AddTag("etag: not modified")
+}
+
+// generate reads the response body to generate the ETag, the body is
+// restored for the following handlers. It returns an empty string if
+// the body is too large or failed to read.
+func (e *ETag) generate(ctx context.HTTPContext) string {
+	w := ctx.Response()
+
+	maxBodySize := int64(e.spec.MaxBodySize)
+	if maxBodySize == 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+
+	body := w.Body()
+	if body == nil {
+		body = http.NoBody
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	closer, _ := body.(io.Closer)
+	if err != nil {
+		logger.Warnf("%s: read response body failed: %v", e.filterSpec.Name(), err)
+		// NOTE: The body is not usable any more, but it must be closed.
+		w.SetBody(&bodyWithCloser{Reader: bytes.NewReader(data), closer: closer})
+		return ""
+	}
+
+	if int64(len(data)) > maxBodySize {
+		w.SetBody(&bodyWithCloser{
+			Reader: io.MultiReader(bytes.NewReader(data), body),
+			closer: closer,
+		})
+		return ""
+	}
+
+	w.SetBody(&bodyWithCloser{Reader: bytes.NewReader(data), closer: closer})
+
+	sum := sha256.Sum256(data)
+	etag := `"` + hex.EncodeToString(sum[:])[:hashLength] + `"`
+	if e.spec.Weak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// matchAny reports whether any of the If-None-Match values matches the
+// ETag with the weak comparison.
+// Reference: https://tools.ietf.org/html/rfc7232#section-3.2
+func matchAny(values []string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, value := range values {
+		for _, v := range strings.Split(value, ",") {
+			v = strings.TrimSpace(v)
+			if v == "*" || strings.TrimPrefix(v, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Status returns status.
+func (e *ETag) Status() interface{} {
+	return nil
+}
+
+// Close closes ETag.
+func (e *ETag) Close() {}
+
+func (b *bodyWithCloser) Close() error {
+	if b.closer == nil {
+		return nil
+	}
+	return b.closer.Close()
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etag
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newETag(t *testing.T, yamlSpec string) *ETag {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	e := &ETag{}
+	e.Init(spec)
+	return e
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	statusCode int
+	body       io.Reader
+}
+
+func newContext(method, ifNoneMatch, body string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		statusCode:        http.StatusOK,
+		body:              strings.NewReader(body),
+	}
+
+	reqHeader := httpheader.New(http.Header{})
+	if ifNoneMatch != "" {
+		reqHeader.Set(httpheader.KeyIfNoneMatch, ifNoneMatch)
+	}
+	respHeader := httpheader.New(http.Header{})
+	respHeader.Set(httpheader.KeyContentType, "text/plain")
+
+	ctx.MockedRequest.MockedMethod = func() string { return method }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return respHeader }
+	ctx.MockedResponse.MockedStatusCode = func() int { return ctx.statusCode }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedResponse.MockedBody = func() io.Reader { return ctx.body }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { ctx.body = body }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	return ctx
+}
+
+func (ctx *mockedContext) bodyString(t *testing.T) string {
+	if ctx.body == nil {
+		return ""
+	}
+	data, err := ioutil.ReadAll(ctx.body)
+	if err != nil {
+		t.Fatalf("read body failed: %v", err)
+	}
+	return string(data)
+}
+
+func TestGenerate(t *testing.T) {
+	e := newETag(t, `
+kind: ETag
+name: etag
+`)
+
+	ctx := newContext(http.MethodGet, "", "hello")
+	e.Handle(ctx)
+	etag := ctx.Response().Header().Get(httpheader.KeyETag)
+	if len(etag) != hashLength+2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		t.Fatalf("invalid strong etag: %s", etag)
+	}
+	if body := ctx.bodyString(t); body != "hello" {
+		t.Errorf("body should be restored, got %s", body)
+	}
+
+	// The same body gets the same ETag.
+	ctx = newContext(http.MethodGet, "", "hello")
+	e.Handle(ctx)
+	if ctx.Response().Header().Get(httpheader.KeyETag) != etag {
+		t.Errorf("etag should be stable")
+	}
+
+	ctx = newContext(http.MethodGet, "", "world")
+	e.Handle(ctx)
+	if ctx.Response().Header().Get(httpheader.KeyETag) == etag {
+		t.Errorf("etag should differ for different bodies")
+	}
+
+	// Not modified.
+	ctx = newContext(http.MethodGet, `"other", `+etag, "hello")
+	e.Handle(ctx)
+	if ctx.statusCode != http.StatusNotModified {
+		t.Errorf("want status code 304, got %d", ctx.statusCode)
+	}
+	if body := ctx.bodyString(t); body != "" {
+		t.Errorf("body of 304 should be empty, got %s", body)
+	}
+	if ctx.Response().Header().Get(httpheader.KeyContentType) != "" {
+		t.Errorf("content type of 304 should be removed")
+	}
+
+	// Modified.
+	ctx = newContext(http.MethodGet, `"other"`, "hello")
+	e.Handle(ctx)
+	if ctx.statusCode != http.StatusOK {
+		t.Errorf("want status code 200, got %d", ctx.statusCode)
+	}
+
+	// Only GET and HEAD requests are handled.
+	ctx = newContext(http.MethodPost, "", "hello")
+	e.Handle(ctx)
+	if ctx.Response().Header().Get(httpheader.KeyETag) != "" {
+		t.Errorf("etag should not be generated for POST")
+	}
+
+	// Only 200 responses are handled.
+	ctx = newContext(http.MethodGet, "", "hello")
+	ctx.statusCode = http.StatusNotFound
+	e.Handle(ctx)
+	if ctx.Response().Header().Get(httpheader.KeyETag) != "" {
+		t.Errorf("etag should not be generated for 404")
+	}
+}
+
+func TestWeakAndMaxBodySize(t *testing.T) {
+	e := newETag(t, `
+kind: ETag
+name: etag
+weak: true
+maxBodySize: 5
+`)
+
+	ctx := newContext(http.MethodGet, "", "hello")
+	e.Handle(ctx)
+	etag := ctx.Response().Header().Get(httpheader.KeyETag)
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("invalid weak etag: %s", etag)
+	}
+
+	// Weak comparison is used for If-None-Match.
+	ctx = newContext(http.MethodGet, strings.TrimPrefix(etag, "W/"), "hello")
+	e.Handle(ctx)
+	if ctx.statusCode != http.StatusNotModified {
+		t.Errorf("want status code 304, got %d", ctx.statusCode)
+	}
+
+	ctx = newContext(http.MethodGet, "", "hello world")
+	e.Handle(ctx)
+	if ctx.Response().Header().Get(httpheader.KeyETag) != "" {
+		t.Errorf("etag should not be generated for large body")
+	}
+	if body := ctx.bodyString(t); body != "hello world" {
+		t.Errorf("body should be restored, got %s", body)
+	}
+}
+
+func TestUpstreamETag(t *testing.T) {
+	e := newETag(t, `
+kind: ETag
+name: etag
+`)
+
+	ctx := newContext(http.MethodGet, "*", "hello")
+	ctx.Response().Header().Set(httpheader.KeyETag, `"upstream"`)
+	e.Handle(ctx)
+	if ctx.Response().Header().Get(httpheader.KeyETag) != `"upstream"` {
+		t.Errorf("etag of upstream should be kept")
+	}
+	if ctx.statusCode != http.StatusNotModified {
+		t.Errorf("want status code 304, got %d", ctx.statusCode)
+	}
+}
+
+func TestMatchAny(t *testing.T) {
+	cases := []struct {
+		values []string
+		etag   string
+		want   bool
+	}{
+		{nil, `"a"`, false},
+		{[]string{`"a"`}, `"a"`, true},
+		{[]string{`W/"a"`}, `"a"`, true},
+		{[]string{`"a"`}, `W/"a"`, true},
+		{[]string{`"b", "c"`, `"a"`}, `"a"`, true},
+		{[]string{`"b", "c"`}, `"a"`, false},
+		{[]string{"*"}, `"a"`, true},
+	}
+
+	for i, c := range cases {
+		if got := matchAny(c.values, c.etag); got != c.want {
+			t.Errorf("case %d: want %v, got %v", i, c.want, got)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of GraphQL.
+	Kind = "GraphQL"
+
+	resultInvalid  = "invalid"
+	resultRejected = "rejected"
+
+	anonymousOperation = "anonymous"
+	otherOperations    = "others"
+
+	// maxOperationNames limits the number of operation names which have
+	// their own metrics, operations beyond it are counted into 'others'.
+	maxOperationNames = 1000
+)
+
+var results = []string{resultInvalid, resultRejected}
+
+func init() {
+	httppipeline.Register(&GraphQL{})
+}
+
+type (
+	// GraphQL is the filter parses GraphQL requests, enforces limits on
+	// them and records metrics per operation.
+	GraphQL struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		mutex sync.Mutex
+		stats map[string]*httpstat.HTTPStat
+	}
+
+	// Spec describes the GraphQL.
+	Spec struct {
+		MaxDepth             int  `yaml:"maxDepth" jsonschema:"omitempty,minimum=0"`
+		MaxComplexity        int  `yaml:"maxComplexity" jsonschema:"omitempty,minimum=0"`
+		DisableIntrospection bool `yaml:"disableIntrospection" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of GraphQL.
+	Status struct {
+		Operations map[string]*httpstat.Status `yaml:"operations"`
+	}
+
+	request struct {
+		Query         string `json:"query"`
+		OperationName string `json:"operationName"`
+	}
+
+	errorResponse struct {
+		Errors []*errorMessage `json:"errors"`
+	}
+
+	errorMessage struct {
+		Message string `json:"message"`
+	}
+)
+
+// Kind returns the kind of GraphQL.
+func (g *GraphQL) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of GraphQL.
+func (g *GraphQL) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of GraphQL.
+func (g *GraphQL) Description() string {
+	return "GraphQL parses GraphQL requests, enforces depth/complexity limits and records per-operation metrics."
+}
+
+// Results returns the results of GraphQL.
+func (g *GraphQL) Results() []string {
+	return results
+}
+
+// Init initializes GraphQL.
+func (g *GraphQL) Init(filterSpec *httppipeline.FilterSpec) {
+	g.filterSpec, g.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	g.stats = map[string]*httpstat.HTTPStat{}
+}
+
+// Inherit inherits previous generation of GraphQL.
+func (g *GraphQL) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	g.Init(filterSpec)
+
+	prev := previousGeneration.(*GraphQL)
+	prev.mutex.Lock()
+	g.stats = prev.stats
+	prev.mutex.Unlock()
+
+	previousGeneration.Close()
+}
+
+// Handle handles GraphQL requests.
+func (g *GraphQL) Handle(ctx context.HTTPContext) string {
+	result := g.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (g *GraphQL) handle(ctx context.HTTPContext) string {
+	gr, err := readRequest(ctx)
+	if err != nil {
+		g.reject(ctx, http.StatusBadRequest, err)
+		return resultInvalid
+	}
+
+	q, err := parseQuery(gr.Query)
+	if err != nil {
+		g.reject(ctx, http.StatusBadRequest, fmt.Errorf("parse query failed: %v", err))
+		return resultInvalid
+	}
+	op, err := q.operation(gr.OperationName)
+	if err != nil {
+		g.reject(ctx, http.StatusBadRequest, err)
+		return resultInvalid
+	}
+
+	name := op.name
+	if name == "" {
+		name = anonymousOperation
+	}
+	ctx.AddTag(stringtool.Cat("graphql operation: ", op.typ, " ", name))
+	stat := g.getStat(name)
+	ctx.OnFinish(func() {
+		stat.Stat(ctx.StatMetric())
+	})
+
+	a, err := q.analyze(op)
+	if err != nil {
+		g.reject(ctx, http.StatusBadRequest, err)
+		return resultInvalid
+	}
+
+	if g.spec.DisableIntrospection && a.introspection {
+		g.reject(ctx, http.StatusForbidden, fmt.Errorf("introspection is disabled"))
+		return resultRejected
+	}
+	if g.spec.MaxDepth > 0 && a.depth > g.spec.MaxDepth {
+		g.reject(ctx, http.StatusBadRequest,
+			fmt.Errorf("query depth %d exceeds the limit %d", a.depth, g.spec.MaxDepth))
+		return resultRejected
+	}
+	if g.spec.MaxComplexity > 0 && a.complexity > g.spec.MaxComplexity {
+		g.reject(ctx, http.StatusBadRequest,
+			fmt.Errorf("query complexity %d exceeds the limit %d", a.complexity, g.spec.MaxComplexity))
+		return resultRejected
+	}
+
+	return ""
+}
+
+// readRequest reads the GraphQL request from GET query string or POST body,
+// the body is restored so that it could be forwarded to the backend.
+func readRequest(ctx context.HTTPContext) (*request, error) {
+	r := ctx.Request()
+
+	if r.Method() == http.MethodGet {
+		values, err := url.ParseQuery(r.Query())
+		if err != nil {
+			return nil, fmt.Errorf("invalid query string: %v", err)
+		}
+		if values.Get("query") == "" {
+			return nil, fmt.Errorf("query is missing")
+		}
+		return &request{Query: values.Get("query"), OperationName: values.Get("operationName")}, nil
+	}
+
+	if r.Method() != http.MethodPost {
+		return nil, fmt.Errorf("method %s is not allowed", r.Method())
+	}
+
+	body, err := io.ReadAll(r.Body())
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+	r.SetBody(bytes.NewReader(body))
+
+	contentType := r.Header().Get("Content-Type")
+	if strings.HasPrefix(contentType, "application/graphql") {
+		return &request{Query: string(body)}, nil
+	}
+
+	gr := &request{}
+	if err = json.Unmarshal(body, gr); err != nil {
+		return nil, fmt.Errorf("unmarshal body failed: %v", err)
+	}
+	if gr.Query == "" {
+		return nil, fmt.Errorf("query is missing")
+	}
+	return gr, nil
+}
+
+func (g *GraphQL) getStat(name string) *httpstat.HTTPStat {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if stat, ok := g.stats[name]; ok {
+		return stat
+	}
+	if len(g.stats) >= maxOperationNames {
+		name = otherOperations
+		if stat, ok := g.stats[name]; ok {
+			return stat
+		}
+	}
+
+	stat := httpstat.New()
+	g.stats[name] = stat
+	return stat
+}
+
+func (g *GraphQL) reject(ctx context.HTTPContext, code int, err error) {
+	buff, e := json.Marshal(&errorResponse{Errors: []*errorMessage{{Message: err.Error()}}})
+	if e != nil {
+		logger.Errorf("BUG: marshal error response failed: %v", e)
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(code)
+	w.Header().Set("Content-Type", "application/json")
+	w.SetBody(bytes.NewReader(buff))
+	ctx.AddTag(stringtool.Cat("graphql: ", err.Error()))
+}
+
+// Status returns status.
+func (g *GraphQL) Status() interface{} {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	s := &Status{Operations: make(map[string]*httpstat.Status, len(g.stats))}
+	for name, stat := range g.stats {
+		s.Operations[name] = stat.Status()
+	}
+	return s
+}
+
+// Close closes GraphQL.
+func (g *GraphQL) Close() {}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestAnalyze(t *testing.T) {
+	cases := []struct {
+		query         string
+		operationName string
+		depth         int
+		complexity    int
+		introspection bool
+		fail          bool
+	}{
+		{query: `{ me { name } }`, depth: 2, complexity: 2},
+		{query: `query Q($id: ID!) { user(id: $id) { name friends(first: 10) { name } } }`, depth: 3, complexity: 4},
+		{query: `
+			query Q { user(id: "a,b}") { ...F } }
+			fragment F on User { name ... on User { email } }`, depth: 2, complexity: 3},
+		{query: `{ __schema { types { name } } }`, depth: 3, complexity: 3, introspection: true},
+		{query: `query A { a } query B { b { c } }`, operationName: "B", depth: 2, complexity: 2},
+		{query: `query A { a } query B { b }`, fail: true},
+		{query: `{ a { ...F } } fragment F on T { b { ...F } }`, fail: true},
+		{query: `{ a { b }`, fail: true},
+		{query: `{ a { ...Missing } }`, fail: true},
+	}
+
+	for i, c := range cases {
+		q, err := parseQuery(c.query)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("case %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		op, err := q.operation(c.operationName)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("case %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		a, err := q.analyze(op)
+		if err != nil {
+			if !c.fail {
+				t.Errorf("case %d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if c.fail {
+			t.Errorf("case %d: should fail", i)
+			continue
+		}
+		if a.depth != c.depth || a.complexity != c.complexity || a.introspection != c.introspection {
+			t.Errorf("case %d: unexpected analysis: %+v", i, a)
+		}
+	}
+}
+
+func TestHandle(t *testing.T) {
+	const yamlSpec = `
+kind: GraphQL
+name: graphql
+maxDepth: 2
+disableIntrospection: true
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, _ := httppipeline.NewFilterSpec(rawSpec, nil)
+	g := &GraphQL{}
+	g.Init(spec)
+
+	handle := func(body string) string {
+		ctx := &contexttest.MockedHTTPContext{}
+		var reader io.Reader = strings.NewReader(body)
+		header := httpheader.New(http.Header{})
+		ctx.MockedRequest.MockedMethod = func() string { return http.MethodPost }
+		ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+		ctx.MockedRequest.MockedBody = func() io.Reader { return reader }
+		ctx.MockedRequest.MockedSetBody = func(r io.Reader) { reader = r }
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+		return g.Handle(ctx)
+	}
+
+	if result := handle(`{"query": "query Me { me { name } }"}`); result != "" {
+		t.Errorf("expected empty result, got %q", result)
+	}
+	if result := handle(`{"query": "{ me { friends { name } } }"}`); result != resultRejected {
+		t.Errorf("expected result %q, got %q", resultRejected, result)
+	}
+	if result := handle(`{"query": "{ __type(name: \"User\") { name } }"}`); result != resultRejected {
+		t.Errorf("expected result %q, got %q", resultRejected, result)
+	}
+	if result := handle(`{"query": "{ me "}`); result != resultInvalid {
+		t.Errorf("expected result %q, got %q", resultInvalid, result)
+	}
+
+	status := g.Status().(*Status)
+	if _, ok := status.Operations["Me"]; !ok {
+		t.Errorf("operation Me should have metrics")
+	}
+}
@@ -0,0 +1,510 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package graphql
+
+import (
+	"fmt"
+)
+
+type (
+	// query is the result of parsing a GraphQL document, only the
+	// information needed by the filter is kept.
+	query struct {
+		operations []*operationDef
+		fragments  map[string]*selectionSet
+	}
+
+	operationDef struct {
+		typ          string
+		name         string
+		selectionSet *selectionSet
+	}
+
+	selectionSet struct {
+		selections []*selection
+	}
+
+	selection struct {
+		// field is the name of the field, empty for fragments.
+		field string
+		// spread is the name of the fragment for fragment spreads.
+		spread       string
+		selectionSet *selectionSet
+	}
+
+	tokenKind int
+
+	token struct {
+		kind  tokenKind
+		value string
+		pos   int
+	}
+
+	lexer struct {
+		src string
+		pos int
+	}
+
+	parser struct {
+		lex *lexer
+		tok token
+	}
+)
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenPunct
+	tokenValue
+)
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' && l.src[l.pos] != '\r' {
+				l.pos++
+			}
+		default:
+			return l.scan()
+		}
+	}
+	return token{kind: tokenEOF, pos: l.pos}, nil
+}
+
+func isNameStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isNameContinue(c byte) bool {
+	return isNameStart(c) || (c >= '0' && c <= '9')
+}
+
+func (l *lexer) scan() (token, error) {
+	start := l.pos
+	c := l.src[l.pos]
+
+	switch {
+	case isNameStart(c):
+		for l.pos < len(l.src) && isNameContinue(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokenName, value: l.src[start:l.pos], pos: start}, nil
+
+	case c == '-' || (c >= '0' && c <= '9'):
+		l.pos++
+		for l.pos < len(l.src) {
+			c = l.src[l.pos]
+			if !isNameContinue(c) && c != '.' && c != '-' && c != '+' {
+				break
+			}
+			l.pos++
+		}
+		return token{kind: tokenValue, value: l.src[start:l.pos], pos: start}, nil
+
+	case c == '"':
+		if len(l.src)-l.pos >= 3 && l.src[l.pos:l.pos+3] == `"""` {
+			l.pos += 3
+			for l.pos < len(l.src) {
+				if l.src[l.pos] == '\\' && len(l.src)-l.pos >= 4 && l.src[l.pos+1:l.pos+4] == `"""` {
+					l.pos += 4
+					continue
+				}
+				if len(l.src)-l.pos >= 3 && l.src[l.pos:l.pos+3] == `"""` {
+					l.pos += 3
+					return token{kind: tokenValue, value: l.src[start:l.pos], pos: start}, nil
+				}
+				l.pos++
+			}
+			return token{}, fmt.Errorf("unterminated block string at %d", start)
+		}
+
+		l.pos++
+		for l.pos < len(l.src) {
+			switch l.src[l.pos] {
+			case '\\':
+				l.pos += 2
+				continue
+			case '"':
+				l.pos++
+				return token{kind: tokenValue, value: l.src[start:l.pos], pos: start}, nil
+			case '\n', '\r':
+				return token{}, fmt.Errorf("unterminated string at %d", start)
+			}
+			l.pos++
+		}
+		return token{}, fmt.Errorf("unterminated string at %d", start)
+
+	case c == '.':
+		if len(l.src)-l.pos >= 3 && l.src[l.pos:l.pos+3] == "..." {
+			l.pos += 3
+			return token{kind: tokenPunct, value: "...", pos: start}, nil
+		}
+		return token{}, fmt.Errorf("unexpected character '.' at %d", start)
+
+	case c == '!' || c == '$' || c == '&' || c == '(' || c == ')' || c == ':' || c == '=' ||
+		c == '@' || c == '[' || c == ']' || c == '{' || c == '|' || c == '}':
+		l.pos++
+		return token{kind: tokenPunct, value: string(c), pos: start}, nil
+	}
+
+	return token{}, fmt.Errorf("unexpected character %q at %d", c, start)
+}
+
+// parseQuery parses a GraphQL executable document.
+func parseQuery(src string) (*query, error) {
+	p := &parser{lex: &lexer{src: src}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	q := &query{fragments: map[string]*selectionSet{}}
+	for p.tok.kind != tokenEOF {
+		if p.is(tokenPunct, "{") {
+			ss, err := p.parseSelectionSet()
+			if err != nil {
+				return nil, err
+			}
+			q.operations = append(q.operations, &operationDef{typ: "query", selectionSet: ss})
+			continue
+		}
+
+		if p.tok.kind != tokenName {
+			return nil, p.unexpected()
+		}
+
+		switch p.tok.value {
+		case "query", "mutation", "subscription":
+			op, err := p.parseOperation()
+			if err != nil {
+				return nil, err
+			}
+			q.operations = append(q.operations, op)
+		case "fragment":
+			name, ss, err := p.parseFragment()
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := q.fragments[name]; ok {
+				return nil, fmt.Errorf("duplicated fragment %s", name)
+			}
+			q.fragments[name] = ss
+		default:
+			return nil, p.unexpected()
+		}
+	}
+
+	if len(q.operations) == 0 {
+		return nil, fmt.Errorf("no operation found")
+	}
+	return q, nil
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) is(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+func (p *parser) unexpected() error {
+	if p.tok.kind == tokenEOF {
+		return fmt.Errorf("unexpected end of query")
+	}
+	return fmt.Errorf("unexpected %q at %d", p.tok.value, p.tok.pos)
+}
+
+func (p *parser) expect(kind tokenKind, value string) error {
+	if !p.is(kind, value) {
+		return p.unexpected()
+	}
+	return p.advance()
+}
+
+func (p *parser) expectName() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.unexpected()
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+// skipBalanced skips a balanced group starting with open, it is used to
+// skip arguments, variable definitions and values which are not needed.
+func (p *parser) skipBalanced(open, close string) error {
+	depth := 0
+	for {
+		switch {
+		case p.tok.kind == tokenEOF:
+			return p.unexpected()
+		case p.is(tokenPunct, open):
+			depth++
+		case p.is(tokenPunct, close):
+			depth--
+		}
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if depth == 0 {
+			return nil
+		}
+	}
+}
+
+func (p *parser) skipDirectives() error {
+	for p.is(tokenPunct, "@") {
+		if err := p.advance(); err != nil {
+			return err
+		}
+		if _, err := p.expectName(); err != nil {
+			return err
+		}
+		if p.is(tokenPunct, "(") {
+			if err := p.skipBalanced("(", ")"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) parseOperation() (*operationDef, error) {
+	op := &operationDef{typ: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind == tokenName {
+		op.name = p.tok.value
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+	}
+	if p.is(tokenPunct, "(") {
+		if err := p.skipBalanced("(", ")"); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.skipDirectives(); err != nil {
+		return nil, err
+	}
+
+	ss, err := p.parseSelectionSet()
+	if err != nil {
+		return nil, err
+	}
+	op.selectionSet = ss
+	return op, nil
+}
+
+func (p *parser) parseFragment() (string, *selectionSet, error) {
+	if err := p.advance(); err != nil {
+		return "", nil, err
+	}
+	name, err := p.expectName()
+	if err != nil {
+		return "", nil, err
+	}
+	if err = p.expect(tokenName, "on"); err != nil {
+		return "", nil, err
+	}
+	if _, err = p.expectName(); err != nil {
+		return "", nil, err
+	}
+	if err = p.skipDirectives(); err != nil {
+		return "", nil, err
+	}
+	ss, err := p.parseSelectionSet()
+	return name, ss, err
+}
+
+func (p *parser) parseSelectionSet() (*selectionSet, error) {
+	if err := p.expect(tokenPunct, "{"); err != nil {
+		return nil, err
+	}
+
+	ss := &selectionSet{}
+	for !p.is(tokenPunct, "}") {
+		sel, err := p.parseSelection()
+		if err != nil {
+			return nil, err
+		}
+		ss.selections = append(ss.selections, sel)
+	}
+	if len(ss.selections) == 0 {
+		return nil, fmt.Errorf("empty selection set at %d", p.tok.pos)
+	}
+
+	return ss, p.advance()
+}
+
+func (p *parser) parseSelection() (*selection, error) {
+	sel := &selection{}
+
+	if p.is(tokenPunct, "...") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+
+		// fragment spread
+		if p.tok.kind == tokenName && p.tok.value != "on" {
+			sel.spread = p.tok.value
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			return sel, p.skipDirectives()
+		}
+
+		// inline fragment
+		if p.is(tokenName, "on") {
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			if _, err := p.expectName(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.skipDirectives(); err != nil {
+			return nil, err
+		}
+		ss, err := p.parseSelectionSet()
+		if err != nil {
+			return nil, err
+		}
+		sel.selectionSet = ss
+		return sel, nil
+	}
+
+	name, err := p.expectName()
+	if err != nil {
+		return nil, err
+	}
+	// alias
+	if p.is(tokenPunct, ":") {
+		if err = p.advance(); err != nil {
+			return nil, err
+		}
+		if name, err = p.expectName(); err != nil {
+			return nil, err
+		}
+	}
+	sel.field = name
+
+	if p.is(tokenPunct, "(") {
+		if err = p.skipBalanced("(", ")"); err != nil {
+			return nil, err
+		}
+	}
+	if err = p.skipDirectives(); err != nil {
+		return nil, err
+	}
+	if p.is(tokenPunct, "{") {
+		if sel.selectionSet, err = p.parseSelectionSet(); err != nil {
+			return nil, err
+		}
+	}
+
+	return sel, nil
+}
+
+// operation returns the operation to execute, name is the operationName
+// of the request, it could be empty if there is only one operation.
+func (q *query) operation(name string) (*operationDef, error) {
+	if name == "" {
+		if len(q.operations) > 1 {
+			return nil, fmt.Errorf("operationName is required for multiple operations")
+		}
+		return q.operations[0], nil
+	}
+
+	for _, op := range q.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("operation %s not found", name)
+}
+
+// analysis is the result of analyzing an operation.
+type analysis struct {
+	depth         int
+	complexity    int
+	introspection bool
+}
+
+// analyze calculates the depth and complexity of the operation, and checks
+// whether it contains introspection fields. Fragments are expanded.
+func (q *query) analyze(op *operationDef) (*analysis, error) {
+	a := &analysis{}
+	visiting := map[string]bool{}
+
+	var walk func(ss *selectionSet, depth int) error
+	walk = func(ss *selectionSet, depth int) error {
+		for _, sel := range ss.selections {
+			switch {
+			case sel.spread != "":
+				frag, ok := q.fragments[sel.spread]
+				if !ok {
+					return fmt.Errorf("fragment %s not found", sel.spread)
+				}
+				if visiting[sel.spread] {
+					return fmt.Errorf("fragment %s forms a cycle", sel.spread)
+				}
+				visiting[sel.spread] = true
+				if err := walk(frag, depth); err != nil {
+					return err
+				}
+				visiting[sel.spread] = false
+
+			case sel.field == "":
+				if err := walk(sel.selectionSet, depth); err != nil {
+					return err
+				}
+
+			default:
+				a.complexity++
+				if depth+1 > a.depth {
+					a.depth = depth + 1
+				}
+				if sel.field == "__schema" || sel.field == "__type" {
+					a.introspection = true
+				}
+				if sel.selectionSet != nil {
+					if err := walk(sel.selectionSet, depth+1); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(op.selectionSet, 0); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,307 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reference: https://tools.ietf.org/html/rfc3507
+
+const (
+	methodReqMod  = "REQMOD"
+	methodRespMod = "RESPMOD"
+
+	defaultPort = "1344"
+)
+
+type (
+	// client is an ICAP client, it uses a new connection for every
+	// transaction.
+	client struct {
+		url     *url.URL
+		host    string
+		timeout time.Duration
+		preview int
+	}
+
+	// message is an HTTP message encapsulated in ICAP.
+	message struct {
+		req  *http.Request
+		resp *http.Response
+		body []byte
+	}
+
+	// result is the result of an ICAP transaction, both req and resp are
+	// nil if the message is not modified.
+	result struct {
+		req  *http.Request
+		resp *http.Response
+		body []byte
+	}
+
+	section struct {
+		name   string
+		offset int
+	}
+)
+
+func newClient(rawURL string, timeout time.Duration, preview int) (*client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "icap" {
+		return nil, fmt.Errorf("scheme of %s is not icap", rawURL)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+
+	return &client{
+		url:     u,
+		host:    host,
+		timeout: timeout,
+		preview: preview,
+	}, nil
+}
+
+func dumpRequestHeader(req *http.Request) []byte {
+	buf := &bytes.Buffer{}
+	uri := req.URL.RequestURI()
+	fmt.Fprintf(buf, "%s %s HTTP/1.1\r\n", req.Method, uri)
+	fmt.Fprintf(buf, "Host: %s\r\n", req.Host)
+	req.Header.Write(buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+func dumpResponseHeader(resp *http.Response) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "HTTP/1.1 %d %s\r\n", resp.StatusCode, http.StatusText(resp.StatusCode))
+	resp.Header.Write(buf)
+	buf.WriteString("\r\n")
+	return buf.Bytes()
+}
+
+func writeChunk(w io.Writer, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%x\r\n", len(data))
+	w.Write(data)
+	io.WriteString(w, "\r\n")
+}
+
+// do runs an ICAP transaction. For REQMOD, msg.req and msg.body are the
+// request and its body, for RESPMOD, msg.resp and msg.body are the
+// response and its body, and msg.req is the request of the response.
+func (c *client) do(method string, msg *message) (*result, error) {
+	conn, err := net.DialTimeout("tcp", c.host, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(c.timeout))
+
+	var encapsulated []string
+	var headers []byte
+
+	reqHeader := dumpRequestHeader(msg.req)
+	if method == methodReqMod {
+		headers = reqHeader
+		encapsulated = append(encapsulated, "req-hdr=0")
+		if msg.body != nil {
+			encapsulated = append(encapsulated, "req-body="+strconv.Itoa(len(headers)))
+		}
+	} else {
+		respHeader := dumpResponseHeader(msg.resp)
+		headers = append(reqHeader, respHeader...)
+		encapsulated = append(encapsulated, "req-hdr=0", "res-hdr="+strconv.Itoa(len(reqHeader)))
+		if msg.body != nil {
+			encapsulated = append(encapsulated, "res-body="+strconv.Itoa(len(headers)))
+		}
+	}
+	if msg.body == nil {
+		encapsulated = append(encapsulated, "null-body="+strconv.Itoa(len(headers)))
+	}
+
+	body, rest := msg.body, []byte(nil)
+	preview := c.preview > 0 && msg.body != nil
+	if preview && len(body) > c.preview {
+		body, rest = body[:c.preview], body[c.preview:]
+	}
+
+	w := bufio.NewWriter(conn)
+	fmt.Fprintf(w, "%s %s ICAP/1.0\r\n", method, c.url.String())
+	fmt.Fprintf(w, "Host: %s\r\n", c.url.Host)
+	fmt.Fprintf(w, "Allow: 204\r\n")
+	if preview {
+		fmt.Fprintf(w, "Preview: %d\r\n", len(body))
+	}
+	fmt.Fprintf(w, "Encapsulated: %s\r\n\r\n", strings.Join(encapsulated, ", "))
+	w.Write(headers)
+
+	if msg.body != nil {
+		writeChunk(w, body)
+		if preview && rest == nil {
+			io.WriteString(w, "0; ieof\r\n\r\n")
+		} else {
+			io.WriteString(w, "0\r\n\r\n")
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	code, header, err := readHeader(br)
+	if err != nil {
+		return nil, err
+	}
+
+	if code == 100 {
+		if !preview || rest == nil {
+			return nil, fmt.Errorf("unexpected 100 continue")
+		}
+		writeChunk(w, rest)
+		io.WriteString(w, "0\r\n\r\n")
+		if err := w.Flush(); err != nil {
+			return nil, err
+		}
+		code, header, err = readHeader(br)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch code {
+	case 204:
+		return &result{}, nil
+	case 200:
+		return readEncapsulated(br, header.Get("Encapsulated"))
+	default:
+		return nil, fmt.Errorf("icap server returns status code %d", code)
+	}
+}
+
+func readHeader(br *bufio.Reader) (int, textproto.MIMEHeader, error) {
+	tr := textproto.NewReader(br)
+	line, err := tr.ReadLine()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) < 2 || fields[0] != "ICAP/1.0" {
+		return 0, nil, fmt.Errorf("invalid status line: %s", line)
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid status line: %s", line)
+	}
+
+	header, err := tr.ReadMIMEHeader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return code, header, nil
+}
+
+func parseEncapsulated(value string) ([]*section, error) {
+	var sections []*section
+	for _, field := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid encapsulated header: %s", value)
+		}
+		offset, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid encapsulated header: %s", value)
+		}
+		if len(sections) > 0 && offset < sections[len(sections)-1].offset {
+			return nil, fmt.Errorf("invalid encapsulated header: %s", value)
+		}
+		sections = append(sections, &section{name: kv[0], offset: offset})
+	}
+
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("empty encapsulated header")
+	}
+	return sections, nil
+}
+
+func readEncapsulated(br *bufio.Reader, encapsulated string) (*result, error) {
+	sections, err := parseEncapsulated(encapsulated)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &result{}
+	last := sections[len(sections)-1]
+	for i, s := range sections[:len(sections)-1] {
+		data := make([]byte, sections[i+1].offset-s.offset)
+		if _, err := io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+
+		switch s.name {
+		case "req-hdr":
+			res.req, err = http.ReadRequest(bufio.NewReader(bytes.NewReader(data)))
+		case "res-hdr":
+			res.resp, err = http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), nil)
+		default:
+			err = fmt.Errorf("invalid encapsulated section: %s", s.name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch last.name {
+	case "req-body", "res-body":
+		res.body, err = ioutil.ReadAll(httputil.NewChunkedReader(br))
+		if err != nil {
+			return nil, err
+		}
+	case "null-body":
+	default:
+		return nil, fmt.Errorf("invalid last encapsulated section: %s", last.name)
+	}
+
+	if res.req == nil && res.resp == nil {
+		return nil, fmt.Errorf("no http message encapsulated")
+	}
+
+	return res, nil
+}
@@ -0,0 +1,341 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package icap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+type (
+	// icapRequest is the request received by the mocked ICAP server.
+	icapRequest struct {
+		method  string
+		header  map[string]string
+		headers string
+		body    []byte
+		ieof    bool
+		// continued is true if the rest of the body is sent after 100 continue.
+		continued bool
+	}
+
+	mockedServer struct {
+		ln net.Listener
+		// handle returns the response, and whether to ask for the rest
+		// of the body after the preview.
+		handle func(req *icapRequest) (resp string, more bool)
+		reqs   chan *icapRequest
+	}
+)
+
+func newMockedServer(t *testing.T, handle func(req *icapRequest) (string, bool)) *mockedServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	s := &mockedServer{ln: ln, handle: handle, reqs: make(chan *icapRequest, 10)}
+	go s.serve()
+	return s
+}
+
+func (s *mockedServer) url(service string) string {
+	return "icap://" + s.ln.Addr().String() + "/" + service
+}
+
+func (s *mockedServer) close() {
+	s.ln.Close()
+}
+
+func (s *mockedServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// readChunks reads chunks until the last chunk, and reports whether the
+// last chunk has the ieof extension.
+func readChunks(br *bufio.Reader) ([]byte, bool) {
+	var body []byte
+	for {
+		line, _ := br.ReadString('\n')
+		line = strings.TrimSpace(line)
+		var size int
+		fmt.Sscanf(line, "%x", &size)
+		if size == 0 {
+			br.ReadString('\n')
+			return body, strings.Contains(line, "ieof")
+		}
+		data := make([]byte, size)
+		io.ReadFull(br, data)
+		br.ReadString('\n')
+		body = append(body, data...)
+	}
+}
+
+func (s *mockedServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+
+	line, _ := br.ReadString('\n')
+	req := &icapRequest{method: strings.Fields(line)[0], header: map[string]string{}}
+	for {
+		line, _ := br.ReadString('\n')
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, ": ", 2)
+		req.header[kv[0]] = kv[1]
+	}
+
+	var sections []string
+	for _, field := range strings.Split(req.header["Encapsulated"], ", ") {
+		sections = append(sections, field)
+	}
+	last := sections[len(sections)-1]
+	var bodyOffset int
+	fmt.Sscanf(last[strings.Index(last, "=")+1:], "%d", &bodyOffset)
+	headers := make([]byte, bodyOffset)
+	io.ReadFull(br, headers)
+	req.headers = string(headers)
+
+	if !strings.HasPrefix(last, "null-body") {
+		req.body, req.ieof = readChunks(br)
+	}
+
+	resp, more := s.handle(req)
+	if more && !req.ieof && req.header["Preview"] != "" {
+		conn.Write([]byte("ICAP/1.0 100 Continue\r\n\r\n"))
+		rest, _ := readChunks(br)
+		req.body = append(req.body, rest...)
+		req.continued = true
+		resp, _ = s.handle(req)
+	}
+
+	s.reqs <- req
+	conn.Write([]byte(resp))
+}
+
+func chunked(body string) string {
+	buf := &bytes.Buffer{}
+	w := httputil.NewChunkedWriter(buf)
+	w.Write([]byte(body))
+	w.Close()
+	return buf.String() + "\r\n"
+}
+
+func newRequest(body string) (*http.Request, []byte) {
+	u, _ := url.Parse("/upload?a=1")
+	req := &http.Request{
+		Method: http.MethodPost,
+		URL:    u,
+		Host:   "example.com",
+		Header: http.Header{"Content-Type": []string{"text/plain"}},
+	}
+	if body == "" {
+		return req, nil
+	}
+	return req, []byte(body)
+}
+
+func TestReqModNoModification(t *testing.T) {
+	s := newMockedServer(t, func(req *icapRequest) (string, bool) {
+		return "ICAP/1.0 204 No Content\r\n\r\n", false
+	})
+	defer s.close()
+
+	c, err := newClient(s.url("reqmod"), time.Second, 0)
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+
+	req, body := newRequest("hello")
+	res, err := c.do(methodReqMod, &message{req: req, body: body})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.req != nil || res.resp != nil {
+		t.Errorf("message should not be modified")
+	}
+
+	got := <-s.reqs
+	if got.method != methodReqMod || got.header["Allow"] != "204" {
+		t.Errorf("unexpected request: %+v", got)
+	}
+	if !strings.HasPrefix(got.headers, "POST /upload?a=1 HTTP/1.1\r\nHost: example.com\r\n") {
+		t.Errorf("unexpected encapsulated headers: %q", got.headers)
+	}
+	if string(got.body) != "hello" {
+		t.Errorf("unexpected body: %q", got.body)
+	}
+}
+
+func TestReqModModified(t *testing.T) {
+	modified := "POST /upload?a=2 HTTP/1.1\r\nHost: example.com\r\nX-Scanned: yes\r\n\r\n"
+	s := newMockedServer(t, func(req *icapRequest) (string, bool) {
+		return fmt.Sprintf("ICAP/1.0 200 OK\r\nEncapsulated: req-hdr=0, req-body=%d\r\n\r\n", len(modified)) +
+			modified + chunked("redacted"), false
+	})
+	defer s.close()
+
+	c, _ := newClient(s.url("reqmod"), time.Second, 0)
+	req, body := newRequest("secret")
+	res, err := c.do(methodReqMod, &message{req: req, body: body})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.req == nil || res.req.URL.RawQuery != "a=2" || res.req.Header.Get("X-Scanned") != "yes" {
+		t.Errorf("unexpected modified request: %+v", res.req)
+	}
+	if string(res.body) != "redacted" {
+		t.Errorf("unexpected modified body: %q", res.body)
+	}
+}
+
+func TestReqModBlocked(t *testing.T) {
+	blocked := "HTTP/1.1 403 Forbidden\r\nContent-Type: text/html\r\n\r\n"
+	s := newMockedServer(t, func(req *icapRequest) (string, bool) {
+		return fmt.Sprintf("ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(blocked)) +
+			blocked + chunked("blocked"), false
+	})
+	defer s.close()
+
+	c, _ := newClient(s.url("reqmod"), time.Second, 0)
+	req, body := newRequest("virus")
+	res, err := c.do(methodReqMod, &message{req: req, body: body})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.resp == nil || res.resp.StatusCode != http.StatusForbidden || string(res.body) != "blocked" {
+		t.Errorf("unexpected response: %+v, %q", res.resp, res.body)
+	}
+}
+
+func TestPreview(t *testing.T) {
+	s := newMockedServer(t, func(req *icapRequest) (string, bool) {
+		// Asks for the rest of the body if the preview is not enough.
+		if strings.Contains(string(req.body), "need more") {
+			return "ICAP/1.0 204 No Content\r\n\r\n", true
+		}
+		return "ICAP/1.0 204 No Content\r\n\r\n", false
+	})
+	defer s.close()
+
+	c, _ := newClient(s.url("reqmod"), time.Second, 10)
+
+	// The whole body fits in the preview.
+	req, body := newRequest("short")
+	if _, err := c.do(methodReqMod, &message{req: req, body: body}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := <-s.reqs
+	if got.header["Preview"] != "5" || !got.ieof || got.continued {
+		t.Errorf("unexpected request: %+v", got)
+	}
+
+	// The server decides by the preview.
+	req, body = newRequest("0123456789 the rest is not sent")
+	if _, err := c.do(methodReqMod, &message{req: req, body: body}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got = <-s.reqs
+	if got.header["Preview"] != "10" || got.ieof || got.continued || string(got.body) != "0123456789" {
+		t.Errorf("unexpected request: %+v", got)
+	}
+
+	// The server asks for the rest of the body.
+	req, body = newRequest("need more and the rest")
+	if _, err := c.do(methodReqMod, &message{req: req, body: body}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got = <-s.reqs
+	if !got.continued || string(got.body) != "need more and the rest" {
+		t.Errorf("unexpected request: %+v", got)
+	}
+}
+
+func TestRespMod(t *testing.T) {
+	modified := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+	s := newMockedServer(t, func(req *icapRequest) (string, bool) {
+		return fmt.Sprintf("ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(modified)) +
+			modified + chunked(strings.ToUpper(string(req.body))), false
+	})
+	defer s.close()
+
+	c, _ := newClient(s.url("respmod"), time.Second, 0)
+	req, _ := newRequest("")
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Content-Type": []string{"text/plain"}}}
+	res, err := c.do(methodRespMod, &message{req: req, resp: resp, body: []byte("hello")})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.resp == nil || string(res.body) != "HELLO" {
+		t.Errorf("unexpected response: %+v, %q", res.resp, res.body)
+	}
+
+	got := <-s.reqs
+	if !strings.HasPrefix(got.header["Encapsulated"], "req-hdr=0, res-hdr=") {
+		t.Errorf("unexpected encapsulated header: %s", got.header["Encapsulated"])
+	}
+	if !strings.Contains(got.headers, "HTTP/1.1 200 OK\r\n") {
+		t.Errorf("response header should be encapsulated: %q", got.headers)
+	}
+}
+
+func TestFailures(t *testing.T) {
+	s := newMockedServer(t, func(req *icapRequest) (string, bool) {
+		return "ICAP/1.0 500 Server Error\r\n\r\n", false
+	})
+	defer s.close()
+
+	c, _ := newClient(s.url("reqmod"), time.Second, 0)
+	req, body := newRequest("hello")
+	if _, err := c.do(methodReqMod, &message{req: req, body: body}); err == nil {
+		t.Errorf("want error for status code 500")
+	}
+
+	c, _ = newClient("icap://127.0.0.1:1/reqmod", time.Second, 0)
+	if _, err := c.do(methodReqMod, &message{req: req, body: body}); err == nil {
+		t.Errorf("want error for unreachable server")
+	}
+
+	if _, err := newClient("http://127.0.0.1/reqmod", time.Second, 0); err == nil {
+		t.Errorf("want error for non-icap scheme")
+	}
+
+	if _, err := readEncapsulated(bufio.NewReader(strings.NewReader("")), "null-body=0"); err == nil {
+		t.Errorf("want error for no http message")
+	}
+	if _, err := parseEncapsulated("req-hdr=10, req-body=0"); err == nil {
+		t.Errorf("want error for decreasing offsets")
+	}
+}
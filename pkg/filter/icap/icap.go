@@ -0,0 +1,315 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package icap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ICAP.
+	Kind = "ICAP"
+
+	resultRejected = "rejected"
+	resultFailed   = "failed"
+
+	defaultTimeout     = 10 * time.Second
+	defaultMaxBodySize = 1024 * 1024
+)
+
+var results = []string{resultRejected, resultFailed}
+
+func init() {
+	httppipeline.Register(&ICAP{})
+}
+
+type (
+	// ICAP sends requests and responses to ICAP servers for inspection
+	// and content adaptation.
+	ICAP struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		reqmod      *client
+		respmod     *client
+		maxBodySize int64
+	}
+
+	// Spec describes the ICAP.
+	Spec struct {
+		// ReqMod is the URL of the REQMOD service, like icap://127.0.0.1:1344/reqmod.
+		ReqMod string `yaml:"reqmod" jsonschema:"omitempty,format=uri"`
+		// RespMod is the URL of the RESPMOD service, like icap://127.0.0.1:1344/respmod.
+		RespMod string `yaml:"respmod" jsonschema:"omitempty,format=uri"`
+		// Preview is the number of body bytes sent as preview, zero means no preview.
+		Preview uint32 `yaml:"preview" jsonschema:"omitempty"`
+		// Timeout is the timeout of an ICAP transaction.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// MaxBodySize is the max size of bodies to inspect.
+		MaxBodySize uint32 `yaml:"maxBodySize" jsonschema:"omitempty"`
+		// BypassOnFailure forwards the message as is if the ICAP server
+		// fails or the body is too large, instead of failing the request.
+		BypassOnFailure bool `yaml:"bypassOnFailure" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.ReqMod == "" && spec.RespMod == "" {
+		return fmt.Errorf("both reqmod and respmod are empty")
+	}
+
+	for _, u := range []string{spec.ReqMod, spec.RespMod} {
+		if u == "" {
+			continue
+		}
+		if _, err := newClient(u, 0, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Kind returns the kind of ICAP.
+func (ic *ICAP) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ICAP.
+func (ic *ICAP) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of ICAP.
+func (ic *ICAP) Description() string {
+	return "ICAP sends requests and responses to ICAP servers for inspection and content adaptation."
+}
+
+// Results returns the results of ICAP.
+func (ic *ICAP) Results() []string {
+	return results
+}
+
+// Init initializes ICAP.
+func (ic *ICAP) Init(filterSpec *httppipeline.FilterSpec) {
+	ic.filterSpec, ic.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+
+	timeout := defaultTimeout
+	if ic.spec.Timeout != "" {
+		d, err := time.ParseDuration(ic.spec.Timeout)
+		if err != nil {
+			logger.Errorf("BUG: parse duration %s failed: %v", ic.spec.Timeout, err)
+		} else {
+			timeout = d
+		}
+	}
+
+	ic.maxBodySize = int64(ic.spec.MaxBodySize)
+	if ic.maxBodySize == 0 {
+		ic.maxBodySize = defaultMaxBodySize
+	}
+
+	// NOTE: The URLs have been validated.
+	if ic.spec.ReqMod != "" {
+		ic.reqmod, _ = newClient(ic.spec.ReqMod, timeout, int(ic.spec.Preview))
+	}
+	if ic.spec.RespMod != "" {
+		ic.respmod, _ = newClient(ic.spec.RespMod, timeout, int(ic.spec.Preview))
+	}
+}
+
+// Inherit inherits previous generation of ICAP.
+func (ic *ICAP) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ic.Init(filterSpec)
+}
+
+// Handle sends the request to the REQMOD service, calls the next handler,
+// and then sends the response to the RESPMOD service.
+func (ic *ICAP) Handle(ctx context.HTTPContext) string {
+	if ic.reqmod != nil {
+		if result := ic.handleRequest(ctx); result != "" {
+			return ctx.CallNextHandler(result)
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+	if result != "" || ic.respmod == nil {
+		return result
+	}
+
+	return ic.handleResponse(ctx)
+}
+
+// readBody reads the body up to maxBodySize, and restores the body.
+// It returns false if the body is too large.
+func (ic *ICAP) readBody(body io.Reader, setBody func(io.Reader)) ([]byte, bool, error) {
+	if body == nil {
+		return nil, true, nil
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(body, ic.maxBodySize+1))
+	if err != nil {
+		return nil, false, err
+	}
+
+	closer, _ := body.(io.Closer)
+	if int64(len(data)) > ic.maxBodySize {
+		r := io.MultiReader(bytes.NewReader(data), body)
+		if closer != nil {
+			setBody(struct {
+				io.Reader
+				io.Closer
+			}{r, closer})
+		} else {
+			setBody(r)
+		}
+		return nil, false, nil
+	}
+
+	// NOTE: The body has been read to completion, it must be closed.
+	if closer != nil {
+		closer.Close()
+	}
+	setBody(bytes.NewReader(data))
+	return data, true, nil
+}
+
+// fail handles the failure according to BypassOnFailure, it returns an
+// empty string if the failure is bypassed.
+func (ic *ICAP) fail(ctx context.HTTPContext, statusCode int, msg string) string {
+	if ic.spec.BypassOnFailure {
+		ctx.AddTag(stringtool.Cat("icap: bypassed: ", msg))
+		return ""
+	}
+
+	ctx.AddTag(stringtool.Cat("icap: ", msg))
+	ctx.Response().SetStatusCode(statusCode)
+	return resultFailed
+}
+
+func (ic *ICAP) stdRequest(ctx context.HTTPContext) *http.Request {
+	r := ctx.Request()
+	return &http.Request{
+		Method: r.Method(),
+		URL: &url.URL{
+			Path:     r.Path(),
+			RawPath:  r.EscapedPath(),
+			RawQuery: r.Query(),
+		},
+		Host:   r.Host(),
+		Header: r.Header().Std(),
+	}
+}
+
+func (ic *ICAP) handleRequest(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	body, ok, err := ic.readBody(r.Body(), r.SetBody)
+	if err != nil {
+		ctx.AddTag(stringtool.Cat("icap: read request body failed: ", err.Error()))
+		ctx.Response().SetStatusCode(http.StatusBadRequest)
+		return resultFailed
+	}
+	if !ok {
+		return ic.fail(ctx, http.StatusRequestEntityTooLarge, "request body too large")
+	}
+
+	res, err := ic.reqmod.do(methodReqMod, &message{req: ic.stdRequest(ctx), body: body})
+	if err != nil {
+		return ic.fail(ctx, http.StatusBadGateway, stringtool.Cat("reqmod failed: ", err.Error()))
+	}
+
+	if res.resp != nil {
+		// NOTE: The ICAP server responds instead of the upstream,
+		// for example, with a page telling the request is blocked.
+		ic.setResponse(ctx, res.resp, res.body)
+		ctx.AddTag("icap: request rejected")
+		return resultRejected
+	}
+
+	if res.req != nil {
+		r.SetMethod(res.req.Method)
+		r.SetPath(res.req.URL.Path)
+		r.SetQuery(res.req.URL.RawQuery)
+		r.Header().Reset(res.req.Header)
+		r.SetBody(bytes.NewReader(res.body))
+		r.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(res.body)))
+		ctx.AddTag("icap: request modified")
+	}
+
+	return ""
+}
+
+func (ic *ICAP) handleResponse(ctx context.HTTPContext) string {
+	w := ctx.Response()
+
+	body, ok, err := ic.readBody(w.Body(), w.SetBody)
+	if err != nil {
+		return ic.fail(ctx, http.StatusBadGateway, stringtool.Cat("read response body failed: ", err.Error()))
+	}
+	if !ok {
+		return ic.fail(ctx, http.StatusBadGateway, "response body too large")
+	}
+
+	resp := &http.Response{
+		StatusCode: w.StatusCode(),
+		Header:     w.Header().Std(),
+	}
+	res, err := ic.respmod.do(methodRespMod, &message{req: ic.stdRequest(ctx), resp: resp, body: body})
+	if err != nil {
+		return ic.fail(ctx, http.StatusBadGateway, stringtool.Cat("respmod failed: ", err.Error()))
+	}
+
+	if res.resp != nil {
+		ic.setResponse(ctx, res.resp, res.body)
+		ctx.AddTag("icap: response modified")
+	}
+
+	return ""
+}
+
+func (ic *ICAP) setResponse(ctx context.HTTPContext, resp *http.Response, body []byte) {
+	w := ctx.Response()
+	w.SetStatusCode(resp.StatusCode)
+	w.Header().Reset(resp.Header)
+	w.Header().Set(httpheader.KeyContentLength, strconv.Itoa(len(body)))
+	w.SetBody(bytes.NewReader(body))
+}
+
+// Status returns status.
+func (ic *ICAP) Status() interface{} {
+	return nil
+}
+
+// Close closes ICAP.
+func (ic *ICAP) Close() {}
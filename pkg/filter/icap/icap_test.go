@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package icap
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newICAP(t *testing.T, yamlSpec string) *ICAP {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ic := &ICAP{}
+	ic.Init(spec)
+	return ic
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	reqBody    io.Reader
+	respBody   io.Reader
+	statusCode int
+	next       int
+}
+
+func newContext(body string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		reqBody:           strings.NewReader(body),
+		statusCode:        http.StatusOK,
+	}
+	reqHeader := httpheader.New(http.Header{})
+	respHeader := httpheader.New(http.Header{})
+
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodPost }
+	ctx.MockedRequest.MockedPath = func() string { return "/upload" }
+	ctx.MockedRequest.MockedEscapedPath = func() string { return "/upload" }
+	ctx.MockedRequest.MockedHost = func() string { return "example.com" }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return ctx.reqBody }
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) { ctx.reqBody = body }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return respHeader }
+	ctx.MockedResponse.MockedStatusCode = func() int { return ctx.statusCode }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedResponse.MockedBody = func() io.Reader { return ctx.respBody }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { ctx.respBody = body }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if lastResult == "" {
+			// Simulates the proxy echoing the request body.
+			ctx.next++
+			data, _ := ioutil.ReadAll(ctx.reqBody)
+			ctx.respBody = strings.NewReader(string(data))
+		}
+		return lastResult
+	}
+
+	return ctx
+}
+
+func readAll(r io.Reader) string {
+	if r == nil {
+		return ""
+	}
+	data, _ := ioutil.ReadAll(r)
+	return string(data)
+}
+
+func TestICAP(t *testing.T) {
+	blocked := "HTTP/1.1 403 Forbidden\r\nContent-Type: text/plain\r\n\r\n"
+	modified := "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n\r\n"
+	s := newMockedServer(t, func(req *icapRequest) (string, bool) {
+		switch {
+		case req.method == methodReqMod && strings.Contains(string(req.body), "virus"):
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(blocked)) +
+				blocked + chunked("blocked"), false
+		case req.method == methodRespMod && strings.Contains(string(req.body), "secret"):
+			return fmt.Sprintf("ICAP/1.0 200 OK\r\nEncapsulated: res-hdr=0, res-body=%d\r\n\r\n", len(modified)) +
+				modified + chunked("******"), false
+		}
+		return "ICAP/1.0 204 No Content\r\n\r\n", false
+	})
+	defer s.close()
+
+	ic := newICAP(t, fmt.Sprintf(`
+kind: ICAP
+name: icap
+reqmod: %s
+respmod: %s
+preview: 16
+`, s.url("reqmod"), s.url("respmod")))
+
+	ctx := newContext("clean")
+	if result := ic.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	if body := readAll(ctx.respBody); body != "clean" {
+		t.Errorf("unexpected body: %s", body)
+	}
+
+	ctx = newContext("a virus")
+	if result := ic.Handle(ctx); result != resultRejected {
+		t.Fatalf("want result rejected, got %s", result)
+	}
+	if ctx.statusCode != http.StatusForbidden || readAll(ctx.respBody) != "blocked" || ctx.next != 0 {
+		t.Errorf("request should be blocked by the icap server")
+	}
+
+	ctx = newContext("a secret")
+	if result := ic.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	if body := readAll(ctx.respBody); body != "******" {
+		t.Errorf("response should be modified, got %s", body)
+	}
+}
+
+func TestBypassOnFailure(t *testing.T) {
+	spec := `
+kind: ICAP
+name: icap
+reqmod: icap://127.0.0.1:1/reqmod
+maxBodySize: 10
+`
+	ic := newICAP(t, spec)
+	ctx := newContext("hello")
+	if result := ic.Handle(ctx); result != resultFailed || ctx.statusCode != http.StatusBadGateway {
+		t.Errorf("want result failed with 502, got %s, %d", result, ctx.statusCode)
+	}
+
+	ctx = newContext("hello world!")
+	if result := ic.Handle(ctx); result != resultFailed || ctx.statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("want result failed with 413, got %s, %d", result, ctx.statusCode)
+	}
+
+	ic = newICAP(t, spec+"bypassOnFailure: true\n")
+	ctx = newContext("hello")
+	if result := ic.Handle(ctx); result != "" || readAll(ctx.respBody) != "hello" {
+		t.Errorf("failure should be bypassed")
+	}
+
+	ctx = newContext("hello world!")
+	if result := ic.Handle(ctx); result != "" || readAll(ctx.respBody) != "hello world!" {
+		t.Errorf("large body should be bypassed")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	if (&Spec{}).Validate() == nil {
+		t.Errorf("empty spec should fail")
+	}
+	if (&Spec{ReqMod: "http://127.0.0.1/reqmod"}).Validate() == nil {
+		t.Errorf("non-icap url should fail")
+	}
+	if (&Spec{RespMod: "icap://127.0.0.1/respmod"}).Validate() != nil {
+		t.Errorf("valid spec should pass")
+	}
+}
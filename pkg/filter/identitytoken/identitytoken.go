@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package identitytoken
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+	"github.com/megaease/easegress/pkg/util/tokencache"
+)
+
+const (
+	// Kind is the kind of IdentityToken.
+	Kind = "IdentityToken"
+
+	resultTokenFailed = "tokenFailed"
+
+	providerGCP  = "gcp"
+	providerOIDC = "oidc"
+
+	defaultHeader = "Authorization"
+
+	fetchTimeout = 10 * time.Second
+)
+
+var results = []string{resultTokenFailed}
+
+func init() {
+	httppipeline.Register(&IdentityToken{})
+}
+
+type (
+	// IdentityToken fetches identity tokens of the gateway and attaches
+	// them to the requests, so the upstreams could authenticate the
+	// gateway, e.g. Cloud Run services and services behind IAP.
+	IdentityToken struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		tokens *tokencache.Cache
+	}
+
+	// Spec describes the IdentityToken.
+	Spec struct {
+		Provider string `yaml:"provider" jsonschema:"required,enum=gcp,enum=oidc"`
+		// Audience is the audience of tokens, e.g. the URL of the Cloud
+		// Run service, or the OAuth client ID of IAP.
+		Audience string `yaml:"audience" jsonschema:"omitempty"`
+		// Header is the header to carry the token, the default is
+		// Authorization, it could be Proxy-Authorization for IAP if the
+		// Authorization of clients should be kept.
+		Header string `yaml:"header" jsonschema:"omitempty"`
+
+		// ServiceAccount is the service account of the GCP metadata
+		// server, the default is the default one of the instance.
+		ServiceAccount string `yaml:"serviceAccount" jsonschema:"omitempty"`
+
+		// The client credentials grant of the OIDC provider.
+		TokenURL     string   `yaml:"tokenURL" jsonschema:"omitempty,format=uri"`
+		ClientID     string   `yaml:"clientID" jsonschema:"omitempty"`
+		ClientSecret string   `yaml:"clientSecret" jsonschema:"omitempty"`
+		Scopes       []string `yaml:"scopes" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	switch spec.Provider {
+	case providerGCP:
+		if spec.Audience == "" {
+			return fmt.Errorf("audience is required by gcp")
+		}
+		if spec.TokenURL != "" || spec.ClientID != "" || spec.ClientSecret != "" || len(spec.Scopes) != 0 {
+			return fmt.Errorf("tokenURL, clientID, clientSecret and scopes are only for oidc")
+		}
+	case providerOIDC:
+		if spec.TokenURL == "" || spec.ClientID == "" || spec.ClientSecret == "" {
+			return fmt.Errorf("tokenURL, clientID and clientSecret are required by oidc")
+		}
+		if spec.ServiceAccount != "" {
+			return fmt.Errorf("serviceAccount is only for gcp")
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of IdentityToken.
+func (it *IdentityToken) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of IdentityToken.
+func (it *IdentityToken) DefaultSpec() interface{} {
+	return &Spec{Header: defaultHeader}
+}
+
+// Description returns the description of IdentityToken.
+func (it *IdentityToken) Description() string {
+	return "IdentityToken attaches identity tokens of the gateway to requests."
+}
+
+// Results returns the results of IdentityToken.
+func (it *IdentityToken) Results() []string {
+	return results
+}
+
+// Init initializes IdentityToken.
+func (it *IdentityToken) Init(filterSpec *httppipeline.FilterSpec) {
+	it.filterSpec, it.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	it.reload()
+}
+
+// Inherit inherits previous generation of IdentityToken.
+func (it *IdentityToken) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	it.Init(filterSpec)
+}
+
+func (it *IdentityToken) reload() {
+	client := &http.Client{Timeout: fetchTimeout, Transport: egressproxy.DefaultTransport}
+
+	var source tokencache.Source
+	if it.spec.Provider == providerGCP {
+		source = newGCPSource(client, it.spec.ServiceAccount, it.spec.Audience)
+	} else {
+		source = &oidcSource{
+			client:       client,
+			tokenURL:     it.spec.TokenURL,
+			clientID:     it.spec.ClientID,
+			clientSecret: it.spec.ClientSecret,
+			audience:     it.spec.Audience,
+			scopes:       it.spec.Scopes,
+		}
+	}
+
+	it.tokens = tokencache.New(source)
+}
+
+// Handle attaches the token to the request.
+func (it *IdentityToken) Handle(ctx context.HTTPContext) string {
+	result := it.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (it *IdentityToken) handle(ctx context.HTTPContext) string {
+	token, err := it.tokens.Get()
+	if err != nil {
+		logger.Errorf("%s: get token failed: %v", it.filterSpec.Name(), err)
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		ctx.AddTag(fmt.Sprintf("identityToken: %v", err))
+		return resultTokenFailed
+	}
+
+	header := it.spec.Header
+	if header == "" {
+		header = defaultHeader
+	}
+	ctx.Request().Header().Set(header, "Bearer "+token)
+	return ""
+}
+
+// Status returns status.
+func (it *IdentityToken) Status() interface{} {
+	return nil
+}
+
+// Close closes IdentityToken.
+func (it *IdentityToken) Close() {}
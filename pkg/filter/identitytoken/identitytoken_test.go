@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package identitytoken
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/tokencache"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newIdentityToken(t *testing.T, yamlSpec string) *IdentityToken {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	it := &IdentityToken{}
+	it.Init(spec)
+	return it
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec  Spec
+		valid bool
+	}{
+		{Spec{Provider: providerGCP, Audience: "https://a.run.app"}, true},
+		{Spec{Provider: providerGCP}, false},
+		{Spec{Provider: providerGCP, Audience: "a", ClientID: "id"}, false},
+		{Spec{Provider: providerOIDC, TokenURL: "https://idp/token", ClientID: "id", ClientSecret: "secret"}, true},
+		{Spec{Provider: providerOIDC, TokenURL: "https://idp/token", ClientID: "id"}, false},
+		{Spec{Provider: providerOIDC, TokenURL: "https://idp/token", ClientID: "id", ClientSecret: "secret", ServiceAccount: "sa"}, false},
+	}
+
+	for i, c := range cases {
+		err := c.spec.Validate()
+		if c.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("case %d: should be invalid", i)
+		}
+	}
+}
+
+type countingSource struct {
+	count int
+	token *tokencache.Token
+	err   error
+}
+
+func (s *countingSource) Fetch() (*tokencache.Token, error) {
+	s.count++
+	return s.token, s.err
+}
+
+func TestHandle(t *testing.T) {
+	it := newIdentityToken(t, `
+kind: IdentityToken
+name: identity-token
+provider: gcp
+audience: https://hello-abc.a.run.app
+header: Proxy-Authorization
+`)
+
+	source := &countingSource{token: &tokencache.Token{Value: "jwt", Expiry: time.Now().Add(time.Hour)}}
+	it.tokens = tokencache.New(source)
+
+	header := httpheader.New(http.Header{})
+	header.Set("Authorization", "Bearer client")
+	statusCode := 0
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	if result := it.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if header.Get("Proxy-Authorization") != "Bearer jwt" || header.Get("Authorization") != "Bearer client" {
+		t.Errorf("unexpected headers %v", header.Std())
+	}
+
+	source.err = fmt.Errorf("failed")
+	it.tokens = tokencache.New(source)
+	if result := it.Handle(ctx); result != resultTokenFailed {
+		t.Errorf("unexpected result %s", result)
+	}
+	if statusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected status code %d", statusCode)
+	}
+}
@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package identitytoken
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/util/tokencache"
+)
+
+const defaultMetadataHost = "metadata.google.internal"
+
+type (
+	// gcpSource fetches identity tokens of the service account from the
+	// metadata server of GCP.
+	gcpSource struct {
+		client *http.Client
+		url    string
+	}
+
+	// oidcSource fetches tokens from the token endpoint of an OIDC
+	// provider with the client credentials grant.
+	oidcSource struct {
+		client       *http.Client
+		tokenURL     string
+		clientID     string
+		clientSecret string
+		audience     string
+		scopes       []string
+	}
+
+	tokenResponse struct {
+		IDToken     string `json:"id_token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+)
+
+func newGCPSource(client *http.Client, serviceAccount, audience string) *gcpSource {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = defaultMetadataHost
+	}
+	if serviceAccount == "" {
+		serviceAccount = "default"
+	}
+
+	q := url.Values{}
+	q.Set("audience", audience)
+	q.Set("format", "full")
+
+	return &gcpSource{
+		client: client,
+		url: fmt.Sprintf("http://%s/computeMetadata/v1/instance/service-accounts/%s/identity?%s",
+			host, url.PathEscape(serviceAccount), q.Encode()),
+	}
+}
+
+// Fetch implements tokencache.Source.
+func (s *gcpSource) Fetch() (*tokencache.Token, error) {
+	req, err := http.NewRequest(http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	body, err := tokencache.Do(s.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch identity token from metadata server failed: %v", err)
+	}
+
+	value := strings.TrimSpace(string(body))
+	return &tokencache.Token{Value: value, Expiry: tokencache.Expiry(value, 0)}, nil
+}
+
+// Fetch implements tokencache.Source.
+func (s *oidcSource) Fetch() (*tokencache.Token, error) {
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	if s.audience != "" {
+		form.Set("audience", s.audience)
+	}
+	if len(s.scopes) != 0 {
+		form.Set("scope", strings.Join(s.scopes, " "))
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(s.clientID), url.QueryEscape(s.clientSecret))
+
+	body, err := tokencache.Do(s.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch token from %s failed: %v", s.tokenURL, err)
+	}
+
+	resp := &tokenResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+
+	// The ID token is preferred, but some providers only issue access
+	// tokens for the client credentials grant.
+	value := resp.IDToken
+	if value == "" {
+		value = resp.AccessToken
+	}
+	if value == "" {
+		return nil, fmt.Errorf("invalid token response: no token")
+	}
+
+	return &tokencache.Token{Value: value, Expiry: tokencache.Expiry(value, resp.ExpiresIn)}, nil
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package identitytoken
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newJWT(exp time.Time) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." +
+		enc.EncodeToString([]byte(fmt.Sprintf(`{"aud":"test","exp":%d}`, exp.Unix()))) + ".sig"
+}
+
+func TestGCPSource(t *testing.T) {
+	jwt := newJWT(time.Now().Add(time.Hour))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" ||
+			r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/identity" ||
+			r.URL.Query().Get("audience") != "https://hello-abc.a.run.app" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, jwt)
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	os.Setenv("GCE_METADATA_HOST", u.Host)
+	defer os.Unsetenv("GCE_METADATA_HOST")
+
+	s := newGCPSource(server.Client(), "", "https://hello-abc.a.run.app")
+	tk, err := s.Fetch()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tk.Value != jwt || time.Until(tk.Expiry) < 59*time.Minute {
+		t.Errorf("unexpected token %+v", tk)
+	}
+
+	s = newGCPSource(server.Client(), "", "other")
+	if _, err = s.Fetch(); err == nil {
+		t.Errorf("expect an error")
+	}
+}
+
+func TestOIDCSource(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, ok := r.BasicAuth()
+		r.ParseForm()
+		if !ok || id != "gateway" || secret != "s3cr3t" || r.Form.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Form.Get("scope") != "openid orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Form.Get("audience") == "access" {
+			fmt.Fprint(w, `{"access_token":"opaque","token_type":"Bearer","expires_in":3600}`)
+			return
+		}
+		fmt.Fprint(w, `{"access_token":"opaque","id_token":"id","expires_in":3600}`)
+	}))
+	defer server.Close()
+
+	s := &oidcSource{
+		client:       server.Client(),
+		tokenURL:     server.URL,
+		clientID:     "gateway",
+		clientSecret: "s3cr3t",
+		scopes:       []string{"openid", "orders"},
+	}
+	tk, err := s.Fetch()
+	if err != nil || tk.Value != "id" {
+		t.Fatalf("id token should be used, got %+v: %v", tk, err)
+	}
+
+	s.audience = "access"
+	tk, err = s.Fetch()
+	if err != nil || tk.Value != "opaque" {
+		t.Fatalf("access token should be used, got %+v: %v", tk, err)
+	}
+
+	s.clientSecret = "wrong"
+	if _, err = s.Fetch(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expect an unauthorized error, got %v", err)
+	}
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multipartinspector
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+type (
+	// inspection re-encodes the parts of a multipart stream into a pipe
+	// after inspecting them, so the upload is never buffered as a whole.
+	inspection struct {
+		spec    *Spec
+		scanner scanner
+
+		pr   *io.PipeReader
+		done chan struct{}
+
+		mutex     sync.Mutex
+		violation *violation
+	}
+
+	violation struct {
+		statusCode int
+		msg        string
+	}
+
+	// errWriter records the error of writing, to tell it from the
+	// error of reading when copying.
+	errWriter struct {
+		w   io.Writer
+		err error
+	}
+)
+
+var errInspectionClosed = errors.New("inspection closed")
+
+func (ew *errWriter) Write(p []byte) (int, error) {
+	n, err := ew.w.Write(p)
+	if err != nil {
+		ew.err = err
+	}
+	return n, err
+}
+
+func (v *violation) Error() string {
+	return v.msg
+}
+
+func newInspection(spec *Spec, s scanner, src io.Reader, boundary string) *inspection {
+	pr, pw := io.Pipe()
+	ins := &inspection{
+		spec:    spec,
+		scanner: s,
+		pr:      pr,
+		done:    make(chan struct{}),
+	}
+
+	go ins.run(src, boundary, pw)
+
+	return ins
+}
+
+func (ins *inspection) Read(p []byte) (int, error) {
+	return ins.pr.Read(p)
+}
+
+// close stops the inspection and returns the violation if any.
+func (ins *inspection) close() *violation {
+	ins.pr.CloseWithError(errInspectionClosed)
+	<-ins.done
+
+	ins.mutex.Lock()
+	defer ins.mutex.Unlock()
+	return ins.violation
+}
+
+func (ins *inspection) fail(pw *io.PipeWriter, statusCode int, format string, args ...interface{}) {
+	v := &violation{statusCode: statusCode, msg: fmt.Sprintf(format, args...)}
+
+	ins.mutex.Lock()
+	ins.violation = v
+	ins.mutex.Unlock()
+
+	pw.CloseWithError(v)
+}
+
+func (ins *inspection) run(src io.Reader, boundary string, pw *io.PipeWriter) {
+	defer close(ins.done)
+
+	mr := multipart.NewReader(src, boundary)
+	mw := multipart.NewWriter(pw)
+	mw.SetBoundary(boundary)
+
+	for count := uint32(1); ; count++ {
+		part, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			ins.fail(pw, http.StatusBadRequest, "invalid multipart body: %v", err)
+			return
+		}
+
+		if ins.spec.MaxParts > 0 && count > ins.spec.MaxParts {
+			ins.fail(pw, http.StatusRequestEntityTooLarge, "too many parts, max is %d", ins.spec.MaxParts)
+			return
+		}
+
+		if !ins.handlePart(part, mw, pw) {
+			return
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		pw.CloseWithError(err)
+		return
+	}
+	pw.Close()
+}
+
+// handlePart inspects and writes the part, it returns false if the
+// inspection should stop.
+func (ins *inspection) handlePart(part *multipart.Part, mw *multipart.Writer, pw *io.PipeWriter) bool {
+	isFile := part.FileName() != ""
+	contentType := part.Header.Get("Content-Type")
+
+	if isFile && !ins.allowContentType(contentType) {
+		ins.fail(pw, http.StatusUnsupportedMediaType,
+			"content type %q of file %q is not allowed", contentType, part.FileName())
+		return false
+	}
+
+	pwr, err := mw.CreatePart(part.Header)
+	if err != nil {
+		// NOTE: The pipe is closed by the reader.
+		return false
+	}
+	w := &errWriter{w: pwr}
+
+	maxSize := int64(ins.spec.MaxPartSize)
+	if isFile && ins.scanner != nil {
+		data, err := io.ReadAll(io.LimitReader(part, maxSize+1))
+		if err != nil {
+			ins.fail(pw, http.StatusBadRequest, "read part failed: %v", err)
+			return false
+		}
+		if int64(len(data)) > maxSize {
+			ins.fail(pw, http.StatusRequestEntityTooLarge,
+				"size of file %q exceeds %d bytes", part.FileName(), maxSize)
+			return false
+		}
+
+		if err := ins.scanner.scan(part.FileName(), contentType, data); err != nil {
+			var v *violation
+			if errors.As(err, &v) {
+				ins.fail(pw, v.statusCode, "%s", v.msg)
+			} else {
+				ins.fail(pw, http.StatusBadGateway, "scan file %q failed: %v", part.FileName(), err)
+			}
+			return false
+		}
+
+		_, err = io.Copy(w, bytes.NewReader(data))
+		return err == nil
+	}
+
+	var src io.Reader = part
+	if maxSize > 0 {
+		src = io.LimitReader(part, maxSize+1)
+	}
+
+	n, err := io.Copy(w, src)
+	if w.err != nil {
+		return false
+	}
+	if err != nil {
+		ins.fail(pw, http.StatusBadRequest, "read part failed: %v", err)
+		return false
+	}
+	if maxSize > 0 && n > maxSize {
+		ins.fail(pw, http.StatusRequestEntityTooLarge, "size of part %q exceeds %d bytes", part.FormName(), maxSize)
+		return false
+	}
+
+	return true
+}
+
+func (ins *inspection) allowContentType(contentType string) bool {
+	if len(ins.spec.AllowedContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range ins.spec.AllowedContentTypes {
+		if allowed == mediaType {
+			return true
+		}
+		if strings.HasSuffix(allowed, "/*") && strings.HasPrefix(mediaType, allowed[:len(allowed)-1]) {
+			return true
+		}
+	}
+
+	return false
+}
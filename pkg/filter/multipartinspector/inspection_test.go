@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multipartinspector
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+type testPart struct {
+	field, filename, contentType, content string
+}
+
+func buildMultipart(t *testing.T, parts ...testPart) ([]byte, string) {
+	buf := &bytes.Buffer{}
+	mw := multipart.NewWriter(buf)
+	for _, p := range parts {
+		h := textproto.MIMEHeader{}
+		cd := `form-data; name="` + p.field + `"`
+		if p.filename != "" {
+			cd += `; filename="` + p.filename + `"`
+		}
+		h.Set("Content-Disposition", cd)
+		if p.contentType != "" {
+			h.Set("Content-Type", p.contentType)
+		}
+		w, err := mw.CreatePart(h)
+		if err != nil {
+			t.Fatalf("create part failed: %v", err)
+		}
+		w.Write([]byte(p.content))
+	}
+	mw.Close()
+	return buf.Bytes(), mw.Boundary()
+}
+
+// inspect reads the inspected stream like a proxy, and returns the
+// stream and the violation.
+func inspect(spec *Spec, s scanner, body []byte, boundary string) ([]byte, *violation) {
+	ins := newInspection(spec, s, bytes.NewReader(body), boundary)
+	data, _ := ioutil.ReadAll(ins)
+	return data, ins.close()
+}
+
+func TestInspectionPassThrough(t *testing.T) {
+	body, boundary := buildMultipart(t,
+		testPart{field: "name", content: "hello"},
+		testPart{field: "file", filename: "a.png", contentType: "image/png", content: "png data"},
+	)
+
+	spec := &Spec{
+		MaxParts:            2,
+		MaxPartSize:         8,
+		AllowedContentTypes: []string{"image/*"},
+	}
+	data, v := inspect(spec, nil, body, boundary)
+	if v != nil {
+		t.Fatalf("unexpected violation: %v", v)
+	}
+
+	mr := multipart.NewReader(bytes.NewReader(data), boundary)
+	form, err := mr.ReadForm(1024)
+	if err != nil {
+		t.Fatalf("read form failed: %v", err)
+	}
+	if form.Value["name"][0] != "hello" {
+		t.Errorf("unexpected value: %v", form.Value)
+	}
+	f, _ := form.File["file"][0].Open()
+	content, _ := ioutil.ReadAll(f)
+	if string(content) != "png data" {
+		t.Errorf("unexpected file content: %s", content)
+	}
+}
+
+func TestInspectionViolations(t *testing.T) {
+	cases := []struct {
+		name       string
+		spec       *Spec
+		parts      []testPart
+		statusCode int
+	}{
+		{
+			name:       "too many parts",
+			spec:       &Spec{MaxParts: 1},
+			parts:      []testPart{{field: "a", content: "1"}, {field: "b", content: "2"}},
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "part too large",
+			spec:       &Spec{MaxPartSize: 4},
+			parts:      []testPart{{field: "a", content: "12345"}},
+			statusCode: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:       "content type not allowed",
+			spec:       &Spec{AllowedContentTypes: []string{"image/*", "application/pdf"}},
+			parts:      []testPart{{field: "f", filename: "a.exe", contentType: "application/octet-stream", content: "MZ"}},
+			statusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "content type missing",
+			spec:       &Spec{AllowedContentTypes: []string{"image/*"}},
+			parts:      []testPart{{field: "f", filename: "a", content: "data"}},
+			statusCode: http.StatusUnsupportedMediaType,
+		},
+	}
+
+	for _, c := range cases {
+		body, boundary := buildMultipart(t, c.parts...)
+		_, v := inspect(c.spec, nil, body, boundary)
+		if v == nil || v.statusCode != c.statusCode {
+			t.Errorf("%s: want status code %d, got %v", c.name, c.statusCode, v)
+		}
+	}
+
+	_, v := inspect(&Spec{}, nil, []byte("not a multipart body"), "boundary")
+	if v == nil || v.statusCode != http.StatusBadRequest {
+		t.Errorf("want status code 400 for malformed body, got %v", v)
+	}
+}
+
+func TestInspectionReaderClosed(t *testing.T) {
+	body, boundary := buildMultipart(t, testPart{field: "a", content: strings.Repeat("x", 1024*1024)})
+	ins := newInspection(&Spec{}, nil, bytes.NewReader(body), boundary)
+
+	// The reader gives up early, for example, the upstream is down.
+	buf := make([]byte, 10)
+	ins.Read(buf)
+	if v := ins.close(); v != nil {
+		t.Errorf("unexpected violation: %v", v)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get(headerFilename) == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.Contains(string(data), "EICAR") {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+	}))
+	defer server.Close()
+
+	spec := &Spec{
+		MaxPartSize: 1024,
+		Scanner:     &ScannerSpec{URL: server.URL, Timeout: "5s"},
+	}
+	s := newScanner(spec.Scanner)
+
+	body, boundary := buildMultipart(t,
+		testPart{field: "name", content: "EICAR in a field is not scanned"},
+		testPart{field: "file", filename: "clean.txt", contentType: "text/plain", content: "clean"},
+	)
+	if _, v := inspect(spec, s, body, boundary); v != nil {
+		t.Errorf("unexpected violation: %v", v)
+	}
+
+	body, boundary = buildMultipart(t,
+		testPart{field: "file", filename: "virus.txt", contentType: "text/plain", content: "EICAR"},
+	)
+	if _, v := inspect(spec, s, body, boundary); v == nil || v.statusCode != http.StatusForbidden {
+		t.Errorf("want status code 403, got %v", v)
+	}
+
+	spec.Scanner.URL = "http://127.0.0.1:1"
+	s = newScanner(spec.Scanner)
+	if _, v := inspect(spec, s, body, boundary); v == nil || v.statusCode != http.StatusBadGateway {
+		t.Errorf("want status code 502, got %v", v)
+	}
+}
@@ -0,0 +1,160 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multipartinspector
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of MultipartInspector.
+	Kind = "MultipartInspector"
+
+	resultInvalid = "invalid"
+)
+
+var results = []string{resultInvalid}
+
+func init() {
+	httppipeline.Register(&MultipartInspector{})
+}
+
+type (
+	// MultipartInspector inspects the parts of multipart/form-data
+	// requests while they are streamed to the following handlers.
+	MultipartInspector struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		scanner scanner
+	}
+
+	// Spec describes the MultipartInspector.
+	Spec struct {
+		// MaxParts is the max number of parts, zero means no limit.
+		MaxParts uint32 `yaml:"maxParts" jsonschema:"omitempty"`
+		// MaxPartSize is the max size of a part in bytes, zero means no limit.
+		MaxPartSize uint64 `yaml:"maxPartSize" jsonschema:"omitempty"`
+		// AllowedContentTypes are the allowed content types of file
+		// parts, a type like image/* matches all subtypes, empty means
+		// all types are allowed.
+		AllowedContentTypes []string `yaml:"allowedContentTypes" jsonschema:"omitempty,uniqueItems=true"`
+		// Scanner scans file parts before they are forwarded.
+		Scanner *ScannerSpec `yaml:"scanner,omitempty" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, ct := range spec.AllowedContentTypes {
+		if !strings.Contains(ct, "/") {
+			return fmt.Errorf("invalid content type %s", ct)
+		}
+	}
+
+	if spec.Scanner != nil && spec.MaxPartSize == 0 {
+		return fmt.Errorf("scanner requires maxPartSize, as file parts are buffered for scanning")
+	}
+
+	return nil
+}
+
+// Kind returns the kind of MultipartInspector.
+func (mi *MultipartInspector) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of MultipartInspector.
+func (mi *MultipartInspector) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of MultipartInspector.
+func (mi *MultipartInspector) Description() string {
+	return "MultipartInspector inspects the parts of multipart/form-data requests."
+}
+
+// Results returns the results of MultipartInspector.
+func (mi *MultipartInspector) Results() []string {
+	return results
+}
+
+// Init initializes MultipartInspector.
+func (mi *MultipartInspector) Init(filterSpec *httppipeline.FilterSpec) {
+	mi.filterSpec, mi.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	if mi.spec.Scanner != nil {
+		mi.scanner = newScanner(mi.spec.Scanner)
+	}
+}
+
+// Inherit inherits previous generation of MultipartInspector.
+func (mi *MultipartInspector) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	mi.Init(filterSpec)
+}
+
+// Handle replaces the request body with the inspected stream and calls
+// the next handler. If the request violates the limits, the stream is
+// aborted and the response is set according to the violation.
+func (mi *MultipartInspector) Handle(ctx context.HTTPContext) string {
+	r := ctx.Request()
+
+	mediaType, params, err := mime.ParseMediaType(r.Header().Get(httpheader.KeyContentType))
+	if err != nil || mediaType != "multipart/form-data" {
+		return ctx.CallNextHandler("")
+	}
+
+	boundary := params["boundary"]
+	if boundary == "" || r.Body() == nil {
+		ctx.Response().SetStatusCode(http.StatusBadRequest)
+		ctx.AddTag("multipartInspector: missing boundary or body")
+		return ctx.CallNextHandler(resultInvalid)
+	}
+
+	ins := newInspection(mi.spec, mi.scanner, r.Body(), boundary)
+	r.SetBody(ins)
+	// NOTE: The length changes after the parts are re-encoded.
+	r.Header().Del(httpheader.KeyContentLength)
+
+	result := ctx.CallNextHandler("")
+
+	v := ins.close()
+	if v == nil {
+		return result
+	}
+
+	ctx.Response().SetStatusCode(v.statusCode)
+	ctx.AddTag(stringtool.Cat("multipartInspector: ", v.msg))
+	return resultInvalid
+}
+
+// Status returns status.
+func (mi *MultipartInspector) Status() interface{} {
+	return nil
+}
+
+// Close closes MultipartInspector.
+func (mi *MultipartInspector) Close() {}
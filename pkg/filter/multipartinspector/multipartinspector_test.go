@@ -0,0 +1,145 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multipartinspector
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newMultipartInspector(t *testing.T) *MultipartInspector {
+	const yamlSpec = `
+kind: MultipartInspector
+name: multipart-inspector
+maxPartSize: 8
+allowedContentTypes: [image/png]
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mi := &MultipartInspector{}
+	mi.Init(spec)
+	return mi
+}
+
+func newContext(contentType string, body []byte) (*contexttest.MockedHTTPContext, *[]byte, *int) {
+	ctx := &contexttest.MockedHTTPContext{}
+	header := httpheader.New(http.Header{})
+	header.Set(httpheader.KeyContentType, contentType)
+	header.Set(httpheader.KeyContentLength, "100")
+
+	var reqBody io.Reader = bytes.NewReader(body)
+	forwarded := []byte{}
+	statusCode := http.StatusOK
+
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return reqBody }
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) { reqBody = body }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if lastResult != "" {
+			return lastResult
+		}
+		// Simulates the proxy.
+		data, err := ioutil.ReadAll(reqBody)
+		forwarded = data
+		if err != nil {
+			statusCode = http.StatusServiceUnavailable
+			return "serverError"
+		}
+		return ""
+	}
+
+	return ctx, &forwarded, &statusCode
+}
+
+func TestMultipartInspector(t *testing.T) {
+	mi := newMultipartInspector(t)
+
+	body, boundary := buildMultipart(t,
+		testPart{field: "file", filename: "a.png", contentType: "image/png", content: "png"},
+	)
+	ctx, forwarded, statusCode := newContext("multipart/form-data; boundary="+boundary, body)
+	if result := mi.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result: %s", result)
+	}
+	if !bytes.Equal(*forwarded, body) {
+		t.Errorf("body should be forwarded as is")
+	}
+	if ctx.Request().Header().Get(httpheader.KeyContentLength) != "" {
+		t.Errorf("content length should be removed")
+	}
+	if *statusCode != http.StatusOK {
+		t.Errorf("unexpected status code %d", *statusCode)
+	}
+
+	body, boundary = buildMultipart(t,
+		testPart{field: "file", filename: "a.gif", contentType: "image/gif", content: "gif"},
+	)
+	ctx, _, statusCode = newContext("multipart/form-data; boundary="+boundary, body)
+	if result := mi.Handle(ctx); result != resultInvalid {
+		t.Errorf("want result invalid, got %s", result)
+	}
+	if *statusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("want status code 415, got %d", *statusCode)
+	}
+
+	ctx, _, statusCode = newContext("multipart/form-data", body)
+	if result := mi.Handle(ctx); result != resultInvalid || *statusCode != http.StatusBadRequest {
+		t.Errorf("request without boundary should be invalid")
+	}
+
+	// Non-multipart requests are not inspected.
+	ctx, forwarded, _ = newContext("application/json", []byte("{}"))
+	if result := mi.Handle(ctx); result != "" || string(*forwarded) != "{}" {
+		t.Errorf("non-multipart request should pass through")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{AllowedContentTypes: []string{"image"}}
+	if spec.Validate() == nil {
+		t.Errorf("invalid content type should fail")
+	}
+
+	spec = &Spec{Scanner: &ScannerSpec{URL: "http://127.0.0.1"}}
+	if spec.Validate() == nil {
+		t.Errorf("scanner without maxPartSize should fail")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package multipartinspector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const defaultScanTimeout = 30 * time.Second
+
+type (
+	// ScannerSpec describes the scanning service for file parts.
+	ScannerSpec struct {
+		// URL is the URL of the scanning service, the content of a file
+		// part is sent to it by a POST request, and the file is clean if
+		// the status code of the response is 2xx.
+		URL string `yaml:"url" jsonschema:"required,format=uri"`
+		// Timeout is the timeout of scanning a file.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// scanner scans the content of a file, it returns a violation if
+	// the file is rejected, or other errors if it fails to scan.
+	scanner interface {
+		scan(filename, contentType string, data []byte) error
+	}
+
+	httpScanner struct {
+		spec    *ScannerSpec
+		timeout time.Duration
+		client  *http.Client
+	}
+)
+
+const (
+	headerFilename = "X-EG-Filename"
+)
+
+func newScanner(spec *ScannerSpec) scanner {
+	timeout := defaultScanTimeout
+	if spec.Timeout != "" {
+		if d, err := time.ParseDuration(spec.Timeout); err == nil {
+			timeout = d
+		}
+	}
+
+	return &httpScanner{
+		spec:    spec,
+		timeout: timeout,
+		client:  &http.Client{},
+	}
+}
+
+func (s *httpScanner) scan(filename, contentType string, data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.spec.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set(headerFilename, filename)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	return &violation{
+		statusCode: http.StatusForbidden,
+		msg:        fmt.Sprintf("file %q is rejected by scanner with status code %d", filename, resp.StatusCode),
+	}
+}
@@ -0,0 +1,475 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsbridge
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPort = "4222"
+
+	dialTimeout = 5 * time.Second
+
+	// maxControlLine is the max length of protocol lines except payloads,
+	// the INFO could be long as it contains the URLs of the cluster.
+	maxControlLine = 32 * 1024
+
+	// inboxSID is the subscription ID of the reply inbox.
+	inboxSID = "1"
+)
+
+var (
+	errTimeout  = errors.New("timeout waiting for reply")
+	errClosed   = errors.New("connection closed")
+	errCanceled = errors.New("request canceled")
+)
+
+type (
+	// client is a minimal NATS client, it supports publishing messages
+	// and the request-reply pattern.
+	// Reference: https://docs.nats.io/reference/reference-protocols/nats-protocol
+	client struct {
+		name     string
+		urls     []*url.URL
+		username string
+		password string
+		token    string
+
+		mutex  sync.Mutex
+		next   int
+		conn   *conn
+		closed bool
+	}
+
+	// serverInfo is the INFO of the NATS server.
+	serverInfo struct {
+		ServerID     string `json:"server_id"`
+		MaxPayload   int64  `json:"max_payload"`
+		TLSRequired  bool   `json:"tls_required"`
+		AuthRequired bool   `json:"auth_required"`
+	}
+
+	connectOptions struct {
+		Verbose   bool   `json:"verbose"`
+		Pedantic  bool   `json:"pedantic"`
+		Name      string `json:"name"`
+		Lang      string `json:"lang"`
+		Version   string `json:"version"`
+		Protocol  int    `json:"protocol"`
+		User      string `json:"user,omitempty"`
+		Pass      string `json:"pass,omitempty"`
+		AuthToken string `json:"auth_token,omitempty"`
+	}
+
+	// conn is a connection to a NATS server, replies of all requests are
+	// received by a wildcard subscription of the inbox.
+	conn struct {
+		c    net.Conn
+		r    *bufio.Reader
+		info serverInfo
+
+		writeMutex sync.Mutex
+		w          *bufio.Writer
+
+		inbox   string
+		mutex   sync.Mutex
+		nextID  uint64
+		replies map[string]chan []byte
+
+		done chan struct{}
+		err  error
+	}
+)
+
+func newClient(name string, urls []string, username, password, token string) (*client, error) {
+	c := &client{name: name, username: username, password: password, token: token}
+	for _, s := range urls {
+		u, err := parseURL(s)
+		if err != nil {
+			return nil, err
+		}
+		c.urls = append(c.urls, u)
+	}
+	return c, nil
+}
+
+// parseURL parses the server URL, the scheme could be nats or tls.
+func parseURL(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "nats://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url %s: %v", s, err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("invalid url %s: unsupported scheme %s", s, u.Scheme)
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return u, nil
+}
+
+// getConn returns the current connection, or connects to the servers in
+// turn if there's none.
+func (c *client) getConn() (*conn, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.closed {
+		return nil, errClosed
+	}
+	if c.conn != nil && !c.conn.isClosed() {
+		return c.conn, nil
+	}
+
+	var lastErr error
+	for range c.urls {
+		u := c.urls[c.next]
+		c.next = (c.next + 1) % len(c.urls)
+
+		conn, err := c.connect(u)
+		if err == nil {
+			c.conn = conn
+			return conn, nil
+		}
+		lastErr = fmt.Errorf("connect %s failed: %v", u.Host, err)
+	}
+	return nil, lastErr
+}
+
+func (c *client) connect(u *url.URL) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", u.Host, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := c.handshake(nc, u)
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+
+	go conn.readLoop()
+	return conn, nil
+}
+
+// handshake reads the INFO, upgrades to TLS if required, sends CONNECT
+// and makes sure it is accepted by PING/PONG, then subscribes the inbox.
+func (c *client) handshake(nc net.Conn, u *url.URL) (*conn, error) {
+	nc.SetDeadline(time.Now().Add(dialTimeout))
+
+	conn := &conn{
+		c:       nc,
+		r:       bufio.NewReaderSize(nc, maxControlLine),
+		replies: map[string]chan []byte{},
+		done:    make(chan struct{}),
+	}
+
+	line, err := conn.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		return nil, fmt.Errorf("unexpected greeting: %s", line)
+	}
+	if err := json.Unmarshal([]byte(line[5:]), &conn.info); err != nil {
+		return nil, fmt.Errorf("invalid INFO: %v", err)
+	}
+
+	if u.Scheme == "tls" || conn.info.TLSRequired {
+		tc := tls.Client(nc, &tls.Config{ServerName: u.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			return nil, fmt.Errorf("tls handshake failed: %v", err)
+		}
+		conn.c, conn.r = tc, bufio.NewReaderSize(tc, maxControlLine)
+	}
+	conn.w = bufio.NewWriter(conn.c)
+
+	opts := &connectOptions{
+		Name:      c.name,
+		Lang:      "go",
+		Version:   "1.0.0",
+		Protocol:  1,
+		User:      c.username,
+		Pass:      c.password,
+		AuthToken: c.token,
+	}
+	if u.User != nil && opts.User == "" && opts.AuthToken == "" {
+		if password, ok := u.User.Password(); ok {
+			opts.User, opts.Pass = u.User.Username(), password
+		} else {
+			opts.AuthToken = u.User.Username()
+		}
+	}
+	data, _ := json.Marshal(opts)
+
+	conn.inbox = "_INBOX." + randomToken() + "."
+	fmt.Fprintf(conn.w, "CONNECT %s\r\nPING\r\n", data)
+	if err := conn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	for {
+		line, err := conn.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line == "+OK" {
+			continue
+		}
+		if line == "PONG" {
+			break
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			return nil, fmt.Errorf("server error: %s", strings.TrimSpace(line[4:]))
+		}
+		return nil, fmt.Errorf("unexpected response: %s", line)
+	}
+
+	fmt.Fprintf(conn.w, "SUB %s* %s\r\n", conn.inbox, inboxSID)
+	if err := conn.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	nc.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// publish publishes the message to the subject.
+func (c *client) publish(subject string, data []byte) error {
+	conn, err := c.getConn()
+	if err != nil {
+		return err
+	}
+	return conn.publish(subject, "", data)
+}
+
+// request publishes the message with a reply subject in the inbox, and
+// waits for the reply until timeout or cancel is closed.
+func (c *client) request(subject string, data []byte, timeout time.Duration, cancel <-chan struct{}) ([]byte, error) {
+	conn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+
+	id, ch := conn.newReply()
+	defer conn.removeReply(id)
+
+	if err := conn.publish(subject, conn.inbox+id, data); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-ch:
+		return reply, nil
+	case <-timer.C:
+		return nil, errTimeout
+	case <-cancel:
+		return nil, errCanceled
+	case <-conn.done:
+		return nil, conn.err
+	}
+}
+
+// maxPayload returns the max payload of the current connection, zero
+// means unknown.
+func (c *client) maxPayload() int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.conn == nil {
+		return 0
+	}
+	return c.conn.info.MaxPayload
+}
+
+func (c *client) close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.closed = true
+	if c.conn != nil {
+		c.conn.close(errClosed)
+		c.conn = nil
+	}
+}
+
+func randomToken() string {
+	b := make([]byte, 11)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (conn *conn) readLine() (string, error) {
+	line, err := conn.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("protocol line too long")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+func (conn *conn) publish(subject, reply string, data []byte) error {
+	if max := conn.info.MaxPayload; max > 0 && int64(len(data)) > max {
+		return fmt.Errorf("payload exceeds the max payload %d of the server", max)
+	}
+
+	conn.writeMutex.Lock()
+	defer conn.writeMutex.Unlock()
+
+	if reply == "" {
+		fmt.Fprintf(conn.w, "PUB %s %d\r\n", subject, len(data))
+	} else {
+		fmt.Fprintf(conn.w, "PUB %s %s %d\r\n", subject, reply, len(data))
+	}
+	conn.w.Write(data)
+	conn.w.WriteString("\r\n")
+
+	if err := conn.w.Flush(); err != nil {
+		conn.close(err)
+		return err
+	}
+	return nil
+}
+
+func (conn *conn) newReply() (string, chan []byte) {
+	ch := make(chan []byte, 1)
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.nextID++
+	id := strconv.FormatUint(conn.nextID, 36)
+	conn.replies[id] = ch
+	return id, ch
+}
+
+func (conn *conn) removeReply(id string) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	delete(conn.replies, id)
+}
+
+// readLoop reads the messages from the server, until the connection is
+// broken or closed.
+func (conn *conn) readLoop() {
+	for {
+		line, err := conn.readLine()
+		if err != nil {
+			conn.close(err)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(line, "MSG "):
+			if err := conn.handleMsg(line); err != nil {
+				conn.close(err)
+				return
+			}
+		case line == "PING":
+			conn.writeMutex.Lock()
+			conn.w.WriteString("PONG\r\n")
+			err = conn.w.Flush()
+			conn.writeMutex.Unlock()
+			if err != nil {
+				conn.close(err)
+				return
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			// NOTE: The server closes the connection after most errors,
+			// the connection is reestablished by the next request then.
+			conn.close(fmt.Errorf("server error: %s", strings.TrimSpace(line[4:])))
+			return
+		}
+		// INFO, PONG and +OK are ignored.
+	}
+}
+
+// handleMsg handles: MSG <subject> <sid> [reply-to] <#bytes>.
+func (conn *conn) handleMsg(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) != 4 && len(fields) != 5 {
+		return fmt.Errorf("invalid MSG: %s", line)
+	}
+
+	size, err := strconv.Atoi(fields[len(fields)-1])
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid MSG: %s", line)
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(conn.r, data); err != nil {
+		return err
+	}
+	data = data[:size]
+
+	id := strings.TrimPrefix(fields[1], conn.inbox)
+	conn.mutex.Lock()
+	ch := conn.replies[id]
+	conn.mutex.Unlock()
+
+	if ch != nil {
+		select {
+		case ch <- data:
+		default:
+			// Only the first reply is used.
+		}
+	}
+	return nil
+}
+
+func (conn *conn) isClosed() bool {
+	select {
+	case <-conn.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (conn *conn) close(err error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	if conn.isClosed() {
+		return
+	}
+	conn.err = err
+	close(conn.done)
+	conn.c.Close()
+}
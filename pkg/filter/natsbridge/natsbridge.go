@@ -0,0 +1,261 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsbridge
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of NATSBridge.
+	Kind = "NATSBridge"
+
+	resultInvalid = "invalid"
+	resultFailure = "failure"
+	resultTimeout = "timeout"
+
+	// defaultMaxPayload is the default max payload of NATS servers, it
+	// is used before the max payload of the server is known.
+	defaultMaxPayload = 1024 * 1024
+
+	defaultTimeout = 5 * time.Second
+
+	noValue = "<no value>"
+)
+
+var results = []string{resultInvalid, resultFailure, resultTimeout}
+
+func init() {
+	httppipeline.Register(&NATSBridge{})
+}
+
+type (
+	// NATSBridge publishes request payloads to NATS subjects, and
+	// optionally returns the replies to the clients.
+	NATSBridge struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		subject *template.Template
+		timeout time.Duration
+		client  *client
+	}
+
+	// Spec describes the NATSBridge.
+	Spec struct {
+		URLs     []string `yaml:"urls" jsonschema:"required,minItems=1"`
+		Username string   `yaml:"username" jsonschema:"omitempty"`
+		Password string   `yaml:"password" jsonschema:"omitempty"`
+		Token    string   `yaml:"token" jsonschema:"omitempty"`
+
+		// Subject is the template of the subject to publish to.
+		Subject string `yaml:"subject" jsonschema:"required"`
+		// RequestReply waits for the reply of the message, and returns
+		// it to the client, Timeout is the max time to wait.
+		RequestReply bool   `yaml:"requestReply" jsonschema:"omitempty"`
+		Timeout      string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, u := range spec.URLs {
+		if _, err := parseURL(u); err != nil {
+			return err
+		}
+	}
+
+	if spec.Token != "" && (spec.Username != "" || spec.Password != "") {
+		return fmt.Errorf("token and username/password are exclusive")
+	}
+
+	if _, err := newSubjectTemplate(spec.Subject); err != nil {
+		return fmt.Errorf("invalid subject: %v", err)
+	}
+
+	if spec.Timeout != "" && !spec.RequestReply {
+		return fmt.Errorf("timeout requires requestReply")
+	}
+
+	return nil
+}
+
+func newSubjectTemplate(subject string) (*template.Template, error) {
+	return template.New("subject").Option("missingkey=zero").Parse(subject)
+}
+
+// Kind returns the kind of NATSBridge.
+func (nb *NATSBridge) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of NATSBridge.
+func (nb *NATSBridge) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of NATSBridge.
+func (nb *NATSBridge) Description() string {
+	return "NATSBridge publishes request payloads to NATS subjects."
+}
+
+// Results returns the results of NATSBridge.
+func (nb *NATSBridge) Results() []string {
+	return results
+}
+
+// Init initializes NATSBridge.
+func (nb *NATSBridge) Init(filterSpec *httppipeline.FilterSpec) {
+	nb.filterSpec, nb.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	nb.reload()
+}
+
+// Inherit inherits previous generation of NATSBridge.
+func (nb *NATSBridge) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	nb.Init(filterSpec)
+}
+
+func (nb *NATSBridge) reload() {
+	// NOTE: The spec has been validated.
+	nb.subject, _ = newSubjectTemplate(nb.spec.Subject)
+
+	nb.timeout = defaultTimeout
+	if nb.spec.Timeout != "" {
+		nb.timeout, _ = time.ParseDuration(nb.spec.Timeout)
+	}
+
+	// The client connects to the servers on the first request.
+	nb.client, _ = newClient("easegress-"+nb.filterSpec.Name(), nb.spec.URLs,
+		nb.spec.Username, nb.spec.Password, nb.spec.Token)
+}
+
+// Handle publishes the request payload, and sets the response.
+func (nb *NATSBridge) Handle(ctx context.HTTPContext) string {
+	result := nb.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (nb *NATSBridge) handle(ctx context.HTTPContext) string {
+	w := ctx.Response()
+
+	subject := nb.renderSubject(ctx)
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		w.SetStatusCode(http.StatusBadRequest)
+		ctx.AddTag(fmt.Sprintf("natsBridge: invalid subject %q", subject))
+		return resultInvalid
+	}
+
+	data, err := nb.readBody(ctx)
+	if err != nil {
+		w.SetStatusCode(http.StatusRequestEntityTooLarge)
+		ctx.AddTag(fmt.Sprintf("natsBridge: %v", err))
+		return resultInvalid
+	}
+
+	if !nb.spec.RequestReply {
+		if err := nb.client.publish(subject, data); err != nil {
+			logger.Warnf("%s: publish to %s failed: %v", nb.filterSpec.Name(), subject, err)
+			w.SetStatusCode(http.StatusServiceUnavailable)
+			ctx.AddTag(fmt.Sprintf("natsBridge: publish failed: %v", err))
+			return resultFailure
+		}
+		w.SetStatusCode(http.StatusAccepted)
+		return ""
+	}
+
+	reply, err := nb.client.request(subject, data, nb.timeout, ctx.Done())
+	if err == errTimeout {
+		w.SetStatusCode(http.StatusGatewayTimeout)
+		ctx.AddTag("natsBridge: timeout waiting for reply")
+		return resultTimeout
+	}
+	if err != nil {
+		logger.Warnf("%s: request to %s failed: %v", nb.filterSpec.Name(), subject, err)
+		w.SetStatusCode(http.StatusServiceUnavailable)
+		ctx.AddTag(fmt.Sprintf("natsBridge: request failed: %v", err))
+		return resultFailure
+	}
+
+	w.SetStatusCode(http.StatusOK)
+	w.SetBody(bytes.NewReader(reply))
+	return ""
+}
+
+// renderSubject renders the subject of the request, the subject is empty
+// if the template fails.
+func (nb *NATSBridge) renderSubject(ctx context.HTTPContext) string {
+	r := ctx.Request()
+	data := map[string]interface{}{
+		"jwt":    ctx.GetKV(context.KeyJWTClaims),
+		"realIP": r.RealIP(),
+		"method": r.Method(),
+		"host":   r.Host(),
+		"path":   r.Path(),
+		"header": r.Header().Std(),
+	}
+
+	var sb strings.Builder
+	if err := nb.subject.Execute(&sb, data); err != nil {
+		return ""
+	}
+	return strings.ReplaceAll(sb.String(), noValue, "")
+}
+
+// readBody reads the whole request body, it fails if the body exceeds
+// the max payload of the server.
+func (nb *NATSBridge) readBody(ctx context.HTTPContext) ([]byte, error) {
+	body := ctx.Request().Body()
+	if body == nil {
+		return nil, nil
+	}
+
+	maxPayload := nb.client.maxPayload()
+	if maxPayload <= 0 {
+		maxPayload = defaultMaxPayload
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxPayload+1))
+	if err != nil {
+		return nil, fmt.Errorf("read body failed: %v", err)
+	}
+	if int64(len(data)) > maxPayload {
+		return nil, fmt.Errorf("body exceeds the max payload %d", maxPayload)
+	}
+	return data, nil
+}
+
+// Status returns status.
+func (nb *NATSBridge) Status() interface{} {
+	return nil
+}
+
+// Close closes NATSBridge.
+func (nb *NATSBridge) Close() {
+	nb.client.close()
+}
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package natsbridge
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newNATSBridge(t *testing.T, yamlSpec string) *NATSBridge {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	nb := &NATSBridge{}
+	nb.Init(spec)
+	return nb
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	statusCode int
+	respBody   io.Reader
+}
+
+func newContext(path, body string) *mockedContext {
+	ctx := &mockedContext{MockedHTTPContext: &contexttest.MockedHTTPContext{}}
+
+	header := httpheader.New(http.Header{})
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return strings.NewReader(body) }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { ctx.respBody = body }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	return ctx
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec  Spec
+		valid bool
+	}{
+		{Spec{URLs: []string{"127.0.0.1:4222"}, Subject: "orders"}, true},
+		{Spec{URLs: []string{"http://127.0.0.1"}, Subject: "orders"}, false},
+		{Spec{URLs: []string{"127.0.0.1"}, Subject: "orders", Token: "t", Username: "u"}, false},
+		{Spec{URLs: []string{"127.0.0.1"}, Subject: "{{.path"}, false},
+		{Spec{URLs: []string{"127.0.0.1"}, Subject: "orders", Timeout: "1s"}, false},
+		{Spec{URLs: []string{"127.0.0.1"}, Subject: "orders", Timeout: "1s", RequestReply: true}, true},
+	}
+
+	for i, c := range cases {
+		err := c.spec.Validate()
+		if c.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("case %d: should be invalid", i)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	s := newFakeServer(t, "")
+	defer s.close()
+
+	nb := newNATSBridge(t, `
+kind: NATSBridge
+name: nats
+urls: [`+s.url()+`]
+subject: "events.{{index .header \"X-Type\" 0}}"
+`)
+	defer nb.Close()
+
+	ctx := newContext("/orders", "hello")
+	ctx.Request().Header().Set("X-Type", "orders")
+	if result := nb.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if ctx.statusCode != http.StatusAccepted {
+		t.Errorf("unexpected status code %d", ctx.statusCode)
+	}
+
+	// The subject is empty without the header.
+	ctx = newContext("/orders", "hello")
+	if result := nb.Handle(ctx); result != resultInvalid {
+		t.Errorf("unexpected result %s", result)
+	}
+
+	ctx = newContext("/orders", strings.Repeat("a", 1025))
+	ctx.Request().Header().Set("X-Type", "orders")
+	if result := nb.Handle(ctx); result != resultInvalid {
+		t.Errorf("body exceeding max payload should be invalid, got %s", result)
+	}
+	if ctx.statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("unexpected status code %d", ctx.statusCode)
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	s := newFakeServer(t, "")
+	defer s.close()
+
+	nb := newNATSBridge(t, `
+kind: NATSBridge
+name: nats
+urls: [`+s.url()+`]
+subject: echo
+requestReply: true
+timeout: 100ms
+`)
+	defer nb.Close()
+
+	ctx := newContext("/echo", "ping")
+	if result := nb.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if ctx.statusCode != http.StatusOK {
+		t.Errorf("unexpected status code %d", ctx.statusCode)
+	}
+	if data, _ := ioutil.ReadAll(ctx.respBody); string(data) != "ping" {
+		t.Errorf("unexpected reply %s", data)
+	}
+
+	nb.subject, _ = newSubjectTemplate("silent")
+	ctx = newContext("/silent", "ping")
+	if result := nb.Handle(ctx); result != resultTimeout {
+		t.Errorf("unexpected result %s", result)
+	}
+	if ctx.statusCode != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code %d", ctx.statusCode)
+	}
+
+	s.close()
+	nb.client.close()
+	nb.client, _ = newClient("test", []string{s.url()}, "", "", "")
+	ctx = newContext("/echo", "ping")
+	if result := nb.Handle(ctx); result != resultFailure {
+		t.Errorf("unexpected result %s", result)
+	}
+	if ctx.statusCode != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status code %d", ctx.statusCode)
+	}
+}
@@ -0,0 +1,258 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth2client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+	"github.com/megaease/easegress/pkg/util/tokencache"
+)
+
+const (
+	// Kind is the kind of OAuth2Client.
+	Kind = "OAuth2Client"
+
+	resultTokenFailed = "tokenFailed"
+
+	authStyleHeader = "header"
+	authStyleParams = "params"
+
+	defaultHeader = "Authorization"
+
+	fetchTimeout = 10 * time.Second
+)
+
+var results = []string{resultTokenFailed}
+
+func init() {
+	httppipeline.Register(&OAuth2Client{})
+}
+
+type (
+	// OAuth2Client obtains access tokens from the authorization server
+	// with the client credentials grant, and injects them into requests,
+	// so the gateway authenticates to upstreams on behalf of clients.
+	OAuth2Client struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		tokens *tokencache.Cache
+	}
+
+	// Spec describes the OAuth2Client.
+	Spec struct {
+		TokenURL     string   `yaml:"tokenURL" jsonschema:"required,format=uri"`
+		ClientID     string   `yaml:"clientID" jsonschema:"required"`
+		ClientSecret string   `yaml:"clientSecret" jsonschema:"required"`
+		Scopes       []string `yaml:"scopes" jsonschema:"omitempty"`
+		Audience     string   `yaml:"audience" jsonschema:"omitempty"`
+		// Params are the extra parameters of token requests, e.g.
+		// resource.
+		Params map[string]string `yaml:"params" jsonschema:"omitempty"`
+		// AuthStyle is how the client authenticates to the authorization
+		// server, header is the HTTP Basic authentication, params is to
+		// put the client ID and secret into the request body.
+		AuthStyle string `yaml:"authStyle" jsonschema:"omitempty,enum=,enum=header,enum=params"`
+		// Header is the header to carry the token.
+		Header string `yaml:"header" jsonschema:"omitempty"`
+	}
+
+	// source fetches access tokens with the client credentials grant.
+	source struct {
+		client *http.Client
+		spec   *Spec
+	}
+
+	tokenResponse struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for k := range spec.Params {
+		switch k {
+		case "grant_type", "client_id", "client_secret", "scope", "audience":
+			return fmt.Errorf("param %s is managed by the filter", k)
+		}
+	}
+	return nil
+}
+
+// sameTokenRequest reports whether the token requests of the two specs
+// are the same, i.e. the specs differ in the header only.
+func (spec *Spec) sameTokenRequest(other *Spec) bool {
+	a, b := *spec, *other
+	a.Header, b.Header = "", ""
+	return reflect.DeepEqual(&a, &b)
+}
+
+// Kind returns the kind of OAuth2Client.
+func (oc *OAuth2Client) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of OAuth2Client.
+func (oc *OAuth2Client) DefaultSpec() interface{} {
+	return &Spec{
+		AuthStyle: authStyleHeader,
+		Header:    defaultHeader,
+	}
+}
+
+// Description returns the description of OAuth2Client.
+func (oc *OAuth2Client) Description() string {
+	return "OAuth2Client injects access tokens of the client credentials grant into requests."
+}
+
+// Results returns the results of OAuth2Client.
+func (oc *OAuth2Client) Results() []string {
+	return results
+}
+
+// Init initializes OAuth2Client.
+func (oc *OAuth2Client) Init(filterSpec *httppipeline.FilterSpec) {
+	oc.filterSpec, oc.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	oc.reload()
+}
+
+// Inherit inherits previous generation of OAuth2Client.
+func (oc *OAuth2Client) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+
+	// NOTE: The token is kept if the token requests are not changed, so
+	// reloading the pipeline doesn't hit the authorization server.
+	previous := previousGeneration.(*OAuth2Client)
+	spec := filterSpec.FilterSpec().(*Spec)
+	if spec.sameTokenRequest(previous.spec) {
+		oc.filterSpec, oc.spec = filterSpec, spec
+		oc.tokens = previous.tokens
+		return
+	}
+
+	oc.Init(filterSpec)
+}
+
+func (oc *OAuth2Client) reload() {
+	oc.tokens = tokencache.New(&source{
+		client: &http.Client{Timeout: fetchTimeout, Transport: egressproxy.DefaultTransport},
+		spec:   oc.spec,
+	})
+}
+
+// Handle injects the access token into the request.
+func (oc *OAuth2Client) Handle(ctx context.HTTPContext) string {
+	token, result := oc.handle(ctx)
+	result = ctx.CallNextHandler(result)
+
+	// The token is rejected by the upstream, e.g. it is revoked, so a
+	// new one is fetched for the next request.
+	if token != "" && ctx.Response().StatusCode() == http.StatusUnauthorized {
+		oc.tokens.Invalidate(token)
+	}
+	return result
+}
+
+func (oc *OAuth2Client) handle(ctx context.HTTPContext) (string, string) {
+	token, err := oc.tokens.Get()
+	if err != nil {
+		logger.Errorf("%s: get token failed: %v", oc.filterSpec.Name(), err)
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		ctx.AddTag(fmt.Sprintf("oauth2Client: %v", err))
+		return "", resultTokenFailed
+	}
+
+	header := oc.spec.Header
+	if header == "" {
+		header = defaultHeader
+	}
+	ctx.Request().Header().Set(header, "Bearer "+token)
+	return token, ""
+}
+
+// Status returns status.
+func (oc *OAuth2Client) Status() interface{} {
+	return nil
+}
+
+// Close closes OAuth2Client.
+func (oc *OAuth2Client) Close() {}
+
+// Fetch implements tokencache.Source.
+func (s *source) Fetch() (*tokencache.Token, error) {
+	form := url.Values{}
+	for k, v := range s.spec.Params {
+		form.Set(k, v)
+	}
+	form.Set("grant_type", "client_credentials")
+	if s.spec.Audience != "" {
+		form.Set("audience", s.spec.Audience)
+	}
+	if len(s.spec.Scopes) != 0 {
+		form.Set("scope", strings.Join(s.spec.Scopes, " "))
+	}
+	if s.spec.AuthStyle == authStyleParams {
+		form.Set("client_id", s.spec.ClientID)
+		form.Set("client_secret", s.spec.ClientSecret)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.spec.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.spec.AuthStyle != authStyleParams {
+		// RFC 6749 2.3.1: the client ID and secret are form encoded
+		// before being used as the user name and password.
+		req.SetBasicAuth(url.QueryEscape(s.spec.ClientID), url.QueryEscape(s.spec.ClientSecret))
+	}
+
+	body, err := tokencache.Do(s.client, req)
+	if err != nil {
+		return nil, fmt.Errorf("fetch token from %s failed: %v", s.spec.TokenURL, err)
+	}
+
+	resp := &tokenResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("invalid token response: %v", err)
+	}
+	if resp.AccessToken == "" {
+		return nil, fmt.Errorf("invalid token response: no access token")
+	}
+	if resp.TokenType != "" && !strings.EqualFold(resp.TokenType, "bearer") {
+		return nil, fmt.Errorf("unsupported token type %s", resp.TokenType)
+	}
+
+	return &tokencache.Token{
+		Value:  resp.AccessToken,
+		Expiry: tokencache.Expiry(resp.AccessToken, resp.ExpiresIn),
+	}, nil
+}
@@ -0,0 +1,222 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oauth2client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newFilterSpec(t *testing.T, yamlSpec string) *httppipeline.FilterSpec {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return spec
+}
+
+func newOAuth2Client(t *testing.T, yamlSpec string) *OAuth2Client {
+	oc := &OAuth2Client{}
+	oc.Init(newFilterSpec(t, yamlSpec))
+	return oc
+}
+
+// newAuthServer creates an authorization server issuing tokens numbered
+// by the count of token requests.
+func newAuthServer(count *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		id, secret, ok := r.BasicAuth()
+		if !ok {
+			id, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+		}
+		if id != "gateway" || secret != "s3cr3t" || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":"invalid_client"}`)
+			return
+		}
+		if r.PostForm.Get("scope") != "orders.read orders.write" || r.PostForm.Get("resource") != "orders" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_scope"}`)
+			return
+		}
+		*count++
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, *count)
+	}))
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{Params: map[string]string{"resource": "orders"}}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	spec.Params["client_id"] = "other"
+	if err := spec.Validate(); err == nil {
+		t.Errorf("client_id should not be in params")
+	}
+}
+
+func TestFetch(t *testing.T) {
+	count := 0
+	server := newAuthServer(&count)
+	defer server.Close()
+
+	spec := &Spec{
+		TokenURL:     server.URL,
+		ClientID:     "gateway",
+		ClientSecret: "s3cr3t",
+		Scopes:       []string{"orders.read", "orders.write"},
+		Params:       map[string]string{"resource": "orders"},
+	}
+	s := &source{client: server.Client(), spec: spec}
+
+	for _, style := range []string{"", authStyleHeader, authStyleParams} {
+		spec.AuthStyle = style
+		tk, err := s.Fetch()
+		if err != nil {
+			t.Fatalf("auth style %q: unexpected error: %v", style, err)
+		}
+		if tk.Value != fmt.Sprintf("token-%d", count) {
+			t.Errorf("unexpected token %s", tk.Value)
+		}
+	}
+
+	spec.ClientSecret = "wrong"
+	if _, err := s.Fetch(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("expect an unauthorized error, got %v", err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	count := 0
+	server := newAuthServer(&count)
+	defer server.Close()
+
+	oc := newOAuth2Client(t, `
+kind: OAuth2Client
+name: oauth2-client
+tokenURL: `+server.URL+`
+clientID: gateway
+clientSecret: s3cr3t
+authStyle: params
+scopes: [orders.read, orders.write]
+params:
+  resource: orders
+`)
+
+	header := httpheader.New(http.Header{})
+	upstreamStatus := http.StatusOK
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedResponse.MockedStatusCode = func() int { return upstreamStatus }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	for i := 0; i < 2; i++ {
+		if result := oc.Handle(ctx); result != "" {
+			t.Fatalf("unexpected result %s", result)
+		}
+		if header.Get("Authorization") != "Bearer token-1" {
+			t.Errorf("unexpected authorization %s", header.Get("Authorization"))
+		}
+	}
+
+	// The token rejected by the upstream is refreshed.
+	upstreamStatus = http.StatusUnauthorized
+	oc.Handle(ctx)
+	upstreamStatus = http.StatusOK
+	oc.Handle(ctx)
+	if header.Get("Authorization") != "Bearer token-2" {
+		t.Errorf("unexpected authorization %s", header.Get("Authorization"))
+	}
+
+	statusCode := 0
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+	oc.spec.ClientSecret = "wrong"
+	oc.reload()
+	if result := oc.Handle(ctx); result != resultTokenFailed {
+		t.Errorf("unexpected result %s", result)
+	}
+	if statusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected status code %d", statusCode)
+	}
+}
+
+func TestInherit(t *testing.T) {
+	count := 0
+	server := newAuthServer(&count)
+	defer server.Close()
+
+	yamlSpec := `
+kind: OAuth2Client
+name: oauth2-client
+tokenURL: ` + server.URL + `
+clientID: gateway
+clientSecret: %s
+scopes: [orders.read, orders.write]
+params:
+  resource: orders
+header: %s
+`
+	oc := newOAuth2Client(t, fmt.Sprintf(yamlSpec, "s3cr3t", "Authorization"))
+
+	header := httpheader.New(http.Header{})
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+	oc.Handle(ctx)
+
+	// The token is kept if only the header is changed.
+	oc2 := &OAuth2Client{}
+	oc2.Inherit(newFilterSpec(t, fmt.Sprintf(yamlSpec, "s3cr3t", "X-Token")), oc)
+	if result := oc2.Handle(ctx); result != "" || header.Get("X-Token") != "Bearer token-1" {
+		t.Errorf("unexpected result %s, headers %v", result, header.Std())
+	}
+	if count != 1 {
+		t.Errorf("token should be kept, fetched %d times", count)
+	}
+
+	// A new token is fetched if the client is changed.
+	oc3 := &OAuth2Client{}
+	oc3.Inherit(newFilterSpec(t, fmt.Sprintf(yamlSpec, "wrong", "X-Token")), oc2)
+	if oc3.tokens == oc2.tokens {
+		t.Errorf("token cache should be rebuilt")
+	}
+	if result := oc3.Handle(ctx); result != resultTokenFailed {
+		t.Errorf("unexpected result %s", result)
+	}
+}
@@ -0,0 +1,386 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/sessionstore"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+)
+
+const (
+	// Kind is the kind of OIDC.
+	Kind = "OIDC"
+
+	resultRedirected      = "redirected"
+	resultUnauthenticated = "unauthenticated"
+	resultLoginFailed     = "loginFailed"
+
+	defaultLoginPath    = "/login"
+	defaultCallbackPath = "/callback"
+	defaultLogoutPath   = "/logout"
+	defaultReturnPath   = "/"
+
+	// The keys of the session data, the claims are saved by their names,
+	// and the login state is kept private to the gateway.
+	keySubject = "sub"
+	keyState   = context.InternalKeyPrefix + "oidc-state"
+	keyNonce   = context.InternalKeyPrefix + "oidc-nonce"
+	keyReturn  = context.InternalKeyPrefix + "oidc-return"
+
+	fetchTimeout = 10 * time.Second
+)
+
+var (
+	results = []string{resultRedirected, resultUnauthenticated, resultLoginFailed}
+
+	defaultScopes = []string{"openid", "profile", "email"}
+	defaultClaims = []string{"email", "name"}
+)
+
+func init() {
+	httppipeline.Register(&OIDC{})
+}
+
+type (
+	// OIDC logs in the users by the authorization code flow of OpenID
+	// Connect, and keeps them in the sessions of the Session filter, so
+	// legacy applications could be protected without changes.
+	OIDC struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		provider *provider
+	}
+
+	// Spec describes the OIDC.
+	Spec struct {
+		Issuer       string   `yaml:"issuer" jsonschema:"required,format=uri"`
+		ClientID     string   `yaml:"clientID" jsonschema:"required"`
+		ClientSecret string   `yaml:"clientSecret" jsonschema:"required"`
+		Scopes       []string `yaml:"scopes" jsonschema:"omitempty"`
+		// Claims are the claims of the ID token saved in the session
+		// besides sub.
+		Claims []string `yaml:"claims" jsonschema:"omitempty"`
+
+		// RedirectURL is the URL of the callback registered in the
+		// provider, the default is the callback path of the host of the
+		// login request.
+		RedirectURL string `yaml:"redirectURL" jsonschema:"omitempty,format=uri"`
+
+		LoginPath    string `yaml:"loginPath" jsonschema:"omitempty,pattern=^/"`
+		CallbackPath string `yaml:"callbackPath" jsonschema:"omitempty,pattern=^/"`
+		LogoutPath   string `yaml:"logoutPath" jsonschema:"omitempty,pattern=^/"`
+		// PostLogoutRedirectURL is where the users go after logout.
+		PostLogoutRedirectURL string `yaml:"postLogoutRedirectURL" jsonschema:"omitempty"`
+
+		// AllowAnonymous passes the requests without logged-in users,
+		// otherwise they are redirected to login.
+		AllowAnonymous bool `yaml:"allowAnonymous" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if !isHTTPS(spec.Issuer) {
+		return fmt.Errorf("issuer must be an https url")
+	}
+
+	paths := map[string]bool{}
+	for _, p := range []string{spec.loginPath(), spec.callbackPath(), spec.logoutPath()} {
+		if paths[p] {
+			return fmt.Errorf("login, callback and logout paths must be different")
+		}
+		paths[p] = true
+	}
+	return nil
+}
+
+func (spec *Spec) loginPath() string {
+	if spec.LoginPath == "" {
+		return defaultLoginPath
+	}
+	return spec.LoginPath
+}
+
+func (spec *Spec) callbackPath() string {
+	if spec.CallbackPath == "" {
+		return defaultCallbackPath
+	}
+	return spec.CallbackPath
+}
+
+func (spec *Spec) logoutPath() string {
+	if spec.LogoutPath == "" {
+		return defaultLogoutPath
+	}
+	return spec.LogoutPath
+}
+
+// Kind returns the kind of OIDC.
+func (o *OIDC) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of OIDC.
+func (o *OIDC) DefaultSpec() interface{} {
+	return &Spec{
+		LoginPath:    defaultLoginPath,
+		CallbackPath: defaultCallbackPath,
+		LogoutPath:   defaultLogoutPath,
+	}
+}
+
+// Description returns the description of OIDC.
+func (o *OIDC) Description() string {
+	return "OIDC logs in users by OpenID Connect and keeps them in sessions."
+}
+
+// Results returns the results of OIDC.
+func (o *OIDC) Results() []string {
+	return results
+}
+
+// Init initializes OIDC.
+func (o *OIDC) Init(filterSpec *httppipeline.FilterSpec) {
+	o.filterSpec, o.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	o.reload()
+}
+
+// Inherit inherits previous generation of OIDC.
+func (o *OIDC) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	o.Init(filterSpec)
+}
+
+func (o *OIDC) reload() {
+	if len(o.spec.Scopes) == 0 {
+		o.spec.Scopes = defaultScopes
+	}
+	if o.spec.Claims == nil {
+		o.spec.Claims = defaultClaims
+	}
+
+	o.provider = &provider{
+		client:   &http.Client{Timeout: fetchTimeout, Transport: egressproxy.DefaultTransport},
+		issuer:   o.spec.Issuer,
+		clientID: o.spec.ClientID,
+		secret:   o.spec.ClientSecret,
+	}
+}
+
+// Handle serves the login, callback and logout paths, and authenticates
+// other requests by the sessions.
+func (o *OIDC) Handle(ctx context.HTTPContext) string {
+	result := o.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (o *OIDC) handle(ctx context.HTTPContext) string {
+	session := context.GetSession(ctx)
+	if session == nil {
+		logger.Errorf("%s: no session, the Session filter must be placed before OIDC", o.filterSpec.Name())
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		ctx.AddTag("oidc: no session")
+		return resultLoginFailed
+	}
+
+	switch ctx.Request().Path() {
+	case o.spec.loginPath():
+		query, _ := url.ParseQuery(ctx.Request().Query())
+		return o.login(ctx, session, query.Get("rd"))
+	case o.spec.callbackPath():
+		return o.callback(ctx, session)
+	case o.spec.logoutPath():
+		return o.logout(ctx, session)
+	}
+
+	if sub := session.Get(keySubject); sub != "" {
+		context.SetIdentity(ctx, "oidc", sub)
+		return ""
+	}
+	if o.spec.AllowAnonymous {
+		return ""
+	}
+
+	// NOTE: Only the requests of browsers navigating pages are redirected,
+	// redirecting other requests doesn't make sense.
+	r := ctx.Request()
+	if r.Method() != http.MethodGet && r.Method() != http.MethodHead {
+		ctx.Response().SetStatusCode(http.StatusUnauthorized)
+		ctx.AddTag("oidc: login required")
+		return resultUnauthenticated
+	}
+	returnTo := r.Path()
+	if r.Query() != "" {
+		returnTo += "?" + r.Query()
+	}
+	return o.login(ctx, session, returnTo)
+}
+
+func (o *OIDC) login(ctx context.HTTPContext, session *context.Session, returnTo string) string {
+	e, err := o.provider.discover()
+	if err != nil {
+		return o.fail(ctx, http.StatusBadGateway, err)
+	}
+
+	state, err := sessionstore.NewID()
+	if err != nil {
+		return o.fail(ctx, http.StatusInternalServerError, err)
+	}
+	nonce, err := sessionstore.NewID()
+	if err != nil {
+		return o.fail(ctx, http.StatusInternalServerError, err)
+	}
+
+	session.Set(keyState, state)
+	session.Set(keyNonce, nonce)
+	session.Set(keyReturn, localPath(returnTo))
+
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {o.spec.ClientID},
+		"redirect_uri":  {o.redirectURL(ctx)},
+		"scope":         {strings.Join(o.scopes(), " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	return o.redirect(ctx, appendQuery(e.AuthorizationEndpoint, query))
+}
+
+func (o *OIDC) callback(ctx context.HTTPContext, session *context.Session) string {
+	query, _ := url.ParseQuery(ctx.Request().Query())
+	if e := query.Get("error"); e != "" {
+		return o.fail(ctx, http.StatusUnauthorized, fmt.Errorf("provider error: %s", e))
+	}
+
+	state := session.Get(keyState)
+	if state == "" || query.Get("state") != state {
+		return o.fail(ctx, http.StatusBadRequest, fmt.Errorf("state mismatches"))
+	}
+	nonce, returnTo := session.Get(keyNonce), session.Get(keyReturn)
+	session.Delete(keyState)
+	session.Delete(keyNonce)
+	session.Delete(keyReturn)
+
+	claims, err := o.provider.exchange(query.Get("code"), o.redirectURL(ctx), nonce)
+	if err != nil {
+		return o.fail(ctx, http.StatusUnauthorized, err)
+	}
+
+	session.Set(keySubject, claims[keySubject].(string))
+	for _, name := range o.spec.Claims {
+		if v, ok := claims[name]; ok {
+			session.Set(name, fmt.Sprint(v))
+		}
+	}
+	session.Renew()
+
+	if returnTo == "" {
+		returnTo = defaultReturnPath
+	}
+	return o.redirect(ctx, returnTo)
+}
+
+func (o *OIDC) logout(ctx context.HTTPContext, session *context.Session) string {
+	session.Destroy()
+
+	target := o.spec.PostLogoutRedirectURL
+	if target == "" {
+		target = defaultReturnPath
+	}
+
+	// NOTE: The users are logged out of the provider too if it supports
+	// RP-initiated logout, a failed discovery doesn't block the logout.
+	if e, err := o.provider.discover(); err == nil && e.EndSessionEndpoint != "" {
+		query := url.Values{"client_id": {o.spec.ClientID}}
+		if o.spec.PostLogoutRedirectURL != "" {
+			query.Set("post_logout_redirect_uri", o.spec.PostLogoutRedirectURL)
+		}
+		target = appendQuery(e.EndSessionEndpoint, query)
+	}
+
+	return o.redirect(ctx, target)
+}
+
+func (o *OIDC) scopes() []string {
+	for _, s := range o.spec.Scopes {
+		if s == "openid" {
+			return o.spec.Scopes
+		}
+	}
+	return append([]string{"openid"}, o.spec.Scopes...)
+}
+
+func (o *OIDC) redirectURL(ctx context.HTTPContext) string {
+	if o.spec.RedirectURL != "" {
+		return o.spec.RedirectURL
+	}
+
+	scheme := "http"
+	if ctx.Request().Std().TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request().Host() + o.spec.callbackPath()
+}
+
+func (o *OIDC) redirect(ctx context.HTTPContext, location string) string {
+	ctx.Response().Header().Set("Location", location)
+	ctx.Response().Header().Set("Cache-Control", "no-store")
+	ctx.Response().SetStatusCode(http.StatusFound)
+	return resultRedirected
+}
+
+func (o *OIDC) fail(ctx context.HTTPContext, code int, err error) string {
+	logger.Warnf("%s: login failed: %v", o.filterSpec.Name(), err)
+	ctx.Response().SetStatusCode(code)
+	ctx.AddTag(fmt.Sprintf("oidc: %v", err))
+	return resultLoginFailed
+}
+
+// localPath returns the path if it's a local one, or the default return
+// path otherwise, to prevent open redirects.
+func localPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return defaultReturnPath
+	}
+	return path
+}
+
+func appendQuery(rawURL string, query url.Values) string {
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + query.Encode()
+	}
+	return rawURL + "?" + query.Encode()
+}
+
+// Status returns status.
+func (o *OIDC) Status() interface{} {
+	return nil
+}
+
+// Close closes OIDC.
+func (o *OIDC) Close() {}
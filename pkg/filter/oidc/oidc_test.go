@@ -0,0 +1,314 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package oidc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newIDToken(claims map[string]interface{}) string {
+	payload, _ := json.Marshal(claims)
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"
+}
+
+// newProvider creates a provider issuing ID tokens of the claims, the
+// nonce is taken from the claims function on every exchange.
+func newProvider(t *testing.T, claims func() map[string]interface{}) *httptest.Server {
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"end_session_endpoint":   server.URL + "/logout",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "app" || secret != "secret" || r.FormValue("code") != "code" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": newIDToken(claims())})
+	})
+	server = httptest.NewTLSServer(mux)
+	return server
+}
+
+func newOIDC(t *testing.T, yamlSpec string) *OIDC {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	o := &OIDC{}
+	o.Init(spec)
+	return o
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	statusCode int
+	respHeader *httpheader.HTTPHeader
+}
+
+func newContext(session *context.Session, method, uri string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		respHeader:        httpheader.New(http.Header{}),
+	}
+	if session != nil {
+		context.SetSession(ctx, session)
+	}
+
+	u, _ := url.Parse(uri)
+	ctx.MockedRequest.MockedMethod = func() string { return method }
+	ctx.MockedRequest.MockedHost = func() string { return "www.megaease.com" }
+	ctx.MockedRequest.MockedPath = func() string { return u.Path }
+	ctx.MockedRequest.MockedQuery = func() string { return u.RawQuery }
+	ctx.MockedRequest.MockedStd = func() *http.Request { return &http.Request{} }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return ctx.respHeader }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	return ctx
+}
+
+func TestOIDC(t *testing.T) {
+	var nonce string
+	var server *httptest.Server
+	server = newProvider(t, func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   server.URL,
+			"aud":   "app",
+			"sub":   "alice",
+			"email": "alice@megaease.com",
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": nonce,
+		}
+	})
+	defer server.Close()
+
+	o := newOIDC(t, fmt.Sprintf(`
+kind: OIDC
+name: oidc
+issuer: %s
+clientID: app
+clientSecret: secret
+postLogoutRedirectURL: https://www.megaease.com/bye
+`, server.URL))
+	o.provider.client = server.Client()
+
+	// The Session filter is required.
+	ctx := newContext(nil, http.MethodGet, "/app")
+	if result := o.Handle(ctx); result != resultLoginFailed {
+		t.Fatalf("unexpected result %s", result)
+	}
+
+	// Requests of other methods are rejected.
+	session := context.NewSession("", nil)
+	ctx = newContext(session, http.MethodPost, "/app")
+	if result := o.Handle(ctx); result != resultUnauthenticated || ctx.statusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected result %s, status code %d", result, ctx.statusCode)
+	}
+
+	// Pages are redirected to the provider.
+	ctx = newContext(session, http.MethodGet, "/app?x=1")
+	if result := o.Handle(ctx); result != resultRedirected || ctx.statusCode != http.StatusFound {
+		t.Fatalf("unexpected result %s, status code %d", result, ctx.statusCode)
+	}
+	location, _ := url.Parse(ctx.respHeader.Get("Location"))
+	query := location.Query()
+	if !strings.HasPrefix(location.String(), server.URL+"/authorize?") ||
+		query.Get("redirect_uri") != "http://www.megaease.com/callback" ||
+		query.Get("scope") != "openid profile email" ||
+		query.Get("state") != session.Get(keyState) {
+		t.Fatalf("unexpected location %s", location)
+	}
+	if session.Get(keyReturn) != "/app?x=1" {
+		t.Errorf("return path should be saved, but is %s", session.Get(keyReturn))
+	}
+	state := query.Get("state")
+	nonce = query.Get("nonce")
+
+	// The state must match.
+	ctx = newContext(session, http.MethodGet, "/callback?code=code&state=forged")
+	if result := o.Handle(ctx); result != resultLoginFailed || ctx.statusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected result %s, status code %d", result, ctx.statusCode)
+	}
+
+	ctx = newContext(session, http.MethodGet, "/callback?code=code&state="+state)
+	if result := o.Handle(ctx); result != resultRedirected || ctx.respHeader.Get("Location") != "/app?x=1" {
+		t.Fatalf("unexpected result %s, location %s", result, ctx.respHeader.Get("Location"))
+	}
+	if session.Get(keySubject) != "alice" || session.Get("email") != "alice@megaease.com" {
+		t.Errorf("claims should be saved: %v", session.Data())
+	}
+	if session.Get(keyState) != "" || !session.Renewed() {
+		t.Errorf("state should be deleted, and the session should be renewed")
+	}
+
+	ctx = newContext(session, http.MethodPost, "/app")
+	if result := o.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if identity := context.GetIdentity(ctx); identity == nil || identity.Subject != "alice" {
+		t.Errorf("identity should be set")
+	}
+
+	ctx = newContext(session, http.MethodGet, "/logout")
+	if result := o.Handle(ctx); result != resultRedirected || !session.Destroyed() {
+		t.Fatalf("unexpected result %s", result)
+	}
+	location, _ = url.Parse(ctx.respHeader.Get("Location"))
+	if !strings.HasPrefix(location.String(), server.URL+"/logout?") ||
+		location.Query().Get("post_logout_redirect_uri") != "https://www.megaease.com/bye" {
+		t.Errorf("unexpected location %s", location)
+	}
+}
+
+func TestAllowAnonymous(t *testing.T) {
+	o := newOIDC(t, `
+kind: OIDC
+name: oidc
+issuer: https://127.0.0.1:1
+clientID: app
+clientSecret: secret
+allowAnonymous: true
+`)
+
+	ctx := newContext(context.NewSession("", nil), http.MethodGet, "/app")
+	if result := o.Handle(ctx); result != "" || context.GetIdentity(ctx) != nil {
+		t.Errorf("anonymous request should pass")
+	}
+
+	// The provider is unavailable.
+	ctx = newContext(context.NewSession("", nil), http.MethodGet, "/login")
+	if result := o.Handle(ctx); result != resultLoginFailed || ctx.statusCode != http.StatusBadGateway {
+		t.Errorf("unexpected result %s, status code %d", result, ctx.statusCode)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{Issuer: "http://idp"}
+	if err := spec.Validate(); err == nil {
+		t.Errorf("http issuer should be invalid")
+	}
+
+	spec = &Spec{Issuer: "https://idp"}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	spec.CallbackPath = spec.loginPath()
+	if err := spec.Validate(); err == nil {
+		t.Errorf("same login and callback paths should be invalid")
+	}
+}
+
+func TestDiscoverInsecureEndpoint(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         "http://" + r.Host + "/token",
+		})
+	}))
+	defer server.Close()
+
+	p := &provider{client: server.Client(), issuer: server.URL}
+	if _, err := p.discover(); err == nil {
+		t.Fatalf("http token endpoint should be rejected")
+	}
+	if p.endpoints != nil {
+		t.Errorf("endpoints should not be saved")
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	p := &provider{issuer: "https://idp", clientID: "app"}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	cases := []struct {
+		claims map[string]interface{}
+		valid  bool
+	}{
+		{map[string]interface{}{"iss": "https://idp", "aud": "app", "sub": "a", "exp": exp, "nonce": "n"}, true},
+		{map[string]interface{}{"iss": "https://idp", "aud": []string{"other", "app"}, "sub": "a", "exp": exp, "nonce": "n"}, true},
+		{map[string]interface{}{"iss": "https://other", "aud": "app", "sub": "a", "exp": exp, "nonce": "n"}, false},
+		{map[string]interface{}{"iss": "https://idp", "aud": "other", "sub": "a", "exp": exp, "nonce": "n"}, false},
+		{map[string]interface{}{"iss": "https://idp", "aud": "app", "sub": "a", "exp": 1, "nonce": "n"}, false},
+		{map[string]interface{}{"iss": "https://idp", "aud": "app", "sub": "a", "exp": exp, "nonce": "x"}, false},
+		{map[string]interface{}{"iss": "https://idp", "aud": "app", "exp": exp, "nonce": "n"}, false},
+	}
+
+	for i, c := range cases {
+		_, err := p.verifyIDToken(newIDToken(c.claims), "n")
+		if c.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("case %d: should be invalid", i)
+		}
+	}
+
+	if _, err := p.verifyIDToken("malformed", "n"); err == nil {
+		t.Errorf("malformed token should be invalid")
+	}
+}
+
+func TestLocalPath(t *testing.T) {
+	cases := map[string]string{
+		"/app?x=1":            "/app?x=1",
+		"":                    "/",
+		"https://evil.com":    "/",
+		"//evil.com":          "/",
+		"/\\evil.com":         "/",
+		"javascript:alert(1)": "/",
+	}
+	for path, want := range cases {
+		if got := localPath(path); got != want {
+			t.Errorf("%q: want %q, got %q", path, want, got)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openapivalidator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+type (
+	// document is the compiled form of an OpenAPI 3 document, only the
+	// parts needed by runtime validation are kept.
+	document struct {
+		operations []*operation
+	}
+
+	operation struct {
+		method     string
+		pathRE     *regexp.Regexp
+		pathParams []string
+		// literal is the number of literal characters in the path template,
+		// the operation with more literal characters wins when several match.
+		literal int
+
+		params      []*parameter
+		requestBody *requestBody
+		responses   map[string]*gojsonschema.Schema
+
+		info *OperationInfo
+	}
+
+	// OperationInfo is the metadata of an operation, for API catalogs.
+	OperationInfo struct {
+		Method      string `yaml:"method"`
+		Path        string `yaml:"path"`
+		OperationID string `yaml:"operationId,omitempty"`
+		Summary     string `yaml:"summary,omitempty"`
+		Deprecated  bool   `yaml:"deprecated,omitempty"`
+	}
+
+	parameter struct {
+		name     string
+		in       string
+		required bool
+		typ      string
+		schema   *gojsonschema.Schema
+	}
+
+	requestBody struct {
+		required bool
+		// schemas is keyed by media type, only JSON bodies are validated.
+		schemas map[string]*gojsonschema.Schema
+	}
+
+	// ValidationError describes one failed check.
+	ValidationError struct {
+		In     string `json:"in"`
+		Name   string `json:"name,omitempty"`
+		Reason string `json:"reason"`
+	}
+
+	rawDocument struct {
+		OpenAPI    string                                `json:"openapi"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components map[string]interface{}                `json:"components"`
+	}
+
+	rawOperation struct {
+		OperationID string                  `json:"operationId"`
+		Summary     string                  `json:"summary"`
+		Deprecated  bool                    `json:"deprecated"`
+		Parameters  []*rawParameter         `json:"parameters"`
+		RequestBody *rawRequestBody         `json:"requestBody"`
+		Responses   map[string]*rawResponse `json:"responses"`
+	}
+
+	rawParameter struct {
+		Name     string                 `json:"name"`
+		In       string                 `json:"in"`
+		Required bool                   `json:"required"`
+		Schema   map[string]interface{} `json:"schema"`
+		Ref      string                 `json:"$ref"`
+	}
+
+	rawRequestBody struct {
+		Required bool                     `json:"required"`
+		Content  map[string]*rawMediaType `json:"content"`
+	}
+
+	rawResponse struct {
+		Content map[string]*rawMediaType `json:"content"`
+	}
+
+	rawMediaType struct {
+		Schema map[string]interface{} `json:"schema"`
+	}
+)
+
+var (
+	httpMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+	pathParamRE = regexp.MustCompile(`\{([^}/]+)\}`)
+)
+
+func (ve *ValidationError) Error() string {
+	if ve.Name == "" {
+		return fmt.Sprintf("%s: %s", ve.In, ve.Reason)
+	}
+	return fmt.Sprintf("%s %s: %s", ve.In, ve.Name, ve.Reason)
+}
+
+// parseDocument parses and compiles an OpenAPI 3 document in YAML or JSON format.
+func parseDocument(data []byte) (*document, error) {
+	jsonBuff, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("convert document to json failed: %v", err)
+	}
+
+	raw := &rawDocument{}
+	if err = json.Unmarshal(jsonBuff, raw); err != nil {
+		return nil, fmt.Errorf("unmarshal document failed: %v", err)
+	}
+	if !strings.HasPrefix(raw.OpenAPI, "3.") {
+		return nil, fmt.Errorf("unsupported openapi version: %q", raw.OpenAPI)
+	}
+
+	doc := &document{}
+	for path, item := range raw.Paths {
+		var commonParams []*rawParameter
+		if buff, ok := item["parameters"]; ok {
+			if err = json.Unmarshal(buff, &commonParams); err != nil {
+				return nil, fmt.Errorf("path %s: unmarshal parameters failed: %v", path, err)
+			}
+		}
+
+		for _, method := range httpMethods {
+			buff, ok := item[method]
+			if !ok {
+				continue
+			}
+			rawOp := &rawOperation{}
+			if err = json.Unmarshal(buff, rawOp); err != nil {
+				return nil, fmt.Errorf("%s %s: unmarshal operation failed: %v", method, path, err)
+			}
+
+			op, err := newOperation(raw, path, method, commonParams, rawOp)
+			if err != nil {
+				return nil, fmt.Errorf("%s %s: %v", method, path, err)
+			}
+			op.info = &OperationInfo{
+				Method:      op.method,
+				Path:        path,
+				OperationID: rawOp.OperationID,
+				Summary:     rawOp.Summary,
+				Deprecated:  rawOp.Deprecated,
+			}
+			doc.operations = append(doc.operations, op)
+		}
+	}
+
+	sort.SliceStable(doc.operations, func(i, j int) bool {
+		return doc.operations[i].literal > doc.operations[j].literal
+	})
+
+	return doc, nil
+}
+
+// compileSchema compiles an OpenAPI schema object, components of the
+// document are attached so that local references could be resolved.
+func compileSchema(raw *rawDocument, schema map[string]interface{}) (*gojsonschema.Schema, error) {
+	root := make(map[string]interface{}, len(schema)+1)
+	for k, v := range schema {
+		root[k] = v
+	}
+	if raw.Components != nil {
+		root["components"] = raw.Components
+	}
+	return gojsonschema.NewSchema(gojsonschema.NewGoLoader(root))
+}
+
+func resolveParameter(raw *rawDocument, p *rawParameter) (*rawParameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+
+	const prefix = "#/components/parameters/"
+	if !strings.HasPrefix(p.Ref, prefix) {
+		return nil, fmt.Errorf("unsupported parameter reference: %s", p.Ref)
+	}
+	params, _ := raw.Components["parameters"].(map[string]interface{})
+	v, ok := params[strings.TrimPrefix(p.Ref, prefix)]
+	if !ok {
+		return nil, fmt.Errorf("parameter reference %s not found", p.Ref)
+	}
+
+	buff, _ := json.Marshal(v)
+	resolved := &rawParameter{}
+	if err := json.Unmarshal(buff, resolved); err != nil {
+		return nil, fmt.Errorf("unmarshal parameter %s failed: %v", p.Ref, err)
+	}
+	return resolved, nil
+}
+
+func newOperation(raw *rawDocument, path, method string, commonParams []*rawParameter, rawOp *rawOperation) (*operation, error) {
+	op := &operation{
+		method:    strings.ToUpper(method),
+		responses: map[string]*gojsonschema.Schema{},
+	}
+
+	expr := "^" + regexp.QuoteMeta(path) + "$"
+	for _, m := range pathParamRE.FindAllStringSubmatch(path, -1) {
+		op.pathParams = append(op.pathParams, m[1])
+		expr = strings.Replace(expr, regexp.QuoteMeta(m[0]), "([^/]+)", 1)
+	}
+	op.pathRE = regexp.MustCompile(expr)
+	op.literal = len(pathParamRE.ReplaceAllString(path, ""))
+
+	// operation level parameters override path level ones with the same name and location.
+	params := map[string]*rawParameter{}
+	var keys []string
+	for _, p := range append(append([]*rawParameter{}, commonParams...), rawOp.Parameters...) {
+		p, err := resolveParameter(raw, p)
+		if err != nil {
+			return nil, err
+		}
+		key := p.In + ":" + p.Name
+		if _, ok := params[key]; !ok {
+			keys = append(keys, key)
+		}
+		params[key] = p
+	}
+
+	for _, key := range keys {
+		p := params[key]
+		param := &parameter{
+			name:     p.Name,
+			in:       p.In,
+			required: p.Required || p.In == "path",
+		}
+		if p.Schema != nil {
+			param.typ, _ = p.Schema["type"].(string)
+			schema, err := compileSchema(raw, p.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("compile schema of parameter %s failed: %v", p.Name, err)
+			}
+			param.schema = schema
+		}
+		op.params = append(op.params, param)
+	}
+
+	if rawOp.RequestBody != nil {
+		op.requestBody = &requestBody{
+			required: rawOp.RequestBody.Required,
+			schemas:  map[string]*gojsonschema.Schema{},
+		}
+		for mediaType, mt := range rawOp.RequestBody.Content {
+			if mt == nil || mt.Schema == nil || !isJSONMediaType(mediaType) {
+				continue
+			}
+			schema, err := compileSchema(raw, mt.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("compile schema of request body failed: %v", err)
+			}
+			op.requestBody.schemas[mediaType] = schema
+		}
+	}
+
+	for code, resp := range rawOp.Responses {
+		if resp == nil {
+			continue
+		}
+		for mediaType, mt := range resp.Content {
+			if mt == nil || mt.Schema == nil || !isJSONMediaType(mediaType) {
+				continue
+			}
+			schema, err := compileSchema(raw, mt.Schema)
+			if err != nil {
+				return nil, fmt.Errorf("compile schema of response %s failed: %v", code, err)
+			}
+			op.responses[code] = schema
+			break
+		}
+	}
+
+	return op, nil
+}
+
+func isJSONMediaType(mediaType string) bool {
+	mediaType = strings.TrimSpace(strings.Split(mediaType, ";")[0])
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// find returns the operation matching method and path, and the values
+// of path parameters. It returns nil if no operation matches.
+func (doc *document) find(method, path string) (*operation, map[string]string) {
+	for _, op := range doc.operations {
+		if op.method != method {
+			continue
+		}
+		m := op.pathRE.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		values := make(map[string]string, len(op.pathParams))
+		for i, name := range op.pathParams {
+			v, err := url.PathUnescape(m[i+1])
+			if err != nil {
+				v = m[i+1]
+			}
+			values[name] = v
+		}
+		return op, values
+	}
+	return nil, nil
+}
+
+// convertValue converts the raw string value of a parameter to the type
+// declared in its schema, so the value could be validated by JSON schema.
+func (p *parameter) convertValue(value string) (interface{}, error) {
+	switch p.typ {
+	case "integer":
+		return strconv.ParseInt(value, 10, 64)
+	case "number":
+		return strconv.ParseFloat(value, 64)
+	case "boolean":
+		return strconv.ParseBool(value)
+	case "array":
+		return strings.Split(value, ","), nil
+	default:
+		return value, nil
+	}
+}
+
+func (p *parameter) validate(value string, exists bool) *ValidationError {
+	if !exists {
+		if p.required {
+			return &ValidationError{In: p.in, Name: p.name, Reason: "required but missing"}
+		}
+		return nil
+	}
+
+	if p.schema == nil {
+		return nil
+	}
+
+	v, err := p.convertValue(value)
+	if err != nil {
+		return &ValidationError{In: p.in, Name: p.name, Reason: fmt.Sprintf("should be %s", p.typ)}
+	}
+
+	res, err := p.schema.Validate(gojsonschema.NewGoLoader(v))
+	if err != nil {
+		return &ValidationError{In: p.in, Name: p.name, Reason: err.Error()}
+	}
+	if !res.Valid() {
+		return &ValidationError{In: p.in, Name: p.name, Reason: res.Errors()[0].Description()}
+	}
+	return nil
+}
+
+func (op *operation) validateParams(pathParams map[string]string, query url.Values,
+	header *httpheader.HTTPHeader) []*ValidationError {
+
+	var errs []*ValidationError
+	for _, p := range op.params {
+		var value string
+		var exists bool
+		switch p.in {
+		case "path":
+			value, exists = pathParams[p.name]
+		case "query":
+			var values []string
+			values, exists = query[p.name]
+			if exists {
+				value = strings.Join(values, ",")
+			}
+		case "header":
+			values := header.GetAll(p.name)
+			exists = len(values) > 0
+			if exists {
+				value = values[0]
+			}
+		default:
+			continue
+		}
+
+		if err := p.validate(value, exists); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func validateJSONBody(schema *gojsonschema.Schema, in string, body []byte) []*ValidationError {
+	res, err := schema.Validate(gojsonschema.NewBytesLoader(body))
+	if err != nil {
+		return []*ValidationError{{In: in, Reason: err.Error()}}
+	}
+
+	var errs []*ValidationError
+	for _, e := range res.Errors() {
+		errs = append(errs, &ValidationError{In: in, Name: e.Field(), Reason: e.Description()})
+	}
+	return errs
+}
+
+func (op *operation) validateBody(contentType string, body io.Reader) []*ValidationError {
+	if op.requestBody == nil {
+		return nil
+	}
+
+	buff, err := io.ReadAll(body)
+	if err != nil {
+		return []*ValidationError{{In: "body", Reason: fmt.Sprintf("read failed: %v", err)}}
+	}
+	if len(buff) == 0 {
+		if op.requestBody.required {
+			return []*ValidationError{{In: "body", Reason: "required but missing"}}
+		}
+		return nil
+	}
+
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+	schema, ok := op.requestBody.schemas[mediaType]
+	if !ok {
+		return nil
+	}
+	return validateJSONBody(schema, "body", buff)
+}
+
+// responseSchema returns the schema of the response with the status code,
+// the range definitions (e.g. 2XX) and 'default' are used as fallbacks.
+func (op *operation) responseSchema(code int) *gojsonschema.Schema {
+	c := strconv.Itoa(code)
+	if s, ok := op.responses[c]; ok {
+		return s
+	}
+	if s, ok := op.responses[c[:1]+"XX"]; ok {
+		return s
+	}
+	return op.responses["default"]
+}
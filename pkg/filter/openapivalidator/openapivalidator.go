@@ -0,0 +1,347 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openapivalidator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of OpenAPIValidator.
+	Kind = "OpenAPIValidator"
+
+	resultInvalid         = "invalid"
+	resultInvalidResponse = "invalidResponse"
+)
+
+var results = []string{resultInvalid, resultInvalidResponse}
+
+func init() {
+	httppipeline.Register(&OpenAPIValidator{})
+}
+
+type (
+	// OpenAPIValidator is the filter validates requests and responses
+	// against an OpenAPI 3 document.
+	OpenAPIValidator struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		doc        atomic.Value // *document
+		lastReload atomic.Value // string
+		modTime    time.Time
+		done       chan struct{}
+	}
+
+	// Spec describes the OpenAPIValidator.
+	Spec struct {
+		// Document is the inline OpenAPI 3 document in YAML or JSON format.
+		Document string `yaml:"document,omitempty" jsonschema:"omitempty"`
+		// DocumentFile is the path of the OpenAPI 3 document file.
+		DocumentFile string `yaml:"documentFile,omitempty" jsonschema:"omitempty"`
+		// ReloadInterval is the interval to check and reload DocumentFile.
+		ReloadInterval string `yaml:"reloadInterval,omitempty" jsonschema:"omitempty,format=duration"`
+		// AllowUnknownOperation lets requests not defined in the document pass.
+		AllowUnknownOperation bool `yaml:"allowUnknownOperation" jsonschema:"omitempty"`
+		// ValidateResponse validates responses of the following filters.
+		ValidateResponse bool `yaml:"validateResponse" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of OpenAPIValidator.
+	Status struct {
+		LastReload string `yaml:"lastReload,omitempty"`
+	}
+
+	errorResponse struct {
+		Error   string             `json:"error"`
+		Details []*ValidationError `json:"details,omitempty"`
+	}
+)
+
+// Validate validates the Spec.
+func (s *Spec) Validate() error {
+	if s.Document == "" && s.DocumentFile == "" {
+		return fmt.Errorf("neither document nor documentFile is specified")
+	}
+	if s.Document != "" && s.DocumentFile != "" {
+		return fmt.Errorf("document and documentFile are mutually exclusive")
+	}
+	if s.ReloadInterval != "" && s.DocumentFile == "" {
+		return fmt.Errorf("reloadInterval requires documentFile")
+	}
+	if s.Document != "" {
+		if _, err := parseDocument([]byte(s.Document)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Operations returns the operations defined in the document, sorted by
+// path and method, it is for API catalogs.
+func (s *Spec) Operations() ([]*OperationInfo, error) {
+	data := []byte(s.Document)
+	if s.DocumentFile != "" {
+		var err error
+		data, err = os.ReadFile(s.DocumentFile)
+		if err != nil {
+			return nil, fmt.Errorf("read openapi document %s failed: %v", s.DocumentFile, err)
+		}
+	}
+
+	doc, err := parseDocument(data)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]*OperationInfo, 0, len(doc.operations))
+	for _, op := range doc.operations {
+		infos = append(infos, op.info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Path != infos[j].Path {
+			return infos[i].Path < infos[j].Path
+		}
+		return infos[i].Method < infos[j].Method
+	})
+	return infos, nil
+}
+
+// Kind returns the kind of OpenAPIValidator.
+func (v *OpenAPIValidator) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of OpenAPIValidator.
+func (v *OpenAPIValidator) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of OpenAPIValidator.
+func (v *OpenAPIValidator) Description() string {
+	return "OpenAPIValidator validates http requests and responses against an OpenAPI document."
+}
+
+// Results returns the results of OpenAPIValidator.
+func (v *OpenAPIValidator) Results() []string {
+	return results
+}
+
+// Init initializes OpenAPIValidator.
+func (v *OpenAPIValidator) Init(filterSpec *httppipeline.FilterSpec) {
+	v.filterSpec, v.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	v.reload()
+}
+
+// Inherit inherits previous generation of OpenAPIValidator.
+func (v *OpenAPIValidator) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	v.Init(filterSpec)
+}
+
+func (v *OpenAPIValidator) reload() {
+	v.done = make(chan struct{})
+
+	if v.spec.Document != "" {
+		doc, err := parseDocument([]byte(v.spec.Document))
+		if err != nil {
+			// NOTE: It should not happen because the document is
+			// validated in Spec.Validate.
+			logger.Errorf("BUG: parse openapi document failed: %v", err)
+			doc = &document{}
+		}
+		v.doc.Store(doc)
+		return
+	}
+
+	if !v.loadFile() {
+		v.doc.Store(&document{})
+	}
+
+	if v.spec.ReloadInterval == "" {
+		return
+	}
+	interval, err := time.ParseDuration(v.spec.ReloadInterval)
+	if err != nil || interval <= 0 {
+		logger.Errorf("BUG: invalid reload interval %s: %v", v.spec.ReloadInterval, err)
+		return
+	}
+	go v.watch(interval)
+}
+
+// loadFile loads the document file if it has been modified since last
+// loading, the current document keeps unchanged on failure.
+func (v *OpenAPIValidator) loadFile() bool {
+	fi, err := os.Stat(v.spec.DocumentFile)
+	if err != nil {
+		logger.Errorf("stat openapi document %s failed: %v", v.spec.DocumentFile, err)
+		return false
+	}
+	if fi.ModTime().Equal(v.modTime) {
+		return true
+	}
+
+	data, err := os.ReadFile(v.spec.DocumentFile)
+	if err != nil {
+		logger.Errorf("read openapi document %s failed: %v", v.spec.DocumentFile, err)
+		return false
+	}
+	doc, err := parseDocument(data)
+	if err != nil {
+		logger.Errorf("parse openapi document %s failed: %v", v.spec.DocumentFile, err)
+		return false
+	}
+
+	v.doc.Store(doc)
+	v.modTime = fi.ModTime()
+	v.lastReload.Store(time.Now().Format(time.RFC3339))
+	logger.Infof("openapi document %s loaded", v.spec.DocumentFile)
+	return true
+}
+
+func (v *OpenAPIValidator) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-v.done:
+			return
+		case <-ticker.C:
+			v.loadFile()
+		}
+	}
+}
+
+// Handle validates HTTPContext.
+func (v *OpenAPIValidator) Handle(ctx context.HTTPContext) string {
+	doc := v.doc.Load().(*document)
+
+	req := ctx.Request()
+	op, pathParams := doc.find(req.Method(), req.Path())
+	if op == nil {
+		if v.spec.AllowUnknownOperation {
+			return ctx.CallNextHandler("")
+		}
+		v.reject(ctx, http.StatusNotFound, "operation not defined", nil)
+		return ctx.CallNextHandler(resultInvalid)
+	}
+
+	if result := v.handleRequest(ctx, op, pathParams); result != "" {
+		return ctx.CallNextHandler(result)
+	}
+
+	result := ctx.CallNextHandler("")
+	if result != "" || !v.spec.ValidateResponse {
+		return result
+	}
+	return v.handleResponse(ctx, op)
+}
+
+func (v *OpenAPIValidator) handleRequest(ctx context.HTTPContext, op *operation, pathParams map[string]string) string {
+	req := ctx.Request()
+
+	query, err := url.ParseQuery(req.Query())
+	if err != nil {
+		v.reject(ctx, http.StatusBadRequest, "invalid query", nil)
+		return resultInvalid
+	}
+
+	errs := op.validateParams(pathParams, query, req.Header())
+
+	if op.requestBody != nil {
+		body, err := io.ReadAll(req.Body())
+		if err != nil {
+			v.reject(ctx, http.StatusBadRequest, "read body failed", nil)
+			return resultInvalid
+		}
+		req.SetBody(bytes.NewReader(body))
+		errs = append(errs, op.validateBody(req.Header().Get("Content-Type"), bytes.NewReader(body))...)
+	}
+
+	if len(errs) != 0 {
+		v.reject(ctx, http.StatusBadRequest, "request validation failed", errs)
+		return resultInvalid
+	}
+	return ""
+}
+
+func (v *OpenAPIValidator) handleResponse(ctx context.HTTPContext, op *operation) string {
+	w := ctx.Response()
+
+	schema := op.responseSchema(w.StatusCode())
+	if schema == nil || !isJSONMediaType(w.Header().Get("Content-Type")) {
+		return ""
+	}
+
+	body, err := io.ReadAll(w.Body())
+	if err != nil {
+		v.reject(ctx, http.StatusBadGateway, "read response body failed", nil)
+		return resultInvalidResponse
+	}
+	w.SetBody(bytes.NewReader(body))
+
+	if errs := validateJSONBody(schema, "response", body); len(errs) != 0 {
+		v.reject(ctx, http.StatusBadGateway, "response validation failed", errs)
+		return resultInvalidResponse
+	}
+	return ""
+}
+
+func (v *OpenAPIValidator) reject(ctx context.HTTPContext, code int, msg string, details []*ValidationError) {
+	buff, err := json.Marshal(&errorResponse{Error: msg, Details: details})
+	if err != nil {
+		logger.Errorf("BUG: marshal error response failed: %v", err)
+	}
+
+	w := ctx.Response()
+	w.SetStatusCode(code)
+	w.Header().Set("Content-Type", "application/json")
+	w.SetBody(bytes.NewReader(buff))
+
+	tag := msg
+	if len(details) != 0 {
+		tag = stringtool.Cat(msg, ": ", details[0].Error())
+	}
+	ctx.AddTag(stringtool.Cat("openapi validator: ", tag))
+}
+
+// Status returns status.
+func (v *OpenAPIValidator) Status() interface{} {
+	lastReload, _ := v.lastReload.Load().(string)
+	return &Status{LastReload: lastReload}
+}
+
+// Close closes OpenAPIValidator.
+func (v *OpenAPIValidator) Close() {
+	close(v.done)
+}
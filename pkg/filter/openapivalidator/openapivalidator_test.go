@@ -0,0 +1,264 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package openapivalidator
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+const yamlSpec = `
+kind: OpenAPIValidator
+name: validator
+validateResponse: true
+document: |
+  openapi: 3.0.0
+  info:
+    title: pets
+    version: 1.0.0
+  paths:
+    /pets/{id}:
+      parameters:
+      - name: id
+        in: path
+        schema:
+          type: integer
+          minimum: 1
+      get:
+        parameters:
+        - name: verbose
+          in: query
+          schema:
+            type: boolean
+        responses:
+          "200":
+            content:
+              application/json:
+                schema:
+                  $ref: '#/components/schemas/Pet'
+    /pets/mine:
+      get:
+        operationId: listMyPets
+        summary: List my pets
+        responses:
+          "200":
+            description: ok
+    /pets:
+      post:
+        requestBody:
+          required: true
+          content:
+            application/json:
+              schema:
+                $ref: '#/components/schemas/Pet'
+        responses:
+          "201":
+            description: created
+  components:
+    schemas:
+      Pet:
+        type: object
+        required: [name]
+        properties:
+          name:
+            type: string
+`
+
+func createValidator(yamlSpec string, prev *OpenAPIValidator) *OpenAPIValidator {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		panic(err)
+	}
+	v := &OpenAPIValidator{}
+	if prev == nil {
+		v.Init(spec)
+	} else {
+		v.Inherit(spec, prev)
+	}
+	return v
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	statusCode int
+	body       string
+}
+
+func newContext(method, path, query, body string) *mockedContext {
+	ctx := &mockedContext{MockedHTTPContext: &contexttest.MockedHTTPContext{}}
+
+	reqHeader := httpheader.New(http.Header{})
+	reqHeader.Set("Content-Type", "application/json")
+	var reqBody io.Reader = strings.NewReader(body)
+	ctx.MockedRequest.MockedMethod = func() string { return method }
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedRequest.MockedQuery = func() string { return query }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return reqBody }
+	ctx.MockedRequest.MockedSetBody = func(r io.Reader) { reqBody = r }
+
+	rspHeader := httpheader.New(http.Header{})
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return rspHeader }
+	ctx.MockedResponse.MockedStatusCode = func() int { return ctx.statusCode }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedResponse.MockedBody = func() io.Reader { return strings.NewReader(ctx.body) }
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) {
+		buff, _ := io.ReadAll(r)
+		ctx.body = string(buff)
+	}
+
+	return ctx
+}
+
+func TestRequest(t *testing.T) {
+	v := createValidator(yamlSpec, nil)
+	defer v.Close()
+
+	cases := []struct {
+		method, path, query, body string
+		result                    string
+	}{
+		{http.MethodGet, "/pets/1", "", "", ""},
+		{http.MethodGet, "/pets/1", "verbose=true", "", ""},
+		{http.MethodGet, "/pets/mine", "", "", ""},
+		{http.MethodGet, "/pets/abc", "", "", resultInvalid},
+		{http.MethodGet, "/pets/0", "", "", resultInvalid},
+		{http.MethodGet, "/pets/1", "verbose=abc", "", resultInvalid},
+		{http.MethodGet, "/users/1", "", "", resultInvalid},
+		{http.MethodPost, "/pets", "", `{"name":"tom"}`, ""},
+		{http.MethodPost, "/pets", "", `{"age":1}`, resultInvalid},
+		{http.MethodPost, "/pets", "", "", resultInvalid},
+	}
+
+	for i, c := range cases {
+		ctx := newContext(c.method, c.path, c.query, c.body)
+		if result := v.Handle(ctx); result != c.result {
+			t.Errorf("case %d: expected result %q, got %q", i, c.result, result)
+		}
+		if c.result != "" && !strings.Contains(ctx.body, `"error"`) {
+			t.Errorf("case %d: expected structured error, got %q", i, ctx.body)
+		}
+	}
+}
+
+func TestResponse(t *testing.T) {
+	v := createValidator(yamlSpec, nil)
+	defer v.Close()
+
+	ctx := newContext(http.MethodGet, "/pets/1", "", "")
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		ctx.statusCode = http.StatusOK
+		ctx.MockedResponse.MockedHeader().Set("Content-Type", "application/json")
+		ctx.body = `{"age":1}`
+		return lastResult
+	}
+	if result := v.Handle(ctx); result != resultInvalidResponse {
+		t.Errorf("expected result %q, got %q", resultInvalidResponse, result)
+	}
+	if ctx.statusCode != http.StatusBadGateway {
+		t.Errorf("expected status code %d, got %d", http.StatusBadGateway, ctx.statusCode)
+	}
+
+	ctx = newContext(http.MethodGet, "/pets/1", "", "")
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		ctx.statusCode = http.StatusOK
+		ctx.MockedResponse.MockedHeader().Set("Content-Type", "application/json")
+		ctx.body = `{"name":"tom"}`
+		return lastResult
+	}
+	if result := v.Handle(ctx); result != "" {
+		t.Errorf("expected empty result, got %q", result)
+	}
+}
+
+func TestReload(t *testing.T) {
+	f, err := os.CreateTemp("", "openapi-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	f.WriteString(`
+openapi: 3.0.0
+paths:
+  /a:
+    get:
+      responses:
+        "200":
+          description: ok
+`)
+	f.Close()
+
+	v := createValidator(`
+kind: OpenAPIValidator
+name: validator
+documentFile: `+f.Name(), nil)
+	defer v.Close()
+
+	if result := v.Handle(newContext(http.MethodGet, "/a", "", "")); result != "" {
+		t.Errorf("expected empty result, got %q", result)
+	}
+	if result := v.Handle(newContext(http.MethodGet, "/b", "", "")); result != resultInvalid {
+		t.Errorf("expected result %q, got %q", resultInvalid, result)
+	}
+
+	spec := &Spec{Document: "openapi: 2.0"}
+	if spec.Validate() == nil {
+		t.Errorf("spec with openapi 2.0 document should be invalid")
+	}
+}
+
+func TestOperations(t *testing.T) {
+	v := createValidator(yamlSpec, nil)
+	defer v.Close()
+
+	ops, err := v.spec.Operations()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"POST /pets", "GET /pets/mine", "GET /pets/{id}"}
+	if len(ops) != len(expected) {
+		t.Fatalf("expected %d operations, got %d", len(expected), len(ops))
+	}
+	for i, op := range ops {
+		if got := op.Method + " " + op.Path; got != expected[i] {
+			t.Errorf("expected operation %s, got %s", expected[i], got)
+		}
+	}
+	if ops[1].OperationID != "listMyPets" || ops[1].Summary != "List my pets" {
+		t.Errorf("unexpected metadata: %+v", ops[1])
+	}
+}
@@ -0,0 +1,372 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package protobufvalidator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	// Kind is the kind of ProtobufValidator.
+	Kind = "ProtobufValidator"
+
+	resultInvalid  = "invalid"
+	resultTooLarge = "tooLarge"
+
+	// UnknownFieldsAllow lets messages with unknown fields pass.
+	UnknownFieldsAllow = "allow"
+	// UnknownFieldsReject rejects messages with unknown fields.
+	UnknownFieldsReject = "reject"
+	// UnknownFieldsDiscard removes unknown fields from messages.
+	UnknownFieldsDiscard = "discard"
+
+	// grpcFrameHeaderSize is the size of the length-prefixed message
+	// header of gRPC, 1 byte compressed flag and 4 bytes message length.
+	grpcFrameHeaderSize = 5
+
+	// gRPC status code INVALID_ARGUMENT and RESOURCE_EXHAUSTED.
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+)
+
+var results = []string{resultInvalid, resultTooLarge}
+
+func init() {
+	httppipeline.Register(&ProtobufValidator{})
+}
+
+type (
+	// ProtobufValidator is the filter validates that request bodies are
+	// valid protobuf messages of the configured type.
+	ProtobufValidator struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		descriptor protoreflect.MessageDescriptor
+	}
+
+	// Spec describes the ProtobufValidator.
+	Spec struct {
+		// DescriptorSetBase64 is the base64 encoded FileDescriptorSet, it
+		// could be generated by 'protoc --include_imports --descriptor_set_out'.
+		DescriptorSetBase64 string `yaml:"descriptorSetBase64,omitempty" jsonschema:"omitempty,format=base64"`
+		// DescriptorSetFile is the path of the FileDescriptorSet file.
+		DescriptorSetFile string `yaml:"descriptorSetFile,omitempty" jsonschema:"omitempty"`
+		// MessageType is the full name of the message, e.g. 'helloworld.HelloRequest'.
+		MessageType    string `yaml:"messageType" jsonschema:"required"`
+		GRPC           bool   `yaml:"grpc" jsonschema:"omitempty"`
+		MaxMessageSize uint32 `yaml:"maxMessageSize" jsonschema:"omitempty"`
+		UnknownFields  string `yaml:"unknownFields" jsonschema:"omitempty,enum=,enum=allow,enum=reject,enum=discard"`
+	}
+
+	validationError struct {
+		code    int
+		message string
+	}
+)
+
+func (e *validationError) Error() string {
+	return e.message
+}
+
+// Validate validates the Spec.
+func (s *Spec) Validate() error {
+	if (s.DescriptorSetBase64 == "") == (s.DescriptorSetFile == "") {
+		return fmt.Errorf("exactly one of descriptorSetBase64 and descriptorSetFile should be specified")
+	}
+	if s.DescriptorSetBase64 != "" {
+		if _, err := s.messageDescriptor(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Spec) messageDescriptor() (protoreflect.MessageDescriptor, error) {
+	var data []byte
+	var err error
+	if s.DescriptorSetBase64 != "" {
+		data, err = base64.StdEncoding.DecodeString(s.DescriptorSetBase64)
+	} else {
+		data, err = os.ReadFile(s.DescriptorSetFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load descriptor set failed: %v", err)
+	}
+
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err = proto.Unmarshal(data, fds); err != nil {
+		return nil, fmt.Errorf("unmarshal descriptor set failed: %v", err)
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return nil, fmt.Errorf("build descriptors failed: %v", err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(s.MessageType))
+	if err != nil {
+		return nil, fmt.Errorf("find message %s failed: %v", s.MessageType, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message", s.MessageType)
+	}
+	return md, nil
+}
+
+// Kind returns the kind of ProtobufValidator.
+func (v *ProtobufValidator) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ProtobufValidator.
+func (v *ProtobufValidator) DefaultSpec() interface{} {
+	return &Spec{UnknownFields: UnknownFieldsAllow}
+}
+
+// Description returns the description of ProtobufValidator.
+func (v *ProtobufValidator) Description() string {
+	return "ProtobufValidator validates request bodies against a protobuf message descriptor."
+}
+
+// Results returns the results of ProtobufValidator.
+func (v *ProtobufValidator) Results() []string {
+	return results
+}
+
+// Init initializes ProtobufValidator.
+func (v *ProtobufValidator) Init(filterSpec *httppipeline.FilterSpec) {
+	v.filterSpec, v.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	v.reload()
+}
+
+// Inherit inherits previous generation of ProtobufValidator.
+func (v *ProtobufValidator) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	v.Init(filterSpec)
+}
+
+func (v *ProtobufValidator) reload() {
+	md, err := v.spec.messageDescriptor()
+	if err != nil {
+		logger.Errorf("load message descriptor failed, all requests will be rejected: %v", err)
+		return
+	}
+	v.descriptor = md
+}
+
+// Handle validates HTTPContext.
+func (v *ProtobufValidator) Handle(ctx context.HTTPContext) string {
+	result := v.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (v *ProtobufValidator) handle(ctx context.HTTPContext) string {
+	if v.descriptor == nil {
+		v.reject(ctx, &validationError{code: http.StatusInternalServerError, message: "message descriptor not loaded"})
+		return resultInvalid
+	}
+
+	req := ctx.Request()
+	body, err := io.ReadAll(req.Body())
+	if err != nil {
+		v.reject(ctx, &validationError{code: http.StatusBadRequest, message: fmt.Sprintf("read body failed: %v", err)})
+		return resultInvalid
+	}
+
+	if v.spec.GRPC {
+		body, err = v.validateGRPC(body)
+	} else {
+		body, err = v.validateMessage(body)
+	}
+	if err != nil {
+		ve := err.(*validationError)
+		v.reject(ctx, ve)
+		if ve.code == http.StatusRequestEntityTooLarge {
+			return resultTooLarge
+		}
+		return resultInvalid
+	}
+
+	req.SetBody(bytes.NewReader(body))
+	return ""
+}
+
+// validateGRPC validates all length-prefixed messages in body.
+func (v *ProtobufValidator) validateGRPC(body []byte) ([]byte, error) {
+	var out []byte
+	for len(body) > 0 {
+		if len(body) < grpcFrameHeaderSize {
+			return nil, &validationError{code: http.StatusBadRequest, message: "incomplete grpc frame header"}
+		}
+		if body[0] != 0 {
+			return nil, &validationError{code: http.StatusBadRequest, message: "compressed grpc message is not supported"}
+		}
+		size := binary.BigEndian.Uint32(body[1:grpcFrameHeaderSize])
+		if v.spec.MaxMessageSize > 0 && size > v.spec.MaxMessageSize {
+			return nil, &validationError{
+				code:    http.StatusRequestEntityTooLarge,
+				message: fmt.Sprintf("message size %d exceeds the limit %d", size, v.spec.MaxMessageSize),
+			}
+		}
+		if uint64(len(body)-grpcFrameHeaderSize) < uint64(size) {
+			return nil, &validationError{code: http.StatusBadRequest, message: "incomplete grpc message"}
+		}
+
+		msg, err := v.validateMessage(body[grpcFrameHeaderSize : grpcFrameHeaderSize+size])
+		if err != nil {
+			return nil, err
+		}
+		header := make([]byte, grpcFrameHeaderSize)
+		binary.BigEndian.PutUint32(header[1:], uint32(len(msg)))
+		out = append(append(out, header...), msg...)
+
+		body = body[grpcFrameHeaderSize+size:]
+	}
+	return out, nil
+}
+
+// validateMessage validates a single message, the returned message is
+// the input one unless unknown fields are discarded.
+func (v *ProtobufValidator) validateMessage(data []byte) ([]byte, error) {
+	if v.spec.MaxMessageSize > 0 && uint64(len(data)) > uint64(v.spec.MaxMessageSize) {
+		return nil, &validationError{
+			code:    http.StatusRequestEntityTooLarge,
+			message: fmt.Sprintf("message size %d exceeds the limit %d", len(data), v.spec.MaxMessageSize),
+		}
+	}
+
+	msg := dynamicpb.NewMessage(v.descriptor)
+	if err := proto.Unmarshal(data, msg); err != nil {
+		return nil, &validationError{code: http.StatusBadRequest, message: fmt.Sprintf("unmarshal message failed: %v", err)}
+	}
+
+	switch v.spec.UnknownFields {
+	case UnknownFieldsReject:
+		if path := findUnknown(msg, string(v.descriptor.Name())); path != "" {
+			return nil, &validationError{code: http.StatusBadRequest, message: "unknown fields in " + path}
+		}
+	case UnknownFieldsDiscard:
+		if path := findUnknown(msg, ""); path != "" {
+			discardUnknown(msg)
+			buff, err := proto.Marshal(msg)
+			if err != nil {
+				return nil, &validationError{code: http.StatusInternalServerError, message: fmt.Sprintf("marshal message failed: %v", err)}
+			}
+			return buff, nil
+		}
+	}
+
+	return data, nil
+}
+
+// findUnknown returns the path of the first message which has unknown
+// fields, or an empty string if there is none.
+func findUnknown(m protoreflect.Message, path string) string {
+	if len(m.GetUnknown()) > 0 {
+		if path == "" {
+			return "."
+		}
+		return path
+	}
+
+	var result string
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := path + "." + string(fd.Name())
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len() && result == ""; i++ {
+				result = findUnknown(list.Get(i).Message(), name+"["+strconv.Itoa(i)+"]")
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+				result = findUnknown(mv.Message(), name+"["+k.String()+"]")
+				return result == ""
+			})
+		case fd.Message() != nil && !fd.IsList() && !fd.IsMap():
+			result = findUnknown(v.Message(), name)
+		}
+		return result == ""
+	})
+	return result
+}
+
+func discardUnknown(m protoreflect.Message) {
+	m.SetUnknown(nil)
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList() && fd.Message() != nil:
+			list := v.List()
+			for i := 0; i < list.Len(); i++ {
+				discardUnknown(list.Get(i).Message())
+			}
+		case fd.IsMap() && fd.MapValue().Message() != nil:
+			v.Map().Range(func(_ protoreflect.MapKey, mv protoreflect.Value) bool {
+				discardUnknown(mv.Message())
+				return true
+			})
+		case fd.Message() != nil && !fd.IsList() && !fd.IsMap():
+			discardUnknown(v.Message())
+		}
+		return true
+	})
+}
+
+func (v *ProtobufValidator) reject(ctx context.HTTPContext, ve *validationError) {
+	w := ctx.Response()
+	if v.spec.GRPC {
+		// gRPC clients expect HTTP 200 with the status in the trailers-only response.
+		code := grpcInvalidArgument
+		if ve.code == http.StatusRequestEntityTooLarge {
+			code = grpcResourceExhausted
+		}
+		w.SetStatusCode(http.StatusOK)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", strconv.Itoa(code))
+		w.Header().Set("Grpc-Message", url.PathEscape(ve.message))
+	} else {
+		w.SetStatusCode(ve.code)
+	}
+	ctx.AddTag(stringtool.Cat("protobuf validator: ", ve.message))
+}
+
+// Status returns status.
+func (v *ProtobufValidator) Status() interface{} { return nil }
+
+// Close closes ProtobufValidator.
+func (v *ProtobufValidator) Close() {}
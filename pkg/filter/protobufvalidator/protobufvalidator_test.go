@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package protobufvalidator
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func descriptorSetBase64() string {
+	fds := &descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{{
+			Name:    proto.String("hello.proto"),
+			Package: proto.String("hello"),
+			Syntax:  proto.String("proto3"),
+			MessageType: []*descriptorpb.DescriptorProto{{
+				Name: proto.String("HelloRequest"),
+				Field: []*descriptorpb.FieldDescriptorProto{{
+					Name:     proto.String("name"),
+					JsonName: proto.String("name"),
+					Number:   proto.Int32(1),
+					Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+					Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				}},
+			}},
+		}},
+	}
+	data, _ := proto.Marshal(fds)
+	return base64.StdEncoding.EncodeToString(data)
+}
+
+func createValidator(extra string) *ProtobufValidator {
+	yamlSpec := `
+kind: ProtobufValidator
+name: validator
+messageType: hello.HelloRequest
+descriptorSetBase64: ` + descriptorSetBase64() + extra
+
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		panic(err)
+	}
+	v := &ProtobufValidator{}
+	v.Init(spec)
+	return v
+}
+
+func handle(v *ProtobufValidator, body []byte) (string, []byte) {
+	ctx := &contexttest.MockedHTTPContext{}
+	var reader io.Reader = bytes.NewReader(body)
+	header := httpheader.New(http.Header{})
+	ctx.MockedRequest.MockedBody = func() io.Reader { return reader }
+	ctx.MockedRequest.MockedSetBody = func(r io.Reader) { reader = r }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+	result := v.Handle(ctx)
+	forwarded, _ := io.ReadAll(reader)
+	return result, forwarded
+}
+
+func TestValidate(t *testing.T) {
+	valid := protowire.AppendTag(nil, 1, protowire.BytesType)
+	valid = protowire.AppendString(valid, "easegress")
+	unknown := protowire.AppendTag(append([]byte{}, valid...), 2, protowire.VarintType)
+	unknown = protowire.AppendVarint(unknown, 1)
+
+	v := createValidator("\nmaxMessageSize: 20\n")
+	if result, _ := handle(v, valid); result != "" {
+		t.Errorf("expected empty result, got %q", result)
+	}
+	if result, _ := handle(v, []byte{0xff, 0xff}); result != resultInvalid {
+		t.Errorf("expected result %q, got %q", resultInvalid, result)
+	}
+	if result, _ := handle(v, bytes.Repeat(valid, 3)); result != resultTooLarge {
+		t.Errorf("expected result %q, got %q", resultTooLarge, result)
+	}
+	if result, _ := handle(v, unknown); result != "" {
+		t.Errorf("expected empty result, got %q", result)
+	}
+
+	v = createValidator("\nunknownFields: reject\n")
+	if result, _ := handle(v, unknown); result != resultInvalid {
+		t.Errorf("expected result %q, got %q", resultInvalid, result)
+	}
+
+	v = createValidator("\nunknownFields: discard\n")
+	result, forwarded := handle(v, unknown)
+	if result != "" {
+		t.Errorf("expected empty result, got %q", result)
+	}
+	if !bytes.Equal(forwarded, valid) {
+		t.Errorf("unknown fields should be discarded")
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	msg := protowire.AppendTag(nil, 1, protowire.BytesType)
+	msg = protowire.AppendString(msg, "easegress")
+	frame := append([]byte{0, 0, 0, 0, byte(len(msg))}, msg...)
+
+	v := createValidator("\ngrpc: true\n")
+	if result, _ := handle(v, frame); result != "" {
+		t.Errorf("expected empty result, got %q", result)
+	}
+	if result, _ := handle(v, frame[:len(frame)-1]); result != resultInvalid {
+		t.Errorf("expected result %q, got %q", resultInvalid, result)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+type (
+	// DrainingServer is a server marked as draining, it stops receiving
+	// new requests while in-flight requests complete.
+	DrainingServer struct {
+		URL string `yaml:"url" jsonschema:"required,format=url"`
+		// KeepSticky keeps routing requests of sticky sessions, that's
+		// requests picked by ipHash or headerHash, to the server.
+		KeepSticky bool      `yaml:"keepSticky" jsonschema:"omitempty"`
+		Since      time.Time `yaml:"since" jsonschema:"omitempty"`
+	}
+
+	// drainingServers syncs the draining servers from the cluster.
+	drainingServers struct {
+		cls     cluster.Cluster
+		servers atomic.Value // map[string]*DrainingServer
+		done    chan struct{}
+	}
+)
+
+// Validate validates DrainingServer.
+func (ds *DrainingServer) Validate() error {
+	u, err := url.Parse(ds.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("invalid url: %s", ds.URL)
+	}
+	return nil
+}
+
+func newDrainingServers(cls cluster.Cluster) *drainingServers {
+	ds := &drainingServers{
+		cls:  cls,
+		done: make(chan struct{}),
+	}
+	ds.servers.Store(map[string]*DrainingServer{})
+
+	go ds.watch()
+
+	return ds
+}
+
+func (ds *drainingServers) watch() {
+	var (
+		ch     <-chan map[string]string
+		syncer *cluster.Syncer
+		err    error
+	)
+
+	for {
+		syncer, err = ds.cls.Syncer(time.Minute)
+		if err == nil {
+			ch, err = syncer.SyncPrefix(ds.cls.Layout().DrainingServerPrefix())
+			if err == nil {
+				break
+			}
+			syncer.Close()
+		}
+		logger.Errorf("failed to watch draining servers: %v", err)
+		select {
+		case <-time.After(10 * time.Second):
+		case <-ds.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case data := <-ch:
+			ds.update(data)
+		case <-ds.done:
+			return
+		}
+	}
+}
+
+func (ds *drainingServers) update(data map[string]string) {
+	servers := make(map[string]*DrainingServer, len(data))
+	for k, v := range data {
+		server := &DrainingServer{}
+		if err := yaml.Unmarshal([]byte(v), server); err != nil {
+			logger.Errorf("unmarshal draining server %s failed: %v", k, err)
+			continue
+		}
+		servers[server.URL] = server
+	}
+	ds.servers.Store(servers)
+}
+
+func (ds *drainingServers) get(url string) *DrainingServer {
+	if ds == nil {
+		return nil
+	}
+	return ds.servers.Load().(map[string]*DrainingServer)[url]
+}
+
+func (ds *drainingServers) close() {
+	close(ds.done)
+}
+
+// undrain returns the server if it is not draining, otherwise picks
+// another server which is not draining. The server is still returned if
+// all servers are draining, to keep the service available.
+func (ss *staticServers) undrain(ctx context.HTTPContext, server *Server, ds *drainingServers) *Server {
+	drainingServer := ds.get(server.URL)
+	if drainingServer == nil {
+		return server
+	}
+
+	switch ss.lb.Policy {
+	case PolicyIPHash, PolicyHeaderHash:
+		if drainingServer.KeepSticky {
+			return server
+		}
+	}
+
+	return ss.exclude(ctx, server, func(s *Server) bool {
+		return ds.get(s.URL) != nil
+	})
+}
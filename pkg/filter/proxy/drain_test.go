@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestUndrain(t *testing.T) {
+	servers := []*Server{
+		{URL: "http://127.0.0.1:9091"},
+		{URL: "http://127.0.0.1:9092"},
+		{URL: "http://127.0.0.1:9093"},
+	}
+
+	ds := &drainingServers{}
+	ds.update(map[string]string{
+		"a": "url: http://127.0.0.1:9091",
+		"b": "url: http://127.0.0.1:9092\nkeepSticky: true",
+	})
+
+	ctx := &contexttest.MockedHTTPContext{}
+	header := httpheader.New(map[string][]string{})
+	header.Set("X-User-Id", "abc")
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return header
+	}
+
+	ss := newStaticServers(servers, nil, &LoadBalance{Policy: PolicyRoundRobin})
+	for i := 0; i < 10; i++ {
+		server := ss.undrain(ctx, ss.next(ctx), ds)
+		if server.URL != "http://127.0.0.1:9093" {
+			t.Fatalf("expect the only undrained server, got %s", server.URL)
+		}
+	}
+
+	ss = newStaticServers(servers, nil, &LoadBalance{Policy: PolicyHeaderHash, HeaderHashKey: "X-User-Id"})
+	if server := ss.undrain(ctx, servers[1], ds); server != servers[1] {
+		t.Fatalf("expect sticky session kept, got %s", server.URL)
+	}
+	if server := ss.undrain(ctx, servers[0], ds); server != servers[2] {
+		t.Fatalf("expect the only undrained server, got %s", server.URL)
+	}
+
+	ds.update(map[string]string{
+		"a": "url: http://127.0.0.1:9091",
+		"b": "url: http://127.0.0.1:9092",
+		"c": "url: http://127.0.0.1:9093",
+	})
+	if server := ss.undrain(ctx, servers[0], ds); server != servers[0] {
+		t.Fatalf("expect the picked server when all are draining, got %s", server.URL)
+	}
+}
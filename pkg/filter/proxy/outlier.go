@@ -0,0 +1,287 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultOutlierInterval         = 10 * time.Second
+	defaultOutlierBaseEjectionTime = 30 * time.Second
+	defaultOutlierMaxEjectionPct   = 10
+	defaultOutlierConsecutive5xx   = 5
+
+	ejectReason5xx            = "consecutive5xx"
+	ejectReasonGatewayFailure = "consecutiveGatewayFailure"
+)
+
+type (
+	// OutlierDetectionSpec describes the outlier detection, servers
+	// returning consecutive errors are ejected from the load balancing
+	// for a period of time.
+	OutlierDetectionSpec struct {
+		// Consecutive5xx is the number of consecutive 5xx responses or
+		// connection failures to eject a server, 0 disables it.
+		Consecutive5xx *int `yaml:"consecutive5xx" jsonschema:"omitempty,minimum=0"`
+		// ConsecutiveGatewayFailure is the number of consecutive 502, 503,
+		// 504 responses or connection failures to eject a server, 0
+		// disables it.
+		ConsecutiveGatewayFailure int `yaml:"consecutiveGatewayFailure" jsonschema:"omitempty,minimum=0"`
+		// Interval is the interval to check whether ejected servers should
+		// be brought back.
+		Interval string `yaml:"interval" jsonschema:"omitempty,format=duration"`
+		// BaseEjectionTime is the base of ejection time, the ejection time
+		// is BaseEjectionTime multiplied by the times of ejection.
+		BaseEjectionTime string `yaml:"baseEjectionTime" jsonschema:"omitempty,format=duration"`
+		// MaxEjectionPercent is the max percentage of servers which could
+		// be ejected, at least one server could be ejected if it's not 0.
+		MaxEjectionPercent *int `yaml:"maxEjectionPercent" jsonschema:"omitempty,minimum=0,maximum=100"`
+	}
+
+	// EjectedServer is the status of an ejected server.
+	EjectedServer struct {
+		URL           string    `yaml:"url"`
+		Reason        string    `yaml:"reason"`
+		EjectedAt     time.Time `yaml:"ejectedAt"`
+		Until         time.Time `yaml:"until"`
+		EjectionTimes int       `yaml:"ejectionTimes"`
+	}
+
+	outlierDetector struct {
+		name             string
+		consecutive5xx   int
+		consecutiveGWF   int
+		interval         time.Duration
+		baseEjectionTime time.Duration
+		maxEjectionPct   int
+
+		mutex   sync.Mutex
+		servers map[string]*outlierServer
+		ejected int
+		done    chan struct{}
+	}
+
+	outlierServer struct {
+		consecutive5xx int
+		consecutiveGWF int
+
+		ejected       bool
+		reason        string
+		ejectedAt     time.Time
+		until         time.Time
+		ejectionTimes int
+	}
+)
+
+// Validate validates OutlierDetectionSpec.
+func (spec *OutlierDetectionSpec) Validate() error {
+	for _, d := range []string{spec.Interval, spec.BaseEjectionTime} {
+		if d == "" {
+			continue
+		}
+		if v, err := time.ParseDuration(d); err != nil || v <= 0 {
+			return fmt.Errorf("invalid duration: %s", d)
+		}
+	}
+	return nil
+}
+
+func newOutlierDetector(name string, spec *OutlierDetectionSpec) *outlierDetector {
+	od := &outlierDetector{
+		name:             name,
+		consecutive5xx:   defaultOutlierConsecutive5xx,
+		consecutiveGWF:   spec.ConsecutiveGatewayFailure,
+		interval:         defaultOutlierInterval,
+		baseEjectionTime: defaultOutlierBaseEjectionTime,
+		maxEjectionPct:   defaultOutlierMaxEjectionPct,
+		servers:          map[string]*outlierServer{},
+		done:             make(chan struct{}),
+	}
+
+	if spec.Consecutive5xx != nil {
+		od.consecutive5xx = *spec.Consecutive5xx
+	}
+	if spec.MaxEjectionPercent != nil {
+		od.maxEjectionPct = *spec.MaxEjectionPercent
+	}
+	if d, err := time.ParseDuration(spec.Interval); err == nil && d > 0 {
+		od.interval = d
+	}
+	if d, err := time.ParseDuration(spec.BaseEjectionTime); err == nil && d > 0 {
+		od.baseEjectionTime = d
+	}
+
+	go od.run()
+
+	return od
+}
+
+func (od *outlierDetector) run() {
+	ticker := time.NewTicker(od.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-od.done:
+			return
+		case now := <-ticker.C:
+			od.check(now)
+		}
+	}
+}
+
+// check brings back the servers whose ejection time is over, and
+// decreases the ejection times of healthy servers.
+func (od *outlierDetector) check(now time.Time) {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	for url, s := range od.servers {
+		if s.ejected {
+			if now.Before(s.until) {
+				continue
+			}
+			s.ejected = false
+			od.ejected--
+			logger.Infof("%s: server %s is brought back after ejected for %s",
+				od.name, url, now.Sub(s.ejectedAt).Truncate(time.Second))
+			continue
+		}
+
+		if s.ejectionTimes > 0 {
+			s.ejectionTimes--
+		}
+		if s.ejectionTimes == 0 && s.consecutive5xx == 0 && s.consecutiveGWF == 0 {
+			delete(od.servers, url)
+		}
+	}
+}
+
+// record records the result of a request to the server, err is true if
+// it failed to get a response. total is the number of servers in the pool.
+func (od *outlierDetector) record(url string, code int, err bool, total int) {
+	gatewayFailure := err || code == http.StatusBadGateway ||
+		code == http.StatusServiceUnavailable || code == http.StatusGatewayTimeout
+	serverError := err || code >= 500
+
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	s := od.servers[url]
+	if s == nil {
+		if !serverError {
+			return
+		}
+		s = &outlierServer{}
+		od.servers[url] = s
+	}
+
+	if serverError {
+		s.consecutive5xx++
+	} else {
+		s.consecutive5xx = 0
+	}
+	if gatewayFailure {
+		s.consecutiveGWF++
+	} else {
+		s.consecutiveGWF = 0
+	}
+
+	if s.ejected {
+		return
+	}
+
+	switch {
+	case od.consecutive5xx > 0 && s.consecutive5xx >= od.consecutive5xx:
+		od.eject(url, s, ejectReason5xx, total)
+	case od.consecutiveGWF > 0 && s.consecutiveGWF >= od.consecutiveGWF:
+		od.eject(url, s, ejectReasonGatewayFailure, total)
+	}
+}
+
+func (od *outlierDetector) eject(url string, s *outlierServer, reason string, total int) {
+	if od.maxEjectionPct == 0 {
+		return
+	}
+
+	max := total * od.maxEjectionPct / 100
+	if max < 1 {
+		max = 1
+	}
+	if od.ejected >= max {
+		logger.Warnf("%s: server %s is not ejected for %s because %d of %d servers are ejected already",
+			od.name, url, reason, od.ejected, total)
+		return
+	}
+
+	now := time.Now()
+	s.ejectionTimes++
+	s.ejected = true
+	s.reason = reason
+	s.ejectedAt = now
+	s.until = now.Add(od.baseEjectionTime * time.Duration(s.ejectionTimes))
+	s.consecutive5xx, s.consecutiveGWF = 0, 0
+	od.ejected++
+
+	logger.Warnf("%s: server %s is ejected for %s until %s (ejection times: %d)",
+		od.name, url, reason, s.until.Format(time.RFC3339), s.ejectionTimes)
+}
+
+func (od *outlierDetector) isEjected(url string) bool {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	s := od.servers[url]
+	return s != nil && s.ejected
+}
+
+func (od *outlierDetector) status() []*EjectedServer {
+	od.mutex.Lock()
+	defer od.mutex.Unlock()
+
+	var result []*EjectedServer
+	for url, s := range od.servers {
+		if !s.ejected {
+			continue
+		}
+		result = append(result, &EjectedServer{
+			URL:           url,
+			Reason:        s.reason,
+			EjectedAt:     s.ejectedAt,
+			Until:         s.until,
+			EjectionTimes: s.ejectionTimes,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URL < result[j].URL
+	})
+
+	return result
+}
+
+func (od *outlierDetector) close() {
+	close(od.done)
+}
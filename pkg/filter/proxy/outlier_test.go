@@ -0,0 +1,134 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+)
+
+func newTestOutlierDetector(spec *OutlierDetectionSpec) *outlierDetector {
+	od := newOutlierDetector("test", spec)
+	// NOTE: Stop the background checking, tests call check by themselves.
+	od.close()
+	return od
+}
+
+func TestOutlierDetection(t *testing.T) {
+	three, fifty := 3, 50
+	od := newTestOutlierDetector(&OutlierDetectionSpec{
+		Consecutive5xx:            &three,
+		ConsecutiveGatewayFailure: 2,
+		BaseEjectionTime:          "10s",
+		MaxEjectionPercent:        &fifty,
+	})
+
+	const a, b, c = "http://a", "http://b", "http://c"
+
+	od.record(a, 500, false, 4)
+	od.record(a, 500, false, 4)
+	od.record(a, 200, false, 4)
+	od.record(a, 500, false, 4)
+	if od.isEjected(a) {
+		t.Fatalf("server should not be ejected after a success")
+	}
+	od.record(a, 500, false, 4)
+	od.record(a, 500, false, 4)
+	if !od.isEjected(a) {
+		t.Fatalf("server should be ejected after 3 consecutive 5xx")
+	}
+
+	od.record(b, 0, true, 4)
+	od.record(b, 503, false, 4)
+	if !od.isEjected(b) {
+		t.Fatalf("server should be ejected after 2 consecutive gateway failures")
+	}
+
+	// 50% of 4 servers are ejected already.
+	od.record(c, 502, false, 4)
+	od.record(c, 502, false, 4)
+	if od.isEjected(c) {
+		t.Fatalf("server should not be ejected over max ejection percent")
+	}
+
+	status := od.status()
+	if len(status) != 2 || status[0].URL != a || status[0].Reason != ejectReason5xx ||
+		status[1].URL != b || status[1].Reason != ejectReasonGatewayFailure {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	od.check(time.Now().Add(5 * time.Second))
+	if !od.isEjected(a) {
+		t.Fatalf("server should be ejected before the ejection time is over")
+	}
+	od.check(time.Now().Add(11 * time.Second))
+	if od.isEjected(a) || od.isEjected(b) {
+		t.Fatalf("servers should be brought back after the ejection time")
+	}
+
+	// The ejection time grows with the ejection times.
+	for i := 0; i < 3; i++ {
+		od.record(a, 500, false, 4)
+	}
+	if s := od.servers[a]; s.ejectionTimes != 2 || s.until.Sub(s.ejectedAt) != 20*time.Second {
+		t.Fatalf("unexpected ejection: %+v", s)
+	}
+}
+
+func TestOutlierDetectionDisabled(t *testing.T) {
+	zero := 0
+	od := newTestOutlierDetector(&OutlierDetectionSpec{MaxEjectionPercent: &zero})
+	for i := 0; i < 10; i++ {
+		od.record("http://a", 500, false, 1)
+	}
+	if od.isEjected("http://a") {
+		t.Fatalf("server should not be ejected when max ejection percent is 0")
+	}
+
+	od = newTestOutlierDetector(&OutlierDetectionSpec{Consecutive5xx: &zero})
+	for i := 0; i < 10; i++ {
+		od.record("http://a", 500, false, 1)
+	}
+	if od.isEjected("http://a") {
+		t.Fatalf("server should not be ejected when consecutive5xx is 0")
+	}
+}
+
+func TestServersSkipEjected(t *testing.T) {
+	s := &servers{
+		static: newStaticServers([]*Server{
+			{URL: "http://127.0.0.1:9091"},
+			{URL: "http://127.0.0.1:9092"},
+		}, nil, &LoadBalance{Policy: PolicyRoundRobin}),
+		outlier: newTestOutlierDetector(&OutlierDetectionSpec{}),
+	}
+
+	for i := 0; i < 5; i++ {
+		s.outlier.record("http://127.0.0.1:9091", 500, false, 2)
+	}
+
+	ctx := &contexttest.MockedHTTPContext{}
+	for i := 0; i < 10; i++ {
+		server, _ := s.next(ctx)
+		if server.URL != "http://127.0.0.1:9092" {
+			t.Fatalf("ejected server should be skipped, got %s", server.URL)
+		}
+	}
+}
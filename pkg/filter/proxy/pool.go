@@ -18,11 +18,15 @@
 package proxy
 
 import (
+	stdcontext "context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/opentracing/opentracing-go"
 	gohttpstat "github.com/tcnksm/go-httpstat"
@@ -32,6 +36,7 @@ import (
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/tracing"
 	"github.com/megaease/easegress/pkg/util/callbackreader"
+	"github.com/megaease/easegress/pkg/util/connstat"
 	"github.com/megaease/easegress/pkg/util/httpfilter"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/httpstat"
@@ -39,6 +44,10 @@ import (
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
 
+// errUpstreamTimeout is returned if the response headers of the upstream
+// request aren't received within the upstream timeout of the request.
+var errUpstreamTimeout = errors.New("upstream timeout")
+
 type (
 	pool struct {
 		spec *PoolSpec
@@ -50,7 +59,9 @@ type (
 
 		servers     *servers
 		httpStat    *httpstat.HTTPStat
+		connStat    *connstat.ConnStat
 		memoryCache *memorycache.MemoryCache
+		sizeLimit   *ResponseSizeLimitSpec
 	}
 
 	// PoolSpec describes a pool of servers.
@@ -63,11 +74,19 @@ type (
 		ServiceName     string            `yaml:"serviceName" jsonschema:"omitempty"`
 		LoadBalance     *LoadBalance      `yaml:"loadBalance" jsonschema:"required"`
 		MemoryCache     *memorycache.Spec `yaml:"memoryCache,omitempty" jsonschema:"omitempty"`
+
+		OutlierDetection *OutlierDetectionSpec `yaml:"outlierDetection,omitempty" jsonschema:"omitempty"`
+		RetryAfter       *RetryAfterSpec       `yaml:"retryAfter,omitempty" jsonschema:"omitempty"`
 	}
 
 	// PoolStatus is the status of Pool.
 	PoolStatus struct {
-		Stat *httpstat.Status `yaml:"stat"`
+		Stat           *httpstat.Status `yaml:"stat"`
+		Conn           *connstat.Status `yaml:"conn"`
+		EjectedServers []*EjectedServer `yaml:"ejectedServers,omitempty"`
+		// RetryAfterServers are the servers blocked by Retry-After.
+		RetryAfterServers []*RetryAfterServer `yaml:"retryAfterServers,omitempty"`
+		MemoryCache       *memorycache.Status `yaml:"memoryCache,omitempty"`
 	}
 )
 
@@ -99,7 +118,7 @@ func (s PoolSpec) Validate() error {
 }
 
 func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
-	writeResponse bool, failureCodes []int) *pool {
+	writeResponse bool, failureCodes []int, joinTimes map[string]time.Time) *pool {
 
 	var filter *httpfilter.HTTPFilter
 	if spec.Filter != nil {
@@ -111,6 +130,14 @@ func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
 		memoryCache = memorycache.New(spec.MemoryCache)
 	}
 
+	servers := newServers(super, spec, joinTimes)
+	if spec.OutlierDetection != nil {
+		servers.outlier = newOutlierDetector(tagPrefix, spec.OutlierDetection)
+	}
+	if spec.RetryAfter != nil {
+		servers.retryAfter = newRetryAfterTracker(tagPrefix, spec.RetryAfter)
+	}
+
 	return &pool{
 		spec: spec,
 
@@ -118,14 +145,27 @@ func newPool(super *supervisor.Supervisor, spec *PoolSpec, tagPrefix string,
 		writeResponse: writeResponse,
 
 		filter:      filter,
-		servers:     newServers(super, spec),
+		servers:     servers,
 		httpStat:    httpstat.New(),
+		connStat:    connstat.New(),
 		memoryCache: memoryCache,
 	}
 }
 
 func (p *pool) status() *PoolStatus {
-	s := &PoolStatus{Stat: p.httpStat.Status()}
+	s := &PoolStatus{
+		Stat: p.httpStat.Status(),
+		Conn: p.connStat.Status(),
+	}
+	if p.servers.outlier != nil {
+		s.EjectedServers = p.servers.outlier.status()
+	}
+	if p.servers.retryAfter != nil {
+		s.RetryAfterServers = p.servers.retryAfter.status()
+	}
+	if p.memoryCache != nil {
+		s.MemoryCache = p.memoryCache.Status()
+	}
 	return s
 }
 
@@ -172,6 +212,21 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 	}
 	addLazyTag("addr", server.URL, -1)
 
+	// NOTE: All servers are blocked if the selected one is blocked.
+	if p.servers.retryAfter != nil {
+		if code, wait, blocked := p.servers.retryAfter.blocked(server.URL); blocked {
+			addLazyTag("retryAfterBlocked", retryAfterValue(wait), -1)
+			ctx.Lock()
+			ctx.Response().Header().Set("Retry-After", retryAfterValue(wait))
+			ctx.Response().SetStatusCode(code)
+			ctx.Unlock()
+			return resultServerError
+		}
+	}
+	if info := context.GetDebugInfo(ctx); info != nil {
+		info.AddUpstream(p.tagPrefix, server.URL)
+	}
+
 	req, err := p.prepareRequest(ctx, server, reqBody, requestPool, httpstatResultPool)
 	if err != nil {
 		msg := stringtool.Cat("prepare request failed: ", err.Error())
@@ -194,19 +249,53 @@ func (p *pool) handle(ctx context.HTTPContext, reqBody io.Reader, client *http.C
 			return resultClientError
 		}
 
-		setStatusCode(http.StatusServiceUnavailable)
+		if p.servers.outlier != nil {
+			p.servers.outlier.record(server.URL, 0, true, p.servers.len())
+		}
+
+		if err == errUpstreamTimeout {
+			setStatusCode(http.StatusGatewayTimeout)
+		} else {
+			setStatusCode(http.StatusServiceUnavailable)
+		}
 		return resultServerError
 	}
 
 	addLazyTag("code", "", resp.StatusCode)
+	if p.servers.outlier != nil {
+		p.servers.outlier.record(server.URL, resp.StatusCode, false, p.servers.len())
+	}
+	if p.servers.retryAfter != nil {
+		p.servers.retryAfter.record(server.URL, resp.StatusCode, resp.Header)
+	}
 
 	ctx.Lock()
 	defer ctx.Unlock()
 	// NOTE: The code below can't use addTag and setStatusCode in case of deadlock.
 
-	respBody := p.statRequestResponse(ctx, req, resp, span)
+	respBody, finish := p.statRequestResponse(ctx, req, resp, span)
 
 	if p.writeResponse {
+		// NOTE: The body of the response switching protocols is the
+		// connection to the server, the HTTPServer tunnels it.
+		if resp.StatusCode == http.StatusSwitchingProtocols {
+			finish()
+			ctx.Response().SetStatusCode(resp.StatusCode)
+			ctx.Response().Header().SetRaw(resp.Header)
+			ctx.Response().SetBody(resp.Body)
+			return ""
+		}
+
+		if p.sizeLimit != nil {
+			var tooLarge bool
+			respBody, tooLarge = p.sizeLimit.limit(resp, respBody, finish)
+			if tooLarge {
+				ctx.AddTag(stringtool.Cat(p.tagPrefix, "#responseTooLarge: ", strconv.Itoa(resp.StatusCode)))
+				ctx.Response().SetStatusCode(http.StatusBadGateway)
+				return resultServerError
+			}
+		}
+
 		ctx.Response().SetStatusCode(resp.StatusCode)
 		ctx.Response().Header().SetRaw(resp.Header)
 		ctx.Response().SetBody(respBody)
@@ -246,8 +335,25 @@ func (p *pool) doRequest(ctx context.HTTPContext, req *request, client *http.Cli
 	span := ctx.Span().NewChildWithStart(spanName, req.startTime())
 	span.Tracer().Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.std.Header))
 
-	resp, err := fnSendRequest(req.std, client)
+	stdr := req.std
+	var timedOut int32
+	if timeout := context.GetUpstreamTimeout(ctx); timeout > 0 {
+		// NOTE: Only the time to the response headers is bounded, the
+		// body is streamed to the client after the request returns.
+		reqCtx, cancel := stdcontext.WithCancel(stdr.Context())
+		timer := time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cancel()
+		})
+		defer timer.Stop()
+		stdr = stdr.WithContext(reqCtx)
+	}
+
+	resp, err := fnSendRequest(stdr, client)
 	if err != nil {
+		if atomic.LoadInt32(&timedOut) == 1 {
+			err = errUpstreamTimeout
+		}
 		return nil, nil, err
 	}
 	return resp, span, nil
@@ -259,17 +365,26 @@ var httpstatMetricPool = sync.Pool{
 	},
 }
 
+// statRequestResponse returns the body to read and a function to finish
+// the request, which is called automatically when the body reaches EOF.
 func (p *pool) statRequestResponse(ctx context.HTTPContext,
-	req *request, resp *http.Response, span tracing.Span) io.Reader {
+	req *request, resp *http.Response, span tracing.Span) (io.Reader, func()) {
 
 	var count int
 
+	var finishOnce sync.Once
+	finish := func() {
+		finishOnce.Do(func() {
+			req.finish()
+			span.Finish()
+		})
+	}
+
 	callbackBody := callbackreader.New(resp.Body)
 	callbackBody.OnAfter(func(num int, p []byte, n int, err error) ([]byte, int, error) {
 		count += n
 		if err == io.EOF {
-			req.finish()
-			span.Finish()
+			finish()
 		}
 
 		return p, n, err
@@ -277,8 +392,7 @@ func (p *pool) statRequestResponse(ctx context.HTTPContext,
 
 	ctx.OnFinish(func() {
 		if !p.writeResponse {
-			req.finish()
-			span.Finish()
+			finish()
 		}
 		duration := req.total()
 		ctx.AddLazyTag(func() string {
@@ -290,6 +404,7 @@ func (p *pool) statRequestResponse(ctx context.HTTPContext,
 		metric.Duration = duration
 		metric.ReqSize = ctx.Request().Size()
 		metric.RespSize = uint64(responseMetaSize(resp) + count)
+		metric.ClientGone = ctx.ClientDisconnected()
 
 		if !p.writeResponse {
 			metric.RespSize = 0
@@ -301,7 +416,7 @@ func (p *pool) statRequestResponse(ctx context.HTTPContext,
 		requestPool.Put(req)
 	})
 
-	return callbackBody
+	return callbackBody, finish
 }
 
 func responseMetaSize(resp *http.Response) int {
@@ -30,7 +30,9 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
 	"github.com/megaease/easegress/pkg/util/fallback"
+	"github.com/megaease/easegress/pkg/util/fips"
 )
 
 const (
@@ -73,6 +75,11 @@ type (
 		client *http.Client
 
 		compression *compression
+
+		// joinTimes are the join times of servers inherited from the
+		// previous generation, for slow start.
+		joinTimes map[string]time.Time
+		draining  *drainingServers
 	}
 
 	// Spec describes the Proxy.
@@ -86,6 +93,12 @@ type (
 		MTLS                *MTLS            `yaml:"mtls,omitempty" jsonschema:"omitempty"`
 		MaxIdleConns        int              `yaml:"maxIdleConns" jsonschema:"omitempty"`
 		MaxIdleConnsPerHost int              `yaml:"maxIdleConnsPerHost" jsonschema:"omitempty"`
+
+		ResponseSizeLimit *ResponseSizeLimitSpec `yaml:"responseSizeLimit,omitempty" jsonschema:"omitempty"`
+
+		// EgressProxy is the forward proxy of the requests to the
+		// backends, it overrides the global one of the server.
+		EgressProxy *egressproxy.Spec `yaml:"egressProxy,omitempty" jsonschema:"omitempty"`
 	}
 
 	// FallbackSpec describes the fallback policy.
@@ -143,6 +156,12 @@ func (s Spec) Validate() error {
 		}
 	}
 
+	if s.EgressProxy != nil {
+		if err := s.EgressProxy.Validate(); err != nil {
+			return fmt.Errorf("egressProxy: %v", err)
+		}
+	}
+
 	return nil
 }
 
@@ -177,6 +196,7 @@ func (b *Proxy) Init(filterSpec *httppipeline.FilterSpec) {
 
 // Inherit inherits previous generation of Proxy.
 func (b *Proxy) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	b.joinTimes = previousGeneration.(*Proxy).serverJoinTimes()
 	previousGeneration.Close()
 	b.Init(filterSpec)
 }
@@ -212,11 +232,19 @@ func (b *Proxy) tlsConfig() *tls.Config {
 	}
 }
 
+// fipsTLSConfig restricts the TLS config to the approved settings in FIPS
+// mode.
+func (b *Proxy) fipsTLSConfig() *tls.Config {
+	tlsConf := b.tlsConfig()
+	fips.ApplyTLS(tlsConf)
+	return tlsConf
+}
+
 func (b *Proxy) reload() {
 	super := b.filterSpec.Super()
 
 	b.mainPool = newPool(super, b.spec.MainPool, "proxy#main",
-		true /*writeResponse*/, b.spec.FailureCodes)
+		true /*writeResponse*/, b.spec.FailureCodes, b.joinTimes)
 
 	if b.spec.Fallback != nil {
 		b.fallback = fallback.New(&b.spec.Fallback.Spec)
@@ -227,30 +255,45 @@ func (b *Proxy) reload() {
 		for k := range b.spec.CandidatePools {
 			candidatePools = append(candidatePools,
 				newPool(super, b.spec.CandidatePools[k], fmt.Sprintf("proxy#candidate#%d", k),
-					true, b.spec.FailureCodes))
+					true, b.spec.FailureCodes, b.joinTimes))
 		}
 		b.candidatePools = candidatePools
 	}
 	if b.spec.MirrorPool != nil {
 		b.mirrorPool = newPool(super, b.spec.MirrorPool, "proxy#mirror",
-			false /*writeResponse*/, b.spec.FailureCodes)
+			false /*writeResponse*/, b.spec.FailureCodes, b.joinTimes)
 	}
 
 	if b.spec.Compression != nil {
 		b.compression = newCompression(b.spec.Compression)
 	}
 
+	if b.spec.ResponseSizeLimit != nil {
+		for _, p := range b.pools() {
+			if p.writeResponse {
+				p.sizeLimit = b.spec.ResponseSizeLimit
+			}
+		}
+	}
+
+	if super != nil && super.Cluster() != nil {
+		b.draining = newDrainingServers(super.Cluster())
+		for _, p := range b.pools() {
+			p.servers.draining = b.draining
+		}
+	}
+
 	b.client = &http.Client{
 		// NOTE: Timeout could be no limit, real client or server could cancel it.
 		Timeout: 0,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
+			Proxy: egressproxy.ProxyFunc(b.spec.EgressProxy),
 			DialContext: (&net.Dialer{
 				Timeout:   30 * time.Second,
 				KeepAlive: 60 * time.Second,
 				DualStack: true,
 			}).DialContext,
-			TLSClientConfig:    b.tlsConfig(),
+			TLSClientConfig:    b.fipsTLSConfig(),
 			DisableCompression: false,
 			// NOTE: The large number of Idle Connections can
 			// reduce overhead of building connections.
@@ -266,6 +309,28 @@ func (b *Proxy) reload() {
 	}
 }
 
+func (b *Proxy) pools() []*pool {
+	pools := append([]*pool{b.mainPool}, b.candidatePools...)
+	if b.mirrorPool != nil {
+		pools = append(pools, b.mirrorPool)
+	}
+	return pools
+}
+
+// serverJoinTimes returns the join times of servers in all pools.
+func (b *Proxy) serverJoinTimes() map[string]time.Time {
+	joinTimes := map[string]time.Time{}
+	for _, p := range b.pools() {
+		for url, t := range p.servers.getJoinTimes() {
+			if prev, exists := joinTimes[url]; !exists || t.Before(prev) {
+				joinTimes[url] = t
+			}
+		}
+	}
+
+	return joinTimes
+}
+
 // Status returns Proxy status.
 func (b *Proxy) Status() interface{} {
 	s := &Status{
@@ -282,6 +347,9 @@ func (b *Proxy) Status() interface{} {
 	return s
 }
 
+// SendsUpstream marks Proxy as a filter sending requests to upstream servers.
+func (b *Proxy) SendsUpstream() {}
+
 // Close closes Proxy.
 func (b *Proxy) Close() {
 	b.mainPool.close()
@@ -295,6 +363,10 @@ func (b *Proxy) Close() {
 	if b.mirrorPool != nil {
 		b.mirrorPool.close()
 	}
+
+	if b.draining != nil {
+		b.draining.close()
+	}
 }
 
 func (b *Proxy) fallbackForCodes(ctx context.HTTPContext) bool {
@@ -357,6 +429,10 @@ func (b *Proxy) handle(ctx context.HTTPContext) (result string) {
 		return resultFallback
 	}
 
+	if ctx.Response().StatusCode() == http.StatusSwitchingProtocols {
+		return ""
+	}
+
 	// compression and memoryCache only work for
 	// normal traffic from real proxy servers.
 	if b.compression != nil {
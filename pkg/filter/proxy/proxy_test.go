@@ -25,6 +25,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
 	"github.com/megaease/easegress/pkg/util/httpfilter"
@@ -148,6 +149,48 @@ failureCodes: [503, 504]
 	time.Sleep(10 * time.Millisecond)
 }
 
+func TestProxyUpstreamTimeout(t *testing.T) {
+	const yamlSpec = `
+name: proxy
+kind: Proxy
+mainPool:
+  servers:
+  - url: http://127.0.0.1:9095
+  loadBalance:
+    policy: roundRobin
+`
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, e := httppipeline.NewFilterSpec(rawSpec, nil)
+	if e != nil {
+		t.Fatalf("unexpected error: %v", e)
+	}
+
+	proxy := &Proxy{}
+	proxy.Init(spec)
+	defer proxy.Close()
+
+	fnSendRequest = func(r *http.Request, client *http.Client) (*http.Response, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}
+
+	ctx := &contexttest.MockedHTTPContext{}
+	context.SetUpstreamTimeout(ctx, 10*time.Millisecond)
+	code := 0
+	ctx.MockedResponse.MockedSetStatusCode = func(c int) {
+		code = c
+	}
+
+	if result := proxy.Handle(ctx); result != resultServerError {
+		t.Errorf("expect result %s, got %s", resultServerError, result)
+	}
+	if code != http.StatusGatewayTimeout {
+		t.Errorf("expect status code 504, got %d", code)
+	}
+}
+
 func TestSpecValidate(t *testing.T) {
 	spec := Spec{}
 
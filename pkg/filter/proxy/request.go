@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptrace"
 	"sync"
 	"time"
 
@@ -30,6 +31,7 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/http1compat"
 )
 
 type (
@@ -69,12 +71,13 @@ func (p *pool) newRequest(
 	}
 
 	newCtx := httpstat.WithHTTPStat(ctx, req.statResult)
+	newCtx = httptrace.WithClientTrace(newCtx, p.connStat.ClientTrace())
 	stdr, err := http.NewRequestWithContext(newCtx, r.Method(), url, reqBody)
 	if err != nil {
 		return nil, fmt.Errorf("BUG: new request failed: %v", err)
 	}
 
-	stdr.Header = r.Header().Std()
+	stdr.Header = http1compat.RestoreHeaderCase(r.Std().Context(), r.Header().Std())
 	stdr.Host = r.Host()
 
 	req.std = stdr
@@ -0,0 +1,210 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const defaultRetryAfterMaxWait = time.Minute
+
+var defaultRetryAfterCodes = []int{http.StatusTooManyRequests, http.StatusServiceUnavailable}
+
+type (
+	// RetryAfterSpec describes how the Retry-After of responses is
+	// honored, the server responding with it is skipped by the load
+	// balancing until the window passes.
+	RetryAfterSpec struct {
+		// Codes are the status codes whose Retry-After is honored, the
+		// default is 429 and 503.
+		Codes []int `yaml:"codes" jsonschema:"omitempty,uniqueItems=true"`
+		// MaxWait caps the window of Retry-After, so a misbehaving server
+		// can't block itself for too long, the default is 1m.
+		MaxWait string `yaml:"maxWait" jsonschema:"omitempty,format=duration"`
+	}
+
+	// RetryAfterServer is the status of a server blocked by Retry-After.
+	RetryAfterServer struct {
+		URL        string    `yaml:"url"`
+		StatusCode int       `yaml:"statusCode"`
+		Until      time.Time `yaml:"until"`
+	}
+
+	retryAfterTracker struct {
+		name    string
+		codes   []int
+		maxWait time.Duration
+
+		mutex   sync.Mutex
+		servers map[string]*retryAfterServer
+	}
+
+	retryAfterServer struct {
+		code  int
+		until time.Time
+	}
+)
+
+// Validate validates RetryAfterSpec.
+func (spec *RetryAfterSpec) Validate() error {
+	for _, code := range spec.Codes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("invalid code %d", code)
+		}
+	}
+	if spec.MaxWait != "" {
+		if d, err := time.ParseDuration(spec.MaxWait); err != nil || d <= 0 {
+			return fmt.Errorf("invalid maxWait: %s", spec.MaxWait)
+		}
+	}
+	return nil
+}
+
+func newRetryAfterTracker(name string, spec *RetryAfterSpec) *retryAfterTracker {
+	t := &retryAfterTracker{
+		name:    name,
+		codes:   spec.Codes,
+		maxWait: defaultRetryAfterMaxWait,
+		servers: map[string]*retryAfterServer{},
+	}
+	if len(t.codes) == 0 {
+		t.codes = defaultRetryAfterCodes
+	}
+	if d, err := time.ParseDuration(spec.MaxWait); err == nil && d > 0 {
+		t.maxWait = d
+	}
+	return t
+}
+
+// parseRetryAfter parses the value of Retry-After, which is either the
+// seconds to wait or an HTTP date.
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs <= 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now), true
+	}
+	return 0, false
+}
+
+// record records the response of the server, the server is blocked if
+// the response has one of the codes and a valid Retry-After.
+func (t *retryAfterTracker) record(url string, code int, header http.Header) {
+	honored := false
+	for _, c := range t.codes {
+		if c == code {
+			honored = true
+			break
+		}
+	}
+	if !honored {
+		return
+	}
+
+	now := time.Now()
+	wait, ok := parseRetryAfter(header.Get("Retry-After"), now)
+	if !ok {
+		return
+	}
+	if wait > t.maxWait {
+		wait = t.maxWait
+	}
+	until := now.Add(wait)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := t.servers[url]
+	if s != nil && !until.After(s.until) {
+		return
+	}
+	t.servers[url] = &retryAfterServer{code: code, until: until}
+
+	logger.Infof("%s: server %s responded %d, it is blocked until %s by Retry-After",
+		t.name, url, code, until.Format(time.RFC3339))
+}
+
+// blocked returns the status code responded by the server and the time
+// to wait if the server is blocked.
+func (t *retryAfterTracker) blocked(url string) (int, time.Duration, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	s := t.servers[url]
+	if s == nil {
+		return 0, 0, false
+	}
+	wait := time.Until(s.until)
+	if wait <= 0 {
+		delete(t.servers, url)
+		return 0, 0, false
+	}
+	return s.code, wait, true
+}
+
+func (t *retryAfterTracker) isBlocked(url string) bool {
+	_, _, blocked := t.blocked(url)
+	return blocked
+}
+
+func (t *retryAfterTracker) status() []*RetryAfterServer {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	now := time.Now()
+	var result []*RetryAfterServer
+	for url, s := range t.servers {
+		if !s.until.After(now) {
+			continue
+		}
+		result = append(result, &RetryAfterServer{
+			URL:        url,
+			StatusCode: s.code,
+			Until:      s.until,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].URL < result[j].URL
+	})
+
+	return result
+}
+
+// retryAfterValue returns the value of Retry-After in seconds, which is
+// rounded up.
+func retryAfterValue(wait time.Duration) string {
+	secs := (wait + time.Second - 1) / time.Second
+	return strconv.Itoa(int(secs))
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"120", 120 * time.Second, true},
+		{" 5 ", 5 * time.Second, true},
+		{"0", 0, false},
+		{"-1", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+		{"Fri, 01 Jan 2021 00:00:30 GMT", 30 * time.Second, true},
+		{"Thu, 31 Dec 2020 23:59:00 GMT", 0, false},
+	}
+	for _, c := range cases {
+		wait, ok := parseRetryAfter(c.value, now)
+		if wait != c.wait || ok != c.ok {
+			t.Errorf("%q: expect %v %v, got %v %v", c.value, c.wait, c.ok, wait, ok)
+		}
+	}
+}
+
+func TestRetryAfterTracker(t *testing.T) {
+	logger.InitNop()
+
+	tracker := newRetryAfterTracker("test", &RetryAfterSpec{MaxWait: "10s"})
+	url := "http://127.0.0.1:9091"
+
+	header := http.Header{}
+	header.Set("Retry-After", "120")
+	tracker.record(url, http.StatusInternalServerError, header)
+	if tracker.isBlocked(url) {
+		t.Errorf("server should not be blocked for 500")
+	}
+
+	tracker.record(url, http.StatusTooManyRequests, header)
+	code, wait, blocked := tracker.blocked(url)
+	if !blocked || code != http.StatusTooManyRequests {
+		t.Fatalf("server should be blocked for 429")
+	}
+	if wait > 10*time.Second {
+		t.Errorf("wait should be capped by maxWait, got %v", wait)
+	}
+	if v := retryAfterValue(wait); v != "10" {
+		t.Errorf("expect Retry-After 10, got %s", v)
+	}
+
+	status := tracker.status()
+	if len(status) != 1 || status[0].URL != url || status[0].StatusCode != http.StatusTooManyRequests {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	tracker.servers[url].until = time.Now().Add(-time.Second)
+	if tracker.isBlocked(url) {
+		t.Errorf("server should not be blocked after the window")
+	}
+	if len(tracker.status()) != 0 {
+		t.Errorf("status should be empty")
+	}
+}
+
+func TestServersSkipRetryAfter(t *testing.T) {
+	logger.InitNop()
+
+	s := &servers{
+		static: newStaticServers([]*Server{
+			{URL: "http://127.0.0.1:9091"},
+			{URL: "http://127.0.0.1:9092"},
+		}, nil, &LoadBalance{Policy: PolicyRoundRobin}),
+		retryAfter: newRetryAfterTracker("test", &RetryAfterSpec{}),
+	}
+
+	header := http.Header{}
+	header.Set("Retry-After", "30")
+	s.retryAfter.record("http://127.0.0.1:9091", http.StatusServiceUnavailable, header)
+
+	ctx := &contexttest.MockedHTTPContext{}
+	for i := 0; i < 10; i++ {
+		server, _ := s.next(ctx)
+		if server.URL != "http://127.0.0.1:9092" {
+			t.Fatalf("blocked server should be skipped, got %s", server.URL)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
 	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/fasttime"
 	"github.com/megaease/easegress/pkg/util/hashtool"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
@@ -45,6 +46,9 @@ const (
 	PolicyHeaderHash = "headerHash"
 
 	retryTimeout = 3 * time.Second
+
+	// slowStartMinFactor is the traffic share factor of a server just joined.
+	slowStartMinFactor = 0.05
 )
 
 type (
@@ -57,6 +61,12 @@ type (
 		serviceWatcher  serviceregistry.ServiceWatcher
 		static          *staticServers
 		done            chan struct{}
+
+		// joinTimes records the time when servers joined, only used by slow start.
+		joinTimes  map[string]time.Time
+		draining   *drainingServers
+		outlier    *outlierDetector
+		retryAfter *retryAfterTracker
 	}
 
 	staticServers struct {
@@ -64,6 +74,11 @@ type (
 		weightsSum int
 		servers    []*Server
 		lb         LoadBalance
+
+		// slowStart related fields, only set when slow start is enabled.
+		slowStart    time.Duration
+		joinTimes    []time.Time
+		slowStartEnd time.Time
 	}
 
 	// Server is proxy server.
@@ -77,6 +92,9 @@ type (
 	LoadBalance struct {
 		Policy        string `yaml:"policy" jsonschema:"required,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash,enum=headerHash"`
 		HeaderHashKey string `yaml:"headerHashKey" jsonschema:"omitempty"`
+		// SlowStart is the window to ramp traffic share of a new server from
+		// a small fraction to its full weight, it doesn't work for hash policies.
+		SlowStart string `yaml:"slowStart" jsonschema:"omitempty,format=duration"`
 	}
 )
 
@@ -90,14 +108,36 @@ func (lb LoadBalance) Validate() error {
 		return fmt.Errorf("headerHash needs to specify headerHashKey")
 	}
 
+	if lb.SlowStart != "" {
+		if _, err := time.ParseDuration(lb.SlowStart); err != nil {
+			return fmt.Errorf("invalid slowStart: %v", err)
+		}
+	}
+
 	return nil
 }
 
-func newServers(super *supervisor.Supervisor, poolSpec *PoolSpec) *servers {
+func (lb *LoadBalance) slowStart() time.Duration {
+	if lb == nil || lb.SlowStart == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(lb.SlowStart)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", lb.SlowStart, err)
+		return 0
+	}
+	return d
+}
+
+// newServers creates servers, joinTimes are the join times of servers
+// inherited from the previous generation, which could be nil.
+func newServers(super *supervisor.Supervisor, poolSpec *PoolSpec, joinTimes map[string]time.Time) *servers {
 	s := &servers{
-		poolSpec: poolSpec,
-		super:    super,
-		done:     make(chan struct{}),
+		poolSpec:  poolSpec,
+		super:     super,
+		done:      make(chan struct{}),
+		joinTimes: joinTimes,
 	}
 
 	s.useStaticServers()
@@ -172,13 +212,29 @@ func (s *servers) useService(serviceInstanceSpecs map[string]*serviceregistry.Se
 
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.static = dynamicServers
+	s.setStatic(dynamicServers)
 }
 
 func (s *servers) useStaticServers() {
 	s.mutex.Lock()
 	defer s.mutex.Unlock()
-	s.static = newStaticServers(s.poolSpec.Servers, s.poolSpec.ServersTags, s.poolSpec.LoadBalance)
+	s.setStatic(newStaticServers(s.poolSpec.Servers, s.poolSpec.ServersTags, s.poolSpec.LoadBalance))
+}
+
+// setStatic sets the current static servers, the caller must hold the lock.
+func (s *servers) setStatic(static *staticServers) {
+	if slowStart := s.poolSpec.LoadBalance.slowStart(); slowStart > 0 {
+		s.joinTimes = static.prepareSlowStart(slowStart, s.joinTimes, time.Now())
+	}
+	s.static = static
+}
+
+// getJoinTimes returns the join times of current servers.
+func (s *servers) getJoinTimes() map[string]time.Time {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.joinTimes
 }
 
 func (s *servers) snapshot() *staticServers {
@@ -201,12 +257,37 @@ func (s *servers) next(ctx context.HTTPContext) (*Server, error) {
 		return nil, fmt.Errorf("no server available")
 	}
 
-	return static.next(ctx), nil
+	server := static.next(ctx)
+	if s.draining != nil {
+		server = static.undrain(ctx, server, s.draining)
+	}
+	if s.unavailable(server) {
+		server = static.exclude(ctx, server, func(server *Server) bool {
+			if s.draining != nil && s.draining.get(server.URL) != nil {
+				return true
+			}
+			return s.unavailable(server)
+		})
+	}
+	return server, nil
+}
+
+// unavailable returns whether the server is ejected by the outlier
+// detection or blocked by Retry-After.
+func (s *servers) unavailable(server *Server) bool {
+	if s.outlier != nil && s.outlier.isEjected(server.URL) {
+		return true
+	}
+	return s.retryAfter != nil && s.retryAfter.isBlocked(server.URL)
 }
 
 func (s *servers) close() {
 	close(s.done)
 
+	if s.outlier != nil {
+		s.outlier.close()
+	}
+
 	if s.serviceWatcher != nil {
 		s.serviceWatcher.Stop()
 	}
@@ -251,11 +332,46 @@ func (ss *staticServers) prepare() {
 	}
 }
 
+// prepareSlowStart records the join times of servers, the servers in
+// joinTimes keep their join times, others join at now. It returns the
+// join times of current servers.
+func (ss *staticServers) prepareSlowStart(slowStart time.Duration,
+	joinTimes map[string]time.Time, now time.Time) map[string]time.Time {
+
+	newJoinTimes := make(map[string]time.Time, len(ss.servers))
+	ss.slowStart = slowStart
+	ss.joinTimes = make([]time.Time, len(ss.servers))
+	for i, server := range ss.servers {
+		t, exists := joinTimes[server.URL]
+		if !exists {
+			t = now
+		}
+		newJoinTimes[server.URL] = t
+		ss.joinTimes[i] = t
+
+		if end := t.Add(slowStart); end.After(ss.slowStartEnd) {
+			ss.slowStartEnd = end
+		}
+	}
+
+	return newJoinTimes
+}
+
 func (ss *staticServers) len() int {
 	return len(ss.servers)
 }
 
 func (ss *staticServers) next(ctx context.HTTPContext) *Server {
+	if ss.slowStart > 0 {
+		switch ss.lb.Policy {
+		case PolicyRoundRobin, PolicyRandom, PolicyWeightedRandom:
+			now := fasttime.Now()
+			if now.Before(ss.slowStartEnd) {
+				return ss.slowStartRandom(now)
+			}
+		}
+	}
+
 	switch ss.lb.Policy {
 	case PolicyRoundRobin:
 		return ss.roundRobin(ctx)
@@ -274,6 +390,32 @@ func (ss *staticServers) next(ctx context.HTTPContext) *Server {
 	return ss.roundRobin(ctx)
 }
 
+// exclude returns the server if it is not excluded, otherwise picks
+// another server which is not excluded. The server is still returned if
+// all servers are excluded, to keep the service available.
+func (ss *staticServers) exclude(ctx context.HTTPContext, server *Server, excluded func(*Server) bool) *Server {
+	servers := make([]*Server, 0, len(ss.servers))
+	for _, s := range ss.servers {
+		if !excluded(s) {
+			servers = append(servers, s)
+		}
+	}
+	if len(servers) == 0 {
+		return server
+	}
+
+	rest := &staticServers{servers: servers, lb: ss.lb}
+	rest.prepare()
+	switch {
+	case ss.lb.Policy == PolicyRoundRobin:
+		// NOTE: The counter of the temporary servers always starts from 0.
+		return rest.random(ctx)
+	case ss.lb.Policy == PolicyWeightedRandom && rest.weightsSum == 0:
+		return rest.random(ctx)
+	}
+	return rest.next(ctx)
+}
+
 func (ss *staticServers) roundRobin(ctx context.HTTPContext) *Server {
 	count := atomic.AddUint64(&ss.count, 1)
 	// NOTE: start from 0.
@@ -300,6 +442,41 @@ func (ss *staticServers) weightedRandom(ctx context.HTTPContext) *Server {
 	return ss.random(ctx)
 }
 
+// slowStartRandom picks a server randomly, the weight of a server is
+// ramped up linearly in the slow start window after it joined.
+func (ss *staticServers) slowStartRandom(now time.Time) *Server {
+	weights := make([]float64, len(ss.servers))
+	sum := 0.0
+	for i, server := range ss.servers {
+		weight := 1.0
+		if ss.lb.Policy == PolicyWeightedRandom {
+			weight = float64(server.Weight)
+		}
+
+		factor := float64(now.Sub(ss.joinTimes[i])) / float64(ss.slowStart)
+		if factor < slowStartMinFactor {
+			factor = slowStartMinFactor
+		} else if factor > 1 {
+			factor = 1
+		}
+
+		weights[i] = weight * factor
+		sum += weights[i]
+	}
+
+	if sum > 0 {
+		randomWeight := rand.Float64() * sum
+		for i, weight := range weights {
+			randomWeight -= weight
+			if randomWeight < 0 {
+				return ss.servers[i]
+			}
+		}
+	}
+
+	return ss.servers[rand.Intn(len(ss.servers))]
+}
+
 func (ss *staticServers) ipHash(ctx context.HTTPContext) *Server {
 	sum32 := int(hashtool.Hash32(ctx.Request().RealIP()))
 	return ss.servers[sum32%len(ss.servers)]
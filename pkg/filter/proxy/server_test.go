@@ -23,6 +23,7 @@ import (
 	"reflect"
 	"sort"
 	"testing"
+	"time"
 
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/object/serviceregistry"
@@ -407,3 +408,53 @@ func TestDynamicService(t *testing.T) {
 		t.Fatalf("want: %+v\ngot :%+v\n", wantStatic, s.static)
 	}
 }
+
+func TestSlowStart(t *testing.T) {
+	lb := &LoadBalance{Policy: PolicyRoundRobin, SlowStart: "10m"}
+	if err := lb.Validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	s := &servers{
+		poolSpec: &PoolSpec{
+			LoadBalance: lb,
+			Servers: []*Server{
+				{URL: "http://127.0.0.1:9091"},
+				{URL: "http://127.0.0.1:9092"},
+			},
+		},
+	}
+
+	// Both servers join at the same time, so they share traffic equally.
+	s.useStaticServers()
+	old := s.getJoinTimes()
+	if len(old) != 2 {
+		t.Fatalf("expect 2 join times, got %v", old)
+	}
+
+	// Make the old servers warmed up and add a new one.
+	joinTimes := map[string]time.Time{}
+	for url := range old {
+		joinTimes[url] = time.Now().Add(-time.Hour)
+	}
+	s.joinTimes = joinTimes
+	s.poolSpec.Servers = append(s.poolSpec.Servers, &Server{URL: "http://127.0.0.1:9093"})
+	s.useStaticServers()
+
+	counts := map[string]int{}
+	ctx := &contexttest.MockedHTTPContext{}
+	for i := 0; i < 10000; i++ {
+		server, err := s.next(ctx)
+		if err != nil {
+			t.Fatalf("next failed: %v", err)
+		}
+		counts[server.URL]++
+	}
+
+	if counts["http://127.0.0.1:9093"] > 1000 {
+		t.Errorf("new server should get a small traffic share, got %v", counts)
+	}
+	if counts["http://127.0.0.1:9091"] < 4000 || counts["http://127.0.0.1:9092"] < 4000 {
+		t.Errorf("old servers should get most traffic, got %v", counts)
+	}
+}
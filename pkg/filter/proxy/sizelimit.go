@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	sizeLimitActionFail     = "fail"
+	sizeLimitActionTruncate = "truncate"
+
+	// headerResponseTruncated is set to truncated responses.
+	headerResponseTruncated = "X-EG-Response-Truncated"
+)
+
+type (
+	// ResponseSizeLimitSpec describes the limit of response body size.
+	ResponseSizeLimitSpec struct {
+		MaxSize int64 `yaml:"maxSize" jsonschema:"required,minimum=1"`
+		// Action is the action for responses exceeding MaxSize, fail
+		// responses 502 and truncate keeps the first MaxSize bytes.
+		Action string `yaml:"action" jsonschema:"omitempty,enum=,enum=fail,enum=truncate"`
+		// ExemptContentTypes are the prefixes of content types which are
+		// streamed through without limit, e.g. text/event-stream, video/.
+		ExemptContentTypes []string `yaml:"exemptContentTypes" jsonschema:"omitempty"`
+	}
+
+	// limitedBody is the response body replacing the original one, and
+	// closing it closes the original one.
+	limitedBody struct {
+		io.Reader
+		close func()
+	}
+)
+
+func (lb *limitedBody) Close() error {
+	lb.close()
+	return nil
+}
+
+func (l *ResponseSizeLimitSpec) exempt(header http.Header) bool {
+	if len(l.ExemptContentTypes) == 0 {
+		return false
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header.Get(httpheader.KeyContentType))
+	if err != nil {
+		return false
+	}
+
+	for _, t := range l.ExemptContentTypes {
+		if strings.HasPrefix(mediaType, strings.ToLower(t)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// limit applies the limit to the body of resp, body is the reader of
+// resp.Body and finish finishes the statistics of the request if body is
+// not read to EOF. It returns the new body, and whether the response is
+// too large and must fail.
+//
+// If the content length is unknown, at most MaxSize+1 bytes are read
+// ahead to decide whether the response exceeds the limit.
+func (l *ResponseSizeLimitSpec) limit(resp *http.Response, body io.Reader, finish func()) (io.Reader, bool) {
+	if resp.ContentLength >= 0 && resp.ContentLength <= l.MaxSize {
+		return body, false
+	}
+
+	if l.exempt(resp.Header) {
+		return body, false
+	}
+
+	closeBody := func() {
+		resp.Body.Close()
+		finish()
+	}
+
+	if resp.ContentLength > l.MaxSize {
+		if l.Action != sizeLimitActionTruncate {
+			closeBody()
+			return nil, true
+		}
+		l.markTruncated(resp)
+		return &limitedBody{Reader: io.LimitReader(body, l.MaxSize), close: closeBody}, false
+	}
+
+	buff := bytes.NewBuffer(nil)
+	n, err := io.CopyN(buff, body, l.MaxSize+1)
+	if n <= l.MaxSize {
+		if err == io.EOF {
+			resp.Header.Set(httpheader.KeyContentLength, strconv.FormatInt(n, 10))
+			return &limitedBody{Reader: buff, close: closeBody}, false
+		}
+		// NOTE: Pass the read error to the client as it is.
+		return &limitedBody{Reader: io.MultiReader(buff, body), close: closeBody}, false
+	}
+
+	if l.Action != sizeLimitActionTruncate {
+		closeBody()
+		return nil, true
+	}
+
+	buff.Truncate(int(l.MaxSize))
+	l.markTruncated(resp)
+	return &limitedBody{Reader: buff, close: closeBody}, false
+}
+
+func (l *ResponseSizeLimitSpec) markTruncated(resp *http.Response) {
+	resp.Header.Set(headerResponseTruncated, "true")
+	resp.Header.Set(httpheader.KeyContentLength, strconv.FormatInt(l.MaxSize, 10))
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newSizeLimitResponse(body string, contentLength int64, contentType string) *http.Response {
+	header := http.Header{}
+	if contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	return &http.Response{
+		Header:        header,
+		ContentLength: contentLength,
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestResponseSizeLimit(t *testing.T) {
+	l := &ResponseSizeLimitSpec{MaxSize: 5, ExemptContentTypes: []string{"text/event-stream"}}
+
+	for _, cl := range []int64{3, -1} {
+		finished := 0
+		resp := newSizeLimitResponse("abc", cl, "")
+		body, tooLarge := l.limit(resp, resp.Body, func() { finished++ })
+		if tooLarge {
+			t.Errorf("small response should pass")
+		}
+		if data, _ := ioutil.ReadAll(body); string(data) != "abc" {
+			t.Errorf("body should be abc, but got %q", data)
+		}
+	}
+
+	for _, cl := range []int64{10, -1} {
+		finished := 0
+		resp := newSizeLimitResponse("0123456789", cl, "")
+		if _, tooLarge := l.limit(resp, resp.Body, func() { finished++ }); !tooLarge {
+			t.Errorf("large response should fail")
+		}
+		if finished != 1 {
+			t.Errorf("request should be finished")
+		}
+	}
+
+	resp := newSizeLimitResponse("0123456789", -1, "text/event-stream; charset=utf-8")
+	body, tooLarge := l.limit(resp, resp.Body, func() {})
+	if tooLarge {
+		t.Errorf("exempt content type should pass")
+	}
+	if data, _ := ioutil.ReadAll(body); string(data) != "0123456789" {
+		t.Errorf("body should not be changed, but got %q", data)
+	}
+
+	l.Action = sizeLimitActionTruncate
+	for _, cl := range []int64{10, -1} {
+		finished := 0
+		resp := newSizeLimitResponse("0123456789", cl, "")
+		body, tooLarge := l.limit(resp, resp.Body, func() { finished++ })
+		if tooLarge {
+			t.Errorf("large response should be truncated")
+		}
+		if data, _ := ioutil.ReadAll(body); string(data) != "01234" {
+			t.Errorf("body should be 01234, but got %q", data)
+		}
+		if resp.Header.Get(headerResponseTruncated) != "true" || resp.Header.Get("Content-Length") != "5" {
+			t.Errorf("unexpected headers: %v", resp.Header)
+		}
+		body.(io.Closer).Close()
+		if finished != 1 {
+			t.Errorf("request should be finished")
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+const noValue = "<no value>"
+
+type (
+	// keyedRateLimiter limits requests separately by their keys.
+	keyedRateLimiter struct {
+		policy *librl.Policy
+		tmpl   *template.Template
+
+		// idle is the duration after which an unused rate limiter has
+		// full permissions again, so it can be removed safely.
+		idle time.Duration
+
+		mutex     sync.Mutex
+		limiters  map[string]*keyedEntry
+		lastSweep time.Time
+	}
+
+	keyedEntry struct {
+		rl       *librl.RateLimiter
+		lastUsed time.Time
+	}
+)
+
+func newKeyTemplate(key string) (*template.Template, error) {
+	return template.New("key").Option("missingkey=zero").Parse(key)
+}
+
+// renderKey renders the key of the request, the key is empty if the
+// template fails, e.g. there are no JWT claims, and these requests share
+// the same rate limiter.
+func renderKey(tmpl *template.Template, ctx context.HTTPContext) string {
+	r := ctx.Request()
+	data := map[string]interface{}{
+		"jwt":    ctx.GetKV(context.KeyJWTClaims),
+		"realIP": r.RealIP(),
+		"method": r.Method(),
+		"host":   r.Host(),
+		"path":   r.Path(),
+		"header": r.Header().Std(),
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return ""
+	}
+
+	return strings.ReplaceAll(sb.String(), noValue, "")
+}
+
+func newKeyedRateLimiter(policy *librl.Policy, tmpl *template.Template) *keyedRateLimiter {
+	idle := policy.LimitRefreshPeriod + policy.TimeoutDuration
+	if idle < time.Second {
+		idle = time.Second
+	}
+
+	return &keyedRateLimiter{
+		policy:    policy,
+		tmpl:      tmpl,
+		idle:      idle,
+		limiters:  map[string]*keyedEntry{},
+		lastSweep: time.Now(),
+	}
+}
+
+// get returns the rate limiter of the key, creates one if not exists.
+func (k *keyedRateLimiter) get(key string) *librl.RateLimiter {
+	now := time.Now()
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	if now.Sub(k.lastSweep) > k.idle {
+		k.sweep(now)
+	}
+
+	entry := k.limiters[key]
+	if entry == nil {
+		entry = &keyedEntry{rl: librl.New(k.policy)}
+		k.limiters[key] = entry
+	}
+	entry.lastUsed = now
+
+	return entry.rl
+}
+
+func (k *keyedRateLimiter) sweep(now time.Time) {
+	for key, entry := range k.limiters {
+		if now.Sub(entry.lastUsed) > k.idle {
+			delete(k.limiters, key)
+		}
+	}
+	k.lastSweep = now
+}
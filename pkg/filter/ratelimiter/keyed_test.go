@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package ratelimiter
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	librl "github.com/megaease/easegress/pkg/util/ratelimiter"
+)
+
+func TestRenderKey(t *testing.T) {
+	ctx := &contexttest.MockedHTTPContext{}
+	header := http.Header{}
+	header.Set("X-Tenant", "megaease")
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader {
+		return httpheader.New(header)
+	}
+	ctx.MockedRequest.MockedRealIP = func() string {
+		return "10.0.0.1"
+	}
+
+	tmpl, err := newKeyTemplate(`{{.jwt.sub}}`)
+	if err != nil {
+		t.Fatalf("parse template failed: %v", err)
+	}
+	if key := renderKey(tmpl, ctx); key != "" {
+		t.Errorf("key should be empty without claims, but got %q", key)
+	}
+
+	ctx.SetKV(context.KeyJWTClaims, map[string]interface{}{"sub": "tenant-1"})
+	if key := renderKey(tmpl, ctx); key != "tenant-1" {
+		t.Errorf("key should be tenant-1, but got %q", key)
+	}
+
+	tmpl, _ = newKeyTemplate(`{{.jwt.tenant}}/{{.header.Get "X-Tenant"}}/{{.realIP}}`)
+	if key := renderKey(tmpl, ctx); key != "/megaease/10.0.0.1" {
+		t.Errorf("key should be /megaease/10.0.0.1, but got %q", key)
+	}
+
+	if _, err := newKeyTemplate(`{{.jwt.sub`); err == nil {
+		t.Errorf("invalid template should fail")
+	}
+}
+
+func TestKeyedRateLimiter(t *testing.T) {
+	policy := librl.NewPolicy(0, time.Second, 1)
+	k := newKeyedRateLimiter(policy, nil)
+
+	if permitted, _ := k.get("a").AcquirePermission(); !permitted {
+		t.Errorf("first request of a should be permitted")
+	}
+	if permitted, _ := k.get("a").AcquirePermission(); permitted {
+		t.Errorf("second request of a should be limited")
+	}
+	if permitted, _ := k.get("b").AcquirePermission(); !permitted {
+		t.Errorf("first request of b should be permitted")
+	}
+
+	k.limiters["a"].lastUsed = time.Now().Add(-2 * k.idle)
+	k.lastSweep = time.Now().Add(-2 * k.idle)
+	k.get("b")
+	if _, exists := k.limiters["a"]; exists {
+		t.Errorf("idle rate limiter should be removed")
+	}
+	if _, exists := k.limiters["b"]; !exists {
+		t.Errorf("active rate limiter should be kept")
+	}
+}
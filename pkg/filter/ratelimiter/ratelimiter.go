@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
@@ -54,8 +55,12 @@ type (
 	// URLRule defines the rate limiter rule for a URL pattern
 	URLRule struct {
 		urlrule.URLRule `yaml:",inline"`
-		policy          *Policy
-		rl              *librl.RateLimiter
+		// Key is a template to generate the key of a request, requests
+		// are limited separately by keys, e.g. '{{.jwt.sub}}'.
+		Key    string `yaml:"key" jsonschema:"omitempty"`
+		policy *Policy
+		rl     *librl.RateLimiter
+		keyed  *keyedRateLimiter
 	}
 
 	// Spec is the configuration of a rate limiter
@@ -63,6 +68,9 @@ type (
 		Policies         []*Policy  `yaml:"policies" jsonschema:"required"`
 		DefaultPolicyRef string     `yaml:"defaultPolicyRef" jsonschema:"omitempty"`
 		URLs             []*URLRule `yaml:"urls" jsonschema:"required"`
+		// ResponseHeaders sets X-RateLimit-* and RateLimit-* headers to
+		// responses to tell clients the quota.
+		ResponseHeaders bool `yaml:"responseHeaders" jsonschema:"omitempty"`
 	}
 
 	// RateLimiter defines the rate limiter
@@ -90,6 +98,15 @@ URLLoop:
 		return fmt.Errorf("policy '%s' is not defined", name)
 	}
 
+	for _, u := range spec.URLs {
+		if u.Key == "" {
+			continue
+		}
+		if _, err := newKeyTemplate(u.Key); err != nil {
+			return fmt.Errorf("invalid key %q: %v", u.Key, err)
+		}
+	}
+
 	return nil
 }
 
@@ -114,7 +131,13 @@ func (url *URLRule) createRateLimiter() {
 		policy.LimitRefreshPeriod = 10 * time.Millisecond
 	}
 
-	url.rl = librl.New(&policy)
+	if url.Key == "" {
+		url.rl = librl.New(&policy)
+		return
+	}
+
+	tmpl, _ := newKeyTemplate(url.Key)
+	url.keyed = newKeyedRateLimiter(&policy, tmpl)
 }
 
 // Kind returns the kind of RateLimiter.
@@ -138,6 +161,11 @@ func (rl *RateLimiter) Results() []string {
 }
 
 func (rl *RateLimiter) setStateListenerForURL(u *URLRule) {
+	// NOTE: State transitions of keyed rate limiters are too many to log.
+	if u.rl == nil {
+		return
+	}
+
 	u.rl.SetStateListener(func(event *librl.Event) {
 		logger.Infof("state of rate limiter '%s' on URL(%s) transited to %s at %d",
 			rl.filterSpec.Name(),
@@ -206,7 +234,7 @@ func (rl *RateLimiter) reload(previousGeneration *RateLimiter) {
 OuterLoop:
 	for _, url := range rl.spec.URLs {
 		for _, prev := range previousGeneration.spec.URLs {
-			if !url.DeepEqual(&prev.URLRule) {
+			if !url.DeepEqual(&prev.URLRule) || url.Key != prev.Key {
 				continue
 			}
 			if !isSamePolicy(rl.spec, previousGeneration.spec, url.PolicyRef) {
@@ -215,8 +243,8 @@ OuterLoop:
 
 			url.Init()
 			rl.bindPolicyToURL(url)
-			url.rl = prev.rl
-			prev.rl = nil
+			url.rl, url.keyed = prev.rl, prev.keyed
+			prev.rl, prev.keyed = nil, nil
 			rl.setStateListenerForURL(url)
 			continue OuterLoop
 		}
@@ -248,11 +276,21 @@ func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
 			continue
 		}
 
-		permitted, d := u.rl.AcquirePermission()
+		limiter := u.rl
+		if u.keyed != nil {
+			limiter = u.keyed.get(renderKey(u.keyed.tmpl, ctx))
+		}
+
+		permitted, d := limiter.AcquirePermission()
+		if rl.spec.ResponseHeaders {
+			limit, remaining, reset := limiter.Quota()
+			setQuotaHeaders(ctx, limit, remaining, reset)
+		}
 		if !permitted {
 			ctx.AddTag("rateLimiter: too many requests")
 			ctx.Response().SetStatusCode(http.StatusTooManyRequests)
 			ctx.Response().Std().Header().Set("X-EG-Rate-Limiter", "too-many-requests")
+			ctx.Response().Std().Header().Set("Retry-After", ceilSeconds(limiter.RetryAfter()))
 			return resultRateLimited
 		}
 
@@ -273,6 +311,30 @@ func (rl *RateLimiter) handle(ctx context.HTTPContext) string {
 	return ""
 }
 
+// ceilSeconds converts d to the number of seconds rounded up, the minimum
+// value is 1 because clients treat 0 as retrying immediately.
+func ceilSeconds(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// setQuotaHeaders sets both the de facto X-RateLimit-* headers and the
+// RateLimit-* headers defined by the IETF draft.
+func setQuotaHeaders(ctx context.HTTPContext, limit, remaining int, reset time.Duration) {
+	h := ctx.Response().Std().Header()
+	l, r, s := strconv.Itoa(limit), strconv.Itoa(remaining), ceilSeconds(reset)
+
+	h.Set("X-RateLimit-Limit", l)
+	h.Set("X-RateLimit-Remaining", r)
+	h.Set("X-RateLimit-Reset", s)
+	h.Set("RateLimit-Limit", l)
+	h.Set("RateLimit-Remaining", r)
+	h.Set("RateLimit-Reset", s)
+}
+
 // Status returns Status generated by Runtime.
 func (rl *RateLimiter) Status() interface{} {
 	return nil
@@ -0,0 +1,198 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package recorder
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	fixtureExt = ".json"
+
+	// maxDiffBodySize is the max size of bodies shown in diffs.
+	maxDiffBodySize = 128
+)
+
+type (
+	// Fixture is a recorded pair of request and response.
+	Fixture struct {
+		Key        string          `json:"key"`
+		RecordedAt time.Time       `json:"recordedAt"`
+		Request    FixtureRequest  `json:"request"`
+		Response   FixtureResponse `json:"response"`
+	}
+
+	// FixtureRequest is the recorded request.
+	FixtureRequest struct {
+		Method string      `json:"method"`
+		Path   string      `json:"path"`
+		Query  string      `json:"query,omitempty"`
+		Header http.Header `json:"header,omitempty"`
+		FixtureBody
+	}
+
+	// FixtureResponse is the recorded response.
+	FixtureResponse struct {
+		StatusCode int         `json:"statusCode"`
+		Header     http.Header `json:"header,omitempty"`
+		FixtureBody
+	}
+
+	// FixtureBody is the recorded body, text bodies are kept as they
+	// are for readability, the others are encoded in base64.
+	FixtureBody struct {
+		Body       string `json:"body,omitempty"`
+		BodyBase64 string `json:"bodyBase64,omitempty"`
+	}
+)
+
+// fixtureKey returns the key of the request, requests with the same
+// method, path, query and body share the same fixture.
+func fixtureKey(method, path, query string, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n", method, path, query)
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (fb *FixtureBody) set(body []byte) {
+	if utf8.Valid(body) {
+		fb.Body, fb.BodyBase64 = string(body), ""
+	} else {
+		fb.Body, fb.BodyBase64 = "", base64.StdEncoding.EncodeToString(body)
+	}
+}
+
+func (fb *FixtureBody) get() ([]byte, error) {
+	if fb.BodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(fb.BodyBase64)
+	}
+	return []byte(fb.Body), nil
+}
+
+func saveFixture(dir string, f *Fixture) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, f.Key+fixtureExt), data, 0o644)
+}
+
+func loadFixtures(dir string) (map[string]*Fixture, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fixtures := map[string]*Fixture{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != fixtureExt {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		f := &Fixture{}
+		if err := json.Unmarshal(data, f); err != nil {
+			return nil, fmt.Errorf("unmarshal %s failed: %v", path, err)
+		}
+		if f.Key == "" {
+			return nil, fmt.Errorf("%s: empty key", path)
+		}
+		fixtures[f.Key] = f
+	}
+
+	return fixtures, nil
+}
+
+// diff compares the actual response with the recorded one, headers in
+// ignoreHeaders and headers absent from the recorded response are not
+// compared. JSON bodies are compared semantically.
+func diff(expected *FixtureResponse, statusCode int, header http.Header,
+	body []byte, ignoreHeaders map[string]bool) []string {
+	var diffs []string
+
+	if statusCode != expected.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("status code: want %d, got %d",
+			expected.StatusCode, statusCode))
+	}
+
+	keys := make([]string, 0, len(expected.Header))
+	for key := range expected.Header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if ignoreHeaders[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		want, got := expected.Header[key], header.Values(key)
+		if strings.Join(want, ", ") != strings.Join(got, ", ") {
+			diffs = append(diffs, fmt.Sprintf("header %s: want %q, got %q",
+				key, strings.Join(want, ", "), strings.Join(got, ", ")))
+		}
+	}
+
+	wantBody, err := expected.get()
+	if err != nil {
+		return append(diffs, fmt.Sprintf("body: invalid fixture: %v", err))
+	}
+	if !bodyEqual(wantBody, body) {
+		diffs = append(diffs, fmt.Sprintf("body: want %s, got %s",
+			truncate(wantBody), truncate(body)))
+	}
+
+	return diffs
+}
+
+func bodyEqual(want, got []byte) bool {
+	if bytes.Equal(want, got) {
+		return true
+	}
+
+	var wantJSON, gotJSON interface{}
+	if json.Unmarshal(want, &wantJSON) != nil || json.Unmarshal(got, &gotJSON) != nil {
+		return false
+	}
+	return reflect.DeepEqual(wantJSON, gotJSON)
+}
+
+func truncate(body []byte) string {
+	if len(body) > maxDiffBodySize {
+		return fmt.Sprintf("%q...", body[:maxDiffBodySize])
+	}
+	return fmt.Sprintf("%q", body)
+}
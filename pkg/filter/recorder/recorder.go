@@ -0,0 +1,356 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of Recorder.
+	Kind = "Recorder"
+
+	modeRecord = "record"
+	modeVerify = "verify"
+
+	defaultMaxBodySize = 1024 * 1024
+
+	// maxFailures is the max number of recent failures in the status.
+	maxFailures = 10
+)
+
+var results = []string{}
+
+// sensitiveHeaders are not recorded, to keep credentials out of fixtures.
+var sensitiveHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+}
+
+// volatileHeaders change on every response, they're not verified.
+var volatileHeaders = []string{
+	"Date",
+	httpheader.KeyContentLength,
+}
+
+func init() {
+	httppipeline.Register(&Recorder{})
+}
+
+type (
+	// Recorder records requests and responses into fixtures, or verifies
+	// responses against the recorded fixtures.
+	Recorder struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		dir           string
+		ignoreHeaders map[string]bool
+		fixtures      map[string]*Fixture
+
+		mutex  sync.Mutex
+		status *Status
+	}
+
+	// Spec describes the Recorder.
+	Spec struct {
+		Mode string `yaml:"mode" jsonschema:"required,enum=record,enum=verify"`
+		// Dir is the directory of fixtures, relative paths are relative
+		// to the data directory.
+		Dir string `yaml:"dir" jsonschema:"required"`
+		// MaxBodySize is the max size of request and response bodies,
+		// larger pairs are skipped, the default is 1MiB.
+		MaxBodySize uint32 `yaml:"maxBodySize" jsonschema:"omitempty"`
+		// IgnoreHeaders are the response headers not verified, besides
+		// Date and Content-Length.
+		IgnoreHeaders []string `yaml:"ignoreHeaders" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of Recorder.
+	Status struct {
+		Recorded  uint64 `yaml:"recorded"`
+		Verified  uint64 `yaml:"verified"`
+		Passed    uint64 `yaml:"passed"`
+		Failed    uint64 `yaml:"failed"`
+		Unmatched uint64 `yaml:"unmatched"`
+		Skipped   uint64 `yaml:"skipped"`
+
+		// Failures are the recent failed verifications.
+		Failures []*Failure `yaml:"failures,omitempty"`
+		Error    string     `yaml:"error,omitempty"`
+	}
+
+	// Failure is a failed verification.
+	Failure struct {
+		Time   time.Time `yaml:"time"`
+		Key    string    `yaml:"key"`
+		Method string    `yaml:"method"`
+		Path   string    `yaml:"path"`
+		Diffs  []string  `yaml:"diffs"`
+	}
+
+	// bodyWithCloser reads from the reader, and closes the closer.
+	bodyWithCloser struct {
+		io.Reader
+		closer io.Closer
+	}
+)
+
+// Kind returns the kind of Recorder.
+func (r *Recorder) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of Recorder.
+func (r *Recorder) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of Recorder.
+func (r *Recorder) Description() string {
+	return "Recorder records requests and responses into fixtures, or verifies responses against them."
+}
+
+// Results returns the results of Recorder.
+func (r *Recorder) Results() []string {
+	return results
+}
+
+// Init initializes Recorder.
+func (r *Recorder) Init(filterSpec *httppipeline.FilterSpec) {
+	r.filterSpec, r.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	r.reload()
+}
+
+// Inherit inherits previous generation of Recorder.
+func (r *Recorder) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	r.Init(filterSpec)
+}
+
+func (r *Recorder) reload() {
+	r.status = &Status{}
+
+	r.dir = r.spec.Dir
+	if !filepath.IsAbs(r.dir) && r.filterSpec.Super() != nil {
+		r.dir = filepath.Join(r.filterSpec.Super().Options().AbsDataDir, r.dir)
+	}
+
+	r.ignoreHeaders = map[string]bool{}
+	for _, key := range volatileHeaders {
+		r.ignoreHeaders[key] = true
+	}
+	for _, key := range r.spec.IgnoreHeaders {
+		r.ignoreHeaders[http.CanonicalHeaderKey(key)] = true
+	}
+
+	var err error
+	if r.spec.Mode == modeRecord {
+		err = os.MkdirAll(r.dir, 0o755)
+	} else {
+		r.fixtures, err = loadFixtures(r.dir)
+	}
+	if err != nil {
+		logger.Errorf("%s: prepare fixtures in %s failed: %v", r.filterSpec.Name(), r.dir, err)
+		r.status.Error = err.Error()
+	}
+}
+
+func (r *Recorder) maxBodySize() int64 {
+	if r.spec.MaxBodySize == 0 {
+		return defaultMaxBodySize
+	}
+	return int64(r.spec.MaxBodySize)
+}
+
+// Handle records or verifies the response of the following handlers.
+func (r *Recorder) Handle(ctx context.HTTPContext) string {
+	req := ctx.Request()
+
+	reqBody, ok := r.readBody(req.Body(), req.SetBody)
+	if !ok {
+		r.count(func(s *Status) { s.Skipped++ })
+		return ctx.CallNextHandler("")
+	}
+
+	key := fixtureKey(req.Method(), req.Path(), req.Query(), reqBody)
+	var fixture *Fixture
+	if r.spec.Mode == modeVerify {
+		fixture = r.fixtures[key]
+		if fixture == nil {
+			r.count(func(s *Status) { s.Unmatched++ })
+			return ctx.CallNextHandler("")
+		}
+	}
+
+	result := ctx.CallNextHandler("")
+
+	w := ctx.Response()
+	respBody, ok := r.readBody(w.Body(), w.SetBody)
+	if !ok {
+		r.count(func(s *Status) { s.Skipped++ })
+		return result
+	}
+
+	if fixture != nil {
+		r.verify(fixture, w.StatusCode(), w.Header().Std(), respBody)
+	} else if result == "" {
+		r.record(ctx, key, reqBody, respBody)
+	}
+
+	return result
+}
+
+// readBody reads the body and restores it by setBody for the following
+// handlers. It returns false if the body is too large or failed to read.
+func (r *Recorder) readBody(body io.Reader, setBody func(io.Reader)) ([]byte, bool) {
+	if body == nil {
+		return nil, true
+	}
+
+	maxBodySize := r.maxBodySize()
+	data, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+	closer, _ := body.(io.Closer)
+	if err != nil {
+		logger.Warnf("%s: read body failed: %v", r.filterSpec.Name(), err)
+		// NOTE: The body is not usable any more, but it must be closed.
+		setBody(&bodyWithCloser{Reader: bytes.NewReader(data), closer: closer})
+		return nil, false
+	}
+
+	if int64(len(data)) > maxBodySize {
+		setBody(&bodyWithCloser{
+			Reader: io.MultiReader(bytes.NewReader(data), body),
+			closer: closer,
+		})
+		return nil, false
+	}
+
+	setBody(&bodyWithCloser{Reader: bytes.NewReader(data), closer: closer})
+	return data, true
+}
+
+func (r *Recorder) record(ctx context.HTTPContext, key string, reqBody, respBody []byte) {
+	req, w := ctx.Request(), ctx.Response()
+
+	reqHeader := req.Header().Std().Clone()
+	for _, key := range sensitiveHeaders {
+		reqHeader.Del(key)
+	}
+
+	f := &Fixture{
+		Key:        key,
+		RecordedAt: time.Now(),
+		Request: FixtureRequest{
+			Method: req.Method(),
+			Path:   req.Path(),
+			Query:  req.Query(),
+			Header: reqHeader,
+		},
+		Response: FixtureResponse{
+			StatusCode: w.StatusCode(),
+			Header:     w.Header().Std().Clone(),
+		},
+	}
+	f.Request.set(reqBody)
+	f.Response.set(respBody)
+
+	if err := saveFixture(r.dir, f); err != nil {
+		logger.Errorf("%s: save fixture %s failed: %v", r.filterSpec.Name(), key, err)
+		r.count(func(s *Status) { s.Error = err.Error() })
+		return
+	}
+	r.count(func(s *Status) { s.Recorded++ })
+}
+
+func (r *Recorder) verify(f *Fixture, statusCode int, header http.Header, body []byte) {
+	diffs := diff(&f.Response, statusCode, header, body, r.ignoreHeaders)
+	if len(diffs) == 0 {
+		r.count(func(s *Status) {
+			s.Verified++
+			s.Passed++
+		})
+		return
+	}
+
+	logger.Warnf("%s: response of %s %s mismatches fixture %s: %v",
+		r.filterSpec.Name(), f.Request.Method, f.Request.Path, f.Key, diffs)
+
+	failure := &Failure{
+		Time:   time.Now(),
+		Key:    f.Key,
+		Method: f.Request.Method,
+		Path:   f.Request.Path,
+		Diffs:  diffs,
+	}
+	r.count(func(s *Status) {
+		s.Verified++
+		s.Failed++
+		s.Failures = append(s.Failures, failure)
+		if len(s.Failures) > maxFailures {
+			s.Failures = s.Failures[len(s.Failures)-maxFailures:]
+		}
+	})
+}
+
+func (r *Recorder) count(fn func(s *Status)) {
+	r.mutex.Lock()
+	fn(r.status)
+	r.mutex.Unlock()
+}
+
+// Status returns status.
+func (r *Recorder) Status() interface{} {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := *r.status
+	s.Failures = append([]*Failure(nil), r.status.Failures...)
+	return &s
+}
+
+// Close closes Recorder.
+func (r *Recorder) Close() {}
+
+func (b *bodyWithCloser) Close() error {
+	if b.closer == nil {
+		return nil
+	}
+	return b.closer.Close()
+}
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	if spec.Mode != modeRecord && spec.Mode != modeVerify {
+		return fmt.Errorf("invalid mode %s", spec.Mode)
+	}
+	return nil
+}
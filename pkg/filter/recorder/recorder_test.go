@@ -0,0 +1,167 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package recorder
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newRecorder(t *testing.T, yamlSpec string) *Recorder {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	r := &Recorder{}
+	r.Init(spec)
+	return r
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	reqBody    io.Reader
+	statusCode int
+	respBody   io.Reader
+}
+
+// newContext returns a context whose following handlers respond with
+// the status code and body.
+func newContext(path, reqBody string, statusCode int, respBody string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		reqBody:           strings.NewReader(reqBody),
+	}
+
+	reqHeader := httpheader.New(http.Header{})
+	reqHeader.Set("Authorization", "Bearer secret")
+	respHeader := httpheader.New(http.Header{})
+
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodPost }
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return ctx.reqBody }
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) { ctx.reqBody = body }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return respHeader }
+	ctx.MockedResponse.MockedStatusCode = func() int { return ctx.statusCode }
+	ctx.MockedResponse.MockedBody = func() io.Reader { return ctx.respBody }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { ctx.respBody = body }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		// The following handlers must see the whole request body.
+		data, _ := ioutil.ReadAll(ctx.reqBody)
+		if string(data) != reqBody {
+			panic("request body is not restored")
+		}
+		ctx.statusCode = statusCode
+		respHeader.Set(httpheader.KeyContentType, "application/json")
+		respHeader.Set("Date", "now")
+		ctx.respBody = strings.NewReader(respBody)
+		return lastResult
+	}
+
+	return ctx
+}
+
+func TestRecordAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "recorder")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	r := newRecorder(t, `
+kind: Recorder
+name: recorder
+mode: record
+dir: `+dir)
+
+	ctx := newContext("/users", `{"name":"bob"}`, http.StatusCreated, `{"id":1,"name":"bob"}`)
+	r.Handle(ctx)
+	data, _ := ioutil.ReadAll(ctx.respBody)
+	if string(data) != `{"id":1,"name":"bob"}` {
+		t.Fatalf("response body should be restored, got %s", data)
+	}
+	if s := r.Status().(*Status); s.Recorded != 1 || s.Error != "" {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+
+	fixtures, err := loadFixtures(dir)
+	if err != nil || len(fixtures) != 1 {
+		t.Fatalf("want 1 fixture, got %d, %v", len(fixtures), err)
+	}
+	for _, f := range fixtures {
+		if f.Request.Header.Get("Authorization") != "" {
+			t.Errorf("authorization should not be recorded")
+		}
+	}
+
+	r = newRecorder(t, `
+kind: Recorder
+name: recorder
+mode: verify
+dir: `+dir)
+
+	// The same JSON in a different order passes.
+	r.Handle(newContext("/users", `{"name":"bob"}`, http.StatusCreated, `{"name":"bob","id":1}`))
+	// Regressions fail.
+	r.Handle(newContext("/users", `{"name":"bob"}`, http.StatusOK, `{"id":"1","name":"bob"}`))
+	// Requests without fixtures are unmatched.
+	r.Handle(newContext("/users", `{"name":"alice"}`, http.StatusCreated, `{}`))
+
+	s := r.Status().(*Status)
+	if s.Verified != 2 || s.Passed != 1 || s.Failed != 1 || s.Unmatched != 1 {
+		t.Fatalf("unexpected status: %+v", s)
+	}
+	if len(s.Failures) != 1 || len(s.Failures[0].Diffs) != 2 {
+		t.Fatalf("unexpected failures: %+v", s.Failures)
+	}
+	if !strings.HasPrefix(s.Failures[0].Diffs[0], "status code") ||
+		!strings.HasPrefix(s.Failures[0].Diffs[1], "body") {
+		t.Errorf("unexpected diffs: %v", s.Failures[0].Diffs)
+	}
+}
+
+func TestFixtureBody(t *testing.T) {
+	for _, body := range [][]byte{[]byte("text"), {0xff, 0xfe, 0x00}} {
+		fb := &FixtureBody{}
+		fb.set(body)
+		got, err := fb.get()
+		if err != nil || string(got) != string(body) {
+			t.Errorf("want %q, got %q, %v", body, got, err)
+		}
+	}
+}
@@ -31,6 +31,7 @@ import (
 	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 )
 
@@ -59,7 +60,7 @@ var globalClient = &http.Client{
 	// NOTE: Timeout could be no limit, real client or server could cancel it.
 	Timeout: 0,
 	Transport: &http.Transport{
-		Proxy: http.ProxyFromEnvironment,
+		Proxy: egressproxy.Global,
 		DialContext: (&net.Dialer{
 			Timeout:   30 * time.Second,
 			KeepAlive: 60 * time.Second,
@@ -215,19 +216,16 @@ func (rf *RemoteFilter) handle(ctx context.HTTPContext) (result string) {
 	errPrefix = "marshal context"
 	ctxBuff := rf.marshalHTTPContext(ctx, reqBody, respBody)
 
-	var (
-		req *http.Request
-		err error
-	)
-
+	// NOTE: The request is derived from ctx, so it is aborted once
+	// the client disconnects.
+	var reqCtx stdcontext.Context = ctx
 	if rf.spec.timeout > 0 {
-		timeoutCtx, cancelFunc := stdcontext.WithTimeout(stdcontext.Background(), rf.spec.timeout)
+		timeoutCtx, cancelFunc := stdcontext.WithTimeout(ctx, rf.spec.timeout)
 		defer cancelFunc()
-		req, err = http.NewRequestWithContext(timeoutCtx, http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
-	} else {
-		req, err = http.NewRequest(http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
+		reqCtx = timeoutCtx
 	}
 
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, rf.spec.URL, bytes.NewReader(ctxBuff))
 	if err != nil {
 		logger.Errorf("BUG: new request failed: %v", err)
 		w.SetStatusCode(http.StatusInternalServerError)
@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestsigner
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/fips"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/signer"
+)
+
+const (
+	// Kind is the kind of RequestSigner.
+	Kind = "RequestSigner"
+
+	resultSignFailed = "signFailed"
+
+	defaultNonceHeader = "X-EG-Nonce"
+)
+
+var results = []string{resultSignFailed}
+
+func init() {
+	httppipeline.Register(&RequestSigner{})
+}
+
+type (
+	// RequestSigner signs requests with the key of the gateway, so the
+	// upstreams could verify the requests come from the gateway, and
+	// they're not tampered or replayed.
+	RequestSigner struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		signer        *signer.Signer
+		signedHeaders map[string]bool
+	}
+
+	// Spec describes the RequestSigner.
+	Spec struct {
+		AccessKeyID     string          `yaml:"accessKeyId" jsonschema:"required"`
+		AccessKeySecret string          `yaml:"accessKeySecret" jsonschema:"required"`
+		Literal         *signer.Literal `yaml:"literal,omitempty" jsonschema:"omitempty"`
+		// Scopes are the scopes of the credential, e.g. the name of the
+		// upstream service.
+		Scopes []string `yaml:"scopes" jsonschema:"omitempty"`
+		// SignedHeaders are the headers signed besides the host, the
+		// content type, the date, the body hash and the nonce, e.g. the
+		// identity headers set by the gateway.
+		SignedHeaders []string `yaml:"signedHeaders" jsonschema:"omitempty,uniqueItems=true"`
+		ExcludeBody   bool     `yaml:"excludeBody" jsonschema:"omitempty"`
+		// NonceHeader is the header of the random nonce of every request,
+		// for the upstreams to reject replayed requests, empty disables it.
+		NonceHeader string `yaml:"nonceHeader" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if err := fips.CheckHMACKey([]byte(spec.AccessKeySecret)); err != nil {
+		return fmt.Errorf("accessKeySecret: %v", err)
+	}
+	return nil
+}
+
+// Kind returns the kind of RequestSigner.
+func (rs *RequestSigner) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of RequestSigner.
+func (rs *RequestSigner) DefaultSpec() interface{} {
+	return &Spec{NonceHeader: defaultNonceHeader}
+}
+
+// Description returns the description of RequestSigner.
+func (rs *RequestSigner) Description() string {
+	return "RequestSigner signs requests with the key of the gateway."
+}
+
+// Results returns the results of RequestSigner.
+func (rs *RequestSigner) Results() []string {
+	return results
+}
+
+// Init initializes RequestSigner.
+func (rs *RequestSigner) Init(filterSpec *httppipeline.FilterSpec) {
+	rs.filterSpec, rs.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	rs.reload()
+}
+
+// Inherit inherits previous generation of RequestSigner.
+func (rs *RequestSigner) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	rs.Init(filterSpec)
+}
+
+func (rs *RequestSigner) reload() {
+	rs.signer = signer.New().
+		SetCredential(rs.spec.AccessKeyID, rs.spec.AccessKeySecret).
+		ExcludeBody(rs.spec.ExcludeBody)
+	if rs.spec.Literal != nil {
+		rs.signer.SetLiteral(rs.spec.Literal)
+	}
+
+	literal := rs.literal()
+	rs.signedHeaders = map[string]bool{
+		httpheader.KeyContentType: true,
+		literal.Date:              true,
+		literal.ContentSHA256:     true,
+	}
+	if rs.spec.NonceHeader != "" {
+		rs.signedHeaders[http.CanonicalHeaderKey(rs.spec.NonceHeader)] = true
+	}
+	for _, h := range rs.spec.SignedHeaders {
+		rs.signedHeaders[http.CanonicalHeaderKey(h)] = true
+	}
+}
+
+func (rs *RequestSigner) literal() *signer.Literal {
+	if rs.spec.Literal != nil {
+		return rs.spec.Literal
+	}
+	return signer.DefaultLiteral()
+}
+
+// Handle signs the request.
+func (rs *RequestSigner) Handle(ctx context.HTTPContext) string {
+	result := rs.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (rs *RequestSigner) handle(ctx context.HTTPContext) string {
+	if err := rs.sign(ctx); err != nil {
+		logger.Errorf("%s: sign request failed: %v", rs.filterSpec.Name(), err)
+		ctx.Response().SetStatusCode(http.StatusInternalServerError)
+		ctx.AddTag(fmt.Sprintf("requestSigner: %v", err))
+		return resultSignFailed
+	}
+	return ""
+}
+
+func (rs *RequestSigner) sign(ctx context.HTTPContext) error {
+	r := ctx.Request()
+	h := r.Header()
+	literal := rs.literal()
+
+	// The signature related headers of clients are replaced, otherwise
+	// they could forge the body hash or reuse the nonce.
+	h.Del("Authorization")
+	h.Del(literal.Date)
+	h.Del(literal.ContentSHA256)
+	if rs.spec.NonceHeader != "" {
+		h.Set(rs.spec.NonceHeader, uuid.NewString())
+	}
+
+	var body io.Reader
+	if !rs.spec.ExcludeBody && r.Body() != nil {
+		data, err := io.ReadAll(r.Body())
+		if err != nil {
+			return fmt.Errorf("read body failed: %v", err)
+		}
+		r.SetBody(bytes.NewReader(data))
+		body = bytes.NewReader(data)
+	}
+
+	// NOTE: The proxy sends requests to the path of the request, so the
+	// escaped path sent is the same as the one signed.
+	u, err := url.Parse("http://" + r.Host() + r.Path())
+	if err != nil {
+		return fmt.Errorf("invalid url: %v", err)
+	}
+	u.RawQuery = r.Query()
+
+	req, err := http.NewRequest(r.Method(), u.String(), body)
+	if err != nil {
+		return fmt.Errorf("invalid request: %v", err)
+	}
+
+	// Only the stable headers are signed, as the others may be changed
+	// by the following filters or the proxy, e.g. the hop-by-hop ones.
+	for k, v := range h.Std() {
+		if rs.signedHeaders[k] {
+			req.Header[k] = v
+		}
+	}
+
+	if err := rs.signer.NewContext(time.Now(), rs.spec.Scopes...).Sign(req); err != nil {
+		return err
+	}
+
+	for _, k := range []string{"Authorization", literal.Date, literal.ContentSHA256} {
+		if v := req.Header.Get(k); v != "" {
+			h.Set(k, v)
+		}
+	}
+	return nil
+}
+
+// Status returns status.
+func (rs *RequestSigner) Status() interface{} {
+	return nil
+}
+
+// Close closes RequestSigner.
+func (rs *RequestSigner) Close() {}
@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestsigner
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/signer"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newRequestSigner(t *testing.T, yamlSpec string) *RequestSigner {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	rs := &RequestSigner{}
+	rs.Init(spec)
+	return rs
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	header     *httpheader.HTTPHeader
+	body       io.Reader
+	statusCode int
+}
+
+func newContext(method, path, query, body string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		header:            httpheader.New(http.Header{}),
+	}
+	if body != "" {
+		ctx.body = strings.NewReader(body)
+	}
+
+	ctx.MockedRequest.MockedMethod = func() string { return method }
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedRequest.MockedQuery = func() string { return query }
+	ctx.MockedRequest.MockedHost = func() string { return "orders.internal" }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return ctx.header }
+	ctx.MockedRequest.MockedBody = func() io.Reader { return ctx.body }
+	ctx.MockedRequest.MockedSetBody = func(body io.Reader) { ctx.body = body }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	return ctx
+}
+
+// stdRequest creates the request as the upstream receives it.
+func (ctx *mockedContext) stdRequest(t *testing.T, body string) *http.Request {
+	r := ctx.Request()
+	url := "http://orders.internal" + r.Path()
+	if r.Query() != "" {
+		url += "?" + r.Query()
+	}
+
+	req, err := http.NewRequest(r.Method(), url, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("new request failed: %v", err)
+	}
+	req.Header = ctx.header.Std().Clone()
+	return req
+}
+
+func newVerifier() *signer.Signer {
+	return signer.New().
+		SetTTL(time.Minute).
+		SetAccessKeyStore(keyStore{"gateway": "s3cr3t"})
+}
+
+type keyStore map[string]string
+
+func (ks keyStore) GetSecret(id string) (string, bool) {
+	secret, ok := ks[id]
+	return secret, ok
+}
+
+func TestSign(t *testing.T) {
+	rs := newRequestSigner(t, `
+kind: RequestSigner
+name: signer
+accessKeyId: gateway
+accessKeySecret: s3cr3t
+scopes: [orders]
+signedHeaders: [x-user-id]
+`)
+
+	body := `{"item":"book"}`
+	ctx := newContext(http.MethodPost, "/orders", "dry=true", body)
+	ctx.header.Set(httpheader.KeyContentType, "application/json")
+	ctx.header.Set("X-User-Id", "alice")
+	ctx.header.Set("Authorization", "Bearer client-token")
+	ctx.header.Set("X-Me-Content-Sha256", "forged")
+	ctx.header.Set(defaultNonceHeader, "replayed")
+
+	if result := rs.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+
+	auth := ctx.header.Get("Authorization")
+	if !strings.HasPrefix(auth, "ME-HMAC-SHA256 Credential=gateway/") || !strings.Contains(auth, "/orders/megaease_request,") {
+		t.Fatalf("unexpected authorization %s", auth)
+	}
+	if !strings.Contains(auth, "SignedHeaders=content-type;host;x-eg-nonce;x-me-date;x-user-id,") {
+		t.Errorf("unexpected signed headers: %s", auth)
+	}
+	nonce := ctx.header.Get(defaultNonceHeader)
+	if nonce == "" || nonce == "replayed" {
+		t.Errorf("nonce should be generated, got %s", nonce)
+	}
+	if ctx.header.Get("X-Me-Content-Sha256") != "" {
+		t.Errorf("the body hash of the client should be removed")
+	}
+	if data, _ := ioutil.ReadAll(ctx.body); string(data) != body {
+		t.Errorf("body should be kept, got %s", data)
+	}
+
+	// Headers which are not signed could be changed.
+	ctx.header.Set("Connection", "close")
+	verifier := newVerifier()
+	if err := verifier.Verify(ctx.stdRequest(t, body)); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	// Tampering with the body or the signed headers is detected.
+	if err := verifier.Verify(ctx.stdRequest(t, `{"item":"car"}`)); err == nil {
+		t.Errorf("tampered body should fail the verification")
+	}
+	ctx.header.Set("X-User-Id", "mallory")
+	if err := verifier.Verify(ctx.stdRequest(t, body)); err == nil {
+		t.Errorf("tampered header should fail the verification")
+	}
+}
+
+func TestSignWithoutBodyAndNonce(t *testing.T) {
+	rs := newRequestSigner(t, `
+kind: RequestSigner
+name: signer
+accessKeyId: gateway
+accessKeySecret: s3cr3t
+excludeBody: true
+nonceHeader: ""
+`)
+
+	ctx := newContext(http.MethodPut, "/files/a.txt", "", "large file")
+	if result := rs.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if ctx.header.Get(defaultNonceHeader) != "" {
+		t.Errorf("nonce should be disabled")
+	}
+	if ctx.header.Get("X-Me-Content-Sha256") != "UNSIGNED-PAYLOAD" {
+		t.Errorf("body should be unsigned")
+	}
+
+	verifier := newVerifier().ExcludeBody(true)
+	if err := verifier.Verify(ctx.stdRequest(t, "other file")); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+}
@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responseintegrity
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const syncRetryInterval = 10 * time.Second
+
+type (
+	// signingKey is the Ed25519 key to sign responses.
+	signingKey struct {
+		id         string
+		privateKey ed25519.PrivateKey
+	}
+
+	// keyManager holds the signing key, the key is the configured one, or
+	// the one generated once and shared by the members via the cluster.
+	keyManager struct {
+		cls      cluster.Cluster
+		storeKey string
+		keyID    string
+
+		key  atomic.Value // *signingKey
+		done chan struct{}
+	}
+)
+
+// parsePrivateKey parses the Ed25519 private key in PKCS #8 PEM.
+func parsePrivateKey(data string) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(data))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("not an Ed25519 private key")
+	}
+	return privateKey, nil
+}
+
+// generatePrivateKey generates an Ed25519 private key in PKCS #8 PEM.
+func generatePrivateKey() (string, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+// encodePublicKey encodes the public key in PKIX PEM.
+func encodePublicKey(publicKey ed25519.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		logger.Errorf("BUG: marshal public key failed: %v", err)
+		return ""
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func newSigningKey(data, keyID string) (*signingKey, error) {
+	privateKey, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	// The default key ID is derived from the public key, so it changes
+	// along with the key.
+	if keyID == "" {
+		sum := sha256.Sum256(privateKey.Public().(ed25519.PublicKey))
+		keyID = hex.EncodeToString(sum[:8])
+	}
+	return &signingKey{id: keyID, privateKey: privateKey}, nil
+}
+
+// newKeyManager creates a keyManager, the key is loaded from the cluster
+// in the background if privateKey is empty.
+func newKeyManager(cls cluster.Cluster, storeKey, privateKey, keyID string) (*keyManager, error) {
+	km := &keyManager{
+		cls:      cls,
+		storeKey: storeKey,
+		keyID:    keyID,
+		done:     make(chan struct{}),
+	}
+
+	if privateKey != "" {
+		key, err := newSigningKey(privateKey, keyID)
+		if err != nil {
+			return nil, err
+		}
+		km.key.Store(key)
+		return km, nil
+	}
+
+	// NOTE: There's no cluster in tests or standalone tools, the key is
+	// only used by this process then.
+	if cls == nil {
+		data, err := generatePrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		km.apply(&data)
+		return km, nil
+	}
+
+	go km.sync()
+	return km, nil
+}
+
+// get returns the signing key, it is nil if the key is not loaded yet.
+func (km *keyManager) get() *signingKey {
+	key, _ := km.key.Load().(*signingKey)
+	return key
+}
+
+func (km *keyManager) apply(value *string) {
+	if value == nil {
+		return
+	}
+	key, err := newSigningKey(*value, km.keyID)
+	if err != nil {
+		logger.Errorf("load signing key %s failed: %v", km.storeKey, err)
+		return
+	}
+	km.key.Store(key)
+}
+
+// ensureKey creates the key in the cluster if there isn't one, it's done
+// in a transaction, so all members share the same key.
+func (km *keyManager) ensureKey() error {
+	data, err := generatePrivateKey()
+	if err != nil {
+		return err
+	}
+	return km.cls.STM(func(stm concurrency.STM) error {
+		if stm.Get(km.storeKey) == "" {
+			stm.Put(km.storeKey, data)
+		}
+		return nil
+	})
+}
+
+func (km *keyManager) sync() {
+	var (
+		syncer *cluster.Syncer
+		ch     <-chan *string
+		err    error
+	)
+
+	for {
+		if err = km.ensureKey(); err != nil {
+			logger.Errorf("create signing key %s failed: %v", km.storeKey, err)
+		} else if syncer, err = km.cls.Syncer(time.Minute); err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.Sync(km.storeKey); err != nil {
+			logger.Errorf("failed to sync %s: %v", km.storeKey, err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(syncRetryInterval):
+		case <-km.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case <-km.done:
+			return
+		case value := <-ch:
+			km.apply(value)
+		}
+	}
+}
+
+func (km *keyManager) close() {
+	close(km.done)
+}
@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responseintegrity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+)
+
+func TestPrivateKey(t *testing.T) {
+	data, err := generatePrivateKey()
+	if err != nil {
+		t.Fatalf("generate private key failed: %v", err)
+	}
+
+	key1, err := newSigningKey(data, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key2, _ := newSigningKey(data, "")
+	if key1.id == "" || key1.id != key2.id {
+		t.Errorf("key ID should be derived from the key, got %s and %s", key1.id, key2.id)
+	}
+	if key, _ := newSigningKey(data, "gateway"); key.id != "gateway" {
+		t.Errorf("key ID should be the configured one")
+	}
+
+	if _, err = parsePrivateKey("invalid"); err == nil {
+		t.Errorf("expect an error for invalid PEM")
+	}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	ecPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if _, err = parsePrivateKey(string(ecPEM)); err == nil {
+		t.Errorf("expect an error for non Ed25519 key")
+	}
+}
+
+func TestKeyManager(t *testing.T) {
+	data, _ := generatePrivateKey()
+	km, err := newKeyManager(nil, "", data, "gateway")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer km.close()
+	if key := km.get(); key == nil || key.id != "gateway" {
+		t.Fatalf("the configured key should be used")
+	}
+
+	// A key is generated without the cluster.
+	local, err := newKeyManager(nil, "", "", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer local.close()
+	if local.get() == nil {
+		t.Fatalf("a key should be generated")
+	}
+
+	// Invalid keys from the cluster are ignored.
+	invalid := "invalid"
+	before := local.get()
+	local.apply(&invalid)
+	local.apply(nil)
+	if local.get() != before {
+		t.Errorf("the key should be kept")
+	}
+
+	if _, err = newKeyManager(nil, "", "invalid", ""); err == nil {
+		t.Errorf("expect an error for invalid key")
+	}
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responseintegrity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/fips"
+)
+
+const (
+	// Kind is the kind of ResponseIntegrity.
+	Kind = "ResponseIntegrity"
+
+	resultSignFailed   = "signFailed"
+	resultBodyTooLarge = "bodyTooLarge"
+
+	digestSHA256 = "sha-256"
+	digestSHA512 = "sha-512"
+
+	headerContentDigest  = "Content-Digest"
+	headerSignature      = "Signature"
+	headerSignatureInput = "Signature-Input"
+
+	signatureLabel = "sig1"
+
+	defaultMaxBodySize = 4 * 1024 * 1024
+)
+
+var results = []string{resultSignFailed, resultBodyTooLarge}
+
+func init() {
+	httppipeline.Register(&ResponseIntegrity{})
+}
+
+type (
+	// ResponseIntegrity adds the digest of the response body, and signs
+	// the response optionally, so intermediaries and clients could detect
+	// tampering with responses.
+	ResponseIntegrity struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		keys *keyManager
+	}
+
+	// Spec describes the ResponseIntegrity.
+	Spec struct {
+		Digest      string         `yaml:"digest" jsonschema:"omitempty,enum=,enum=sha-256,enum=sha-512"`
+		MaxBodySize int64          `yaml:"maxBodySize" jsonschema:"omitempty,minimum=1"`
+		Signature   *SignatureSpec `yaml:"signature,omitempty" jsonschema:"omitempty"`
+	}
+
+	// SignatureSpec describes the HTTP message signature of responses.
+	SignatureSpec struct {
+		// KeyID is the ID of the key, the default one is derived from the
+		// public key.
+		KeyID string `yaml:"keyID" jsonschema:"omitempty"`
+		// PrivateKey is the Ed25519 private key in PKCS #8 PEM, a key is
+		// generated and shared in the cluster if it is empty.
+		PrivateKey string `yaml:"privateKey" jsonschema:"omitempty"`
+		// Headers are the headers signed besides the status code and the
+		// digest, the absent ones are skipped.
+		Headers []string `yaml:"headers" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Status is the status of ResponseIntegrity.
+	Status struct {
+		KeyID     string `yaml:"keyID,omitempty"`
+		PublicKey string `yaml:"publicKey,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Signature != nil && fips.Enabled() {
+		return fmt.Errorf("signature is not allowed in FIPS mode, Ed25519 is not approved by FIPS 140-2")
+	}
+	if spec.Signature == nil || spec.Signature.PrivateKey == "" {
+		return nil
+	}
+	if _, err := parsePrivateKey(spec.Signature.PrivateKey); err != nil {
+		return fmt.Errorf("invalid privateKey: %v", err)
+	}
+	return nil
+}
+
+// Kind returns the kind of ResponseIntegrity.
+func (ri *ResponseIntegrity) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of ResponseIntegrity.
+func (ri *ResponseIntegrity) DefaultSpec() interface{} {
+	return &Spec{
+		Digest:      digestSHA256,
+		MaxBodySize: defaultMaxBodySize,
+	}
+}
+
+// Description returns the description of ResponseIntegrity.
+func (ri *ResponseIntegrity) Description() string {
+	return "ResponseIntegrity adds the digest and the signature of responses."
+}
+
+// Results returns the results of ResponseIntegrity.
+func (ri *ResponseIntegrity) Results() []string {
+	return results
+}
+
+// Init initializes ResponseIntegrity.
+func (ri *ResponseIntegrity) Init(filterSpec *httppipeline.FilterSpec) {
+	ri.filterSpec, ri.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	ri.reload()
+}
+
+// Inherit inherits previous generation of ResponseIntegrity.
+func (ri *ResponseIntegrity) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	ri.Init(filterSpec)
+}
+
+func (ri *ResponseIntegrity) reload() {
+	if ri.spec.Signature == nil {
+		return
+	}
+
+	var cls cluster.Cluster
+	storeKey := ""
+	if super := ri.filterSpec.Super(); super != nil && super.Cluster() != nil {
+		cls = super.Cluster()
+		storeKey = cls.Layout().IntegrityKey(ri.filterSpec.Pipeline(), ri.filterSpec.Name())
+	}
+
+	keys, err := newKeyManager(cls, storeKey, ri.spec.Signature.PrivateKey, ri.spec.Signature.KeyID)
+	if err != nil {
+		logger.Errorf("%s: load signing key failed: %v", ri.filterSpec.Name(), err)
+		return
+	}
+	ri.keys = keys
+}
+
+// Handle adds the digest and the signature to the response.
+func (ri *ResponseIntegrity) Handle(ctx context.HTTPContext) string {
+	result := ri.handle(ctx)
+	return ctx.CallNextHandler(result)
+}
+
+func (ri *ResponseIntegrity) handle(ctx context.HTTPContext) string {
+	resp := ctx.Response()
+
+	var data []byte
+	if body := resp.Body(); body != nil {
+		limit := ri.spec.MaxBodySize
+		if limit <= 0 {
+			limit = defaultMaxBodySize
+		}
+
+		var err error
+		data, err = io.ReadAll(io.LimitReader(body, limit+1))
+		if err != nil {
+			ctx.AddTag(fmt.Sprintf("responseIntegrity: read body failed: %v", err))
+			resp.SetStatusCode(http.StatusInternalServerError)
+			resp.SetBody(nil)
+			return resultSignFailed
+		}
+
+		// The large body is sent as is without the digest.
+		if int64(len(data)) > limit {
+			resp.SetBody(io.MultiReader(bytes.NewReader(data), body))
+			ctx.AddTag(fmt.Sprintf("responseIntegrity: body exceeds %d bytes", limit))
+			return resultBodyTooLarge
+		}
+		resp.SetBody(bytes.NewReader(data))
+	}
+
+	h := resp.Header()
+	h.Set(headerContentDigest, contentDigest(ri.spec.Digest, data))
+
+	if ri.spec.Signature == nil {
+		return ""
+	}
+
+	var key *signingKey
+	if ri.keys != nil {
+		key = ri.keys.get()
+	}
+	if key == nil {
+		ctx.AddTag("responseIntegrity: signing key not available")
+		resp.SetStatusCode(http.StatusInternalServerError)
+		resp.SetBody(nil)
+		return resultSignFailed
+	}
+
+	input, signature := ri.sign(key, resp.StatusCode(), h.Std(), time.Now())
+	h.Set(headerSignatureInput, signatureLabel+"="+input)
+	h.Set(headerSignature, signatureLabel+"=:"+signature+":")
+	return ""
+}
+
+// contentDigest returns the value of the Content-Digest header, see RFC 9530.
+func contentDigest(algorithm string, data []byte) string {
+	var hasher hash.Hash
+	if algorithm == digestSHA512 {
+		hasher = sha512.New()
+	} else {
+		algorithm = digestSHA256
+		hasher = sha256.New()
+	}
+	hasher.Write(data)
+	return algorithm + "=:" + base64.StdEncoding.EncodeToString(hasher.Sum(nil)) + ":"
+}
+
+// sign returns the signature parameters and the signature of the response,
+// see RFC 9421.
+func (ri *ResponseIntegrity) sign(key *signingKey, statusCode int, header http.Header, now time.Time) (string, string) {
+	components := []string{`"@status"`}
+	base := strings.Builder{}
+	base.WriteString(`"@status": ` + strconv.Itoa(statusCode) + "\n")
+
+	names := append([]string{headerContentDigest}, ri.spec.Signature.Headers...)
+	for _, name := range names {
+		values := make([]string, 0, 1)
+		for _, v := range header.Values(name) {
+			values = append(values, strings.TrimSpace(v))
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		component := `"` + strings.ToLower(name) + `"`
+		components = append(components, component)
+		base.WriteString(component + ": " + strings.Join(values, ", ") + "\n")
+	}
+
+	params := fmt.Sprintf(`(%s);created=%d;keyid="%s";alg="ed25519"`,
+		strings.Join(components, " "), now.Unix(), key.id)
+	base.WriteString(`"@signature-params": ` + params)
+
+	signature := ed25519.Sign(key.privateKey, []byte(base.String()))
+	return params, base64.StdEncoding.EncodeToString(signature)
+}
+
+// Status returns status.
+func (ri *ResponseIntegrity) Status() interface{} {
+	if ri.keys == nil {
+		return nil
+	}
+	key := ri.keys.get()
+	if key == nil {
+		return nil
+	}
+	return &Status{
+		KeyID:     key.id,
+		PublicKey: encodePublicKey(key.privateKey.Public().(ed25519.PublicKey)),
+	}
+}
+
+// Close closes ResponseIntegrity.
+func (ri *ResponseIntegrity) Close() {
+	if ri.keys != nil {
+		ri.keys.close()
+	}
+}
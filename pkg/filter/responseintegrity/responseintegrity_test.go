@@ -0,0 +1,191 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package responseintegrity
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newResponseIntegrity(t *testing.T, yamlSpec string) *ResponseIntegrity {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ri := &ResponseIntegrity{}
+	ri.Init(spec)
+	return ri
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	header     *httpheader.HTTPHeader
+	body       io.Reader
+	statusCode int
+}
+
+func newContext(statusCode int, body string) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		header:            httpheader.New(http.Header{}),
+		statusCode:        statusCode,
+	}
+	if body != "" {
+		ctx.body = strings.NewReader(body)
+	}
+
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return ctx.header }
+	ctx.MockedResponse.MockedBody = func() io.Reader { return ctx.body }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { ctx.body = body }
+	ctx.MockedResponse.MockedStatusCode = func() int { return ctx.statusCode }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return lastResult }
+
+	return ctx
+}
+
+func TestContentDigest(t *testing.T) {
+	// The examples of RFC 9530.
+	data := []byte(`{"hello": "world"}`)
+	if d := contentDigest(digestSHA256, data); d != "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:" {
+		t.Errorf("unexpected sha-256 digest %s", d)
+	}
+	want := "sha-512=:WZDPaVn/7XgHaAy8pmojAkGWoRx2UFChF41A2svX+TaPm+AbwAgBWnrIiYllu7BNNyealdVLvRwEmTHWXvJwew==:"
+	if d := contentDigest(digestSHA512, data); d != want {
+		t.Errorf("unexpected sha-512 digest %s", d)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	ri := newResponseIntegrity(t, `
+kind: ResponseIntegrity
+name: integrity
+`)
+
+	ctx := newContext(http.StatusOK, `{"hello": "world"}`)
+	if result := ri.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if d := ctx.header.Get(headerContentDigest); d != "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:" {
+		t.Errorf("unexpected digest %s", d)
+	}
+	if data, _ := ioutil.ReadAll(ctx.body); string(data) != `{"hello": "world"}` {
+		t.Errorf("body should be kept, got %s", data)
+	}
+	if ctx.header.Get(headerSignature) != "" || ri.Status() != nil {
+		t.Errorf("response should not be signed")
+	}
+
+	// The large body is sent as is.
+	ri.spec.MaxBodySize = 4
+	ctx = newContext(http.StatusOK, "large body")
+	if result := ri.Handle(ctx); result != resultBodyTooLarge {
+		t.Errorf("unexpected result %s", result)
+	}
+	if data, _ := ioutil.ReadAll(ctx.body); string(data) != "large body" {
+		t.Errorf("body should be kept, got %s", data)
+	}
+	if ctx.header.Get(headerContentDigest) != "" {
+		t.Errorf("digest should not be added")
+	}
+}
+
+func TestSignature(t *testing.T) {
+	ri := newResponseIntegrity(t, `
+kind: ResponseIntegrity
+name: integrity
+digest: sha-512
+signature:
+  keyID: gateway
+  headers: [content-type, etag]
+`)
+	defer ri.Close()
+
+	ctx := newContext(http.StatusCreated, `{"id":1}`)
+	ctx.header.Set("Content-Type", " application/json ")
+	if result := ri.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if ctx.header.Get("Content-Type") != " application/json " {
+		t.Errorf("header should not be changed")
+	}
+
+	input := ctx.header.Get(headerSignatureInput)
+	if !strings.HasPrefix(input, `sig1=("@status" "content-digest" "content-type");created=`) ||
+		!strings.HasSuffix(input, `;keyid="gateway";alg="ed25519"`) {
+		t.Fatalf("unexpected signature input %s", input)
+	}
+
+	status := ri.Status().(*Status)
+	block, _ := pem.Decode([]byte(status.PublicKey))
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil || status.KeyID != "gateway" {
+		t.Fatalf("unexpected status %+v: %v", status, err)
+	}
+
+	// Verify the signature as clients do.
+	params := strings.TrimPrefix(input, "sig1=")
+	base := `"@status": 201` + "\n" +
+		`"content-digest": ` + ctx.header.Get(headerContentDigest) + "\n" +
+		`"content-type": application/json` + "\n" +
+		`"@signature-params": ` + params
+	value := ctx.header.Get(headerSignature)
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, "sig1=:"), ":"))
+	if err != nil {
+		t.Fatalf("invalid signature %s: %v", value, err)
+	}
+	if !ed25519.Verify(publicKey.(ed25519.PublicKey), []byte(base), signature) {
+		t.Errorf("verify signature failed")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{Signature: &SignatureSpec{PrivateKey: "invalid"}}
+	if err := spec.Validate(); err == nil {
+		t.Errorf("expect an error")
+	}
+
+	spec.Signature.PrivateKey, _ = generatePrivateKey()
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/sessionstore"
+)
+
+const (
+	// Kind is the kind of Session.
+	Kind = "Session"
+
+	resultSessionRequired = "sessionRequired"
+	resultStoreFailed     = "storeFailed"
+
+	defaultCookieName   = "EG_SESSION"
+	defaultCookiePath   = "/"
+	defaultHeaderPrefix = "X-Session-"
+
+	// destroyKey is the key of the response header to destroy the session.
+	destroyKey = "destroy"
+)
+
+var results = []string{resultSessionRequired, resultStoreFailed}
+
+func init() {
+	httppipeline.Register(&Session{})
+}
+
+type (
+	// Session issues and validates opaque session cookies, the data of
+	// sessions are kept in the SessionStore and attached to the requests,
+	// so the backends could be stateless.
+	Session struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		headerPrefix string
+		sameSite     http.SameSite
+	}
+
+	// Spec describes the Session.
+	Spec struct {
+		// Store is the name of the SessionStore.
+		Store string `yaml:"store" jsonschema:"required"`
+
+		CookieName   string `yaml:"cookieName" jsonschema:"omitempty"`
+		CookiePath   string `yaml:"cookiePath" jsonschema:"omitempty"`
+		CookieDomain string `yaml:"cookieDomain" jsonschema:"omitempty"`
+		Secure       bool   `yaml:"secure" jsonschema:"omitempty"`
+		SameSite     string `yaml:"sameSite" jsonschema:"omitempty,enum=,enum=lax,enum=strict,enum=none"`
+
+		// HeaderPrefix is the prefix of the headers carrying the session
+		// data, the data are set to request headers for the backends, and
+		// the backends change them by response headers.
+		HeaderPrefix string `yaml:"headerPrefix" jsonschema:"omitempty"`
+
+		// Required rejects the requests without valid sessions.
+		Required bool `yaml:"required" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.SameSite == "none" && !spec.Secure {
+		return fmt.Errorf("sameSite none requires secure")
+	}
+	return nil
+}
+
+// Kind returns the kind of Session.
+func (s *Session) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of Session.
+func (s *Session) DefaultSpec() interface{} {
+	return &Spec{
+		CookieName:   defaultCookieName,
+		CookiePath:   defaultCookiePath,
+		HeaderPrefix: defaultHeaderPrefix,
+	}
+}
+
+// Description returns the description of Session.
+func (s *Session) Description() string {
+	return "Session manages server-side sessions of clients by opaque cookies."
+}
+
+// Results returns the results of Session.
+func (s *Session) Results() []string {
+	return results
+}
+
+// Init initializes Session.
+func (s *Session) Init(filterSpec *httppipeline.FilterSpec) {
+	s.filterSpec, s.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	s.reload()
+}
+
+// Inherit inherits previous generation of Session.
+func (s *Session) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	s.Init(filterSpec)
+}
+
+func (s *Session) reload() {
+	if s.spec.CookieName == "" {
+		s.spec.CookieName = defaultCookieName
+	}
+	if s.spec.CookiePath == "" {
+		s.spec.CookiePath = defaultCookiePath
+	}
+
+	s.headerPrefix = s.spec.HeaderPrefix
+	if s.headerPrefix == "" {
+		s.headerPrefix = defaultHeaderPrefix
+	}
+	s.headerPrefix = http.CanonicalHeaderKey(s.headerPrefix)
+
+	switch s.spec.SameSite {
+	case "lax":
+		s.sameSite = http.SameSiteLaxMode
+	case "strict":
+		s.sameSite = http.SameSiteStrictMode
+	case "none":
+		s.sameSite = http.SameSiteNoneMode
+	default:
+		s.sameSite = http.SameSiteDefaultMode
+	}
+}
+
+// Handle loads the session of the request, and saves it after the
+// following filters.
+func (s *Session) Handle(ctx context.HTTPContext) string {
+	store := sessionstore.Get(s.spec.Store)
+	session, result := s.load(ctx, store)
+	result = ctx.CallNextHandler(result)
+	if session != nil {
+		s.save(ctx, store, session)
+	}
+	return result
+}
+
+func (s *Session) load(ctx context.HTTPContext, store *sessionstore.SessionStore) (*context.Session, string) {
+	// The headers carrying session data must come from the gateway only.
+	header := ctx.Request().Header()
+	for key := range header.Std() {
+		if strings.HasPrefix(key, s.headerPrefix) {
+			header.Del(key)
+		}
+	}
+
+	if store == nil {
+		logger.Errorf("%s: session store %s not found", s.filterSpec.Name(), s.spec.Store)
+		ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+		ctx.AddTag(fmt.Sprintf("session: store %s not found", s.spec.Store))
+		return nil, resultStoreFailed
+	}
+
+	var session *context.Session
+	if cookie, err := ctx.Request().Cookie(s.spec.CookieName); err == nil && cookie.Value != "" {
+		data, err := store.Load(cookie.Value)
+		if err != nil {
+			logger.Errorf("%s: load session failed: %v", s.filterSpec.Name(), err)
+			ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+			ctx.AddTag(fmt.Sprintf("session: %v", err))
+			return nil, resultStoreFailed
+		}
+		if data != nil {
+			session = context.NewSession(cookie.Value, data)
+		}
+	}
+
+	// NOTE: Unknown session IDs are never adopted to prevent session
+	// fixation, new sessions always get IDs generated by the gateway.
+	if session == nil {
+		if s.spec.Required {
+			ctx.Response().SetStatusCode(http.StatusUnauthorized)
+			ctx.AddTag("session: session required")
+			return nil, resultSessionRequired
+		}
+		session = context.NewSession("", nil)
+	}
+
+	for key, value := range session.Data() {
+		if !context.IsInternalKey(key) {
+			header.Set(s.headerPrefix+key, value)
+		}
+	}
+	context.SetSession(ctx, session)
+	return session, ""
+}
+
+func (s *Session) save(ctx context.HTTPContext, store *sessionstore.SessionStore, session *context.Session) {
+	header := ctx.Response().Header()
+	for key, values := range header.Std() {
+		if !strings.HasPrefix(key, s.headerPrefix) {
+			continue
+		}
+		header.Del(key)
+
+		dataKey := strings.ToLower(key[len(s.headerPrefix):])
+		switch {
+		case context.IsInternalKey(dataKey):
+			logger.Warnf("%s: internal session key %s can't be changed by backends", s.filterSpec.Name(), dataKey)
+		case dataKey == destroyKey:
+			session.Destroy()
+		case len(values) == 0 || values[0] == "":
+			session.Delete(dataKey)
+		default:
+			session.Set(dataKey, values[0])
+		}
+	}
+
+	var err error
+	switch {
+	case session.Destroyed():
+		if session.ID != "" {
+			err = store.Delete(session.ID)
+			ctx.Response().SetCookie(s.newCookie("", -1))
+		}
+	case session.Modified():
+		if session.Renewed() && session.ID != "" {
+			if err = store.Delete(session.ID); err != nil {
+				break
+			}
+			session.ID = ""
+		}
+		isNew := session.ID == ""
+		if isNew {
+			session.ID, err = sessionstore.NewID()
+			if err != nil {
+				break
+			}
+		}
+		err = store.Save(session.ID, session.Data())
+		if err == nil && isNew {
+			ctx.Response().SetCookie(s.newCookie(session.ID, 0))
+		}
+	case session.ID != "":
+		err = store.Touch(session.ID)
+	}
+
+	if err != nil {
+		logger.Errorf("%s: save session failed: %v", s.filterSpec.Name(), err)
+		ctx.AddTag(fmt.Sprintf("session: %v", err))
+	}
+}
+
+func (s *Session) newCookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.spec.CookieName,
+		Value:    value,
+		Path:     s.spec.CookiePath,
+		Domain:   s.spec.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   s.spec.Secure,
+		HttpOnly: true,
+		SameSite: s.sameSite,
+	}
+}
+
+// Status returns status.
+func (s *Session) Status() interface{} {
+	return nil
+}
+
+// Close closes Session.
+func (s *Session) Close() {}
@@ -0,0 +1,259 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package session
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/object/sessionstore"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/yamltool"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func newSession(t *testing.T, yamlSpec string) *Session {
+	rawSpec := make(map[string]interface{})
+	yamltool.Unmarshal([]byte(yamlSpec), &rawSpec)
+
+	spec, err := httppipeline.NewFilterSpec(rawSpec, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s := &Session{}
+	s.Init(spec)
+	return s
+}
+
+func newStore(t *testing.T) *sessionstore.SessionStore {
+	superSpec, err := supervisor.NewDefaultMock().NewSpec(`
+kind: SessionStore
+name: sessions
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ss := &sessionstore.SessionStore{}
+	ss.Init(superSpec)
+	return ss
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	statusCode int
+	reqHeader  *httpheader.HTTPHeader
+	respHeader *httpheader.HTTPHeader
+	cookies    []*http.Cookie
+}
+
+// newContext creates a context with the cookie, and next is called as
+// the following filters.
+func newContext(cookie string, next func(ctx *mockedContext)) *mockedContext {
+	ctx := &mockedContext{
+		MockedHTTPContext: &contexttest.MockedHTTPContext{},
+		statusCode:        http.StatusOK,
+		reqHeader:         httpheader.New(http.Header{}),
+		respHeader:        httpheader.New(http.Header{}),
+	}
+
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return ctx.reqHeader }
+	ctx.MockedRequest.MockedCookie = func(name string) (*http.Cookie, error) {
+		if name != defaultCookieName || cookie == "" {
+			return nil, http.ErrNoCookie
+		}
+		return &http.Cookie{Name: name, Value: cookie}, nil
+	}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return ctx.respHeader }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { ctx.statusCode = code }
+	ctx.MockedResponse.MockedSetCookie = func(c *http.Cookie) { ctx.cookies = append(ctx.cookies, c) }
+	ctx.MockedCallNextHandler = func(lastResult string) string {
+		if lastResult == "" && next != nil {
+			next(ctx)
+		}
+		return lastResult
+	}
+	return ctx
+}
+
+func TestSession(t *testing.T) {
+	s := newSession(t, `
+kind: Session
+name: session
+store: sessions
+`)
+
+	// The store is absent.
+	ctx := newContext("", nil)
+	if result := s.Handle(ctx); result != resultStoreFailed || ctx.statusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected result %s, status code %d", result, ctx.statusCode)
+	}
+
+	store := newStore(t)
+	defer store.Close()
+
+	// Nothing is saved for the session without data.
+	ctx = newContext("unknown", func(ctx *mockedContext) {
+		if session := context.GetSession(ctx); session == nil || session.ID != "" {
+			t.Errorf("there should be a new session")
+		}
+	})
+	ctx.reqHeader.Set("X-Session-User", "forged")
+	if result := s.Handle(ctx); result != "" {
+		t.Fatalf("unexpected result %s", result)
+	}
+	if ctx.reqHeader.Get("X-Session-User") != "" {
+		t.Errorf("session headers of clients should be removed")
+	}
+	if len(ctx.cookies) != 0 {
+		t.Errorf("cookie should not be set for the empty session")
+	}
+
+	// The backend logs in the user.
+	ctx = newContext("", func(ctx *mockedContext) {
+		ctx.respHeader.Set("X-Session-User", "alice")
+		context.GetSession(ctx).Set("role", "admin")
+	})
+	s.Handle(ctx)
+	if len(ctx.cookies) != 1 || ctx.cookies[0].Value == "" || !ctx.cookies[0].HttpOnly {
+		t.Fatalf("cookie of the new session should be set: %v", ctx.cookies)
+	}
+	if ctx.respHeader.Get("X-Session-User") != "" {
+		t.Errorf("session headers should be removed from the response")
+	}
+	id := ctx.cookies[0].Value
+
+	// The data are attached to the following requests.
+	ctx = newContext(id, nil)
+	s.Handle(ctx)
+	if ctx.reqHeader.Get("X-Session-User") != "alice" || ctx.reqHeader.Get("X-Session-Role") != "admin" {
+		t.Errorf("session data should be attached: %v", ctx.reqHeader.Std())
+	}
+	if len(ctx.cookies) != 0 {
+		t.Errorf("cookie should not be set for the existing session")
+	}
+
+	// The ID is renewed while the data are kept.
+	ctx = newContext(id, func(ctx *mockedContext) {
+		context.GetSession(ctx).Renew()
+	})
+	s.Handle(ctx)
+	if len(ctx.cookies) != 1 || ctx.cookies[0].Value == id {
+		t.Fatalf("cookie of the renewed session should be set: %v", ctx.cookies)
+	}
+	if data, _ := store.Load(id); data != nil {
+		t.Errorf("session of the old ID should be deleted")
+	}
+	id = ctx.cookies[0].Value
+	if data, _ := store.Load(id); data["user"] != "alice" {
+		t.Errorf("data should be kept, but are %v", data)
+	}
+
+	// The backend logs out the user.
+	ctx = newContext(id, func(ctx *mockedContext) {
+		ctx.respHeader.Set("X-Session-Destroy", "true")
+	})
+	s.Handle(ctx)
+	if len(ctx.cookies) != 1 || ctx.cookies[0].MaxAge >= 0 {
+		t.Errorf("cookie should be expired: %v", ctx.cookies)
+	}
+	if data, _ := store.Load(id); data != nil {
+		t.Errorf("session should be deleted")
+	}
+}
+
+func TestSessionRequired(t *testing.T) {
+	s := newSession(t, `
+kind: Session
+name: session
+store: sessions
+required: true
+`)
+
+	store := newStore(t)
+	defer store.Close()
+
+	ctx := newContext("unknown", nil)
+	if result := s.Handle(ctx); result != resultSessionRequired || ctx.statusCode != http.StatusUnauthorized {
+		t.Fatalf("unexpected result %s, status code %d", result, ctx.statusCode)
+	}
+
+	store.Save("known", map[string]string{"user": "bob"})
+	ctx = newContext("known", nil)
+	if result := s.Handle(ctx); result != "" || ctx.reqHeader.Get("X-Session-User") != "bob" {
+		t.Errorf("unexpected result %s, headers %v", result, ctx.reqHeader.Std())
+	}
+}
+
+func TestSessionInternalKeys(t *testing.T) {
+	s := newSession(t, `
+kind: Session
+name: session
+store: sessions
+`)
+
+	store := newStore(t)
+	defer store.Close()
+
+	store.Save("known", map[string]string{"user": "bob", "eg-state": "s"})
+
+	// Internal keys are not sent to the backends.
+	ctx := newContext("known", func(ctx *mockedContext) {
+		if context.GetSession(ctx).Get("eg-state") != "s" {
+			t.Errorf("internal keys should be accessible to filters")
+		}
+		ctx.respHeader.Set("X-Session-Eg-State", "")
+		ctx.respHeader.Set("X-Session-Eg-Other", "forged")
+	})
+	s.Handle(ctx)
+	if ctx.reqHeader.Get("X-Session-User") != "bob" || ctx.reqHeader.Get("X-Session-Eg-State") != "" {
+		t.Errorf("unexpected headers %v", ctx.reqHeader.Std())
+	}
+	if ctx.respHeader.Get("X-Session-Eg-State") != "" || ctx.respHeader.Get("X-Session-Eg-Other") != "" {
+		t.Errorf("session headers should be removed from the response")
+	}
+
+	// Nor are they changed by the backends.
+	data, _ := store.Load("known")
+	if data["eg-state"] != "s" || data["eg-other"] != "" {
+		t.Errorf("internal keys should not be changed, but data are %v", data)
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := &Spec{Store: "sessions", SameSite: "none"}
+	if spec.Validate() == nil {
+		t.Errorf("sameSite none without secure should be invalid")
+	}
+	spec.Secure = true
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
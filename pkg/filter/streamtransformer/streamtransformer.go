@@ -0,0 +1,223 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package streamtransformer
+
+import (
+	"fmt"
+	"mime"
+	"regexp"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// Kind is the kind of StreamTransformer.
+	Kind = "StreamTransformer"
+
+	formatSSE       = "sse"
+	formatJSONLines = "jsonlines"
+
+	defaultIDField       = "id"
+	defaultMaxRecordSize = 1024 * 1024
+)
+
+var (
+	results = []string{}
+
+	defaultContentTypes = map[string][]string{
+		formatSSE:       {"text/event-stream"},
+		formatJSONLines: {"application/x-ndjson", "application/jsonl", "application/x-jsonlines"},
+	}
+)
+
+func init() {
+	httppipeline.Register(&StreamTransformer{})
+}
+
+type (
+	// StreamTransformer rewrites SSE or JSON lines response streams record
+	// by record, without buffering the whole stream.
+	StreamTransformer struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+
+		contentTypes map[string]struct{}
+		include      []*matcher
+		exclude      []*matcher
+	}
+
+	// Spec describes the StreamTransformer.
+	Spec struct {
+		Format       string   `yaml:"format" jsonschema:"required,enum=sse,enum=jsonlines"`
+		ContentTypes []string `yaml:"contentTypes" jsonschema:"omitempty,uniqueItems=true"`
+		// InjectID adds ids to records lacking them, the ids are sequence
+		// numbers starting from 1 in every stream.
+		InjectID bool `yaml:"injectID" jsonschema:"omitempty"`
+		// IDField is the field name of the injected ids in JSON lines.
+		IDField       string   `yaml:"idField" jsonschema:"omitempty"`
+		Include       []*Match `yaml:"include" jsonschema:"omitempty"`
+		Exclude       []*Match `yaml:"exclude" jsonschema:"omitempty"`
+		MaxRecordSize uint32   `yaml:"maxRecordSize" jsonschema:"omitempty"`
+	}
+
+	// Match matches records by the value of a field.
+	//
+	// For SSE, the field is one of event, id, data, or a dot separated
+	// path prefixed with data to look up the JSON data, e.g. data.user.name.
+	// For JSON lines, the field is a dot separated path.
+	Match struct {
+		Field  string   `yaml:"field" jsonschema:"required"`
+		Values []string `yaml:"values" jsonschema:"omitempty,uniqueItems=true"`
+		Regexp string   `yaml:"regexp" jsonschema:"omitempty,format=regexp"`
+	}
+)
+
+// Validate validates the Match.
+func (m *Match) Validate() error {
+	if len(m.Values) == 0 && m.Regexp == "" {
+		return fmt.Errorf("both values and regexp are empty")
+	}
+	if m.Regexp != "" {
+		if _, err := regexp.Compile(m.Regexp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	if spec.Format == formatSSE {
+		if spec.IDField != "" {
+			return fmt.Errorf("idField is only for format jsonlines")
+		}
+		for _, m := range append(append([]*Match{}, spec.Include...), spec.Exclude...) {
+			name := strings.SplitN(m.Field, ".", 2)[0]
+			switch {
+			case name == "data":
+			case m.Field == "event", m.Field == "id":
+			default:
+				return fmt.Errorf("invalid field %s for format sse", m.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// Kind returns the kind of StreamTransformer.
+func (st *StreamTransformer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of StreamTransformer.
+func (st *StreamTransformer) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of StreamTransformer.
+func (st *StreamTransformer) Description() string {
+	return "StreamTransformer rewrites SSE or JSON lines response streams on the fly."
+}
+
+// Results returns the results of StreamTransformer.
+func (st *StreamTransformer) Results() []string {
+	return results
+}
+
+// Init initializes StreamTransformer.
+func (st *StreamTransformer) Init(filterSpec *httppipeline.FilterSpec) {
+	st.filterSpec, st.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	st.reload()
+}
+
+// Inherit inherits previous generation of StreamTransformer.
+func (st *StreamTransformer) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	st.Init(filterSpec)
+}
+
+func (st *StreamTransformer) reload() {
+	contentTypes := st.spec.ContentTypes
+	if len(contentTypes) == 0 {
+		contentTypes = defaultContentTypes[st.spec.Format]
+	}
+	st.contentTypes = map[string]struct{}{}
+	for _, ct := range contentTypes {
+		st.contentTypes[strings.ToLower(ct)] = struct{}{}
+	}
+
+	st.include = nil
+	for _, m := range st.spec.Include {
+		st.include = append(st.include, newMatcher(m))
+	}
+	st.exclude = nil
+	for _, m := range st.spec.Exclude {
+		st.exclude = append(st.exclude, newMatcher(m))
+	}
+}
+
+// Handle calls the next handler, and then transforms the response stream.
+func (st *StreamTransformer) Handle(ctx context.HTTPContext) string {
+	result := ctx.CallNextHandler("")
+	if result == "" {
+		st.handle(ctx)
+	}
+	return result
+}
+
+func (st *StreamTransformer) handle(ctx context.HTTPContext) {
+	w := ctx.Response()
+	if w.Body() == nil {
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(w.Header().Get(httpheader.KeyContentType))
+	if _, ok := st.contentTypes[strings.ToLower(mediaType)]; !ok {
+		return
+	}
+
+	idField := st.spec.IDField
+	if idField == "" {
+		idField = defaultIDField
+	}
+	maxRecordSize := int(st.spec.MaxRecordSize)
+	if maxRecordSize == 0 {
+		maxRecordSize = defaultMaxRecordSize
+	}
+
+	t := &transformer{
+		include:  st.include,
+		exclude:  st.exclude,
+		injectID: st.spec.InjectID,
+		idField:  idField,
+	}
+
+	w.Header().Del(httpheader.KeyContentLength)
+	w.SetBody(newTransformReader(w.Body(), t, st.spec.Format == formatSSE, maxRecordSize))
+}
+
+// Status returns status.
+func (st *StreamTransformer) Status() interface{} {
+	return nil
+}
+
+// Close closes StreamTransformer.
+func (st *StreamTransformer) Close() {}
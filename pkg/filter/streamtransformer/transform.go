@@ -0,0 +1,338 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package streamtransformer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var errRecordTooLarge = errors.New("record too large")
+
+type (
+	// transformReader transforms the records of the source stream one by
+	// one, a record is a line for JSON lines or an event for SSE.
+	transformReader struct {
+		src    *bufio.Reader
+		closer io.Closer
+
+		maxRecordSize int
+		sse           bool
+		t             *transformer
+
+		out bytes.Buffer
+		err error
+	}
+
+	// transformer transforms a single record, it keeps the state of a stream.
+	transformer struct {
+		include []*matcher
+		exclude []*matcher
+
+		injectID bool
+		idField  string
+		seq      uint64
+	}
+
+	matcher struct {
+		path   []string
+		values map[string]struct{}
+		re     *regexp.Regexp
+	}
+
+	sseField struct {
+		name  string
+		value string
+	}
+)
+
+func newMatcher(m *Match) *matcher {
+	mt := &matcher{path: strings.Split(m.Field, ".")}
+	if len(m.Values) > 0 {
+		mt.values = map[string]struct{}{}
+		for _, v := range m.Values {
+			mt.values[v] = struct{}{}
+		}
+	}
+	if m.Regexp != "" {
+		mt.re = regexp.MustCompile(m.Regexp)
+	}
+	return mt
+}
+
+func (m *matcher) match(value string, exists bool) bool {
+	if !exists {
+		return false
+	}
+	if _, ok := m.values[value]; ok {
+		return true
+	}
+	return m.re != nil && m.re.MatchString(value)
+}
+
+// lookup looks up the value of the path in a JSON value.
+func lookup(v interface{}, path []string) (string, bool) {
+	for _, key := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if v, ok = obj[key]; !ok {
+			return "", false
+		}
+	}
+
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case nil:
+		return "null", true
+	default:
+		data, _ := json.Marshal(v)
+		return string(data), true
+	}
+}
+
+// keep reports whether the record is kept, get returns the value of
+// the path in the record.
+func (t *transformer) keep(get func(path []string) (string, bool)) bool {
+	if len(t.include) > 0 {
+		matched := false
+		for _, m := range t.include {
+			if m.match(get(m.path)) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	for _, m := range t.exclude {
+		if m.match(get(m.path)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (t *transformer) nextID() string {
+	t.seq++
+	return strconv.FormatUint(t.seq, 10)
+}
+
+// transformLine transforms a JSON line, lines which are not JSON objects
+// are kept as is. It returns nil if the line is dropped.
+func (t *transformer) transformLine(line []byte) []byte {
+	trimmed := bytes.TrimSpace(line)
+	if len(trimmed) == 0 {
+		return line
+	}
+
+	var obj map[string]interface{}
+	if err := json.Unmarshal(trimmed, &obj); err != nil {
+		return line
+	}
+
+	if !t.keep(func(path []string) (string, bool) { return lookup(obj, path) }) {
+		return nil
+	}
+
+	if !t.injectID {
+		return line
+	}
+	if _, exists := obj[t.idField]; exists {
+		return line
+	}
+
+	obj[t.idField] = t.nextID()
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return line
+	}
+	return append(data, '\n')
+}
+
+func parseSSE(event []byte) []*sseField {
+	var fields []*sseField
+	for _, line := range strings.Split(strings.TrimRight(string(event), "\r\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		name, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			name, value = line[:i], strings.TrimPrefix(line[i+1:], " ")
+		}
+		fields = append(fields, &sseField{name: name, value: value})
+	}
+	return fields
+}
+
+// transformEvent transforms an SSE event, events with only comments are
+// kept as is. It returns nil if the event is dropped.
+// Reference: https://html.spec.whatwg.org/multipage/server-sent-events.html
+func (t *transformer) transformEvent(event []byte) []byte {
+	fields := parseSSE(event)
+
+	hasID, hasData := false, false
+	var data []string
+	eventType := "message"
+	for _, f := range fields {
+		switch f.name {
+		case "id":
+			hasID = true
+		case "data":
+			hasData = true
+			data = append(data, f.value)
+		case "event":
+			eventType = f.value
+		}
+	}
+	if !hasData {
+		return event
+	}
+
+	joined := strings.Join(data, "\n")
+	var parsed interface{}
+	parsedOnce := false
+	get := func(path []string) (string, bool) {
+		switch path[0] {
+		case "event":
+			return eventType, len(path) == 1
+		case "data":
+			if len(path) == 1 {
+				return joined, true
+			}
+			if !parsedOnce {
+				parsedOnce = true
+				json.Unmarshal([]byte(joined), &parsed)
+			}
+			return lookup(parsed, path[1:])
+		case "id":
+			for _, f := range fields {
+				if f.name == "id" {
+					return f.value, len(path) == 1
+				}
+			}
+		}
+		return "", false
+	}
+
+	if !t.keep(get) {
+		return nil
+	}
+
+	if !t.injectID || hasID {
+		return event
+	}
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "id: %s\n", t.nextID())
+	for _, f := range fields {
+		if f.name == "" && f.value == "" {
+			continue
+		}
+		buf.WriteString(f.name)
+		buf.WriteString(": ")
+		buf.WriteString(f.value)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes()
+}
+
+func newTransformReader(src io.Reader, t *transformer, sse bool, maxRecordSize int) *transformReader {
+	tr := &transformReader{
+		src:           bufio.NewReader(src),
+		maxRecordSize: maxRecordSize,
+		sse:           sse,
+		t:             t,
+	}
+	tr.closer, _ = src.(io.Closer)
+	return tr
+}
+
+// readLine reads a line including the line feed.
+func (tr *transformReader) readLine(buf []byte) ([]byte, error) {
+	for {
+		line, err := tr.src.ReadSlice('\n')
+		buf = append(buf, line...)
+		if len(buf) > tr.maxRecordSize {
+			return nil, errRecordTooLarge
+		}
+		if err != bufio.ErrBufferFull {
+			return buf, err
+		}
+	}
+}
+
+// readRecord reads a line for JSON lines, or lines until an empty line
+// for SSE.
+func (tr *transformReader) readRecord() ([]byte, error) {
+	if !tr.sse {
+		return tr.readLine(nil)
+	}
+
+	var record []byte
+	for {
+		start := len(record)
+		var err error
+		record, err = tr.readLine(record)
+		if err != nil {
+			return record, err
+		}
+		line := record[start:]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			return record, nil
+		}
+	}
+}
+
+func (tr *transformReader) Read(p []byte) (int, error) {
+	for tr.out.Len() == 0 && tr.err == nil {
+		record, err := tr.readRecord()
+		if len(record) > 0 {
+			if tr.sse {
+				record = tr.t.transformEvent(record)
+			} else {
+				record = tr.t.transformLine(record)
+			}
+			tr.out.Write(record)
+		}
+		tr.err = err
+	}
+
+	if tr.out.Len() > 0 {
+		return tr.out.Read(p)
+	}
+	return 0, tr.err
+}
+
+func (tr *transformReader) Close() error {
+	if tr.closer == nil {
+		return nil
+	}
+	return tr.closer.Close()
+}
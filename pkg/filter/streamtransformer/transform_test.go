@@ -0,0 +1,125 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package streamtransformer
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func transform(t *testing.T, tr *transformer, sse bool, input string) string {
+	r := newTransformReader(strings.NewReader(input), tr, sse, defaultMaxRecordSize)
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return string(data)
+}
+
+func TestSSE(t *testing.T) {
+	input := ": keepalive\n\n" +
+		"event: ping\ndata: {}\n\n" +
+		"id: 7\ndata: {\"user\":{\"name\":\"alice\"}}\n\n" +
+		"data: {\"user\":{\"name\":\"bob\"}}\n\n"
+
+	tr := &transformer{}
+	if got := transform(t, tr, true, input); got != input {
+		t.Errorf("expected the stream unchanged, got %q", got)
+	}
+
+	tr = &transformer{
+		exclude:  []*matcher{newMatcher(&Match{Field: "event", Values: []string{"ping"}})},
+		injectID: true,
+	}
+	expected := ": keepalive\n\n" +
+		"id: 7\ndata: {\"user\":{\"name\":\"alice\"}}\n\n" +
+		"id: 1\ndata: {\"user\":{\"name\":\"bob\"}}\n\n"
+	if got := transform(t, tr, true, input); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	tr = &transformer{
+		include: []*matcher{newMatcher(&Match{Field: "data.user.name", Regexp: "^b"})},
+	}
+	expected = ": keepalive\n\n" +
+		"data: {\"user\":{\"name\":\"bob\"}}\n\n"
+	if got := transform(t, tr, true, input); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestJSONLines(t *testing.T) {
+	input := "{\"type\":\"a\",\"id\":\"x\"}\n" +
+		"{\"type\":\"b\"}\n" +
+		"not json\n" +
+		"{\"type\":\"a\"}"
+
+	tr := &transformer{
+		exclude:  []*matcher{newMatcher(&Match{Field: "type", Values: []string{"b"}})},
+		injectID: true,
+		idField:  "seq",
+	}
+	got := transform(t, tr, false, input)
+	lines := strings.Split(got, "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 3 lines, got %q", got)
+	}
+	if lines[0] != "{\"id\":\"x\",\"seq\":\"1\",\"type\":\"a\"}" {
+		t.Errorf("unexpected line %q", lines[0])
+	}
+	if lines[1] != "not json" {
+		t.Errorf("unexpected line %q", lines[1])
+	}
+	if lines[2] != "{\"seq\":\"2\",\"type\":\"a\"}" {
+		t.Errorf("unexpected line %q", lines[2])
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	pr, pw := io.Pipe()
+	r := bufio.NewReader(newTransformReader(pr, &transformer{}, true, defaultMaxRecordSize))
+
+	go pw.Write([]byte("data: 1\n\ndata: 2"))
+
+	// The first event must be available before the stream ends.
+	line, err := r.ReadString('\n')
+	if err != nil || line != "data: 1\n" {
+		t.Fatalf("unexpected line %q, error %v", line, err)
+	}
+
+	pw.Close()
+	rest, _ := ioutil.ReadAll(r)
+	if string(rest) != "\ndata: 2" {
+		t.Errorf("unexpected rest %q", rest)
+	}
+}
+
+func TestMaxRecordSize(t *testing.T) {
+	input := "{\"a\":1}\n" + strings.Repeat("x", 100) + "\n"
+	r := newTransformReader(strings.NewReader(input), &transformer{}, false, 64)
+	data, err := ioutil.ReadAll(r)
+	if err != errRecordTooLarge {
+		t.Errorf("expected error %v, got %v", errRecordTooLarge, err)
+	}
+	if string(data) != "{\"a\":1}\n" {
+		t.Errorf("unexpected data %q", data)
+	}
+}
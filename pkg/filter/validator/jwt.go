@@ -25,6 +25,7 @@ import (
 	"github.com/golang-jwt/jwt"
 
 	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/fips"
 )
 
 // JWTValidatorSpec defines the configuration of JWT validator
@@ -36,6 +37,16 @@ type JWTValidatorSpec struct {
 	// this name both exists and has a non-empty value, its value is used as token
 	// string, the Authorization header is used to get the token string otherwise.
 	CookieName string `yaml:"cookieName" jsonschema:"omitempty"`
+	// ConsumerClaim and TenantClaim are the names of the claims holding
+	// the consumer and tenant of the identity, for access logs and metrics.
+	ConsumerClaim string `yaml:"consumerClaim" jsonschema:"omitempty"`
+	TenantClaim   string `yaml:"tenantClaim" jsonschema:"omitempty"`
+}
+
+// Validate validates JWTValidatorSpec.
+func (spec *JWTValidatorSpec) Validate() error {
+	secret, _ := hex.DecodeString(spec.Secret)
+	return fips.CheckHMACKey(secret)
 }
 
 // NewJWTValidator creates a new JWT validator
@@ -55,6 +66,13 @@ type JWTValidator struct {
 
 // Validate validates the JWT token of a http request
 func (v *JWTValidator) Validate(req context.HTTPRequest) error {
+	_, err := v.ValidateClaims(req)
+	return err
+}
+
+// ValidateClaims validates the JWT token of a http request and returns
+// its claims.
+func (v *JWTValidator) ValidateClaims(req context.HTTPRequest) (map[string]interface{}, error) {
 	var token string
 
 	if v.spec.CookieName != "" {
@@ -67,18 +85,22 @@ func (v *JWTValidator) Validate(req context.HTTPRequest) error {
 		const prefix = "Bearer "
 		authHdr := req.Header().Get("Authorization")
 		if !strings.HasPrefix(authHdr, prefix) {
-			return fmt.Errorf("unexpected authorization header: %s", authHdr)
+			return nil, fmt.Errorf("unexpected authorization header: %s", authHdr)
 		}
 		token = authHdr[len(prefix):]
 	}
 
 	// jwt.Parse does everything including parsing and verification
-	_, e := jwt.Parse(token, func(token *jwt.Token) (interface{}, error) {
+	claims := jwt.MapClaims{}
+	_, e := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
 		if alg := token.Method.Alg(); alg != v.spec.Algorithm {
 			return nil, fmt.Errorf("unexpected signing method: %v", alg)
 		}
 		return v.secretBytes, nil
 	})
+	if e != nil {
+		return nil, e
+	}
 
-	return e
+	return claims, nil
 }
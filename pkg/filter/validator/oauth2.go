@@ -29,6 +29,8 @@ import (
 	"github.com/golang-jwt/jwt"
 
 	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+	"github.com/megaease/easegress/pkg/util/fips"
 )
 
 type (
@@ -76,6 +78,12 @@ type (
 	}
 )
 
+// Validate validates OAuth2JWT.
+func (spec *OAuth2JWT) Validate() error {
+	secret, _ := hex.DecodeString(spec.Secret)
+	return fips.CheckHMACKey(secret)
+}
+
 // NewOAuth2Validator creates a new OAuth2 validator
 func NewOAuth2Validator(spec *OAuth2ValidatorSpec) *OAuth2Validator {
 	if spec.JWT != nil {
@@ -85,9 +93,9 @@ func NewOAuth2Validator(spec *OAuth2ValidatorSpec) *OAuth2Validator {
 	if spec.TokenIntrospect != nil {
 		if spec.TokenIntrospect.InsecureTLS {
 			cfg := tls.Config{InsecureSkipVerify: true}
-			v.client = &http.Client{Transport: &http.Transport{TLSClientConfig: &cfg}}
+			v.client = &http.Client{Transport: &http.Transport{Proxy: egressproxy.Global, TLSClientConfig: &cfg}}
 		} else {
-			v.client = http.DefaultClient
+			v.client = &http.Client{Transport: egressproxy.DefaultTransport}
 		}
 	}
 	return v
@@ -139,25 +147,33 @@ func (v *OAuth2Validator) introspectToken(tokenStr string) (*tokenInfo, error) {
 
 // Validate validates the access token of a http request
 func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
+	_, _, err := v.ValidateIdentity(req)
+	return err
+}
+
+// ValidateIdentity validates the access token of a http request, and
+// returns the subject and the client ID of the token.
+func (v *OAuth2Validator) ValidateIdentity(req context.HTTPRequest) (subject, clientID string, err error) {
 	const prefix = "Bearer "
 
 	hdr := req.Header()
 	tokenStr := hdr.Get("Authorization")
 	if !strings.HasPrefix(tokenStr, prefix) {
-		return fmt.Errorf("unexpected authorization header: %s", tokenStr)
+		return "", "", fmt.Errorf("unexpected authorization header: %s", tokenStr)
 	}
 	tokenStr = tokenStr[len(prefix):]
 
-	var subject, scope string
+	var scope string
 	if v.spec.TokenIntrospect != nil {
 		ti, e := v.introspectToken(tokenStr)
 		if e != nil {
-			return e
+			return "", "", e
 		}
 		if !ti.Active {
-			return fmt.Errorf("oauth2 authorization failed, token is inactive")
+			return "", "", fmt.Errorf("oauth2 authorization failed, token is inactive")
 		}
 		subject = ti.Subject
+		clientID = ti.ClientID
 		scope = ti.Scope
 	} else {
 		token, e := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
@@ -167,11 +183,12 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 			return v.spec.JWT.secretBytes, nil
 		})
 		if e != nil {
-			return e
+			return "", "", e
 		}
 
 		claims := token.Claims.(jwt.MapClaims)
 		subject, _ = claims["sub"].(string)
+		clientID, _ = claims["client_id"].(string)
 		scope, _ = claims["scope"].(string)
 	}
 
@@ -183,5 +200,5 @@ func (v *OAuth2Validator) Validate(req context.HTTPRequest) error {
 		hdr.Set("X-Authenticated-Scope", scope)
 	}
 
-	return nil
+	return subject, clientID, nil
 }
@@ -0,0 +1,180 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package validator
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/fips"
+)
+
+const (
+	defaultSignedURLExpiresParam   = "expires"
+	defaultSignedURLSignatureParam = "signature"
+	defaultSignedURLKeyIDParam     = "keyId"
+)
+
+type (
+	// SignedURLValidatorSpec defines the configuration of signed URL validator.
+	// The signature is the hex encoded HMAC-SHA256 of the path, the expire
+	// time in unix seconds and the optional client IP, joined by '\n'.
+	SignedURLValidatorSpec struct {
+		// Keys maps key ids to secrets, multiple keys are used for rotation.
+		Keys map[string]string `yaml:"keys" jsonschema:"required,minProperties=1"`
+		// BindIP includes the real IP of the client in the signature.
+		BindIP bool `yaml:"bindIP" jsonschema:"omitempty"`
+		// MaxTTL rejects links expiring too far in the future, empty means no limit.
+		MaxTTL string `yaml:"maxTTL" jsonschema:"omitempty,format=duration"`
+
+		ExpiresParam   string `yaml:"expiresParam" jsonschema:"omitempty"`
+		SignatureParam string `yaml:"signatureParam" jsonschema:"omitempty"`
+		KeyIDParam     string `yaml:"keyIdParam" jsonschema:"omitempty"`
+	}
+
+	// SignedURLValidator defines the signed URL validator
+	SignedURLValidator struct {
+		spec   *SignedURLValidatorSpec
+		maxTTL time.Duration
+
+		expiresParam   string
+		signatureParam string
+		keyIDParam     string
+	}
+)
+
+// Validate validates SignedURLValidatorSpec.
+func (spec *SignedURLValidatorSpec) Validate() error {
+	for id, secret := range spec.Keys {
+		if err := fips.CheckHMACKey([]byte(secret)); err != nil {
+			return fmt.Errorf("key %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// NewSignedURLValidator creates a new signed URL validator
+func NewSignedURLValidator(spec *SignedURLValidatorSpec) *SignedURLValidator {
+	v := &SignedURLValidator{
+		spec:           spec,
+		expiresParam:   spec.ExpiresParam,
+		signatureParam: spec.SignatureParam,
+		keyIDParam:     spec.KeyIDParam,
+	}
+
+	if v.expiresParam == "" {
+		v.expiresParam = defaultSignedURLExpiresParam
+	}
+	if v.signatureParam == "" {
+		v.signatureParam = defaultSignedURLSignatureParam
+	}
+	if v.keyIDParam == "" {
+		v.keyIDParam = defaultSignedURLKeyIDParam
+	}
+
+	if spec.MaxTTL != "" {
+		v.maxTTL, _ = time.ParseDuration(spec.MaxTTL)
+	}
+
+	return v
+}
+
+func signURL(secret, path, expires, ip string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(expires))
+	if ip != "" {
+		mac.Write([]byte{'\n'})
+		mac.Write([]byte(ip))
+	}
+	return mac.Sum(nil)
+}
+
+// Validate validates the signed URL of a http request, the signature
+// parameters are removed from the query after validation.
+func (v *SignedURLValidator) Validate(req context.HTTPRequest) error {
+	return v.validate(req, time.Now())
+}
+
+func (v *SignedURLValidator) validate(req context.HTTPRequest, now time.Time) error {
+	query, err := url.ParseQuery(req.Query())
+	if err != nil {
+		return fmt.Errorf("invalid query: %v", err)
+	}
+
+	expires, signature := query.Get(v.expiresParam), query.Get(v.signatureParam)
+	if expires == "" || signature == "" {
+		return fmt.Errorf("missing signature or expire time")
+	}
+
+	sec, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid expire time: %s", expires)
+	}
+	expireAt := time.Unix(sec, 0)
+	if now.After(expireAt) {
+		return fmt.Errorf("link expired at %s", expireAt.Format(time.RFC3339))
+	}
+	if v.maxTTL > 0 && expireAt.Sub(now) > v.maxTTL {
+		return fmt.Errorf("expire time exceeds max ttl %s", v.spec.MaxTTL)
+	}
+
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+
+	ip := ""
+	if v.spec.BindIP {
+		ip = req.RealIP()
+	}
+
+	// NOTE: Links without key id are checked against all keys, so that
+	// keys can be rotated without changing the links.
+	var secrets []string
+	if keyID := query.Get(v.keyIDParam); keyID != "" {
+		secret, ok := v.spec.Keys[keyID]
+		if !ok {
+			return fmt.Errorf("unknown key id: %s", keyID)
+		}
+		secrets = append(secrets, secret)
+	} else {
+		for _, secret := range v.spec.Keys {
+			secrets = append(secrets, secret)
+		}
+	}
+
+	for _, secret := range secrets {
+		if hmac.Equal(sig, signURL(secret, req.Path(), expires, ip)) {
+			query.Del(v.expiresParam)
+			query.Del(v.signatureParam)
+			query.Del(v.keyIDParam)
+			req.SetQuery(query.Encode())
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signature mismatch")
+}
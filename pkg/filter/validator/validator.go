@@ -46,10 +46,11 @@ type (
 		filterSpec *httppipeline.FilterSpec
 		spec       *Spec
 
-		headers *httpheader.Validator
-		jwt     *JWTValidator
-		signer  *signer.Signer
-		oauth2  *OAuth2Validator
+		headers   *httpheader.Validator
+		jwt       *JWTValidator
+		signer    *signer.Signer
+		oauth2    *OAuth2Validator
+		signedURL *SignedURLValidator
 	}
 
 	// Spec describes the Validator.
@@ -58,6 +59,7 @@ type (
 		JWT       *JWTValidatorSpec         `yaml:"jwt,omitempty" jsonschema:"omitempty"`
 		Signature *signer.Spec              `yaml:"signature,omitempty" jsonschema:"omitempty"`
 		OAuth2    *OAuth2ValidatorSpec      `yaml:"oauth2,omitempty" jsonschema:"omitempty"`
+		SignedURL *SignedURLValidatorSpec   `yaml:"signedURL,omitempty" jsonschema:"omitempty"`
 	}
 )
 
@@ -109,6 +111,10 @@ func (v *Validator) reload() {
 	if v.spec.OAuth2 != nil {
 		v.oauth2 = NewOAuth2Validator(v.spec.OAuth2)
 	}
+
+	if v.spec.SignedURL != nil {
+		v.signedURL = NewSignedURLValidator(v.spec.SignedURL)
+	}
 }
 
 // Handle validates HTTPContext.
@@ -130,12 +136,21 @@ func (v *Validator) handle(ctx context.HTTPContext) string {
 	}
 
 	if v.jwt != nil {
-		err := v.jwt.Validate(req)
+		claims, err := v.jwt.ValidateClaims(req)
 		if err != nil {
 			ctx.Response().SetStatusCode(http.StatusUnauthorized)
 			ctx.AddTag(stringtool.Cat("JWT validator: ", err.Error()))
 			return resultInvalid
 		}
+		ctx.SetKV(context.KeyJWTClaims, claims)
+		sub, _ := claims["sub"].(string)
+		identity := context.SetIdentity(ctx, "jwt", sub)
+		if c := v.spec.JWT.ConsumerClaim; c != "" {
+			identity.Consumer, _ = claims[c].(string)
+		}
+		if c := v.spec.JWT.TenantClaim; c != "" {
+			identity.Tenant, _ = claims[c].(string)
+		}
 	}
 
 	if v.signer != nil {
@@ -145,15 +160,27 @@ func (v *Validator) handle(ctx context.HTTPContext) string {
 			ctx.AddTag(stringtool.Cat("signature validator: ", err.Error()))
 			return resultInvalid
 		}
+		context.SetIdentity(ctx, "signature", "")
 	}
 
 	if v.oauth2 != nil {
-		err := v.oauth2.Validate(req)
+		subject, clientID, err := v.oauth2.ValidateIdentity(req)
 		if err != nil {
 			ctx.Response().SetStatusCode(http.StatusUnauthorized)
 			ctx.AddTag(stringtool.Cat("oauth2 validator: ", err.Error()))
 			return resultInvalid
 		}
+		context.SetIdentity(ctx, "oauth2", subject).Consumer = clientID
+	}
+
+	if v.signedURL != nil {
+		err := v.signedURL.Validate(req)
+		if err != nil {
+			ctx.Response().SetStatusCode(http.StatusForbidden)
+			ctx.AddTag(stringtool.Cat("signed URL validator: ", err.Error()))
+			return resultInvalid
+		}
+		context.SetIdentity(ctx, "signedURL", "")
 	}
 
 	return ""
@@ -162,5 +189,8 @@ func (v *Validator) handle(ctx context.HTTPContext) string {
 // Status returns status.
 func (v *Validator) Status() interface{} { return nil }
 
+// Authenticates marks Validator as a filter authenticating requests.
+func (v *Validator) Authenticates() {}
+
 // Close closes Validator.
 func (v *Validator) Close() {}
@@ -24,7 +24,9 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/megaease/easegress/pkg/context"
 	"github.com/megaease/easegress/pkg/context/contexttest"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/httppipeline"
@@ -126,6 +128,10 @@ jwt:
 	if result == resultInvalid {
 		t.Errorf("the jwt token in header should be valid")
 	}
+	claims, _ := ctx.GetKV(context.KeyJWTClaims).(map[string]interface{})
+	if claims["sub"] != "1234567890" {
+		t.Errorf("the jwt claims should be shared, but got %v", claims)
+	}
 
 	header.Set("Authorization", "not Bearer "+token)
 	result = v.Handle(ctx)
@@ -289,3 +295,84 @@ signature:
 		t.Errorf("OAuth/2 Authorization should fail")
 	}
 }
+
+func TestSignedURL(t *testing.T) {
+	const yamlSpec = `
+kind: Validator
+name: validator
+signedURL:
+  keys:
+    k1: secret1
+    k2: secret2
+  bindIP: true
+  maxTTL: 1h
+`
+	v := createValidator(yamlSpec, nil)
+
+	var query string
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedRequest.MockedPath = func() string {
+		return "/downloads/file.zip"
+	}
+	ctx.MockedRequest.MockedRealIP = func() string {
+		return "192.168.1.1"
+	}
+	ctx.MockedRequest.MockedQuery = func() string {
+		return query
+	}
+	ctx.MockedRequest.MockedSetQuery = func(q string) {
+		query = q
+	}
+
+	sign := func(secret, expires, ip string) string {
+		return fmt.Sprintf("%x", signURL(secret, "/downloads/file.zip", expires, ip))
+	}
+
+	expires := fmt.Sprintf("%d", time.Now().Add(10*time.Minute).Unix())
+
+	query = "a=1&keyId=k2&expires=" + expires + "&signature=" + sign("secret2", expires, "192.168.1.1")
+	if result := v.Handle(ctx); result != "" {
+		t.Errorf("signed URL should be valid")
+	}
+	if query != "a=1" {
+		t.Errorf("signature parameters should be removed, got %s", query)
+	}
+
+	// Without key id, all keys are tried.
+	query = "expires=" + expires + "&signature=" + sign("secret1", expires, "192.168.1.1")
+	if result := v.Handle(ctx); result != "" {
+		t.Errorf("signed URL without key id should be valid")
+	}
+
+	for _, q := range []string{
+		// missing signature
+		"expires=" + expires,
+		// signed by another key
+		"keyId=k1&expires=" + expires + "&signature=" + sign("secret2", expires, "192.168.1.1"),
+		// unknown key
+		"keyId=k3&expires=" + expires + "&signature=" + sign("secret2", expires, "192.168.1.1"),
+		// signed for another IP
+		"expires=" + expires + "&signature=" + sign("secret1", expires, "192.168.1.2"),
+		// tampered expire time
+		"expires=1" + expires + "&signature=" + sign("secret1", expires, "192.168.1.1"),
+	} {
+		query = q
+		if result := v.Handle(ctx); result != resultInvalid {
+			t.Errorf("signed URL should be invalid: %s", q)
+		}
+	}
+
+	// expired
+	expired := fmt.Sprintf("%d", time.Now().Add(-time.Minute).Unix())
+	query = "expires=" + expired + "&signature=" + sign("secret1", expired, "192.168.1.1")
+	if result := v.Handle(ctx); result != resultInvalid {
+		t.Errorf("expired link should be invalid")
+	}
+
+	// exceeds max ttl
+	tooLong := fmt.Sprintf("%d", time.Now().Add(2*time.Hour).Unix())
+	query = "expires=" + tooLong + "&signature=" + sign("secret1", tooLong, "192.168.1.1")
+	if result := v.Handle(ctx); result != resultInvalid {
+		t.Errorf("link exceeds max ttl should be invalid")
+	}
+}
@@ -0,0 +1,463 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webdav
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+type (
+	// s3FS implements webdav.FileSystem on the objects of an S3 bucket,
+	// directories are the common prefixes of keys ending with "/", and
+	// empty directories are kept by the marker objects named "<dir>/".
+	s3FS struct {
+		client    *s3Client
+		keyPrefix string
+	}
+
+	// s3File is a file opened for either reading or writing. The content
+	// of a file opened for writing is spooled to a temporary file, and
+	// uploaded when it is closed.
+	s3File struct {
+		fs   *s3FS
+		name string
+		info *fileInfo
+
+		// for reading
+		offset   int64
+		body     io.ReadCloser
+		children []os.FileInfo
+		listed   bool
+
+		// for writing
+		tmp  *os.File
+		hash hash.Hash
+		size int64
+	}
+
+	// fileInfo implements os.FileInfo, webdav.ETager and
+	// webdav.ContentTyper.
+	fileInfo struct {
+		name        string
+		size        int64
+		modTime     time.Time
+		isDir       bool
+		etag        string
+		contentType string
+	}
+)
+
+var _ webdav.FileSystem = (*s3FS)(nil)
+
+// key returns the object key of the name, name is a cleaned absolute path.
+func (fs *s3FS) key(name string) string {
+	return fs.keyPrefix + strings.TrimPrefix(path.Clean("/"+name), "/")
+}
+
+func (fs *s3FS) dirKey(name string) string {
+	key := fs.key(name)
+	if key == "" || strings.HasSuffix(key, "/") {
+		return key
+	}
+	return key + "/"
+}
+
+func isRoot(name string) bool {
+	return path.Clean("/"+name) == "/"
+}
+
+func (fs *s3FS) stat(name string) (*fileInfo, error) {
+	if isRoot(name) {
+		return &fileInfo{name: "/", isDir: true}, nil
+	}
+
+	info, err := fs.client.headObject(fs.key(name))
+	if err == nil {
+		return &fileInfo{
+			name:        path.Base(name),
+			size:        info.size,
+			modTime:     info.modTime,
+			etag:        info.etag,
+			contentType: info.contentType,
+		}, nil
+	}
+	if err = toFSError(err); err != os.ErrNotExist {
+		return nil, err
+	}
+
+	// The name is a directory if any key starts with it.
+	result, err := fs.client.listObjects(fs.dirKey(name), "/", 1, "")
+	if err != nil {
+		return nil, toFSError(err)
+	}
+	if len(result.Contents) == 0 && len(result.CommonPrefixes) == 0 {
+		return nil, os.ErrNotExist
+	}
+	return &fileInfo{name: path.Base(name), isDir: true}, nil
+}
+
+// Stat implements webdav.FileSystem.
+func (fs *s3FS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	info, err := fs.stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// checkParent returns os.ErrNotExist if the parent directory of name
+// doesn't exist.
+func (fs *s3FS) checkParent(name string) error {
+	parent := path.Dir(path.Clean("/" + name))
+	if isRoot(parent) {
+		return nil
+	}
+
+	info, err := fs.stat(parent)
+	if err != nil {
+		return err
+	}
+	if !info.isDir {
+		return os.ErrNotExist
+	}
+	return nil
+}
+
+// Mkdir implements webdav.FileSystem.
+func (fs *s3FS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if _, err := fs.stat(name); err == nil {
+		return os.ErrExist
+	} else if err != os.ErrNotExist {
+		return err
+	}
+
+	if err := fs.checkParent(name); err != nil {
+		return err
+	}
+
+	return toFSError(fs.client.putObject(fs.dirKey(name), nil, 0, "", ""))
+}
+
+// OpenFile implements webdav.FileSystem.
+func (fs *s3FS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		info, err := fs.stat(name)
+		if err != nil {
+			return nil, err
+		}
+		return &s3File{fs: fs, name: name, info: info}, nil
+	}
+
+	if isRoot(name) {
+		return nil, os.ErrPermission
+	}
+	if err := fs.checkParent(name); err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile("", "easegress-webdav-")
+	if err != nil {
+		return nil, err
+	}
+	return &s3File{fs: fs, name: name, tmp: tmp, hash: sha256.New()}, nil
+}
+
+// RemoveAll implements webdav.FileSystem.
+func (fs *s3FS) RemoveAll(ctx context.Context, name string) error {
+	if isRoot(name) {
+		return os.ErrPermission
+	}
+
+	if err := fs.client.deleteObject(fs.key(name)); err != nil {
+		if err = toFSError(err); err != os.ErrNotExist {
+			return err
+		}
+	}
+
+	err := fs.client.walk(fs.dirKey(name), "", func(result *listResult) error {
+		for _, c := range result.Contents {
+			if err := fs.client.deleteObject(c.Key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return toFSError(err)
+}
+
+// Rename implements webdav.FileSystem, objects are copied to the new keys
+// and then deleted, so renaming a directory is not atomic.
+func (fs *s3FS) Rename(ctx context.Context, oldName, newName string) error {
+	if isRoot(oldName) || isRoot(newName) {
+		return os.ErrPermission
+	}
+
+	info, err := fs.stat(oldName)
+	if err != nil {
+		return err
+	}
+	if err = fs.checkParent(newName); err != nil {
+		return err
+	}
+
+	if !info.isDir {
+		oldKey, newKey := fs.key(oldName), fs.key(newName)
+		if err = fs.client.copyObject(oldKey, newKey); err != nil {
+			return toFSError(err)
+		}
+		return toFSError(fs.client.deleteObject(oldKey))
+	}
+
+	oldPrefix, newPrefix := fs.dirKey(oldName), fs.dirKey(newName)
+	var keys []string
+	err = fs.client.walk(oldPrefix, "", func(result *listResult) error {
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		return nil
+	})
+	if err != nil {
+		return toFSError(err)
+	}
+
+	for _, key := range keys {
+		if err = fs.client.copyObject(key, newPrefix+strings.TrimPrefix(key, oldPrefix)); err != nil {
+			return toFSError(err)
+		}
+	}
+	for _, key := range keys {
+		if err = fs.client.deleteObject(key); err != nil {
+			return toFSError(err)
+		}
+	}
+	return nil
+}
+
+// Read implements io.Reader.
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.info == nil || f.info.isDir {
+		return 0, os.ErrInvalid
+	}
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+
+	if f.body == nil {
+		body, err := f.fs.client.getObject(f.fs.key(f.name), f.offset)
+		if err != nil {
+			return 0, toFSError(err)
+		}
+		f.body = body
+	}
+
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+// Seek implements io.Seeker, the object is requested again from the new
+// offset on the next read.
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	if f.info == nil {
+		return 0, os.ErrInvalid
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	default:
+		return 0, os.ErrInvalid
+	}
+	if offset < 0 {
+		return 0, os.ErrInvalid
+	}
+
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// Readdir implements http.File.
+func (f *s3File) Readdir(count int) ([]os.FileInfo, error) {
+	if f.info == nil || !f.info.isDir {
+		return nil, os.ErrInvalid
+	}
+
+	if !f.listed {
+		prefix := f.fs.dirKey(f.name)
+		err := f.fs.client.walk(prefix, "/", func(result *listResult) error {
+			for _, c := range result.Contents {
+				// Skip the marker of the directory itself.
+				if c.Key == prefix {
+					continue
+				}
+				f.children = append(f.children, &fileInfo{
+					name:    strings.TrimPrefix(c.Key, prefix),
+					size:    c.Size,
+					modTime: c.LastModified,
+					etag:    c.ETag,
+				})
+			}
+			for _, p := range result.CommonPrefixes {
+				f.children = append(f.children, &fileInfo{
+					name:  strings.TrimSuffix(strings.TrimPrefix(p.Prefix, prefix), "/"),
+					isDir: true,
+				})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, toFSError(err)
+		}
+		f.listed = true
+	}
+
+	if count <= 0 {
+		children := f.children
+		f.children = nil
+		return children, nil
+	}
+
+	if len(f.children) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.children) {
+		count = len(f.children)
+	}
+	children := f.children[:count]
+	f.children = f.children[count:]
+	return children, nil
+}
+
+// Stat implements http.File.
+func (f *s3File) Stat() (os.FileInfo, error) {
+	if f.tmp != nil {
+		return &fileInfo{name: path.Base(f.name), size: f.size, modTime: time.Now()}, nil
+	}
+	return f.info, nil
+}
+
+// Write implements io.Writer.
+func (f *s3File) Write(p []byte) (int, error) {
+	if f.tmp == nil {
+		return 0, os.ErrInvalid
+	}
+
+	n, err := f.tmp.Write(p)
+	f.hash.Write(p[:n])
+	f.size += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer, the content is uploaded if the file is
+// opened for writing.
+func (f *s3File) Close() error {
+	if f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+
+	if f.tmp == nil {
+		return nil
+	}
+
+	tmp := f.tmp
+	f.tmp = nil
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	payloadHash := hex.EncodeToString(f.hash.Sum(nil))
+	contentType := mime.TypeByExtension(path.Ext(f.name))
+	err := f.fs.client.putObject(f.fs.key(f.name), tmp, f.size, payloadHash, contentType)
+	return toFSError(err)
+}
+
+// Name implements os.FileInfo.
+func (fi *fileInfo) Name() string {
+	return fi.name
+}
+
+// Size implements os.FileInfo.
+func (fi *fileInfo) Size() int64 {
+	return fi.size
+}
+
+// Mode implements os.FileInfo.
+func (fi *fileInfo) Mode() os.FileMode {
+	if fi.isDir {
+		return os.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// ModTime implements os.FileInfo.
+func (fi *fileInfo) ModTime() time.Time {
+	return fi.modTime
+}
+
+// IsDir implements os.FileInfo.
+func (fi *fileInfo) IsDir() bool {
+	return fi.isDir
+}
+
+// Sys implements os.FileInfo.
+func (fi *fileInfo) Sys() interface{} {
+	return nil
+}
+
+// ETag implements webdav.ETager, it returns the ETag of the object.
+func (fi *fileInfo) ETag(ctx context.Context) (string, error) {
+	if fi.etag == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return fi.etag, nil
+}
+
+// ContentType implements webdav.ContentTyper, it avoids reading the objects
+// to detect their content types.
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if fi.contentType != "" {
+		return fi.contentType, nil
+	}
+	if ct := mime.TypeByExtension(path.Ext(fi.name)); ct != "" {
+		return ct, nil
+	}
+	return "application/octet-stream", nil
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/signer"
+)
+
+// sha256Empty is the hex encoded SHA256 of an empty payload.
+const sha256Empty = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+type (
+	// s3Client is a minimal client of the S3 compatible API, it uses the
+	// path style URLs, e.g. https://s3.example.com/bucket/key.
+	s3Client struct {
+		endpoint *url.URL
+		bucket   string
+		region   string
+		signer   *signer.Signer
+		client   *http.Client
+	}
+
+	// objectInfo is the metadata of an object.
+	objectInfo struct {
+		key         string
+		size        int64
+		modTime     time.Time
+		etag        string
+		contentType string
+	}
+
+	// listResult is the result of ListObjectsV2.
+	listResult struct {
+		Contents []struct {
+			Key          string    `xml:"Key"`
+			LastModified time.Time `xml:"LastModified"`
+			ETag         string    `xml:"ETag"`
+			Size         int64     `xml:"Size"`
+		} `xml:"Contents"`
+		CommonPrefixes []struct {
+			Prefix string `xml:"Prefix"`
+		} `xml:"CommonPrefixes"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken"`
+	}
+
+	// s3Error is the error responded by the S3 server.
+	s3Error struct {
+		StatusCode int
+		Code       string `xml:"Code"`
+		Message    string `xml:"Message"`
+	}
+)
+
+func newS3Client(spec *Spec) *s3Client {
+	// NOTE: The endpoint has been validated.
+	endpoint, _ := url.Parse(strings.TrimSuffix(spec.Endpoint, "/"))
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = parseDuration(spec.Timeout, defaultTimeout)
+
+	return &s3Client{
+		endpoint: endpoint,
+		bucket:   spec.Bucket,
+		region:   spec.Region,
+		signer: signer.New().
+			SetLiteral(signer.AWSLiteral()).
+			SingleEscapePath(true).
+			SetCredential(spec.AccessKeyID, spec.SecretAccessKey),
+		client: &http.Client{Transport: transport},
+	}
+}
+
+func (e *s3Error) Error() string {
+	return fmt.Sprintf("s3 responded %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// toFSError converts the errors of S3 to the ones of the os package, which
+// are mapped to the status codes by the WebDAV handler.
+func toFSError(err error) error {
+	if e, ok := err.(*s3Error); ok {
+		switch e.StatusCode {
+		case http.StatusNotFound:
+			return os.ErrNotExist
+		case http.StatusForbidden:
+			return os.ErrPermission
+		}
+	}
+	return err
+}
+
+// do sends the request, payloadHash is the hex encoded SHA256 of the body.
+func (c *s3Client) do(method, key string, query url.Values, header http.Header,
+	body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *c.endpoint
+	u.Path += "/" + c.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if payloadHash == "" {
+		payloadHash = sha256Empty
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	if err = c.signer.NewContext(time.Now(), c.region, "s3").Sign(req); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		defer resp.Body.Close()
+		e := &s3Error{StatusCode: resp.StatusCode}
+		data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		xml.Unmarshal(data, e)
+		return nil, e
+	}
+
+	return resp, nil
+}
+
+func (c *s3Client) headObject(key string) (*objectInfo, error) {
+	resp, err := c.do(http.MethodHead, key, nil, nil, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	info := &objectInfo{
+		key:         key,
+		size:        resp.ContentLength,
+		etag:        resp.Header.Get("ETag"),
+		contentType: resp.Header.Get("Content-Type"),
+	}
+	info.modTime, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return info, nil
+}
+
+// getObject returns the content of the object from the offset.
+func (c *s3Client) getObject(key string, offset int64) (io.ReadCloser, error) {
+	var header http.Header
+	if offset > 0 {
+		header = http.Header{"Range": []string{"bytes=" + strconv.FormatInt(offset, 10) + "-"}}
+	}
+
+	resp, err := c.do(http.MethodGet, key, nil, header, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	return resp.Body, nil
+}
+
+func (c *s3Client) putObject(key string, body io.Reader, size int64, payloadHash, contentType string) error {
+	var header http.Header
+	if contentType != "" {
+		header = http.Header{"Content-Type": []string{contentType}}
+	}
+
+	resp, err := c.do(http.MethodPut, key, nil, header, body, size, payloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) copyObject(srcKey, dstKey string) error {
+	src := (&url.URL{Path: "/" + c.bucket + "/" + srcKey}).EscapedPath()
+	header := http.Header{"X-Amz-Copy-Source": []string{src}}
+
+	resp, err := c.do(http.MethodPut, dstKey, nil, header, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (c *s3Client) deleteObject(key string) error {
+	resp, err := c.do(http.MethodDelete, key, nil, nil, nil, 0, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listObjects lists the objects with the prefix, the objects in the
+// sub-directories are grouped into common prefixes if delimiter is "/".
+func (c *s3Client) listObjects(prefix, delimiter string, maxKeys int, token string) (*listResult, error) {
+	query := url.Values{}
+	query.Set("list-type", "2")
+	query.Set("prefix", prefix)
+	if delimiter != "" {
+		query.Set("delimiter", delimiter)
+	}
+	if maxKeys > 0 {
+		query.Set("max-keys", strconv.Itoa(maxKeys))
+	}
+	if token != "" {
+		query.Set("continuation-token", token)
+	}
+
+	resp, err := c.do(http.MethodGet, "", query, nil, nil, 0, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	result := &listResult{}
+	if err = xml.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("decode list result failed: %v", err)
+	}
+	return result, nil
+}
+
+// walk calls fn with every page of the objects with the prefix.
+func (c *s3Client) walk(prefix, delimiter string, fn func(result *listResult) error) error {
+	token := ""
+	for {
+		result, err := c.listObjects(prefix, delimiter, 0, token)
+		if err != nil {
+			return err
+		}
+		if err = fn(result); err != nil {
+			return err
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/httppipeline"
+)
+
+const (
+	// Kind is the kind of WebDAV.
+	Kind = "WebDAV"
+
+	defaultTimeout = 30 * time.Second
+)
+
+var results = []string{}
+
+func init() {
+	httppipeline.Register(&WebDAV{})
+}
+
+type (
+	// WebDAV serves the objects of an S3 compatible bucket via WebDAV, the
+	// authentication and rate limiting are left to the filters before it.
+	WebDAV struct {
+		filterSpec *httppipeline.FilterSpec
+		spec       *Spec
+		handler    *webdav.Handler
+	}
+
+	// Spec describes the WebDAV.
+	Spec struct {
+		// Prefix is the path prefix of the WebDAV root, it is stripped
+		// from the request paths.
+		Prefix string `yaml:"prefix" jsonschema:"omitempty,pattern=^/"`
+		// Endpoint is the URL of the S3 compatible service, objects are
+		// accessed in the path style.
+		Endpoint        string `yaml:"endpoint" jsonschema:"required,format=url"`
+		Region          string `yaml:"region" jsonschema:"required"`
+		Bucket          string `yaml:"bucket" jsonschema:"required"`
+		AccessKeyID     string `yaml:"accessKeyId" jsonschema:"required"`
+		SecretAccessKey string `yaml:"secretAccessKey" jsonschema:"required"`
+		// KeyPrefix is prepended to the object keys, it must end with "/".
+		KeyPrefix string `yaml:"keyPrefix" jsonschema:"omitempty"`
+		// ReadOnly rejects the methods modifying objects.
+		ReadOnly bool `yaml:"readOnly" jsonschema:"omitempty"`
+		// Timeout is the timeout of waiting for the response headers of
+		// the S3 service.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// responseWriter adapts the response of HTTPContext to
+	// http.ResponseWriter, the body is streamed by a pipe.
+	responseWriter struct {
+		header     http.Header
+		statusCode int
+		pw         *io.PipeWriter
+		once       sync.Once
+		// ready is closed when the header is written.
+		ready chan struct{}
+	}
+)
+
+var readOnlyMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	u, err := url.Parse(spec.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid endpoint %s", spec.Endpoint)
+	}
+	if spec.KeyPrefix != "" && (strings.HasPrefix(spec.KeyPrefix, "/") || !strings.HasSuffix(spec.KeyPrefix, "/")) {
+		return fmt.Errorf("keyPrefix must not start with / but end with /")
+	}
+	return nil
+}
+
+// Kind returns the kind of WebDAV.
+func (w *WebDAV) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns default spec of WebDAV.
+func (w *WebDAV) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Description returns the description of WebDAV.
+func (w *WebDAV) Description() string {
+	return "WebDAV serves the objects of an S3 compatible bucket via WebDAV."
+}
+
+// Results returns the results of WebDAV.
+func (w *WebDAV) Results() []string {
+	return results
+}
+
+// Init initializes WebDAV.
+func (w *WebDAV) Init(filterSpec *httppipeline.FilterSpec) {
+	w.filterSpec, w.spec = filterSpec, filterSpec.FilterSpec().(*Spec)
+	w.reload()
+}
+
+// Inherit inherits previous generation of WebDAV.
+func (w *WebDAV) Inherit(filterSpec *httppipeline.FilterSpec, previousGeneration httppipeline.Filter) {
+	previousGeneration.Close()
+	w.Init(filterSpec)
+}
+
+func (w *WebDAV) reload() {
+	fs := &s3FS{
+		client:    newS3Client(w.spec),
+		keyPrefix: w.spec.KeyPrefix,
+	}
+
+	name := w.filterSpec.Name()
+	w.handler = &webdav.Handler{
+		Prefix:     strings.TrimSuffix(w.spec.Prefix, "/"),
+		FileSystem: fs,
+		// NOTE: Locks are kept in memory, they are not shared among the
+		// members of the cluster.
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(req *http.Request, err error) {
+			if err != nil {
+				logger.Warnf("%s: %s %s failed: %v", name, req.Method, req.URL.Path, err)
+			}
+		},
+	}
+}
+
+// Handle handles the WebDAV request.
+func (w *WebDAV) Handle(ctx context.HTTPContext) string {
+	w.handle(ctx)
+	return ctx.CallNextHandler("")
+}
+
+func (w *WebDAV) handle(ctx context.HTTPContext) {
+	r, resp := ctx.Request(), ctx.Response()
+
+	if w.spec.ReadOnly && !readOnlyMethods[r.Method()] {
+		resp.SetStatusCode(http.StatusForbidden)
+		return
+	}
+
+	req := r.Std().Clone(r.Std().Context())
+	req.Method = r.Method()
+	req.URL.Path, req.URL.RawPath = r.Path(), ""
+	req.Body = ioutil.NopCloser(r.Body())
+
+	pr, pw := io.Pipe()
+	rw := &responseWriter{
+		header: http.Header{},
+		pw:     pw,
+		ready:  make(chan struct{}),
+	}
+
+	go func() {
+		w.handler.ServeHTTP(rw, req)
+		rw.WriteHeader(http.StatusOK)
+		pw.Close()
+	}()
+
+	<-rw.ready
+	resp.SetStatusCode(rw.statusCode)
+	for k, v := range rw.header {
+		for _, vv := range v {
+			resp.Header().Add(k, vv)
+		}
+	}
+	resp.SetBody(pr)
+
+	// NOTE: The body is closed on finishing normally, but it may be
+	// replaced by other filters, so close it again to make sure the
+	// handler goroutine exits.
+	ctx.OnFinish(func() {
+		pr.Close()
+	})
+}
+
+// Header implements http.ResponseWriter.
+func (rw *responseWriter) Header() http.Header {
+	return rw.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (rw *responseWriter) WriteHeader(statusCode int) {
+	rw.once.Do(func() {
+		rw.statusCode = statusCode
+		close(rw.ready)
+	})
+}
+
+// Write implements http.ResponseWriter.
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	rw.WriteHeader(http.StatusOK)
+	return rw.pw.Write(p)
+}
+
+// Status returns status.
+func (w *WebDAV) Status() interface{} {
+	return nil
+}
+
+// Close closes WebDAV.
+func (w *WebDAV) Close() {}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return d
+	}
+	return v
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webdav
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// fakeS3 is an in-memory S3 server supporting the APIs used by s3Client.
+type fakeS3 struct {
+	mutex   sync.Mutex
+	bucket  string
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if r.Header.Get("Authorization") == "" || r.Header.Get("X-Amz-Content-Sha256") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+s.bucket)
+	if key == "" {
+		s.list(w, r.URL.Query())
+		return
+	}
+	key = key[1:]
+
+	switch r.Method {
+	case http.MethodHead, http.MethodGet:
+		data, ok := s.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("<Error><Code>NoSuchKey</Code></Error>"))
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "" {
+			offset, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			data = data[offset:]
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("ETag", `"etag-`+key+`"`)
+		w.Header().Set("Last-Modified", time.Now().UTC().Format(http.TimeFormat))
+		w.Write(data)
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			src, _ = url.PathUnescape(src)
+			s.objects[key] = s.objects[strings.TrimPrefix(src, "/"+s.bucket+"/")]
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[key] = data
+	case http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	prefix, delimiter := query.Get("prefix"), query.Get("delimiter")
+	maxKeys, _ := strconv.Atoi(query.Get("max-keys"))
+
+	keys := []string{}
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := &listResult{}
+	prefixes := map[string]bool{}
+	for _, k := range keys {
+		if !strings.HasPrefix(k, prefix) {
+			continue
+		}
+		if maxKeys > 0 && len(result.Contents)+len(result.CommonPrefixes) >= maxKeys {
+			break
+		}
+
+		rest := k[len(prefix):]
+		if i := strings.Index(rest, delimiter); delimiter != "" && i >= 0 {
+			p := prefix + rest[:i+1]
+			if !prefixes[p] {
+				prefixes[p] = true
+				result.CommonPrefixes = append(result.CommonPrefixes, struct {
+					Prefix string `xml:"Prefix"`
+				}{Prefix: p})
+			}
+			continue
+		}
+
+		result.Contents = append(result.Contents, struct {
+			Key          string    `xml:"Key"`
+			LastModified time.Time `xml:"LastModified"`
+			ETag         string    `xml:"ETag"`
+			Size         int64     `xml:"Size"`
+		}{Key: k, LastModified: time.Now(), Size: int64(len(s.objects[k]))})
+	}
+
+	data, _ := xml.Marshal(struct {
+		XMLName xml.Name `xml:"ListBucketResult"`
+		*listResult
+	}{listResult: result})
+	w.Write(data)
+}
+
+func newTestHandler(t *testing.T) (*fakeS3, *webdav.Handler, func()) {
+	s3 := &fakeS3{bucket: "bucket", objects: map[string][]byte{}}
+	ts := httptest.NewServer(s3)
+
+	spec := &Spec{
+		Endpoint:        ts.URL,
+		Region:          "us-east-1",
+		Bucket:          "bucket",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "SECRET",
+		KeyPrefix:       "home/",
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("spec should be valid: %v", err)
+	}
+
+	h := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: &s3FS{client: newS3Client(spec), keyPrefix: spec.KeyPrefix},
+		LockSystem: webdav.NewMemLS(),
+	}
+	return s3, h, ts.Close
+}
+
+func serve(h http.Handler, method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestWebDAV(t *testing.T) {
+	s3, h, closeFn := newTestHandler(t)
+	defer closeFn()
+
+	if w := serve(h, "MKCOL", "/dav/docs", "", nil); w.Code != http.StatusCreated {
+		t.Fatalf("MKCOL should return 201, but got %d", w.Code)
+	}
+	if _, ok := s3.objects["home/docs/"]; !ok {
+		t.Errorf("directory marker should be created")
+	}
+	if w := serve(h, "MKCOL", "/dav/a/b", "", nil); w.Code != http.StatusConflict {
+		t.Errorf("MKCOL without parent should return 409, but got %d", w.Code)
+	}
+
+	if w := serve(h, http.MethodPut, "/dav/docs/hello.txt", "hello world", nil); w.Code != http.StatusCreated {
+		t.Fatalf("PUT should return 201, but got %d", w.Code)
+	}
+	if string(s3.objects["home/docs/hello.txt"]) != "hello world" {
+		t.Errorf("object should be uploaded")
+	}
+
+	w := serve(h, http.MethodGet, "/dav/docs/hello.txt", "", map[string]string{"Range": "bytes=6-"})
+	if w.Code != http.StatusPartialContent || w.Body.String() != "world" {
+		t.Errorf("unexpected range response: %d %q", w.Code, w.Body.String())
+	}
+
+	w = serve(h, "PROPFIND", "/dav/docs", "", map[string]string{"Depth": "1"})
+	if w.Code != http.StatusMultiStatus || !strings.Contains(w.Body.String(), "/dav/docs/hello.txt") {
+		t.Errorf("unexpected PROPFIND response: %d %s", w.Code, w.Body.String())
+	}
+
+	w = serve(h, "MOVE", "/dav/docs", "", map[string]string{"Destination": "http://example.com/dav/archive"})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("MOVE should return 201, but got %d", w.Code)
+	}
+	if _, ok := s3.objects["home/archive/hello.txt"]; !ok {
+		t.Errorf("objects should be moved: %v", s3.objects)
+	}
+	if _, ok := s3.objects["home/docs/hello.txt"]; ok {
+		t.Errorf("old objects should be deleted: %v", s3.objects)
+	}
+
+	if w := serve(h, http.MethodDelete, "/dav/archive", "", nil); w.Code != http.StatusNoContent {
+		t.Fatalf("DELETE should return 204, but got %d", w.Code)
+	}
+	if len(s3.objects) != 0 {
+		t.Errorf("all objects should be deleted: %v", s3.objects)
+	}
+
+	if w := serve(h, http.MethodGet, "/dav/nothing", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("GET of missing file should return 404, but got %d", w.Code)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	spec := &Spec{Endpoint: "s3.amazonaws.com"}
+	if spec.Validate() == nil {
+		t.Errorf("endpoint without scheme should be invalid")
+	}
+
+	spec = &Spec{Endpoint: "https://s3.amazonaws.com", KeyPrefix: "home"}
+	if spec.Validate() == nil {
+		t.Errorf("keyPrefix without trailing slash should be invalid")
+	}
+}
@@ -0,0 +1,257 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+const (
+	// batchLogBufferSize is the max number of logs waiting to be written,
+	// the logs beyond it are dropped.
+	batchLogBufferSize = 16384
+)
+
+type (
+	// batchLogFile is the log file for traffic logs, e.g. access logs,
+	// it differs from logFile in that writers never wait, so the latency
+	// of the disk can't stall the handling of requests:
+	// 1. Logs are pushed into a lock-free ring buffer, and they're dropped
+	//    if the buffer is full.
+	// 2. A dedicated goroutine drains the buffer, and writes the logs in
+	//    batch by caching them with timeout.
+	// 3. Reopen the file after receiving SIGHUP, for log rotate.
+	batchLogFile struct {
+		filename string
+		file     *os.File
+
+		buffer *ringBuffer
+		// sleeping is 1 if the goroutine draining the buffer is waiting
+		// for logs, the writer pushing a log wakes it up.
+		sleeping      int32
+		wakeUpChan    chan struct{}
+		syncEventChan chan *syncEvent
+
+		dropped         uint64
+		reportedDropped uint64
+
+		cacheCount    uint32
+		maxCacheCount uint32
+		cache         *bytes.Buffer
+	}
+)
+
+// batchLogFiles contains the batch log files by the base names of their
+// files, for reporting the dropped logs.
+var batchLogFiles sync.Map // string -> *batchLogFile
+
+// DroppedLogs returns the number of logs dropped by every batch log file
+// as its buffer is full, by the base name of the file.
+func DroppedLogs() map[string]uint64 {
+	dropped := map[string]uint64{}
+	batchLogFiles.Range(func(key, value interface{}) bool {
+		dropped[key.(string)] = value.(*batchLogFile).Dropped()
+		return true
+	})
+	return dropped
+}
+
+func newBatchLogFile(filename string, maxCacheCount uint32) (*batchLogFile, error) {
+	lf := &batchLogFile{
+		filename:      filename,
+		buffer:        newRingBuffer(batchLogBufferSize),
+		wakeUpChan:    make(chan struct{}, 1),
+		syncEventChan: make(chan *syncEvent),
+		maxCacheCount: maxCacheCount,
+		cache:         bytes.NewBuffer(nil),
+	}
+
+	err := lf.openFile()
+	if err != nil {
+		return nil, err
+	}
+
+	go lf.run()
+	batchLogFiles.Store(filepath.Base(filename), lf)
+
+	return lf, nil
+}
+
+func (lf *batchLogFile) openFile() error {
+	file, err := os.OpenFile(lf.filename, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return err
+	}
+
+	lf.file = file
+	return nil
+}
+
+func (lf *batchLogFile) reopenFile() {
+	err := lf.file.Close()
+	if err != nil {
+		stderrLogger.Errorf("close %s failed: %v", lf.filename, err)
+	}
+
+	err = lf.openFile()
+	if err != nil {
+		stderrLogger.Errorf("open %s failed: %v", lf.filename, err)
+	}
+}
+
+func (lf *batchLogFile) run() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGHUP)
+
+	ticker := time.NewTicker(cacheTimeout)
+	defer ticker.Stop()
+
+	for {
+		lf.drain()
+
+		atomic.StoreInt32(&lf.sleeping, 1)
+		// NOTE: Check again after announcing the sleep, the log pushed
+		// before it doesn't wake us up.
+		if !lf.buffer.empty() {
+			atomic.StoreInt32(&lf.sleeping, 0)
+			continue
+		}
+
+		select {
+		case <-lf.wakeUpChan:
+		case <-signalChan:
+			lf.flushAndReport()
+			lf.reopenFile()
+		case syncEvent := <-lf.syncEventChan:
+			lf.drain()
+			err := lf.flush()
+			if err != nil {
+				syncEvent.resultChan <- err
+			} else {
+				syncEvent.resultChan <- lf.file.Sync()
+			}
+		case <-ticker.C:
+			lf.flushAndReport()
+		}
+
+		atomic.StoreInt32(&lf.sleeping, 0)
+	}
+}
+
+// Write writes log asynchronously, it always returns successful result,
+// the log is dropped if too many logs are waiting to be written.
+func (lf *batchLogFile) Write(p []byte) (int, error) {
+	// NOTE: The memory of p may be corrupted after Write returned
+	// So it's necessary to do copy.
+	buff := make([]byte, len(p))
+	copy(buff, p)
+
+	if !lf.buffer.push(buff) {
+		atomic.AddUint64(&lf.dropped, 1)
+		return len(p), nil
+	}
+
+	if atomic.CompareAndSwapInt32(&lf.sleeping, 1, 0) {
+		select {
+		case lf.wakeUpChan <- struct{}{}:
+		default:
+		}
+	}
+
+	return len(p), nil
+}
+
+// Sync flushes all cache to file with os-level flush.
+func (lf *batchLogFile) Sync() error {
+	event := &syncEvent{
+		resultChan: make(chan error, 1),
+	}
+	lf.syncEventChan <- event
+
+	return <-event.resultChan
+}
+
+// Dropped returns the number of logs dropped as the buffer is full.
+func (lf *batchLogFile) Dropped() uint64 {
+	return atomic.LoadUint64(&lf.dropped)
+}
+
+// drain moves the logs in the buffer into the cache, and flushes the
+// cache every maxCacheCount logs.
+func (lf *batchLogFile) drain() {
+	for {
+		p, ok := lf.buffer.pop()
+		if !ok {
+			return
+		}
+
+		lf.cache.Write(p)
+		lf.cacheCount++
+
+		if lf.cacheCount < lf.maxCacheCount {
+			continue
+		}
+
+		err := lf.flush()
+		if err != nil {
+			stderrLogger.Errorf("%v", err)
+		}
+	}
+}
+
+func (lf *batchLogFile) flushAndReport() {
+	err := lf.flush()
+	if err != nil {
+		stderrLogger.Errorf("%v", err)
+	}
+
+	dropped := lf.Dropped()
+	if dropped != lf.reportedDropped {
+		stderrLogger.Warnf("%d logs of %s dropped as the buffer is full, %d in total",
+			dropped-lf.reportedDropped, lf.filename, dropped)
+		lf.reportedDropped = dropped
+	}
+}
+
+// flush flushes all cache to file without os-level flush.
+func (lf *batchLogFile) flush() error {
+	if lf.cache.Len() == 0 {
+		return nil
+	}
+
+	// NOTE: Discard all buffer regardless of it succeed or failed.
+	defer func() {
+		lf.cache.Reset()
+		lf.cacheCount = 0
+	}()
+
+	n, err := lf.file.Write(lf.cache.Bytes())
+	if err != nil || n != lf.cache.Len() {
+		return fmt.Errorf("write buffer to %s failed: %d, %v", lf.filename, n, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import "testing"
+
+func TestBatchLogFileDropped(t *testing.T) {
+	// NOTE: The file isn't run, so nothing drains the buffer.
+	lf := &batchLogFile{
+		buffer:     newRingBuffer(4),
+		wakeUpChan: make(chan struct{}, 1),
+	}
+	batchLogFiles.Store("test.log", lf)
+	defer batchLogFiles.Delete("test.log")
+
+	for i := 0; i < 6; i++ {
+		if n, err := lf.Write([]byte("log\n")); n != 4 || err != nil {
+			t.Fatalf("write should always succeed, got %d, %v", n, err)
+		}
+	}
+	if dropped := lf.Dropped(); dropped != 2 {
+		t.Errorf("want 2 logs dropped, got %d", dropped)
+	}
+	if dropped := DroppedLogs()["test.log"]; dropped != 2 {
+		t.Errorf("want 2 logs dropped in status, got %d", dropped)
+	}
+
+	if _, ok := lf.buffer.pop(); !ok {
+		t.Fatalf("buffer should not be empty")
+	}
+	lf.Write([]byte("log\n"))
+	if dropped := lf.Dropped(); dropped != 2 {
+		t.Errorf("log should be pushed after the buffer is drained, got %d dropped", dropped)
+	}
+}
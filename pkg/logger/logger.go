@@ -176,13 +176,31 @@ func newPlainLogger(opt *option.Options, filename string, maxCacheCount uint32)
 		LineEnding:    zapcore.DefaultLineEnding,
 	}
 
-	fr, err := newLogFile(filepath.Join(opt.AbsLogDir, filename), maxCacheCount)
+	syncer, err := newPlainLogFile(filepath.Join(opt.AbsLogDir, filename), maxCacheCount)
 	if err != nil {
 		common.Exit(1, err.Error())
 	}
 
-	syncer := zapcore.AddSync(fr)
 	core := zapcore.NewCore(zapcore.NewConsoleEncoder(encoderConfig), syncer, zap.DebugLevel)
 
 	return zap.New(core).Sugar()
 }
+
+// newPlainLogFile creates the batch log file for the cacheable traffic
+// logs, which could be dropped rather than stalling the traffic, and the
+// regular log file otherwise.
+func newPlainLogFile(filename string, maxCacheCount uint32) (zapcore.WriteSyncer, error) {
+	if maxCacheCount == 0 {
+		lf, err := newLogFile(filename, maxCacheCount)
+		if err != nil {
+			return nil, err
+		}
+		return lf, nil
+	}
+
+	lf, err := newBatchLogFile(filename, maxCacheCount)
+	if err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
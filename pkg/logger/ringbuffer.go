@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"sync/atomic"
+)
+
+type (
+	// ringBuffer is a bounded lock-free queue of multiple producers and
+	// a single consumer, the producers never wait for the consumer, they
+	// fail if the buffer is full.
+	//
+	// Every slot has a sequence number: it is the position of the slot
+	// when the slot is free for the producer at the position, and the
+	// position plus one when the slot is filled for the consumer.
+	ringBuffer struct {
+		head uint64 // the position of the next push
+		_    [56]byte
+		tail uint64 // the position of the next pop, only for the consumer
+		_    [56]byte
+
+		mask  uint64
+		slots []ringSlot
+	}
+
+	ringSlot struct {
+		seq  uint64
+		data []byte
+	}
+)
+
+// newRingBuffer creates a ring buffer, the size is rounded up to a power of 2.
+func newRingBuffer(size int) *ringBuffer {
+	n := 1
+	for n < size {
+		n <<= 1
+	}
+
+	rb := &ringBuffer{
+		mask:  uint64(n - 1),
+		slots: make([]ringSlot, n),
+	}
+	for i := range rb.slots {
+		rb.slots[i].seq = uint64(i)
+	}
+
+	return rb
+}
+
+// push adds data to the buffer, it returns false if the buffer is full.
+func (rb *ringBuffer) push(data []byte) bool {
+	for {
+		pos := atomic.LoadUint64(&rb.head)
+		slot := &rb.slots[pos&rb.mask]
+		seq := atomic.LoadUint64(&slot.seq)
+
+		switch diff := int64(seq - pos); {
+		case diff == 0:
+			if atomic.CompareAndSwapUint64(&rb.head, pos, pos+1) {
+				slot.data = data
+				atomic.StoreUint64(&slot.seq, pos+1)
+				return true
+			}
+		case diff < 0:
+			// The slot hasn't been consumed since the last round.
+			return false
+		}
+		// Another producer took the position, try the next one.
+	}
+}
+
+// pop removes the oldest data from the buffer, it returns false if the
+// buffer is empty. It must be called by the only consumer.
+func (rb *ringBuffer) pop() ([]byte, bool) {
+	pos := rb.tail
+	slot := &rb.slots[pos&rb.mask]
+	if atomic.LoadUint64(&slot.seq) != pos+1 {
+		return nil, false
+	}
+
+	data := slot.data
+	slot.data = nil
+	atomic.StoreUint64(&slot.seq, pos+rb.mask+1)
+	rb.tail = pos + 1
+
+	return data, true
+}
+
+// empty returns whether the buffer is empty, it must be called by the
+// only consumer.
+func (rb *ringBuffer) empty() bool {
+	slot := &rb.slots[rb.tail&rb.mask]
+	return atomic.LoadUint64(&slot.seq) != rb.tail+1
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package logger
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestRingBuffer(t *testing.T) {
+	rb := newRingBuffer(3)
+	if len(rb.slots) != 4 {
+		t.Fatalf("size should be rounded up to 4, got %d", len(rb.slots))
+	}
+
+	if !rb.empty() {
+		t.Errorf("new buffer should be empty")
+	}
+	if _, ok := rb.pop(); ok {
+		t.Errorf("pop of empty buffer should fail")
+	}
+
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 4; i++ {
+			if !rb.push([]byte{byte(i)}) {
+				t.Fatalf("round %d: push %d should succeed", round, i)
+			}
+		}
+		if rb.push([]byte{4}) {
+			t.Fatalf("round %d: push into full buffer should fail", round)
+		}
+
+		for i := 0; i < 4; i++ {
+			p, ok := rb.pop()
+			if !ok || p[0] != byte(i) {
+				t.Fatalf("round %d: expect %d, got %v %v", round, i, p, ok)
+			}
+		}
+		if !rb.empty() {
+			t.Errorf("round %d: buffer should be empty", round)
+		}
+	}
+}
+
+func TestRingBufferConcurrentPush(t *testing.T) {
+	const producers, count = 8, 1000
+
+	rb := newRingBuffer(producers * count)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < count; j++ {
+				if !rb.push([]byte(strconv.Itoa(i*count + j))) {
+					t.Errorf("push should succeed")
+				}
+			}
+		}(i)
+	}
+
+	seen := map[string]bool{}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for finished := false; ; {
+		for {
+			p, ok := rb.pop()
+			if !ok {
+				break
+			}
+			seen[string(p)] = true
+		}
+		if finished {
+			break
+		}
+		select {
+		case <-done:
+			finished = true
+		default:
+		}
+	}
+
+	if len(seen) != producers*count {
+		t.Errorf("expect %d logs, got %d", producers*count, len(seen))
+	}
+}
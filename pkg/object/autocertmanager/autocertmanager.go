@@ -58,6 +58,10 @@ type (
 
 		renewBefore time.Duration
 		domains     []Domain
+
+		// renewCert renews the certificate of the domain, it is replaced
+		// in tests.
+		renewCert func(d *Domain) error
 	}
 
 	// Spec describes AutoCertManager.
@@ -81,6 +85,7 @@ type (
 	CertificateStatus struct {
 		Name       string    `yaml:"name"`
 		ExpireTime time.Time `yaml:"expireTime"`
+		RenewError string    `yaml:"renewError,omitempty"`
 	}
 
 	// Status is the status of AutoCertManager.
@@ -170,7 +175,7 @@ func (acm *AutoCertManager) Init(superSpec *supervisor.Spec) {
 		logger.Warnf("an AutoCertManager instance is already exist")
 	}
 
-	acm.reload()
+	acm.reload(nil)
 }
 
 // Inherit inherits previous generation of AutoCertManager.
@@ -179,8 +184,9 @@ func (acm *AutoCertManager) Inherit(superSpec *supervisor.Spec, previousGenerati
 	acm.spec = superSpec.ObjectSpec().(*Spec)
 	acm.super = superSpec.Super()
 
-	acm.reload()
-	previousGeneration.(*AutoCertManager).Close()
+	previous := previousGeneration.(*AutoCertManager)
+	acm.reload(previous)
+	previous.Close()
 }
 
 func (acm *AutoCertManager) findDomain(name string, exactMatch bool) *Domain {
@@ -201,8 +207,9 @@ func (acm *AutoCertManager) findDomain(name string, exactMatch bool) *Domain {
 	return nil
 }
 
-func (acm *AutoCertManager) reload() {
+func (acm *AutoCertManager) reload(previous *AutoCertManager) {
 	acm.stopCtx, acm.cancel = context.WithCancel(context.Background())
+	acm.renewCert = func(d *Domain) error { return d.renewCert(acm) }
 	acm.storage = newStorage(acm.super.Cluster())
 
 	acm.renewBefore, _ = time.ParseDuration(acm.spec.RenewBefore)
@@ -229,20 +236,41 @@ func (acm *AutoCertManager) reload() {
 		}
 		d.certificate.Store(cert)
 	}
+	acm.inheritRenewErrors(previous)
 
 	globalACM.Store(acm)
 	go acm.run()
 	go acm.watchCertificate()
 }
 
+// inheritRenewErrors keeps the renewal errors of the domains of the
+// previous generation, so they are not lost on reloading before the next
+// renewal.
+func (acm *AutoCertManager) inheritRenewErrors(previous *AutoCertManager) {
+	if previous == nil {
+		return
+	}
+
+	for i := range acm.domains {
+		d := &acm.domains[i]
+		if pd := previous.findDomain(d.Name, true); pd != nil {
+			if renewError, _ := pd.renewError.Load().(string); renewError != "" {
+				d.renewError.Store(renewError)
+			}
+		}
+	}
+}
+
 // Status returns the status of AutoCertManager.
 func (acm *AutoCertManager) Status() *supervisor.Status {
 	status := &Status{}
 	for i := range acm.domains {
 		d := &acm.domains[i]
+		renewError, _ := d.renewError.Load().(string)
 		status.Domains = append(status.Domains, CertificateStatus{
 			Name:       d.Name,
 			ExpireTime: d.certExpireTime(),
+			RenewError: renewError,
 		})
 	}
 	return &supervisor.Status{ObjectStatus: status}
@@ -283,10 +311,12 @@ func (acm *AutoCertManager) renew() bool {
 		}
 
 		logger.Infof("begin renew certificate for domain %s", d.Name)
-		if err := d.renewCert(acm); err == nil {
+		if err := acm.renewCert(d); err == nil {
 			logger.Infof("certificate for domain %s has been renewed", d.Name)
+			d.renewError.Store("")
 		} else {
 			logger.Errorf("failed to renew cerficate for domain %s: %v", d.Name, err)
+			d.renewError.Store(err.Error())
 			allSucc = false
 		}
 	}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+// leaderCluster is the cluster whose member is always the leader.
+type leaderCluster struct {
+	cluster.Cluster
+}
+
+func (c *leaderCluster) IsLeader() bool {
+	return true
+}
+
+func newTestACM(names ...string) *AutoCertManager {
+	super := supervisor.NewMock(nil, &leaderCluster{}, sync.Map{}, sync.Map{}, nil, nil, false, nil, nil)
+	acm := &AutoCertManager{
+		super:       super,
+		renewBefore: time.Hour,
+		domains:     make([]Domain, len(names)),
+	}
+	for i, name := range names {
+		acm.domains[i].DomainSpec = &DomainSpec{Name: name}
+		acm.domains[i].nameInPunyCode = name
+	}
+	return acm
+}
+
+func renewErrors(acm *AutoCertManager) map[string]string {
+	errs := map[string]string{}
+	for _, d := range acm.Status().ObjectStatus.(*Status).Domains {
+		errs[d.Name] = d.RenewError
+	}
+	return errs
+}
+
+func TestRenewError(t *testing.T) {
+	acm := newTestACM("a.megaease.com", "b.megaease.com")
+
+	var renewErr error
+	renewed := 0
+	acm.renewCert = func(d *Domain) error {
+		renewed++
+		if d.Name == "a.megaease.com" {
+			return renewErr
+		}
+		return nil
+	}
+
+	renewErr = fmt.Errorf("rate limited")
+	if acm.renew() {
+		t.Fatalf("renewal should fail")
+	}
+	if renewed != 2 {
+		t.Fatalf("both domains should be renewed, but %d", renewed)
+	}
+	errs := renewErrors(acm)
+	if errs["a.megaease.com"] != "rate limited" || errs["b.megaease.com"] != "" {
+		t.Errorf("unexpected renewal errors %v", errs)
+	}
+
+	// The errors are kept on reloading.
+	next := newTestACM("a.megaease.com", "c.megaease.com")
+	next.inheritRenewErrors(acm)
+	errs = renewErrors(next)
+	if errs["a.megaease.com"] != "rate limited" || errs["c.megaease.com"] != "" {
+		t.Errorf("unexpected renewal errors %v", errs)
+	}
+
+	// The error is cleared after a successful renewal.
+	renewErr = nil
+	if !acm.renew() {
+		t.Fatalf("renewal should succeed")
+	}
+	if errs = renewErrors(acm); errs["a.megaease.com"] != "" {
+		t.Errorf("renewal error should be cleared, but is %s", errs["a.megaease.com"])
+	}
+}
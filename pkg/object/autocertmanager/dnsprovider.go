@@ -19,6 +19,7 @@ package autocertmanager
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/libdns/alidns"
 	"github.com/libdns/azure"
@@ -31,6 +32,7 @@ import (
 	"github.com/libdns/libdns"
 	"github.com/libdns/route53"
 	"github.com/libdns/vultr"
+	"golang.org/x/net/idna"
 )
 
 type dnsProvider interface {
@@ -55,10 +57,19 @@ func newDNSProvider(d *DomainSpec) (dnsProvider, error) {
 		return nil, fmt.Errorf("DNS provider name is required for domain: %s", d.Name)
 	}
 
-	if _, ok := d.DNSProvider["zone"]; !ok {
+	zone, ok := d.DNSProvider["zone"]
+	if !ok {
 		return nil, fmt.Errorf("DNS provider field 'zone' is required for domain: %s", d.Name)
 	}
 
+	// the challenge record is created in the zone, so the domain must be
+	// the zone itself or one of its subdomains.
+	zone = toASCII(strings.TrimSuffix(zone, "."))
+	domain := toASCII(strings.TrimPrefix(d.Name, "*."))
+	if domain != zone && !strings.HasSuffix(domain, "."+zone) {
+		return nil, fmt.Errorf("domain %s is not in DNS zone %s", d.Name, zone)
+	}
+
 	creator := dnsProviderCreators[name]
 	if creator == nil {
 		return nil, fmt.Errorf("unknown DNS provider %q for domain: %s", name, d.Name)
@@ -73,6 +84,13 @@ func newDNSProvider(d *DomainSpec) (dnsProvider, error) {
 	return creator.creatorFn(d)
 }
 
+func toASCII(name string) string {
+	if v, err := idna.Lookup.ToASCII(name); err == nil {
+		return v
+	}
+	return strings.ToLower(name)
+}
+
 var dnsProviderCreators = map[string]*dnsProviderCreator{
 	"alidns": {
 		requiredFields: []string{"accessKeyId", "accessKeySecret"},
@@ -27,6 +27,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net"
+	"strings"
 	"sync/atomic"
 	"time"
 
@@ -40,8 +41,11 @@ type Domain struct {
 	*DomainSpec
 	nameInPunyCode string
 	certificate    atomic.Value
-	cleanups       []func() error
-	ctx            context.Context
+	// renewError is the error of the last renewal, it's empty if the
+	// renewal succeeded or the node is not the leader.
+	renewError atomic.Value // string
+	cleanups   []func() error
+	ctx        context.Context
 }
 
 // isWildcard returns whether the domain is for a wildcard one
@@ -146,13 +150,23 @@ func (d *Domain) runTLSALPN01(acm *AutoCertManager, z *acme.Authorization, chal
 	return err
 }
 
+// challengeRecordName returns the fully qualified name of the DNS-01
+// challenge record and its name relative to the zone, e.g. for domain
+// '*.api.example.com' in zone 'example.com', they are
+// '_acme-challenge.api.example.com' and '_acme-challenge.api'. The names
+// are normalized as the validation of the DNS provider does, so the zone
+// could be in upper case or unicode.
+func challengeRecordName(domain, zone string) (string, string) {
+	domain = toASCII(strings.TrimPrefix(domain, "*."))
+	fqdn := "_acme-challenge." + domain
+
+	zone = toASCII(strings.TrimSuffix(zone, "."))
+	relative := strings.TrimSuffix(fqdn, "."+zone)
+	return fqdn, relative
+}
+
 func (d *Domain) waitDNSRecord(value string) error {
-	name := "_acme-challenge."
-	if d.isWildcard() {
-		name += d.nameInPunyCode[2:] // skip '*.'
-	} else {
-		name += d.nameInPunyCode
-	}
+	name, _ := challengeRecordName(d.nameInPunyCode, d.Zone())
 
 	ticker := time.NewTicker(5 * time.Second)
 	defer ticker.Stop()
@@ -189,9 +203,10 @@ func (d *Domain) runDNS01(acm *AutoCertManager, chal *acme.Challenge) error {
 		return err
 	}
 
+	_, name := challengeRecordName(d.nameInPunyCode, d.Zone())
 	record := libdns.Record{
 		Type: "TXT",
-		Name: "_acme-challenge",
+		Name: name,
 	}
 	// ignore the error of DeleteRecords because the record may not exist
 	dp.DeleteRecords(d.ctx, d.Zone(), []libdns.Record{record})
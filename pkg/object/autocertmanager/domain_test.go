@@ -0,0 +1,61 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package autocertmanager
+
+import "testing"
+
+func TestChallengeRecordName(t *testing.T) {
+	cases := []struct {
+		domain, zone, fqdn, relative string
+	}{
+		{"example.com", "example.com", "_acme-challenge.example.com", "_acme-challenge"},
+		{"*.example.com", "example.com", "_acme-challenge.example.com", "_acme-challenge"},
+		{"*.api.example.com", "example.com", "_acme-challenge.api.example.com", "_acme-challenge.api"},
+		{"www.api.example.com", "example.com.", "_acme-challenge.www.api.example.com", "_acme-challenge.www.api"},
+		{"*.api.example.com", "api.example.com", "_acme-challenge.api.example.com", "_acme-challenge"},
+		{"www.example.com", "Example.COM.", "_acme-challenge.www.example.com", "_acme-challenge.www"},
+		{"*.xn--fiqs8s.xn--0zwm56d", "中国.测试", "_acme-challenge.xn--fiqs8s.xn--0zwm56d", "_acme-challenge"},
+		{"www.xn--fiqs8s.xn--0zwm56d", "中国.测试", "_acme-challenge.www.xn--fiqs8s.xn--0zwm56d", "_acme-challenge.www"},
+	}
+
+	for _, c := range cases {
+		fqdn, relative := challengeRecordName(c.domain, c.zone)
+		if fqdn != c.fqdn || relative != c.relative {
+			t.Errorf("%s in %s: want (%s, %s), got (%s, %s)", c.domain, c.zone, c.fqdn, c.relative, fqdn, relative)
+		}
+	}
+}
+
+func TestNewDNSProviderZone(t *testing.T) {
+	d := &DomainSpec{
+		Name: "*.api.example.com",
+		DNSProvider: map[string]string{
+			"name":     "cloudflare",
+			"zone":     "example.com",
+			"apiToken": "token",
+		},
+	}
+	if _, err := newDNSProvider(d); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	d.DNSProvider["zone"] = "example.org"
+	if _, err := newDNSProvider(d); err == nil {
+		t.Errorf("domain out of the zone should be invalid")
+	}
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of DBProxy.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of DBProxy.
+	Kind = "DBProxy"
+)
+
+func init() {
+	supervisor.Register(&DBProxy{})
+}
+
+type (
+	// DBProxy proxies connections of MySQL or PostgreSQL clients to the
+	// database server, it understands the handshake of the wire protocol
+	// to limit connections by users and collect the query statistics.
+	DBProxy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		proxy     *Proxy
+	}
+)
+
+// Category returns the category of DBProxy.
+func (dp *DBProxy) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of DBProxy.
+func (dp *DBProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of DBProxy.
+func (dp *DBProxy) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConnections:   10240,
+		HandshakeTimeout: "10s",
+		ConnectTimeout:   "5s",
+	}
+}
+
+// Init initializes DBProxy.
+func (dp *DBProxy) Init(superSpec *supervisor.Spec) {
+	dp.superSpec, dp.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	dp.reload()
+}
+
+// Inherit inherits previous generation of DBProxy.
+func (dp *DBProxy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	dp.Init(superSpec)
+}
+
+func (dp *DBProxy) reload() {
+	dp.proxy = newProxy(dp.superSpec.Name(), dp.spec)
+	go dp.proxy.run()
+}
+
+// Status returns the status of DBProxy.
+func (dp *DBProxy) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: dp.proxy.Status(),
+	}
+}
+
+// Close closes DBProxy.
+func (dp *DBProxy) Close() {
+	dp.proxy.Close()
+}
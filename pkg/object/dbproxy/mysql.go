@@ -0,0 +1,195 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Reference: https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase.html
+const (
+	mysqlClientProtocol41 = 0x0200
+	mysqlClientSSL        = 0x0800
+
+	mysqlComQuery       = 0x03
+	mysqlComStmtPrepare = 0x16
+	mysqlComStmtExecute = 0x17
+
+	mysqlErrPacket = 0xff
+
+	mysqlMaxHandshakeSize = 64 * 1024
+
+	// mysqlErrTooManyConnections is ER_CON_COUNT_ERROR.
+	mysqlErrTooManyConnections = 1040
+)
+
+// mysqlProtocol is the wire protocol of MySQL.
+type mysqlProtocol struct{}
+
+// readMySQLPacket reads a packet of the connection phase, it returns the
+// header and the payload.
+func readMySQLPacket(r io.Reader) ([]byte, []byte, error) {
+	header, err := readFull(r, 4)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	size := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	if size == 0 || size > mysqlMaxHandshakeSize {
+		return nil, nil, fmt.Errorf("invalid size of handshake packet: %d", size)
+	}
+
+	payload, err := readFull(r, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return header, payload, nil
+}
+
+// handshake relays the greeting of the server and reads the handshake
+// response of the client. The SSL capability of the server is hidden
+// from the client, so the user in the response could be read.
+func (mp *mysqlProtocol) handshake(s *session) (string, error) {
+	// NOTE: The server greets first, so it's connected at the beginning.
+	if err := s.dial(); err != nil {
+		return "", err
+	}
+
+	s.server.SetReadDeadline(time.Now().Add(s.p.handshakeTimeout))
+	header, greeting, err := readMySQLPacket(s.server)
+	if err != nil {
+		return "", fmt.Errorf("read greeting of server failed: %v", err)
+	}
+	s.server.SetReadDeadline(time.Time{})
+
+	if greeting[0] == mysqlErrPacket {
+		s.client.Write(append(header, greeting...))
+		return "", fmt.Errorf("server refused the connection")
+	}
+	if greeting[0] != 10 {
+		return "", fmt.Errorf("unsupported protocol version %d", greeting[0])
+	}
+
+	// The lower 2 bytes of the capabilities follow the server version, the
+	// connection id, the first part of the auth plugin data and a filler.
+	end := bytes.IndexByte(greeting[1:], 0)
+	pos := 1 + end + 1 + 4 + 8 + 1
+	if end < 0 || pos+2 > len(greeting) {
+		return "", fmt.Errorf("invalid greeting of server")
+	}
+	capabilities := binary.LittleEndian.Uint16(greeting[pos:])
+	binary.LittleEndian.PutUint16(greeting[pos:], capabilities&^mysqlClientSSL)
+
+	if _, err := s.client.Write(append(header, greeting...)); err != nil {
+		return "", err
+	}
+
+	header, response, err := readMySQLPacket(s.client)
+	if err != nil {
+		return "", fmt.Errorf("read handshake response failed: %v", err)
+	}
+
+	user, err := parseMySQLUser(response)
+	if err != nil {
+		return "", err
+	}
+
+	s.handshake = append(header, response...)
+	s.seq = header[3]
+	return user, nil
+}
+
+// parseMySQLUser parses the user of the handshake response.
+func parseMySQLUser(response []byte) (string, error) {
+	if len(response) < 2 {
+		return "", fmt.Errorf("invalid handshake response")
+	}
+
+	// HandshakeResponse41 has 4 bytes of capabilities, 4 bytes of max
+	// packet size, 1 byte of charset and 23 bytes of filler before the
+	// user, HandshakeResponse320 has 2 bytes of capabilities and 3 bytes
+	// of max packet size.
+	pos := 5
+	if binary.LittleEndian.Uint16(response)&mysqlClientProtocol41 != 0 {
+		pos = 32
+	}
+	if pos >= len(response) {
+		return "", fmt.Errorf("invalid handshake response")
+	}
+
+	end := bytes.IndexByte(response[pos:], 0)
+	if end <= 0 {
+		return "", fmt.Errorf("user is absent in handshake response")
+	}
+	return string(response[pos : pos+end]), nil
+}
+
+// reject sends an ERR packet to the client.
+func (mp *mysqlProtocol) reject(s *session, message string) {
+	payload := []byte{mysqlErrPacket, 0, 0}
+	binary.LittleEndian.PutUint16(payload[1:], mysqlErrTooManyConnections)
+	payload = append(payload, "#08004"...)
+	payload = append(payload, message...)
+
+	header := []byte{byte(len(payload)), byte(len(payload) >> 8), byte(len(payload) >> 16), s.seq + 1}
+	s.client.Write(append(header, payload...))
+}
+
+func (mp *mysqlProtocol) resume(s *session) error {
+	_, err := s.server.Write(s.handshake)
+	return err
+}
+
+// scanners tracks queries from the commands of queries and prepared
+// statements to the first packet of the response, which is an ERR packet
+// if the query failed.
+func (mp *mysqlProtocol) scanners(tracker *queryTracker) (*frameScanner, *frameScanner) {
+	bodySize := func(header []byte) int {
+		return int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	}
+
+	client := &frameScanner{
+		headerSize: 4,
+		bodySize:   bodySize,
+		onFrame: func(header []byte, first byte) {
+			// NOTE: Commands start with sequence number zero.
+			if header[3] != 0 {
+				return
+			}
+			switch first {
+			case mysqlComQuery, mysqlComStmtPrepare, mysqlComStmtExecute:
+				tracker.begin()
+			}
+		},
+	}
+
+	server := &frameScanner{
+		headerSize: 4,
+		bodySize:   bodySize,
+		onFrame: func(header []byte, first byte) {
+			tracker.end(first == mysqlErrPacket)
+		},
+	}
+
+	return client, server
+}
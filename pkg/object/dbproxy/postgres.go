@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// Reference: https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	pgSSLRequest    = 80877103
+	pgGSSENCRequest = 80877104
+	pgCancelRequest = 80877102
+
+	pgMaxStartupSize = 10000
+
+	// pgTooManyConnections is the SQLSTATE of too_many_connections.
+	pgTooManyConnections = "53300"
+)
+
+// postgresProtocol is the wire protocol of PostgreSQL.
+type postgresProtocol struct{}
+
+// handshake reads the startup message of the client. The SSL and GSSAPI
+// encryption are refused, so the user in the startup message could be
+// read.
+func (pp *postgresProtocol) handshake(s *session) (string, error) {
+	for {
+		header, err := readFull(s.client, 4)
+		if err != nil {
+			return "", err
+		}
+		size := int(binary.BigEndian.Uint32(header))
+		if size < 8 || size > pgMaxStartupSize {
+			return "", fmt.Errorf("invalid size of startup message: %d", size)
+		}
+
+		body, err := readFull(s.client, size-4)
+		if err != nil {
+			return "", err
+		}
+
+		code := binary.BigEndian.Uint32(body)
+		switch code {
+		case pgSSLRequest, pgGSSENCRequest:
+			if _, err := s.client.Write([]byte{'N'}); err != nil {
+				return "", err
+			}
+			continue
+		case pgCancelRequest:
+			// NOTE: Cancel requests bypass the pool, so queries could be
+			// cancelled even if the pool is exhausted.
+			conn, err := net.DialTimeout("tcp", s.p.spec.Server, s.p.connectTimeout)
+			if err == nil {
+				conn.Write(append(header, body...))
+				conn.Close()
+			}
+			return "", errCancelRequest
+		}
+
+		if major := code >> 16; major != 3 {
+			return "", fmt.Errorf("unsupported protocol version %d.%d", major, code&0xffff)
+		}
+
+		params := parsePgParams(body[4:])
+		if params["user"] == "" {
+			return "", fmt.Errorf("user is absent in startup message")
+		}
+
+		s.handshake = append(header, body...)
+		return params["user"], nil
+	}
+}
+
+// parsePgParams parses the parameters of the startup message, which are
+// pairs of null-terminated names and values.
+func parsePgParams(data []byte) map[string]string {
+	params := map[string]string{}
+	fields := bytes.Split(data, []byte{0})
+	for i := 0; i+1 < len(fields); i += 2 {
+		if len(fields[i]) == 0 {
+			break
+		}
+		params[string(fields[i])] = string(fields[i+1])
+	}
+	return params
+}
+
+// reject sends an ErrorResponse to the client.
+func (pp *postgresProtocol) reject(s *session, message string) {
+	var body bytes.Buffer
+	for _, field := range []struct {
+		code  byte
+		value string
+	}{
+		{'S', "FATAL"},
+		{'V', "FATAL"},
+		{'C', pgTooManyConnections},
+		{'M', message},
+	} {
+		body.WriteByte(field.code)
+		body.WriteString(field.value)
+		body.WriteByte(0)
+	}
+	body.WriteByte(0)
+
+	msg := make([]byte, 5, 5+body.Len())
+	msg[0] = 'E'
+	binary.BigEndian.PutUint32(msg[1:], uint32(4+body.Len()))
+	s.client.Write(append(msg, body.Bytes()...))
+}
+
+func (pp *postgresProtocol) resume(s *session) error {
+	if err := s.dial(); err != nil {
+		pp.reject(s, fmt.Sprintf("connect to server failed: %v", err))
+		return err
+	}
+	_, err := s.server.Write(s.handshake)
+	return err
+}
+
+// scanners tracks queries from the simple query or the first message of
+// the extended query to the ReadyForQuery of the server.
+func (pp *postgresProtocol) scanners(tracker *queryTracker) (*frameScanner, *frameScanner) {
+	bodySize := func(header []byte) int {
+		return int(binary.BigEndian.Uint32(header[1:])) - 4
+	}
+
+	client := &frameScanner{
+		headerSize: 5,
+		bodySize:   bodySize,
+		onFrame: func(header []byte, first byte) {
+			switch header[0] {
+			case 'Q', 'P', 'B', 'E', 'F':
+				tracker.begin()
+			}
+		},
+	}
+
+	server := &frameScanner{
+		headerSize: 5,
+		bodySize:   bodySize,
+		onFrame: func(header []byte, first byte) {
+			switch header[0] {
+			case 'E':
+				tracker.fail()
+			case 'Z':
+				tracker.end(false)
+			}
+		},
+	}
+
+	return client, server
+}
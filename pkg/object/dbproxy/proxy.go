@@ -0,0 +1,372 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/limitlistener"
+)
+
+const (
+	stateNil     stateType = "nil"
+	stateRunning stateType = "running"
+	stateFailed  stateType = "failed"
+	stateClosed  stateType = "closed"
+
+	checkFailedTimeout = 10 * time.Second
+)
+
+// errCancelRequest is returned by the handshake of the cancel requests,
+// which are forwarded to the server without sessions.
+var errCancelRequest = errors.New("cancel request")
+
+type (
+	stateType string
+
+	// wireProtocol is the wire protocol of a database.
+	wireProtocol interface {
+		// handshake reads the handshake of the client until the user is
+		// known, the server is connected by s.dial if it's required.
+		handshake(s *session) (user string, err error)
+		// reject rejects the client after the handshake.
+		reject(s *session, message string)
+		// resume forwards the rest of the handshake to the server, the
+		// server is connected after it succeeds.
+		resume(s *session) error
+		// scanners returns the scanners of the streams from the client
+		// and the server, which track the queries by the tracker.
+		scanners(tracker *queryTracker) (client, server *frameScanner)
+	}
+
+	// session is a connection of a client and its connection to the
+	// server.
+	session struct {
+		p      *Proxy
+		client net.Conn
+		server net.Conn
+
+		// handshake is the handshake of the client read by the protocol,
+		// it's forwarded to the server on resuming.
+		handshake []byte
+		// seq is the sequence number of the last packet of the client.
+		seq byte
+	}
+
+	// Proxy accepts the connections of database clients, and passes them
+	// through to the database server after the handshake is inspected.
+	Proxy struct {
+		name string
+		spec *Spec
+
+		protocol         wireProtocol
+		ipFilter         *ipfilter.IPFilter
+		handshakeTimeout time.Duration
+		connectTimeout   time.Duration
+		waitTimeout      time.Duration
+		// slots limits the connections to the server, it's nil if the
+		// pool is not configured.
+		slots chan struct{}
+		users *userStats
+
+		mutex    sync.Mutex
+		state    stateType
+		err      error
+		listener *limitlistener.LimitListener
+
+		activeConns       int64
+		serverConns       int64
+		totalConns        uint64
+		rejectedConns     uint64
+		handshakeFailures uint64
+		dialFailures      uint64
+		poolTimeouts      uint64
+
+		// done is the channel for shutdowning this proxy.
+		done chan struct{}
+	}
+
+	// Status is the status of DBProxy.
+	Status struct {
+		State stateType `yaml:"state"`
+		Error string    `yaml:"error,omitempty"`
+
+		ActiveConnections   int64  `yaml:"activeConnections"`
+		ServerConnections   int64  `yaml:"serverConnections"`
+		TotalConnections    uint64 `yaml:"totalConnections"`
+		RejectedConnections uint64 `yaml:"rejectedConnections"`
+		HandshakeFailures   uint64 `yaml:"handshakeFailures"`
+		DialFailures        uint64 `yaml:"dialFailures"`
+		PoolTimeouts        uint64 `yaml:"poolTimeouts"`
+
+		Users map[string]*UserStatus `yaml:"users,omitempty"`
+	}
+)
+
+func newProxy(name string, spec *Spec) *Proxy {
+	p := &Proxy{
+		name:             name,
+		spec:             spec,
+		handshakeTimeout: parseDuration(spec.HandshakeTimeout, defaultHandshakeTimeout),
+		connectTimeout:   parseDuration(spec.ConnectTimeout, defaultConnectTimeout),
+		users:            newUserStats(),
+		state:            stateNil,
+		done:             make(chan struct{}),
+	}
+
+	if spec.Protocol == ProtocolMySQL {
+		p.protocol = &mysqlProtocol{}
+	} else {
+		p.protocol = &postgresProtocol{}
+	}
+
+	if spec.IPFilter != nil {
+		p.ipFilter = ipfilter.New(spec.IPFilter)
+	}
+
+	if spec.Pool != nil {
+		p.slots = make(chan struct{}, spec.Pool.MaxConnections)
+		p.waitTimeout = parseDuration(spec.Pool.WaitTimeout, defaultWaitTimeout)
+	}
+
+	return p
+}
+
+func (p *Proxy) run() {
+	for {
+		if p.listen() {
+			return
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-time.After(checkFailedTimeout):
+		}
+	}
+}
+
+// listen starts listening and serving, it returns false if it failed to
+// listen and should retry.
+func (p *Proxy) listen() bool {
+	p.mutex.Lock()
+	select {
+	case <-p.done:
+		p.mutex.Unlock()
+		return true
+	default:
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", p.spec.Port))
+	if err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", p.name, p.spec.Port, err)
+		p.state, p.err = stateFailed, err
+		p.mutex.Unlock()
+		return false
+	}
+
+	p.listener = limitlistener.NewLimitListener(l, p.spec.MaxConnections)
+	p.state, p.err = stateRunning, nil
+	listener := p.listener
+	p.mutex.Unlock()
+
+	p.serve(listener)
+	return true
+}
+
+func (p *Proxy) serve(l net.Listener) {
+	var tempDelay time.Duration
+
+	for {
+		conn, err := l.Accept()
+		if err == nil {
+			tempDelay = 0
+			go p.handleConn(conn)
+			continue
+		}
+
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if max := 1 * time.Second; tempDelay > max {
+				tempDelay = max
+			}
+			time.Sleep(tempDelay)
+			continue
+		}
+
+		logger.Errorf("%s accept failed: %v", p.name, err)
+		p.mutex.Lock()
+		p.state, p.err = stateFailed, err
+		p.mutex.Unlock()
+		return
+	}
+}
+
+func (p *Proxy) handleConn(conn net.Conn) {
+	atomic.AddInt64(&p.activeConns, 1)
+	atomic.AddUint64(&p.totalConns, 1)
+	defer atomic.AddInt64(&p.activeConns, -1)
+
+	clientAddr := conn.RemoteAddr()
+	if p.ipFilter != nil {
+		ip := clientAddr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		if !p.ipFilter.Allow(ip) {
+			atomic.AddUint64(&p.rejectedConns, 1)
+			conn.Close()
+			return
+		}
+	}
+
+	s := &session{p: p, client: conn}
+	defer s.close()
+
+	conn.SetReadDeadline(time.Now().Add(p.handshakeTimeout))
+	user, err := p.protocol.handshake(s)
+	if err == errCancelRequest {
+		return
+	}
+	if err != nil {
+		logger.Debugf("%s handshake with %s failed: %v", p.name, clientAddr, err)
+		atomic.AddUint64(&p.handshakeFailures, 1)
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if !p.users.admit(user, p.spec.maxUserConnections(user)) {
+		logger.Debugf("%s reject %s: too many connections of user %s", p.name, clientAddr, user)
+		atomic.AddUint64(&p.rejectedConns, 1)
+		p.protocol.reject(s, fmt.Sprintf("too many connections of user %s", user))
+		return
+	}
+	defer p.users.release(user)
+
+	if err := p.protocol.resume(s); err != nil {
+		logger.Warnf("%s resume handshake of user %s from %s failed: %v", p.name, user, clientAddr, err)
+		return
+	}
+
+	tracker := &queryTracker{
+		onQuery: func(latency time.Duration, failed bool) {
+			p.users.query(user, latency, failed)
+		},
+	}
+	clientScanner, serverScanner := p.protocol.scanners(tracker)
+	relay(s.client, s.server, clientScanner, serverScanner)
+}
+
+// dial connects to the server, it waits for a free slot of the pool.
+func (s *session) dial() error {
+	p := s.p
+
+	if p.slots != nil {
+		select {
+		case p.slots <- struct{}{}:
+		case <-time.After(p.waitTimeout):
+			atomic.AddUint64(&p.poolTimeouts, 1)
+			return fmt.Errorf("wait for a server connection timeout")
+		case <-p.done:
+			return fmt.Errorf("proxy closed")
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", p.spec.Server, p.connectTimeout)
+	if err != nil {
+		if p.slots != nil {
+			<-p.slots
+		}
+		atomic.AddUint64(&p.dialFailures, 1)
+		return err
+	}
+
+	atomic.AddInt64(&p.serverConns, 1)
+	s.server = conn
+	return nil
+}
+
+// close closes the connections of the session, and releases the slot of
+// the server connection.
+func (s *session) close() {
+	s.client.Close()
+	if s.server == nil {
+		return
+	}
+
+	s.server.Close()
+	s.server = nil
+	atomic.AddInt64(&s.p.serverConns, -1)
+	if s.p.slots != nil {
+		<-s.p.slots
+	}
+}
+
+// Status returns the status of the proxy.
+func (p *Proxy) Status() *Status {
+	p.mutex.Lock()
+	state, err := p.state, p.err
+	p.mutex.Unlock()
+
+	s := &Status{
+		State:               state,
+		ActiveConnections:   atomic.LoadInt64(&p.activeConns),
+		ServerConnections:   atomic.LoadInt64(&p.serverConns),
+		TotalConnections:    atomic.LoadUint64(&p.totalConns),
+		RejectedConnections: atomic.LoadUint64(&p.rejectedConns),
+		HandshakeFailures:   atomic.LoadUint64(&p.handshakeFailures),
+		DialFailures:        atomic.LoadUint64(&p.dialFailures),
+		PoolTimeouts:        atomic.LoadUint64(&p.poolTimeouts),
+		Users:               p.users.status(),
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	return s
+}
+
+// Close closes the listener, established connections are kept until
+// either side closes them.
+func (p *Proxy) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	close(p.done)
+	p.state = stateClosed
+	if p.listener != nil {
+		p.listener.Close()
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func pgMessage(t byte, body []byte) []byte {
+	msg := []byte{t, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(msg[1:], uint32(4+len(body)))
+	return append(msg, body...)
+}
+
+func pgStartup(user string) []byte {
+	body := []byte{0, 3, 0, 0}
+	body = append(body, "user\x00"+user+"\x00database\x00db\x00\x00"...)
+	msg := make([]byte, 4)
+	binary.BigEndian.PutUint32(msg, uint32(4+len(body)))
+	return append(msg, body...)
+}
+
+func readPgMessage(t *testing.T, r io.Reader) byte {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatalf("read message failed: %v", err)
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatalf("read message failed: %v", err)
+	}
+	return header[0]
+}
+
+// startPgServer starts a fake PostgreSQL server, which accepts every
+// user and answers queries with CommandComplete, or ErrorResponse for
+// queries starting with "bad".
+func startPgServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				header := make([]byte, 4)
+				if _, err := io.ReadFull(conn, header); err != nil {
+					return
+				}
+				io.ReadFull(conn, make([]byte, binary.BigEndian.Uint32(header)-4))
+				conn.Write(pgMessage('R', []byte{0, 0, 0, 0}))
+				conn.Write(pgMessage('Z', []byte{'I'}))
+
+				for {
+					header := make([]byte, 5)
+					if _, err := io.ReadFull(conn, header); err != nil {
+						return
+					}
+					body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+					io.ReadFull(conn, body)
+					if header[0] != 'Q' {
+						return
+					}
+					time.Sleep(10 * time.Millisecond)
+					if string(body[:3]) == "bad" {
+						conn.Write(pgMessage('E', []byte("SERROR\x00\x00")))
+					} else {
+						conn.Write(pgMessage('C', []byte("SELECT 1\x00")))
+					}
+					conn.Write(pgMessage('Z', []byte{'I'}))
+				}
+			}()
+		}
+	}()
+
+	return l
+}
+
+func TestPostgresProxy(t *testing.T) {
+	server := startPgServer(t)
+	defer server.Close()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	p := newProxy("test", &Spec{
+		Port:           uint16(port),
+		Protocol:       ProtocolPostgres,
+		Server:         server.Addr().String(),
+		MaxConnections: 10,
+		Pool:           &PoolSpec{MaxConnections: 5},
+		Users:          []*UserSpec{{Name: "alice", MaxConnections: 1}},
+	})
+	go p.run()
+	defer p.Close()
+
+	var conn net.Conn
+	for i := 0; i < 50; i++ {
+		if conn, err = net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("dial proxy failed: %v", err)
+	}
+	defer conn.Close()
+
+	// SSL is refused.
+	sslRequest := []byte{0, 0, 0, 8, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(sslRequest[4:], pgSSLRequest)
+	conn.Write(sslRequest)
+	answer := make([]byte, 1)
+	if _, err := io.ReadFull(conn, answer); err != nil || answer[0] != 'N' {
+		t.Fatalf("want N for ssl request, got %q, %v", answer, err)
+	}
+
+	conn.Write(pgStartup("alice"))
+	if m := readPgMessage(t, conn); m != 'R' {
+		t.Fatalf("want authentication, got %c", m)
+	}
+	if m := readPgMessage(t, conn); m != 'Z' {
+		t.Fatalf("want ready for query, got %c", m)
+	}
+
+	for _, query := range []string{"select 1\x00", "bad query\x00"} {
+		conn.Write(pgMessage('Q', []byte(query)))
+		readPgMessage(t, conn)
+		if m := readPgMessage(t, conn); m != 'Z' {
+			t.Fatalf("want ready for query, got %c", m)
+		}
+	}
+
+	// The second connection of alice is rejected.
+	conn2, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("dial proxy failed: %v", err)
+	}
+	defer conn2.Close()
+	conn2.Write(pgStartup("alice"))
+	if m := readPgMessage(t, conn2); m != 'E' {
+		t.Fatalf("want error response, got %c", m)
+	}
+
+	status := p.Status()
+	alice := status.Users["alice"]
+	if alice == nil {
+		t.Fatalf("status of alice is absent")
+	}
+	if alice.ActiveConnections != 1 || alice.RejectedConnections != 1 {
+		t.Errorf("unexpected connections of alice: %+v", alice)
+	}
+	if alice.Queries != 2 || alice.QueryErrors != 1 {
+		t.Errorf("want 2 queries and 1 error, got %d and %d", alice.Queries, alice.QueryErrors)
+	}
+	if alice.Latency == nil || alice.Latency.Max < 10 {
+		t.Errorf("unexpected latency: %+v", alice.Latency)
+	}
+	if status.ServerConnections != 1 {
+		t.Errorf("want 1 server connection, got %d", status.ServerConnections)
+	}
+}
+
+func TestFrameScanner(t *testing.T) {
+	var frames []byte
+	s := &frameScanner{
+		headerSize: 5,
+		bodySize: func(header []byte) int {
+			return int(binary.BigEndian.Uint32(header[1:])) - 4
+		},
+		onFrame: func(header []byte, first byte) {
+			frames = append(frames, header[0], first)
+		},
+	}
+
+	data := append(pgMessage('Q', []byte("abc")), pgMessage('S', nil)...)
+	data = append(data, pgMessage('X', []byte("z"))...)
+	// Feed the stream byte by byte.
+	for i := range data {
+		s.scan(data[i : i+1])
+	}
+
+	if string(frames) != "QaS\x00Xz" {
+		t.Errorf("unexpected frames: %q", frames)
+	}
+}
+
+func TestParseMySQLUser(t *testing.T) {
+	response := make([]byte, 32)
+	binary.LittleEndian.PutUint16(response, mysqlClientProtocol41)
+	response = append(response, "bob\x00"...)
+	if user, err := parseMySQLUser(response); err != nil || user != "bob" {
+		t.Errorf("want bob, got %s, %v", user, err)
+	}
+
+	response = []byte{0, 0, 0, 0, 0}
+	response = append(response, "bob\x00"...)
+	if user, err := parseMySQLUser(response); err != nil || user != "bob" {
+		t.Errorf("want bob, got %s, %v", user, err)
+	}
+
+	if _, err := parseMySQLUser([]byte{0, 0, 0}); err == nil {
+		t.Errorf("expect an error")
+	}
+}
+
+func TestUserStats(t *testing.T) {
+	us := newUserStats()
+	if !us.admit("bob", 1) || us.admit("bob", 1) {
+		t.Fatalf("the second connection of bob should be rejected")
+	}
+	us.release("bob")
+	if !us.admit("bob", 1) {
+		t.Fatalf("bob should be admitted after release")
+	}
+	if !us.admit("alice", 0) || !us.admit("alice", 0) {
+		t.Fatalf("alice is unlimited")
+	}
+
+	status := us.status()
+	if status["bob"].TotalConnections != 2 || status["bob"].RejectedConnections != 1 {
+		t.Errorf("unexpected status of bob: %+v", status["bob"])
+	}
+}
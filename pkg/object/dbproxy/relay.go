@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+type (
+	// frameScanner splits a stream of the wire protocol into frames
+	// without buffering them, onFrame is called with the header and the
+	// first byte of the body (zero if the body is empty) of every frame.
+	frameScanner struct {
+		headerSize int
+		bodySize   func(header []byte) int
+		onFrame    func(header []byte, first byte)
+
+		header    []byte
+		remaining int
+	}
+
+	// queryTracker tracks the queries of a session, a query begins with
+	// a request of the client and ends with the response of the server.
+	queryTracker struct {
+		mutex   sync.Mutex
+		pending bool
+		failed  bool
+		start   time.Time
+
+		onQuery func(latency time.Duration, failed bool)
+	}
+)
+
+func (s *frameScanner) scan(p []byte) {
+	for len(p) > 0 {
+		if s.remaining > 0 {
+			n := len(p)
+			if n > s.remaining {
+				n = s.remaining
+			}
+			s.remaining -= n
+			p = p[n:]
+			continue
+		}
+
+		if len(s.header) < s.headerSize {
+			n := s.headerSize - len(s.header)
+			if n > len(p) {
+				n = len(p)
+			}
+			s.header = append(s.header, p[:n]...)
+			p = p[n:]
+			if len(s.header) < s.headerSize {
+				return
+			}
+		}
+
+		size := s.bodySize(s.header)
+		if size < 0 {
+			size = 0
+		}
+
+		var first byte
+		if size > 0 {
+			if len(p) == 0 {
+				// NOTE: Wait for the first byte of the body.
+				return
+			}
+			first = p[0]
+		}
+
+		s.onFrame(s.header, first)
+		s.header = s.header[:0]
+		s.remaining = size
+	}
+}
+
+// begin begins a query, it does nothing if a query is pending.
+func (t *queryTracker) begin() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if !t.pending {
+		t.pending, t.failed, t.start = true, false, time.Now()
+	}
+}
+
+// fail marks the pending query as failed.
+func (t *queryTracker) fail() {
+	t.mutex.Lock()
+	t.failed = t.failed || t.pending
+	t.mutex.Unlock()
+}
+
+// end ends the pending query.
+func (t *queryTracker) end(failed bool) {
+	t.mutex.Lock()
+	if !t.pending {
+		t.mutex.Unlock()
+		return
+	}
+	latency, failed := time.Since(t.start), failed || t.failed
+	t.pending = false
+	t.mutex.Unlock()
+
+	t.onQuery(latency, failed)
+}
+
+// relay copies data between the client and the server with scanning
+// until both directions are finished, the write side is closed as soon
+// as its source reaches EOF.
+func relay(client, server net.Conn, clientScanner, serverScanner *frameScanner) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	copyConn := func(dst, src net.Conn, scanner *frameScanner) {
+		defer wg.Done()
+
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				scanner.scan(buf[:n])
+				if _, werr := dst.Write(buf[:n]); werr != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		if cw, ok := dst.(interface{ CloseWrite() error }); !ok || cw.CloseWrite() != nil {
+			// NOTE: Without half close, the other direction can't be
+			// notified, so close both connections.
+			dst.Close()
+			src.Close()
+		}
+	}
+
+	go copyConn(server, client, clientScanner)
+	go copyConn(client, server, serverScanner)
+	wg.Wait()
+
+	client.Close()
+	server.Close()
+}
+
+// readFull reads exactly n bytes from the reader.
+func readFull(r io.Reader, n int) ([]byte, error) {
+	buf := make([]byte, n)
+	_, err := io.ReadFull(r, buf)
+	return buf, err
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+)
+
+const (
+	// ProtocolMySQL is the protocol of MySQL.
+	ProtocolMySQL = "mysql"
+	// ProtocolPostgres is the protocol of PostgreSQL.
+	ProtocolPostgres = "postgres"
+
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultConnectTimeout   = 5 * time.Second
+	defaultWaitTimeout      = 5 * time.Second
+)
+
+type (
+	// Spec describes the DBProxy.
+	Spec struct {
+		Port             uint16         `yaml:"port" jsonschema:"required,minimum=1"`
+		Protocol         string         `yaml:"protocol" jsonschema:"required,enum=mysql,enum=postgres"`
+		Server           string         `yaml:"server" jsonschema:"required"`
+		MaxConnections   uint32         `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
+		HandshakeTimeout string         `yaml:"handshakeTimeout" jsonschema:"omitempty,format=duration"`
+		ConnectTimeout   string         `yaml:"connectTimeout" jsonschema:"omitempty,format=duration"`
+		IPFilter         *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
+		// Pool limits the connections to the database server.
+		Pool *PoolSpec `yaml:"pool,omitempty" jsonschema:"omitempty"`
+		// MaxConnectionsPerUser limits the connections of every user,
+		// Users overrides it for specific users.
+		MaxConnectionsPerUser uint32      `yaml:"maxConnectionsPerUser" jsonschema:"omitempty"`
+		Users                 []*UserSpec `yaml:"users" jsonschema:"omitempty"`
+	}
+
+	// PoolSpec describes the pool of connections to the database server.
+	PoolSpec struct {
+		// MaxConnections is the max number of connections to the server,
+		// clients beyond it wait for a connection to be released.
+		MaxConnections uint32 `yaml:"maxConnections" jsonschema:"required,minimum=1"`
+		// WaitTimeout is the max time to wait for a connection.
+		WaitTimeout string `yaml:"waitTimeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// UserSpec describes the limits of a user.
+	UserSpec struct {
+		Name string `yaml:"name" jsonschema:"required"`
+		// MaxConnections is the max connections of the user, zero means
+		// unlimited.
+		MaxConnections uint32 `yaml:"maxConnections" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if _, _, err := net.SplitHostPort(spec.Server); err != nil {
+		return fmt.Errorf("invalid server address %s: %v", spec.Server, err)
+	}
+
+	names := map[string]struct{}{}
+	for _, user := range spec.Users {
+		if _, exists := names[user.Name]; exists {
+			return fmt.Errorf("user %s is duplicated", user.Name)
+		}
+		names[user.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// maxUserConnections returns the max connections of the user, zero
+// means unlimited.
+func (spec *Spec) maxUserConnections(user string) uint32 {
+	for _, u := range spec.Users {
+		if u.Name == user {
+			return u.MaxConnections
+		}
+	}
+	return spec.MaxConnectionsPerUser
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return d
+	}
+	return v
+}
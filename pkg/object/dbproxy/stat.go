@@ -0,0 +1,171 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dbproxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/sampler"
+)
+
+const (
+	// maxUserStats is the max number of users having their own
+	// statistics, the rest are merged into otherUsers.
+	maxUserStats = 1000
+	otherUsers   = "~other"
+)
+
+type (
+	// userStats contains the statistics of users.
+	userStats struct {
+		mutex sync.Mutex
+		users map[string]*userStat
+	}
+
+	userStat struct {
+		active      int64
+		total       uint64
+		rejected    uint64
+		queries     uint64
+		queryErrors uint64
+
+		latencyTotal time.Duration
+		latencyMax   time.Duration
+		sampler      *sampler.DurationSampler
+	}
+
+	// UserStatus is the status of a user.
+	UserStatus struct {
+		ActiveConnections   int64  `yaml:"activeConnections"`
+		TotalConnections    uint64 `yaml:"totalConnections"`
+		RejectedConnections uint64 `yaml:"rejectedConnections"`
+		Queries             uint64 `yaml:"queries"`
+		QueryErrors         uint64 `yaml:"queryErrors"`
+
+		// Latency contains the statistics of query latencies, in
+		// milliseconds.
+		Latency *LatencyStatus `yaml:"latency,omitempty"`
+	}
+
+	// LatencyStatus contains the statistics of latencies, in milliseconds.
+	LatencyStatus struct {
+		Mean float64 `yaml:"mean"`
+		Max  float64 `yaml:"max"`
+		P50  float64 `yaml:"p50"`
+		P95  float64 `yaml:"p95"`
+		P99  float64 `yaml:"p99"`
+	}
+)
+
+func newUserStats() *userStats {
+	return &userStats{users: map[string]*userStat{}}
+}
+
+// admit counts a connection of the user, it returns false if the
+// connections of the user exceed the limit, zero means unlimited.
+func (us *userStats) admit(user string, limit uint32) bool {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	stat := us.get(user)
+	if limit != 0 && stat.active >= int64(limit) {
+		stat.rejected++
+		return false
+	}
+	stat.active++
+	stat.total++
+	return true
+}
+
+// release releases a connection of the user admitted before.
+func (us *userStats) release(user string) {
+	us.mutex.Lock()
+	us.get(user).active--
+	us.mutex.Unlock()
+}
+
+// query records a query of the user.
+func (us *userStats) query(user string, latency time.Duration, failed bool) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	stat := us.get(user)
+	stat.queries++
+	if failed {
+		stat.queryErrors++
+	}
+	stat.latencyTotal += latency
+	if latency > stat.latencyMax {
+		stat.latencyMax = latency
+	}
+	stat.sampler.Update(latency)
+}
+
+// get returns the statistics of the user, it must be called with the
+// mutex held.
+func (us *userStats) get(user string) *userStat {
+	stat := us.users[user]
+	if stat != nil {
+		return stat
+	}
+
+	if len(us.users) >= maxUserStats {
+		user = otherUsers
+		if stat = us.users[user]; stat != nil {
+			return stat
+		}
+	}
+
+	stat = &userStat{sampler: sampler.NewDurationSampler()}
+	us.users[user] = stat
+	return stat
+}
+
+func (us *userStats) status() map[string]*UserStatus {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if len(us.users) == 0 {
+		return nil
+	}
+
+	status := make(map[string]*UserStatus, len(us.users))
+	for user, stat := range us.users {
+		s := &UserStatus{
+			ActiveConnections:   stat.active,
+			TotalConnections:    stat.total,
+			RejectedConnections: stat.rejected,
+			Queries:             stat.queries,
+			QueryErrors:         stat.queryErrors,
+		}
+		if stat.queries != 0 {
+			percentiles := stat.sampler.Percentiles()
+			s.Latency = &LatencyStatus{
+				Mean: float64(stat.latencyTotal/time.Duration(stat.queries)) / float64(time.Millisecond),
+				Max:  float64(stat.latencyMax) / float64(time.Millisecond),
+				P50:  percentiles[1],
+				P95:  percentiles[3],
+				P99:  percentiles[5],
+			}
+		}
+		status[user] = s
+	}
+
+	return status
+}
@@ -130,6 +130,9 @@ type (
 
 		ReqSize  uint64 `json:"reqsize"`
 		RespSize uint64 `json:"respsize"`
+
+		ReqSizeHistogram  []uint64 `json:"reqsizehist,omitempty"`
+		RespSizeHistogram []uint64 `json:"respsizehist,omitempty"`
 	}
 
 	// StatusCodeMetrics is the metrics of http status code.
@@ -399,6 +402,15 @@ func (emm *EaseMonitorMetrics) httpServer2Metrics(
 		codeMetrics = append(codeMetrics, codes...)
 	}
 
+	for _, item := range serverStatus.Routes {
+		baseFieldsServerRoute := *baseFields
+		baseFieldsServerRoute.Resource = "SERVER_ROUTE"
+		baseFieldsServerRoute.URL = item.Route
+		req, codes := emm.httpStat2Metrics(&baseFieldsServerRoute, item.Status)
+		reqMetrics = append(reqMetrics, req)
+		codeMetrics = append(codeMetrics, codes...)
+	}
+
 	return
 }
 
@@ -437,6 +449,9 @@ func (emm *EaseMonitorMetrics) httpStat2Metrics(baseFields *GlobalFields, s *htt
 
 		ReqSize:  s.ReqSize,
 		RespSize: s.RespSize,
+
+		ReqSizeHistogram:  s.ReqSizeHistogram,
+		RespSizeHistogram: s.RespSizeHistogram,
 	}
 
 	baseFields.Type = "eg-http-status-code"
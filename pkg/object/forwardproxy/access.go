@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package forwardproxy
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net"
+	"strings"
+)
+
+type (
+	// hostMatcher matches the destinations against the allowed hosts.
+	hostMatcher struct {
+		all        bool
+		nets       []*net.IPNet
+		domains    []string
+		subdomains []string
+	}
+
+	// authenticator authenticates the clients by Proxy-Authorization.
+	authenticator struct {
+		users map[string]string
+	}
+)
+
+func newHostMatcher(hosts []string) *hostMatcher {
+	m := &hostMatcher{}
+
+	for _, host := range hosts {
+		switch {
+		case host == "*":
+			m.all = true
+		case strings.Contains(host, "/"):
+			if _, ipNet, err := net.ParseCIDR(host); err == nil {
+				m.nets = append(m.nets, ipNet)
+			}
+		case net.ParseIP(host) != nil:
+			ip := net.ParseIP(host)
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			m.nets = append(m.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+		case strings.HasPrefix(host, "*."):
+			m.subdomains = append(m.subdomains, strings.ToLower(host[1:]))
+		case strings.HasPrefix(host, "."):
+			m.subdomains = append(m.subdomains, strings.ToLower(host))
+		default:
+			m.domains = append(m.domains, strings.ToLower(host))
+		}
+	}
+
+	return m
+}
+
+// hasIPRules returns whether there are IP or CIDR rules, which are the
+// only rules that resolved domains could match.
+func (m *hostMatcher) hasIPRules() bool {
+	return m.all || len(m.nets) > 0
+}
+
+func (m *hostMatcher) matchIP(ip net.IP) bool {
+	if m.all {
+		return true
+	}
+	for _, ipNet := range m.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *hostMatcher) matchDomain(domain string) bool {
+	if m.all {
+		return true
+	}
+
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, d := range m.domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	for _, suffix := range m.subdomains {
+		if strings.HasSuffix(domain, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+func newAuthenticator(users []*UserSpec) *authenticator {
+	if len(users) == 0 {
+		return nil
+	}
+
+	a := &authenticator{users: map[string]string{}}
+	for _, user := range users {
+		a.users[user.Username] = user.Password
+	}
+	return a
+}
+
+// authenticate returns the username and whether the credentials in the
+// Proxy-Authorization header are valid.
+func (a *authenticator) authenticate(header string) (string, bool) {
+	const prefix = "Basic "
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(header[len(prefix):]))
+	if err != nil {
+		return "", false
+	}
+
+	credentials := string(decoded)
+	i := strings.IndexByte(credentials, ':')
+	if i < 0 {
+		return "", false
+	}
+	username, password := credentials[:i], credentials[i+1:]
+
+	expected, exists := a.users[username]
+	if !exists {
+		return "", false
+	}
+	if subtle.ConstantTimeCompare([]byte(password), []byte(expected)) != 1 {
+		return "", false
+	}
+
+	return username, true
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package forwardproxy
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of ForwardProxy.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of ForwardProxy.
+	Kind = "ForwardProxy"
+)
+
+func init() {
+	supervisor.Register(&ForwardProxy{})
+}
+
+type (
+	// ForwardProxy is the forward proxy of the outbound traffic from the
+	// internal networks, it tunnels the CONNECT requests to the allowed
+	// destinations after the clients are authenticated, and limits the
+	// bandwidth of the tunnels.
+	ForwardProxy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		server    *Server
+	}
+)
+
+// Category returns the category of ForwardProxy.
+func (fp *ForwardProxy) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of ForwardProxy.
+func (fp *ForwardProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of ForwardProxy.
+func (fp *ForwardProxy) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConnections: 10240,
+		AllowedPorts:   []uint16{443},
+		DialTimeout:    "10s",
+		IdleTimeout:    "5m",
+	}
+}
+
+// Init initializes ForwardProxy.
+func (fp *ForwardProxy) Init(superSpec *supervisor.Spec) {
+	fp.superSpec, fp.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	fp.reload()
+}
+
+// Inherit inherits previous generation of ForwardProxy.
+func (fp *ForwardProxy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	fp.Init(superSpec)
+}
+
+func (fp *ForwardProxy) reload() {
+	fp.server = newServer(fp.superSpec.Name(), fp.spec)
+	go fp.server.run()
+}
+
+// Status returns the status of ForwardProxy.
+func (fp *ForwardProxy) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: fp.server.Status(),
+	}
+}
+
+// Close closes ForwardProxy.
+func (fp *ForwardProxy) Close() {
+	fp.server.Close()
+}
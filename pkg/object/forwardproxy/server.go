@@ -0,0 +1,496 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package forwardproxy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/bandwidth"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/limitlistener"
+)
+
+const (
+	stateNil     stateType = "nil"
+	stateRunning stateType = "running"
+	stateFailed  stateType = "failed"
+	stateClosed  stateType = "closed"
+
+	checkFailedTimeout = 10 * time.Second
+
+	copyBufferSize = 32 * 1024
+)
+
+type (
+	stateType string
+
+	// Server accepts CONNECT requests of internal clients, and tunnels
+	// them to the allowed destinations.
+	Server struct {
+		name string
+		spec *Spec
+
+		dialTimeout time.Duration
+		idleTimeout time.Duration
+		ipFilter    *ipfilter.IPFilter
+		hosts       *hostMatcher
+		ports       map[uint16]struct{}
+		auth        *authenticator
+		perTunnel   int64
+		total       *limiterPair
+		users       map[string]*limiterPair
+
+		mutex    sync.Mutex
+		state    stateType
+		err      error
+		listener *limitlistener.LimitListener
+
+		activeConns   int64
+		totalConns    uint64
+		rejectedConns uint64
+		unauthorized  uint64
+		forbidden     uint64
+		failedDials   uint64
+		activeTunnels int64
+		totalTunnels  uint64
+		bytesSent     uint64
+		bytesReceived uint64
+
+		// done is the channel for shutdowning this server.
+		done chan struct{}
+	}
+
+	// Status is the status of ForwardProxy.
+	Status struct {
+		State stateType `yaml:"state"`
+		Error string    `yaml:"error,omitempty"`
+
+		ActiveConnections    int64  `yaml:"activeConnections"`
+		TotalConnections     uint64 `yaml:"totalConnections"`
+		RejectedConnections  uint64 `yaml:"rejectedConnections"`
+		UnauthorizedRequests uint64 `yaml:"unauthorizedRequests"`
+		ForbiddenRequests    uint64 `yaml:"forbiddenRequests"`
+		FailedDials          uint64 `yaml:"failedDials"`
+		ActiveTunnels        int64  `yaml:"activeTunnels"`
+		TotalTunnels         uint64 `yaml:"totalTunnels"`
+		// BytesSent is the bytes sent from the clients to the destinations.
+		BytesSent uint64 `yaml:"bytesSent"`
+		// BytesReceived is the bytes received by the clients from the
+		// destinations.
+		BytesReceived uint64 `yaml:"bytesReceived"`
+	}
+
+	// limiterPair is the limiters of both directions.
+	limiterPair struct {
+		up   *bandwidth.Limiter
+		down *bandwidth.Limiter
+	}
+
+	// tunnel is a CONNECT tunnel between a client and a destination.
+	tunnel struct {
+		server       *Server
+		client       net.Conn
+		clientReader io.Reader
+		upstream     net.Conn
+		limiters     []*limiterPair
+
+		// lastActive is the unix nano time of the last transfer of
+		// either direction.
+		lastActive int64
+	}
+)
+
+func newLimiterPair(bytesPerSecond int64) *limiterPair {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &limiterPair{
+		up:   bandwidth.NewLimiter(bytesPerSecond, 0),
+		down: bandwidth.NewLimiter(bytesPerSecond, 0),
+	}
+}
+
+func newServer(name string, spec *Spec) *Server {
+	s := &Server{
+		name:        name,
+		spec:        spec,
+		dialTimeout: parseDuration(spec.DialTimeout, defaultDialTimeout),
+		idleTimeout: parseDuration(spec.IdleTimeout, defaultIdleTimeout),
+		hosts:       newHostMatcher(spec.AllowedHosts),
+		ports:       map[uint16]struct{}{},
+		auth:        newAuthenticator(spec.Users),
+		state:       stateNil,
+		done:        make(chan struct{}),
+	}
+
+	if spec.IPFilter != nil {
+		s.ipFilter = ipfilter.New(spec.IPFilter)
+	}
+
+	ports := spec.AllowedPorts
+	if len(ports) == 0 {
+		ports = []uint16{443}
+	}
+	for _, port := range ports {
+		s.ports[port] = struct{}{}
+	}
+
+	if spec.Bandwidth != nil {
+		s.perTunnel = spec.Bandwidth.PerTunnel
+		s.total = newLimiterPair(spec.Bandwidth.Total)
+		if spec.Bandwidth.PerUser > 0 {
+			s.users = map[string]*limiterPair{}
+			for _, user := range spec.Users {
+				s.users[user.Username] = newLimiterPair(spec.Bandwidth.PerUser)
+			}
+		}
+	}
+
+	return s
+}
+
+func (s *Server) run() {
+	for {
+		if s.listen() {
+			return
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(checkFailedTimeout):
+		}
+	}
+}
+
+// listen starts listening and serving, it returns false if it failed to
+// listen and should retry.
+func (s *Server) listen() bool {
+	s.mutex.Lock()
+	select {
+	case <-s.done:
+		s.mutex.Unlock()
+		return true
+	default:
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.spec.Port))
+	if err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", s.name, s.spec.Port, err)
+		s.state, s.err = stateFailed, err
+		s.mutex.Unlock()
+		return false
+	}
+
+	s.listener = limitlistener.NewLimitListener(l, s.spec.MaxConnections)
+	s.state, s.err = stateRunning, nil
+	listener := s.listener
+	s.mutex.Unlock()
+
+	s.serve(listener)
+	return true
+}
+
+func (s *Server) serve(l net.Listener) {
+	var tempDelay time.Duration
+
+	for {
+		conn, err := l.Accept()
+		if err == nil {
+			tempDelay = 0
+			go s.handleConn(conn)
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if max := 1 * time.Second; tempDelay > max {
+				tempDelay = max
+			}
+			time.Sleep(tempDelay)
+			continue
+		}
+
+		logger.Errorf("%s accept failed: %v", s.name, err)
+		s.mutex.Lock()
+		s.state, s.err = stateFailed, err
+		s.mutex.Unlock()
+		return
+	}
+}
+
+func reply(conn net.Conn, code int, headers ...string) {
+	resp := fmt.Sprintf("HTTP/1.1 %d %s\r\n", code, http.StatusText(code))
+	for _, h := range headers {
+		resp += h + "\r\n"
+	}
+	if code != http.StatusOK {
+		resp += "Content-Length: 0\r\nConnection: close\r\n"
+	}
+	resp += "\r\n"
+	conn.Write([]byte(resp))
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	atomic.AddInt64(&s.activeConns, 1)
+	atomic.AddUint64(&s.totalConns, 1)
+	defer atomic.AddInt64(&s.activeConns, -1)
+	defer conn.Close()
+
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if s.ipFilter != nil && !s.ipFilter.Allow(ip) {
+		atomic.AddUint64(&s.rejectedConns, 1)
+		return
+	}
+
+	conn.SetDeadline(time.Now().Add(s.idleTimeout))
+	br := bufio.NewReader(conn)
+	req, err := http.ReadRequest(br)
+	if err != nil {
+		reply(conn, http.StatusBadRequest)
+		return
+	}
+
+	if req.Method != http.MethodConnect {
+		reply(conn, http.StatusMethodNotAllowed, "Allow: CONNECT")
+		return
+	}
+
+	username := ""
+	if s.auth != nil {
+		var ok bool
+		username, ok = s.auth.authenticate(req.Header.Get("Proxy-Authorization"))
+		if !ok {
+			atomic.AddUint64(&s.unauthorized, 1)
+			reply(conn, http.StatusProxyAuthRequired, fmt.Sprintf("Proxy-Authenticate: Basic realm=%q", s.name))
+			return
+		}
+	}
+
+	addr, ok := s.resolve(req.Host)
+	if !ok {
+		atomic.AddUint64(&s.forbidden, 1)
+		reply(conn, http.StatusForbidden)
+		return
+	}
+
+	upstream, err := net.DialTimeout("tcp", addr, s.dialTimeout)
+	if err != nil {
+		atomic.AddUint64(&s.failedDials, 1)
+		logger.Warnf("%s dial %s for %s failed: %v", s.name, req.Host, ip, err)
+		reply(conn, http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	conn.SetDeadline(time.Time{})
+	reply(conn, http.StatusOK)
+
+	t := &tunnel{
+		server:       s,
+		client:       conn,
+		clientReader: br,
+		upstream:     upstream,
+	}
+	for _, lp := range []*limiterPair{newLimiterPair(s.perTunnel), s.users[username], s.total} {
+		if lp != nil {
+			t.limiters = append(t.limiters, lp)
+		}
+	}
+
+	atomic.AddInt64(&s.activeTunnels, 1)
+	atomic.AddUint64(&s.totalTunnels, 1)
+	defer atomic.AddInt64(&s.activeTunnels, -1)
+	t.run()
+}
+
+// resolve checks the destination of a CONNECT request against the allowed
+// ports and hosts, and returns the address to dial. The domains allowed
+// only by IP rules are resolved here, and the allowed IP is dialed rather
+// than the domain, so a later resolution can't lead to a different one.
+func (s *Server) resolve(hostport string) (string, bool) {
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return "", false
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return "", false
+	}
+	if _, ok := s.ports[uint16(port)]; !ok {
+		return "", false
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		return hostport, s.hosts.matchIP(ip)
+	}
+	if s.hosts.matchDomain(host) {
+		return hostport, true
+	}
+	if !s.hosts.hasIPRules() {
+		return "", false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.dialTimeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", false
+	}
+	for _, addr := range addrs {
+		if s.hosts.matchIP(addr.IP) {
+			return net.JoinHostPort(addr.IP.String(), portStr), true
+		}
+	}
+	return "", false
+}
+
+func (t *tunnel) run() {
+	atomic.StoreInt64(&t.lastActive, time.Now().UnixNano())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var up, down []*bandwidth.Limiter
+	for _, lp := range t.limiters {
+		up, down = append(up, lp.up), append(down, lp.down)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		t.pipe(ctx, cancel, t.upstream, t.clientReader, t.client, up, &t.server.bytesSent)
+	}()
+	go func() {
+		defer wg.Done()
+		t.pipe(ctx, cancel, t.client, t.upstream, t.upstream, down, &t.server.bytesReceived)
+	}()
+	wg.Wait()
+}
+
+// pipe copies from src to dst until EOF, and then closes the write side
+// of dst. It closes both connections on errors or when the tunnel has
+// been idle for the idle timeout, so the other direction ends too.
+func (t *tunnel) pipe(ctx context.Context, cancel func(), dst net.Conn, src io.Reader,
+	srcConn net.Conn, limiters []*bandwidth.Limiter, counter *uint64) {
+	w := bandwidth.NewWriter(ctx, dst, limiters...)
+	buf := make([]byte, copyBufferSize)
+	idleTimeout := t.server.idleTimeout
+
+	abort := func() {
+		cancel()
+		t.client.Close()
+		t.upstream.Close()
+	}
+
+	for {
+		srcConn.SetReadDeadline(time.Now().Add(idleTimeout))
+		n, err := src.Read(buf)
+		if n > 0 {
+			atomic.StoreInt64(&t.lastActive, time.Now().UnixNano())
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				abort()
+				return
+			}
+			atomic.AddUint64(counter, uint64(n))
+		}
+
+		if err == nil {
+			continue
+		}
+		if err == io.EOF {
+			if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+				cw.CloseWrite()
+			} else {
+				abort()
+			}
+			return
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			last := time.Unix(0, atomic.LoadInt64(&t.lastActive))
+			if time.Since(last) < idleTimeout {
+				continue
+			}
+		}
+		abort()
+		return
+	}
+}
+
+// Status returns the status of the server.
+func (s *Server) Status() *Status {
+	s.mutex.Lock()
+	state, err := s.state, s.err
+	s.mutex.Unlock()
+
+	status := &Status{
+		State:                state,
+		ActiveConnections:    atomic.LoadInt64(&s.activeConns),
+		TotalConnections:     atomic.LoadUint64(&s.totalConns),
+		RejectedConnections:  atomic.LoadUint64(&s.rejectedConns),
+		UnauthorizedRequests: atomic.LoadUint64(&s.unauthorized),
+		ForbiddenRequests:    atomic.LoadUint64(&s.forbidden),
+		FailedDials:          atomic.LoadUint64(&s.failedDials),
+		ActiveTunnels:        atomic.LoadInt64(&s.activeTunnels),
+		TotalTunnels:         atomic.LoadUint64(&s.totalTunnels),
+		BytesSent:            atomic.LoadUint64(&s.bytesSent),
+		BytesReceived:        atomic.LoadUint64(&s.bytesReceived),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	return status
+}
+
+// Close closes the listener, established tunnels are kept until either
+// side closes them or they become idle.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	close(s.done)
+	s.state = stateClosed
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
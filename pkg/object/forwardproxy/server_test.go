@@ -0,0 +1,232 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package forwardproxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestHostMatcher(t *testing.T) {
+	m := newHostMatcher([]string{"example.com", ".megaease.com", "*.easegress.io", "10.0.0.0/8", "192.168.1.1"})
+
+	domains := map[string]bool{
+		"example.com":       true,
+		"api.example.com":   true,
+		"EXAMPLE.COM.":      true,
+		"badexample.com":    false,
+		"megaease.com":      false,
+		"www.megaease.com":  true,
+		"easegress.io":      false,
+		"a.b.easegress.io":  true,
+		"example.org":       false,
+		"example.com.evil":  false,
+		"www.megaease.com2": false,
+	}
+	for domain, want := range domains {
+		if got := m.matchDomain(domain); got != want {
+			t.Errorf("domain %s: want %v, got %v", domain, want, got)
+		}
+	}
+
+	ips := map[string]bool{
+		"10.1.2.3":    true,
+		"11.1.2.3":    false,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+	}
+	for ip, want := range ips {
+		if got := m.matchIP(net.ParseIP(ip)); got != want {
+			t.Errorf("ip %s: want %v, got %v", ip, want, got)
+		}
+	}
+
+	if !m.hasIPRules() {
+		t.Errorf("should have ip rules")
+	}
+	if newHostMatcher([]string{"example.com"}).hasIPRules() {
+		t.Errorf("should not have ip rules")
+	}
+	if !newHostMatcher([]string{"*"}).matchDomain("any.com") {
+		t.Errorf("* should match any domain")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a := newAuthenticator([]*UserSpec{{Username: "alice", Password: "secret:1"}})
+
+	basic := func(s string) string {
+		return "Basic " + base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	if username, ok := a.authenticate(basic("alice:secret:1")); !ok || username != "alice" {
+		t.Errorf("alice should be authenticated")
+	}
+	for _, header := range []string{"", basic("alice:secret"), basic("bob:secret:1"), basic("alice"), "Bearer abc", "Basic !!!"} {
+		if _, ok := a.authenticate(header); ok {
+			t.Errorf("%q should not be authenticated", header)
+		}
+	}
+
+	if newAuthenticator(nil) != nil {
+		t.Errorf("authenticator should be nil without users")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec *Spec
+		ok   bool
+	}{
+		{spec: &Spec{AllowedHosts: []string{"example.com", "10.0.0.0/8"}}, ok: true},
+		{spec: &Spec{AllowedHosts: []string{"10.0.0.0/33"}}},
+		{spec: &Spec{AllowedHosts: []string{""}}},
+		{spec: &Spec{AllowedHosts: []string{"*"}, AllowedPorts: []uint16{0}}},
+		{spec: &Spec{AllowedHosts: []string{"*"}, Users: []*UserSpec{{Username: "a:b", Password: "c"}}}},
+		{spec: &Spec{AllowedHosts: []string{"*"}, Users: []*UserSpec{{Username: "a", Password: "b"}, {Username: "a", Password: "c"}}}},
+		{spec: &Spec{AllowedHosts: []string{"*"}, Bandwidth: &BandwidthSpec{PerUser: 1024}}},
+	}
+
+	for i, c := range cases {
+		if err := c.spec.Validate(); (err == nil) != c.ok {
+			t.Errorf("case %d: ok should be %v, err: %v", i, c.ok, err)
+		}
+	}
+}
+
+func startEchoServer(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return l
+}
+
+func startServer(t *testing.T, spec *Spec) (*Server, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	s := newServer("proxy", spec)
+	s.state = stateRunning
+	go s.serve(l)
+	return s, l.Addr().String()
+}
+
+func connect(t *testing.T, proxyAddr, target, auth string) (net.Conn, *bufio.Reader, int) {
+	conn, err := net.Dial("tcp", proxyAddr)
+	if err != nil {
+		t.Fatalf("dial proxy failed: %v", err)
+	}
+
+	req := "CONNECT " + target + " HTTP/1.1\r\nHost: " + target + "\r\n"
+	if auth != "" {
+		req += "Proxy-Authorization: " + auth + "\r\n"
+	}
+	conn.Write([]byte(req + "\r\n"))
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read response failed: %v", err)
+	}
+	return conn, br, resp.StatusCode
+}
+
+func TestTunnel(t *testing.T) {
+	echo := startEchoServer(t)
+	defer echo.Close()
+	target := echo.Addr().String()
+	_, port, _ := net.SplitHostPort(target)
+	portNum, _ := strconv.Atoi(port)
+
+	s, addr := startServer(t, &Spec{
+		Users:        []*UserSpec{{Username: "alice", Password: "secret"}},
+		AllowedHosts: []string{"127.0.0.1"},
+		AllowedPorts: []uint16{uint16(portNum)},
+	})
+	defer s.Close()
+
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("alice:secret"))
+
+	conn, _, code := connect(t, addr, target, "")
+	conn.Close()
+	if code != http.StatusProxyAuthRequired {
+		t.Errorf("status should be 407, but is %d", code)
+	}
+
+	conn, _, code = connect(t, addr, "127.0.0.2:"+port, auth)
+	conn.Close()
+	if code != http.StatusForbidden {
+		t.Errorf("status should be 403, but is %d", code)
+	}
+
+	conn, _, code = connect(t, addr, "127.0.0.1:1", auth)
+	conn.Close()
+	if code != http.StatusForbidden {
+		t.Errorf("status should be 403, but is %d", code)
+	}
+
+	conn, br, code := connect(t, addr, target, auth)
+	defer conn.Close()
+	if code != http.StatusOK {
+		t.Fatalf("status should be 200, but is %d", code)
+	}
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	conn.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(br, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("echo should be hello, but is %q, err: %v", buf, err)
+	}
+
+	status := s.Status()
+	if status.TotalTunnels != 1 || status.UnauthorizedRequests != 1 || status.ForbiddenRequests != 2 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
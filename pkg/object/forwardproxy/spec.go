@@ -0,0 +1,124 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package forwardproxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+)
+
+const (
+	defaultDialTimeout = 10 * time.Second
+	defaultIdleTimeout = 5 * time.Minute
+)
+
+type (
+	// Spec describes the ForwardProxy.
+	Spec struct {
+		Port           uint16         `yaml:"port" jsonschema:"required,minimum=1"`
+		MaxConnections uint32         `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
+		IPFilter       *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
+		// Users are the users authenticated by Proxy-Authorization with
+		// the basic scheme, no authentication if it is empty.
+		Users []*UserSpec `yaml:"users,omitempty" jsonschema:"omitempty"`
+		// AllowedHosts are the destinations allowed: "*" for all, IPs,
+		// CIDRs, and domains matching the subdomains too, a leading "."
+		// or "*." matches the subdomains only. The domains not matched
+		// are resolved, and they're allowed if their IPs are.
+		AllowedHosts []string `yaml:"allowedHosts" jsonschema:"required,uniqueItems=true"`
+		// AllowedPorts are the ports of the destinations allowed, the
+		// default is 443.
+		AllowedPorts []uint16       `yaml:"allowedPorts" jsonschema:"omitempty,uniqueItems=true"`
+		DialTimeout  string         `yaml:"dialTimeout" jsonschema:"omitempty,format=duration"`
+		IdleTimeout  string         `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		Bandwidth    *BandwidthSpec `yaml:"bandwidth,omitempty" jsonschema:"omitempty"`
+	}
+
+	// UserSpec describes a user of the proxy.
+	UserSpec struct {
+		Username string `yaml:"username" jsonschema:"required"`
+		Password string `yaml:"password" jsonschema:"required"`
+	}
+
+	// BandwidthSpec describes the bandwidth limits in bytes per second,
+	// they apply to each direction separately.
+	BandwidthSpec struct {
+		// PerTunnel is the limit of every tunnel.
+		PerTunnel int64 `yaml:"perTunnel" jsonschema:"omitempty,minimum=1"`
+		// PerUser is the limit shared by the tunnels of a user, it
+		// requires users.
+		PerUser int64 `yaml:"perUser" jsonschema:"omitempty,minimum=1"`
+		// Total is the limit shared by all tunnels.
+		Total int64 `yaml:"total" jsonschema:"omitempty,minimum=1"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	usernames := map[string]struct{}{}
+	for _, user := range spec.Users {
+		if strings.Contains(user.Username, ":") {
+			return fmt.Errorf("username %s contains colon", user.Username)
+		}
+		if _, exists := usernames[user.Username]; exists {
+			return fmt.Errorf("username %s is duplicated", user.Username)
+		}
+		usernames[user.Username] = struct{}{}
+	}
+
+	for _, host := range spec.AllowedHosts {
+		if host == "" {
+			return fmt.Errorf("empty host in allowedHosts")
+		}
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return fmt.Errorf("invalid cidr %s in allowedHosts", host)
+			}
+		}
+	}
+
+	for _, port := range spec.AllowedPorts {
+		if port == 0 {
+			return fmt.Errorf("invalid port 0 in allowedPorts")
+		}
+	}
+
+	if spec.Bandwidth != nil && spec.Bandwidth.PerUser != 0 && len(spec.Users) == 0 {
+		return fmt.Errorf("bandwidth.perUser requires users")
+	}
+
+	return nil
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return d
+	}
+	return v
+}
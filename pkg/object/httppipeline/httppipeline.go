@@ -19,9 +19,11 @@ package httppipeline
 
 import (
 	"fmt"
+	"net/http"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/megaease/easegress/pkg/context"
@@ -29,6 +31,7 @@ import (
 	"github.com/megaease/easegress/pkg/protocol"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/resourcestat"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 	"github.com/megaease/easegress/pkg/util/yamltool"
 )
@@ -57,6 +60,10 @@ type (
 		muxMapper      protocol.MuxMapper
 		runningFilters []*runningFilter
 		ht             *context.HTTPTemplate
+
+		// clientGone is the count of requests aborted by the pipeline as
+		// their clients disconnected, it's accessed atomically.
+		clientGone uint64
 	}
 
 	runningFilter struct {
@@ -83,6 +90,9 @@ type (
 		Health string `yaml:"health"`
 
 		Filters map[string]interface{} `yaml:"filters"`
+		// ClientGone is the count of requests aborted as their clients
+		// disconnected.
+		ClientGone uint64 `yaml:"clientGone"`
 	}
 
 	// FilterStat records the statistics of the running filter.
@@ -300,7 +310,9 @@ func (hp *HTTPPipeline) Init(superSpec *supervisor.Spec, muxMapper protocol.MuxM
 func (hp *HTTPPipeline) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object, muxMapper protocol.MuxMapper) {
 	hp.superSpec, hp.spec, hp.muxMapper = superSpec, superSpec.ObjectSpec().(*Spec), muxMapper
 
-	hp.reload(previousGeneration.(*HTTPPipeline))
+	previous := previousGeneration.(*HTTPPipeline)
+	atomic.StoreUint64(&hp.clientGone, atomic.LoadUint64(&previous.clientGone))
+	hp.reload(previous)
 
 	// NOTE: It's filters' responsibility to inherit and clean their resources.
 	// previousGeneration.Close()
@@ -378,6 +390,9 @@ func (hp *HTTPPipeline) reload(previousGeneration *HTTPPipeline) {
 
 		filter := reflect.New(reflect.TypeOf(rootFilter).Elem()).Interface().(Filter)
 		runningFilter.spec.meta.Pipeline = pipelineName
+		if injector, ok := filter.(MuxMapperInjector); ok && hp.muxMapper != nil {
+			injector.InjectMuxMapper(hp.muxMapper)
+		}
 		if prevInstance == nil {
 			filter.Init(runningFilter.spec)
 		} else {
@@ -482,6 +497,20 @@ func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) string {
 		filter := hp.runningFilters[filterIndex]
 		name := filter.spec.Name()
 
+		// NOTE: No need to run the rest filters for a client which
+		// has gone, as nobody will receive the response.
+		if ctx.ClientDisconnected() {
+			atomic.AddUint64(&hp.clientGone, 1)
+			ctx.AddTag(stringtool.Cat("pipeline: client gone before filter ", name))
+			return LabelEND
+		}
+
+		if _, ok := filter.filter.(UpstreamFilter); ok && context.Unauthenticated(ctx) {
+			ctx.Response().SetStatusCode(http.StatusUnauthorized)
+			ctx.AddTag(stringtool.Cat("pipeline: unauthenticated request before filter ", name))
+			return LabelEND
+		}
+
 		if err := ctx.SaveReqToTemplate(name); err != nil {
 			format := "save http req failed, dict is %#v err is %v"
 			logger.Errorf(format, ctx.Template().GetDict(), err)
@@ -494,6 +523,10 @@ func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) string {
 		filterStat.Name = name
 		filterStat.Kind = filter.spec.Kind()
 
+		// NOTE: The tenant is known after the authentication, the rest
+		// filters are accounted to it.
+		resourcestat.LabelTenant(ctx)
+
 		startTime := fasttime.Now()
 		result := filter.filter.Handle(ctx)
 
@@ -507,7 +540,11 @@ func (hp *HTTPPipeline) Handle(ctx context.HTTPContext) string {
 	ctx.SetHandlerCaller(handle)
 	result := handle("")
 
-	ctx.AddTag(filterStat.marshalAndRelease())
+	stat := filterStat.marshalAndRelease()
+	ctx.AddTag(stat)
+	if info := context.GetDebugInfo(ctx); info != nil {
+		info.AddPipeline(hp.superSpec.Name(), strings.TrimPrefix(stat, "pipeline: "))
+	}
 	return result
 }
 
@@ -524,7 +561,8 @@ func (hp *HTTPPipeline) getRunningFilter(name string) *runningFilter {
 // Status returns Status generated by Runtime.
 func (hp *HTTPPipeline) Status() *supervisor.Status {
 	s := &Status{
-		Filters: make(map[string]interface{}),
+		Filters:    make(map[string]interface{}),
+		ClientGone: atomic.LoadUint64(&hp.clientGone),
 	}
 
 	for _, runningFilter := range hp.runningFilters {
@@ -271,7 +271,7 @@ filters:
 	if err != nil {
 		t.Errorf("failed to create spec %s", err)
 	}
-	httpPipeline := HTTPPipeline{nil, nil, nil, []*runningFilter{}, nil}
+	httpPipeline := HTTPPipeline{nil, nil, nil, []*runningFilter{}, nil, 0}
 	httpPipeline.Init(superSpec, nil)
 	httpPipeline.Inherit(superSpec, &httpPipeline, nil)
 
@@ -337,7 +337,7 @@ filters:
 	if err != nil {
 		t.Errorf("failed to create spec %s", err)
 	}
-	httpPipeline := HTTPPipeline{nil, nil, nil, []*runningFilter{}, nil}
+	httpPipeline := HTTPPipeline{nil, nil, nil, []*runningFilter{}, nil, 0}
 	httpPipeline.Init(superSpec, nil)
 	httpPipeline.Inherit(superSpec, &httpPipeline, nil)
 
@@ -356,3 +356,73 @@ filters:
 	httpPipeline.Close()
 	cleanup()
 }
+
+var handledFilters []string
+
+// NextFilterMock records the filters handling the request and calls the
+// next one.
+type NextFilterMock struct {
+	FilterMock
+	name string
+}
+
+func (m *NextFilterMock) Kind() string                             { return "NextFilterMock" }
+func (m *NextFilterMock) Init(filterSpec *FilterSpec)              { m.name = filterSpec.Name() }
+func (m *NextFilterMock) Inherit(filterSpec *FilterSpec, _ Filter) { m.Init(filterSpec) }
+func (m *NextFilterMock) Handle(ctx context.HTTPContext) string {
+	handledFilters = append(handledFilters, m.name)
+	return ctx.CallNextHandler("")
+}
+
+func TestHttpipelineClientGone(t *testing.T) {
+	superSpecYaml := `
+name: http-pipeline-test
+kind: HTTPPipeline
+filters:
+  - name: filter-1
+    kind: NextFilterMock
+  - name: filter-2
+    kind: NextFilterMock
+  - name: filter-3
+    kind: NextFilterMock
+`
+	logger.InitNop()
+	Register(CreateObjectMock("HTTPPipeline"))
+	Register(&NextFilterMock{})
+	defer cleanup()
+
+	superSpec, err := supervisor.NewSpec(superSpecYaml)
+	if err != nil {
+		t.Fatalf("failed to create spec %s", err)
+	}
+	httpPipeline := &HTTPPipeline{}
+	httpPipeline.Init(superSpec, nil)
+	defer httpPipeline.Close()
+
+	var caller context.HandlerCaller
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedSetHandlerCaller = func(c context.HandlerCaller) { caller = c }
+	ctx.MockedCallNextHandler = func(lastResult string) string { return caller(lastResult) }
+	ctx.MockedClientDisconnected = func() bool { return len(handledFilters) > 0 }
+
+	handledFilters = nil
+	if result := httpPipeline.Handle(ctx); result != LabelEND {
+		t.Errorf("result should be %q, was %q", LabelEND, result)
+	}
+	if !reflect.DeepEqual(handledFilters, []string{"filter-1"}) {
+		t.Errorf("only filter-1 should be called, were %v", handledFilters)
+	}
+	status := httpPipeline.Status().ObjectStatus.(*Status)
+	if status.ClientGone != 1 {
+		t.Errorf("clientGone should be 1, was %d", status.ClientGone)
+	}
+
+	// The counter survives reloads.
+	next := &HTTPPipeline{}
+	next.Inherit(superSpec, httpPipeline, nil)
+	httpPipeline = next
+	status = httpPipeline.Status().ObjectStatus.(*Status)
+	if status.ClientGone != 1 {
+		t.Errorf("clientGone should be 1 after reload, was %d", status.ClientGone)
+	}
+}
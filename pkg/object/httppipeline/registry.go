@@ -22,6 +22,7 @@ import (
 	"reflect"
 
 	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocol"
 )
 
 type (
@@ -60,6 +61,26 @@ type (
 		// Close closes itself.
 		Close()
 	}
+
+	// MuxMapperInjector is the optional interface for filters which need
+	// to hand requests over to other pipelines. The mux mapper is injected
+	// before Init or Inherit.
+	MuxMapperInjector interface {
+		InjectMuxMapper(mapper protocol.MuxMapper)
+	}
+
+	// UpstreamFilter is the optional interface for filters which send
+	// requests to upstream servers. The pipeline refuses to run them for
+	// requests requiring an authenticated identity but don't have one.
+	UpstreamFilter interface {
+		SendsUpstream()
+	}
+
+	// AuthFilter is the optional interface for filters which authenticate
+	// requests and set the identity of them, it's for API catalogs.
+	AuthFilter interface {
+		Authenticates()
+	}
 )
 
 var filterRegistry = map[string]Filter{}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestLimitedBody(t *testing.T) {
+	body := newLimitedBody(io.NopCloser(strings.NewReader("hello")), 5)
+	data, err := io.ReadAll(body)
+	if err != nil || string(data) != "hello" || body.exceeded {
+		t.Errorf("body within the limit should be read: %s, %v", data, err)
+	}
+
+	body = newLimitedBody(io.NopCloser(strings.NewReader("hello world")), 5)
+	data, err = io.ReadAll(body)
+	if err != errBodyTooLarge || !body.exceeded {
+		t.Errorf("expect body too large, got %v", err)
+	}
+	if len(data) > 5 {
+		t.Errorf("read %d bytes beyond the limit", len(data))
+	}
+	if _, err = body.Read(make([]byte, 10)); err != errBodyTooLarge {
+		t.Errorf("expect body too large, got %v", err)
+	}
+}
+
+func TestHandleBodyTooLarge(t *testing.T) {
+	ctx := &contexttest.MockedHTTPContext{}
+
+	body := &closeRecorder{Reader: strings.NewReader("bad gateway")}
+	var respBody io.Reader = body
+	statusCode := http.StatusBadGateway
+	header := httpheader.New(http.Header{"Content-Type": []string{"text/plain"}})
+	ctx.MockedResponse.MockedBody = func() io.Reader { return respBody }
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) { respBody = r }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+	(&mux{}).handleBodyTooLarge(ctx)
+	if !body.closed || respBody != nil || header.Get("Content-Type") != "" {
+		t.Errorf("the original response should be dropped")
+	}
+	if statusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected status code 413, got %d", statusCode)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// certificates contains the certificates of an HTTPServer, and selects
+// the certificate of a TLS connection by its server name (SNI).
+type certificates struct {
+	// defaultCert is used if no certificate matches the server name.
+	defaultCert *tls.Certificate
+	// byName is keyed by lower case host names, which could be wildcard
+	// names like "*.example.com".
+	byName map[string]*tls.Certificate
+
+	// caCertBase64 is the CA certificates to verify client certificates,
+	// clientCAs is its pool, it is nil if clients are not verified.
+	caCertBase64 string
+	clientCAs    *x509.CertPool
+}
+
+// certificates loads the certificates of the spec. The certificates of
+// certs/keys are indexed by their keys and the DNS names in them, so the
+// keys could be either host names or logic pair names.
+func (spec *Spec) certificates() (*certificates, error) {
+	c := &certificates{byName: map[string]*tls.Certificate{}}
+
+	if spec.CertBase64 != "" && spec.KeyBase64 != "" {
+		// Prefer add CertBase64 and KeyBase64
+		certPem, _ := base64.StdEncoding.DecodeString(spec.CertBase64)
+		keyPem, _ := base64.StdEncoding.DecodeString(spec.KeyBase64)
+		cert, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			return nil, fmt.Errorf("generate x509 key pair failed: %v", err)
+		}
+		c.add("", &cert)
+	}
+
+	// Sort the names to make the default certificate stable.
+	names := make([]string, 0, len(spec.Certs))
+	for k := range spec.Certs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	for _, k := range names {
+		secret, exists := spec.Keys[k]
+		if !exists {
+			return nil, fmt.Errorf("certs %s hasn't secret corresponded to it", k)
+		}
+
+		certPem := tryDecodeBase64Pem(spec.Certs[k])
+		keyPem := tryDecodeBase64Pem(secret)
+		cert, err := tls.X509KeyPair(certPem, keyPem)
+		if err != nil {
+			return nil, fmt.Errorf("generate x509 key pair for %s failed: %s ", k, err)
+		}
+		c.add(k, &cert)
+	}
+
+	if c.defaultCert == nil && !spec.AutoCert {
+		return nil, fmt.Errorf("none valid certs and secret")
+	}
+
+	// if caCertBase64 configuration is provided, should enable tls.ClientAuth and
+	// add the root cert
+	if len(spec.CaCertBase64) != 0 {
+		rootCertPem, _ := base64.StdEncoding.DecodeString(spec.CaCertBase64)
+		c.caCertBase64 = spec.CaCertBase64
+		c.clientCAs = x509.NewCertPool()
+		c.clientCAs.AppendCertsFromPEM(rootCertPem)
+	}
+
+	return c, nil
+}
+
+func (c *certificates) add(name string, cert *tls.Certificate) {
+	if c.defaultCert == nil {
+		c.defaultCert = cert
+	}
+
+	if name != "" {
+		c.byName[strings.ToLower(name)] = cert
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return
+	}
+	cert.Leaf = leaf
+
+	// The names of keys take precedence over the names in certificates.
+	for _, name := range leaf.DNSNames {
+		name = strings.ToLower(name)
+		if _, ok := c.byName[name]; !ok {
+			c.byName[name] = cert
+		}
+	}
+}
+
+// setClientAuth sets the client authentication of the TLS config
+// according to the client CAs.
+func (c *certificates) setClientAuth(tlsConf *tls.Config) {
+	tlsConf.ClientCAs = c.clientCAs
+	if c.clientCAs != nil {
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		tlsConf.ClientAuth = tls.NoClientCert
+	}
+}
+
+// get returns the certificate of the server name, the default one is
+// returned if none matches.
+func (c *certificates) get(serverName string) *tls.Certificate {
+	name := strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if name == "" {
+		return c.defaultCert
+	}
+
+	if cert := c.byName[name]; cert != nil {
+		return cert
+	}
+
+	// Wildcard certificates match one label only.
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if cert := c.byName["*"+name[i:]]; cert != nil {
+			return cert
+		}
+	}
+
+	return c.defaultCert
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newCertKeyPem(t *testing.T, names ...string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: names[0]},
+		NotBefore:    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:     time.Date(2031, 1, 1, 0, 0, 0, 0, time.UTC),
+		DNSNames:     names,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(certPem), string(keyPem)
+}
+
+func TestCertificates(t *testing.T) {
+	spec := &Spec{
+		HTTPS: true,
+		Certs: map[string]string{},
+		Keys:  map[string]string{},
+	}
+	spec.Certs["a.megaease.com"], spec.Keys["a.megaease.com"] = newCertKeyPem(t, "a.megaease.com")
+	spec.Certs["wildcard"], spec.Keys["wildcard"] = newCertKeyPem(t, "*.megaease.cn", "megaease.cn")
+	spec.Certs["b.megaease.com"], spec.Keys["b.megaease.com"] = newCertKeyPem(t, "other.megaease.com")
+
+	certs, err := spec.certificates()
+	if err != nil {
+		t.Fatalf("load certificates failed: %v", err)
+	}
+
+	cases := map[string]string{
+		"a.megaease.com":      "a.megaease.com",
+		"A.MegaEase.com.":     "a.megaease.com",
+		"b.megaease.com":      "other.megaease.com",
+		"other.megaease.com":  "other.megaease.com",
+		"www.megaease.cn":     "*.megaease.cn",
+		"megaease.cn":         "*.megaease.cn",
+		"a.b.megaease.cn":     "a.megaease.com",
+		"unknown.example.com": "a.megaease.com",
+		"":                    "a.megaease.com",
+	}
+	for serverName, want := range cases {
+		cert := certs.get(serverName)
+		if cert == nil || cert.Leaf == nil {
+			t.Errorf("%q: certificate should not be nil", serverName)
+			continue
+		}
+		if got := cert.Leaf.DNSNames[0]; got != want {
+			t.Errorf("%q: certificate should be %s, but is %s", serverName, want, got)
+		}
+	}
+
+	spec.Keys = map[string]string{}
+	if _, err = spec.certificates(); err == nil {
+		t.Errorf("certificates without keys should fail")
+	}
+}
+
+func TestTLSConfigGetCertificate(t *testing.T) {
+	certPem, keyPem := newCertKeyPem(t, "a.megaease.com")
+	spec := &Spec{
+		HTTPS: true,
+		Certs: map[string]string{"a.megaease.com": certPem},
+		Keys:  map[string]string{"a.megaease.com": keyPem},
+	}
+
+	tlsConfig, err := spec.tlsConfig()
+	if err != nil {
+		t.Fatalf("create tls config failed: %v", err)
+	}
+
+	cert, err := tlsConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "a.megaease.com"})
+	if err != nil || cert == nil {
+		t.Fatalf("get certificate failed: %v", err)
+	}
+	if cert.Leaf.DNSNames[0] != "a.megaease.com" {
+		t.Errorf("certificate should be a.megaease.com, but is %s", cert.Leaf.DNSNames[0])
+	}
+}
+
+func TestTLSConfigClientCAs(t *testing.T) {
+	certPem, keyPem := newCertKeyPem(t, "a.megaease.com")
+	caPem, _ := newCertKeyPem(t, "ca.megaease.com")
+	spec := &Spec{
+		HTTPS: true,
+		Certs: map[string]string{"a.megaease.com": certPem},
+		Keys:  map[string]string{"a.megaease.com": keyPem},
+	}
+
+	certs, err := spec.certificates()
+	if err != nil {
+		t.Fatalf("load certificates failed: %v", err)
+	}
+	tlsConfig := spec.newTLSConfig(func() *certificates { return certs })
+	if tlsConfig.ClientAuth != tls.NoClientCert || tlsConfig.ClientCAs != nil {
+		t.Fatalf("client certificates should not be required")
+	}
+
+	conf, err := tlsConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+	if conf != nil || err != nil {
+		t.Fatalf("config should not be changed")
+	}
+
+	// Enable client authentication without restarting.
+	spec.CaCertBase64 = base64.StdEncoding.EncodeToString([]byte(caPem))
+	certs, err = spec.certificates()
+	if err != nil {
+		t.Fatalf("load certificates failed: %v", err)
+	}
+
+	conf, err = tlsConfig.GetConfigForClient(&tls.ClientHelloInfo{})
+	if err != nil || conf == nil {
+		t.Fatalf("config should be changed: %v", err)
+	}
+	if conf.ClientAuth != tls.RequireAndVerifyClientCert || conf.ClientCAs == nil {
+		t.Errorf("client certificates should be required")
+	}
+	if conf.GetCertificate == nil {
+		t.Errorf("certificates should be kept")
+	}
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	defaultClientCertHeader = "X-Forwarded-Client-Cert"
+
+	clientCertDetailHash    = "hash"
+	clientCertDetailSubject = "subject"
+	clientCertDetailSAN     = "san"
+	clientCertDetailCert    = "cert"
+	clientCertDetailChain   = "chain"
+)
+
+type (
+	// ForwardClientCertSpec describes the forwarding of the details of
+	// client certificates to the backends.
+	ForwardClientCertSpec struct {
+		// Header is the header of the details, the default is
+		// X-Forwarded-Client-Cert.
+		Header string `yaml:"header" jsonschema:"omitempty"`
+		// Details are the details forwarded, the default is hash, subject
+		// and san.
+		Details []string `yaml:"details" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// clientCertForwarder replaces the header of requests with the
+	// details of the verified client certificates, in the format of
+	// Envoy, e.g.
+	//
+	//	Hash=<sha256>;Subject="CN=client";DNS=client.example.com
+	clientCertForwarder struct {
+		header  string
+		details map[string]bool
+	}
+)
+
+// Validate validates ForwardClientCertSpec.
+func (spec *ForwardClientCertSpec) Validate() error {
+	if spec.Header != "" && !httpguts.ValidHeaderFieldName(spec.Header) {
+		return fmt.Errorf("invalid header %q", spec.Header)
+	}
+
+	for _, detail := range spec.Details {
+		switch detail {
+		case clientCertDetailHash, clientCertDetailSubject, clientCertDetailSAN,
+			clientCertDetailCert, clientCertDetailChain:
+		default:
+			return fmt.Errorf("invalid detail %s, it must be one of hash, subject, san, cert and chain", detail)
+		}
+	}
+
+	return nil
+}
+
+func newClientCertForwarder(spec *ForwardClientCertSpec) *clientCertForwarder {
+	if spec == nil {
+		return nil
+	}
+
+	f := &clientCertForwarder{
+		header:  defaultClientCertHeader,
+		details: map[string]bool{},
+	}
+	if spec.Header != "" {
+		f.header = httpheader.CanonicalKey(spec.Header)
+	}
+
+	details := spec.Details
+	if len(details) == 0 {
+		details = []string{clientCertDetailHash, clientCertDetailSubject, clientCertDetailSAN}
+	}
+	for _, detail := range details {
+		f.details[detail] = true
+	}
+
+	return f
+}
+
+// forward sets the header to the details of the client certificate, the
+// header from clients is always removed, so it can't be spoofed.
+func (f *clientCertForwarder) forward(ctx context.HTTPContext) {
+	header := ctx.Request().Header()
+	header.Del(f.header)
+
+	state := ctx.Request().Std().TLS
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	header.Set(f.header, f.value(state.PeerCertificates))
+}
+
+func (f *clientCertForwarder) value(chain []*x509.Certificate) string {
+	cert := chain[0]
+
+	var elements []string
+	if f.details[clientCertDetailHash] {
+		sum := sha256.Sum256(cert.Raw)
+		elements = append(elements, "Hash="+hex.EncodeToString(sum[:]))
+	}
+	if f.details[clientCertDetailSubject] {
+		elements = append(elements, "Subject="+quoteClientCertValue(cert.Subject.String()))
+	}
+	if f.details[clientCertDetailSAN] {
+		for _, uri := range cert.URIs {
+			elements = append(elements, "URI="+uri.String())
+		}
+		for _, name := range cert.DNSNames {
+			elements = append(elements, "DNS="+name)
+		}
+		for _, email := range cert.EmailAddresses {
+			elements = append(elements, "Email="+email)
+		}
+		for _, ip := range cert.IPAddresses {
+			elements = append(elements, "IP="+ip.String())
+		}
+	}
+	if f.details[clientCertDetailCert] {
+		elements = append(elements, "Cert="+quoteClientCertValue(url.QueryEscape(encodePEM(cert))))
+	}
+	if f.details[clientCertDetailChain] {
+		var pems strings.Builder
+		for _, cert := range chain {
+			pems.WriteString(encodePEM(cert))
+		}
+		elements = append(elements, "Chain="+quoteClientCertValue(url.QueryEscape(pems.String())))
+	}
+
+	return strings.Join(elements, ";")
+}
+
+func encodePEM(cert *x509.Certificate) string {
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+}
+
+// quoteClientCertValue quotes the value which may contain the separators,
+// the quotes and the backslashes in it are escaped.
+func quoteClientCertValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	return `"` + value + `"`
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestForwardClientCertSpecValidate(t *testing.T) {
+	spec := &ForwardClientCertSpec{Header: "X-Client-Cert", Details: []string{"hash", "cert", "chain"}}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := []*ForwardClientCertSpec{
+		{Header: "bad header"},
+		{Details: []string{"issuer"}},
+	}
+	for _, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expect error for %+v", spec)
+		}
+	}
+}
+
+func TestClientCertForwarder(t *testing.T) {
+	certPem, _ := newCertKeyPem(t, "client", "client.example.com")
+	block, _ := pem.Decode([]byte(certPem))
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(cert.Raw)
+	hash := hex.EncodeToString(sum[:])
+
+	newContext := func(state *tls.ConnectionState, header http.Header) *contexttest.MockedHTTPContext {
+		stdr := &http.Request{TLS: state, Header: header}
+		h := httpheader.New(header)
+		ctx := &contexttest.MockedHTTPContext{}
+		ctx.MockedRequest.MockedStd = func() *http.Request { return stdr }
+		ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return h }
+		return ctx
+	}
+	state := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+
+	f := newClientCertForwarder(&ForwardClientCertSpec{})
+	header := http.Header{"X-Forwarded-Client-Cert": {"Hash=spoofed"}}
+	f.forward(newContext(state, header))
+	expected := "Hash=" + hash + ";Subject=\"CN=client\";DNS=client;DNS=client.example.com"
+	if v := header.Get("X-Forwarded-Client-Cert"); v != expected {
+		t.Errorf("expect %s, got %s", expected, v)
+	}
+
+	// The header from clients is removed without client certificates.
+	header = http.Header{"X-Forwarded-Client-Cert": {"Hash=spoofed"}}
+	f.forward(newContext(&tls.ConnectionState{}, header))
+	if _, exists := header["X-Forwarded-Client-Cert"]; exists {
+		t.Errorf("header should be removed")
+	}
+
+	f = newClientCertForwarder(&ForwardClientCertSpec{Header: "x-client-cert", Details: []string{"cert"}})
+	header = http.Header{}
+	f.forward(newContext(state, header))
+	expected = `Cert="` + url.QueryEscape(certPem) + `"`
+	if v := header.Get("X-Client-Cert"); v != expected {
+		t.Errorf("expect %s, got %s", expected, v)
+	}
+}
+
+func TestQuoteClientCertValue(t *testing.T) {
+	cases := map[string]string{
+		"CN=client":   `"CN=client"`,
+		`CN=a\"b,O=c`: `"CN=a\\\"b,O=c"`,
+	}
+	for value, expected := range cases {
+		if quoted := quoteClientCertValue(value); quoted != expected {
+			t.Errorf("expect %s, got %s", expected, quoted)
+		}
+	}
+}
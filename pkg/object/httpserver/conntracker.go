@@ -0,0 +1,328 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	minReapInterval = time.Second
+	maxReapInterval = 10 * time.Second
+)
+
+type (
+	// connTracker tracks the connections of the server, it closes the
+	// connections idle beyond the idle timeout, and the idle connections
+	// living beyond the max lifetime, so that long-lived keep-alive
+	// connections don't pin to the old process across graceful updates.
+	// It also recycles the connections serving the max requests, so the
+	// clients reconnect and get rebalanced by the L4 load balancer.
+	connTracker struct {
+		// NOTE: They are accessed atomically, keep them 64-bit aligned.
+		reapedIdle     uint64
+		reapedLifetime uint64
+		recycled       uint64
+		// maxRequests is the max requests per connection, zero means
+		// no limit, it is accessed atomically.
+		maxRequests uint32
+
+		mutex       sync.Mutex
+		conns       map[net.Conn]*trackedConn
+		idleTimeout time.Duration
+		maxLifetime time.Duration
+		// byAddr indexes the TCP connections by their addresses, which
+		// requests tell, see connAddrKey.
+		byAddr map[string]*trackedConn
+
+		done chan struct{}
+	}
+
+	trackedConn struct {
+		state     http.ConnState
+		createdAt time.Time
+		// idleSince is the time of the last transition to the new or
+		// idle state.
+		idleSince time.Time
+		addrKey   string
+		requests  uint32
+		conn      net.Conn
+	}
+
+	// ConnectionStatus contains the statistics of connections.
+	ConnectionStatus struct {
+		Active uint64 `yaml:"active"`
+		Idle   uint64 `yaml:"idle"`
+		// ReapedIdle is the number of connections closed for being idle
+		// beyond the keep-alive timeout.
+		ReapedIdle uint64 `yaml:"reapedIdle"`
+		// ReapedLifetime is the number of connections closed for living
+		// beyond the max connection lifetime.
+		ReapedLifetime uint64 `yaml:"reapedLifetime"`
+		// Recycled is the number of connections closed after serving the
+		// max requests per connection.
+		Recycled uint64 `yaml:"recycled"`
+	}
+)
+
+func newConnTracker(idleTimeout, maxLifetime time.Duration) *connTracker {
+	ct := &connTracker{
+		conns:       map[net.Conn]*trackedConn{},
+		byAddr:      map[string]*trackedConn{},
+		idleTimeout: idleTimeout,
+		maxLifetime: maxLifetime,
+		done:        make(chan struct{}),
+	}
+	go ct.run()
+	return ct
+}
+
+func (ct *connTracker) setTimeouts(idleTimeout, maxLifetime time.Duration) {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+	ct.idleTimeout, ct.maxLifetime = idleTimeout, maxLifetime
+}
+
+func (ct *connTracker) setMaxRequests(maxRequests uint32) {
+	atomic.StoreUint32(&ct.maxRequests, maxRequests)
+}
+
+// connAddrKey returns the key of the connection of the addresses, it is
+// empty for the unix sockets, whose addresses are not unique.
+func connAddrKey(localAddr, remoteAddr string) string {
+	if localAddr == "" || remoteAddr == "" || remoteAddr == "@" {
+		return ""
+	}
+	return localAddr + "|" + remoteAddr
+}
+
+// requestAddrKey returns the key of the connection of the request.
+func requestAddrKey(req *http.Request) string {
+	localAddr := ""
+	if addr, ok := req.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		localAddr = addr.String()
+	}
+	return connAddrKey(localAddr, req.RemoteAddr)
+}
+
+// connState is the ConnState hook of http.Server.
+func (ct *connTracker) connState(conn net.Conn, state http.ConnState) {
+	now := time.Now()
+	expired := false
+
+	ct.mutex.Lock()
+	defer func() {
+		ct.mutex.Unlock()
+		// NOTE: Close it out of the lock, as closing TLS connections writes.
+		if expired {
+			conn.Close()
+		}
+	}()
+
+	switch state {
+	case http.StateNew:
+		tc := &trackedConn{state: state, createdAt: now, idleSince: now, conn: conn}
+		if conn.LocalAddr().Network() != "unix" {
+			tc.addrKey = connAddrKey(conn.LocalAddr().String(), conn.RemoteAddr().String())
+		}
+		ct.conns[conn] = tc
+		if tc.addrKey != "" {
+			ct.byAddr[tc.addrKey] = tc
+		}
+	case http.StateActive:
+		if tc := ct.conns[conn]; tc != nil {
+			tc.state = state
+		}
+	case http.StateIdle:
+		tc := ct.conns[conn]
+		if tc == nil {
+			return
+		}
+		tc.state, tc.idleSince = state, now
+		// NOTE: Close it as soon as the in-flight request finishes.
+		if ct.maxLifetime > 0 && now.Sub(tc.createdAt) >= ct.maxLifetime {
+			ct.deleteLocked(conn, tc)
+			atomic.AddUint64(&ct.reapedLifetime, 1)
+			expired = true
+		}
+	case http.StateHijacked, http.StateClosed:
+		if tc := ct.conns[conn]; tc != nil {
+			ct.deleteLocked(conn, tc)
+		}
+	}
+}
+
+func (ct *connTracker) deleteLocked(conn net.Conn, tc *trackedConn) {
+	delete(ct.conns, conn)
+	if tc.addrKey != "" && ct.byAddr[tc.addrKey] == tc {
+		delete(ct.byAddr, tc.addrKey)
+	}
+}
+
+// countRequest counts a request of the connection, and returns whether
+// the connection should be closed after the request, as it reaches the
+// max requests per connection.
+func (ct *connTracker) countRequest(addrKey string) bool {
+	maxRequests := atomic.LoadUint32(&ct.maxRequests)
+	if maxRequests == 0 {
+		return false
+	}
+
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	tc := ct.byAddr[addrKey]
+	if tc == nil {
+		return false
+	}
+
+	tc.requests++
+	if tc.requests == maxRequests {
+		atomic.AddUint64(&ct.recycled, 1)
+	}
+	return tc.requests >= maxRequests
+}
+
+// handler wraps the handler to recycle the connections serving the max
+// requests. The response of the last request has "Connection: close",
+// so the HTTP/1.x connection is closed after it, and the HTTP/2 one is
+// shut down gracefully by GOAWAY.
+func (ct *connTracker) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// NOTE: HTTP/3 connections are not tracked, and the addresses
+		// of them may collide with the TCP ones.
+		if req.ProtoMajor >= 3 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if ct.countRequest(requestAddrKey(req)) {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+func (ct *connTracker) reapInterval() time.Duration {
+	ct.mutex.Lock()
+	interval := ct.idleTimeout
+	if ct.maxLifetime > 0 && (interval <= 0 || ct.maxLifetime < interval) {
+		interval = ct.maxLifetime
+	}
+	ct.mutex.Unlock()
+
+	interval /= 2
+	if interval < minReapInterval {
+		return minReapInterval
+	}
+	if interval > maxReapInterval {
+		return maxReapInterval
+	}
+	return interval
+}
+
+func (ct *connTracker) run() {
+	timer := time.NewTimer(ct.reapInterval())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ct.done:
+			return
+		case now := <-timer.C:
+			ct.reap(now)
+			timer.Reset(ct.reapInterval())
+		}
+	}
+}
+
+// reap closes the connections idle beyond the idle timeout, including
+// the new ones never sending a request, and the idle ones living beyond
+// the max lifetime.
+func (ct *connTracker) reap(now time.Time) {
+	var expired []net.Conn
+
+	ct.mutex.Lock()
+	for conn, tc := range ct.conns {
+		if tc.state != http.StateNew && tc.state != http.StateIdle {
+			continue
+		}
+
+		switch {
+		case ct.idleTimeout > 0 && now.Sub(tc.idleSince) >= ct.idleTimeout:
+			atomic.AddUint64(&ct.reapedIdle, 1)
+		case ct.maxLifetime > 0 && now.Sub(tc.createdAt) >= ct.maxLifetime:
+			atomic.AddUint64(&ct.reapedLifetime, 1)
+		default:
+			continue
+		}
+
+		ct.deleteLocked(conn, tc)
+		expired = append(expired, conn)
+	}
+	ct.mutex.Unlock()
+
+	for _, conn := range expired {
+		conn.Close()
+	}
+}
+
+func (ct *connTracker) status() *ConnectionStatus {
+	s := &ConnectionStatus{
+		ReapedIdle:     atomic.LoadUint64(&ct.reapedIdle),
+		ReapedLifetime: atomic.LoadUint64(&ct.reapedLifetime),
+		Recycled:       atomic.LoadUint64(&ct.recycled),
+	}
+
+	ct.mutex.Lock()
+	for _, tc := range ct.conns {
+		if tc.state == http.StateActive {
+			s.Active++
+		} else {
+			s.Idle++
+		}
+	}
+	ct.mutex.Unlock()
+
+	return s
+}
+
+func (ct *connTracker) close() {
+	close(ct.done)
+}
+
+// httpConn returns the connection of the HTTP/1.x request, nil is returned
+// for the other protocols, whose connections are shared by requests, and
+// the unix sockets.
+func (ct *connTracker) httpConn(req *http.Request) net.Conn {
+	if ct == nil || req.ProtoMajor != 1 {
+		return nil
+	}
+
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	if tc := ct.byAddr[requestAddrKey(req)]; tc != nil {
+		return tc.conn
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeConn struct {
+	net.Conn
+	closed     bool
+	localAddr  net.Addr
+	remoteAddr net.Addr
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *fakeConn) LocalAddr() net.Addr {
+	if c.localAddr == nil {
+		return &net.UnixAddr{Name: "/tmp/fake.sock", Net: "unix"}
+	}
+	return c.localAddr
+}
+
+func (c *fakeConn) RemoteAddr() net.Addr {
+	if c.remoteAddr == nil {
+		return &net.UnixAddr{Name: "@", Net: "unix"}
+	}
+	return c.remoteAddr
+}
+
+func TestConnTracker(t *testing.T) {
+	ct := &connTracker{
+		conns:       map[net.Conn]*trackedConn{},
+		byAddr:      map[string]*trackedConn{},
+		idleTimeout: time.Minute,
+		maxLifetime: time.Hour,
+	}
+
+	idle, active, old := &fakeConn{}, &fakeConn{}, &fakeConn{}
+	ct.connState(idle, http.StateNew)
+	ct.connState(active, http.StateNew)
+	ct.connState(active, http.StateActive)
+	ct.connState(old, http.StateNew)
+	ct.conns[old].createdAt = time.Now().Add(-2 * time.Hour)
+
+	s := ct.status()
+	if s.Active != 1 || s.Idle != 2 {
+		t.Fatalf("unexpected status %+v", s)
+	}
+
+	// The old connection is closed once it becomes idle.
+	ct.connState(old, http.StateActive)
+	ct.connState(old, http.StateIdle)
+	if !old.closed {
+		t.Errorf("expected the old connection closed")
+	}
+
+	ct.reap(time.Now().Add(2 * time.Minute))
+	if !idle.closed {
+		t.Errorf("expected the idle connection closed")
+	}
+	if active.closed {
+		t.Errorf("the active connection should not be closed")
+	}
+
+	s = ct.status()
+	if s.Active != 1 || s.Idle != 0 || s.ReapedIdle != 1 || s.ReapedLifetime != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	ct.connState(active, http.StateClosed)
+	if s := ct.status(); s.Active != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestConnTrackerMaxRequests(t *testing.T) {
+	ct := &connTracker{
+		conns:  map[net.Conn]*trackedConn{},
+		byAddr: map[string]*trackedConn{},
+	}
+	ct.setMaxRequests(2)
+
+	localAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 10080}
+	remoteAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000}
+	conn := &fakeConn{localAddr: localAddr, remoteAddr: remoteAddr}
+	ct.connState(conn, http.StateNew)
+
+	handler := ct.handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	serve := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr.String()
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, localAddr))
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w.Header().Get("Connection")
+	}
+
+	if got := serve(); got != "" {
+		t.Errorf("the first request should not close the connection, got %q", got)
+	}
+	if got := serve(); got != "close" {
+		t.Errorf("the second request should close the connection, got %q", got)
+	}
+	if s := ct.status(); s.Recycled != 1 {
+		t.Errorf("unexpected status %+v", s)
+	}
+
+	ct.connState(conn, http.StateClosed)
+	if len(ct.byAddr) != 0 {
+		t.Errorf("the closed connection should be removed")
+	}
+
+	// Unix socket connections are not recycled.
+	unixConn := &fakeConn{}
+	ct.connState(unixConn, http.StateNew)
+	if len(ct.byAddr) != 0 {
+		t.Errorf("the unix socket connection should not be indexed")
+	}
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+// otherConsumers is the name of the statistics of the consumers beyond
+// the limit.
+const otherConsumers = "~other"
+
+type (
+	// consumerStat is the statistics of authenticated consumers, the number
+	// of consumers is limited to guard against unbounded cardinality.
+	consumerStat struct {
+		mutex sync.RWMutex
+		m     map[string]*httpstat.HTTPStat
+	}
+
+	// ConsumerStatus is the status of a consumer.
+	ConsumerStatus struct {
+		// Consumer is in format [<tenant>/]<consumer or subject>.
+		Consumer string `yaml:"consumer"`
+		*httpstat.Status
+	}
+)
+
+func newConsumerStat() *consumerStat {
+	return &consumerStat{m: map[string]*httpstat.HTTPStat{}}
+}
+
+// get returns the statistics of the identity, the statistics of consumers
+// beyond the limit are merged into otherConsumers.
+func (cs *consumerStat) get(identity *context.Identity, limit int) *httpstat.HTTPStat {
+	name := identity.Name()
+
+	cs.mutex.RLock()
+	stat := cs.m[name]
+	cs.mutex.RUnlock()
+	if stat != nil {
+		return stat
+	}
+
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if stat = cs.m[name]; stat != nil {
+		return stat
+	}
+
+	if len(cs.m) >= limit {
+		name = otherConsumers
+		if stat = cs.m[name]; stat != nil {
+			return stat
+		}
+	}
+
+	stat = httpstat.New()
+	cs.m[name] = stat
+	return stat
+}
+
+// reset removes the statistics of all consumers.
+func (cs *consumerStat) reset() {
+	cs.mutex.Lock()
+	cs.m = map[string]*httpstat.HTTPStat{}
+	cs.mutex.Unlock()
+}
+
+// Status returns the status of consumers.
+func (cs *consumerStat) Status() []*ConsumerStatus {
+	cs.mutex.RLock()
+	status := make([]*ConsumerStatus, 0, len(cs.m))
+	for name, stat := range cs.m {
+		status = append(status, &ConsumerStatus{Consumer: name, Status: stat.Status()})
+	}
+	cs.mutex.RUnlock()
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Consumer < status[j].Consumer
+	})
+	return status
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+func TestConsumerStat(t *testing.T) {
+	cs := newConsumerStat()
+	metric := &httpstat.Metric{StatusCode: 200}
+
+	alice := &context.Identity{Subject: "alice"}
+	bob := &context.Identity{Subject: "bob", Consumer: "app", Tenant: "acme"}
+	carol := &context.Identity{Subject: "carol"}
+
+	cs.get(alice, 2).Stat(metric)
+	cs.get(alice, 2).Stat(metric)
+	cs.get(bob, 2).Stat(metric)
+	cs.get(carol, 2).Stat(metric)
+
+	status := cs.Status()
+	expected := map[string]uint64{"alice": 2, "acme/app": 1, otherConsumers: 1}
+	if len(status) != len(expected) {
+		t.Fatalf("expected %d consumers, got %d", len(expected), len(status))
+	}
+	for _, s := range status {
+		if s.Count != expected[s.Consumer] {
+			t.Errorf("expected count of %s to be %d, got %d", s.Consumer, expected[s.Consumer], s.Count)
+		}
+	}
+
+	cs.reset()
+	if len(cs.Status()) != 0 {
+		t.Errorf("expected no consumers after reset")
+	}
+}
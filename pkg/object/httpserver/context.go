@@ -23,10 +23,12 @@ import (
 )
 
 const (
-	serverShutdownTimeout = 30 * time.Second
+	// defaultShutdownTimeout is the default max time to drain in-flight
+	// requests when shutting down the server.
+	defaultShutdownTimeout = 30 * time.Second
 )
 
-func serverShutdownContext() (stdcontext.Context, stdcontext.CancelFunc) {
-	ctx, cancelFunc := stdcontext.WithTimeout(stdcontext.Background(), serverShutdownTimeout)
+func serverShutdownContext(timeout time.Duration) (stdcontext.Context, stdcontext.CancelFunc) {
+	ctx, cancelFunc := stdcontext.WithTimeout(stdcontext.Background(), timeout)
 	return ctx, cancelFunc
 }
@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+const (
+	defaultDebugHeader = "X-EG-Debug"
+
+	debugHeaderRoute     = "X-EG-Debug-Route"
+	debugHeaderBackend   = "X-EG-Debug-Backend"
+	debugHeaderPipelines = "X-EG-Debug-Pipelines"
+	debugHeaderUpstreams = "X-EG-Debug-Upstreams"
+
+	// debugRouteNone is the route of requests matching no route.
+	debugRouteNone = "<none>"
+)
+
+// DebugSpec describes the debug mode, in which the response carries
+// headers describing the routing decisions of the request.
+type DebugSpec struct {
+	// Header is the request header to trigger the debug mode, its value
+	// must be the token.
+	Header string `yaml:"header" jsonschema:"omitempty"`
+	Token  string `yaml:"token" jsonschema:"required,minLength=16"`
+}
+
+// Validate validates DebugSpec.
+func (spec *DebugSpec) Validate() error {
+	if len(spec.Token) < 16 {
+		return fmt.Errorf("token must be at least 16 characters")
+	}
+	return nil
+}
+
+func (spec *DebugSpec) header() string {
+	if spec.Header == "" {
+		return defaultDebugHeader
+	}
+	return spec.Header
+}
+
+// startDebug returns the debug information if the request triggers the
+// debug mode. The trigger header is removed, so the token never leaks
+// to the upstreams.
+func startDebug(spec *DebugSpec, ctx context.HTTPContext) *context.DebugInfo {
+	if spec == nil {
+		return nil
+	}
+
+	header := ctx.Request().Header()
+	token := header.Get(spec.header())
+	if token == "" {
+		return nil
+	}
+	header.Del(spec.header())
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(spec.Token)) != 1 {
+		return nil
+	}
+
+	info := &context.DebugInfo{}
+	info.SetRoute(debugRouteNone, "")
+	ctx.SetKV(context.KeyDebugInfo, info)
+	return info
+}
+
+// writeDebugHeaders writes the debug information into response headers,
+// it must be called before the response is flushed.
+func writeDebugHeaders(ctx context.HTTPContext, info *context.DebugInfo) {
+	header := ctx.Response().Header()
+
+	route, backend := info.Route()
+	header.Set(debugHeaderRoute, route)
+	if backend != "" {
+		header.Set(debugHeaderBackend, backend)
+	}
+	if pipelines := info.Pipelines(); pipelines != "" {
+		header.Set(debugHeaderPipelines, pipelines)
+	}
+	if upstreams := info.Upstreams(); upstreams != "" {
+		header.Set(debugHeaderUpstreams, upstreams)
+	}
+}
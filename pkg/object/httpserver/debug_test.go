@@ -0,0 +1,88 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func newDebugContext(token string) (*contexttest.MockedHTTPContext, *httpheader.HTTPHeader, *httpheader.HTTPHeader) {
+	ctx := &contexttest.MockedHTTPContext{}
+	reqHeader := httpheader.New(http.Header{})
+	respHeader := httpheader.New(http.Header{})
+	if token != "" {
+		reqHeader.Set(defaultDebugHeader, token)
+	}
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return respHeader }
+	return ctx, reqHeader, respHeader
+}
+
+func TestDebug(t *testing.T) {
+	spec := &DebugSpec{Token: "0123456789abcdef"}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := (&DebugSpec{Token: "short"}).Validate(); err == nil {
+		t.Errorf("expect error for short token")
+	}
+
+	ctx, _, _ := newDebugContext("")
+	if startDebug(spec, ctx) != nil {
+		t.Errorf("debug mode should not be enabled without the header")
+	}
+
+	ctx, reqHeader, _ := newDebugContext("wrong")
+	if startDebug(spec, ctx) != nil {
+		t.Errorf("debug mode should not be enabled with a wrong token")
+	}
+	if reqHeader.Get(defaultDebugHeader) != "" {
+		t.Errorf("the debug header should be removed")
+	}
+
+	ctx, reqHeader, respHeader := newDebugContext(spec.Token)
+	info := startDebug(spec, ctx)
+	if info == nil || context.GetDebugInfo(ctx) != info {
+		t.Fatalf("debug mode should be enabled")
+	}
+	if reqHeader.Get(defaultDebugHeader) != "" {
+		t.Errorf("the debug header should be removed")
+	}
+
+	info.SetRoute("* /api -> pipeline", "pipeline")
+	info.AddPipeline("pipeline", "validator(1ms)->proxy(10ms)")
+	info.AddUpstream("proxy#main", "http://127.0.0.1:9095")
+	writeDebugHeaders(ctx, info)
+
+	expected := map[string]string{
+		debugHeaderRoute:     "* /api -> pipeline",
+		debugHeaderBackend:   "pipeline",
+		debugHeaderPipelines: "pipeline: validator(1ms)->proxy(10ms)",
+		debugHeaderUpstreams: "proxy#main: http://127.0.0.1:9095",
+	}
+	for k, v := range expected {
+		if got := respHeader.Get(k); got != v {
+			t.Errorf("expected %s to be %q, got %q", k, v, got)
+		}
+	}
+}
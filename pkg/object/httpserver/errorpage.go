@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const defaultErrorPageContentType = "text/html; charset=utf-8"
+
+type (
+	// ErrorPage is the custom response body of status codes.
+	ErrorPage struct {
+		Codes []int `yaml:"codes" jsonschema:"required,minItems=1,uniqueItems=true"`
+		// ContentType is the content type of the body, the default is
+		// text/html.
+		ContentType string `yaml:"contentType" jsonschema:"omitempty"`
+		// Body is a Go template, the values are escaped for HTML if the
+		// content type is HTML.
+		Body string `yaml:"body" jsonschema:"required"`
+	}
+
+	// errorPages maps status codes to the compiled error pages.
+	errorPages map[int]*errorPage
+
+	errorPage struct {
+		contentType string
+		template    bodyTemplate
+	}
+
+	bodyTemplate interface {
+		Execute(w io.Writer, data interface{}) error
+	}
+
+	// errorPageData is the data of the template of error pages.
+	errorPageData struct {
+		StatusCode int
+		StatusText string
+		Method     string
+		Host       string
+		Path       string
+	}
+)
+
+// Validate validates ErrorPage.
+func (ep *ErrorPage) Validate() error {
+	for _, code := range ep.Codes {
+		if code < 400 || code > 599 {
+			return fmt.Errorf("invalid code %d, only 4xx and 5xx are supported", code)
+		}
+	}
+	_, err := ep.compile()
+	return err
+}
+
+func (ep *ErrorPage) contentType() string {
+	if ep.ContentType == "" {
+		return defaultErrorPageContentType
+	}
+	return ep.ContentType
+}
+
+func (ep *ErrorPage) compile() (*errorPage, error) {
+	contentType := ep.contentType()
+
+	var t bodyTemplate
+	var err error
+	if strings.Contains(contentType, "html") {
+		t, err = htmltemplate.New("errorPage").Parse(ep.Body)
+	} else {
+		t, err = template.New("errorPage").Parse(ep.Body)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse body failed: %v", err)
+	}
+
+	return &errorPage{contentType: contentType, template: t}, nil
+}
+
+func validateErrorPages(pages []*ErrorPage) error {
+	codes := map[int]struct{}{}
+	for _, page := range pages {
+		if err := page.Validate(); err != nil {
+			return err
+		}
+		for _, code := range page.Codes {
+			if _, exists := codes[code]; exists {
+				return fmt.Errorf("code %d is duplicated", code)
+			}
+			codes[code] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func newErrorPages(pages []*ErrorPage) errorPages {
+	if len(pages) == 0 {
+		return nil
+	}
+
+	result := errorPages{}
+	for _, page := range pages {
+		ep, err := page.compile()
+		if err != nil {
+			logger.Errorf("BUG: compile error page failed: %v", err)
+			continue
+		}
+		for _, code := range page.Codes {
+			result[code] = ep
+		}
+	}
+	return result
+}
+
+// render replaces the bare response of the status code with the error
+// page, the responses having a body, e.g. the ones of the backends, are
+// kept as they are.
+func (eps errorPages) render(ctx context.HTTPContext) {
+	resp := ctx.Response()
+	ep := eps[resp.StatusCode()]
+	if ep == nil || resp.Body() != nil {
+		return
+	}
+
+	req := ctx.Request()
+	data := &errorPageData{
+		StatusCode: resp.StatusCode(),
+		StatusText: http.StatusText(resp.StatusCode()),
+		Method:     req.Method(),
+		Host:       req.Host(),
+		Path:       req.Path(),
+	}
+
+	buff := &bytes.Buffer{}
+	if err := ep.template.Execute(buff, data); err != nil {
+		logger.Errorf("render error page of %d failed: %v", data.StatusCode, err)
+		return
+	}
+
+	resp.Header().Set(httpheader.KeyContentType, ep.contentType)
+	resp.Header().Del(httpheader.KeyContentLength)
+	resp.SetBody(buff)
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestErrorPageValidate(t *testing.T) {
+	pages := []*ErrorPage{
+		{Codes: []int{404}, Body: "not found"},
+		{Codes: []int{502, 503}, ContentType: "application/json", Body: `{"code":{{.StatusCode}}}`},
+	}
+	if err := validateErrorPages(pages); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := [][]*ErrorPage{
+		{{Codes: []int{200}, Body: "ok"}},
+		{{Codes: []int{404}, Body: "{{.StatusCode"}},
+		{{Codes: []int{404}, Body: "a"}, {Codes: []int{503, 404}, Body: "b"}},
+	}
+	for i, pages := range invalid {
+		if err := validateErrorPages(pages); err == nil {
+			t.Errorf("case %d: expect error", i)
+		}
+	}
+}
+
+func TestErrorPageRender(t *testing.T) {
+	eps := newErrorPages([]*ErrorPage{
+		{Codes: []int{404}, Body: "<p>{{.Path}} {{.StatusText}}</p>"},
+		{Codes: []int{503}, ContentType: "text/plain", Body: "{{.Method}} {{.Path}}: {{.StatusCode}}"},
+	})
+
+	newCtx := func(code int, body io.Reader) (*contexttest.MockedHTTPContext, *httpheader.HTTPHeader, *io.Reader) {
+		ctx := &contexttest.MockedHTTPContext{}
+		respBody := body
+		header := httpheader.New(http.Header{})
+		ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+		ctx.MockedRequest.MockedHost = func() string { return "example.com" }
+		ctx.MockedRequest.MockedPath = func() string { return "/<a>" }
+		ctx.MockedResponse.MockedStatusCode = func() int { return code }
+		ctx.MockedResponse.MockedBody = func() io.Reader { return respBody }
+		ctx.MockedResponse.MockedSetBody = func(r io.Reader) { respBody = r }
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+		return ctx, header, &respBody
+	}
+
+	readBody := func(r io.Reader) string {
+		data, _ := io.ReadAll(r)
+		return string(data)
+	}
+
+	ctx, header, body := newCtx(http.StatusNotFound, nil)
+	eps.render(ctx)
+	if got := readBody(*body); got != "<p>/&lt;a&gt; Not Found</p>" {
+		t.Errorf("unexpected body: %s", got)
+	}
+	if header.Get(httpheader.KeyContentType) != defaultErrorPageContentType {
+		t.Errorf("unexpected content type: %s", header.Get(httpheader.KeyContentType))
+	}
+
+	ctx, header, body = newCtx(http.StatusServiceUnavailable, nil)
+	eps.render(ctx)
+	if got := readBody(*body); got != "GET /<a>: 503" {
+		t.Errorf("unexpected body: %s", got)
+	}
+	if header.Get(httpheader.KeyContentType) != "text/plain" {
+		t.Errorf("unexpected content type: %s", header.Get(httpheader.KeyContentType))
+	}
+
+	// The response with a body is kept.
+	ctx, _, body = newCtx(http.StatusServiceUnavailable, strings.NewReader("backend"))
+	eps.render(ctx)
+	if got := readBody(*body); got != "backend" {
+		t.Errorf("unexpected body: %s", got)
+	}
+
+	// The status code without error page is kept.
+	ctx, _, body = newCtx(http.StatusBadGateway, nil)
+	eps.render(ctx)
+	if *body != nil {
+		t.Errorf("unexpected body")
+	}
+}
@@ -0,0 +1,172 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// eventQueue delivers events to the FSM of the runtime. Sending to
+	// it never blocks: events are coalesced, as only the latest reload,
+	// the latest serve failure of every listener, the latest warm-up and
+	// one check matter, and events sent after the close are dropped.
+	eventQueue struct {
+		mutex  sync.Mutex
+		notify chan struct{}
+
+		closed bool
+		// pending is the number of pending events except the close event.
+		pending     int
+		closeEvent  *eventClose
+		serveFailed map[string]*eventServeFailed // listener -> event
+		reload      *eventReload
+		checkFailed *eventCheckFailed
+		warmedUp    *eventWarmedUp
+
+		enqueued  uint64
+		coalesced uint64
+		dropped   uint64
+		processed uint64
+	}
+
+	// EventQueueStatus contains the statistics of the FSM events.
+	EventQueueStatus struct {
+		Enqueued  uint64 `yaml:"enqueued"`
+		Coalesced uint64 `yaml:"coalesced"`
+		Dropped   uint64 `yaml:"dropped"`
+		Processed uint64 `yaml:"processed"`
+	}
+)
+
+func newEventQueue() *eventQueue {
+	return &eventQueue{
+		notify:      make(chan struct{}, 1),
+		serveFailed: map[string]*eventServeFailed{},
+	}
+}
+
+// send enqueues the event, it never blocks.
+func (q *eventQueue) send(e interface{}) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.closed {
+		atomic.AddUint64(&q.dropped, 1)
+		if e, ok := e.(*eventClose); ok {
+			close(e.done)
+		}
+		return
+	}
+
+	atomic.AddUint64(&q.enqueued, 1)
+	coalesced := false
+	switch e := e.(type) {
+	case *eventClose:
+		q.closed = true
+		q.closeEvent = e
+	case *eventServeFailed:
+		if prev := q.serveFailed[e.listener]; prev != nil {
+			coalesced = true
+			if prev.startNum > e.startNum {
+				e = prev
+			}
+		}
+		q.serveFailed[e.listener] = e
+	case *eventReload:
+		coalesced = q.reload != nil
+		q.reload = e
+	case *eventCheckFailed:
+		coalesced = q.checkFailed != nil
+		q.checkFailed = e
+	case *eventWarmedUp:
+		if q.warmedUp != nil {
+			coalesced = true
+			if q.warmedUp.warmUpNum > e.warmUpNum {
+				e = q.warmedUp
+			}
+		}
+		q.warmedUp = e
+	}
+	if coalesced {
+		atomic.AddUint64(&q.coalesced, 1)
+	} else if !q.closed {
+		q.pending++
+	}
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// next returns the next event to handle, or nil if there's none.
+// The close event goes first, as other events make no sense after it,
+// and the serve failure goes before the reload, as the reload may
+// restart the server.
+func (q *eventQueue) next() interface{} {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var e interface{}
+	switch {
+	case q.closeEvent != nil:
+		e = q.closeEvent
+		q.closeEvent = nil
+		atomic.AddUint64(&q.dropped, uint64(q.pending))
+		q.pending = 0
+		q.serveFailed = map[string]*eventServeFailed{}
+		q.reload, q.checkFailed, q.warmedUp = nil, nil, nil
+		atomic.AddUint64(&q.processed, 1)
+		return e
+	case len(q.serveFailed) != 0:
+		for p, sf := range q.serveFailed {
+			e = sf
+			delete(q.serveFailed, p)
+			break
+		}
+		q.pending--
+	case q.reload != nil:
+		e = q.reload
+		q.reload = nil
+		q.pending--
+	case q.checkFailed != nil:
+		e = q.checkFailed
+		q.checkFailed = nil
+		q.pending--
+	case q.warmedUp != nil:
+		e = q.warmedUp
+		q.warmedUp = nil
+		q.pending--
+	default:
+		return nil
+	}
+
+	atomic.AddUint64(&q.processed, 1)
+	return e
+}
+
+func (q *eventQueue) status() *EventQueueStatus {
+	return &EventQueueStatus{
+		Enqueued:  atomic.LoadUint64(&q.enqueued),
+		Coalesced: atomic.LoadUint64(&q.coalesced),
+		Dropped:   atomic.LoadUint64(&q.dropped),
+		Processed: atomic.LoadUint64(&q.processed),
+	}
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEventQueueCoalesce(t *testing.T) {
+	q := newEventQueue()
+
+	reload1, reload2 := &eventReload{}, &eventReload{}
+	q.send(&eventCheckFailed{})
+	q.send(reload1)
+	q.send(&eventServeFailed{listener: protocolTCP, startNum: 2})
+	q.send(&eventServeFailed{listener: protocolTCP, startNum: 1})
+	q.send(&eventServeFailed{listener: protocolQUIC, startNum: 1})
+	q.send(reload2)
+	q.send(&eventCheckFailed{})
+
+	// Serve failures of different listeners are not coalesced.
+	failed := map[string]uint64{}
+	for i := 0; i < 2; i++ {
+		e, ok := q.next().(*eventServeFailed)
+		if !ok {
+			t.Fatalf("expected serve failure, got %#v", e)
+		}
+		failed[e.listener] = e.startNum
+	}
+	if failed[protocolTCP] != 2 || failed[protocolQUIC] != 1 {
+		t.Fatalf("expected the latest serve failures, got %v", failed)
+	}
+	if e := q.next(); e != reload2 {
+		t.Fatalf("expected the latest reload, got %#v", e)
+	}
+	if _, ok := q.next().(*eventCheckFailed); !ok {
+		t.Fatalf("expected check failed")
+	}
+	if e := q.next(); e != nil {
+		t.Fatalf("expected no event, got %#v", e)
+	}
+
+	s := q.status()
+	if s.Enqueued != 7 || s.Coalesced != 3 || s.Processed != 4 || s.Dropped != 0 {
+		t.Errorf("unexpected status %+v", s)
+	}
+}
+
+func TestEventQueueClose(t *testing.T) {
+	q := newEventQueue()
+
+	q.send(&eventReload{})
+	done := make(chan struct{})
+	q.send(&eventClose{done: done})
+	q.send(&eventCheckFailed{})
+
+	if _, ok := q.next().(*eventClose); !ok {
+		t.Fatalf("expected the close event first")
+	}
+	if e := q.next(); e != nil {
+		t.Fatalf("expected no event after close, got %#v", e)
+	}
+	close(done)
+
+	// Closing again must not block.
+	done2 := make(chan struct{})
+	q.send(&eventClose{done: done2})
+	<-done2
+
+	s := q.status()
+	if s.Dropped != 3 {
+		t.Errorf("expected 3 dropped events, got %+v", s)
+	}
+}
+
+func TestEventQueueNeverBlocks(t *testing.T) {
+	q := newEventQueue()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				q.send(&eventCheckFailed{})
+				q.send(&eventReload{})
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("sending events blocked")
+	}
+
+	handled := 0
+	for range q.notify {
+		for e := q.next(); e != nil; e = q.next() {
+			handled++
+		}
+		break
+	}
+	if handled != 2 {
+		t.Errorf("expected 2 events handled, got %d", handled)
+	}
+}
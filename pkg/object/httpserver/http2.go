@@ -0,0 +1,103 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+const (
+	minHTTP2FrameSize = 1 << 14
+	maxHTTP2FrameSize = 1<<24 - 1
+)
+
+// HTTP2Spec describes the HTTP/2 options of the HTTPServer, HTTP/2 is
+// negotiated via ALPN for https, and served as h2c for http with h2c.
+type HTTP2Spec struct {
+	// Disabled disables HTTP/2, only HTTP/1.x is served.
+	Disabled bool `yaml:"disabled" jsonschema:"omitempty"`
+	// MaxConcurrentStreams is the max number of concurrent streams
+	// of a connection, zero means the default (250).
+	MaxConcurrentStreams uint32 `yaml:"maxConcurrentStreams" jsonschema:"omitempty"`
+	// MaxReadFrameSize is the max size of frames the server reads, zero
+	// means the default (1MB).
+	MaxReadFrameSize uint32 `yaml:"maxReadFrameSize" jsonschema:"omitempty"`
+}
+
+// Validate validates HTTP2Spec.
+func (spec *HTTP2Spec) Validate() error {
+	if spec.Disabled {
+		if spec.MaxConcurrentStreams != 0 || spec.MaxReadFrameSize != 0 {
+			return fmt.Errorf("options are specified while http2 is disabled")
+		}
+		return nil
+	}
+
+	if n := spec.MaxReadFrameSize; n != 0 && (n < minHTTP2FrameSize || n > maxHTTP2FrameSize) {
+		return fmt.Errorf("maxReadFrameSize must be in [%d, %d]", minHTTP2FrameSize, maxHTTP2FrameSize)
+	}
+
+	return nil
+}
+
+// configureHTTP2 configures HTTP/2 of the server, it must be called after
+// the TLS config of the server is set.
+func configureHTTP2(srv *http.Server, spec *HTTP2Spec) error {
+	if spec == nil {
+		// NOTE: The server enables HTTP/2 by default.
+		return nil
+	}
+
+	if spec.Disabled {
+		// NOTE: A non-nil empty map disables HTTP/2, and h2 must not be
+		// advertised via ALPN.
+		srv.TLSNextProto = map[string]func(*http.Server, *tls.Conn, http.Handler){}
+		return nil
+	}
+
+	return http2.ConfigureServer(srv, &http2.Server{
+		MaxConcurrentStreams: spec.MaxConcurrentStreams,
+		MaxReadFrameSize:     spec.MaxReadFrameSize,
+	})
+}
+
+// configureH2C serves HTTP/2 over cleartext TCP (h2c) besides HTTP/1.x,
+// both the upgrade from HTTP/1.1 and the prior knowledge are supported.
+// It must be called after the handler of the server is set.
+func configureH2C(srv *http.Server, spec *HTTP2Spec) error {
+	h2s := &http2.Server{}
+	if spec != nil {
+		h2s.MaxConcurrentStreams = spec.MaxConcurrentStreams
+		h2s.MaxReadFrameSize = spec.MaxReadFrameSize
+	}
+
+	// NOTE: h2c connections are hijacked from the server, configuring the
+	// server registers the graceful shutdown of them, so they are drained
+	// like the others when the server shuts down.
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return err
+	}
+	srv.Handler = h2c.NewHandler(srv.Handler, h2s)
+
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+func TestHTTP2SpecValidate(t *testing.T) {
+	valid := []*HTTP2Spec{
+		{},
+		{Disabled: true},
+		{MaxConcurrentStreams: 100, MaxReadFrameSize: 1 << 20},
+	}
+	for i, spec := range valid {
+		if err := spec.Validate(); err != nil {
+			t.Errorf("spec %d: unexpected error: %v", i, err)
+		}
+	}
+
+	invalid := []*HTTP2Spec{
+		{Disabled: true, MaxConcurrentStreams: 100},
+		{MaxReadFrameSize: 1024},
+		{MaxReadFrameSize: 1 << 24},
+	}
+	for i, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("spec %d: expect an error", i)
+		}
+	}
+}
+
+func TestConfigureHTTP2(t *testing.T) {
+	srv := &http.Server{TLSConfig: &tls.Config{}}
+	if err := configureHTTP2(srv, &HTTP2Spec{Disabled: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if srv.TLSNextProto == nil || len(srv.TLSNextProto) != 0 {
+		t.Errorf("http2 should be disabled")
+	}
+
+	srv = &http.Server{TLSConfig: &tls.Config{}}
+	if err := configureHTTP2(srv, &HTTP2Spec{MaxConcurrentStreams: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := srv.TLSNextProto["h2"]; !ok {
+		t.Errorf("http2 should be enabled")
+	}
+	found := false
+	for _, p := range srv.TLSConfig.NextProtos {
+		if p == "h2" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("h2 should be advertised via ALPN")
+	}
+}
+
+func TestConfigureH2C(t *testing.T) {
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}),
+	}
+	if err := configureH2C(srv, &HTTP2Spec{MaxConcurrentStreams: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ts := httptest.NewUnstartedServer(srv.Handler)
+	ts.Config = srv
+	ts.Start()
+	defer ts.Close()
+
+	// HTTP/2 with prior knowledge.
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+	for proto, c := range map[string]*http.Client{"HTTP/2.0": client, "HTTP/1.1": http.DefaultClient} {
+		resp, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", proto, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != proto {
+			t.Errorf("want %s, got %s", proto, body)
+		}
+	}
+}
@@ -65,20 +65,20 @@ func (hs *HTTPServer) Init(superSpec *supervisor.Spec, muxMapper protocol.MuxMap
 
 	hs.runtime = newRuntime(superSpec, muxMapper)
 
-	hs.runtime.eventChan <- &eventReload{
+	hs.runtime.events.send(&eventReload{
 		nextSuperSpec: superSpec,
 		muxMapper:     muxMapper,
-	}
+	})
 }
 
 // Inherit inherits previous generation of HTTPServer.
 func (hs *HTTPServer) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object, muxMapper protocol.MuxMapper) {
 	hs.runtime = previousGeneration.(*HTTPServer).runtime
 
-	hs.runtime.eventChan <- &eventReload{
+	hs.runtime.events.send(&eventReload{
 		nextSuperSpec: superSpec,
 		muxMapper:     muxMapper,
-	}
+	})
 }
 
 // Status is the wrapper of runtime's Status.
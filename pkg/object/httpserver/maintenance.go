@@ -0,0 +1,224 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+type (
+	// MaintenanceSpec describes the maintenance mode, in which the server
+	// answers all requests with 503 while keeping the listeners up.
+	MaintenanceSpec struct {
+		// Enabled turns on the maintenance mode, which could also be
+		// turned on by the admin API without updating the spec.
+		Enabled bool `yaml:"enabled" jsonschema:"omitempty"`
+		// RetryAfter is the value of the Retry-After header.
+		RetryAfter  string `yaml:"retryAfter" jsonschema:"omitempty,format=duration"`
+		ContentType string `yaml:"contentType" jsonschema:"omitempty"`
+		Body        string `yaml:"body" jsonschema:"omitempty"`
+	}
+
+	// Maintenance marks an HTTPServer in maintenance by the admin API.
+	Maintenance struct {
+		Server string    `yaml:"server" jsonschema:"required"`
+		Reason string    `yaml:"reason" jsonschema:"omitempty"`
+		Since  time.Time `yaml:"since" jsonschema:"omitempty"`
+	}
+
+	// MaintenanceStatus is the status of the maintenance mode.
+	MaintenanceStatus struct {
+		// Source is spec or api, telling how the mode is turned on.
+		Source string `yaml:"source"`
+		Reason string `yaml:"reason,omitempty"`
+		Since  string `yaml:"since,omitempty"`
+	}
+
+	// maintenanceFlag syncs the maintenance flag of the server set by the
+	// admin API from the cluster.
+	maintenanceFlag struct {
+		cls         cluster.Cluster
+		key         string
+		maintenance atomic.Value // *Maintenance
+		done        chan struct{}
+	}
+)
+
+// Validate validates MaintenanceSpec.
+func (spec *MaintenanceSpec) Validate() error {
+	if spec.RetryAfter == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(spec.RetryAfter)
+	if err != nil {
+		return fmt.Errorf("invalid retryAfter: %v", err)
+	}
+	if d < time.Second {
+		return fmt.Errorf("retryAfter must be at least 1s")
+	}
+	return nil
+}
+
+// retryAfter returns the value of the Retry-After header in seconds, or
+// empty if it's not set.
+func (spec *MaintenanceSpec) retryAfter() string {
+	if spec == nil || spec.RetryAfter == "" {
+		return ""
+	}
+	d, _ := time.ParseDuration(spec.RetryAfter)
+	return strconv.Itoa(int(d / time.Second))
+}
+
+// Validate validates Maintenance.
+func (m *Maintenance) Validate() error {
+	if strings.TrimSpace(m.Server) == "" {
+		return fmt.Errorf("server is required")
+	}
+	return nil
+}
+
+func newMaintenanceFlag(cls cluster.Cluster, serverName string) *maintenanceFlag {
+	mf := &maintenanceFlag{
+		cls:  cls,
+		key:  cls.Layout().MaintenanceKey(serverName),
+		done: make(chan struct{}),
+	}
+	mf.maintenance.Store((*Maintenance)(nil))
+
+	go mf.sync()
+
+	return mf
+}
+
+func (mf *maintenanceFlag) sync() {
+	var (
+		syncer *cluster.Syncer
+		err    error
+		ch     <-chan *string
+	)
+
+	for {
+		syncer, err = mf.cls.Syncer(time.Minute)
+		if err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.Sync(mf.key); err != nil {
+			logger.Errorf("failed to sync %s: %v", mf.key, err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-mf.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case <-mf.done:
+			return
+		case value := <-ch:
+			mf.apply(value)
+		}
+	}
+}
+
+func (mf *maintenanceFlag) apply(value *string) {
+	if value == nil {
+		mf.maintenance.Store((*Maintenance)(nil))
+		return
+	}
+
+	m := &Maintenance{}
+	if err := yaml.Unmarshal([]byte(*value), m); err != nil {
+		logger.Errorf("unmarshal maintenance %s failed: %v", mf.key, err)
+		return
+	}
+	mf.maintenance.Store(m)
+}
+
+// get returns the maintenance set by the admin API, or nil if there is
+// not.
+func (mf *maintenanceFlag) get() *Maintenance {
+	if mf == nil {
+		return nil
+	}
+	return mf.maintenance.Load().(*Maintenance)
+}
+
+func (mf *maintenanceFlag) close() {
+	if mf != nil {
+		close(mf.done)
+	}
+}
+
+// maintenanceStatus returns the status of the maintenance mode, or nil
+// if the server is not in maintenance.
+func (m *mux) maintenanceStatus() *MaintenanceStatus {
+	spec := m.rules.Load().(*muxRules).spec.Maintenance
+	if maintenance := m.maintenance.get(); maintenance != nil {
+		s := &MaintenanceStatus{Source: "api", Reason: maintenance.Reason}
+		if !maintenance.Since.IsZero() {
+			s.Since = maintenance.Since.Format(time.RFC3339)
+		}
+		return s
+	}
+	if spec != nil && spec.Enabled {
+		return &MaintenanceStatus{Source: "spec"}
+	}
+	return nil
+}
+
+// inMaintenance returns whether the server is in maintenance.
+func (m *mux) inMaintenance(spec *MaintenanceSpec) bool {
+	return (spec != nil && spec.Enabled) || m.maintenance.get() != nil
+}
+
+// handleMaintenance answers the request with 503, the response without
+// a body could be replaced by the error page of 503.
+func (m *mux) handleMaintenance(ctx context.HTTPContext, spec *MaintenanceSpec) {
+	ctx.AddTag("server in maintenance")
+	resp := ctx.Response()
+	resp.SetStatusCode(http.StatusServiceUnavailable)
+	if retryAfter := spec.retryAfter(); retryAfter != "" {
+		resp.Header().Set("Retry-After", retryAfter)
+	}
+	if spec != nil && spec.Body != "" {
+		contentType := spec.ContentType
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		resp.Header().Set(httpheader.KeyContentType, contentType)
+		resp.SetBody(strings.NewReader(spec.Body))
+	}
+}
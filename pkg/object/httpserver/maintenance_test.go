@@ -0,0 +1,107 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestMaintenanceSpec(t *testing.T) {
+	spec := &MaintenanceSpec{RetryAfter: "5m"}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if spec.retryAfter() != "300" {
+		t.Errorf("want retry after 300, got %s", spec.retryAfter())
+	}
+	if (*MaintenanceSpec)(nil).retryAfter() != "" {
+		t.Errorf("retry after of nil spec should be empty")
+	}
+
+	for _, d := range []string{"abc", "100ms"} {
+		if err := (&MaintenanceSpec{RetryAfter: d}).Validate(); err == nil {
+			t.Errorf("expect error for %s", d)
+		}
+	}
+
+	if err := (&Maintenance{}).Validate(); err == nil {
+		t.Errorf("expect error for empty server")
+	}
+}
+
+func TestMaintenanceFlag(t *testing.T) {
+	m := &mux{}
+	m.rules.Store(&muxRules{spec: &Spec{}})
+	if m.inMaintenance(nil) || m.maintenanceStatus() != nil {
+		t.Errorf("should not be in maintenance")
+	}
+
+	m.rules.Store(&muxRules{spec: &Spec{Maintenance: &MaintenanceSpec{Enabled: true}}})
+	if status := m.maintenanceStatus(); status == nil || status.Source != "spec" {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	mf := &maintenanceFlag{key: "test"}
+	mf.maintenance.Store((*Maintenance)(nil))
+	m.maintenance = mf
+	m.rules.Store(&muxRules{spec: &Spec{}})
+
+	value := "server: demo\nreason: upgrade\n"
+	mf.apply(&value)
+	if !m.inMaintenance(nil) {
+		t.Errorf("should be in maintenance")
+	}
+	if status := m.maintenanceStatus(); status == nil || status.Source != "api" || status.Reason != "upgrade" {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	mf.apply(nil)
+	if m.inMaintenance(nil) {
+		t.Errorf("should not be in maintenance")
+	}
+}
+
+func TestHandleMaintenance(t *testing.T) {
+	ctx := &contexttest.MockedHTTPContext{}
+
+	var respBody io.Reader
+	statusCode := 0
+	header := httpheader.New(http.Header{})
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) { respBody = r }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+	spec := &MaintenanceSpec{Enabled: true, RetryAfter: "1m", Body: `{"message":"maintenance"}`, ContentType: "application/json"}
+	(&mux{}).handleMaintenance(ctx, spec)
+
+	if statusCode != http.StatusServiceUnavailable {
+		t.Errorf("want 503, got %d", statusCode)
+	}
+	if header.Get("Retry-After") != "60" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected header: %v", header.Std())
+	}
+	data, _ := io.ReadAll(respBody)
+	if string(data) != spec.Body {
+		t.Errorf("unexpected body: %s", data)
+	}
+}
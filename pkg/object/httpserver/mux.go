@@ -18,12 +18,19 @@
 package httpserver
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/megaease/easegress/pkg/object/globalfilter"
 
@@ -33,22 +40,75 @@ import (
 	"github.com/megaease/easegress/pkg/protocol"
 	"github.com/megaease/easegress/pkg/supervisor"
 	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/clientip"
+	"github.com/megaease/easegress/pkg/util/fasttime"
+	"github.com/megaease/easegress/pkg/util/http1compat"
 	"github.com/megaease/easegress/pkg/util/httpheader"
 	"github.com/megaease/easegress/pkg/util/httpstat"
 	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/resourcestat"
 	"github.com/megaease/easegress/pkg/util/stringtool"
 	"github.com/megaease/easegress/pkg/util/topn"
 )
 
 type (
 	mux struct {
-		httpStat *httpstat.HTTPStat
-		topN     *topn.TopN
+		// NOTE: They are accessed atomically, keep them 64-bit aligned.
+		generation     uint64
+		inFlight       int64
+		webSocketConns int64
+
+		httpStat     *httpstat.HTTPStat
+		topN         *topn.TopN
+		routeStat    *routeStat
+		sloStat      *sloStat
+		consumerStat *consumerStat
+		workerPool   *workerPool
+		connTracker  *connTracker
+		// maintenance is the maintenance flag set by the admin API, it's
+		// nil if the cluster is unavailable.
+		maintenance *maintenanceFlag
+
+		// accounting tells whether the resource sampler is acquired,
+		// it's only accessed by the runtime.
+		accounting bool
 
 		rules atomic.Value // *muxRules
-	}
-
+		// reloadErr is the error of the last reload which failed to
+		// compile the rules, so the previous rules are kept.
+		reloadErr atomic.Value // string
+	}
+
+	// MuxStatus contains the status of the routing table, which tells
+	// the version of the config being served.
+	MuxStatus struct {
+		Generation uint64 `yaml:"generation"`
+		// SpecHash is the hash of the spec of the rules.
+		SpecHash string `yaml:"specHash,omitempty"`
+		LoadedAt string `yaml:"loadedAt,omitempty"`
+		// Error is the error of the last reload, the previous generation
+		// keeps serving if the rules fail to compile.
+		Error string `yaml:"error,omitempty"`
+	}
+
+	// muxRules is an immutable snapshot of the routing table, reloading
+	// builds a new one and swaps it atomically, so a request always sees
+	// a complete rule set from the beginning to the end.
 	muxRules struct {
+		// refs is the number of requests using the snapshot, the
+		// resources of a retired snapshot are released when it drops
+		// to zero.
+		refs        int64
+		retired     int32
+		releaseOnce sync.Once
+		releaseFunc func()
+
+		// generation increases by one on every reload.
+		generation uint64
+		specHash   string
+		loadedAt   time.Time
+		errs       []string
+
 		superSpec *supervisor.Spec
 		spec      *Spec
 
@@ -59,6 +119,11 @@ type (
 		tracer       *tracing.Tracing
 		ipFilter     *ipfilter.IPFilter
 		ipFilterChan *ipfilter.IPFilters
+		errorPages   errorPages
+		respHeaders  responseHeaders
+		clientIP     *clientip.Resolver
+		clientCert   *clientCertForwarder
+		regexps      *regexps
 
 		rules []*muxRule
 	}
@@ -77,14 +142,30 @@ type (
 		ipFilter      *ipfilter.IPFilter
 		ipFilterChain *ipfilter.IPFilters
 
-		path          string
-		pathPrefix    string
-		pathRegexp    string
-		pathRE        *regexp.Regexp
-		methods       []string
-		rewriteTarget string
-		backend       string
-		headers       []*Header
+		path       string
+		pathPrefix string
+		pathRegexp string
+		pathRE     *regexp.Regexp
+		// pathParamNames are the names of the parameters of the path
+		// template, whose regexp is pathRE.
+		pathParamNames []string
+		methods        []string
+		rewriteTarget  string
+		backend        string
+		headers        []*Header
+		ja3            []string
+		ja4            []string
+		httpStat       *httpstat.HTTPStat
+		slo            *sloTracker
+		// route is the name of the route, for the debug mode.
+		route string
+		// requireAuth requires requests to have an authenticated identity.
+		requireAuth bool
+		// priorityClass is the priority class in the worker pool.
+		priorityClass string
+		// timeouts overrides the timeouts of the server, it's nil if
+		// there's no override.
+		timeouts *routeTimeouts
 	}
 )
 
@@ -146,16 +227,17 @@ func (mr *muxRules) putCacheItem(ctx context.HTTPContext, ci *cacheItem) {
 	mr.cache.put(key, ci)
 }
 
-func newMuxRule(parentIPFilters *ipfilter.IPFilters, rule *Rule, paths []*muxPath) *muxRule {
+func newMuxRule(parentIPFilters *ipfilter.IPFilters, rule *Rule, paths []*muxPath, res *regexps) (*muxRule, error) {
 	var hostRE *regexp.Regexp
+	var err error
 
 	if rule.HostRegexp != "" {
-		var err error
-		hostRE, err = regexp.Compile(rule.HostRegexp)
+		hostRE, err = res.compile(rule.HostRegexp)
 		// defensive programming
 		if err != nil {
 			logger.Errorf("BUG: compile %s failed: %v",
 				rule.HostRegexp, err)
+			err = fmt.Errorf("compile host regexp %s failed: %v", rule.HostRegexp, err)
 		}
 	}
 
@@ -167,7 +249,7 @@ func newMuxRule(parentIPFilters *ipfilter.IPFilters, rule *Rule, paths []*muxPat
 		hostRegexp: rule.HostRegexp,
 		hostRE:     hostRE,
 		paths:      paths,
-	}
+	}, err
 }
 
 func (mr *muxRule) pass(ctx context.HTTPContext) bool {
@@ -198,35 +280,54 @@ func (mr *muxRule) match(ctx context.HTTPContext) bool {
 	return false
 }
 
-func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path) *muxPath {
+func newMuxPath(parentIPFilters *ipfilter.IPFilters, path *Path, res *regexps) (*muxPath, error) {
 	var pathRE *regexp.Regexp
-	if path.PathRegexp != "" {
-		var err error
-		pathRE, err = regexp.Compile(path.PathRegexp)
+	var pathParamNames []string
+	var err error
+	exactPath := path.Path
+	if isPathTemplate(path.Path) {
+		exactPath = ""
+		pathRE, pathParamNames, err = compilePathTemplate(path.Path, res)
+		// defensive programming
+		if err != nil {
+			logger.Errorf("BUG: compile path template %s failed: %v", path.Path, err)
+			err = fmt.Errorf("compile path template %s failed: %v", path.Path, err)
+		}
+	} else if path.PathRegexp != "" {
+		pathRE, err = res.compile(path.PathRegexp)
 		// defensive programming
 		if err != nil {
 			logger.Errorf("BUG: compile %s failed: %v",
 				path.PathRegexp, err)
+			err = fmt.Errorf("compile path regexp %s failed: %v", path.PathRegexp, err)
 		}
 	}
 
 	for _, p := range path.Headers {
-		p.initHeaderRoute()
+		// defensive programming
+		if e := p.initHeaderRoute(res); e != nil {
+			logger.Errorf("BUG: compile %s failed: %v", p.Regexp, e)
+			err = fmt.Errorf("compile header regexp %s failed: %v", p.Regexp, e)
+		}
 	}
 
 	return &muxPath{
 		ipFilter:      newIPFilter(path.IPFilter),
 		ipFilterChain: newIPFilterChain(parentIPFilters, path.IPFilter),
 
-		path:          path.Path,
-		pathPrefix:    path.PathPrefix,
-		pathRegexp:    path.PathRegexp,
-		pathRE:        pathRE,
-		rewriteTarget: path.RewriteTarget,
-		methods:       path.Methods,
-		backend:       path.Backend,
-		headers:       path.Headers,
-	}
+		path:           exactPath,
+		pathPrefix:     path.PathPrefix,
+		pathRegexp:     path.PathRegexp,
+		pathRE:         pathRE,
+		pathParamNames: pathParamNames,
+		rewriteTarget:  path.RewriteTarget,
+		methods:        path.Methods,
+		backend:        path.Backend,
+		headers:        path.Headers,
+		ja3:            path.JA3,
+		ja4:            path.JA4,
+		priorityClass:  path.PriorityClass,
+	}, err
 }
 
 func (mp *muxPath) pass(ctx context.HTTPContext) bool {
@@ -269,13 +370,34 @@ func (mp *muxPath) hasHeaders() bool {
 	return len(mp.headers) > 0
 }
 
+func (mp *muxPath) hasTLSFingerprints() bool {
+	return len(mp.ja3) > 0 || len(mp.ja4) > 0
+}
+
+func (mp *muxPath) matchTLSFingerprints(ctx context.HTTPContext) bool {
+	fp := ctx.Request().TLSFingerprint()
+	if fp == nil {
+		return false
+	}
+
+	return stringtool.StrInSlice(fp.JA3, mp.ja3) || stringtool.StrInSlice(fp.JA4, mp.ja4)
+}
+
 func (mp *muxPath) matchHeaders(ctx context.HTTPContext) bool {
 	for _, h := range mp.headers {
+		if h.Exists && len(ctx.Request().Header().GetAll(h.Key)) > 0 {
+			return true
+		}
+
 		v := ctx.Request().Header().Get(h.Key)
 		if stringtool.StrInSlice(v, h.Values) {
 			return true
 		}
 
+		if h.Prefix != "" && strings.HasPrefix(v, h.Prefix) {
+			return true
+		}
+
 		if h.Regexp != "" && h.headerRE.MatchString(v) {
 			return true
 		}
@@ -284,10 +406,18 @@ func (mp *muxPath) matchHeaders(ctx context.HTTPContext) bool {
 	return false
 }
 
-func newMux(httpStat *httpstat.HTTPStat, topN *topn.TopN, mapper protocol.MuxMapper) *mux {
+func newMux(httpStat *httpstat.HTTPStat, topN *topn.TopN, routeStat *routeStat,
+	sloStat *sloStat, consumerStat *consumerStat, connTracker *connTracker,
+	mapper protocol.MuxMapper) *mux {
+
 	m := &mux{
-		httpStat: httpStat,
-		topN:     topN,
+		httpStat:     httpStat,
+		topN:         topN,
+		routeStat:    routeStat,
+		sloStat:      sloStat,
+		consumerStat: consumerStat,
+		workerPool:   newWorkerPool(),
+		connTracker:  connTracker,
 	}
 
 	m.rules.Store(&muxRules{
@@ -302,57 +432,205 @@ func newMux(httpStat *httpstat.HTTPStat, topN *topn.TopN, mapper protocol.MuxMap
 func (m *mux) reloadRules(superSpec *supervisor.Spec, muxMapper protocol.MuxMapper) {
 	spec := superSpec.ObjectSpec().(*Spec)
 
-	tracer := tracing.NoopTracing
+	var errs []string
 	oldRules := m.rules.Load().(*muxRules)
+
+	rules := &muxRules{
+		specHash:     specHash(superSpec),
+		loadedAt:     time.Now(),
+		superSpec:    superSpec,
+		spec:         spec,
+		muxMapper:    muxMapper,
+		ipFilter:     newIPFilter(spec.IPFilter),
+		ipFilterChan: newIPFilterChain(nil, spec.IPFilter),
+		errorPages:   newErrorPages(spec.ErrorPages),
+		respHeaders:  newResponseHeaders(spec.ResponseHeaders),
+		clientCert:   newClientCertForwarder(spec.ForwardClientCert),
+		regexps:      newRegexps(oldRules.regexps),
+		rules:        make([]*muxRule, 0, len(spec.Rules)),
+	}
+
+	if spec.CacheSize > 0 {
+		rules.cache = newCache(spec.CacheSize)
+	}
+	if spec.ClientIP != nil {
+		rules.clientIP = clientip.New(spec.ClientIP)
+	}
+
+	routes, sloRoutes := map[string]struct{}{}, map[string]struct{}{}
+	for _, specRule := range spec.Rules {
+		ruleIPFilterChain := newIPFilterChain(rules.ipFilterChan, specRule.IPFilter)
+
+		paths := make([]*muxPath, 0, len(specRule.Paths))
+		for _, specPath := range specRule.Paths {
+			path, err := newMuxPath(ruleIPFilterChain, specPath, rules.regexps)
+			if err != nil {
+				errs = append(errs, err.Error())
+				continue
+			}
+
+			route := routeName(specRule, specPath)
+			routes[route] = struct{}{}
+			path.route = route
+			path.httpStat = m.routeStat.get(route)
+			path.requireAuth = specPath.RequireAuth || (spec.RequireAuth && !specPath.AllowAnonymous)
+			path.timeouts = newRouteTimeouts(specRule.Timeouts, specPath.Timeouts)
+
+			if slo := specPath.SLO; slo != nil {
+				sloRoutes[route] = struct{}{}
+				path.slo = m.sloStat.get(superSpec.Name(), route, slo)
+			}
+			paths = append(paths, path)
+		}
+
+		// NOTE: Given the parent ipFilters not its own.
+		rule, err := newMuxRule(rules.ipFilterChan, specRule, paths, rules.regexps)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		rules.rules = append(rules.rules, rule)
+	}
+
+	// NOTE: The previous rules keep serving if the new ones fail to
+	// compile, rather than serving a part of them.
+	if len(errs) != 0 {
+		err := stringtool.Cat("rules failed to compile, keep serving generation ",
+			strconv.FormatUint(oldRules.generation, 10), ": ", strings.Join(errs, "; "))
+		logger.Errorf("reload rules of %s failed: %s", superSpec.Name(), err)
+		m.reloadErr.Store(err)
+		return
+	}
+	m.reloadErr.Store("")
+
+	tracer := tracing.NoopTracing
+	var releaseOld func()
 	if !reflect.DeepEqual(oldRules.spec.Tracing, spec.Tracing) {
-		defer func() {
-			err := oldRules.tracer.Close()
+		// NOTE: The old tracer is closed after all requests using the
+		// old rules finish.
+		oldTracer := oldRules.tracer
+		releaseOld = func() {
+			err := oldTracer.Close()
 			if err != nil {
 				logger.Errorf("close tracing failed: %v", err)
 			}
-		}()
+		}
 		tracer0, err := tracing.New(spec.Tracing)
 		if err != nil {
 			logger.Errorf("create tracing failed: %v", err)
+			errs = append(errs, fmt.Sprintf("create tracing failed: %v", err))
 		} else {
 			tracer = tracer0
 		}
 	} else if oldRules.tracer != nil {
 		tracer = oldRules.tracer
 	}
+	rules.generation = atomic.AddUint64(&m.generation, 1)
+	rules.tracer = tracer
 
-	rules := &muxRules{
-		superSpec:    superSpec,
-		spec:         spec,
-		muxMapper:    muxMapper,
-		ipFilter:     newIPFilter(spec.IPFilter),
-		ipFilterChan: newIPFilterChain(nil, spec.IPFilter),
-		rules:        make([]*muxRule, len(spec.Rules)),
-		tracer:       tracer,
+	m.routeStat.retain(routes)
+	m.sloStat.retain(sloRoutes)
+	if spec.MaxConsumerStats == 0 {
+		m.consumerStat.reset()
 	}
+	m.setAccounting(superSpec.Name(), spec.ResourceAccounting)
+	m.workerPool.reload(spec.WorkerPool)
+	rules.errs = errs
+	rules.regexps.done()
 
-	if spec.CacheSize > 0 {
-		rules.cache = newCache(spec.CacheSize)
+	m.rules.Store(rules)
+	oldRules.retire(releaseOld)
+}
+
+// setAccounting acquires or releases the resource sampler according to
+// the switch of the resource accounting.
+func (m *mux) setAccounting(server string, enabled bool) {
+	if m.accounting == enabled {
+		return
+	}
+	m.accounting = enabled
+	if enabled {
+		resourcestat.Acquire()
+	} else {
+		resourcestat.Release()
+		resourcestat.RemoveServer(server)
 	}
+}
 
-	for i := 0; i < len(rules.rules); i++ {
-		specRule := spec.Rules[i]
+// acquireRules returns the current rules, which must be released by
+// the caller after using.
+func (m *mux) acquireRules() *muxRules {
+	for {
+		rules := m.rules.Load().(*muxRules)
+		atomic.AddInt64(&rules.refs, 1)
+		// NOTE: The rules may be retired between loading and referencing,
+		// in which case the resources may have been released.
+		if m.rules.Load().(*muxRules) == rules {
+			return rules
+		}
+		rules.release()
+	}
+}
 
-		ruleIPFilterChain := newIPFilterChain(rules.ipFilterChan, specRule.IPFilter)
+func (mr *muxRules) release() {
+	if atomic.AddInt64(&mr.refs, -1) == 0 && atomic.LoadInt32(&mr.retired) == 1 {
+		mr.releaseResources()
+	}
+}
 
-		paths := make([]*muxPath, len(specRule.Paths))
-		for j := 0; j < len(paths); j++ {
-			paths[j] = newMuxPath(ruleIPFilterChain, specRule.Paths[j])
+// retire marks the rules retired, fn is called to release resources
+// once no request uses the rules.
+func (mr *muxRules) retire(fn func()) {
+	mr.releaseFunc = fn
+	atomic.StoreInt32(&mr.retired, 1)
+	if atomic.LoadInt64(&mr.refs) == 0 {
+		mr.releaseResources()
+	}
+}
+
+func (mr *muxRules) releaseResources() {
+	mr.releaseOnce.Do(func() {
+		if mr.releaseFunc != nil {
+			mr.releaseFunc()
 		}
+	})
+}
 
-		// NOTE: Given the parent ipFilters not its own.
-		rules.rules[i] = newMuxRule(rules.ipFilterChan, specRule, paths)
+// specHash returns the hash of the spec, which identifies the version
+// of the config.
+func specHash(superSpec *supervisor.Spec) string {
+	sum := sha256.Sum256([]byte(superSpec.YAMLConfig()))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (m *mux) status() *MuxStatus {
+	rules := m.rules.Load().(*muxRules)
+	s := &MuxStatus{
+		Generation: rules.generation,
+		SpecHash:   rules.specHash,
+		Error:      strings.Join(rules.errs, "; "),
+	}
+	if err, _ := m.reloadErr.Load().(string); err != "" {
+		s.Error = err
+	}
+	if !rules.loadedAt.IsZero() {
+		s.LoadedAt = rules.loadedAt.Format(time.RFC3339)
 	}
+	return s
+}
 
-	m.rules.Store(rules)
+func (m *mux) resourceStatus() []*resourcestat.Status {
+	rules := m.rules.Load().(*muxRules)
+	if !rules.spec.ResourceAccounting {
+		return nil
+	}
+	return resourcestat.ServerStatus(rules.superSpec.Name())
 }
 
 func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
+	atomic.AddInt64(&m.inFlight, 1)
+	defer atomic.AddInt64(&m.inFlight, -1)
+
 	// HTTP-01 challenges requires HTTP server to listen on port 80, but we don't
 	// know which HTTP server listen on this port (consider there's an nginx sitting
 	// in front of Easegress), so all HTTP servers need to handle HTTP-01 challenges.
@@ -361,15 +639,81 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		return
 	}
 
-	rules := m.rules.Load().(*muxRules)
+	rules := m.acquireRules()
+	defer rules.release()
+
+	// NOTE: It must be called for every request to keep the order.
+	stdr = http1compat.BindRequest(stdr)
+
+	var body *limitedBody
+	if limit := rules.spec.MaxRequestBodySize; limit > 0 && stdr.ContentLength < 0 {
+		body = newLimitedBody(stdr.Body, limit)
+		stdr.Body = body
+	}
+
+	var upgrade *upgradeResponseWriter
+	if isWebSocketUpgrade(stdr) {
+		upgrade = &upgradeResponseWriter{ResponseWriter: stdw}
+		stdw = upgrade
+	}
 
 	ctx := context.New(stdw, stdr, rules.tracer, rules.superSpec.Name())
 	defer ctx.Finish()
+	// NOTE: It must be resolved before anything using the client address,
+	// e.g. the IP filters and the access log.
+	if rules.clientIP != nil {
+		ctx.Request().SetRealIP(rules.clientIP.Resolve(stdr))
+	}
+	if rules.clientCert != nil {
+		rules.clientCert.forward(ctx)
+	}
+	// NOTE: It must be called before ctx.Finish, so it's deferred after.
+	if info := startDebug(rules.spec.Debug, ctx); info != nil {
+		defer writeDebugHeaders(ctx, info)
+	}
 	ctx.OnFinish(func() {
 		ctx.Span().Finish()
 		m.httpStat.Stat(ctx.StatMetric())
 		m.topN.Stat(ctx)
 	})
+	if rules.respHeaders != nil {
+		defer rules.respHeaders.inject(ctx)
+	}
+	// NOTE: It must be deferred before the handling of too large bodies,
+	// so it's called after.
+	if rules.errorPages != nil {
+		defer rules.errorPages.render(ctx)
+	}
+
+	if m.inMaintenance(rules.spec.Maintenance) {
+		m.handleMaintenance(ctx, rules.spec.Maintenance)
+		return
+	}
+
+	if fp := ctx.Request().TLSFingerprint(); fp != nil {
+		ctx.AddTag(stringtool.Cat("ja3: ", fp.JA3, ", ja4: ", fp.JA4))
+	}
+
+	// NOTE: The body exceeding the limit is rejected after the handling
+	// by the ones reading it.
+	if body != nil {
+		defer func() {
+			if body.exceeded {
+				m.handleBodyTooLarge(ctx)
+			}
+		}()
+	} else if limit := rules.spec.MaxRequestBodySize; limit > 0 && stdr.ContentLength > limit {
+		m.handleBodyTooLarge(ctx)
+		return
+	}
+
+	if upgrade != nil {
+		if !m.acquireWebSocket(rules.spec.WebSocket) {
+			m.handleTooManyWebSockets(ctx)
+			return
+		}
+		defer m.releaseWebSocket()
+	}
 
 	ci := rules.getCacheItem(ctx)
 	if ci != nil {
@@ -382,6 +726,10 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 		return
 	}
 
+	// NOTE: The result is not cached once a path matching headers or TLS
+	// fingerprints is checked, as it depends on more than the cache key,
+	// e.g. a canary path is skipped by the cached result otherwise.
+	conditional := false
 	for _, host := range rules.rules {
 		if !host.match(ctx) {
 			continue
@@ -399,7 +747,9 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 
 			if !path.matchMethod(ctx) {
 				ci = &cacheItem{ipFilterChan: path.ipFilterChain, methodNotAllowed: true}
-				rules.putCacheItem(ctx, ci)
+				if !conditional {
+					rules.putCacheItem(ctx, ci)
+				}
 				m.handleRequestWithCache(rules, ctx, ci)
 				return
 			}
@@ -409,15 +759,23 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 				return
 			}
 
-			if !path.hasHeaders() {
+			if !path.hasHeaders() && !path.hasTLSFingerprints() {
 				ci = &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
-				rules.putCacheItem(ctx, ci)
+				if !conditional {
+					rules.putCacheItem(ctx, ci)
+				}
 				m.handleRequestWithCache(rules, ctx, ci)
 				return
 			}
 
-			if path.matchHeaders(ctx) {
-				// NOTE: No cache for the request matching headers.
+			conditional = true
+
+			if path.hasHeaders() && !path.matchHeaders(ctx) {
+				continue
+			}
+
+			if !path.hasTLSFingerprints() || path.matchTLSFingerprints(ctx) {
+				// NOTE: No cache for the request matching headers or TLS fingerprints.
 				ci = &cacheItem{ipFilterChan: path.ipFilterChain, path: path}
 				m.handleRequestWithCache(rules, ctx, ci)
 				return
@@ -426,10 +784,17 @@ func (m *mux) ServeHTTP(stdw http.ResponseWriter, stdr *http.Request) {
 	}
 
 	ci = &cacheItem{ipFilterChan: rules.ipFilterChan, notFound: true}
-	rules.putCacheItem(ctx, ci)
+	if !conditional {
+		rules.putCacheItem(ctx, ci)
+	}
 	m.handleRequestWithCache(rules, ctx, ci)
 }
 
+// inFlightRequests returns the number of requests being served.
+func (m *mux) inFlightRequests() int64 {
+	return atomic.LoadInt64(&m.inFlight)
+}
+
 func (m *mux) handleIPNotAllow(ctx context.HTTPContext) {
 	ctx.AddTag(stringtool.Cat("ip ", ctx.Request().RealIP(), " not allow"))
 	ctx.Response().SetStatusCode(http.StatusForbidden)
@@ -449,6 +814,22 @@ func (m *mux) handleRequestWithCache(rules *muxRules, ctx context.HTTPContext, c
 	case ci.methodNotAllowed:
 		ctx.Response().SetStatusCode(http.StatusMethodNotAllowed)
 	case ci.path != nil:
+		if info := context.GetDebugInfo(ctx); info != nil {
+			info.SetRoute(ci.path.route, ci.path.backend)
+		}
+
+		if httpStat, slo := ci.path.httpStat, ci.path.slo; httpStat != nil || slo != nil {
+			ctx.OnFinish(func() {
+				metric := ctx.StatMetric()
+				if httpStat != nil {
+					httpStat.Stat(metric)
+				}
+				if slo != nil {
+					slo.record(metric.StatusCode, metric.Duration, fasttime.Now())
+				}
+			})
+		}
+
 		handler, exists := rules.muxMapper.GetHandler(ci.path.backend)
 		if !exists {
 			ctx.AddTag(stringtool.Cat("backend ", ci.path.backend, " not found"))
@@ -460,21 +841,99 @@ func (m *mux) handleRequestWithCache(rules *muxRules, ctx context.HTTPContext, c
 			m.appendXForwardedFor(ctx)
 		}
 
+		if len(ci.path.pathParamNames) != 0 {
+			context.SetPathParams(ctx, ci.path.pathParams(ctx.Request().Path()))
+		}
 		if ci.path.pathRE != nil && ci.path.rewriteTarget != "" {
 			path := ctx.Request().Path()
 			path = ci.path.pathRE.ReplaceAllString(path, ci.path.rewriteTarget)
 			ctx.Request().SetPath(path)
 		}
-		// global filter
-		globalFilter := m.getGlobalFilter(rules)
-		if globalFilter == nil {
-			handler.Handle(ctx)
-			return
+		if ci.path.requireAuth {
+			context.SetAuthRequired(ctx)
+		}
+
+		if limit := int(rules.spec.MaxConsumerStats); limit > 0 {
+			ctx.OnFinish(func() {
+				if identity := context.GetIdentity(ctx); identity != nil {
+					m.consumerStat.get(identity, limit).Stat(ctx.StatMetric())
+				}
+			})
+		}
+
+		if rules.spec.ResourceAccounting {
+			defer resourcestat.Start(ctx, rules.superSpec.Name(), ci.path.route)()
+		}
+
+		if ci.path.timeouts != nil {
+			ci.path.timeouts.apply(ctx, m.connTracker.httpConn(ctx.Request().Std()))
 		}
-		globalFilter.Handle(ctx, handler)
+
+		m.runHandler(rules, ctx, ci.path, handler)
+
+		if name := rules.spec.NoAccessLogHeader; name != "" {
+			skipAccessLog(ctx, name)
+		}
+
+		if ci.path.timeouts != nil && context.TimedOut(ctx) {
+			m.handleRouteTimeout(ctx)
+		}
+
+		// NOTE: Pipelines without upstream filters (e.g. Mock) still could
+		// respond to unauthenticated requests, so the check is made again
+		// after the chain finishes.
+		if context.Unauthenticated(ctx) {
+			m.handleUnauthenticated(ctx)
+		}
+
+		upgrade, ok := ctx.Response().Std().(*upgradeResponseWriter)
+		if ok && ctx.Response().StatusCode() == http.StatusSwitchingProtocols {
+			m.tunnelWebSocket(ctx, upgrade, rules.spec.WebSocket)
+		}
+	}
+}
+
+// skipAccessLog skips the access log of the request if the response
+// header of the name is true, the header is removed from the response.
+func skipAccessLog(ctx context.HTTPContext, name string) {
+	h := ctx.Response().Header()
+	value := h.Get(name)
+	if value == "" {
+		return
+	}
+
+	h.Del(name)
+	if skip, _ := strconv.ParseBool(value); skip {
+		context.SetNoAccessLog(ctx)
 	}
 }
 
+// handleUnauthenticated replaces the response of a request requiring an
+// authenticated identity but doesn't have one.
+func (m *mux) handleUnauthenticated(ctx context.HTTPContext) {
+	resp := ctx.Response()
+	if body, ok := resp.Body().(io.Closer); ok {
+		body.Close()
+	}
+	resp.SetBody(nil)
+	resp.Header().Reset(nil)
+	resp.SetStatusCode(http.StatusUnauthorized)
+	ctx.AddTag("unauthenticated request rejected")
+}
+
+// handleBodyTooLarge replaces the response of a request whose body exceeds
+// the limit.
+func (m *mux) handleBodyTooLarge(ctx context.HTTPContext) {
+	resp := ctx.Response()
+	if body, ok := resp.Body().(io.Closer); ok {
+		body.Close()
+	}
+	resp.SetBody(nil)
+	resp.Header().Reset(nil)
+	resp.SetStatusCode(http.StatusRequestEntityTooLarge)
+	ctx.AddTag("request body too large")
+}
+
 func (m *mux) appendXForwardedFor(ctx context.HTTPContext) {
 	v := ctx.Request().Header().Get(httpheader.KeyXForwardedFor)
 	ip := ctx.Request().RealIP()
@@ -507,6 +966,10 @@ func (m *mux) getGlobalFilter(rules *muxRules) *globalfilter.GlobalFilter {
 
 func (m *mux) close() {
 	rules := m.rules.Load().(*muxRules)
+	if rules.superSpec != nil {
+		m.setAccounting(rules.superSpec.Name(), false)
+	}
+	m.maintenance.close()
 	err := rules.tracer.Close()
 	if err != nil {
 		logger.Errorf("%s close tracer failed: %v",
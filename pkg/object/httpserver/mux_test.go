@@ -0,0 +1,291 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+	"github.com/megaease/easegress/pkg/util/topn"
+)
+
+func TestMuxRulesRetire(t *testing.T) {
+	m := &mux{}
+	old := &muxRules{generation: 1}
+	m.rules.Store(old)
+
+	r1 := m.acquireRules()
+	if r1 != old {
+		t.Fatalf("expected the current rules")
+	}
+
+	released := 0
+	m.rules.Store(&muxRules{generation: 2})
+	old.retire(func() { released++ })
+	if released != 0 {
+		t.Fatalf("rules in use should not be released")
+	}
+
+	r2 := m.acquireRules()
+	if r2.generation != 2 {
+		t.Fatalf("expected generation 2, got %d", r2.generation)
+	}
+	r2.release()
+
+	r1.release()
+	if released != 1 {
+		t.Fatalf("expected the rules released once, got %d", released)
+	}
+
+	// Releasing happens only once.
+	old.retire(func() { released++ })
+	if released != 1 {
+		t.Fatalf("expected the rules released once, got %d", released)
+	}
+
+	if s := m.status(); s.Generation != 2 {
+		t.Errorf("expected generation 2, got %d", s.Generation)
+	}
+}
+
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestHandleUnauthenticated(t *testing.T) {
+	ctx := &contexttest.MockedHTTPContext{}
+	if context.Unauthenticated(ctx) {
+		t.Fatalf("request not requiring auth should not be unauthenticated")
+	}
+
+	context.SetAuthRequired(ctx)
+	if !context.Unauthenticated(ctx) {
+		t.Fatalf("request requiring auth without identity should be unauthenticated")
+	}
+	context.SetIdentity(ctx, "jwt", "alice")
+	if context.Unauthenticated(ctx) {
+		t.Fatalf("request with identity should not be unauthenticated")
+	}
+
+	body := &closeRecorder{Reader: strings.NewReader("secret")}
+	var respBody io.Reader = body
+	statusCode := http.StatusOK
+	header := httpheader.New(http.Header{"Content-Type": []string{"text/plain"}})
+	ctx.MockedResponse.MockedBody = func() io.Reader { return respBody }
+	ctx.MockedResponse.MockedSetBody = func(r io.Reader) { respBody = r }
+	ctx.MockedResponse.MockedSetStatusCode = func(code int) { statusCode = code }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+	(&mux{}).handleUnauthenticated(ctx)
+	if !body.closed || respBody != nil {
+		t.Errorf("the original body should be closed and dropped")
+	}
+	if header.Get("Content-Type") != "" {
+		t.Errorf("the original headers should be dropped")
+	}
+	if statusCode != http.StatusUnauthorized {
+		t.Errorf("expected status code 401, got %d", statusCode)
+	}
+}
+
+func TestSkipAccessLog(t *testing.T) {
+	for value, want := range map[string]bool{"": false, "true": true, "1": true, "false": false, "yes": false} {
+		ctx := &contexttest.MockedHTTPContext{}
+		header := httpheader.New(http.Header{})
+		if value != "" {
+			header.Set("X-Gateway-No-Log", value)
+		}
+		ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+		skipAccessLog(ctx, "X-Gateway-No-Log")
+		if got := context.NoAccessLog(ctx); got != want {
+			t.Errorf("%q: access log skipped should be %v, but is %v", value, want, got)
+		}
+		if header.Get("X-Gateway-No-Log") != "" {
+			t.Errorf("%q: header should be removed", value)
+		}
+	}
+}
+
+func TestRegexpsReuse(t *testing.T) {
+	path := &Path{
+		PathRegexp: "^/v[0-9]+/",
+		Headers:    []*Header{{Key: "X-Version", Regexp: "^v[0-9]+$"}},
+	}
+	template := &Path{Path: "/users/{id}/orders/{orderID}"}
+
+	res1 := newRegexps(nil)
+	mp1, err := newMuxPath(nil, path, res1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	headerRE := path.Headers[0].headerRE
+	mpt1, err := newMuxPath(nil, template, res1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	res1.done()
+
+	// The regexps of unchanged expressions are reused by the next reload.
+	res2 := newRegexps(res1)
+	mp2, _ := newMuxPath(nil, path, res2)
+	if mp2.pathRE != mp1.pathRE || path.Headers[0].headerRE != headerRE {
+		t.Errorf("regexps should be reused")
+	}
+	mpt2, _ := newMuxPath(nil, template, res2)
+	if mpt2.pathRE != mpt1.pathRE {
+		t.Errorf("regexps of path templates should be reused")
+	}
+	res2.done()
+
+	res3 := newRegexps(res2)
+	mp3, _ := newMuxPath(nil, &Path{PathRegexp: "^/v[0-9]+/api/"}, res3)
+	if mp3.pathRE == mp1.pathRE || !mp3.pathRE.MatchString("/v1/api/") {
+		t.Errorf("new expressions should be compiled")
+	}
+	if len(res3.m) != 1 {
+		t.Errorf("regexps not used should be dropped, but there are %d", len(res3.m))
+	}
+
+	if _, err := newRegexps(nil).compile("("); err == nil {
+		t.Errorf("invalid expression should fail")
+	}
+}
+
+func TestMatchHeaders(t *testing.T) {
+	headers := []*Header{
+		{Key: "X-Canary", Values: []string{"true"}},
+		{Key: "User-Agent", Prefix: "Mozilla/"},
+		{Key: "X-Version", Regexp: "^v[0-9]+$"},
+		{Key: "X-Debug", Exists: true},
+	}
+	mp, err := newMuxPath(nil, &Path{Headers: headers}, newRegexps(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cases := []struct {
+		header http.Header
+		want   bool
+	}{
+		{http.Header{"X-Canary": {"true"}}, true},
+		{http.Header{"X-Canary": {"false"}}, false},
+		{http.Header{"User-Agent": {"Mozilla/5.0"}}, true},
+		{http.Header{"User-Agent": {"curl/7.0"}}, false},
+		{http.Header{"X-Version": {"v2"}}, true},
+		{http.Header{"X-Version": {"v2-beta"}}, false},
+		{http.Header{"X-Debug": {""}}, true},
+		{http.Header{}, false},
+	}
+	for i, c := range cases {
+		ctx := &contexttest.MockedHTTPContext{}
+		header := httpheader.New(c.header)
+		ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return header }
+		if got := mp.matchHeaders(ctx); got != c.want {
+			t.Errorf("case %d: match should be %v, but is %v", i, c.want, got)
+		}
+	}
+
+	if err := (&Header{Key: "X-Canary"}).Validate(); err == nil {
+		t.Errorf("header without any condition should be invalid")
+	}
+	if err := (&Header{Key: "X-Canary", Exists: true}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestMuxStatusReload(t *testing.T) {
+	logger.InitNop()
+
+	newSuperSpec := func(pathRegexp string) *supervisor.Spec {
+		superSpec, err := supervisor.NewSpec(`
+kind: HTTPServer
+name: http-server-test
+port: 10080
+keepAlive: true
+https: false
+rules:
+  - paths:
+    - pathRegexp: ` + pathRegexp + `
+      backend: pipeline
+`)
+		if err != nil {
+			t.Fatalf("create spec failed: %v", err)
+		}
+		return superSpec
+	}
+
+	m := newMux(httpstat.New(), topn.New(topNum), newRouteStat(), newSLOStat(),
+		newConsumerStat(), newConnTracker(0, 0), nil)
+	defer m.close()
+
+	m.reloadRules(newSuperSpec("^/v1/"), nil)
+	s1 := m.status()
+	if s1.Generation != 1 || s1.SpecHash == "" || s1.LoadedAt == "" || s1.Error != "" {
+		t.Fatalf("unexpected status after the first load: %+v", s1)
+	}
+
+	m.reloadRules(newSuperSpec("^/v2/"), nil)
+	s2 := m.status()
+	if s2.Generation != 2 {
+		t.Errorf("want generation 2, got %d", s2.Generation)
+	}
+	if s2.SpecHash == s1.SpecHash {
+		t.Errorf("spec hash should change with the spec")
+	}
+	if s2.Error != "" {
+		t.Errorf("unexpected error: %s", s2.Error)
+	}
+
+	// NOTE: The validation rejects invalid regexps, so break the spec
+	// after creating it.
+	superSpec := newSuperSpec("^/v3/")
+	superSpec.ObjectSpec().(*Spec).Rules[0].Paths[0].PathRegexp = "("
+	m.reloadRules(superSpec, nil)
+	s3 := m.status()
+	if s3.Generation != 2 || s3.SpecHash != s2.SpecHash {
+		t.Errorf("generation 2 should keep serving, got %+v", s3)
+	}
+	if !strings.Contains(s3.Error, "compile") {
+		t.Errorf("error should be reported, got %q", s3.Error)
+	}
+	rules := m.rules.Load().(*muxRules)
+	if rules.generation != 2 || rules.rules[0].paths[0].pathRegexp != "^/v2/" {
+		t.Errorf("rules of generation 2 should keep serving")
+	}
+
+	// A successful reload clears the error.
+	m.reloadRules(newSuperSpec("^/v4/"), nil)
+	if s4 := m.status(); s4.Generation != 3 || s4.Error != "" {
+		t.Errorf("unexpected status after recovering: %+v", s4)
+	}
+}
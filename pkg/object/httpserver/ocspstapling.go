@@ -0,0 +1,430 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	defaultOCSPTimeout = 10 * time.Second
+	// defaultOCSPRefresh is the refresh interval of the responses
+	// without nextUpdate.
+	defaultOCSPRefresh = time.Hour
+	// ocspCheckInterval is the interval of checking the responses to
+	// refresh, failed fetches are retried in it too.
+	ocspCheckInterval   = time.Minute
+	maxOCSPResponseSize = 1024 * 1024
+)
+
+type (
+	// OCSPStaplingSpec describes the OCSP stapling of the certificates.
+	OCSPStaplingSpec struct {
+		// Timeout is the timeout of fetching a response from the
+		// responder, the default is 10s.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// OCSPStatus is the status of the OCSP staple of a certificate.
+	OCSPStatus struct {
+		// Names are the DNS names of the certificate, or its common name
+		// if there aren't.
+		Names   []string `yaml:"names"`
+		Stapled bool     `yaml:"stapled"`
+		// AgeSeconds is the seconds since the thisUpdate of the staple.
+		AgeSeconds int64  `yaml:"ageSeconds,omitempty"`
+		ThisUpdate string `yaml:"thisUpdate,omitempty"`
+		NextUpdate string `yaml:"nextUpdate,omitempty"`
+		// Failures is the number of failed fetches, and Error is the
+		// error of the last fetch.
+		Failures uint64 `yaml:"failures"`
+		Error    string `yaml:"error,omitempty"`
+	}
+
+	// ocspStaple is the cached OCSP response of a certificate.
+	ocspStaple struct {
+		names       []string
+		der         []byte
+		thisUpdate  time.Time
+		nextUpdate  time.Time
+		nextRefresh time.Time
+		failures    uint64
+		err         error
+	}
+
+	// ocspStapler fetches and caches the OCSP responses of certificates,
+	// and stores the certificates with the responses stapled, which are
+	// sent to clients in TLS handshakes.
+	ocspStapler struct {
+		client  *http.Client
+		timeout time.Duration
+		store   func(*certificates)
+
+		mutex   sync.Mutex
+		certs   *certificates
+		staples map[string]*ocspStaple // certificate fingerprint -> staple
+
+		refreshChan chan struct{}
+		done        chan struct{}
+	}
+)
+
+// Validate validates OCSPStaplingSpec.
+func (spec *OCSPStaplingSpec) Validate() error {
+	if spec.Timeout == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(spec.Timeout); err != nil {
+		return fmt.Errorf("invalid timeout: %v", err)
+	}
+	return nil
+}
+
+func (spec *OCSPStaplingSpec) timeout() time.Duration {
+	if spec.Timeout == "" {
+		return defaultOCSPTimeout
+	}
+	d, err := time.ParseDuration(spec.Timeout)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", spec.Timeout, err)
+		return defaultOCSPTimeout
+	}
+	return d
+}
+
+// newOCSPStapler creates an ocspStapler, store is called to apply the
+// certificates with the responses stapled.
+func newOCSPStapler(spec *OCSPStaplingSpec, store func(*certificates)) *ocspStapler {
+	s := &ocspStapler{
+		client: &http.Client{
+			Transport: egressproxy.DefaultTransport,
+			Timeout:   spec.timeout(),
+		},
+		timeout:     spec.timeout(),
+		store:       store,
+		staples:     map[string]*ocspStaple{},
+		refreshChan: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+
+	go s.run()
+
+	return s
+}
+
+func certFingerprint(cert *tls.Certificate) string {
+	sum := sha256.Sum256(cert.Certificate[0])
+	return hex.EncodeToString(sum[:])
+}
+
+func certNames(leaf *x509.Certificate) []string {
+	if len(leaf.DNSNames) > 0 {
+		return leaf.DNSNames
+	}
+	return []string{leaf.Subject.CommonName}
+}
+
+// setCertificates sets the certificates to staple, they're stored with
+// the cached responses immediately, and the missing responses are
+// fetched in the background.
+func (s *ocspStapler) setCertificates(certs *certificates) {
+	s.mutex.Lock()
+	s.certs = certs
+	s.storeLocked(time.Now())
+	s.mutex.Unlock()
+
+	select {
+	case s.refreshChan <- struct{}{}:
+	default:
+	}
+}
+
+// storeLocked stores the certificates with the valid staples, the
+// expired staples are not stapled.
+func (s *ocspStapler) storeLocked(now time.Time) {
+	if s.certs == nil {
+		return
+	}
+	// A closed stapler must not overwrite the certificates of its
+	// successor.
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+
+	stapled := map[*tls.Certificate]*tls.Certificate{}
+	staple := func(cert *tls.Certificate) *tls.Certificate {
+		if cert == nil {
+			return nil
+		}
+		if c, ok := stapled[cert]; ok {
+			return c
+		}
+
+		c := cert
+		if st := s.staples[certFingerprint(cert)]; st != nil && st.valid(now) {
+			copied := *cert
+			copied.OCSPStaple = st.der
+			c = &copied
+		}
+		stapled[cert] = c
+		return c
+	}
+
+	certs := *s.certs
+	certs.defaultCert = staple(s.certs.defaultCert)
+	certs.byName = make(map[string]*tls.Certificate, len(s.certs.byName))
+	for name, cert := range s.certs.byName {
+		certs.byName[name] = staple(cert)
+	}
+
+	s.store(&certs)
+}
+
+// uniqueCerts returns the distinct certificates with leaves.
+func (c *certificates) uniqueCerts() []*tls.Certificate {
+	seen := map[*tls.Certificate]struct{}{}
+	var result []*tls.Certificate
+
+	add := func(cert *tls.Certificate) {
+		if cert == nil || cert.Leaf == nil {
+			return
+		}
+		if _, ok := seen[cert]; ok {
+			return
+		}
+		seen[cert] = struct{}{}
+		result = append(result, cert)
+	}
+
+	add(c.defaultCert)
+	for _, cert := range c.byName {
+		add(cert)
+	}
+	return result
+}
+
+func (st *ocspStaple) valid(now time.Time) bool {
+	if st.der == nil {
+		return false
+	}
+	return st.nextUpdate.IsZero() || now.Before(st.nextUpdate)
+}
+
+func (s *ocspStapler) run() {
+	ticker := time.NewTicker(ocspCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		case <-s.refreshChan:
+		}
+		s.refresh(time.Now())
+	}
+}
+
+// refresh fetches the responses which are missing or due to refresh.
+func (s *ocspStapler) refresh(now time.Time) {
+	s.mutex.Lock()
+	certs := s.certs
+	s.mutex.Unlock()
+	if certs == nil {
+		return
+	}
+
+	type fetched struct {
+		fingerprint string
+		staple      *ocspStaple
+	}
+
+	var results []fetched
+	live := map[string]struct{}{}
+	for _, cert := range certs.uniqueCerts() {
+		fingerprint := certFingerprint(cert)
+		live[fingerprint] = struct{}{}
+
+		s.mutex.Lock()
+		old := s.staples[fingerprint]
+		s.mutex.Unlock()
+		if old != nil && now.Before(old.nextRefresh) {
+			continue
+		}
+
+		st := &ocspStaple{names: certNames(cert.Leaf)}
+		resp, der, err := s.fetch(cert)
+		if err != nil {
+			logger.Warnf("fetch OCSP response of %v failed: %v", st.names, err)
+			if old != nil {
+				*st = *old
+			}
+			st.failures++
+			st.err = err
+			st.nextRefresh = now.Add(ocspCheckInterval)
+		} else {
+			if old != nil {
+				st.failures = old.failures
+			}
+			st.der = der
+			st.thisUpdate, st.nextUpdate = resp.ThisUpdate, resp.NextUpdate
+			st.nextRefresh = ocspNextRefresh(resp, now)
+		}
+		results = append(results, fetched{fingerprint: fingerprint, staple: st})
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, r := range results {
+		s.staples[r.fingerprint] = r.staple
+	}
+	for fingerprint := range s.staples {
+		if _, ok := live[fingerprint]; !ok {
+			delete(s.staples, fingerprint)
+		}
+	}
+
+	// NOTE: The certificates may have been updated during the fetches,
+	// the latest ones are stored, and they're refreshed in the next round.
+	s.storeLocked(now)
+}
+
+// ocspNextRefresh returns the time to refresh the response, which is the
+// middle of its validity period, so there is plenty of time to retry.
+func ocspNextRefresh(resp *ocsp.Response, now time.Time) time.Time {
+	if resp.NextUpdate.IsZero() {
+		return now.Add(defaultOCSPRefresh)
+	}
+
+	next := resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+	if min := now.Add(ocspCheckInterval); next.Before(min) {
+		next = min
+	}
+	return next
+}
+
+// fetch fetches the OCSP response of the certificate from the responder
+// in it, the issuer must be the second certificate of the chain. Only
+// responses telling the certificate is good are returned.
+func (s *ocspStapler) fetch(cert *tls.Certificate) (*ocsp.Response, []byte, error) {
+	leaf := cert.Leaf
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, fmt.Errorf("no OCSP server in the certificate")
+	}
+	if len(cert.Certificate) < 2 {
+		return nil, nil, fmt.Errorf("no issuer certificate in the chain")
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse issuer certificate failed: %v", err)
+	}
+
+	reqBody, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create OCSP request failed: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, leaf.OCSPServer[0], bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder returned status %d", resp.StatusCode)
+	}
+	der, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	parsed, err := ocsp.ParseResponseForCert(der, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse OCSP response failed: %v", err)
+	}
+	switch parsed.Status {
+	case ocsp.Good:
+		return parsed, der, nil
+	case ocsp.Revoked:
+		return nil, nil, fmt.Errorf("certificate revoked at %s", parsed.RevokedAt.Format(time.RFC3339))
+	default:
+		return nil, nil, fmt.Errorf("certificate status is unknown to the responder")
+	}
+}
+
+func (s *ocspStapler) status() []*OCSPStatus {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	var result []*OCSPStatus
+	for _, st := range s.staples {
+		status := &OCSPStatus{
+			Names:    st.names,
+			Stapled:  st.valid(now),
+			Failures: st.failures,
+		}
+		if st.der != nil {
+			status.AgeSeconds = int64(now.Sub(st.thisUpdate) / time.Second)
+			status.ThisUpdate = st.thisUpdate.Format(time.RFC3339)
+			if !st.nextUpdate.IsZero() {
+				status.NextUpdate = st.nextUpdate.Format(time.RFC3339)
+			}
+		}
+		if st.err != nil {
+			status.Error = st.err.Error()
+		}
+		result = append(result, status)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Names[0] < result[j].Names[0]
+	})
+	return result
+}
+
+func (s *ocspStapler) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	close(s.done)
+}
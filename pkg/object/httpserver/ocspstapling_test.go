@@ -0,0 +1,227 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// newOCSPTestCert creates a certificate issued by a new CA, with the OCSP
+// server responding the status.
+func newOCSPTestCert(t *testing.T, status *int32) (*tls.Certificate, *httptest.Server) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	var leaf *x509.Certificate
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		req, err := ocsp.ParseRequest(body)
+		if err != nil || req.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		now := time.Now().Truncate(time.Second)
+		resp, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       int(atomic.LoadInt32(status)),
+			SerialNumber: leaf.SerialNumber,
+			ThisUpdate:   now.Add(-time.Minute),
+			NextUpdate:   now.Add(time.Hour),
+			RevokedAt:    now.Add(-time.Minute),
+		}, caKey)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(resp)
+	}))
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{responder.URL},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ = x509.ParseCertificate(der)
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, caDER},
+		PrivateKey:  crypto.PrivateKey(key),
+		Leaf:        leaf,
+	}
+	return cert, responder
+}
+
+func TestOCSPStapler(t *testing.T) {
+	status := int32(ocsp.Good)
+	cert, responder := newOCSPTestCert(t, &status)
+	defer responder.Close()
+
+	var stored atomic.Value
+	s := &ocspStapler{
+		client:      responder.Client(),
+		store:       func(c *certificates) { stored.Store(c) },
+		staples:     map[string]*ocspStaple{},
+		refreshChan: make(chan struct{}, 1),
+		done:        make(chan struct{}),
+	}
+
+	certs := &certificates{byName: map[string]*tls.Certificate{}}
+	certs.defaultCert = cert
+	certs.byName["example.com"] = cert
+	s.setCertificates(certs)
+
+	if got := stored.Load().(*certificates).get("example.com"); got.OCSPStaple != nil {
+		t.Fatalf("certificate should not be stapled before fetching")
+	}
+
+	now := time.Now()
+	s.refresh(now)
+	got := stored.Load().(*certificates)
+	if got.get("example.com").OCSPStaple == nil || got.defaultCert != got.get("example.com") {
+		t.Fatalf("certificate should be stapled")
+	}
+	if cert.OCSPStaple != nil {
+		t.Errorf("original certificate should not be modified")
+	}
+
+	statuses := s.status()
+	if len(statuses) != 1 || !statuses[0].Stapled || statuses[0].Failures != 0 || statuses[0].Names[0] != "example.com" {
+		t.Fatalf("unexpected status: %+v", statuses[0])
+	}
+
+	// Not due to refresh yet, so the revocation is not seen.
+	atomic.StoreInt32(&status, ocsp.Revoked)
+	s.refresh(now.Add(time.Minute))
+	if statuses = s.status(); statuses[0].Failures != 0 {
+		t.Errorf("response should not be refreshed")
+	}
+
+	// The failed refresh keeps the valid staple.
+	s.refresh(now.Add(40 * time.Minute))
+	statuses = s.status()
+	if statuses[0].Failures != 1 || statuses[0].Error == "" || !statuses[0].Stapled {
+		t.Errorf("unexpected status: %+v", statuses[0])
+	}
+
+	// The expired staple is removed.
+	s.refresh(now.Add(2 * time.Hour))
+	if stored.Load().(*certificates).get("example.com").OCSPStaple != nil {
+		t.Errorf("expired staple should not be stapled")
+	}
+	if statuses = s.status(); statuses[0].Failures != 2 {
+		t.Errorf("unexpected status: %+v", statuses[0])
+	}
+
+	s.close()
+}
+
+func TestOCSPStaplerNoResponder(t *testing.T) {
+	spec := &Spec{HTTPS: true, Certs: map[string]string{}, Keys: map[string]string{}}
+	spec.Certs["example.com"], spec.Keys["example.com"] = newCertKeyPem(t, "example.com")
+	certs, err := spec.certificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &ocspStapler{
+		client:  http.DefaultClient,
+		store:   func(c *certificates) {},
+		staples: map[string]*ocspStaple{},
+		done:    make(chan struct{}),
+	}
+	s.certs = certs
+	s.refresh(time.Now())
+
+	statuses := s.status()
+	if len(statuses) != 1 || statuses[0].Stapled || statuses[0].Error == "" {
+		t.Errorf("unexpected status: %+v", statuses)
+	}
+}
+
+func TestOCSPNextRefresh(t *testing.T) {
+	now := time.Now()
+
+	resp := &ocsp.Response{ThisUpdate: now, NextUpdate: now.Add(4 * time.Hour)}
+	if got := ocspNextRefresh(resp, now); !got.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("next refresh should be in the middle, but is %v", got.Sub(now))
+	}
+
+	resp = &ocsp.Response{ThisUpdate: now}
+	if got := ocspNextRefresh(resp, now); !got.Equal(now.Add(defaultOCSPRefresh)) {
+		t.Errorf("next refresh should be default, but is %v", got.Sub(now))
+	}
+
+	resp = &ocsp.Response{ThisUpdate: now.Add(-time.Hour), NextUpdate: now.Add(10 * time.Second)}
+	if got := ocspNextRefresh(resp, now); !got.Equal(now.Add(ocspCheckInterval)) {
+		t.Errorf("next refresh should be after the check interval, but is %v", got.Sub(now))
+	}
+}
+
+func TestOCSPStaplingSpecValidate(t *testing.T) {
+	if err := (&OCSPStaplingSpec{}).Validate(); err != nil {
+		t.Errorf("empty spec should be valid: %v", err)
+	}
+	if err := (&OCSPStaplingSpec{Timeout: "5s"}).Validate(); err != nil {
+		t.Errorf("spec should be valid: %v", err)
+	}
+	if err := (&OCSPStaplingSpec{Timeout: "5"}).Validate(); err == nil {
+		t.Errorf("spec should be invalid")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// catchAllSuffix is the suffix of the parameter matching the rest of the
+// path, e.g. {path...}.
+const catchAllSuffix = "..."
+
+var pathParamNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// isPathTemplate reports whether the path has parameters, e.g.
+// /users/{id}/orders/{orderID}.
+func isPathTemplate(path string) bool {
+	return strings.ContainsAny(path, "{}")
+}
+
+// compilePathTemplate compiles the path template into a regexp capturing
+// the parameters by their names, and returns the names in order. Every
+// parameter is a whole segment, which matches a non-empty segment, or
+// the rest of the path if it's the last one with the catch-all suffix.
+// The regexp is compiled by res, so it's reused across reloads.
+func compilePathTemplate(template string, res *regexps) (*regexp.Regexp, []string, error) {
+	var names []string
+	segments := strings.Split(template, "/")
+
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i, seg := range segments {
+		if i > 0 {
+			sb.WriteString("/")
+		}
+		if !strings.ContainsAny(seg, "{}") {
+			sb.WriteString(regexp.QuoteMeta(seg))
+			continue
+		}
+
+		if len(seg) < 2 || seg[0] != '{' || seg[len(seg)-1] != '}' {
+			return nil, nil, fmt.Errorf("parameter %s must be a whole segment", seg)
+		}
+		name, pattern := seg[1:len(seg)-1], "[^/]+"
+		if strings.HasSuffix(name, catchAllSuffix) {
+			if i != len(segments)-1 {
+				return nil, nil, fmt.Errorf("catch-all parameter %s must be the last segment", seg)
+			}
+			name, pattern = strings.TrimSuffix(name, catchAllSuffix), ".+"
+		}
+		if !pathParamNameRE.MatchString(name) {
+			return nil, nil, fmt.Errorf("invalid parameter name %s", seg)
+		}
+		for _, n := range names {
+			if n == name {
+				return nil, nil, fmt.Errorf("duplicated parameter %s", seg)
+			}
+		}
+
+		names = append(names, name)
+		sb.WriteString("(?P<" + name + ">" + pattern + ")")
+	}
+	sb.WriteString("$")
+
+	re, err := res.compile(sb.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return re, names, nil
+}
+
+// pathParams returns the parameters captured from the path, it's nil if
+// the path doesn't match.
+func (mp *muxPath) pathParams(path string) map[string]string {
+	matches := mp.pathRE.FindStringSubmatch(path)
+	if matches == nil {
+		return nil
+	}
+
+	params := make(map[string]string, len(mp.pathParamNames))
+	for i, name := range mp.pathParamNames {
+		params[name] = matches[i+1]
+	}
+	return params
+}
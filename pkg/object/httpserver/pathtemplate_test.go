@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompilePathTemplate(t *testing.T) {
+	invalid := []string{
+		"/users/{id",
+		"/users/id}",
+		"/users/x{id}",
+		"/users/{}",
+		"/users/{1d}",
+		"/users/{id}/orders/{id}",
+		"/files/{path...}/meta",
+	}
+	for _, template := range invalid {
+		if _, _, err := compilePathTemplate(template, newRegexps(nil)); err == nil {
+			t.Errorf("%s should be invalid", template)
+		}
+	}
+
+	cases := []struct {
+		template string
+		path     string
+		params   map[string]string
+	}{
+		{"/users/{id}/orders/{orderID}", "/users/42/orders/a-1", map[string]string{"id": "42", "orderID": "a-1"}},
+		{"/users/{id}/orders/{orderID}", "/users/42/orders/", nil},
+		{"/users/{id}/orders/{orderID}", "/users/42/orders/a/b", nil},
+		{"/users/{id}", "/users/42/", nil},
+		{"/v1.0/{name}", "/v1x0/a", nil},
+		{"/files/{path...}", "/files/a/b.txt", map[string]string{"path": "a/b.txt"}},
+		{"/files/{path...}", "/files/", nil},
+	}
+	for _, c := range cases {
+		re, names, err := compilePathTemplate(c.template, newRegexps(nil))
+		if err != nil {
+			t.Fatalf("compile %s failed: %v", c.template, err)
+		}
+		mp := &muxPath{pathRE: re, pathParamNames: names}
+		if got := mp.pathParams(c.path); !reflect.DeepEqual(got, c.params) {
+			t.Errorf("%s %s: params should be %v, but are %v", c.template, c.path, c.params, got)
+		}
+	}
+
+	re, _, _ := compilePathTemplate("/users/{id}/orders/{orderID}", newRegexps(nil))
+	if got := re.ReplaceAllString("/users/42/orders/7", "/orders/${orderID}?user=${id}"); got != "/orders/7?user=42" {
+		t.Errorf("rewritten path should be /orders/7?user=42, but is %s", got)
+	}
+}
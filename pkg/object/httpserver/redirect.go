@@ -0,0 +1,141 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/object/autocertmanager"
+)
+
+const defaultRedirectStatusCode = http.StatusMovedPermanently
+
+type (
+	// HTTPRedirectSpec describes the plain HTTP listener redirecting all
+	// requests to the HTTPS address of the server.
+	HTTPRedirectSpec struct {
+		// Port is the port of the plain HTTP listener, it listens on the
+		// same addresses as the HTTPS one.
+		Port uint16 `yaml:"port" jsonschema:"required,minimum=1"`
+		// StatusCode is the status code of redirections, the default is 301.
+		StatusCode int `yaml:"statusCode" jsonschema:"omitempty"`
+		// HTTPSPort is the port in the redirection locations, the default
+		// is the port of the server, e.g. it is the port of the load
+		// balancer in front of the server.
+		HTTPSPort uint16 `yaml:"httpsPort" jsonschema:"omitempty"`
+	}
+
+	// redirectHandler redirects requests to the HTTPS address.
+	redirectHandler struct {
+		statusCode int
+		httpsPort  uint16
+	}
+)
+
+// Validate validates HTTPRedirectSpec.
+func (spec *HTTPRedirectSpec) Validate() error {
+	if spec.Port == 0 {
+		return fmt.Errorf("port is required")
+	}
+
+	switch spec.StatusCode {
+	case 0, http.StatusMovedPermanently, http.StatusFound,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return fmt.Errorf("invalid statusCode %d, it must be one of 301, 302, 307 and 308", spec.StatusCode)
+	}
+
+	return nil
+}
+
+func (spec *HTTPRedirectSpec) statusCode() int {
+	if spec.StatusCode == 0 {
+		return defaultRedirectStatusCode
+	}
+	return spec.StatusCode
+}
+
+// listenAddresses returns the addresses of the redirect listeners, they're
+// the ones of the server with the port replaced.
+func (spec *HTTPRedirectSpec) listenAddresses(serverSpec *Spec) []string {
+	port := strconv.Itoa(int(spec.Port))
+
+	var addresses []string
+	for _, address := range serverSpec.listenAddresses() {
+		host, _, _ := net.SplitHostPort(address)
+		addresses = append(addresses, net.JoinHostPort(host, port))
+	}
+	return addresses
+}
+
+func newRedirectHandler(spec *HTTPRedirectSpec, serverPort uint16) *redirectHandler {
+	h := &redirectHandler{
+		statusCode: spec.statusCode(),
+		httpsPort:  spec.HTTPSPort,
+	}
+	if h.httpsPort == 0 {
+		h.httpsPort = serverPort
+	}
+	return h
+}
+
+func (h *redirectHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// NOTE: The redirect listener usually listens on port 80, so it
+	// handles HTTP-01 challenges as well.
+	if strings.HasPrefix(req.URL.Path, "/.well-known/acme-challenge/") {
+		autocertmanager.HandleHTTP01Challenge(w, req)
+		return
+	}
+
+	location := h.location(req)
+	if location == "" {
+		http.Error(w, "missing host", http.StatusBadRequest)
+		return
+	}
+
+	// NOTE: The location is absolute, so it is written directly instead
+	// of http.Redirect, which cleans the path.
+	w.Header().Set("Location", location)
+	w.WriteHeader(h.statusCode)
+}
+
+// location returns the HTTPS URL of the request, the port of the host is
+// replaced by the HTTPS port, which is omitted if it is 443.
+func (h *redirectHandler) location(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		return ""
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	} else {
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	}
+
+	if h.httpsPort != 443 {
+		host = net.JoinHostPort(host, strconv.Itoa(int(h.httpsPort)))
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+
+	return "https://" + host + req.URL.RequestURI()
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestHTTPRedirectSpecValidate(t *testing.T) {
+	for _, code := range []int{0, 301, 302, 307, 308} {
+		spec := &HTTPRedirectSpec{Port: 80, StatusCode: code}
+		if err := spec.Validate(); err != nil {
+			t.Errorf("unexpected error for %d: %v", code, err)
+		}
+	}
+
+	invalid := []*HTTPRedirectSpec{
+		{},
+		{Port: 80, StatusCode: 200},
+		{Port: 80, StatusCode: 303},
+	}
+	for _, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expect error for %+v", spec)
+		}
+	}
+}
+
+func TestHTTPRedirectListenAddresses(t *testing.T) {
+	redirect := &HTTPRedirectSpec{Port: 80}
+
+	addresses := redirect.listenAddresses(&Spec{Port: 443})
+	if !reflect.DeepEqual(addresses, []string{":80"}) {
+		t.Errorf("unexpected addresses: %v", addresses)
+	}
+
+	addresses = redirect.listenAddresses(&Spec{Port: 443, Address: "127.0.0.1", Addresses: []string{"::1"}})
+	if !reflect.DeepEqual(addresses, []string{"127.0.0.1:80", "[::1]:80"}) {
+		t.Errorf("unexpected addresses: %v", addresses)
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	cases := []struct {
+		spec     *HTTPRedirectSpec
+		port     uint16
+		url      string
+		host     string
+		code     int
+		location string
+	}{
+		{&HTTPRedirectSpec{Port: 80}, 443, "/a/b?c=d", "example.com", 301, "https://example.com/a/b?c=d"},
+		{&HTTPRedirectSpec{Port: 80}, 443, "/", "example.com:80", 301, "https://example.com/"},
+		{&HTTPRedirectSpec{Port: 8080, StatusCode: 308}, 8443, "/a", "example.com:8080", 308, "https://example.com:8443/a"},
+		{&HTTPRedirectSpec{Port: 8080, HTTPSPort: 443}, 8443, "/a", "example.com:8080", 301, "https://example.com/a"},
+		{&HTTPRedirectSpec{Port: 80}, 443, "/a", "[::1]:80", 301, "https://[::1]/a"},
+		{&HTTPRedirectSpec{Port: 80}, 8443, "/a", "[::1]", 301, "https://[::1]:8443/a"},
+		{&HTTPRedirectSpec{Port: 80}, 443, "/a//b/../c", "example.com", 301, "https://example.com/a//b/../c"},
+	}
+
+	for i, c := range cases {
+		h := newRedirectHandler(c.spec, c.port)
+		req := httptest.NewRequest(http.MethodPost, c.url, nil)
+		req.Host = c.host
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != c.code {
+			t.Errorf("case %d: expect status code %d, got %d", i, c.code, w.Code)
+		}
+		if location := w.Header().Get("Location"); location != c.location {
+			t.Errorf("case %d: expect location %s, got %s", i, c.location, location)
+		}
+	}
+
+	h := newRedirectHandler(&HTTPRedirectSpec{Port: 80}, 443)
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Host = ""
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expect status code 400 for missing host, got %d", w.Code)
+	}
+}
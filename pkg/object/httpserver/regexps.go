@@ -0,0 +1,62 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import "regexp"
+
+// regexps contains the compiled regexps of the rules by their expressions,
+// it is built by reloadRules and swapped with the rules, so only the new
+// expressions are compiled on a reload, and nothing is compiled when
+// serving requests.
+type regexps struct {
+	prev map[string]*regexp.Regexp
+	m    map[string]*regexp.Regexp
+}
+
+func newRegexps(prev *regexps) *regexps {
+	rs := &regexps{m: map[string]*regexp.Regexp{}}
+	if prev != nil {
+		rs.prev = prev.m
+	}
+	return rs
+}
+
+// compile returns the compiled regexp of the expression, which is reused
+// from the previous rules if possible.
+func (rs *regexps) compile(expr string) (*regexp.Regexp, error) {
+	if re := rs.m[expr]; re != nil {
+		return re, nil
+	}
+
+	re := rs.prev[expr]
+	if re == nil {
+		var err error
+		re, err = regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rs.m[expr] = re
+	return re, nil
+}
+
+// done drops the previous regexps, which are not needed after reloading.
+func (rs *regexps) done() {
+	rs.prev = nil
+}
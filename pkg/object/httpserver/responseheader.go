@@ -0,0 +1,91 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+type (
+	// responseHeaders are the headers injected into every response.
+	responseHeaders []*responseHeader
+
+	responseHeader struct {
+		key   string
+		value string
+	}
+)
+
+// reservedResponseHeaders are the headers managed by the server.
+var reservedResponseHeaders = map[string]struct{}{
+	"Connection":        {},
+	"Content-Length":    {},
+	"Transfer-Encoding": {},
+	"Upgrade":           {},
+}
+
+func validateResponseHeaders(headers map[string]string) error {
+	keys := map[string]struct{}{}
+	for key, value := range headers {
+		if !httpguts.ValidHeaderFieldName(key) {
+			return fmt.Errorf("invalid header name %q", key)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid value of header %s", key)
+		}
+		canonical := httpheader.CanonicalKey(key)
+		if _, exists := reservedResponseHeaders[canonical]; exists {
+			return fmt.Errorf("header %s is managed by the server", key)
+		}
+		if _, exists := keys[canonical]; exists {
+			return fmt.Errorf("header %s is duplicated", canonical)
+		}
+		keys[canonical] = struct{}{}
+	}
+	return nil
+}
+
+func newResponseHeaders(headers map[string]string) responseHeaders {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	result := make(responseHeaders, 0, len(headers))
+	for key, value := range headers {
+		result = append(result, &responseHeader{key: httpheader.CanonicalKey(key), value: value})
+	}
+	return result
+}
+
+// inject sets the headers to the response, overwriting the ones from
+// the backends, the headers with empty values are deleted, e.g. Server.
+func (rhs responseHeaders) inject(ctx context.HTTPContext) {
+	header := ctx.Response().Header()
+	for _, rh := range rhs {
+		if rh.value == "" {
+			header.Del(rh.key)
+		} else {
+			header.Set(rh.key, rh.value)
+		}
+	}
+}
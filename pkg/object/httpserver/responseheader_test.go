@@ -0,0 +1,85 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestValidateResponseHeaders(t *testing.T) {
+	headers := map[string]string{
+		"strict-transport-security": "max-age=31536000",
+		"X-Frame-Options":           "DENY",
+		"Server":                    "",
+	}
+	if err := validateResponseHeaders(headers); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := []map[string]string{
+		{"bad key": "a"},
+		{"X-Foo": "a\nb"},
+		{"content-length": "1"},
+		{"server": "a", "Server": "b"},
+	}
+	for _, headers := range invalid {
+		if err := validateResponseHeaders(headers); err == nil {
+			t.Errorf("expect error for %v", headers)
+		}
+	}
+}
+
+func TestInjectResponseHeaders(t *testing.T) {
+	rhs := newResponseHeaders(map[string]string{
+		"strict-transport-security": "max-age=31536000",
+		"X-Frame-Options":           "DENY",
+		"Server":                    "",
+	})
+
+	header := httpheader.New(http.Header{
+		"Server":          {"nginx"},
+		"X-Frame-Options": {"SAMEORIGIN"},
+		"Content-Type":    {"text/plain"},
+	})
+	ctx := &contexttest.MockedHTTPContext{}
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return header }
+
+	rhs.inject(ctx)
+
+	std := header.Std()
+	if std.Get("Strict-Transport-Security") != "max-age=31536000" {
+		t.Errorf("hsts should be injected")
+	}
+	if values := std["X-Frame-Options"]; len(values) != 1 || values[0] != "DENY" {
+		t.Errorf("x-frame-options should be overwritten: %v", values)
+	}
+	if _, exists := std["Server"]; exists {
+		t.Errorf("server should be deleted")
+	}
+	if std.Get("Content-Type") != "text/plain" {
+		t.Errorf("other headers should be kept")
+	}
+
+	if newResponseHeaders(nil) != nil {
+		t.Errorf("response headers should be nil")
+	}
+}
@@ -0,0 +1,105 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/megaease/easegress/pkg/util/httpstat"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+type (
+	// routeStat is the statistics of routes, it is kept across reloading
+	// of rules, so the statistics of unchanged routes are not reset.
+	routeStat struct {
+		m sync.Map // route -> *httpstat.HTTPStat
+	}
+
+	// RouteStatus is the status of a route.
+	RouteStatus struct {
+		Route string `yaml:"route"`
+		*httpstat.Status
+	}
+)
+
+func newRouteStat() *routeStat {
+	return &routeStat{}
+}
+
+// routeName returns the name of route in format: <host> <path> -> <backend>,
+// the host is "*" if it is empty, the regexps are prefixed with "~", and
+// the path prefix is suffixed with "*".
+func routeName(rule *Rule, path *Path) string {
+	host := "*"
+	switch {
+	case rule.Host != "":
+		host = rule.Host
+	case rule.HostRegexp != "":
+		host = "~" + rule.HostRegexp
+	}
+
+	p := "*"
+	switch {
+	case path.Path != "":
+		p = path.Path
+	case path.PathPrefix != "":
+		p = path.PathPrefix + "*"
+	case path.PathRegexp != "":
+		p = "~" + path.PathRegexp
+	}
+
+	return stringtool.Cat(host, " ", p, " -> ", path.Backend)
+}
+
+func (rs *routeStat) get(route string) *httpstat.HTTPStat {
+	if v, loaded := rs.m.Load(route); loaded {
+		return v.(*httpstat.HTTPStat)
+	}
+
+	v, _ := rs.m.LoadOrStore(route, httpstat.New())
+	return v.(*httpstat.HTTPStat)
+}
+
+// retain removes the statistics of routes not in routes.
+func (rs *routeStat) retain(routes map[string]struct{}) {
+	rs.m.Range(func(key, value interface{}) bool {
+		if _, exists := routes[key.(string)]; !exists {
+			rs.m.Delete(key)
+		}
+		return true
+	})
+}
+
+// Status returns the status of routes which have served requests.
+func (rs *routeStat) Status() []*RouteStatus {
+	status := []*RouteStatus{}
+	rs.m.Range(func(key, value interface{}) bool {
+		s := value.(*httpstat.HTTPStat).Status()
+		if s.Count > 0 {
+			status = append(status, &RouteStatus{Route: key.(string), Status: s})
+		}
+		return true
+	})
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Route < status[j].Route
+	})
+	return status
+}
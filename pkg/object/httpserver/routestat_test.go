@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"testing"
+
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+func TestRouteName(t *testing.T) {
+	cases := []struct {
+		rule *Rule
+		path *Path
+		want string
+	}{
+		{&Rule{Host: "example.com"}, &Path{Path: "/api", Backend: "p1"}, "example.com /api -> p1"},
+		{&Rule{HostRegexp: `^.*\.com$`}, &Path{PathPrefix: "/api/", Backend: "p2"}, `~^.*\.com$ /api/* -> p2`},
+		{&Rule{}, &Path{PathRegexp: "^/v[0-9]+/", Backend: "p3"}, "* ~^/v[0-9]+/ -> p3"},
+		{&Rule{}, &Path{Backend: "p4"}, "* * -> p4"},
+	}
+
+	for _, c := range cases {
+		if got := routeName(c.rule, c.path); got != c.want {
+			t.Errorf("expect %q, got %q", c.want, got)
+		}
+	}
+}
+
+func TestRouteStat(t *testing.T) {
+	rs := newRouteStat()
+
+	s1 := rs.get("r1")
+	if rs.get("r1") != s1 {
+		t.Fatalf("expect the same stat for the same route")
+	}
+	rs.get("r2")
+
+	s1.Stat(&httpstat.Metric{StatusCode: 200, ReqSize: 100, RespSize: 5000})
+
+	status := rs.Status()
+	if len(status) != 1 || status[0].Route != "r1" {
+		t.Fatalf("expect only r1 in status, got %+v", status)
+	}
+	if status[0].ReqSizeHistogram[0] != 1 || status[0].RespSizeHistogram[2] != 1 {
+		t.Errorf("unexpected size histograms: %v, %v",
+			status[0].ReqSizeHistogram, status[0].RespSizeHistogram)
+	}
+
+	rs.retain(map[string]struct{}{"r2": {}})
+	if _, exists := rs.m.Load("r1"); exists {
+		t.Errorf("expect r1 to be removed")
+	}
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+)
+
+type (
+	// RouteTimeoutsSpec describes the timeouts of a route, which override
+	// the timeouts of the server, so that a slow route doesn't force long
+	// timeouts on the whole server.
+	RouteTimeoutsSpec struct {
+		// Read is the max time reading the request body, it applies to
+		// HTTP/1.x only.
+		Read string `yaml:"read,omitempty" jsonschema:"omitempty,format=duration"`
+		// Upstream is the max time waiting for the response headers of
+		// every upstream request.
+		Upstream string `yaml:"upstream,omitempty" jsonschema:"omitempty,format=duration"`
+		// Total is the max time handling the request, from the request
+		// is routed to the response is written.
+		Total string `yaml:"total,omitempty" jsonschema:"omitempty,format=duration"`
+	}
+
+	routeTimeouts struct {
+		read     time.Duration
+		upstream time.Duration
+		total    time.Duration
+	}
+
+	// deadlineBody lifts the read deadline of the connection to the
+	// deadline of the request once the body is read, so that the
+	// background read of the server doesn't cancel the request.
+	deadlineBody struct {
+		io.Reader
+		conn     net.Conn
+		deadline time.Time
+		lifted   bool
+	}
+)
+
+// Validate validates RouteTimeoutsSpec.
+func (spec *RouteTimeoutsSpec) Validate() error {
+	for _, timeout := range []struct {
+		name  string
+		value string
+	}{
+		{"read", spec.Read},
+		{"upstream", spec.Upstream},
+		{"total", spec.Total},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(timeout.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s: %v", timeout.name, timeout.value, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", timeout.name)
+		}
+	}
+	return nil
+}
+
+// newRouteTimeouts returns the timeouts of the path, the ones of the path
+// override the ones of the rule, nil is returned if there's none.
+func newRouteTimeouts(rule, path *RouteTimeoutsSpec) *routeTimeouts {
+	rt := &routeTimeouts{}
+	for _, spec := range []*RouteTimeoutsSpec{rule, path} {
+		if spec == nil {
+			continue
+		}
+		// NOTE: The durations have been validated.
+		if d, _ := time.ParseDuration(spec.Read); d > 0 {
+			rt.read = d
+		}
+		if d, _ := time.ParseDuration(spec.Upstream); d > 0 {
+			rt.upstream = d
+		}
+		if d, _ := time.ParseDuration(spec.Total); d > 0 {
+			rt.total = d
+		}
+	}
+
+	if *rt == (routeTimeouts{}) {
+		return nil
+	}
+	return rt
+}
+
+// apply applies the timeouts to the request, conn is the connection of
+// the HTTP/1.x request, whose deadlines replace the ones set by the
+// readTimeout and writeTimeout of the server.
+func (rt *routeTimeouts) apply(ctx context.HTTPContext, conn net.Conn) {
+	if rt.upstream > 0 {
+		context.SetUpstreamTimeout(ctx, rt.upstream)
+	}
+
+	var deadline time.Time
+	if rt.total > 0 {
+		deadline = time.Now().Add(rt.total)
+		context.SetTimeout(ctx, rt.total)
+	}
+
+	if conn == nil {
+		return
+	}
+
+	if rt.total > 0 {
+		conn.SetWriteDeadline(deadline)
+		// NOTE: The server doesn't reset the write deadline for the
+		// next request if its writeTimeout is zero.
+		ctx.OnFinish(func() {
+			conn.SetWriteDeadline(time.Time{})
+		})
+	}
+
+	// NOTE: Only the deadlines configured by the route are changed, the
+	// read timeout applies to the request body only, so it changes
+	// nothing for a request without body.
+	switch {
+	case rt.read > 0 && ctx.Request().Std().ContentLength != 0:
+		conn.SetReadDeadline(time.Now().Add(rt.read))
+		ctx.Request().SetBody(&deadlineBody{
+			Reader:   ctx.Request().Body(),
+			conn:     conn,
+			deadline: deadline,
+		})
+	case rt.total > 0:
+		conn.SetReadDeadline(deadline)
+	}
+}
+
+func (b *deadlineBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF && !b.lifted {
+		b.lifted = true
+		b.conn.SetReadDeadline(b.deadline)
+	}
+	return n, err
+}
+
+// handleRouteTimeout replaces the response of a request exceeding the
+// total timeout of its route.
+func (m *mux) handleRouteTimeout(ctx context.HTTPContext) {
+	resp := ctx.Response()
+	if body, ok := resp.Body().(io.Closer); ok {
+		body.Close()
+	}
+	resp.SetBody(nil)
+	resp.Header().Reset(nil)
+	resp.SetStatusCode(http.StatusGatewayTimeout)
+	ctx.AddTag("route timeout")
+}
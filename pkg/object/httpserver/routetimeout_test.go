@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+type deadlineConn struct {
+	net.Conn
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (c *deadlineConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline = t
+	return nil
+}
+
+func (c *deadlineConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline = t
+	return nil
+}
+
+func TestRouteTimeoutsSpecValidate(t *testing.T) {
+	if err := (&RouteTimeoutsSpec{Read: "1s", Upstream: "2s", Total: "3s"}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, spec := range []*RouteTimeoutsSpec{
+		{Read: "abc"},
+		{Upstream: "-1s"},
+		{Total: "0s"},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expect error for %+v", spec)
+		}
+	}
+}
+
+func TestNewRouteTimeouts(t *testing.T) {
+	if rt := newRouteTimeouts(nil, &RouteTimeoutsSpec{}); rt != nil {
+		t.Errorf("expect nil, got %+v", rt)
+	}
+
+	rt := newRouteTimeouts(
+		&RouteTimeoutsSpec{Read: "1s", Total: "10s"},
+		&RouteTimeoutsSpec{Upstream: "5s", Total: "120s"},
+	)
+	expected := routeTimeouts{read: time.Second, upstream: 5 * time.Second, total: 120 * time.Second}
+	if rt == nil || *rt != expected {
+		t.Errorf("expect %+v, got %+v", expected, rt)
+	}
+}
+
+func TestRouteTimeoutsApply(t *testing.T) {
+	rt := &routeTimeouts{read: 10 * time.Millisecond, upstream: 2 * time.Second, total: 100 * time.Millisecond}
+
+	stdr := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("body"))
+	ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+	conn := &deadlineConn{}
+	rt.apply(ctx, conn)
+
+	if d := context.GetUpstreamTimeout(ctx); d != 2*time.Second {
+		t.Errorf("expect upstream timeout 2s, got %v", d)
+	}
+	if conn.writeDeadline.IsZero() {
+		t.Errorf("write deadline should be set")
+	}
+	readDeadline := conn.readDeadline
+	if readDeadline.IsZero() || readDeadline.After(time.Now().Add(10*time.Millisecond)) {
+		t.Errorf("unexpected read deadline %v", readDeadline)
+	}
+
+	// The read deadline is lifted to the total deadline once the body
+	// is read.
+	io.ReadAll(ctx.Request().Body())
+	if !conn.readDeadline.After(readDeadline) {
+		t.Errorf("read deadline should be lifted to the total deadline")
+	}
+
+	if context.TimedOut(ctx) {
+		t.Errorf("request should not time out yet")
+	}
+	<-ctx.Done()
+	if !context.TimedOut(ctx) {
+		t.Errorf("request should time out")
+	}
+
+	ctx.Finish()
+	if !conn.writeDeadline.IsZero() {
+		t.Errorf("write deadline should be reset")
+	}
+}
+
+func TestRouteTimeoutsApplyPartial(t *testing.T) {
+	serverDeadline := time.Now().Add(time.Hour)
+	apply := func(rt *routeTimeouts, body string) (*deadlineConn, context.HTTPContext) {
+		var stdr *http.Request
+		if body == "" {
+			stdr = httptest.NewRequest(http.MethodGet, "/", nil)
+		} else {
+			stdr = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		}
+		ctx := context.New(httptest.NewRecorder(), stdr, tracing.NoopTracing, "test")
+		conn := &deadlineConn{readDeadline: serverDeadline, writeDeadline: serverDeadline}
+		rt.apply(ctx, conn)
+		return conn, ctx
+	}
+
+	// The read timeout changes nothing for a request without body.
+	readOnly := &routeTimeouts{read: 10 * time.Millisecond}
+	conn, _ := apply(readOnly, "")
+	if conn.readDeadline != serverDeadline || conn.writeDeadline != serverDeadline {
+		t.Errorf("deadlines should be untouched, got read %v, write %v",
+			conn.readDeadline, conn.writeDeadline)
+	}
+
+	// The read timeout applies to the body only.
+	conn, _ = apply(readOnly, "body")
+	if !conn.readDeadline.Before(serverDeadline) || conn.readDeadline.IsZero() {
+		t.Errorf("unexpected read deadline %v", conn.readDeadline)
+	}
+	if conn.writeDeadline != serverDeadline {
+		t.Errorf("write deadline should be untouched, got %v", conn.writeDeadline)
+	}
+
+	// The total timeout changes the write deadline, and the read one
+	// so that the read timeout of the server doesn't cut the request.
+	writeOnly := &routeTimeouts{total: 2 * time.Hour}
+	conn, ctx := apply(writeOnly, "")
+	if !conn.writeDeadline.After(serverDeadline) || conn.readDeadline != conn.writeDeadline {
+		t.Errorf("deadlines should be the total deadline, got read %v, write %v",
+			conn.readDeadline, conn.writeDeadline)
+	}
+	ctx.Finish()
+
+	conn, _ = apply(&routeTimeouts{upstream: time.Second}, "body")
+	if conn.readDeadline != serverDeadline || conn.writeDeadline != serverDeadline {
+		t.Errorf("deadlines should be untouched, got read %v, write %v",
+			conn.readDeadline, conn.writeDeadline)
+	}
+}
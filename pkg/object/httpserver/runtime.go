@@ -18,9 +18,14 @@
 package httpserver
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
 	"reflect"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -30,8 +35,14 @@ import (
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/protocol"
 	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/connstat"
+	"github.com/megaease/easegress/pkg/util/http1compat"
 	"github.com/megaease/easegress/pkg/util/httpstat"
 	"github.com/megaease/easegress/pkg/util/limitlistener"
+	"github.com/megaease/easegress/pkg/util/proxyprotocol"
+	"github.com/megaease/easegress/pkg/util/resourcestat"
+	"github.com/megaease/easegress/pkg/util/tcpoption"
+	"github.com/megaease/easegress/pkg/util/tlsfingerprint"
 	"github.com/megaease/easegress/pkg/util/topn"
 )
 
@@ -40,12 +51,28 @@ const (
 
 	checkFailedTimeout = 10 * time.Second
 
+	// proxyProtocolTimeout is the max time to wait for the PROXY protocol
+	// header of a connection.
+	proxyProtocolTimeout = 10 * time.Second
+
 	topNum = 10
 
 	stateNil     stateType = "nil"
 	stateFailed  stateType = "failed"
 	stateRunning stateType = "running"
-	stateClosed  stateType = "closed"
+	// stateDraining is the state of shutting down the server, it is
+	// waiting for the in-flight requests to finish.
+	stateDraining stateType = "draining"
+	stateClosed   stateType = "closed"
+
+	// protocolTCP and protocolUnix are the protocols of the listeners for
+	// HTTP/1.x and HTTP/2, protocolQUIC is the one for HTTP/3.
+	protocolTCP  = "tcp"
+	protocolUnix = "unix"
+	protocolQUIC = "quic"
+	// protocolRedirect is the protocol of the listeners redirecting
+	// plain HTTP requests to HTTPS.
+	protocolRedirect = "redirect"
 )
 
 var (
@@ -58,6 +85,9 @@ type (
 
 	eventCheckFailed struct{}
 	eventServeFailed struct {
+		// listener is the name of the listener, see listenerName.
+		listener string
+		// startNum is the start number of the listener.
 		startNum uint64
 		err      error
 	}
@@ -67,22 +97,50 @@ type (
 	}
 	eventClose struct{ done chan struct{} }
 
+	// previousServer is the server on the old port, which keeps serving
+	// until the server on the new port starts.
+	previousServer struct {
+		server  *http.Server
+		server3 *http3.Server
+	}
+
 	runtime struct {
 		superSpec *supervisor.Spec
 		spec      *Spec
 		server    *http.Server
 		server3   *http3.Server
+		previous  *previousServer
 		mux       *mux
-		startNum  uint64
-		eventChan chan interface{}
+		events    *eventQueue
+		// startNums are the start numbers of the listeners,
+		// they're only accessed by the FSM.
+		startNums map[string]uint64
+		// warmUpNum is the number of warm-ups after starting the server.
+		warmUpNum uint64
 
 		// status
-		state atomic.Value // stateType
-		err   atomic.Value // error
-
-		httpStat      *httpstat.HTTPStat
-		topN          *topn.TopN
-		limitListener *limitlistener.LimitListener
+		state     atomic.Value // stateType
+		err       atomic.Value // error
+		listeners sync.Map     // listener -> *ListenerStatus
+		// sniffing is 1 if the spec enables sniffing, it's published for
+		// Status, which must not access the spec owned by the FSM.
+		sniffing int32
+
+		httpStat       *httpstat.HTTPStat
+		connStat       *connstat.ConnStat
+		topN           *topn.TopN
+		routeStat      *routeStat
+		sloStat        *sloStat
+		consumerStat   *consumerStat
+		limitListeners map[string]*limitlistener.LimitListener // listener -> limit listener
+		connTracker    *connTracker
+		sniffStat      *sniffStat
+
+		sessionTicketKeys atomic.Value // *sessionTicketKeys
+		certs             atomic.Value // *certificates
+		ocspStapler       atomic.Value // *ocspStapler
+		// redirectServer serves the listeners of HTTPRedirect.
+		redirectServer *http.Server
 	}
 
 	// Status contains all status generated by runtime, for displaying to users.
@@ -92,20 +150,87 @@ type (
 		State stateType `yaml:"state"`
 		Error string    `yaml:"error,omitempty"`
 
+		// InFlightRequests is the number of in-flight requests remaining,
+		// it is only reported while draining.
+		InFlightRequests int64 `yaml:"inFlightRequests,omitempty"`
+		// WebSocketConnections is the number of WebSocket connections.
+		WebSocketConnections int64 `yaml:"webSocketConnections,omitempty"`
+
+		// Listeners contains the status of the listeners, see listenerName.
+		Listeners map[string]*ListenerStatus `yaml:"listeners,omitempty"`
+
 		*httpstat.Status
 		TopN *topn.Status `yaml:"topN"`
+
+		// Routes contains the statistics of routes which have served requests.
+		Routes []*RouteStatus `yaml:"routes,omitempty"`
+		// SLOs contains the status of service level objectives of routes.
+		SLOs []*SLOStatus `yaml:"slos,omitempty"`
+		// Consumers contains the statistics of authenticated consumers.
+		Consumers []*ConsumerStatus `yaml:"consumers,omitempty"`
+		// Maintenance is the status of the maintenance mode, it's absent
+		// if the server is not in maintenance.
+		Maintenance *MaintenanceStatus `yaml:"maintenance,omitempty"`
+		// WorkerPool contains the status of the worker pool.
+		WorkerPool *WorkerPoolStatus `yaml:"workerPool,omitempty"`
+		// Resources contains the estimated resource usage of routes and
+		// tenants, only for resourceAccounting.
+		Resources []*resourcestat.Status `yaml:"resources,omitempty"`
+
+		// TLS contains the TLS handshake statistics, only for https.
+		TLS *connstat.Status `yaml:"tls,omitempty"`
+		// SessionTicket contains the status of the session ticket keys,
+		// only for sessionTicket.
+		SessionTicket *SessionTicketStatus `yaml:"sessionTicket,omitempty"`
+		// OCSP contains the status of the OCSP staples of certificates,
+		// only for ocspStapling.
+		OCSP []*OCSPStatus `yaml:"ocsp,omitempty"`
+
+		// Rules contains the status of the routing table.
+		Rules *MuxStatus `yaml:"rules"`
+
+		// Connections contains the statistics of connections, excluding
+		// the ones of http3.
+		Connections *ConnectionStatus `yaml:"connections"`
+
+		// Sniffing contains the number of sniffed connections of every
+		// protocol, only for sniffing.
+		Sniffing *SniffingStatus `yaml:"sniffing,omitempty"`
+
+		// Events contains the statistics of the events of the runtime.
+		Events *EventQueueStatus `yaml:"events"`
+	}
+
+	// ListenerStatus is the status of the listener of a protocol.
+	ListenerStatus struct {
+		State stateType `yaml:"state"`
+		Error string    `yaml:"error,omitempty"`
 	}
 )
 
 func newRuntime(superSpec *supervisor.Spec, muxMapper protocol.MuxMapper) *runtime {
 	r := &runtime{
 		superSpec: superSpec,
-		eventChan: make(chan interface{}, 10),
+		events:    newEventQueue(),
+		startNums: map[string]uint64{},
 		httpStat:  httpstat.New(),
+		connStat:  connstat.New(),
 		topN:      topn.New(topNum),
+		routeStat: newRouteStat(),
+		sloStat:   newSLOStat(),
+
+		consumerStat: newConsumerStat(),
+
+		limitListeners: map[string]*limitlistener.LimitListener{},
+
+		connTracker: newConnTracker(0, 0),
+		sniffStat:   &sniffStat{},
 	}
 
-	r.mux = newMux(r.httpStat, r.topN, muxMapper)
+	r.mux = newMux(r.httpStat, r.topN, r.routeStat, r.sloStat, r.consumerStat, r.connTracker, muxMapper)
+	if super := superSpec.Super(); super != nil && super.Cluster() != nil {
+		r.mux.maintenance = newMaintenanceFlag(super.Cluster(), superSpec.Name())
+	}
 	r.setState(stateNil)
 	r.setError(errNil)
 
@@ -118,7 +243,7 @@ func newRuntime(superSpec *supervisor.Spec, muxMapper protocol.MuxMapper) *runti
 // Close closes runtime.
 func (r *runtime) Close() {
 	done := make(chan struct{})
-	r.eventChan <- &eventClose{done: done}
+	r.events.send(&eventClose{done: done})
 	<-done
 }
 
@@ -126,33 +251,75 @@ func (r *runtime) Close() {
 func (r *runtime) Status() *Status {
 	health := r.getError().Error()
 
-	return &Status{
+	status := &Status{
 		Health: health,
 		State:  r.getState(),
 		Error:  r.getError().Error(),
 		Status: r.httpStat.Status(),
 		TopN:   r.topN.Status(),
+		Routes: r.routeStat.Status(),
+		SLOs:   r.sloStat.Status(),
+		Rules:  r.mux.status(),
+		Events: r.events.status(),
+
+		Connections: r.connTracker.status(),
+		Consumers:   r.consumerStat.Status(),
+	}
+
+	r.listeners.Range(func(key, value interface{}) bool {
+		if status.Listeners == nil {
+			status.Listeners = map[string]*ListenerStatus{}
+		}
+		status.Listeners[key.(string)] = value.(*ListenerStatus)
+		return true
+	})
+
+	if status.State == stateDraining {
+		status.InFlightRequests = r.mux.inFlightRequests()
+	}
+	status.WebSocketConnections = r.mux.webSocketConnections()
+	status.Resources = r.mux.resourceStatus()
+	status.WorkerPool = r.mux.workerPool.status()
+	status.Maintenance = r.mux.maintenanceStatus()
+
+	tlsStatus := r.connStat.Status()
+	if tlsStatus.TLSHandshakes > 0 || len(tlsStatus.TLSHandshakeFailures) > 0 {
+		status.TLS = tlsStatus
+	}
+	if stk := r.loadSessionTicketKeys(); stk != nil {
+		status.SessionTicket = stk.status()
 	}
+	if stapler := r.loadOCSPStapler(); stapler != nil {
+		status.OCSP = stapler.status()
+	}
+	if atomic.LoadInt32(&r.sniffing) == 1 {
+		status.Sniffing = r.sniffStat.status()
+	}
+
+	return status
 }
 
 // FSM is the finite-state-machine for the runtime.
 func (r *runtime) fsm() {
-	for e := range r.eventChan {
-		switch e := e.(type) {
-		case *eventCheckFailed:
-			r.handleEventCheckFailed(e)
-		case *eventServeFailed:
-			r.handleEventServeFailed(e)
-		case *eventReload:
-			r.handleEventReload(e)
-		case *eventClose:
-			r.handleEventClose(e)
-			// NOTE: We don't close hs.eventChan,
-			// in case of panic of any other goroutines
-			// to send event to it later.
-			return
-		default:
-			logger.Errorf("BUG: unknown event: %T\n", e)
+	for range r.events.notify {
+		for e := r.events.next(); e != nil; e = r.events.next() {
+			switch e := e.(type) {
+			case *eventCheckFailed:
+				r.handleEventCheckFailed(e)
+			case *eventServeFailed:
+				r.handleEventServeFailed(e)
+			case *eventReload:
+				r.handleEventReload(e)
+			case *eventWarmedUp:
+				r.handleEventWarmedUp(e)
+			case *eventClose:
+				r.handleEventClose(e)
+				// NOTE: We don't close the notify channel of the events,
+				// events sent to it later are dropped.
+				return
+			default:
+				logger.Errorf("BUG: unknown event: %T\n", e)
+			}
 		}
 	}
 }
@@ -163,9 +330,16 @@ func (r *runtime) reload(nextSuperSpec *supervisor.Spec, muxMapper protocol.MuxM
 
 	nextSpec := nextSuperSpec.ObjectSpec().(*Spec)
 
-	// r.limitListener is not created just after the process started and the config load for the first time.
-	if nextSpec != nil && r.limitListener != nil {
-		r.limitListener.SetMaxConnection(nextSpec.MaxConnections)
+	// r.limitListeners are not created just after the process started and the config load for the first time.
+	if nextSpec != nil {
+		for _, limitListener := range r.limitListeners {
+			limitListener.SetMaxConnection(nextSpec.MaxConnections)
+		}
+	}
+
+	if nextSpec != nil {
+		r.connTracker.setTimeouts(nextSpec.keepAliveTimeout(), nextSpec.maxConnectionLifetime())
+		r.connTracker.setMaxRequests(nextSpec.MaxRequestsPerConnection)
 	}
 
 	// NOTE: Due to the mechanism of supervisor,
@@ -177,17 +351,25 @@ func (r *runtime) reload(nextSuperSpec *supervisor.Spec, muxMapper protocol.MuxM
 	case r.spec == nil && nextSpec != nil:
 		r.spec = nextSpec
 		r.startServer()
+		r.startWarmUp(true)
 	case r.spec != nil && nextSpec == nil:
 		logger.Errorf("BUG: nextSpec is nil")
 		r.spec = nil
 		r.closeServer()
 	case r.spec != nil && nextSpec != nil:
-		if r.needRestartServer(nextSpec) {
+		if r.needRestartServer(nextSpec) && r.spec.Port != nextSpec.Port && !sameUnixSocket(r.spec, nextSpec) {
+			r.spec = nextSpec
+			r.switchServer()
+			r.startWarmUp(true)
+		} else if r.needRestartServer(nextSpec) {
 			r.spec = nextSpec
 			r.closeServer()
 			r.startServer()
+			r.startWarmUp(true)
 		} else {
 			r.spec = nextSpec
+			r.updateCertificates()
+			r.startWarmUp(false)
 		}
 	}
 }
@@ -227,114 +409,525 @@ func (r *runtime) needRestartServer(nextSpec *Spec) bool {
 	x.XForwardedFor, y.XForwardedFor = false, false
 	x.Tracing, y.Tracing = nil, nil
 	x.IPFilter, y.IPFilter = nil, nil
+	x.ClientIP, y.ClientIP = nil, nil
+	x.ForwardClientCert, y.ForwardClientCert = nil, nil
 	x.Rules, y.Rules = nil, nil
+	x.WarmUp, y.WarmUp = nil, nil
+	x.MaxConnectionLifetime, y.MaxConnectionLifetime = "", ""
+	x.MaxRequestsPerConnection, y.MaxRequestsPerConnection = 0, 0
+	x.CertBase64, y.CertBase64 = "", ""
+	x.KeyBase64, y.KeyBase64 = "", ""
+	x.Certs, y.Certs = nil, nil
+	x.Keys, y.Keys = nil, nil
+	x.CaCertBase64, y.CaCertBase64 = "", ""
+	x.ShutdownTimeout, y.ShutdownTimeout = "", ""
 
 	// The update of rules need not to shutdown server.
 	return !reflect.DeepEqual(x, y)
 }
 
 func (r *runtime) startServer() {
-	keepAliveTimeout := defaultKeepAliveTimeout
-	if r.spec.KeepAliveTimeout != "" {
-		t, err := time.ParseDuration(r.spec.KeepAliveTimeout)
-		if err != nil {
-			logger.Errorf("BUG: parse duration %s failed: %v",
-				r.spec.KeepAliveTimeout, err)
-		} else {
-			keepAliveTimeout = t
-		}
-	}
-
+	r.server, r.server3 = nil, nil
+	r.limitListeners = map[string]*limitlistener.LimitListener{}
+	r.listeners.Range(func(key, value interface{}) bool {
+		r.listeners.Delete(key)
+		return true
+	})
+
+	readTimeout, readHeaderTimeout, writeTimeout := r.spec.timeouts()
 	srv := &http.Server{
-		Addr:        fmt.Sprintf(":%d", r.spec.Port),
-		Handler:     r.mux,
-		IdleTimeout: keepAliveTimeout,
+		Addr:              r.spec.listenAddresses()[0],
+		Handler:           r.mux,
+		ReadTimeout:       readTimeout,
+		ReadHeaderTimeout: readHeaderTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       r.spec.keepAliveTimeout(),
+		ConnState:         r.connTracker.connState,
 	}
 	srv.SetKeepAlivesEnabled(r.spec.KeepAlive)
 
+	r.setupOCSPStapling()
 	if r.spec.HTTPS {
-		tlsConfig, _ := r.spec.tlsConfig()
+		r.updateCertificates()
+		tlsConfig := r.spec.newTLSConfig(r.loadCertificates)
+		r.connStat.WrapServerTLSConfig(tlsConfig)
 		srv.TLSConfig = tlsConfig
+		srv.ErrorLog = r.connStat.ServerErrorLog(os.Stderr)
+		if err := configureHTTP2(srv, r.spec.HTTP2); err != nil {
+			r.setState(stateFailed)
+			r.setError(fmt.Errorf("configure http2 failed: %v", err))
+
+			return
+		}
+	}
+	r.setupSessionTicketKeys(srv.TLSConfig)
+
+	if r.spec.H2C {
+		if err := configureH2C(srv, r.spec.HTTP2); err != nil {
+			r.setState(stateFailed)
+			r.setError(fmt.Errorf("configure h2c failed: %v", err))
+
+			return
+		}
+	}
+
+	if r.spec.HTTP3 {
+		// NOTE: The HTTP/3 server shares the handler with the HTTP/1.x
+		// and HTTP/2 one, so they share the mux and the statistics.
+		server3 := &http3.Server{Server: srv}
+		srv.Handler = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			// Advertise HTTP/3 to clients connecting over TCP.
+			if req.ProtoMajor < 3 {
+				server3.SetQuicHeaders(w.Header())
+			}
+			r.mux.ServeHTTP(w, req)
+		})
+		r.server3 = server3
 	}
+	srv.Handler = r.connTracker.handler(srv.Handler)
 
 	r.server = srv
-	r.startNum++
 	r.setState(stateRunning)
 	r.setError(nil)
 
-	if r.spec.HTTP3 {
-		r.server3 = &http3.Server{
-			Server: r.server,
+	if r.spec.Port != 0 {
+		for _, address := range r.spec.listenAddresses() {
+			r.startTCPListener(address)
 		}
-		go r.runHTTP3Server(r.startNum)
+	}
+	if r.spec.UnixSocket != nil {
+		r.startUnixListener()
+	}
+	if r.server3 != nil {
+		r.startQUICListener()
+	}
+	if r.spec.HTTPRedirect != nil {
+		r.startRedirectServer(readHeaderTimeout)
+	}
+}
+
+// startRedirectServer starts the plain HTTP server redirecting requests
+// to HTTPS, it shares nothing with the HTTPS server but the FSM.
+func (r *runtime) startRedirectServer(readHeaderTimeout time.Duration) {
+	r.redirectServer = &http.Server{
+		Handler:           newRedirectHandler(r.spec.HTTPRedirect, r.spec.Port),
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       r.spec.keepAliveTimeout(),
+	}
+	r.redirectServer.SetKeepAlivesEnabled(r.spec.KeepAlive)
+
+	for _, address := range r.spec.HTTPRedirect.listenAddresses(r.spec) {
+		r.startRedirectListener(address)
+	}
+}
+
+func (r *runtime) startRedirectListener(address string) {
+	name := listenerName(protocolRedirect, address)
+	r.startNums[name]++
+	startNum := r.startNums[name]
+
+	listener, err := gnet.Listen("tcp", address)
+	if err != nil {
+		r.setListenerFailed(name, err)
+		return
+	}
+
+	r.listeners.Store(name, &ListenerStatus{State: stateRunning})
+	go r.runRedirectServer(name, r.redirectServer, listener, startNum)
+}
+
+// closeRedirectServer closes the redirect server immediately, as there's
+// nothing worth draining in redirections, and it releases the port for
+// the next start.
+func (r *runtime) closeRedirectServer() {
+	if r.redirectServer == nil {
+		return
+	}
+	if err := r.redirectServer.Close(); err != nil {
+		logger.Warnf("close redirect server of %s failed: %v", r.superSpec.Name(), err)
+	}
+	r.redirectServer = nil
+}
+
+// listenerName returns the name of the listener, it is the protocol for
+// the listener on all interfaces, and <protocol>://<address> otherwise.
+func listenerName(protocol, address string) string {
+	if strings.HasPrefix(address, ":") {
+		return protocol
+	}
+	return protocol + "://" + address
+}
+
+func (r *runtime) startTCPListener(address string) {
+	name := listenerName(protocolTCP, address)
+	r.startNums[name]++
+	startNum := r.startNums[name]
+
+	var (
+		listener net.Listener
+		err      error
+	)
+	if len(r.spec.Hosts) != 0 {
+		// NOTE: The shared port is listened by the first server joining
+		// it, and closed after the last one leaves.
+		listener, err = sharedPorts.join(address, r.superSpec.Name(), r.spec.HTTPS, r.spec.Hosts)
+	} else if r.spec.TCP != nil && r.spec.TCP.ReusePort {
+		// NOTE: The listener with SO_REUSEPORT is not inherited on graceful
+		// updates, the new process listens on the same port by itself.
+		listener, err = tcpoption.Listen("tcp", address, r.spec.TCP)
 	} else {
-		listener, err := gnet.Listen("tcp", fmt.Sprintf(":%d", r.spec.Port))
-		if err != nil {
-			r.setState(stateFailed)
-			r.setError(err)
+		listener, err = gnet.Listen("tcp", address)
+	}
+	if err != nil {
+		r.setListenerFailed(name, err)
+		return
+	}
 
-			return
+	if r.spec.TCP != nil {
+		listener = tcpoption.NewListener(listener, r.spec.TCP)
+	}
+
+	r.listeners.Store(name, &ListenerStatus{State: stateRunning})
+	if r.spec.Sniffing != nil {
+		sl := newSniffListener(r.wrapListener(name, listener), r.spec.Sniffing, r.spec.HTTPS, r.sniffStat)
+		go r.runSniffingServer(name, sl, startNum)
+		return
+	}
+	go r.runHTTP1And2Server(name, r.wrapListener(name, listener), r.spec.HTTPS, startNum)
+}
+
+func (r *runtime) startUnixListener() {
+	r.startNums[protocolUnix]++
+	startNum := r.startNums[protocolUnix]
+
+	listener, err := listenUnix(r.spec.UnixSocket)
+	if err != nil {
+		r.setListenerFailed(protocolUnix, err)
+		return
+	}
+
+	r.listeners.Store(protocolUnix, &ListenerStatus{State: stateRunning})
+	go r.runHTTP1And2Server(protocolUnix, r.wrapListener(protocolUnix, listener), r.spec.HTTPS, startNum)
+}
+
+// wrapListener wraps the listener of HTTP/1.x and HTTP/2 with the
+// connection limit and the options of the spec.
+func (r *runtime) wrapListener(name string, listener net.Listener) net.Listener {
+	limitListener := limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
+	r.limitListeners[name] = limitListener
+
+	var l net.Listener = limitListener
+	if r.spec.ProxyProtocol {
+		// NOTE: The header is sent before anything else, including the
+		// TLS handshake, so it must be parsed first.
+		l = proxyprotocol.NewListener(l, proxyProtocolTimeout)
+	}
+	if r.spec.TLSFingerprint {
+		fpListener := tlsfingerprint.NewListener(l)
+		r.server.ConnContext = fpListener.ConnContext
+		l = fpListener
+	}
+	if r.spec.PreserveHeaderCase || r.spec.HTTP10Compatible {
+		l = http1compat.NewListener(l, &http1compat.Options{
+			PreserveHeaderCase: r.spec.PreserveHeaderCase,
+			AddMissingHost:     r.spec.HTTP10Compatible,
+		})
+		r.server.ConnContext = http1compat.ConnContext
+	}
+
+	return l
+}
+
+func (r *runtime) startQUICListener() {
+	r.startNums[protocolQUIC]++
+	startNum := r.startNums[protocolQUIC]
+
+	// NOTE: The HTTP/3 server listens asynchronously, failures are
+	// reported by serve failure events.
+	r.listeners.Store(protocolQUIC, &ListenerStatus{State: stateRunning})
+	go r.runHTTP3Server(r.server3, startNum)
+}
+
+func (r *runtime) setListenerFailed(name string, err error) {
+	r.listeners.Store(name, &ListenerStatus{State: stateFailed, Error: err.Error()})
+	r.setState(stateFailed)
+	r.setError(fmt.Errorf("%s: %v", name, err))
+}
+
+func (r *runtime) listenerFailed(name string) bool {
+	value, ok := r.listeners.Load(name)
+	return ok && value.(*ListenerStatus).State == stateFailed
+}
+
+// restartFailedListeners restarts the failed listeners only, so the
+// failure of a listener doesn't interrupt the others.
+func (r *runtime) restartFailedListeners() {
+	var failedTCP []string
+	if r.spec.Port != 0 {
+		for _, address := range r.spec.listenAddresses() {
+			if r.listenerFailed(listenerName(protocolTCP, address)) {
+				failedTCP = append(failedTCP, address)
+			}
+		}
+	}
+	failedUnix := r.listenerFailed(protocolUnix)
+	failedQUIC := r.server3 != nil && r.listenerFailed(protocolQUIC)
+	var failedRedirect []string
+	if r.redirectServer != nil {
+		for _, address := range r.spec.HTTPRedirect.listenAddresses(r.spec) {
+			if r.listenerFailed(listenerName(protocolRedirect, address)) {
+				failedRedirect = append(failedRedirect, address)
+			}
 		}
+	}
+
+	r.setState(stateRunning)
+	r.setError(nil)
+
+	for _, address := range failedTCP {
+		r.startTCPListener(address)
+	}
+	if failedUnix {
+		r.startUnixListener()
+	}
+	if failedQUIC {
+		r.startQUICListener()
+	}
+	for _, address := range failedRedirect {
+		r.startRedirectListener(address)
+	}
+}
+
+func (r *runtime) setupSessionTicketKeys(tlsConfig *tls.Config) {
+	spec := r.spec.SessionTicket
+	if spec == nil || tlsConfig == nil {
+		r.closeSessionTicketKeys()
+		return
+	}
+
+	if stk := r.loadSessionTicketKeys(); stk != nil && stk.interval != spec.interval() {
+		r.closeSessionTicketKeys()
+	}
+	stk := r.loadSessionTicketKeys()
+	if stk == nil {
+		stk = newSessionTicketKeys(r.superSpec.Super().Cluster(), r.superSpec.Name(), spec)
+		r.sessionTicketKeys.Store(stk)
+	}
+	stk.setTLSConfig(tlsConfig)
+}
 
-		limitListener := limitlistener.NewLimitListener(listener, r.spec.MaxConnections)
-		r.limitListener = limitListener
-		go r.runHTTP1And2Server(limitListener, r.spec.HTTPS, r.startNum)
+func (r *runtime) loadSessionTicketKeys() *sessionTicketKeys {
+	stk, _ := r.sessionTicketKeys.Load().(*sessionTicketKeys)
+	return stk
+}
+
+// updateCertificates loads the certificates of the current spec, they're
+// used by new TLS connections immediately.
+func (r *runtime) updateCertificates() {
+	if !r.spec.HTTPS {
+		return
 	}
+
+	// NOTE: The spec has been validated, so the error is impossible.
+	certs, err := r.spec.certificates()
+	if err != nil {
+		logger.Errorf("BUG: load certificates failed: %v", err)
+		return
+	}
+	if stapler := r.loadOCSPStapler(); stapler != nil {
+		stapler.setCertificates(certs)
+		return
+	}
+	r.certs.Store(certs)
 }
 
-func (r *runtime) runHTTP3Server(startNum uint64) {
-	err := r.server3.ListenAndServe()
+// setupOCSPStapling creates or closes the OCSP stapler according to the
+// spec, it must be called before updateCertificates.
+func (r *runtime) setupOCSPStapling() {
+	spec := r.spec.OCSPStapling
+	if spec == nil {
+		r.closeOCSPStapler()
+		return
+	}
+
+	if stapler := r.loadOCSPStapler(); stapler != nil && stapler.timeout != spec.timeout() {
+		r.closeOCSPStapler()
+	}
+	if r.loadOCSPStapler() == nil {
+		r.ocspStapler.Store(newOCSPStapler(spec, func(certs *certificates) {
+			r.certs.Store(certs)
+		}))
+	}
+}
+
+func (r *runtime) loadOCSPStapler() *ocspStapler {
+	stapler, _ := r.ocspStapler.Load().(*ocspStapler)
+	return stapler
+}
+
+func (r *runtime) closeOCSPStapler() {
+	if stapler := r.loadOCSPStapler(); stapler != nil {
+		stapler.close()
+		r.ocspStapler.Store((*ocspStapler)(nil))
+	}
+}
+
+func (r *runtime) loadCertificates() *certificates {
+	if certs, ok := r.certs.Load().(*certificates); ok {
+		return certs
+	}
+	return &certificates{}
+}
+
+func (r *runtime) closeSessionTicketKeys() {
+	if stk := r.loadSessionTicketKeys(); stk != nil {
+		stk.close()
+		r.sessionTicketKeys.Store((*sessionTicketKeys)(nil))
+	}
+}
+
+func (r *runtime) runHTTP3Server(server3 *http3.Server, startNum uint64) {
+	err := server3.ListenAndServe()
 	if err != http.ErrServerClosed {
-		r.eventChan <- &eventServeFailed{
+		r.events.send(&eventServeFailed{
+			listener: protocolQUIC,
 			err:      err,
 			startNum: startNum,
-		}
+		})
 	}
 }
 
-func (r *runtime) runHTTP1And2Server(limitListener *limitlistener.LimitListener, https bool, startNum uint64) {
+func (r *runtime) runRedirectServer(name string, server *http.Server, listener net.Listener, startNum uint64) {
+	err := server.Serve(listener)
+	if err != http.ErrServerClosed {
+		r.events.send(&eventServeFailed{
+			listener: name,
+			err:      err,
+			startNum: startNum,
+		})
+	}
+}
+
+func (r *runtime) runHTTP1And2Server(name string, listener net.Listener, https bool, startNum uint64) {
 	var err error
 	if https {
-		err = r.server.ServeTLS(limitListener, "", "")
+		err = r.server.ServeTLS(listener, "", "")
 	} else {
-		err = r.server.Serve(limitListener)
+		err = r.server.Serve(listener)
 	}
 	if err != http.ErrServerClosed {
-		r.eventChan <- &eventServeFailed{
+		r.events.send(&eventServeFailed{
+			listener: name,
 			err:      err,
 			startNum: startNum,
-		}
+		})
 	}
 }
 
+// runSniffingServer serves the HTTP and TLS connections dispatched by
+// the sniffing listener, the failure of either one is reported once.
+func (r *runtime) runSniffingServer(name string, l *sniffListener, startNum uint64) {
+	go l.run()
+
+	errs := make(chan error, 2)
+	serve := func(fn func() error) {
+		errs <- fn()
+		// NOTE: Closing the listener makes the other serving return.
+		l.Close()
+	}
+	go serve(func() error { return r.server.Serve(l.plain) })
+	if l.https {
+		go serve(func() error { return r.server.ServeTLS(l.tls, "", "") })
+	}
+
+	// The first returned error is the cause.
+	if err := <-errs; err != http.ErrServerClosed {
+		r.events.send(&eventServeFailed{
+			listener: name,
+			err:      err,
+			startNum: startNum,
+		})
+	}
+}
+
+// closeServer shuts down the server, the state is draining until the
+// in-flight requests finish or the shutdown timeout is reached.
 func (r *runtime) closeServer() {
-	if r.server3 != nil {
-		err := r.server3.Close()
+	r.closePreviousServer()
+	r.closeRedirectServer()
+	if r.server != nil {
+		r.setState(stateDraining)
+	}
+	shutdownServer(r.superSpec.Name(), r.server, r.server3, r.shutdownTimeout())
+}
+
+func (r *runtime) shutdownTimeout() time.Duration {
+	if r.spec == nil {
+		return defaultShutdownTimeout
+	}
+	return r.spec.shutdownTimeout()
+}
+
+func shutdownServer(name string, server *http.Server, server3 *http3.Server, timeout time.Duration) {
+	if server3 != nil {
+		err := server3.Close()
 		if err != nil {
-			logger.Warnf("shutdown http3 server %s failed: %v",
-				r.superSpec.Name(), err)
+			logger.Warnf("shutdown http3 server %s failed: %v", name, err)
 		}
-		return
 	}
 
-	if r.server != nil {
+	if server != nil {
 		// NOTE: It's safe to shutdown serve failed server.
-		ctx, cancelFunc := serverShutdownContext()
+		ctx, cancelFunc := serverShutdownContext(timeout)
 		defer cancelFunc()
-		err := r.server.Shutdown(ctx)
+		err := server.Shutdown(ctx)
 		if err != nil {
-			logger.Warnf("shutdown http1/2 server %s failed: %v",
-				r.superSpec.Name(), err)
+			logger.Warnf("shutdown http1/2 server %s failed: %v", name, err)
 		}
 	}
 }
 
+// switchServer starts the server on the new port before shutting down
+// the one on the old port, so there's no gap in serving. If the new
+// server fails to listen, the old one keeps serving until a later start
+// succeeds.
+func (r *runtime) switchServer() {
+	if r.previous == nil {
+		r.previous = &previousServer{server: r.server, server3: r.server3}
+	} else {
+		// NOTE: The previous server is still serving, as the current
+		// one failed to start, it's safe to shutdown the current one.
+		shutdownServer(r.superSpec.Name(), r.server, r.server3, r.shutdownTimeout())
+	}
+	r.server, r.server3 = nil, nil
+	// NOTE: The redirect server may listen on the same port after the
+	// switch, so it must be closed before the start.
+	r.closeRedirectServer()
+
+	r.startServer()
+	if r.getState() != stateFailed {
+		r.closePreviousServer()
+	}
+}
+
+// closePreviousServer shuts down the server on the old port in the
+// background, in-flight requests on it are drained.
+func (r *runtime) closePreviousServer() {
+	if r.previous == nil {
+		return
+	}
+
+	name, previous, timeout := r.superSpec.Name(), r.previous, r.shutdownTimeout()
+	r.previous = nil
+	go shutdownServer(name, previous.server, previous.server3, timeout)
+}
+
 func (r *runtime) checkFailed() {
 	ticker := time.NewTicker(checkFailedTimeout)
 	for range ticker.C {
 		state := r.getState()
 		if state == stateFailed {
-			r.eventChan <- &eventCheckFailed{}
+			r.events.send(&eventCheckFailed{})
 		} else if state == stateClosed {
 			ticker.Stop()
 			return
@@ -344,24 +937,41 @@ func (r *runtime) checkFailed() {
 
 func (r *runtime) handleEventCheckFailed(e *eventCheckFailed) {
 	if r.getState() == stateFailed {
-		r.startServer()
+		if r.server == nil {
+			r.startServer()
+		} else {
+			r.restartFailedListeners()
+		}
+		if r.getState() != stateFailed {
+			r.closePreviousServer()
+		}
+		r.startWarmUp(true)
 	}
 }
 
 func (r *runtime) handleEventServeFailed(e *eventServeFailed) {
-	if r.startNum > e.startNum {
+	if r.startNums[e.listener] > e.startNum {
 		return
 	}
-	r.setState(stateFailed)
-	r.setError(e.err)
+	r.setListenerFailed(e.listener, e.err)
 }
 
 func (r *runtime) handleEventReload(e *eventReload) {
 	r.reload(e.nextSuperSpec, e.muxMapper)
+
+	var sniffing int32
+	if r.spec != nil && r.spec.Sniffing != nil {
+		sniffing = 1
+	}
+	atomic.StoreInt32(&r.sniffing, sniffing)
 }
 
 func (r *runtime) handleEventClose(e *eventClose) {
 	r.closeServer()
+	r.setState(stateClosed)
+	r.closeSessionTicketKeys()
+	r.closeOCSPStapler()
+	r.connTracker.close()
 	r.mux.close()
 	close(e.done)
 }
@@ -0,0 +1,262 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+func TestHandleEventServeFailed(t *testing.T) {
+	r := &runtime{startNums: map[string]uint64{protocolTCP: 1, protocolQUIC: 2}}
+	r.setState(stateRunning)
+	r.setError(nil)
+	r.listeners.Store(protocolTCP, &ListenerStatus{State: stateRunning})
+	r.listeners.Store(protocolQUIC, &ListenerStatus{State: stateRunning})
+
+	// Failure of a stale listener is ignored.
+	r.handleEventServeFailed(&eventServeFailed{listener: protocolQUIC, startNum: 1, err: fmt.Errorf("stale")})
+	if r.getState() != stateRunning || r.listenerFailed(protocolQUIC) {
+		t.Fatalf("stale serve failure should be ignored")
+	}
+
+	r.handleEventServeFailed(&eventServeFailed{listener: protocolQUIC, startNum: 2, err: fmt.Errorf("boom")})
+	if r.getState() != stateFailed {
+		t.Fatalf("want state %s, got %s", stateFailed, r.getState())
+	}
+	if !r.listenerFailed(protocolQUIC) {
+		t.Fatalf("quic listener should be failed")
+	}
+	if r.listenerFailed(protocolTCP) {
+		t.Fatalf("tcp listener should keep running")
+	}
+
+	value, _ := r.listeners.Load(protocolQUIC)
+	if value.(*ListenerStatus).Error != "boom" {
+		t.Fatalf("want error boom, got %s", value.(*ListenerStatus).Error)
+	}
+}
+
+func TestListenAddresses(t *testing.T) {
+	spec := &Spec{Port: 8080}
+	addresses := spec.listenAddresses()
+	if len(addresses) != 1 || addresses[0] != ":8080" {
+		t.Fatalf("unexpected addresses: %v", addresses)
+	}
+	if name := listenerName(protocolTCP, addresses[0]); name != protocolTCP {
+		t.Errorf("want name %s, got %s", protocolTCP, name)
+	}
+
+	spec.Address = "127.0.0.1"
+	spec.Addresses = []string{"10.0.0.5", "127.0.0.1", "::1"}
+	addresses = spec.listenAddresses()
+	want := []string{"127.0.0.1:8080", "10.0.0.5:8080", "[::1]:8080"}
+	if len(addresses) != len(want) {
+		t.Fatalf("want addresses %v, got %v", want, addresses)
+	}
+	for i := range want {
+		if addresses[i] != want[i] {
+			t.Errorf("want address %s, got %s", want[i], addresses[i])
+		}
+	}
+	if name := listenerName(protocolTCP, addresses[0]); name != "tcp://127.0.0.1:8080" {
+		t.Errorf("want name tcp://127.0.0.1:8080, got %s", name)
+	}
+}
+
+func TestShutdownServerTimeout(t *testing.T) {
+	logger.InitNop()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	started, done := make(chan struct{}), make(chan struct{})
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-done
+	})}
+	go server.Serve(listener)
+	defer close(done)
+
+	go http.Get("http://" + listener.Addr().String())
+	<-started
+
+	start := time.Now()
+	shutdownServer("test", server, nil, 100*time.Millisecond)
+	if d := time.Since(start); d < 100*time.Millisecond || d > 5*time.Second {
+		t.Errorf("shutdown should wait for the timeout, took %v", d)
+	}
+}
+
+func newPortSpec(t *testing.T, port int) *supervisor.Spec {
+	superSpec, err := supervisor.NewSpec(fmt.Sprintf(`
+kind: HTTPServer
+name: http-server-test
+port: %d
+keepAlive: false
+https: false
+`, port))
+	if err != nil {
+		t.Fatalf("create spec failed: %v", err)
+	}
+	return superSpec
+}
+
+// freePort returns a port which is free at the moment.
+func freePort(t *testing.T) int {
+	listener, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func serving(port int) bool {
+	client := &http.Client{
+		Timeout:   time.Second,
+		Transport: &http.Transport{DisableKeepAlives: true},
+	}
+	resp, err := client.Get(fmt.Sprintf("http://127.0.0.1:%d/", port))
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+func waitFor(t *testing.T, msg string, cond func() bool) {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatalf("timeout waiting for %s", msg)
+}
+
+func TestSwitchPort(t *testing.T) {
+	logger.InitNop()
+
+	oldPort, newPort := freePort(t), freePort(t)
+
+	hs := &HTTPServer{}
+	hs.Init(newPortSpec(t, oldPort), nil)
+	defer hs.Close()
+	waitFor(t, "the old port serving", func() bool { return serving(oldPort) })
+
+	next := &HTTPServer{}
+	next.Inherit(newPortSpec(t, newPort), hs, nil)
+	waitFor(t, "the new port serving", func() bool { return serving(newPort) })
+
+	// The old port is closed once the new one serves.
+	waitFor(t, "the old port closed", func() bool { return !serving(oldPort) })
+	if status := next.runtime.Status(); status.State != stateRunning {
+		t.Errorf("want state %s, got %s: %s", stateRunning, status.State, status.Error)
+	}
+}
+
+func TestSwitchPortInUse(t *testing.T) {
+	logger.InitNop()
+
+	oldPort := freePort(t)
+	occupier, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer occupier.Close()
+	newPort := occupier.Addr().(*net.TCPAddr).Port
+
+	hs := &HTTPServer{}
+	hs.Init(newPortSpec(t, oldPort), nil)
+	defer hs.Close()
+	waitFor(t, "the old port serving", func() bool { return serving(oldPort) })
+
+	next := &HTTPServer{}
+	next.Inherit(newPortSpec(t, newPort), hs, nil)
+	waitFor(t, "the switch failed", func() bool {
+		return next.runtime.Status().State == stateFailed
+	})
+
+	// The old port keeps serving while the new one fails to listen.
+	status := next.runtime.Status()
+	if !strings.Contains(status.Error, fmt.Sprint(newPort)) {
+		t.Errorf("error should report port %d, got %q", newPort, status.Error)
+	}
+	if ls := status.Listeners[protocolTCP]; ls == nil || ls.State != stateFailed {
+		t.Errorf("tcp listener should be failed, got %+v", ls)
+	}
+	if !serving(oldPort) {
+		t.Fatalf("the old port should keep serving")
+	}
+
+	// The switch completes once the new port is released.
+	occupier.Close()
+	next.runtime.events.send(&eventCheckFailed{})
+	waitFor(t, "the new port serving", func() bool { return serving(newPort) })
+	waitFor(t, "the old port closed", func() bool { return !serving(oldPort) })
+	if status := next.runtime.Status(); status.State != stateRunning || status.Error != "" {
+		t.Errorf("want state %s without error, got %s: %s", stateRunning, status.State, status.Error)
+	}
+}
+
+func TestStatusSniffing(t *testing.T) {
+	logger.InitNop()
+
+	port := freePort(t)
+	sniffingSpec, err := supervisor.NewSpec(fmt.Sprintf(`
+kind: HTTPServer
+name: http-server-test
+port: %d
+keepAlive: false
+https: false
+sniffing:
+  timeout: 1s
+`, port))
+	if err != nil {
+		t.Fatalf("create spec failed: %v", err)
+	}
+
+	hs := &HTTPServer{}
+	hs.Init(newPortSpec(t, port), nil)
+	defer hs.Close()
+
+	// Status is read while the FSM reloads the spec, run with -race.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			hs.runtime.Status()
+		}
+	}()
+	next := &HTTPServer{}
+	next.Inherit(sniffingSpec, hs, nil)
+	<-done
+
+	waitFor(t, "sniffing in status", func() bool {
+		return next.runtime.Status().Sniffing != nil
+	})
+}
@@ -0,0 +1,304 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	sessionTicketKeysKey = "httpserver/sessionticketkeys/%s"
+
+	defaultSessionTicketRotation = 12 * time.Hour
+	minSessionTicketRotation     = time.Minute
+	sessionTicketCheckInterval   = time.Minute
+
+	// maxSessionTicketKeys is the number of keys kept, the first one is
+	// used to encrypt new tickets, and all of them are used to decrypt,
+	// so tickets issued before the last two rotations could be resumed.
+	maxSessionTicketKeys = 3
+)
+
+type (
+	// SessionTicketSpec describes the session ticket keys shared in the cluster.
+	SessionTicketSpec struct {
+		RotationInterval string `yaml:"rotationInterval" jsonschema:"omitempty,format=duration"`
+	}
+
+	// SessionTicketStatus is the status of the session ticket keys, the
+	// members sharing the keys have the same status.
+	SessionTicketStatus struct {
+		// Keys is the number of keys applied.
+		Keys int `yaml:"keys"`
+		// KeyID identifies the key encrypting new tickets, it is derived
+		// from the key by a one-way hash.
+		KeyID     string `yaml:"keyID,omitempty"`
+		RotatedAt string `yaml:"rotatedAt,omitempty"`
+	}
+
+	// sessionTicketData is the data saved in the cluster.
+	sessionTicketData struct {
+		Keys      [][]byte  `json:"keys"`
+		RotatedAt time.Time `json:"rotatedAt"`
+	}
+
+	// sessionTicketKeys syncs session ticket keys from the cluster and
+	// applies them to the TLS config, the leader rotates the keys.
+	sessionTicketKeys struct {
+		cls      cluster.Cluster
+		key      string
+		interval time.Duration
+
+		mutex     sync.Mutex
+		tlsConfig *tls.Config
+		keys      [][32]byte
+		rotatedAt time.Time
+
+		done chan struct{}
+	}
+)
+
+// Validate validates SessionTicketSpec.
+func (spec *SessionTicketSpec) Validate() error {
+	if spec.RotationInterval == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(spec.RotationInterval)
+	if err != nil {
+		return err
+	}
+	if d < minSessionTicketRotation {
+		return fmt.Errorf("rotationInterval should not be less than %s", minSessionTicketRotation)
+	}
+	return nil
+}
+
+func (spec *SessionTicketSpec) interval() time.Duration {
+	if spec.RotationInterval == "" {
+		return defaultSessionTicketRotation
+	}
+	d, err := time.ParseDuration(spec.RotationInterval)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", spec.RotationInterval, err)
+		return defaultSessionTicketRotation
+	}
+	return d
+}
+
+func newSessionTicketKeys(cls cluster.Cluster, serverName string, spec *SessionTicketSpec) *sessionTicketKeys {
+	stk := &sessionTicketKeys{
+		cls:      cls,
+		key:      fmt.Sprintf(sessionTicketKeysKey, serverName),
+		interval: spec.interval(),
+		done:     make(chan struct{}),
+	}
+
+	go stk.sync()
+	go stk.rotate()
+
+	return stk
+}
+
+// setTLSConfig sets the TLS config to apply keys, the current keys are
+// applied immediately if there are.
+func (stk *sessionTicketKeys) setTLSConfig(tlsConfig *tls.Config) {
+	stk.mutex.Lock()
+	defer stk.mutex.Unlock()
+
+	stk.tlsConfig = tlsConfig
+	if tlsConfig != nil && len(stk.keys) > 0 {
+		tlsConfig.SetSessionTicketKeys(stk.keys)
+	}
+}
+
+func (stk *sessionTicketKeys) apply(value *string) {
+	if value == nil {
+		return
+	}
+
+	data := &sessionTicketData{}
+	if err := json.Unmarshal([]byte(*value), data); err != nil {
+		logger.Errorf("unmarshal session ticket keys failed: %v", err)
+		return
+	}
+
+	var keys [][32]byte
+	for _, k := range data.Keys {
+		if len(k) != 32 {
+			logger.Errorf("invalid session ticket key length: %d", len(k))
+			return
+		}
+		var key [32]byte
+		copy(key[:], k)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	stk.mutex.Lock()
+	defer stk.mutex.Unlock()
+
+	stk.keys, stk.rotatedAt = keys, data.RotatedAt
+	if stk.tlsConfig != nil {
+		stk.tlsConfig.SetSessionTicketKeys(keys)
+	}
+}
+
+func (stk *sessionTicketKeys) sync() {
+	var (
+		syncer *cluster.Syncer
+		err    error
+		ch     <-chan *string
+	)
+
+	for {
+		syncer, err = stk.cls.Syncer(time.Minute)
+		if err != nil {
+			logger.Errorf("failed to create syncer: %v", err)
+		} else if ch, err = syncer.Sync(stk.key); err != nil {
+			logger.Errorf("failed to sync %s: %v", stk.key, err)
+			syncer.Close()
+		} else {
+			break
+		}
+
+		select {
+		case <-time.After(10 * time.Second):
+		case <-stk.done:
+			return
+		}
+	}
+	defer syncer.Close()
+
+	for {
+		select {
+		case <-stk.done:
+			return
+		case value := <-ch:
+			stk.apply(value)
+		}
+	}
+}
+
+// nextSessionTicketData returns the data with a new key prepended if the
+// keys need to be rotated, or nil if not.
+func nextSessionTicketData(value *string, now time.Time, interval time.Duration) (*sessionTicketData, error) {
+	data := &sessionTicketData{}
+	if value != nil {
+		if err := json.Unmarshal([]byte(*value), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(data.Keys) > 0 && now.Sub(data.RotatedAt) < interval {
+		return nil, nil
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	keys := append([][]byte{key}, data.Keys...)
+	if len(keys) > maxSessionTicketKeys {
+		keys = keys[:maxSessionTicketKeys]
+	}
+	return &sessionTicketData{Keys: keys, RotatedAt: now}, nil
+}
+
+func (stk *sessionTicketKeys) rotateIfNeeded() {
+	if !stk.cls.IsLeader() {
+		return
+	}
+
+	value, err := stk.cls.Get(stk.key)
+	if err != nil {
+		logger.Errorf("get session ticket keys failed: %v", err)
+		return
+	}
+
+	data, err := nextSessionTicketData(value, time.Now(), stk.interval)
+	if err != nil {
+		logger.Errorf("rotate session ticket keys failed: %v", err)
+		return
+	}
+	if data == nil {
+		return
+	}
+
+	buff, err := json.Marshal(data)
+	if err != nil {
+		logger.Errorf("BUG: marshal session ticket keys failed: %v", err)
+		return
+	}
+	if err = stk.cls.Put(stk.key, string(buff)); err != nil {
+		logger.Errorf("put session ticket keys failed: %v", err)
+		return
+	}
+	logger.Infof("session ticket keys %s rotated", stk.key)
+}
+
+func (stk *sessionTicketKeys) rotate() {
+	stk.rotateIfNeeded()
+
+	ticker := time.NewTicker(sessionTicketCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stk.done:
+			return
+		case <-ticker.C:
+			stk.rotateIfNeeded()
+		}
+	}
+}
+
+func (stk *sessionTicketKeys) status() *SessionTicketStatus {
+	stk.mutex.Lock()
+	defer stk.mutex.Unlock()
+
+	status := &SessionTicketStatus{Keys: len(stk.keys)}
+	if len(stk.keys) > 0 {
+		status.KeyID = sessionTicketKeyID(stk.keys[0])
+		status.RotatedAt = stk.rotatedAt.Format(time.RFC3339)
+	}
+	return status
+}
+
+// sessionTicketKeyID returns the ID of the key, which is the first 8
+// bytes of its SHA-256 digest in hex.
+func sessionTicketKeyID(key [32]byte) string {
+	sum := sha256.Sum256(key[:])
+	return hex.EncodeToString(sum[:8])
+}
+
+func (stk *sessionTicketKeys) close() {
+	close(stk.done)
+}
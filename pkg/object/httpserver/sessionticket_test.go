@@ -0,0 +1,104 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestNextSessionTicketData(t *testing.T) {
+	now := time.Now()
+
+	data, err := nextSessionTicketData(nil, now, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data == nil || len(data.Keys) != 1 || len(data.Keys[0]) != 32 {
+		t.Fatalf("expect one 32 bytes key, got %+v", data)
+	}
+
+	for i := 0; i < maxSessionTicketKeys+1; i++ {
+		buff, _ := json.Marshal(data)
+		value := string(buff)
+
+		next, err := nextSessionTicketData(&value, data.RotatedAt.Add(time.Minute), time.Hour)
+		if err != nil || next != nil {
+			t.Fatalf("expect no rotation, got %+v, %v", next, err)
+		}
+
+		next, err = nextSessionTicketData(&value, data.RotatedAt.Add(time.Hour), time.Hour)
+		if err != nil || next == nil {
+			t.Fatalf("expect rotation, got %v", err)
+		}
+		if string(next.Keys[1]) != string(data.Keys[0]) {
+			t.Fatalf("expect previous key to be kept")
+		}
+		if len(next.Keys) > maxSessionTicketKeys {
+			t.Fatalf("expect at most %d keys, got %d", maxSessionTicketKeys, len(next.Keys))
+		}
+		data = next
+	}
+
+	value := "invalid"
+	if _, err = nextSessionTicketData(&value, now, time.Hour); err == nil {
+		t.Fatalf("expect error for invalid data")
+	}
+}
+
+func TestSessionTicketSpecValidate(t *testing.T) {
+	spec := &SessionTicketSpec{}
+	if spec.Validate() != nil || spec.interval() != defaultSessionTicketRotation {
+		t.Fatalf("expect default rotation interval")
+	}
+
+	spec.RotationInterval = "10s"
+	if spec.Validate() == nil {
+		t.Fatalf("expect error for too short interval")
+	}
+
+	spec.RotationInterval = "1h"
+	if spec.Validate() != nil || spec.interval() != time.Hour {
+		t.Fatalf("expect 1h rotation interval")
+	}
+}
+
+func TestSessionTicketKeysStatus(t *testing.T) {
+	stk := &sessionTicketKeys{}
+	if status := stk.status(); status.Keys != 0 || status.KeyID != "" {
+		t.Fatalf("expect empty status, got %+v", status)
+	}
+
+	data, _ := nextSessionTicketData(nil, time.Now(), time.Hour)
+	buff, _ := json.Marshal(data)
+	value := string(buff)
+	stk.apply(&value)
+
+	status := stk.status()
+	if status.Keys != 1 || len(status.KeyID) != 16 || status.RotatedAt != data.RotatedAt.Format(time.RFC3339) {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+
+	// The ID is stable, so the members sharing keys report the same one.
+	other := &sessionTicketKeys{}
+	other.apply(&value)
+	if other.status().KeyID != status.KeyID {
+		t.Fatalf("expect the same key ID")
+	}
+}
@@ -0,0 +1,357 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	// sharedPortReadTimeout is the max time to read the ClientHello or
+	// the first request of connections to the shared ports.
+	sharedPortReadTimeout = 10 * time.Second
+	// sharedPortAnyHost is the host of the server serving the
+	// connections unmatched by other servers.
+	sharedPortAnyHost = "*"
+)
+
+var (
+	sharedPorts = &sharedPortRegistry{ports: map[string]*sharedPort{}}
+
+	// errHelloRead aborts the handshake once the ClientHello has been read.
+	errHelloRead = errors.New("client hello read")
+
+	misdirectedResponse = []byte("HTTP/1.1 421 Misdirected Request\r\n" +
+		"Content-Length: 0\r\nConnection: close\r\n\r\n")
+)
+
+type (
+	// sharedPortRegistry contains the ports shared by the HTTPServers
+	// declaring hosts, the key is the listening address.
+	sharedPortRegistry struct {
+		mutex sync.Mutex
+		ports map[string]*sharedPort
+	}
+
+	// sharedPort owns the listener of a port shared by HTTPServers, it
+	// dispatches connections to the servers by SNI for https or by the
+	// Host header of the first request for http.
+	sharedPort struct {
+		registry *sharedPortRegistry
+		address  string
+		listener net.Listener
+		https    bool
+
+		// members and hosts are protected by the mutex of the registry.
+		members map[string]*sharedPortMember
+		hosts   map[string]*sharedPortMember
+	}
+
+	// sharedPortMember is the listener of a server on the shared port,
+	// closing it leaves the port.
+	sharedPortMember struct {
+		port  *sharedPort
+		name  string
+		hosts []string
+		conns chan net.Conn
+
+		closeOnce sync.Once
+		done      chan struct{}
+		err       error
+	}
+
+	// readOnlyConn feeds the TLS server with the client data and refuses
+	// to write anything back, so the client never sees the aborted
+	// handshake.
+	readOnlyConn struct {
+		net.Conn
+		reader io.Reader
+	}
+)
+
+// validateSharedHosts validates the hosts of a server sharing the port.
+func validateSharedHosts(hosts []string) error {
+	seen := map[string]bool{}
+	for _, host := range hosts {
+		h := strings.ToLower(strings.TrimSuffix(host, "."))
+		switch {
+		case h == "":
+			return fmt.Errorf("empty host")
+		case strings.ContainsAny(h, ":/"):
+			return fmt.Errorf("invalid host %s", host)
+		case strings.Contains(h[1:], "*"), h[0] == '*' && h != sharedPortAnyHost && !strings.HasPrefix(h, "*."):
+			return fmt.Errorf("invalid wildcard host %s", host)
+		case seen[h]:
+			return fmt.Errorf("duplicated host %s", host)
+		}
+		seen[h] = true
+	}
+	return nil
+}
+
+// normalizeHost removes the port and the trailing dot of the host, and
+// converts it to lower case.
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// join joins the server to the shared port of the address, the port is
+// listened by the first server joining it.
+func (r *sharedPortRegistry) join(address, name string, https bool, hosts []string) (*sharedPortMember, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p := r.ports[address]
+	if p == nil {
+		listener, err := gnet.Listen("tcp", address)
+		if err != nil {
+			return nil, err
+		}
+		p = &sharedPort{
+			registry: r,
+			address:  address,
+			listener: listener,
+			https:    https,
+			members:  map[string]*sharedPortMember{},
+			hosts:    map[string]*sharedPortMember{},
+		}
+		r.ports[address] = p
+		go p.run()
+	}
+
+	if p.https != https {
+		return nil, fmt.Errorf("%s is shared by servers with different https", address)
+	}
+	if _, exists := p.members[name]; exists {
+		return nil, fmt.Errorf("%s has been joined by %s", address, name)
+	}
+	for _, host := range hosts {
+		if m := p.hosts[normalizeHost(host)]; m != nil {
+			return nil, fmt.Errorf("host %s on %s is served by %s", host, address, m.name)
+		}
+	}
+
+	m := &sharedPortMember{
+		port:  p,
+		name:  name,
+		hosts: hosts,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	p.members[name] = m
+	for _, host := range hosts {
+		p.hosts[normalizeHost(host)] = m
+	}
+
+	return m, nil
+}
+
+// leave removes the member from the shared port, the listener is closed
+// once the last member leaves.
+func (r *sharedPortRegistry) leave(m *sharedPortMember) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p := m.port
+	if p.members[m.name] != m {
+		return
+	}
+	delete(p.members, m.name)
+	for _, host := range m.hosts {
+		delete(p.hosts, normalizeHost(host))
+	}
+
+	if len(p.members) == 0 {
+		if r.ports[p.address] == p {
+			delete(r.ports, p.address)
+		}
+		p.listener.Close()
+	}
+}
+
+// fail closes the shared port and all its members after the listener
+// failed, so the servers restart and join a new one.
+func (r *sharedPortRegistry) fail(p *sharedPort, err error) {
+	r.mutex.Lock()
+	if r.ports[p.address] == p {
+		delete(r.ports, p.address)
+	}
+	members := make([]*sharedPortMember, 0, len(p.members))
+	for _, m := range p.members {
+		members = append(members, m)
+	}
+	r.mutex.Unlock()
+
+	p.listener.Close()
+	for _, m := range members {
+		m.close(err)
+	}
+}
+
+func (p *sharedPort) run() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			p.registry.fail(p, err)
+			return
+		}
+		go p.dispatch(conn)
+	}
+}
+
+// match returns the member serving the host, the exact host takes
+// precedence over the wildcard one, which takes precedence over "*".
+func (p *sharedPort) match(host string) *sharedPortMember {
+	p.registry.mutex.Lock()
+	defer p.registry.mutex.Unlock()
+
+	host = normalizeHost(host)
+	if m := p.hosts[host]; m != nil {
+		return m
+	}
+	if i := strings.IndexByte(host, '.'); i >= 0 {
+		if m := p.hosts["*"+host[i:]]; m != nil {
+			return m
+		}
+	}
+	return p.hosts[sharedPortAnyHost]
+}
+
+func (p *sharedPort) dispatch(conn net.Conn) {
+	var (
+		host string
+		head []byte
+		err  error
+	)
+
+	conn.SetReadDeadline(time.Now().Add(sharedPortReadTimeout))
+	if p.https {
+		host, head, err = readServerName(conn)
+	} else {
+		host, head, err = readRequestHost(conn)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	m := p.match(host)
+	if m == nil {
+		logger.Debugf("%s: no server serves host %s", p.address, host)
+		if !p.https {
+			conn.Write(misdirectedResponse)
+		}
+		conn.Close()
+		return
+	}
+
+	m.deliver(&sniffedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(head), conn)})
+}
+
+// readServerName reads the ClientHello from the connection and returns
+// the server name in it, along with all bytes read from the connection.
+func readServerName(conn net.Conn) (string, []byte, error) {
+	buff := &bytes.Buffer{}
+	var serverName string
+	var helloRead bool
+
+	err := tls.Server(readOnlyConn{Conn: conn, reader: io.TeeReader(conn, buff)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName, helloRead = info.ServerName, true
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	if !helloRead {
+		return "", buff.Bytes(), err
+	}
+	return serverName, buff.Bytes(), nil
+}
+
+// readRequestHost reads the head of the first request from the
+// connection and returns its host, along with all bytes read from the
+// connection.
+func readRequestHost(conn net.Conn) (string, []byte, error) {
+	buff := &bytes.Buffer{}
+	reader := io.LimitReader(conn, http.DefaultMaxHeaderBytes)
+	req, err := http.ReadRequest(bufio.NewReader(io.TeeReader(reader, buff)))
+	if err != nil {
+		return "", buff.Bytes(), err
+	}
+	return req.Host, buff.Bytes(), nil
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)  { return c.reader.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error) { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                { return nil }
+
+// deliver delivers the connection to the server accepting from the
+// member, it's closed if the member has left.
+func (m *sharedPortMember) deliver(conn net.Conn) {
+	select {
+	case m.conns <- conn:
+	case <-m.done:
+		conn.Close()
+	}
+}
+
+func (m *sharedPortMember) close(err error) {
+	m.closeOnce.Do(func() {
+		m.err = err
+		close(m.done)
+	})
+}
+
+func (m *sharedPortMember) Accept() (net.Conn, error) {
+	select {
+	case conn := <-m.conns:
+		return conn, nil
+	case <-m.done:
+		return nil, m.err
+	}
+}
+
+func (m *sharedPortMember) Close() error {
+	m.close(net.ErrClosed)
+	m.port.registry.leave(m)
+	return nil
+}
+
+func (m *sharedPortMember) Addr() net.Addr {
+	return m.port.listener.Addr()
+}
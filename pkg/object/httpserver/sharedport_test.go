@@ -0,0 +1,199 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidateSharedHosts(t *testing.T) {
+	valid := [][]string{
+		{"a.megaease.com", "B.megaease.com."},
+		{"*.megaease.com", "*"},
+	}
+	for _, hosts := range valid {
+		if err := validateSharedHosts(hosts); err != nil {
+			t.Errorf("%v should be valid: %v", hosts, err)
+		}
+	}
+
+	invalid := [][]string{
+		{""},
+		{"a.megaease.com:8080"},
+		{"a.*.megaease.com"},
+		{"*megaease.com"},
+		{"a.megaease.com", "A.megaease.com"},
+	}
+	for _, hosts := range invalid {
+		if err := validateSharedHosts(hosts); err == nil {
+			t.Errorf("%v should be invalid", hosts)
+		}
+	}
+}
+
+func TestSharedPortMatch(t *testing.T) {
+	registry := &sharedPortRegistry{ports: map[string]*sharedPort{}}
+	p := &sharedPort{
+		registry: registry,
+		members:  map[string]*sharedPortMember{},
+		hosts:    map[string]*sharedPortMember{},
+	}
+	exact := &sharedPortMember{name: "exact"}
+	wildcard := &sharedPortMember{name: "wildcard"}
+	p.hosts["a.megaease.com"] = exact
+	p.hosts["*.megaease.com"] = wildcard
+
+	cases := map[string]*sharedPortMember{
+		"a.megaease.com":      exact,
+		"A.MegaEase.com:8080": exact,
+		"b.megaease.com":      wildcard,
+		"a.b.megaease.com":    nil,
+		"megaease.com":        nil,
+		"":                    nil,
+	}
+	for host, want := range cases {
+		if got := p.match(host); got != want {
+			t.Errorf("%q: member should be %v, but is %v", host, want, got)
+		}
+	}
+
+	anyHost := &sharedPortMember{name: "any"}
+	p.hosts[sharedPortAnyHost] = anyHost
+	if got := p.match("megaease.com"); got != anyHost {
+		t.Errorf("unmatched host should be served by any")
+	}
+}
+
+func TestSharedPort(t *testing.T) {
+	registry := &sharedPortRegistry{ports: map[string]*sharedPort{}}
+	address := "127.0.0.1:0"
+
+	a, err := registry.join(address, "a", false, []string{"a.megaease.com"})
+	if err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	b, err := registry.join(address, "b", false, []string{"*.megaease.com"})
+	if err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	if len(registry.ports) != 1 || a.Addr().String() != b.Addr().String() {
+		t.Fatalf("servers should share the port")
+	}
+
+	if _, err := registry.join(address, "c", false, []string{"A.megaease.com"}); err == nil {
+		t.Errorf("join with conflicted hosts should fail")
+	}
+	if _, err := registry.join(address, "a", false, []string{"c.megaease.com"}); err == nil {
+		t.Errorf("join with the same name should fail")
+	}
+	if _, err := registry.join(address, "c", true, []string{"c.megaease.com"}); err == nil {
+		t.Errorf("join with different https should fail")
+	}
+
+	request := func(host string) net.Conn {
+		conn, err := net.Dial("tcp", a.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"))
+		return conn
+	}
+
+	for host, m := range map[string]*sharedPortMember{
+		"a.megaease.com": a,
+		"b.megaease.com": b,
+	} {
+		client := request(host)
+		conn, err := m.Accept()
+		if err != nil {
+			t.Fatalf("accept failed: %v", err)
+		}
+		want := "GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"
+		buf := make([]byte, len(want))
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != want {
+			t.Errorf("request should be replayed, but got %q: %v", buf, err)
+		}
+		conn.Close()
+		client.Close()
+	}
+
+	client := request("megaease.cn")
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	resp, _ := io.ReadAll(client)
+	if string(resp) != string(misdirectedResponse) {
+		t.Errorf("unmatched host should get 421, but got %q", resp)
+	}
+	client.Close()
+
+	a.Close()
+	if _, err := a.Accept(); err == nil {
+		t.Errorf("accept should fail after leaving")
+	}
+	if len(registry.ports) != 1 {
+		t.Errorf("port should be kept until the last server leaves")
+	}
+	b.Close()
+	if len(registry.ports) != 0 {
+		t.Errorf("port should be closed after the last server leaves")
+	}
+}
+
+func TestSharedPortTLS(t *testing.T) {
+	registry := &sharedPortRegistry{ports: map[string]*sharedPort{}}
+	m, err := registry.join("127.0.0.1:0", "a", true, []string{"a.megaease.com"})
+	if err != nil {
+		t.Fatalf("join failed: %v", err)
+	}
+	defer m.Close()
+
+	go func() {
+		conn, err := tls.Dial("tcp", m.Addr().String(), &tls.Config{ServerName: "a.megaease.com"})
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := m.Accept()
+	if err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The ClientHello is replayed to the server.
+	serverName := ""
+	certPem, keyPem := newCertKeyPem(t, "a.megaease.com")
+	cert, err := tls.X509KeyPair([]byte(certPem), []byte(keyPem))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tls.Server(conn, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = info.ServerName
+			return nil, nil
+		},
+	}).Handshake()
+	if serverName != "a.megaease.com" {
+		t.Errorf("server name should be a.megaease.com, but is %q", serverName)
+	}
+}
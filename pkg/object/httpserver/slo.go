@@ -0,0 +1,353 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+)
+
+const (
+	// sloBuckets is the number of one-minute buckets, which covers the
+	// longest window of burn rates.
+	sloBuckets = 60
+
+	defaultSLOLatencyTarget = 99
+	defaultSLOAlertBurnRate = 14.4
+
+	sloObjectiveAvailability = "availability"
+	sloObjectiveLatency      = "latency"
+
+	sloAlertFiring   = "firing"
+	sloAlertResolved = "resolved"
+)
+
+type (
+	// SLOSpec describes the service level objectives of a route.
+	SLOSpec struct {
+		// Availability is the target percentage of requests not
+		// responded with 5xx, e.g. 99.9.
+		Availability float64 `yaml:"availability" jsonschema:"omitempty,minimum=0,maximum=100"`
+		// Latency is the latency threshold, LatencyTarget is the target
+		// percentage of requests faster than it.
+		Latency       string  `yaml:"latency" jsonschema:"omitempty,format=duration"`
+		LatencyTarget float64 `yaml:"latencyTarget" jsonschema:"omitempty,minimum=0,maximum=100"`
+		// AlertBurnRate is the burn rate of both the 5m and 1h windows to
+		// fire an alert, AlertWebhook receives the alerts if it's not empty.
+		AlertBurnRate float64 `yaml:"alertBurnRate" jsonschema:"omitempty,minimum=1"`
+		AlertWebhook  string  `yaml:"alertWebhook" jsonschema:"omitempty,format=url"`
+	}
+
+	// SLOStatus is the status of the SLO of a route.
+	SLOStatus struct {
+		Route      string                `yaml:"route"`
+		Objectives []*SLOObjectiveStatus `yaml:"objectives"`
+	}
+
+	// SLOObjectiveStatus is the status of an objective.
+	SLOObjectiveStatus struct {
+		Objective  string  `yaml:"objective"`
+		Target     float64 `yaml:"target"`
+		Current5m  float64 `yaml:"current5m"`
+		Current1h  float64 `yaml:"current1h"`
+		BurnRate5m float64 `yaml:"burnRate5m"`
+		BurnRate1h float64 `yaml:"burnRate1h"`
+		Alerting   bool    `yaml:"alerting"`
+	}
+
+	// SLOAlert is the alert sent to the webhook.
+	SLOAlert struct {
+		Server     string    `json:"server"`
+		Route      string    `json:"route"`
+		Objective  string    `json:"objective"`
+		Status     string    `json:"status"`
+		Target     float64   `json:"target"`
+		BurnRate5m float64   `json:"burnRate5m"`
+		BurnRate1h float64   `json:"burnRate1h"`
+		Time       time.Time `json:"time"`
+	}
+
+	sloBucket struct {
+		minute int64
+		total  uint64
+		errors uint64
+		slow   uint64
+	}
+
+	// sloTracker tracks the SLO of a route.
+	sloTracker struct {
+		server  string
+		route   string
+		spec    *SLOSpec
+		latency time.Duration
+
+		mutex    sync.Mutex
+		buckets  [sloBuckets]sloBucket
+		alerting map[string]bool
+	}
+
+	// sloStat is the SLO trackers of routes, it is kept across reloading
+	// of rules, so trackers of unchanged SLOs are not reset.
+	sloStat struct {
+		m sync.Map // route -> *sloTracker
+	}
+)
+
+// Validate validates SLOSpec.
+func (spec *SLOSpec) Validate() error {
+	if spec.Availability == 0 && spec.Latency == "" {
+		return fmt.Errorf("availability or latency is required")
+	}
+	if spec.Availability == 100 || spec.LatencyTarget == 100 {
+		return fmt.Errorf("the target of 100%% leaves no error budget")
+	}
+	if spec.Latency != "" {
+		if _, err := time.ParseDuration(spec.Latency); err != nil {
+			return fmt.Errorf("invalid latency: %v", err)
+		}
+	}
+	return nil
+}
+
+func (spec *SLOSpec) latencyTarget() float64 {
+	if spec.LatencyTarget == 0 {
+		return defaultSLOLatencyTarget
+	}
+	return spec.LatencyTarget
+}
+
+func (spec *SLOSpec) alertBurnRate() float64 {
+	if spec.AlertBurnRate == 0 {
+		return defaultSLOAlertBurnRate
+	}
+	return spec.AlertBurnRate
+}
+
+func newSLOTracker(server, route string, spec *SLOSpec) *sloTracker {
+	t := &sloTracker{
+		server:   server,
+		route:    route,
+		spec:     spec,
+		alerting: map[string]bool{},
+	}
+
+	if spec.Latency != "" {
+		t.latency, _ = time.ParseDuration(spec.Latency)
+	}
+
+	return t
+}
+
+// record records a request, the alerts are evaluated once a minute
+// when requests come.
+func (t *sloTracker) record(code int, duration time.Duration, now time.Time) {
+	minute := now.Unix() / 60
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	b := &t.buckets[minute%sloBuckets]
+	if b.minute != minute {
+		*b = sloBucket{minute: minute}
+		t.evaluate(now)
+	}
+
+	b.total++
+	if code >= 500 {
+		b.errors++
+	}
+	if t.latency > 0 && duration > t.latency {
+		b.slow++
+	}
+}
+
+// sum sums the buckets in the last minutes, the caller must hold the lock.
+func (t *sloTracker) sum(now time.Time, minutes int64) (total, errors, slow uint64) {
+	current := now.Unix() / 60
+	for i := range t.buckets {
+		b := &t.buckets[i]
+		if b.minute > current-minutes && b.minute <= current {
+			total += b.total
+			errors += b.errors
+			slow += b.slow
+		}
+	}
+	return
+}
+
+// objectives returns the status of objectives, the caller must hold the lock.
+func (t *sloTracker) objectives(now time.Time) []*SLOObjectiveStatus {
+	total5m, errors5m, slow5m := t.sum(now, 5)
+	total1h, errors1h, slow1h := t.sum(now, 60)
+
+	var objectives []*SLOObjectiveStatus
+	if t.spec.Availability > 0 {
+		objectives = append(objectives, newSLOObjectiveStatus(sloObjectiveAvailability,
+			t.spec.Availability, total5m, errors5m, total1h, errors1h))
+	}
+	if t.latency > 0 {
+		objectives = append(objectives, newSLOObjectiveStatus(sloObjectiveLatency,
+			t.spec.latencyTarget(), total5m, slow5m, total1h, slow1h))
+	}
+
+	for _, o := range objectives {
+		o.Alerting = t.alerting[o.Objective]
+	}
+	return objectives
+}
+
+func newSLOObjectiveStatus(objective string, target float64,
+	total5m, bad5m, total1h, bad1h uint64) *SLOObjectiveStatus {
+
+	budget := 1 - target/100
+	current := func(total, bad uint64) float64 {
+		if total == 0 {
+			return 100
+		}
+		return 100 * float64(total-bad) / float64(total)
+	}
+	burnRate := func(total, bad uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return float64(bad) / float64(total) / budget
+	}
+
+	return &SLOObjectiveStatus{
+		Objective:  objective,
+		Target:     target,
+		Current5m:  current(total5m, bad5m),
+		Current1h:  current(total1h, bad1h),
+		BurnRate5m: burnRate(total5m, bad5m),
+		BurnRate1h: burnRate(total1h, bad1h),
+	}
+}
+
+// evaluate evaluates the alerts, the caller must hold the lock.
+func (t *sloTracker) evaluate(now time.Time) {
+	threshold := t.spec.alertBurnRate()
+	for _, o := range t.objectives(now) {
+		firing := o.BurnRate5m >= threshold && o.BurnRate1h >= threshold
+		if firing == t.alerting[o.Objective] {
+			continue
+		}
+		t.alerting[o.Objective] = firing
+
+		alert := &SLOAlert{
+			Server:     t.server,
+			Route:      t.route,
+			Objective:  o.Objective,
+			Status:     sloAlertResolved,
+			Target:     o.Target,
+			BurnRate5m: o.BurnRate5m,
+			BurnRate1h: o.BurnRate1h,
+			Time:       now,
+		}
+		if firing {
+			alert.Status = sloAlertFiring
+		}
+
+		logger.Warnf("%s: slo alert of %s %s %s: burn rate 5m %.2f, 1h %.2f",
+			t.server, t.route, o.Objective, alert.Status, o.BurnRate5m, o.BurnRate1h)
+		if t.spec.AlertWebhook != "" {
+			go sendSLOAlert(t.spec.AlertWebhook, alert)
+		}
+	}
+}
+
+func sendSLOAlert(webhook string, alert *SLOAlert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		logger.Errorf("BUG: marshal %#v to json failed: %v", alert, err)
+		return
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second, Transport: egressproxy.DefaultTransport}
+	resp, err := client.Post(webhook, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Errorf("send slo alert to %s failed: %v", webhook, err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logger.Errorf("send slo alert to %s failed: status code %d", webhook, resp.StatusCode)
+	}
+}
+
+func (t *sloTracker) status(now time.Time) *SLOStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return &SLOStatus{
+		Route:      t.route,
+		Objectives: t.objectives(now),
+	}
+}
+
+func newSLOStat() *sloStat {
+	return &sloStat{}
+}
+
+// get returns the tracker of the route, a new tracker is created if the
+// spec has changed.
+func (ss *sloStat) get(server, route string, spec *SLOSpec) *sloTracker {
+	if v, loaded := ss.m.Load(route); loaded {
+		t := v.(*sloTracker)
+		if reflect.DeepEqual(t.spec, spec) {
+			return t
+		}
+	}
+
+	t := newSLOTracker(server, route, spec)
+	ss.m.Store(route, t)
+	return t
+}
+
+// retain removes the trackers of routes not in routes.
+func (ss *sloStat) retain(routes map[string]struct{}) {
+	ss.m.Range(func(key, value interface{}) bool {
+		if _, exists := routes[key.(string)]; !exists {
+			ss.m.Delete(key)
+		}
+		return true
+	})
+}
+
+// Status returns the status of all SLOs.
+func (ss *sloStat) Status() []*SLOStatus {
+	now := time.Now()
+	status := []*SLOStatus{}
+	ss.m.Range(func(key, value interface{}) bool {
+		status = append(status, value.(*sloTracker).status(now))
+		return true
+	})
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Route < status[j].Route
+	})
+	return status
+}
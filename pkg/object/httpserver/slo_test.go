@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestSLOSpecValidate(t *testing.T) {
+	if (&SLOSpec{}).Validate() == nil {
+		t.Errorf("expect error for empty objectives")
+	}
+	if (&SLOSpec{Availability: 100}).Validate() == nil {
+		t.Errorf("expect error for 100%% target")
+	}
+	if (&SLOSpec{Latency: "1x"}).Validate() == nil {
+		t.Errorf("expect error for invalid latency")
+	}
+	if err := (&SLOSpec{Availability: 99.9, Latency: "300ms"}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSLOTracker(t *testing.T) {
+	logger.InitNop()
+
+	spec := &SLOSpec{Availability: 99, Latency: "100ms", LatencyTarget: 90, AlertBurnRate: 2}
+	tracker := newSLOTracker("server", "* /api -> pipeline", spec)
+
+	now := time.Unix(1600000000, 0)
+	for i := 0; i < 100; i++ {
+		code, duration := 200, 10*time.Millisecond
+		if i < 5 {
+			code = 503
+		}
+		if i < 20 {
+			duration = time.Second
+		}
+		tracker.record(code, duration, now)
+	}
+
+	status := tracker.status(now)
+	if len(status.Objectives) != 2 {
+		t.Fatalf("expect 2 objectives, got %d", len(status.Objectives))
+	}
+
+	availability, latency := status.Objectives[0], status.Objectives[1]
+	if availability.Current5m != 95 || availability.BurnRate5m < 4.99 || availability.BurnRate5m > 5.01 {
+		t.Errorf("unexpected availability status: %+v", availability)
+	}
+	if latency.Current1h != 80 || latency.BurnRate1h < 1.99 || latency.BurnRate1h > 2.01 {
+		t.Errorf("unexpected latency status: %+v", latency)
+	}
+	if availability.Alerting || latency.Alerting {
+		t.Errorf("alerts should be evaluated in the next minute")
+	}
+
+	// The alerts are evaluated when the first request of next minute comes.
+	now = now.Add(time.Minute)
+	tracker.record(200, 0, now)
+	status = tracker.status(now)
+	if !status.Objectives[0].Alerting || !status.Objectives[1].Alerting {
+		t.Errorf("expect alerts firing, got %+v, %+v", status.Objectives[0], status.Objectives[1])
+	}
+
+	// The requests are out of the 5m window.
+	now = now.Add(10 * time.Minute)
+	tracker.record(200, 0, now)
+	status = tracker.status(now)
+	if status.Objectives[0].Alerting || status.Objectives[0].BurnRate5m != 0 {
+		t.Errorf("expect alert resolved, got %+v", status.Objectives[0])
+	}
+}
+
+func TestSLOStat(t *testing.T) {
+	ss := newSLOStat()
+	spec := &SLOSpec{Availability: 99.9}
+
+	t1 := ss.get("server", "r1", spec)
+	if ss.get("server", "r1", &SLOSpec{Availability: 99.9}) != t1 {
+		t.Errorf("expect the same tracker for the same spec")
+	}
+	if ss.get("server", "r1", &SLOSpec{Availability: 99}) == t1 {
+		t.Errorf("expect a new tracker for a changed spec")
+	}
+
+	ss.retain(map[string]struct{}{})
+	if len(ss.Status()) != 0 {
+		t.Errorf("expect no trackers")
+	}
+}
@@ -0,0 +1,410 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultSniffTimeout = 3 * time.Second
+	sniffDialTimeout    = 10 * time.Second
+
+	sniffedHTTP = "http"
+	sniffedTLS  = "tls"
+	sniffedTCP  = "tcp"
+
+	// tlsRecordHandshake is the first byte of TLS ClientHello records.
+	tlsRecordHandshake = 0x16
+)
+
+// httpPrefixes are the prefixes of HTTP/1.x requests and the HTTP/2
+// connection preface of cleartext HTTP/2 clients, e.g. gRPC without TLS.
+var httpPrefixes = [][]byte{
+	[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "),
+	[]byte("DELETE "), []byte("CONNECT "), []byte("OPTIONS "),
+	[]byte("TRACE "), []byte("PATCH "), []byte("PRI * HTTP/2.0"),
+}
+
+// maxSniffLen is the max number of bytes to sniff the protocol, every
+// protocol is decided once all the prefixes are comparable.
+var maxSniffLen = func() int {
+	n := 0
+	for _, p := range httpPrefixes {
+		if len(p) > n {
+			n = len(p)
+		}
+	}
+	return n
+}()
+
+type (
+	// SniffingSpec describes the protocol sniffing of the port, which
+	// inspects the first bytes of connections to serve HTTP, HTTPS and
+	// other TCP protocols on one port.
+	SniffingSpec struct {
+		// TCPBackend is the address which the connections of the other
+		// protocols are forwarded to, they're closed if it's empty. The
+		// TLS connections are forwarded to it too if https is disabled.
+		TCPBackend string `yaml:"tcpBackend" jsonschema:"omitempty"`
+		// Timeout is the max time to wait for the first bytes, the
+		// connections sending nothing within it are treated as the
+		// ones of the other protocols, e.g. the server-first protocols
+		// like SMTP, the default is 3s.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// SniffingStatus contains the number of sniffed connections of
+	// every protocol.
+	SniffingStatus struct {
+		HTTP uint64 `yaml:"http"`
+		TLS  uint64 `yaml:"tls"`
+		TCP  uint64 `yaml:"tcp"`
+		// Failed is the number of connections closed without being
+		// served, for errors or the absent tcpBackend.
+		Failed uint64 `yaml:"failed"`
+	}
+
+	sniffStat struct {
+		http, tls, tcp, failed uint64
+	}
+
+	// sniffListener accepts connections from the underlying listener,
+	// sniffs their protocols and dispatches them to the sub listeners
+	// of HTTP and TLS, or forwards them to the TCP backend.
+	sniffListener struct {
+		net.Listener
+		spec    *SniffingSpec
+		https   bool
+		timeout time.Duration
+		stat    *sniffStat
+
+		plain *subListener
+		tls   *subListener
+
+		closeOnce sync.Once
+		done      chan struct{}
+		err       atomic.Value // error
+
+		mutex   sync.Mutex
+		tunnels map[net.Conn]struct{}
+	}
+
+	// subListener is the listener of the connections of a protocol,
+	// closing it closes the whole sniffListener.
+	subListener struct {
+		parent *sniffListener
+		conns  chan net.Conn
+	}
+
+	// sniffedConn replays the sniffed bytes before reading from the
+	// connection.
+	sniffedConn struct {
+		net.Conn
+		r io.Reader
+	}
+)
+
+// Validate validates SniffingSpec.
+func (spec *SniffingSpec) Validate() error {
+	if spec.TCPBackend != "" {
+		if _, _, err := net.SplitHostPort(spec.TCPBackend); err != nil {
+			return fmt.Errorf("invalid tcpBackend %s: %v", spec.TCPBackend, err)
+		}
+	}
+	if spec.Timeout != "" {
+		d, err := time.ParseDuration(spec.Timeout)
+		if err != nil {
+			return fmt.Errorf("invalid timeout %s: %v", spec.Timeout, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("timeout must be positive")
+		}
+	}
+	return nil
+}
+
+func (spec *SniffingSpec) timeout() time.Duration {
+	d, err := time.ParseDuration(spec.Timeout)
+	if err != nil || d <= 0 {
+		return defaultSniffTimeout
+	}
+	return d
+}
+
+func (s *sniffStat) status() *SniffingStatus {
+	return &SniffingStatus{
+		HTTP:   atomic.LoadUint64(&s.http),
+		TLS:    atomic.LoadUint64(&s.tls),
+		TCP:    atomic.LoadUint64(&s.tcp),
+		Failed: atomic.LoadUint64(&s.failed),
+	}
+}
+
+// sniffProtocol returns the protocol of the head of a connection, it
+// returns an empty string if more bytes are required.
+func sniffProtocol(head []byte) string {
+	if len(head) == 0 {
+		return ""
+	}
+	if head[0] == tlsRecordHandshake {
+		return sniffedTLS
+	}
+
+	undecided := false
+	for _, p := range httpPrefixes {
+		if bytes.HasPrefix(head, p) {
+			return sniffedHTTP
+		}
+		if bytes.HasPrefix(p, head) {
+			undecided = true
+		}
+	}
+	if undecided {
+		return ""
+	}
+	return sniffedTCP
+}
+
+// sniff reads the head of the connection until its protocol is decided.
+// The connections sending nothing before the deadline are treated as
+// the ones of the other TCP protocols.
+func sniff(r io.Reader) (string, []byte, error) {
+	head := make([]byte, 0, maxSniffLen)
+	for {
+		n, err := r.Read(head[len(head):cap(head)])
+		head = head[:len(head)+n]
+		if proto := sniffProtocol(head); proto != "" {
+			return proto, head, nil
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return sniffedTCP, head, nil
+		}
+		if err != nil {
+			return "", head, err
+		}
+	}
+}
+
+func newSniffListener(l net.Listener, spec *SniffingSpec, https bool, stat *sniffStat) *sniffListener {
+	sl := &sniffListener{
+		Listener: l,
+		spec:     spec,
+		https:    https,
+		timeout:  spec.timeout(),
+		stat:     stat,
+		done:     make(chan struct{}),
+		tunnels:  map[net.Conn]struct{}{},
+	}
+	sl.plain = &subListener{parent: sl, conns: make(chan net.Conn)}
+	sl.tls = &subListener{parent: sl, conns: make(chan net.Conn)}
+	return sl
+}
+
+func (l *sniffListener) run() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			l.err.Store(err)
+			l.Close()
+			return
+		}
+		go l.dispatch(conn)
+	}
+}
+
+func (l *sniffListener) dispatch(conn net.Conn) {
+	conn.SetReadDeadline(time.Now().Add(l.timeout))
+	proto, head, err := sniff(conn)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		atomic.AddUint64(&l.stat.failed, 1)
+		conn.Close()
+		return
+	}
+
+	conn = &sniffedConn{Conn: conn, r: io.MultiReader(bytes.NewReader(head), conn)}
+	switch {
+	case proto == sniffedHTTP:
+		atomic.AddUint64(&l.stat.http, 1)
+		l.plain.deliver(conn)
+	case proto == sniffedTLS && l.https:
+		atomic.AddUint64(&l.stat.tls, 1)
+		l.tls.deliver(conn)
+	default:
+		if proto == sniffedTLS {
+			atomic.AddUint64(&l.stat.tls, 1)
+		} else {
+			atomic.AddUint64(&l.stat.tcp, 1)
+		}
+		l.forward(conn)
+	}
+}
+
+// forward forwards the connection to the TCP backend.
+func (l *sniffListener) forward(conn net.Conn) {
+	if l.spec.TCPBackend == "" {
+		atomic.AddUint64(&l.stat.failed, 1)
+		conn.Close()
+		return
+	}
+
+	backend, err := net.DialTimeout("tcp", l.spec.TCPBackend, sniffDialTimeout)
+	if err != nil {
+		logger.Warnf("dial tcp backend %s failed: %v", l.spec.TCPBackend, err)
+		atomic.AddUint64(&l.stat.failed, 1)
+		conn.Close()
+		return
+	}
+
+	if !l.addTunnel(conn, backend) {
+		conn.Close()
+		backend.Close()
+		return
+	}
+	defer l.removeTunnel(conn, backend)
+
+	pipeTCP(conn, backend)
+}
+
+// addTunnel tracks the connections of a tunnel, so they're closed with
+// the listener, it returns false if the listener has been closed.
+func (l *sniffListener) addTunnel(conns ...net.Conn) bool {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	select {
+	case <-l.done:
+		return false
+	default:
+	}
+	for _, c := range conns {
+		l.tunnels[c] = struct{}{}
+	}
+	return true
+}
+
+func (l *sniffListener) removeTunnel(conns ...net.Conn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, c := range conns {
+		delete(l.tunnels, c)
+	}
+}
+
+// Close closes the underlying listener and the tunnels to the TCP
+// backend, the connections of HTTP and TLS are closed by the server.
+func (l *sniffListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		l.mutex.Lock()
+		close(l.done)
+		for c := range l.tunnels {
+			c.Close()
+		}
+		l.mutex.Unlock()
+
+		err = l.Listener.Close()
+	})
+	return err
+}
+
+func (l *sniffListener) acceptErr() error {
+	if err, ok := l.err.Load().(error); ok {
+		return err
+	}
+	return net.ErrClosed
+}
+
+// deliver delivers the connection to the server accepting from the
+// sub listener, it's closed if the listener has been closed.
+func (sl *subListener) deliver(conn net.Conn) {
+	select {
+	case sl.conns <- conn:
+	case <-sl.parent.done:
+		conn.Close()
+	}
+}
+
+func (sl *subListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-sl.conns:
+		return conn, nil
+	case <-sl.parent.done:
+		return nil, sl.parent.acceptErr()
+	}
+}
+
+func (sl *subListener) Close() error {
+	return sl.parent.Close()
+}
+
+func (sl *subListener) Addr() net.Addr {
+	return sl.parent.Addr()
+}
+
+func (c *sniffedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// CloseWrite shuts down the writing side of the underlying connection,
+// which is required by half-closing tunnels.
+func (c *sniffedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("%T doesn't support CloseWrite", c.Conn)
+}
+
+// pipeTCP copies data between the two connections until both directions
+// are finished, the write side is closed as soon as its source reaches EOF.
+func pipeTCP(client, backend net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	copyConn := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); !ok || cw.CloseWrite() != nil {
+			// NOTE: Without half close, the other direction can't be
+			// notified, so close both connections.
+			dst.Close()
+			src.Close()
+		}
+	}
+
+	go copyConn(backend, client)
+	go copyConn(client, backend)
+	wg.Wait()
+
+	client.Close()
+	backend.Close()
+}
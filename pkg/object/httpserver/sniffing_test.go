@@ -0,0 +1,151 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSniffProtocol(t *testing.T) {
+	cases := []struct {
+		head string
+		want string
+	}{
+		{"", ""},
+		{"\x16\x03\x01", sniffedTLS},
+		{"G", ""},
+		{"GET", ""},
+		{"GET /", sniffedHTTP},
+		{"GETX", sniffedTCP},
+		{"OPTIONS * HTTP/1.1", sniffedHTTP},
+		{"PRI * HTTP/2.0\r\n", sniffedHTTP},
+		{"PRI * HT", ""},
+		{"SSH-2.0-OpenSSH", sniffedTCP},
+		{"\x00\x00\x00\x08", sniffedTCP},
+	}
+	for _, c := range cases {
+		if got := sniffProtocol([]byte(c.head)); got != c.want {
+			t.Errorf("%q: protocol should be %q, but is %q", c.head, c.want, got)
+		}
+	}
+}
+
+func TestSniffListener(t *testing.T) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				// A server-first protocol greets the client.
+				conn.Write([]byte("hello "))
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &SniffingSpec{TCPBackend: backend.Addr().String(), Timeout: "100ms"}
+	stat := &sniffStat{}
+	sl := newSniffListener(l, spec, true, stat)
+	defer sl.Close()
+	go sl.run()
+
+	dial := func(data string) net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data != "" {
+			conn.Write([]byte(data))
+		}
+		return conn
+	}
+	readAll := func(conn net.Conn, n int) string {
+		buf := make([]byte, n)
+		conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			t.Fatalf("read failed: %v", err)
+		}
+		return string(buf)
+	}
+
+	// HTTP and TLS connections are accepted by the sub listeners with
+	// the sniffed bytes replayed.
+	for _, c := range []struct {
+		data string
+		sl   *subListener
+	}{
+		{"GET / HTTP/1.1\r\n", sl.plain},
+		{"PRI * HTTP/2.0\r\n", sl.plain},
+		{"\x16\x03\x01\x02\x00", sl.tls},
+	} {
+		client := dial(c.data)
+		conn, err := c.sl.Accept()
+		if err != nil {
+			t.Fatalf("accept failed: %v", err)
+		}
+		if got := readAll(conn, len(c.data)); got != c.data {
+			t.Errorf("data should be %q, but is %q", c.data, got)
+		}
+		conn.Close()
+		client.Close()
+	}
+
+	// Other protocols are forwarded to the backend.
+	client := dial("SSH-2.0\r\n")
+	if got := readAll(client, 15); got != "hello SSH-2.0\r\n" {
+		t.Errorf("data should be forwarded, but is %q", got)
+	}
+	client.Close()
+
+	// Silent clients are forwarded after the timeout.
+	client = dial("")
+	if got := readAll(client, 6); got != "hello " {
+		t.Errorf("silent client should be forwarded, but got %q", got)
+	}
+
+	status := stat.status()
+	if status.HTTP != 2 || status.TLS != 1 || status.TCP != 2 {
+		t.Errorf("unexpected status %+v", status)
+	}
+
+	// Closing the listener closes the tunnels and the sub listeners.
+	sl.plain.Close()
+	client.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if n, _ := client.Read(make([]byte, 1)); n != 0 {
+		t.Errorf("tunnel should be closed")
+	}
+	client.Close()
+	if _, err := sl.tls.Accept(); err == nil {
+		t.Errorf("accept should fail after closing")
+	}
+}
@@ -19,21 +19,30 @@ package httpserver
 
 import (
 	"crypto/tls"
-	"crypto/x509"
 	"encoding/base64"
 	"fmt"
+	"net"
 	"regexp"
+	"strconv"
+	"time"
 
+	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/object/autocertmanager"
 	"github.com/megaease/easegress/pkg/tracing"
+	"github.com/megaease/easegress/pkg/util/clientip"
+	"github.com/megaease/easegress/pkg/util/fips"
 	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+	"github.com/megaease/easegress/pkg/util/tcpoption"
 )
 
 type (
 	// Spec describes the HTTPServer.
 	Spec struct {
 		HTTP3            bool          `yaml:"http3" jsonschema:"omitempty"`
-		Port             uint16        `yaml:"port" jsonschema:"required,minimum=1"`
+		Port             uint16        `yaml:"port" jsonschema:"omitempty"`
+		Address          string        `yaml:"address" jsonschema:"omitempty"`
+		Addresses        []string      `yaml:"addresses" jsonschema:"omitempty,uniqueItems=true"`
 		KeepAlive        bool          `yaml:"keepAlive" jsonschema:"required"`
 		KeepAliveTimeout string        `yaml:"keepAliveTimeout" jsonschema:"omitempty,format=duration"`
 		MaxConnections   uint32        `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
@@ -43,6 +52,72 @@ type (
 		XForwardedFor    bool          `yaml:"xForwardedFor" jsonschema:"omitempty"`
 		Tracing          *tracing.Spec `yaml:"tracing" jsonschema:"omitempty"`
 		CaCertBase64     string        `yaml:"caCertBase64" jsonschema:"omitempty,format=base64"`
+		// ReadTimeout, ReadHeaderTimeout and WriteTimeout bound the time
+		// of reading the whole request, reading the request header and
+		// writing the response, zero means no limit.
+		ReadTimeout       string `yaml:"readTimeout" jsonschema:"omitempty,format=duration"`
+		ReadHeaderTimeout string `yaml:"readHeaderTimeout" jsonschema:"omitempty,format=duration"`
+		WriteTimeout      string `yaml:"writeTimeout" jsonschema:"omitempty,format=duration"`
+		// ShutdownTimeout is the max time to drain in-flight requests when
+		// the server shuts down or restarts, the default is 30s.
+		ShutdownTimeout string `yaml:"shutdownTimeout" jsonschema:"omitempty,format=duration"`
+		// MaxConnectionLifetime is the max lifetime of connections, the
+		// connections beyond it are closed once they become idle.
+		MaxConnectionLifetime string `yaml:"maxConnectionLifetime" jsonschema:"omitempty,format=duration"`
+		// MaxRequestsPerConnection is the max requests served by a
+		// keep-alive connection, the connection is closed after the last
+		// one, so long-lived clients reconnect and get rebalanced across
+		// the instances behind an L4 load balancer, zero means no limit.
+		MaxRequestsPerConnection uint32 `yaml:"maxRequestsPerConnection" jsonschema:"omitempty"`
+		// MaxRequestBodySize is the max size of request bodies in bytes, the
+		// requests beyond it are rejected with 413, zero means no limit.
+		MaxRequestBodySize int64 `yaml:"maxRequestBodySize" jsonschema:"omitempty,minimum=0"`
+		// ProxyProtocol parses the PROXY protocol headers of connections,
+		// for the server behind L4 load balancers, so the addresses of
+		// connections are the ones of clients.
+		ProxyProtocol bool `yaml:"proxyProtocol" jsonschema:"omitempty"`
+		// TLSFingerprint computes JA3/JA4 fingerprints of TLS clients.
+		TLSFingerprint bool `yaml:"tlsFingerprint" jsonschema:"omitempty"`
+		// SessionTicket shares session ticket keys among the cluster members,
+		// so TLS sessions could be resumed on any of them.
+		SessionTicket *SessionTicketSpec `yaml:"sessionTicket,omitempty" jsonschema:"omitempty"`
+		// HTTPRedirect opens a plain HTTP listener besides the HTTPS one,
+		// which redirects all requests to the HTTPS address.
+		HTTPRedirect *HTTPRedirectSpec `yaml:"httpRedirect,omitempty" jsonschema:"omitempty"`
+		// ForwardClientCert forwards the details of verified client
+		// certificates to the backends in a header, requires caCertBase64.
+		ForwardClientCert *ForwardClientCertSpec `yaml:"forwardClientCert,omitempty" jsonschema:"omitempty"`
+		// OCSPStapling fetches the OCSP responses of the certificates and
+		// staples them in TLS handshakes.
+		OCSPStapling *OCSPStaplingSpec `yaml:"ocspStapling,omitempty" jsonschema:"omitempty"`
+		// TLS is the TLS versions, cipher suites and curves.
+		TLS *TLSSpec `yaml:"tls,omitempty" jsonschema:"omitempty"`
+		// PreserveHeaderCase preserves the original case of request header
+		// names when proxying, HTTP10Compatible adds the Host header to the
+		// requests missing it, they're for ancient HTTP/1.x clients.
+		PreserveHeaderCase bool `yaml:"preserveHeaderCase" jsonschema:"omitempty"`
+		HTTP10Compatible   bool `yaml:"http10Compatible" jsonschema:"omitempty"`
+		// TCP is the TCP options of the listener and accepted connections.
+		TCP *tcpoption.Spec `yaml:"tcp,omitempty" jsonschema:"omitempty"`
+		// HTTP2 is the HTTP/2 options, HTTP/2 is enabled for https by default.
+		HTTP2 *HTTP2Spec `yaml:"http2,omitempty" jsonschema:"omitempty"`
+		// H2C serves HTTP/2 over cleartext TCP besides HTTP/1.x for http,
+		// e.g. for gRPC without TLS.
+		H2C bool `yaml:"h2c" jsonschema:"omitempty"`
+		// WebSocket is the options of proxying WebSocket connections, the
+		// upgrade requests are tunneled to the backends switching protocols.
+		WebSocket *WebSocketSpec `yaml:"webSocket,omitempty" jsonschema:"omitempty"`
+		// Hosts shares the port with other HTTPServers declaring hosts,
+		// the server serves the connections of the hosts only, which are
+		// dispatched by SNI for https or by the Host header of the first
+		// request for http.
+		Hosts []string `yaml:"hosts" jsonschema:"omitempty,uniqueItems=true"`
+		// Sniffing inspects the first bytes of connections to serve HTTP,
+		// HTTPS and other TCP protocols on the port.
+		Sniffing *SniffingSpec `yaml:"sniffing,omitempty" jsonschema:"omitempty"`
+		// UnixSocket is the unix domain socket listened besides the port,
+		// the port could be zero to listen on the unix socket only.
+		UnixSocket *UnixSocketSpec `yaml:"unixSocket,omitempty" jsonschema:"omitempty"`
 
 		// Support multiple certs, preserve the certbase64 and keybase64
 		// for backward compatibility
@@ -55,9 +130,52 @@ type (
 		Keys map[string]string `yaml:"keys" jsonschema:"omitempty"`
 
 		IPFilter *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
+		// ClientIP trusts the client address in the header of requests
+		// from the trusted proxies only, the address is used by the IP
+		// filters, the access log and the filters.
+		ClientIP *clientip.Spec `yaml:"clientIP,omitempty" jsonschema:"omitempty"`
 		Rules    []*Rule        `yaml:"rules" jsonschema:"omitempty"`
 
 		GlobalFilter string `yaml:"globalFilter,omitempty" jsonschema:"omitempty"`
+
+		// RequireAuth requires requests of all paths to have an identity
+		// authenticated by filters, paths could opt out by allowAnonymous.
+		RequireAuth bool `yaml:"requireAuth" jsonschema:"omitempty"`
+		// MaxConsumerStats is the max number of authenticated consumers
+		// having their own statistics, zero disables the statistics.
+		MaxConsumerStats uint32 `yaml:"maxConsumerStats" jsonschema:"omitempty"`
+		// ResourceAccounting enables the estimation of the CPU time and
+		// the memory allocations of routes and tenants, by sampled CPU
+		// profiling.
+		ResourceAccounting bool `yaml:"resourceAccounting" jsonschema:"omitempty"`
+
+		// Maintenance is the maintenance mode, in which all requests are
+		// answered with 503.
+		Maintenance *MaintenanceSpec `yaml:"maintenance,omitempty" jsonschema:"omitempty"`
+
+		// WorkerPool bounds the number of requests running the pipelines
+		// concurrently, the requests beyond it wait in a queue.
+		WorkerPool *WorkerPoolSpec `yaml:"workerPool,omitempty" jsonschema:"omitempty"`
+
+		// ResponseHeaders are injected into every response, overwriting
+		// the ones from the backends, empty values delete the headers.
+		ResponseHeaders map[string]string `yaml:"responseHeaders,omitempty" jsonschema:"omitempty"`
+
+		// NoAccessLogHeader is the response header with which backends
+		// skip the access log of requests, e.g. X-Gateway-No-Log, it takes
+		// effect if its value is true, and it's removed from responses.
+		NoAccessLogHeader string `yaml:"noAccessLogHeader,omitempty" jsonschema:"omitempty"`
+
+		// ErrorPages replaces the bare responses of status codes, e.g.
+		// the ones of unmatched requests and failed backends.
+		ErrorPages []*ErrorPage `yaml:"errorPages,omitempty" jsonschema:"omitempty"`
+
+		// Debug enables the debug mode for requests with the token.
+		Debug *DebugSpec `yaml:"debug,omitempty" jsonschema:"omitempty"`
+
+		// WarmUp fires synthetic requests after the server starts or
+		// reloads, the state is warmingUp until they finish.
+		WarmUp *WarmUpSpec `yaml:"warmUp,omitempty" jsonschema:"omitempty"`
 	}
 
 	// Rule is first level entry of router.
@@ -73,6 +191,9 @@ type (
 		Host       string         `yaml:"host" jsonschema:"omitempty"`
 		HostRegexp string         `yaml:"hostRegexp" jsonschema:"omitempty,format=regexp"`
 		Paths      []*Path        `yaml:"paths" jsonschema:"omitempty"`
+		// Timeouts overrides the timeouts of the server for all paths of
+		// the rule.
+		Timeouts *RouteTimeoutsSpec `yaml:"timeouts,omitempty" jsonschema:"omitempty"`
 	}
 
 	// Path is second level entry of router.
@@ -85,6 +206,22 @@ type (
 		Methods       []string       `yaml:"methods,omitempty" jsonschema:"omitempty,uniqueItems=true,format=httpmethod-array"`
 		Backend       string         `yaml:"backend" jsonschema:"required"`
 		Headers       []*Header      `yaml:"headers" jsonschema:"omitempty"`
+		// JA3 and JA4 match the TLS fingerprint of clients, they require
+		// tlsFingerprint of the HTTPServer to be enabled.
+		JA3 []string `yaml:"ja3,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		JA4 []string `yaml:"ja4,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		// SLO is the service level objectives of the path.
+		SLO *SLOSpec `yaml:"slo,omitempty" jsonschema:"omitempty"`
+		// RequireAuth requires requests of the path to have an identity
+		// authenticated by filters, AllowAnonymous exempts the path from
+		// the requireAuth of the HTTPServer.
+		RequireAuth    bool `yaml:"requireAuth" jsonschema:"omitempty"`
+		AllowAnonymous bool `yaml:"allowAnonymous" jsonschema:"omitempty"`
+		// PriorityClass is the priority class of the path in the worker
+		// pool, the default class is used if it's empty.
+		PriorityClass string `yaml:"priorityClass,omitempty" jsonschema:"omitempty"`
+		// Timeouts overrides the timeouts of the server and the rule.
+		Timeouts *RouteTimeoutsSpec `yaml:"timeouts,omitempty" jsonschema:"omitempty"`
 	}
 
 	// Header is the third level entry of router. A header entry is always under a specific path entry, that is to mean
@@ -93,14 +230,329 @@ type (
 	Header struct {
 		Key    string   `yaml:"key" jsonschema:"required"`
 		Values []string `yaml:"values,omitempty" jsonschema:"omitempty,uniqueItems=true"`
+		Prefix string   `yaml:"prefix,omitempty" jsonschema:"omitempty"`
 		Regexp string   `yaml:"regexp,omitempty" jsonschema:"omitempty,format=regexp"`
+		// Exists matches the header with any value, even an empty one.
+		Exists bool `yaml:"exists,omitempty" jsonschema:"omitempty"`
 
 		headerRE *regexp.Regexp
 	}
 )
 
+// listenAddresses returns the TCP addresses to listen on.
+func (spec *Spec) listenAddresses() []string {
+	port := strconv.Itoa(int(spec.Port))
+
+	var addresses []string
+	for _, ip := range append([]string{spec.Address}, spec.Addresses...) {
+		if ip == "" {
+			continue
+		}
+		address := net.JoinHostPort(ip, port)
+		if !stringtool.StrInSlice(address, addresses) {
+			addresses = append(addresses, address)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return []string{":" + port}
+	}
+	return addresses
+}
+
+func (spec *Spec) keepAliveTimeout() time.Duration {
+	if spec.KeepAliveTimeout == "" {
+		return defaultKeepAliveTimeout
+	}
+	t, err := time.ParseDuration(spec.KeepAliveTimeout)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", spec.KeepAliveTimeout, err)
+		return defaultKeepAliveTimeout
+	}
+	return t
+}
+
+func (spec *Spec) maxConnectionLifetime() time.Duration {
+	d, _ := time.ParseDuration(spec.MaxConnectionLifetime)
+	return d
+}
+
+// timeouts returns the read, read header and write timeouts.
+func (spec *Spec) timeouts() (read, readHeader, write time.Duration) {
+	// NOTE: The durations have been validated.
+	read, _ = time.ParseDuration(spec.ReadTimeout)
+	readHeader, _ = time.ParseDuration(spec.ReadHeaderTimeout)
+	write, _ = time.ParseDuration(spec.WriteTimeout)
+	return
+}
+
+func (spec *Spec) shutdownTimeout() time.Duration {
+	// NOTE: The duration has been validated.
+	d, _ := time.ParseDuration(spec.ShutdownTimeout)
+	if d == 0 {
+		return defaultShutdownTimeout
+	}
+	return d
+}
+
+func (spec *Spec) validateTimeouts() error {
+	for _, timeout := range []struct {
+		name  string
+		value string
+	}{
+		{"readTimeout", spec.ReadTimeout},
+		{"readHeaderTimeout", spec.ReadHeaderTimeout},
+		{"writeTimeout", spec.WriteTimeout},
+		{"shutdownTimeout", spec.ShutdownTimeout},
+	} {
+		if timeout.value == "" {
+			continue
+		}
+		d, err := time.ParseDuration(timeout.value)
+		if err != nil {
+			return fmt.Errorf("invalid %s %s: %v", timeout.name, timeout.value, err)
+		}
+		if d <= 0 {
+			return fmt.Errorf("%s must be positive", timeout.name)
+		}
+	}
+
+	read, readHeader, _ := spec.timeouts()
+	if read != 0 && readHeader > read {
+		return fmt.Errorf("readHeaderTimeout %v is greater than readTimeout %v", readHeader, read)
+	}
+	return nil
+}
+
 // Validate validates HTTPServerSpec.
 func (spec *Spec) Validate() error {
+	if err := spec.validateTimeouts(); err != nil {
+		return err
+	}
+
+	if spec.Port == 0 {
+		if spec.UnixSocket == nil {
+			return fmt.Errorf("port is zero and unixSocket is absent")
+		}
+		if spec.HTTP3 || spec.TCP != nil {
+			return fmt.Errorf("http3 and tcp require port")
+		}
+	}
+
+	for _, ip := range append([]string{spec.Address}, spec.Addresses...) {
+		if ip != "" && net.ParseIP(ip) == nil {
+			return fmt.Errorf("invalid address %s", ip)
+		}
+	}
+	if spec.Address != "" || len(spec.Addresses) != 0 {
+		if spec.Port == 0 {
+			return fmt.Errorf("address and addresses require port")
+		}
+		if spec.HTTP3 && len(spec.listenAddresses()) > 1 {
+			return fmt.Errorf("http3 supports only one address")
+		}
+	}
+
+	if spec.WebSocket != nil {
+		if err := spec.WebSocket.Validate(); err != nil {
+			return fmt.Errorf("webSocket: %v", err)
+		}
+	}
+
+	if len(spec.Hosts) != 0 {
+		if spec.Port == 0 {
+			return fmt.Errorf("hosts requires port")
+		}
+		if spec.HTTP3 || spec.TCP != nil || spec.ProxyProtocol || spec.Sniffing != nil {
+			return fmt.Errorf("hosts doesn't support http3, tcp, proxyProtocol and sniffing")
+		}
+		if err := validateSharedHosts(spec.Hosts); err != nil {
+			return fmt.Errorf("hosts: %v", err)
+		}
+	}
+
+	if spec.Sniffing != nil {
+		if spec.Port == 0 {
+			return fmt.Errorf("sniffing requires port")
+		}
+		if spec.PreserveHeaderCase || spec.HTTP10Compatible {
+			return fmt.Errorf("sniffing doesn't support preserveHeaderCase and http10Compatible")
+		}
+		if err := spec.Sniffing.Validate(); err != nil {
+			return fmt.Errorf("sniffing: %v", err)
+		}
+	}
+
+	if spec.UnixSocket != nil {
+		if err := spec.UnixSocket.Validate(); err != nil {
+			return fmt.Errorf("unixSocket: %v", err)
+		}
+	}
+
+	if spec.TLSFingerprint && !spec.HTTPS {
+		return fmt.Errorf("tlsFingerprint requires https")
+	}
+	if spec.TLSFingerprint && spec.UnixSocket != nil {
+		// NOTE: The fingerprints are looked up by the remote addresses,
+		// which are the same for all clients of a unix socket.
+		return fmt.Errorf("tlsFingerprint doesn't support unixSocket")
+	}
+
+	if (spec.PreserveHeaderCase || spec.HTTP10Compatible) && spec.HTTPS {
+		return fmt.Errorf("preserveHeaderCase and http10Compatible don't support https")
+	}
+
+	if spec.SessionTicket != nil {
+		if !spec.HTTPS {
+			return fmt.Errorf("sessionTicket requires https")
+		}
+		if err := spec.SessionTicket.Validate(); err != nil {
+			return fmt.Errorf("sessionTicket: %v", err)
+		}
+	}
+
+	if spec.HTTPRedirect != nil {
+		if !spec.HTTPS || spec.Port == 0 {
+			return fmt.Errorf("httpRedirect requires https and port")
+		}
+		if err := spec.HTTPRedirect.Validate(); err != nil {
+			return fmt.Errorf("httpRedirect: %v", err)
+		}
+		if spec.HTTPRedirect.Port == spec.Port {
+			return fmt.Errorf("httpRedirect: port conflicts with the server")
+		}
+	}
+
+	if spec.ForwardClientCert != nil {
+		if !spec.HTTPS || spec.CaCertBase64 == "" {
+			return fmt.Errorf("forwardClientCert requires https and caCertBase64")
+		}
+		if err := spec.ForwardClientCert.Validate(); err != nil {
+			return fmt.Errorf("forwardClientCert: %v", err)
+		}
+	}
+
+	if spec.OCSPStapling != nil {
+		if !spec.HTTPS {
+			return fmt.Errorf("ocspStapling requires https")
+		}
+		if err := spec.OCSPStapling.Validate(); err != nil {
+			return fmt.Errorf("ocspStapling: %v", err)
+		}
+	}
+
+	if spec.TLS != nil {
+		if !spec.HTTPS {
+			return fmt.Errorf("tls requires https")
+		}
+		if err := spec.TLS.Validate(); err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		if (spec.HTTP2 == nil || !spec.HTTP2.Disabled) && !spec.TLS.supportHTTP2() {
+			return fmt.Errorf("tls: cipherSuites must contain TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 " +
+				"or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 for http2")
+		}
+		if _, maxVersion := spec.TLS.versions(); spec.HTTP3 && maxVersion != 0 && maxVersion < tls.VersionTLS13 {
+			return fmt.Errorf("tls: http3 requires TLS 1.3")
+		}
+	}
+
+	if spec.TCP != nil {
+		if err := spec.TCP.Validate(); err != nil {
+			return fmt.Errorf("tcp: %v", err)
+		}
+	}
+
+	if spec.H2C {
+		if spec.HTTPS {
+			return fmt.Errorf("h2c doesn't support https, which negotiates http2 via ALPN")
+		}
+		if spec.PreserveHeaderCase || spec.HTTP10Compatible {
+			return fmt.Errorf("h2c doesn't support preserveHeaderCase and http10Compatible")
+		}
+	}
+
+	if spec.HTTP2 != nil {
+		if !spec.HTTPS && !spec.H2C {
+			return fmt.Errorf("http2 requires https or h2c")
+		}
+		if spec.H2C && spec.HTTP2.Disabled {
+			return fmt.Errorf("http2 is disabled while h2c is enabled")
+		}
+		if err := spec.HTTP2.Validate(); err != nil {
+			return fmt.Errorf("http2: %v", err)
+		}
+	}
+
+	if spec.ClientIP != nil {
+		if err := spec.ClientIP.Validate(); err != nil {
+			return fmt.Errorf("clientIP: %v", err)
+		}
+	}
+
+	if spec.Maintenance != nil {
+		if err := spec.Maintenance.Validate(); err != nil {
+			return fmt.Errorf("maintenance: %v", err)
+		}
+	}
+
+	if spec.WorkerPool != nil {
+		if err := spec.WorkerPool.Validate(); err != nil {
+			return fmt.Errorf("workerPool: %v", err)
+		}
+	}
+
+	if err := validateResponseHeaders(spec.ResponseHeaders); err != nil {
+		return fmt.Errorf("responseHeaders: %v", err)
+	}
+
+	if err := validateErrorPages(spec.ErrorPages); err != nil {
+		return fmt.Errorf("errorPages: %v", err)
+	}
+
+	if spec.Debug != nil {
+		if err := spec.Debug.Validate(); err != nil {
+			return fmt.Errorf("debug: %v", err)
+		}
+	}
+
+	if spec.WarmUp != nil {
+		if err := spec.WarmUp.Validate(); err != nil {
+			return fmt.Errorf("warmUp: %v", err)
+		}
+	}
+
+	for _, r := range spec.Rules {
+		if r.Timeouts != nil {
+			if err := r.Timeouts.Validate(); err != nil {
+				return fmt.Errorf("timeouts of rule %s%s: %v", r.Host, r.HostRegexp, err)
+			}
+		}
+		for _, p := range r.Paths {
+			if isPathTemplate(p.Path) {
+				if p.PathRegexp != "" {
+					return fmt.Errorf("path %s with parameters and pathRegexp are exclusive", p.Path)
+				}
+				if _, _, err := compilePathTemplate(p.Path, newRegexps(nil)); err != nil {
+					return fmt.Errorf("path %s: %v", p.Path, err)
+				}
+			}
+			if p.RequireAuth && p.AllowAnonymous {
+				return fmt.Errorf("requireAuth and allowAnonymous of path %s%s%s are exclusive",
+					p.Path, p.PathPrefix, p.PathRegexp)
+			}
+			if p.PriorityClass != "" && (spec.WorkerPool == nil || !spec.WorkerPool.hasClass(p.PriorityClass)) {
+				return fmt.Errorf("priorityClass %s of path %s%s%s is not defined in workerPool",
+					p.PriorityClass, p.Path, p.PathPrefix, p.PathRegexp)
+			}
+			if p.Timeouts != nil {
+				if err := p.Timeouts.Validate(); err != nil {
+					return fmt.Errorf("timeouts of path %s%s%s: %v", p.Path, p.PathPrefix, p.PathRegexp, err)
+				}
+			}
+		}
+	}
+
 	if !spec.HTTPS {
 		if spec.HTTP3 {
 			return fmt.Errorf("https is disabled when http3 enabled")
@@ -108,6 +560,11 @@ func (spec *Spec) Validate() error {
 		return nil
 	}
 
+	// NOTE: HTTP/3 requires TLS 1.3, while only TLS 1.2 is allowed.
+	if spec.HTTP3 && fips.Enabled() {
+		return fmt.Errorf("http3 is not allowed in FIPS mode")
+	}
+
 	if spec.CertBase64 == "" && spec.KeyBase64 == "" && len(spec.Certs) == 0 && len(spec.Keys) == 0 && !spec.AutoCert {
 		return fmt.Errorf("certBase64/keyBase64, certs/keys are both empty and autocert is disabled when https enabled")
 	}
@@ -127,73 +584,79 @@ func tryDecodeBase64Pem(pem string) []byte {
 }
 
 func (spec *Spec) tlsConfig() (*tls.Config, error) {
-	var certificates []tls.Certificate
-
-	if spec.CertBase64 != "" && spec.KeyBase64 != "" {
-		// Prefer add CertBase64 and KeyBase64
-		certPem, _ := base64.StdEncoding.DecodeString(spec.CertBase64)
-		keyPem, _ := base64.StdEncoding.DecodeString(spec.KeyBase64)
-		cert, err := tls.X509KeyPair(certPem, keyPem)
-		if err != nil {
-			return nil, fmt.Errorf("generate x509 key pair failed: %v", err)
-		}
-		certificates = append(certificates, cert)
-	}
-
-	for k, v := range spec.Certs {
-		secret, exists := spec.Keys[k]
-		if !exists {
-			return nil, fmt.Errorf("certs %s hasn't secret corresponded to it", k)
-		}
-
-		certPem := tryDecodeBase64Pem(v)
-		keyPem := tryDecodeBase64Pem(secret)
-		cert, err := tls.X509KeyPair(certPem, keyPem)
-		if err != nil {
-			return nil, fmt.Errorf("generate x509 key pair for %s failed: %s ", k, err)
-		}
-		certificates = append(certificates, cert)
-	}
-
-	if len(certificates) == 0 && !spec.AutoCert {
-		return nil, fmt.Errorf("none valid certs and secret")
+	certs, err := spec.certificates()
+	if err != nil {
+		return nil, err
 	}
+	return spec.newTLSConfig(func() *certificates { return certs }), nil
+}
 
+// newTLSConfig creates the TLS config, getCerts returns the current
+// certificates, so they could be updated without restarting the server.
+func (spec *Spec) newTLSConfig(getCerts func() *certificates) *tls.Config {
 	// TLS-ALPN-01 challenges requires HTTP server to listen on port 443, but we don't
 	// know which HTTP server listen on this port (consider there's an nginx sitting in
 	// front of Easegress), so all HTTP servers need to handle TLS-ALPN-01 challenges.
 	// But for HTTP servers who have disabled AutoCert, it should only handle the
 	// TLS-ALPN-01 token certificate request.
 	tlsConf := &tls.Config{
-		Certificates: certificates,
-		NextProtos:   []string{"acme-tls/1"},
+		NextProtos: []string{"acme-tls/1"},
+	}
+	if spec.TLS != nil {
+		spec.TLS.apply(tlsConf)
 	}
+	fips.ApplyTLS(tlsConf)
 	tlsConf.GetCertificate = func(chi *tls.ClientHelloInfo) (*tls.Certificate, error) {
-		return autocertmanager.GetCertificate(chi, !spec.AutoCert /* tokenOnly */)
+		cert, err := autocertmanager.GetCertificate(chi, !spec.AutoCert /* tokenOnly */)
+		if cert != nil {
+			return cert, nil
+		}
+
+		// Select the static certificate by SNI.
+		if cert := getCerts().get(chi.ServerName); cert != nil {
+			return cert, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("no certificate for %s", chi.ServerName)
+		}
+		return nil, err
 	}
 
-	// if caCertBase64 configuration is provided, should enable tls.ClientAuth and
-	// add the root cert
-	if len(spec.CaCertBase64) != 0 {
-		rootCertPem, _ := base64.StdEncoding.DecodeString(spec.CaCertBase64)
-		certPool := x509.NewCertPool()
-		certPool.AppendCertsFromPEM(rootCertPem)
+	// The client CAs could be updated too, the TLS config is copied with
+	// the current client CAs for new connections if they are changed.
+	// NOTE: The copy is made from tlsConf at the time of handshakes, so it
+	// keeps the changes made by others after this function, e.g. HTTP/2.
+	initialCerts := getCerts()
+	initialCerts.setClientAuth(tlsConf)
+	tlsConf.GetConfigForClient = func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+		certs := getCerts()
+		if certs.caCertBase64 == initialCerts.caCertBase64 {
+			return nil, nil
+		}
 
-		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
-		tlsConf.ClientCAs = certPool
+		conf := tlsConf.Clone()
+		conf.GetConfigForClient = nil
+		certs.setClientAuth(conf)
+		return conf, nil
 	}
 
-	return tlsConf, nil
+	return tlsConf
 }
 
-func (h *Header) initHeaderRoute() {
-	h.headerRE = regexp.MustCompile(h.Regexp)
+func (h *Header) initHeaderRoute(res *regexps) error {
+	if h.Regexp == "" {
+		return nil
+	}
+
+	var err error
+	h.headerRE, err = res.compile(h.Regexp)
+	return err
 }
 
 // Validate validates Header.
 func (h *Header) Validate() error {
-	if len(h.Values) == 0 && h.Regexp == "" {
-		return fmt.Errorf("both of values and regexp are empty for key: %s", h.Key)
+	if len(h.Values) == 0 && h.Prefix == "" && h.Regexp == "" && !h.Exists {
+		return fmt.Errorf("none of values, prefix, regexp and exists is set for key: %s", h.Key)
 	}
 
 	return nil
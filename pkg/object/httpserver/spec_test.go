@@ -0,0 +1,81 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSpecTimeouts(t *testing.T) {
+	spec := &Spec{Port: 80}
+	if err := spec.validateTimeouts(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	read, readHeader, write := spec.timeouts()
+	if read != 0 || readHeader != 0 || write != 0 {
+		t.Errorf("timeouts should be zero by default")
+	}
+
+	spec.ReadTimeout, spec.ReadHeaderTimeout, spec.WriteTimeout = "30s", "5s", "1m"
+	if err := spec.validateTimeouts(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	read, readHeader, write = spec.timeouts()
+	if read != 30*time.Second || readHeader != 5*time.Second || write != time.Minute {
+		t.Errorf("unexpected timeouts: %v, %v, %v", read, readHeader, write)
+	}
+
+	invalid := []*Spec{
+		{ReadTimeout: "abc"},
+		{ReadHeaderTimeout: "-1s"},
+		{WriteTimeout: "0s"},
+		{ReadTimeout: "5s", ReadHeaderTimeout: "10s"},
+	}
+	for i, spec := range invalid {
+		if err := spec.validateTimeouts(); err == nil {
+			t.Errorf("spec %d: expect an error", i)
+		}
+	}
+
+	// readHeaderTimeout could be used without readTimeout.
+	spec = &Spec{ReadHeaderTimeout: "10s"}
+	if err := spec.validateTimeouts(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSpecShutdownTimeout(t *testing.T) {
+	spec := &Spec{}
+	if d := spec.shutdownTimeout(); d != defaultShutdownTimeout {
+		t.Errorf("want the default shutdown timeout, got %v", d)
+	}
+
+	spec.ShutdownTimeout = "5s"
+	if err := spec.validateTimeouts(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if d := spec.shutdownTimeout(); d != 5*time.Second {
+		t.Errorf("want shutdown timeout 5s, got %v", d)
+	}
+
+	spec.ShutdownTimeout = "-1s"
+	if err := spec.validateTimeouts(); err == nil {
+		t.Errorf("expect an error")
+	}
+}
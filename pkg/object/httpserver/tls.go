@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"github.com/megaease/easegress/pkg/util/fips"
+)
+
+// TLSSpec describes the TLS versions, cipher suites and curves of the
+// HTTPServer, the defaults of Go are used for the absent ones.
+type TLSSpec struct {
+	// MinVersion and MaxVersion are the TLS versions: 1.0, 1.1, 1.2, 1.3.
+	MinVersion string `yaml:"minVersion" jsonschema:"omitempty,enum=,enum=1.0,enum=1.1,enum=1.2,enum=1.3"`
+	MaxVersion string `yaml:"maxVersion" jsonschema:"omitempty,enum=,enum=1.0,enum=1.1,enum=1.2,enum=1.3"`
+	// CipherSuites are the IANA names of the cipher suites of TLS 1.0-1.2,
+	// the cipher suites of TLS 1.3 are not configurable.
+	CipherSuites []string `yaml:"cipherSuites" jsonschema:"omitempty,uniqueItems=true"`
+	// CurvePreferences are the elliptic curves in preference order:
+	// X25519, P256, P384, P521.
+	CurvePreferences []string `yaml:"curvePreferences" jsonschema:"omitempty,uniqueItems=true"`
+}
+
+var (
+	tlsVersions = map[string]uint16{
+		"1.0": tls.VersionTLS10,
+		"1.1": tls.VersionTLS11,
+		"1.2": tls.VersionTLS12,
+		"1.3": tls.VersionTLS13,
+	}
+
+	tlsCurves = map[string]tls.CurveID{
+		"X25519": tls.X25519,
+		"P256":   tls.CurveP256,
+		"P384":   tls.CurveP384,
+		"P521":   tls.CurveP521,
+	}
+
+	// http2CipherSuites are the cipher suites required by HTTP/2 if the
+	// cipher suites are configured.
+	// Reference: https://httpwg.org/specs/rfc7540.html#rfc.section.9.2.2
+	http2CipherSuites = []uint16{
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	}
+)
+
+// cipherSuiteID returns the ID of the cipher suite name, the insecure
+// ones are supported too, for legacy clients.
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, cs := range tls.CipherSuites() {
+		if cs.Name == name {
+			return cs.ID, true
+		}
+	}
+	for _, cs := range tls.InsecureCipherSuites() {
+		if cs.Name == name {
+			return cs.ID, true
+		}
+	}
+	return 0, false
+}
+
+// Validate validates TLSSpec.
+func (spec *TLSSpec) Validate() error {
+	minVersion, maxVersion := spec.versions()
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return fmt.Errorf("minVersion %s is greater than maxVersion %s", spec.MinVersion, spec.MaxVersion)
+	}
+
+	for _, name := range spec.CipherSuites {
+		if _, ok := cipherSuiteID(name); !ok {
+			return fmt.Errorf("unknown cipher suite %s", name)
+		}
+	}
+	if len(spec.CipherSuites) != 0 && minVersion == tls.VersionTLS13 {
+		return fmt.Errorf("cipherSuites are not configurable for TLS 1.3")
+	}
+	if err := fips.CheckTLSVersions(minVersion, maxVersion); err != nil {
+		return err
+	}
+	for _, name := range spec.CipherSuites {
+		id, _ := cipherSuiteID(name)
+		if err := fips.CheckCipherSuite(id); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range spec.CurvePreferences {
+		if _, ok := tlsCurves[name]; !ok {
+			return fmt.Errorf("unknown curve %s", name)
+		}
+		if err := fips.CheckCurve(tlsCurves[name]); err != nil {
+			return fmt.Errorf("curve %s is not allowed in FIPS mode", name)
+		}
+	}
+
+	return nil
+}
+
+// versions returns the min and max versions, zero means the default.
+func (spec *TLSSpec) versions() (uint16, uint16) {
+	return tlsVersions[spec.MinVersion], tlsVersions[spec.MaxVersion]
+}
+
+// supportHTTP2 reports whether the cipher suites meet the requirement
+// of HTTP/2, which is checked when HTTP/2 is configured.
+func (spec *TLSSpec) supportHTTP2() bool {
+	if len(spec.CipherSuites) == 0 {
+		return true
+	}
+
+	for _, name := range spec.CipherSuites {
+		id, _ := cipherSuiteID(name)
+		for _, required := range http2CipherSuites {
+			if id == required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// apply applies the spec to the TLS config.
+func (spec *TLSSpec) apply(tlsConf *tls.Config) {
+	tlsConf.MinVersion, tlsConf.MaxVersion = spec.versions()
+
+	tlsConf.CipherSuites = nil
+	for _, name := range spec.CipherSuites {
+		id, _ := cipherSuiteID(name)
+		tlsConf.CipherSuites = append(tlsConf.CipherSuites, id)
+	}
+
+	tlsConf.CurvePreferences = nil
+	for _, name := range spec.CurvePreferences {
+		tlsConf.CurvePreferences = append(tlsConf.CurvePreferences, tlsCurves[name])
+	}
+}
@@ -0,0 +1,90 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+func TestTLSSpecValidate(t *testing.T) {
+	valid := []*TLSSpec{
+		{},
+		{MinVersion: "1.2", MaxVersion: "1.3"},
+		{MinVersion: "1.2", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+		{CurvePreferences: []string{"X25519", "P256"}},
+	}
+	for i, spec := range valid {
+		if err := spec.Validate(); err != nil {
+			t.Errorf("spec %d: unexpected error: %v", i, err)
+		}
+	}
+
+	invalid := []*TLSSpec{
+		{MinVersion: "1.3", MaxVersion: "1.2"},
+		{CipherSuites: []string{"TLS_UNKNOWN"}},
+		{MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}},
+		{CurvePreferences: []string{"P224"}},
+	}
+	for i, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("spec %d: expect an error", i)
+		}
+	}
+}
+
+func TestTLSSpecSupportHTTP2(t *testing.T) {
+	spec := &TLSSpec{}
+	if !spec.supportHTTP2() {
+		t.Errorf("default cipher suites should support http2")
+	}
+
+	spec.CipherSuites = []string{"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"}
+	if spec.supportHTTP2() {
+		t.Errorf("cipher suites without the required one should not support http2")
+	}
+
+	spec.CipherSuites = append(spec.CipherSuites, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+	if !spec.supportHTTP2() {
+		t.Errorf("cipher suites with the required one should support http2")
+	}
+}
+
+func TestTLSSpecApply(t *testing.T) {
+	spec := &TLSSpec{
+		MinVersion:       "1.2",
+		CipherSuites:     []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384"},
+		CurvePreferences: []string{"P384", "X25519"},
+	}
+
+	tlsConf := &tls.Config{}
+	spec.apply(tlsConf)
+
+	if tlsConf.MinVersion != tls.VersionTLS12 || tlsConf.MaxVersion != 0 {
+		t.Errorf("unexpected versions: %x, %x", tlsConf.MinVersion, tlsConf.MaxVersion)
+	}
+	if len(tlsConf.CipherSuites) != 2 || tlsConf.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 ||
+		tlsConf.CipherSuites[1] != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("unexpected cipher suites: %v", tlsConf.CipherSuites)
+	}
+	if len(tlsConf.CurvePreferences) != 2 || tlsConf.CurvePreferences[0] != tls.CurveP384 ||
+		tlsConf.CurvePreferences[1] != tls.X25519 {
+		t.Errorf("unexpected curves: %v", tlsConf.CurvePreferences)
+	}
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// staleSocketCheckTimeout is the timeout to check if a socket file is
+// listened by a process.
+const staleSocketCheckTimeout = time.Second
+
+// UnixSocketSpec describes the unix domain socket of the HTTPServer.
+type UnixSocketSpec struct {
+	Path string `yaml:"path" jsonschema:"required"`
+	// Mode is the octal permission of the socket file, e.g. 0660, the
+	// default is decided by the umask of the process.
+	Mode string `yaml:"mode" jsonschema:"omitempty,pattern=^0?[0-7]{3}$"`
+}
+
+// Validate validates UnixSocketSpec.
+func (spec *UnixSocketSpec) Validate() error {
+	if spec.Path == "" {
+		return fmt.Errorf("empty path")
+	}
+	if _, err := spec.fileMode(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (spec *UnixSocketSpec) fileMode() (os.FileMode, error) {
+	if spec.Mode == "" {
+		return 0, nil
+	}
+	mode, err := strconv.ParseUint(spec.Mode, 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid mode %s", spec.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+func sameUnixSocket(x, y *Spec) bool {
+	return x.UnixSocket != nil && y.UnixSocket != nil && x.UnixSocket.Path == y.UnixSocket.Path
+}
+
+// listenUnix listens on the unix socket. The socket file is kept when
+// the listener closes, so the child process of the graceful update
+// inherits a reachable socket like the TCP ones, and the stale socket
+// file left by previous processes is removed before listening.
+func listenUnix(spec *UnixSocketSpec) (net.Listener, error) {
+	if isStaleSocket(spec.Path) {
+		if err := os.Remove(spec.Path); err != nil {
+			return nil, fmt.Errorf("remove stale socket %s failed: %v", spec.Path, err)
+		}
+	}
+
+	listener, err := gnet.Listen("unix", spec.Path)
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := listener.(*net.UnixListener); ok {
+		l.SetUnlinkOnClose(false)
+	}
+
+	mode, _ := spec.fileMode()
+	if mode != 0 {
+		if err := os.Chmod(spec.Path, mode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("chmod %s failed: %v", spec.Path, err)
+		}
+	}
+
+	return listener, nil
+}
+
+// isStaleSocket returns true if the path is a socket file listened by
+// nobody, the ones listened by the parent process of the graceful update
+// are not stale.
+func isStaleSocket(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return false
+	}
+
+	conn, err := net.DialTimeout("unix", path, staleSocketCheckTimeout)
+	if err != nil {
+		return true
+	}
+	conn.Close()
+	return false
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnixSocketSpecValidate(t *testing.T) {
+	for _, mode := range []string{"", "660", "0660", "0777"} {
+		spec := &UnixSocketSpec{Path: "/tmp/eg.sock", Mode: mode}
+		if err := spec.Validate(); err != nil {
+			t.Errorf("mode %s: unexpected error: %v", mode, err)
+		}
+	}
+
+	for _, spec := range []*UnixSocketSpec{{}, {Path: "/tmp/eg.sock", Mode: "0999"}, {Path: "/tmp/eg.sock", Mode: "01777"}} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("spec %+v: expect an error", spec)
+		}
+	}
+
+	// The fingerprints are looked up by the remote addresses, which are
+	// the same for the clients of a unix socket.
+	spec := &Spec{Port: 10080, HTTPS: true, TLSFingerprint: true, UnixSocket: &UnixSocketSpec{Path: "/tmp/eg.sock"}}
+	if err := spec.Validate(); err == nil || !strings.Contains(err.Error(), "tlsFingerprint") {
+		t.Errorf("tlsFingerprint with unixSocket should be rejected, got %v", err)
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpserver")
+	if err != nil {
+		t.Fatalf("create temp dir failed: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	spec := &UnixSocketSpec{Path: filepath.Join(dir, "eg.sock"), Mode: "0600"}
+	l, err := listenUnix(spec)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := os.Stat(spec.Path)
+	if err != nil {
+		t.Fatalf("stat socket failed: %v", err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("want mode 0600, got %o", info.Mode().Perm())
+	}
+
+	// A socket being listened is not stale.
+	if isStaleSocket(spec.Path) {
+		t.Errorf("socket should not be stale")
+	}
+
+	conn, err := net.Dial("unix", spec.Path)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	conn.Close()
+
+	// The socket file is kept on close, and it's stale afterwards.
+	l.Close()
+	if !isStaleSocket(spec.Path) {
+		t.Errorf("socket should be stale")
+	}
+
+	l, err = listenUnix(spec)
+	if err != nil {
+		t.Fatalf("listen on stale socket failed: %v", err)
+	}
+	l.Close()
+}
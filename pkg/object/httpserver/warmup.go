@@ -0,0 +1,188 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	stdcontext "context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	defaultWarmUpTimeout = 10 * time.Second
+
+	// warmUpHeader marks the warm-up requests, so that they could be
+	// recognized by the filters and the upstreams.
+	warmUpHeader = "X-EG-Warm-Up"
+
+	stateWarmingUp stateType = "warmingUp"
+)
+
+type (
+	// WarmUpSpec describes the synthetic requests fired after the server
+	// starts or reloads, to establish upstream connections, initialize
+	// plugins and warm caches before serving real traffic.
+	WarmUpSpec struct {
+		Requests []*WarmUpRequest `yaml:"requests" jsonschema:"required,minItems=1"`
+		// Timeout is the timeout of every request.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// WarmUpRequest is a synthetic request.
+	WarmUpRequest struct {
+		Method  string            `yaml:"method" jsonschema:"omitempty,format=httpmethod"`
+		Host    string            `yaml:"host" jsonschema:"omitempty"`
+		Path    string            `yaml:"path" jsonschema:"required,pattern=^/"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		// Repeat is the number of times to send the request concurrently,
+		// it is useful to establish multiple upstream connections.
+		Repeat uint16 `yaml:"repeat" jsonschema:"omitempty,minimum=1"`
+	}
+
+	eventWarmedUp struct {
+		warmUpNum uint64
+	}
+
+	// discardResponseWriter discards the responses of warm-up requests.
+	discardResponseWriter struct {
+		header http.Header
+	}
+)
+
+// Validate validates WarmUpSpec.
+func (spec *WarmUpSpec) Validate() error {
+	if spec.Timeout != "" {
+		if _, err := time.ParseDuration(spec.Timeout); err != nil {
+			return err
+		}
+	}
+	for _, r := range spec.Requests {
+		if _, err := r.newRequest(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (spec *WarmUpSpec) timeout() time.Duration {
+	d, err := time.ParseDuration(spec.Timeout)
+	if err != nil || d <= 0 {
+		return defaultWarmUpTimeout
+	}
+	return d
+}
+
+func (r *WarmUpRequest) newRequest() (*http.Request, error) {
+	method := r.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	host := r.Host
+	if host == "" {
+		host = "localhost"
+	}
+
+	req, err := http.NewRequest(method, "http://"+host+r.Path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid warm-up request %s %s: %v", method, r.Path, err)
+	}
+	for k, v := range r.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set(warmUpHeader, "true")
+	req.RemoteAddr = "127.0.0.1:0"
+	return req, nil
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(statusCode int) {}
+
+// warmUp sends the warm-up requests through the mux, it returns after
+// all requests finish.
+func warmUp(name string, spec *WarmUpSpec, handler http.Handler) {
+	timeout := spec.timeout()
+
+	var wg sync.WaitGroup
+	for _, r := range spec.Requests {
+		repeat := int(r.Repeat)
+		if repeat == 0 {
+			repeat = 1
+		}
+
+		for i := 0; i < repeat; i++ {
+			req, err := r.newRequest()
+			if err != nil {
+				logger.Errorf("BUG: %s: %v", name, err)
+				continue
+			}
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				ctx, cancel := stdcontext.WithTimeout(stdcontext.Background(), timeout)
+				defer cancel()
+
+				w := &discardResponseWriter{header: http.Header{}}
+				handler.ServeHTTP(w, req.WithContext(ctx))
+			}()
+		}
+	}
+	wg.Wait()
+
+	logger.Infof("%s: warm-up finished", name)
+}
+
+// startWarmUp starts warming up, if the server has just been started,
+// the state is warmingUp until the warm-up finishes.
+func (r *runtime) startWarmUp(started bool) {
+	if r.spec == nil || r.spec.WarmUp == nil || r.getState() == stateFailed {
+		return
+	}
+	spec := r.spec.WarmUp
+
+	if started {
+		r.warmUpNum++
+		r.setState(stateWarmingUp)
+	}
+
+	name, warmUpNum := r.superSpec.Name(), r.warmUpNum
+	go func() {
+		warmUp(name, spec, r.mux)
+		if started {
+			r.events.send(&eventWarmedUp{warmUpNum: warmUpNum})
+		}
+	}()
+}
+
+func (r *runtime) handleEventWarmedUp(e *eventWarmedUp) {
+	if e.warmUpNum == r.warmUpNum && r.getState() == stateWarmingUp {
+		r.setState(stateRunning)
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestWarmUpSpecValidate(t *testing.T) {
+	spec := &WarmUpSpec{Requests: []*WarmUpRequest{{Path: "/health"}}, Timeout: "3s"}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if spec.timeout().Seconds() != 3 {
+		t.Errorf("expected timeout 3s, got %v", spec.timeout())
+	}
+
+	spec.Timeout = "3"
+	if err := spec.Validate(); err == nil {
+		t.Errorf("expect error for invalid timeout")
+	}
+
+	spec = &WarmUpSpec{Requests: []*WarmUpRequest{{Method: "BAD METHOD", Path: "/"}}}
+	if err := spec.Validate(); err == nil {
+		t.Errorf("expect error for invalid method")
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	logger.InitNop()
+
+	var mutex sync.Mutex
+	counts := map[string]int{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(warmUpHeader) != "true" {
+			t.Errorf("expected warm-up header")
+		}
+		mutex.Lock()
+		counts[r.Method+" "+r.Host+r.URL.Path+" "+r.Header.Get("X-Test")]++
+		mutex.Unlock()
+		w.Write([]byte("ok"))
+	})
+
+	spec := &WarmUpSpec{
+		Requests: []*WarmUpRequest{
+			{Path: "/a", Repeat: 3},
+			{Method: http.MethodPost, Host: "example.com", Path: "/b", Headers: map[string]string{"X-Test": "1"}},
+		},
+	}
+	warmUp("server", spec, handler)
+
+	if counts["GET localhost/a "] != 3 {
+		t.Errorf("expected 3 requests to /a, got %v", counts)
+	}
+	if counts["POST example.com/b 1"] != 1 {
+		t.Errorf("expected 1 request to /b, got %v", counts)
+	}
+}
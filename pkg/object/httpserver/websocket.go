@@ -0,0 +1,231 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+type (
+	// WebSocketSpec describes the proxying of WebSocket connections.
+	WebSocketSpec struct {
+		// IdleTimeout closes the connections without any frames in both
+		// directions for the duration, zero means no limit.
+		IdleTimeout string `yaml:"idleTimeout" jsonschema:"omitempty,format=duration"`
+		// MaxConnections is the max number of WebSocket connections, the
+		// upgrade requests beyond it are rejected with 503, zero means no
+		// limit.
+		MaxConnections int64 `yaml:"maxConnections" jsonschema:"omitempty,minimum=0"`
+	}
+
+	// upgradeResponseWriter is the response writer of upgrade requests,
+	// the connection is hijacked once the backend switches protocols, so
+	// the writes after it are dropped.
+	upgradeResponseWriter struct {
+		http.ResponseWriter
+		hijacked bool
+	}
+
+	// idleReader resets the idle timer on every read.
+	idleReader struct {
+		r       io.Reader
+		timer   *time.Timer
+		timeout time.Duration
+	}
+)
+
+// Validate validates WebSocketSpec.
+func (spec *WebSocketSpec) Validate() error {
+	if spec.IdleTimeout == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(spec.IdleTimeout); err != nil {
+		return fmt.Errorf("invalid idleTimeout: %v", err)
+	}
+	return nil
+}
+
+func (spec *WebSocketSpec) idleTimeout() time.Duration {
+	if spec == nil || spec.IdleTimeout == "" {
+		return 0
+	}
+	d, _ := time.ParseDuration(spec.IdleTimeout)
+	return d
+}
+
+func (spec *WebSocketSpec) maxConnections() int64 {
+	if spec == nil {
+		return 0
+	}
+	return spec.MaxConnections
+}
+
+// isWebSocketUpgrade returns whether the request is a WebSocket upgrade
+// request, only HTTP/1.1 supports it.
+func isWebSocketUpgrade(r *http.Request) bool {
+	return r.ProtoMajor == 1 &&
+		httpguts.HeaderValuesContainsToken(r.Header["Connection"], "upgrade") &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket")
+}
+
+func (w *upgradeResponseWriter) WriteHeader(code int) {
+	if !w.hijacked {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *upgradeResponseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *upgradeResponseWriter) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("hijacking is not supported")
+	}
+
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+
+	// NOTE: Clear the deadlines set by the read and write timeouts of the
+	// server, the idle timeout takes over.
+	conn.SetDeadline(time.Time{})
+	return conn, rw, nil
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 && r.timer != nil {
+		r.timer.Reset(r.timeout)
+	}
+	return n, err
+}
+
+// acquireWebSocket counts the WebSocket connection, it returns false if
+// the max number of connections is reached.
+func (m *mux) acquireWebSocket(spec *WebSocketSpec) bool {
+	n := atomic.AddInt64(&m.webSocketConns, 1)
+	if max := spec.maxConnections(); max > 0 && n > max {
+		atomic.AddInt64(&m.webSocketConns, -1)
+		return false
+	}
+	return true
+}
+
+func (m *mux) releaseWebSocket() {
+	atomic.AddInt64(&m.webSocketConns, -1)
+}
+
+// webSocketConnections returns the number of WebSocket connections.
+func (m *mux) webSocketConnections() int64 {
+	return atomic.LoadInt64(&m.webSocketConns)
+}
+
+func (m *mux) handleTooManyWebSockets(ctx context.HTTPContext) {
+	ctx.AddTag("too many websocket connections")
+	ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+}
+
+// tunnelWebSocket tunnels the frames between the client and the backend
+// after the backend switches protocols, it returns when either side
+// closes the connection or the connection is idle for the idle timeout.
+func (m *mux) tunnelWebSocket(ctx context.HTTPContext, w *upgradeResponseWriter, spec *WebSocketSpec) {
+	resp := ctx.Response()
+	backend, ok := resp.Body().(io.ReadWriteCloser)
+	if !ok {
+		if body, ok := resp.Body().(io.Closer); ok {
+			body.Close()
+		}
+		resp.SetBody(nil)
+		resp.SetStatusCode(http.StatusBadGateway)
+		ctx.AddTag("websocket: no backend connection to tunnel")
+		return
+	}
+	resp.SetBody(nil)
+	defer backend.Close()
+
+	conn, rw, err := w.hijack()
+	if err != nil {
+		resp.SetStatusCode(http.StatusInternalServerError)
+		ctx.AddTag(stringtool.Cat("websocket: ", err.Error()))
+		return
+	}
+	defer conn.Close()
+
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	resp.Header().Std().Write(rw)
+	rw.WriteString("\r\n")
+	if err = rw.Flush(); err != nil {
+		ctx.AddTag(stringtool.Cat("websocket: write handshake failed: ", err.Error()))
+		return
+	}
+
+	in, out := pipeWebSocket(conn, rw.Reader, backend, spec.idleTimeout())
+	ctx.AddLazyTag(func() string {
+		return stringtool.Cat("websocket: ", strconv.FormatInt(in, 10), " bytes in, ",
+			strconv.FormatInt(out, 10), " bytes out")
+	})
+}
+
+// pipeWebSocket copies data between the client and the backend, the
+// reader of the client contains the data buffered by the server.
+func pipeWebSocket(client net.Conn, clientReader io.Reader, backend io.ReadWriteCloser,
+	idleTimeout time.Duration) (in, out int64) {
+	closeBoth := func() {
+		client.Close()
+		backend.Close()
+	}
+
+	var timer *time.Timer
+	if idleTimeout > 0 {
+		timer = time.AfterFunc(idleTimeout, closeBoth)
+		defer timer.Stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		in, _ = io.Copy(backend, &idleReader{r: clientReader, timer: timer, timeout: idleTimeout})
+		// NOTE: Closing both sides makes the other copying return.
+		closeBoth()
+		close(done)
+	}()
+
+	out, _ = io.Copy(client, &idleReader{r: backend, timer: timer, timeout: idleTimeout})
+	closeBoth()
+	<-done
+	return in, out
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/tracing"
+)
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com/ws", nil)
+	if isWebSocketUpgrade(req) {
+		t.Errorf("plain request is not an upgrade request")
+	}
+
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "WebSocket")
+	if !isWebSocketUpgrade(req) {
+		t.Errorf("request should be an upgrade request")
+	}
+
+	req.Header.Set("Upgrade", "h2c")
+	if isWebSocketUpgrade(req) {
+		t.Errorf("h2c upgrade is not a websocket upgrade")
+	}
+}
+
+func TestWebSocketSpec(t *testing.T) {
+	var spec *WebSocketSpec
+	if spec.idleTimeout() != 0 || spec.maxConnections() != 0 {
+		t.Errorf("nil spec should have no limit")
+	}
+
+	spec = &WebSocketSpec{IdleTimeout: "bad"}
+	if spec.Validate() == nil {
+		t.Errorf("invalid idleTimeout should fail")
+	}
+
+	spec = &WebSocketSpec{IdleTimeout: "1m", MaxConnections: 1}
+	if err := spec.Validate(); err != nil || spec.idleTimeout() != time.Minute {
+		t.Errorf("unexpected result: %v, %v", err, spec.idleTimeout())
+	}
+
+	m := &mux{}
+	if !m.acquireWebSocket(spec) || m.acquireWebSocket(spec) {
+		t.Errorf("only one connection should be acquired")
+	}
+	m.releaseWebSocket()
+	if m.webSocketConnections() != 0 {
+		t.Errorf("connections should be zero, got %d", m.webSocketConnections())
+	}
+}
+
+func TestTunnelWebSocket(t *testing.T) {
+	backend, server := net.Pipe()
+	go io.Copy(server, server)
+
+	m := &mux{}
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(stdw http.ResponseWriter, stdr *http.Request) {
+		defer close(done)
+
+		w := &upgradeResponseWriter{ResponseWriter: stdw}
+		ctx := context.New(w, stdr, tracing.NoopTracing, "test")
+		ctx.Response().SetStatusCode(http.StatusSwitchingProtocols)
+		ctx.Response().Header().Set("Connection", "Upgrade")
+		ctx.Response().Header().Set("Upgrade", "websocket")
+		ctx.Response().SetBody(backend)
+
+		m.tunnelWebSocket(ctx, w, &WebSocketSpec{})
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+
+	// NOTE: The frame sent along with the request is buffered by the
+	// server, it must be tunneled too.
+	io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: example.com\r\n"+
+		"Connection: Upgrade\r\nUpgrade: websocket\r\n\r\nhello")
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read response failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "websocket" {
+		t.Fatalf("unexpected response: %d %v", resp.StatusCode, resp.Header)
+	}
+
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(br, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("expect hello echoed, got %q, %v", buf, err)
+	}
+
+	io.WriteString(conn, "world")
+	if _, err = io.ReadFull(br, buf); err != nil || string(buf) != "world" {
+		t.Errorf("expect world echoed, got %q, %v", buf, err)
+	}
+
+	conn.Close()
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Errorf("tunnel should be closed with the client")
+	}
+}
+
+func TestPipeWebSocketIdleTimeout(t *testing.T) {
+	client, _ := net.Pipe()
+	backend, _ := net.Pipe()
+
+	done := make(chan struct{})
+	go func() {
+		pipeWebSocket(client, client, backend, 50*time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(3 * time.Second):
+		t.Errorf("idle connection should be closed")
+	}
+}
@@ -0,0 +1,424 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/protocol"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	defaultQueueTimeout = time.Second
+
+	// defaultPriorityClass is the class of the paths without one.
+	defaultPriorityClass = "default"
+	// strideOne is the stride of the classes of weight 1, the stride of
+	// a class is inversely proportional to its weight, so the weight is
+	// at most strideOne to keep the stride positive.
+	strideOne = 1 << 20
+)
+
+type (
+	// WorkerPoolSpec describes the worker pool bounding the number of
+	// requests running the pipelines concurrently.
+	WorkerPoolSpec struct {
+		MaxWorkers uint32 `yaml:"maxWorkers" jsonschema:"required,minimum=1"`
+		// MaxQueueLength is the max number of requests waiting for a
+		// worker, the requests beyond it are rejected immediately.
+		MaxQueueLength uint32 `yaml:"maxQueueLength" jsonschema:"omitempty"`
+		// QueueTimeout is the max time of a request waiting for a
+		// worker, the default is 1s.
+		QueueTimeout string `yaml:"queueTimeout" jsonschema:"omitempty,format=duration"`
+		// Classes are the priority classes sharing the workers by their
+		// weights when requests are queued, paths refer to them by
+		// priorityClass.
+		Classes []*PriorityClassSpec `yaml:"classes,omitempty" jsonschema:"omitempty"`
+	}
+
+	// PriorityClassSpec describes a priority class of the worker pool.
+	PriorityClassSpec struct {
+		Name   string `yaml:"name" jsonschema:"required"`
+		Weight uint32 `yaml:"weight" jsonschema:"required,minimum=1,maximum=1048576"`
+	}
+
+	// workerPool admits requests to run the pipelines, at most maxWorkers
+	// requests run at the same time, and the rest wait in the FIFO queues
+	// of their priority classes. The queues are served by the weighted
+	// fair queueing (stride scheduling): the class with the smallest pass
+	// is served first, and its pass advances by its stride, so the
+	// classes get the workers in proportion to their weights.
+	// It is kept across reloads, so the limits take effect in place.
+	workerPool struct {
+		mutex          sync.Mutex
+		maxWorkers     int
+		maxQueueLength int
+		queueTimeout   time.Duration
+
+		busy    int
+		queued  int
+		classes []*priorityClass
+		// pass is the pass of the last served class, the classes
+		// becoming backlogged start from it, so they can't take the
+		// workers by the credit accumulated while being idle.
+		pass uint64
+
+		rejected uint64
+		timedOut uint64
+		canceled uint64
+	}
+
+	priorityClass struct {
+		name   string
+		weight uint32
+		stride uint64
+		pass   uint64
+		queue  []chan struct{}
+	}
+
+	// WorkerPoolStatus is the status of the worker pool.
+	WorkerPoolStatus struct {
+		MaxWorkers int `yaml:"maxWorkers"`
+		Busy       int `yaml:"busy"`
+		Queued     int `yaml:"queued"`
+		// Rejected is the number of requests rejected for the full queue,
+		// TimedOut is the ones waiting beyond the queue timeout, and
+		// Canceled is the ones whose clients gone while waiting.
+		Rejected uint64 `yaml:"rejected"`
+		TimedOut uint64 `yaml:"timedOut"`
+		Canceled uint64 `yaml:"canceled"`
+		// Classes contains the number of queued requests of the
+		// priority classes, it's empty if no classes are configured.
+		Classes map[string]int `yaml:"classes,omitempty"`
+	}
+
+	errWorkerPool string
+)
+
+const (
+	errQueueFull    errWorkerPool = "worker pool queue is full"
+	errQueueTimeout errWorkerPool = "worker pool queue timeout"
+	errCanceled     errWorkerPool = "client gone while waiting for worker"
+)
+
+func (e errWorkerPool) Error() string {
+	return string(e)
+}
+
+// Validate validates WorkerPoolSpec.
+func (spec *WorkerPoolSpec) Validate() error {
+	if spec.MaxWorkers == 0 {
+		return fmt.Errorf("maxWorkers must be positive")
+	}
+
+	names := map[string]struct{}{}
+	for _, class := range spec.Classes {
+		if class.Name == "" {
+			return fmt.Errorf("name of class is empty")
+		}
+		if _, exists := names[class.Name]; exists {
+			return fmt.Errorf("class %s is duplicated", class.Name)
+		}
+		names[class.Name] = struct{}{}
+		if class.Weight == 0 || class.Weight > strideOne {
+			return fmt.Errorf("weight of class %s must be in [1, %d]", class.Name, strideOne)
+		}
+	}
+
+	if spec.QueueTimeout == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(spec.QueueTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid queueTimeout: %v", err)
+	}
+	if d <= 0 {
+		return fmt.Errorf("queueTimeout must be positive")
+	}
+	return nil
+}
+
+// hasClass returns whether the priority class is defined, the default
+// class is always defined.
+func (spec *WorkerPoolSpec) hasClass(name string) bool {
+	if name == defaultPriorityClass {
+		return true
+	}
+	for _, class := range spec.Classes {
+		if class.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (spec *WorkerPoolSpec) queueTimeout() time.Duration {
+	if spec.QueueTimeout == "" {
+		return defaultQueueTimeout
+	}
+	d, _ := time.ParseDuration(spec.QueueTimeout)
+	return d
+}
+
+func newWorkerPool() *workerPool {
+	wp := &workerPool{}
+	wp.reloadClasses(nil)
+	return wp
+}
+
+// reload applies the spec, a nil spec disables the pool, the waiting
+// requests are admitted if the pool is enlarged or disabled.
+func (wp *workerPool) reload(spec *WorkerPoolSpec) {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	if spec == nil {
+		wp.maxWorkers = 0
+	} else {
+		wp.maxWorkers = int(spec.MaxWorkers)
+		wp.maxQueueLength = int(spec.MaxQueueLength)
+		wp.queueTimeout = spec.queueTimeout()
+	}
+	wp.reloadClasses(spec)
+
+	for wp.queued > 0 && (wp.maxWorkers == 0 || wp.busy < wp.maxWorkers) {
+		wp.admitNext()
+	}
+}
+
+// reloadClasses rebuilds the priority classes, the waiting requests of
+// the removed classes are moved to the default class, the caller must
+// hold the lock.
+func (wp *workerPool) reloadClasses(spec *WorkerPoolSpec) {
+	weights := map[string]uint32{defaultPriorityClass: 1}
+	if spec != nil {
+		for _, class := range spec.Classes {
+			weights[class.Name] = class.Weight
+		}
+	}
+
+	old := map[string]*priorityClass{}
+	for _, class := range wp.classes {
+		old[class.name] = class
+	}
+
+	classes := make([]*priorityClass, 0, len(weights))
+	for name, weight := range weights {
+		class := old[name]
+		if class == nil {
+			class = &priorityClass{name: name, pass: wp.pass}
+		}
+		delete(old, name)
+		class.weight = weight
+		class.stride = strideOne / uint64(weight)
+		classes = append(classes, class)
+	}
+	// NOTE: Sort them so that the classes of the same pass are served
+	// in a stable order.
+	sort.Slice(classes, func(i, j int) bool {
+		return classes[i].name < classes[j].name
+	})
+	wp.classes = classes
+
+	def := wp.getClass(defaultPriorityClass)
+	for _, class := range old {
+		def.queue = append(def.queue, class.queue...)
+	}
+}
+
+// getClass returns the priority class of the name, or the default class
+// if the name is unknown, the caller must hold the lock.
+func (wp *workerPool) getClass(name string) *priorityClass {
+	var def *priorityClass
+	for _, class := range wp.classes {
+		if class.name == name {
+			return class
+		}
+		if class.name == defaultPriorityClass {
+			def = class
+		}
+	}
+	return def
+}
+
+// admitNext hands a worker to the first waiting request of the class
+// with the smallest pass, the caller must hold the lock.
+func (wp *workerPool) admitNext() {
+	var next *priorityClass
+	for _, class := range wp.classes {
+		if len(class.queue) == 0 {
+			continue
+		}
+		if next == nil || class.pass < next.pass {
+			next = class
+		}
+	}
+
+	ch := next.queue[0]
+	next.queue[0] = nil
+	next.queue = next.queue[1:]
+	wp.pass = next.pass
+	next.pass += next.stride
+	wp.queued--
+	wp.busy++
+	close(ch)
+}
+
+// acquire gets a worker for the request of the priority class, it waits
+// in the queue if all workers are busy, until a worker is available, the
+// queue timeout, or done is closed. release must be called after a
+// successful acquiring.
+func (wp *workerPool) acquire(className string, done <-chan struct{}) error {
+	wp.mutex.Lock()
+	if wp.maxWorkers == 0 || wp.busy < wp.maxWorkers {
+		wp.busy++
+		wp.mutex.Unlock()
+		return nil
+	}
+	if wp.queued >= wp.maxQueueLength {
+		wp.rejected++
+		wp.mutex.Unlock()
+		return errQueueFull
+	}
+	ch := make(chan struct{})
+	class := wp.getClass(className)
+	if len(class.queue) == 0 && class.pass < wp.pass {
+		class.pass = wp.pass
+	}
+	class.queue = append(class.queue, ch)
+	wp.queued++
+	timeout := wp.queueTimeout
+	wp.mutex.Unlock()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+		err = errQueueTimeout
+	case <-done:
+		err = errCanceled
+	}
+
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	// NOTE: The worker may be handed over after the timeout or the
+	// cancellation, but before the lock is held.
+	select {
+	case <-ch:
+		return nil
+	default:
+	}
+
+	// NOTE: The request may have been moved to another class by reload.
+	wp.removeWaiting(ch)
+	if err == errQueueTimeout {
+		wp.timedOut++
+	} else {
+		wp.canceled++
+	}
+	return err
+}
+
+// removeWaiting removes the waiting request from its queue, the caller
+// must hold the lock.
+func (wp *workerPool) removeWaiting(ch chan struct{}) {
+	for _, class := range wp.classes {
+		for i, c := range class.queue {
+			if c == ch {
+				class.queue = append(class.queue[:i], class.queue[i+1:]...)
+				wp.queued--
+				return
+			}
+		}
+	}
+}
+
+// release returns the worker, which is handed over to the next waiting
+// request if there is one.
+func (wp *workerPool) release() {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	wp.busy--
+	if wp.queued > 0 && (wp.maxWorkers == 0 || wp.busy < wp.maxWorkers) {
+		wp.admitNext()
+	}
+}
+
+func (wp *workerPool) status() *WorkerPoolStatus {
+	wp.mutex.Lock()
+	defer wp.mutex.Unlock()
+
+	if wp.maxWorkers == 0 {
+		return nil
+	}
+
+	s := &WorkerPoolStatus{
+		MaxWorkers: wp.maxWorkers,
+		Busy:       wp.busy,
+		Queued:     wp.queued,
+		Rejected:   wp.rejected,
+		TimedOut:   wp.timedOut,
+		Canceled:   wp.canceled,
+	}
+	if len(wp.classes) > 1 {
+		s.Classes = make(map[string]int, len(wp.classes))
+		for _, class := range wp.classes {
+			s.Classes[class.name] = len(class.queue)
+		}
+	}
+
+	return s
+}
+
+// runHandler runs the handler with a worker of the pool if it's enabled,
+// the request waits in the queue of the priority class of the path.
+func (m *mux) runHandler(rules *muxRules, ctx context.HTTPContext, path *muxPath, handler protocol.HTTPHandler) {
+	if rules.spec.WorkerPool != nil {
+		// NOTE: ctx is done if the client is gone or the request exceeds
+		// the total timeout of its route.
+		if err := m.workerPool.acquire(path.priorityClass, ctx.Done()); err != nil {
+			m.handleWorkerPoolError(ctx, err)
+			return
+		}
+		defer m.workerPool.release()
+	}
+
+	// global filter
+	if globalFilter := m.getGlobalFilter(rules); globalFilter != nil {
+		globalFilter.Handle(ctx, handler)
+	} else {
+		handler.Handle(ctx)
+	}
+}
+
+func (m *mux) handleWorkerPoolError(ctx context.HTTPContext, err error) {
+	ctx.AddTag(stringtool.Cat("rejected: ", err.Error()))
+	ctx.Response().SetStatusCode(http.StatusServiceUnavailable)
+}
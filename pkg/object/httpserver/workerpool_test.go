@@ -0,0 +1,164 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpserver
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWorkerPoolSpecValidate(t *testing.T) {
+	if err := (&WorkerPoolSpec{MaxWorkers: 1, QueueTimeout: "100ms"}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, spec := range []*WorkerPoolSpec{
+		{},
+		{MaxWorkers: 1, QueueTimeout: "abc"},
+		{MaxWorkers: 1, QueueTimeout: "-1s"},
+		{MaxWorkers: 1, Classes: []*PriorityClassSpec{{Name: "", Weight: 1}}},
+		{MaxWorkers: 1, Classes: []*PriorityClassSpec{{Name: "a", Weight: 0}}},
+		{MaxWorkers: 1, Classes: []*PriorityClassSpec{{Name: "a", Weight: strideOne + 1}}},
+		{MaxWorkers: 1, Classes: []*PriorityClassSpec{{Name: "a", Weight: 1}, {Name: "a", Weight: 2}}},
+	} {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expect error for %+v", spec)
+		}
+	}
+}
+
+func TestWorkerPool(t *testing.T) {
+	wp := newWorkerPool()
+	if wp.status() != nil {
+		t.Errorf("status of disabled pool should be nil")
+	}
+	wp.reload(&WorkerPoolSpec{MaxWorkers: 1, MaxQueueLength: 1, QueueTimeout: "50ms"})
+
+	if err := wp.acquire("", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The queued request gets the worker once it's released.
+	acquired := make(chan error)
+	go func() {
+		acquired <- wp.acquire("", nil)
+	}()
+	for wp.status().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full.
+	if err := wp.acquire("", nil); err != errQueueFull {
+		t.Errorf("expect queue full, got %v", err)
+	}
+
+	wp.release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Timeout.
+	if err := wp.acquire("", nil); err != errQueueTimeout {
+		t.Errorf("expect queue timeout, got %v", err)
+	}
+
+	// Canceled.
+	done := make(chan struct{})
+	close(done)
+	if err := wp.acquire("", done); err != errCanceled {
+		t.Errorf("expect canceled, got %v", err)
+	}
+
+	status := wp.status()
+	if status.Busy != 1 || status.Queued != 0 || status.Rejected != 1 || status.TimedOut != 1 || status.Canceled != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	// Enlarging the pool admits the waiting requests.
+	go func() {
+		acquired <- wp.acquire("", nil)
+	}()
+	for wp.status().Queued != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	wp.reload(&WorkerPoolSpec{MaxWorkers: 2, MaxQueueLength: 1, QueueTimeout: "50ms"})
+	if err := <-acquired; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if status := wp.status(); status.Busy != 2 {
+		t.Errorf("expect 2 busy workers, got %d", status.Busy)
+	}
+
+	wp.release()
+	wp.release()
+	wp.reload(nil)
+	if wp.status() != nil {
+		t.Errorf("status of disabled pool should be nil")
+	}
+}
+
+func TestWorkerPoolPriorityClasses(t *testing.T) {
+	wp := newWorkerPool()
+	wp.reload(&WorkerPoolSpec{
+		MaxWorkers:     1,
+		MaxQueueLength: 100,
+		QueueTimeout:   "10s",
+		Classes: []*PriorityClassSpec{
+			{Name: "bulk", Weight: 1},
+			{Name: "high", Weight: 3},
+		},
+	})
+
+	if err := wp.acquire("high", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	admitted := make(chan string)
+	for i := 0; i < 4; i++ {
+		for _, class := range []string{"bulk", "high"} {
+			class := class
+			go func() {
+				if err := wp.acquire(class, nil); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				admitted <- class
+			}()
+		}
+	}
+	for wp.status().Queued != 8 {
+		time.Sleep(time.Millisecond)
+	}
+	if classes := wp.status().Classes; classes["bulk"] != 4 || classes["high"] != 4 || classes["default"] != 0 {
+		t.Errorf("unexpected classes: %v", classes)
+	}
+
+	var order []string
+	for i := 0; i < 8; i++ {
+		wp.release()
+		order = append(order, <-admitted)
+	}
+	wp.release()
+
+	// The weight of high is 3 times of bulk, so it gets 3 workers for
+	// every worker of bulk until its queue is drained.
+	expected := []string{"bulk", "high", "high", "high", "high", "bulk", "bulk", "bulk"}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("expect order %v, got %v", expected, order)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sessionstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const memoryCleanupInterval = time.Minute
+
+type (
+	// memoryBackend stores sessions in the memory of the instance.
+	memoryBackend struct {
+		maxSessions int
+
+		mutex    sync.Mutex
+		sessions map[string]*memorySession
+
+		closeOnce sync.Once
+		done      chan struct{}
+	}
+
+	memorySession struct {
+		data     map[string]string
+		expireAt time.Time
+	}
+)
+
+func newMemoryBackend(spec *MemorySpec) *memoryBackend {
+	mb := &memoryBackend{
+		sessions: map[string]*memorySession{},
+		done:     make(chan struct{}),
+	}
+	if spec != nil {
+		mb.maxSessions = int(spec.MaxSessions)
+	}
+	go mb.run()
+	return mb
+}
+
+func (mb *memoryBackend) run() {
+	ticker := time.NewTicker(memoryCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-mb.done:
+			return
+		case now := <-ticker.C:
+			mb.mutex.Lock()
+			mb.removeExpired(now)
+			mb.mutex.Unlock()
+		}
+	}
+}
+
+// removeExpired removes expired sessions, the caller must hold the lock.
+func (mb *memoryBackend) removeExpired(now time.Time) {
+	for id, s := range mb.sessions {
+		if now.After(s.expireAt) {
+			delete(mb.sessions, id)
+		}
+	}
+}
+
+func copyData(data map[string]string) map[string]string {
+	c := make(map[string]string, len(data))
+	for k, v := range data {
+		c[k] = v
+	}
+	return c
+}
+
+func (mb *memoryBackend) load(id string) (map[string]string, error) {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	s := mb.sessions[id]
+	if s == nil {
+		return nil, nil
+	}
+	if time.Now().After(s.expireAt) {
+		delete(mb.sessions, id)
+		return nil, nil
+	}
+	return copyData(s.data), nil
+}
+
+func (mb *memoryBackend) save(id string, data map[string]string, ttl time.Duration) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	now := time.Now()
+	if _, exists := mb.sessions[id]; !exists && mb.maxSessions > 0 && len(mb.sessions) >= mb.maxSessions {
+		mb.removeExpired(now)
+		if len(mb.sessions) >= mb.maxSessions {
+			return fmt.Errorf("too many sessions (max %d)", mb.maxSessions)
+		}
+	}
+
+	mb.sessions[id] = &memorySession{data: copyData(data), expireAt: now.Add(ttl)}
+	return nil
+}
+
+func (mb *memoryBackend) touch(id string, ttl time.Duration) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	if s := mb.sessions[id]; s != nil {
+		s.expireAt = time.Now().Add(ttl)
+	}
+	return nil
+}
+
+func (mb *memoryBackend) delete(id string) error {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+
+	delete(mb.sessions, id)
+	return nil
+}
+
+func (mb *memoryBackend) len() int {
+	mb.mutex.Lock()
+	defer mb.mutex.Unlock()
+	return len(mb.sessions)
+}
+
+func (mb *memoryBackend) close() {
+	mb.closeOnce.Do(func() { close(mb.done) })
+}
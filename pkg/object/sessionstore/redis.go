@@ -0,0 +1,293 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sessionstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRedisKeyPrefix = "easegress:session:"
+	defaultRedisTimeout   = 3 * time.Second
+	maxIdleRedisConns     = 16
+)
+
+type (
+	// RedisSpec describes the Redis backend.
+	RedisSpec struct {
+		Address  string `yaml:"address" jsonschema:"required"`
+		Username string `yaml:"username" jsonschema:"omitempty"`
+		Password string `yaml:"password" jsonschema:"omitempty"`
+		DB       int    `yaml:"db" jsonschema:"omitempty,minimum=0"`
+		// KeyPrefix is the prefix of the keys of sessions, the default
+		// is easegress:session:.
+		KeyPrefix string `yaml:"keyPrefix" jsonschema:"omitempty"`
+		// Timeout is the timeout of dialing and every command, the
+		// default is 3s.
+		Timeout string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// redisBackend stores sessions in Redis, it speaks the RESP protocol
+	// with the few commands it needs.
+	redisBackend struct {
+		spec      *RedisSpec
+		keyPrefix string
+		timeout   time.Duration
+
+		mutex  sync.Mutex
+		idle   []*redisConn
+		closed bool
+	}
+
+	redisConn struct {
+		net.Conn
+		r *bufio.Reader
+	}
+
+	// redisError is the error replied by Redis.
+	redisError string
+)
+
+// Validate validates RedisSpec.
+func (spec *RedisSpec) Validate() error {
+	if _, _, err := net.SplitHostPort(spec.Address); err != nil {
+		return fmt.Errorf("invalid address %s: %v", spec.Address, err)
+	}
+	if spec.Timeout != "" {
+		if _, err := time.ParseDuration(spec.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %s: %v", spec.Timeout, err)
+		}
+	}
+	return nil
+}
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+func newRedisBackend(spec *RedisSpec) *redisBackend {
+	rb := &redisBackend{
+		spec:      spec,
+		keyPrefix: spec.KeyPrefix,
+		timeout:   defaultRedisTimeout,
+	}
+	if rb.keyPrefix == "" {
+		rb.keyPrefix = defaultRedisKeyPrefix
+	}
+	if d, err := time.ParseDuration(spec.Timeout); err == nil && d > 0 {
+		rb.timeout = d
+	}
+	return rb
+}
+
+func (rb *redisBackend) key(id string) string {
+	return rb.keyPrefix + id
+}
+
+func (rb *redisBackend) load(id string) (map[string]string, error) {
+	reply, err := rb.do("GET", rb.key(id))
+	if err != nil || reply == nil {
+		return nil, err
+	}
+
+	value, ok := reply.(string)
+	if !ok {
+		return nil, fmt.Errorf("unexpected reply %v", reply)
+	}
+	data := map[string]string{}
+	if err := json.Unmarshal([]byte(value), &data); err != nil {
+		return nil, fmt.Errorf("unmarshal session failed: %v", err)
+	}
+	return data, nil
+}
+
+func (rb *redisBackend) save(id string, data map[string]string, ttl time.Duration) error {
+	value, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = rb.do("SET", rb.key(id), string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (rb *redisBackend) touch(id string, ttl time.Duration) error {
+	_, err := rb.do("PEXPIRE", rb.key(id), strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+func (rb *redisBackend) delete(id string) error {
+	_, err := rb.do("DEL", rb.key(id))
+	return err
+}
+
+// do sends the command and returns the reply, the reply of nil bulk
+// strings is nil.
+func (rb *redisBackend) do(args ...string) (interface{}, error) {
+	conn, err := rb.get()
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := conn.do(rb.timeout, args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		// NOTE: The connection is broken by errors other than the ones
+		// replied by Redis.
+		conn.Close()
+		return nil, err
+	}
+
+	rb.put(conn)
+	return reply, err
+}
+
+func (rb *redisBackend) get() (*redisConn, error) {
+	rb.mutex.Lock()
+	if rb.closed {
+		rb.mutex.Unlock()
+		return nil, fmt.Errorf("redis backend closed")
+	}
+	if n := len(rb.idle); n > 0 {
+		conn := rb.idle[n-1]
+		rb.idle = rb.idle[:n-1]
+		rb.mutex.Unlock()
+		return conn, nil
+	}
+	rb.mutex.Unlock()
+
+	c, err := net.DialTimeout("tcp", rb.spec.Address, rb.timeout)
+	if err != nil {
+		return nil, err
+	}
+	conn := &redisConn{Conn: c, r: bufio.NewReader(c)}
+
+	if rb.spec.Password != "" {
+		args := []string{"AUTH", rb.spec.Password}
+		if rb.spec.Username != "" {
+			args = []string{"AUTH", rb.spec.Username, rb.spec.Password}
+		}
+		if _, err := conn.do(rb.timeout, args...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if rb.spec.DB != 0 {
+		if _, err := conn.do(rb.timeout, "SELECT", strconv.Itoa(rb.spec.DB)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (rb *redisBackend) put(conn *redisConn) {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	if rb.closed || len(rb.idle) >= maxIdleRedisConns {
+		conn.Close()
+		return
+	}
+	rb.idle = append(rb.idle, conn)
+}
+
+func (rb *redisBackend) close() {
+	rb.mutex.Lock()
+	defer rb.mutex.Unlock()
+
+	rb.closed = true
+	for _, conn := range rb.idle {
+		conn.Close()
+	}
+	rb.idle = nil
+}
+
+func (c *redisConn) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.SetDeadline(time.Now().Add(timeout))
+
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	if _, err := io.WriteString(c.Conn, sb.String()); err != nil {
+		return nil, err
+	}
+
+	return readReply(c.r)
+}
+
+// readReply reads a RESP reply, simple strings and bulk strings are
+// returned as strings, integers as int64, arrays as []interface{}.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("invalid reply %q", line)
+	}
+	typ, line := line[0], line[1:len(line)-2]
+
+	switch typ {
+	case '+':
+		return line, nil
+	case '-':
+		return nil, redisError(line)
+	case ':':
+		return strconv.ParseInt(line, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("invalid reply %q", string(typ)+line)
+	}
+}
@@ -0,0 +1,294 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sessionstore
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of SessionStore.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of SessionStore.
+	Kind = "SessionStore"
+
+	defaultTTL = 30 * time.Minute
+
+	backendMemory = "memory"
+	backendRedis  = "redis"
+
+	// idBytes is the number of random bytes of session IDs.
+	idBytes = 32
+)
+
+func init() {
+	supervisor.Register(&SessionStore{})
+}
+
+// stores contains the running SessionStores by names, for the filters.
+var stores = struct {
+	sync.RWMutex
+	m map[string]*SessionStore
+}{m: map[string]*SessionStore{}}
+
+type (
+	// SessionStore stores the server-side sessions of clients, which are
+	// issued and validated by the Session filter, so stateless backends
+	// could rely on the sessions managed by the gateway.
+	SessionStore struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		ttl       time.Duration
+		backend   backend
+
+		loads  uint64
+		saves  uint64
+		errors uint64
+	}
+
+	// Spec describes the SessionStore.
+	Spec struct {
+		// TTL is the idle timeout of sessions, which is extended on every
+		// access, the default is 30m.
+		TTL string `yaml:"ttl" jsonschema:"omitempty,format=duration"`
+		// Memory stores sessions in the memory of the instance, it's the
+		// default if Redis is absent.
+		Memory *MemorySpec `yaml:"memory,omitempty" jsonschema:"omitempty"`
+		// Redis stores sessions in Redis, which are shared by instances.
+		Redis *RedisSpec `yaml:"redis,omitempty" jsonschema:"omitempty"`
+	}
+
+	// MemorySpec describes the memory backend.
+	MemorySpec struct {
+		// MaxSessions is the max number of sessions, new sessions beyond
+		// it are not saved, zero means no limit.
+		MaxSessions uint32 `yaml:"maxSessions" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of SessionStore.
+	Status struct {
+		Health  string `yaml:"health"`
+		Backend string `yaml:"backend"`
+		// Sessions is the number of sessions, only for memory.
+		Sessions int    `yaml:"sessions,omitempty"`
+		Loads    uint64 `yaml:"loads"`
+		Saves    uint64 `yaml:"saves"`
+		Errors   uint64 `yaml:"errors"`
+	}
+
+	backend interface {
+		// load returns nil data if the session doesn't exist.
+		load(id string) (map[string]string, error)
+		save(id string, data map[string]string, ttl time.Duration) error
+		touch(id string, ttl time.Duration) error
+		delete(id string) error
+		close()
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.TTL != "" {
+		d, err := time.ParseDuration(spec.TTL)
+		if err != nil {
+			return fmt.Errorf("invalid ttl %s: %v", spec.TTL, err)
+		}
+		if d < time.Second {
+			return fmt.Errorf("ttl must be at least 1s")
+		}
+	}
+
+	if spec.Memory != nil && spec.Redis != nil {
+		return fmt.Errorf("memory and redis are exclusive")
+	}
+	if spec.Redis != nil {
+		if err := spec.Redis.Validate(); err != nil {
+			return fmt.Errorf("redis: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (spec *Spec) ttl() time.Duration {
+	if spec.TTL == "" {
+		return defaultTTL
+	}
+	d, err := time.ParseDuration(spec.TTL)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", spec.TTL, err)
+		return defaultTTL
+	}
+	return d
+}
+
+func (spec *Spec) backendName() string {
+	if spec.Redis != nil {
+		return backendRedis
+	}
+	return backendMemory
+}
+
+// Get returns the running SessionStore of the name, it's nil if absent.
+func Get(name string) *SessionStore {
+	stores.RLock()
+	defer stores.RUnlock()
+	return stores.m[name]
+}
+
+// NewID generates a random opaque session ID.
+func NewID() (string, error) {
+	b := make([]byte, idBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Category returns the category of SessionStore.
+func (ss *SessionStore) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of SessionStore.
+func (ss *SessionStore) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SessionStore.
+func (ss *SessionStore) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Init initializes SessionStore.
+func (ss *SessionStore) Init(superSpec *supervisor.Spec) {
+	ss.superSpec, ss.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	ss.reload(nil)
+}
+
+// Inherit inherits previous generation of SessionStore.
+func (ss *SessionStore) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	ss.superSpec, ss.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+
+	// NOTE: The backend is inherited if it's not changed, so the sessions
+	// in memory survive the updates of other fields, e.g. the TTL.
+	previous := previousGeneration.(*SessionStore)
+	if reflect.DeepEqual(previous.spec.Memory, ss.spec.Memory) &&
+		reflect.DeepEqual(previous.spec.Redis, ss.spec.Redis) {
+		ss.reload(previous.backend)
+		return
+	}
+
+	ss.reload(nil)
+	previous.Close()
+}
+
+func (ss *SessionStore) reload(b backend) {
+	ss.ttl = ss.spec.ttl()
+	if b != nil {
+		ss.backend = b
+	} else if ss.spec.Redis != nil {
+		ss.backend = newRedisBackend(ss.spec.Redis)
+	} else {
+		ss.backend = newMemoryBackend(ss.spec.Memory)
+	}
+
+	stores.Lock()
+	stores.m[ss.superSpec.Name()] = ss
+	stores.Unlock()
+}
+
+// Load loads the data of the session, it returns nil data if the session
+// doesn't exist or has expired.
+func (ss *SessionStore) Load(id string) (map[string]string, error) {
+	atomic.AddUint64(&ss.loads, 1)
+	data, err := ss.backend.load(id)
+	if err != nil {
+		atomic.AddUint64(&ss.errors, 1)
+	}
+	return data, err
+}
+
+// Save saves the data of the session, and extends its expiration.
+func (ss *SessionStore) Save(id string, data map[string]string) error {
+	atomic.AddUint64(&ss.saves, 1)
+	err := ss.backend.save(id, data, ss.ttl)
+	if err != nil {
+		atomic.AddUint64(&ss.errors, 1)
+	}
+	return err
+}
+
+// Touch extends the expiration of the session.
+func (ss *SessionStore) Touch(id string) error {
+	err := ss.backend.touch(id, ss.ttl)
+	if err != nil {
+		atomic.AddUint64(&ss.errors, 1)
+	}
+	return err
+}
+
+// Delete deletes the session.
+func (ss *SessionStore) Delete(id string) error {
+	err := ss.backend.delete(id)
+	if err != nil {
+		atomic.AddUint64(&ss.errors, 1)
+	}
+	return err
+}
+
+// TTL returns the idle timeout of sessions.
+func (ss *SessionStore) TTL() time.Duration {
+	return ss.ttl
+}
+
+// Status returns the status of SessionStore.
+func (ss *SessionStore) Status() *supervisor.Status {
+	s := &Status{
+		Health:  "ready",
+		Backend: ss.spec.backendName(),
+		Loads:   atomic.LoadUint64(&ss.loads),
+		Saves:   atomic.LoadUint64(&ss.saves),
+		Errors:  atomic.LoadUint64(&ss.errors),
+	}
+	if mb, ok := ss.backend.(*memoryBackend); ok {
+		s.Sessions = mb.len()
+	}
+	return &supervisor.Status{ObjectStatus: s}
+}
+
+// Close closes SessionStore.
+func (ss *SessionStore) Close() {
+	stores.Lock()
+	if stores.m[ss.superSpec.Name()] == ss {
+		delete(stores.m, ss.superSpec.Name())
+	}
+	stores.Unlock()
+
+	ss.backend.close()
+}
@@ -0,0 +1,280 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sessionstore
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestSpecValidate(t *testing.T) {
+	cases := []struct {
+		spec  Spec
+		valid bool
+	}{
+		{Spec{}, true},
+		{Spec{TTL: "1h", Memory: &MemorySpec{MaxSessions: 10}}, true},
+		{Spec{TTL: "1ms"}, false},
+		{Spec{TTL: "x"}, false},
+		{Spec{Redis: &RedisSpec{Address: "127.0.0.1:6379"}}, true},
+		{Spec{Redis: &RedisSpec{Address: "127.0.0.1"}}, false},
+		{Spec{Memory: &MemorySpec{}, Redis: &RedisSpec{Address: "127.0.0.1:6379"}}, false},
+	}
+
+	for i, c := range cases {
+		err := c.spec.Validate()
+		if c.valid && err != nil {
+			t.Errorf("case %d: unexpected error: %v", i, err)
+		}
+		if !c.valid && err == nil {
+			t.Errorf("case %d: should be invalid", i)
+		}
+	}
+}
+
+func TestNewID(t *testing.T) {
+	a, err := NewID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := NewID()
+	if a == b || len(a) != 43 {
+		t.Errorf("IDs should be random and of 43 characters: %s %s", a, b)
+	}
+}
+
+func testBackend(t *testing.T, b backend) {
+	data := map[string]string{"user": "alice"}
+	if err := b.save("a", data, time.Hour); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	data["user"] = "bob"
+
+	got, err := b.load("a")
+	if err != nil || !reflect.DeepEqual(got, map[string]string{"user": "alice"}) {
+		t.Errorf("loaded data should be saved one, but is %v: %v", got, err)
+	}
+	if got, err := b.load("unknown"); got != nil || err != nil {
+		t.Errorf("unknown session should be nil: %v %v", got, err)
+	}
+
+	if err := b.save("b", data, 50*time.Millisecond); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if err := b.touch("b", time.Hour); err != nil {
+		t.Fatalf("touch failed: %v", err)
+	}
+	if err := b.save("c", data, 50*time.Millisecond); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got, _ := b.load("b"); got == nil {
+		t.Errorf("touched session should not expire")
+	}
+	if got, _ := b.load("c"); got != nil {
+		t.Errorf("session should expire")
+	}
+
+	if err := b.delete("a"); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if got, _ := b.load("a"); got != nil {
+		t.Errorf("deleted session should be nil")
+	}
+}
+
+func TestMemoryBackend(t *testing.T) {
+	mb := newMemoryBackend(nil)
+	defer mb.close()
+	testBackend(t, mb)
+
+	mb = newMemoryBackend(&MemorySpec{MaxSessions: 1})
+	defer mb.close()
+	if err := mb.save("a", nil, 50*time.Millisecond); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if err := mb.save("b", nil, time.Hour); err == nil {
+		t.Errorf("save beyond max sessions should fail")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if err := mb.save("b", nil, time.Hour); err != nil {
+		t.Errorf("expired session should be removed for new ones: %v", err)
+	}
+	if mb.len() != 1 {
+		t.Errorf("there should be 1 session, but %d", mb.len())
+	}
+}
+
+// fakeRedis serves the commands used by the redis backend.
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mutex  sync.Mutex
+	values map[string]string
+	expiry map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := &fakeRedis{listener: l, password: password, values: map[string]string{}, expiry: map[string]time.Time{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go fr.serve(conn)
+		}
+	}()
+	return fr
+}
+
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := fr.password == ""
+
+	for {
+		reply, err := readReply(r)
+		if err != nil {
+			return
+		}
+		items := reply.([]interface{})
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i] = item.(string)
+		}
+
+		var resp string
+		fr.mutex.Lock()
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authed = args[len(args)-1] == fr.password
+			resp = "+OK\r\n"
+			if !authed {
+				resp = "-WRONGPASS invalid password\r\n"
+			}
+		case !authed:
+			resp = "-NOAUTH Authentication required\r\n"
+		case cmd == "SELECT":
+			resp = "+OK\r\n"
+		case cmd == "GET":
+			if v, ok := fr.values[args[1]]; ok && !fr.expired(args[1]) {
+				resp = fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+			} else {
+				resp = "$-1\r\n"
+			}
+		case cmd == "SET":
+			fr.values[args[1]] = args[2]
+			fr.expire(args[1], args[4])
+			resp = "+OK\r\n"
+		case cmd == "PEXPIRE":
+			fr.expire(args[1], args[2])
+			resp = ":1\r\n"
+		case cmd == "DEL":
+			delete(fr.values, args[1])
+			resp = ":1\r\n"
+		default:
+			resp = "-ERR unknown command\r\n"
+		}
+		fr.mutex.Unlock()
+
+		conn.Write([]byte(resp))
+	}
+}
+
+func (fr *fakeRedis) expire(key, ms string) {
+	d, _ := time.ParseDuration(ms + "ms")
+	fr.expiry[key] = time.Now().Add(d)
+}
+
+func (fr *fakeRedis) expired(key string) bool {
+	deadline, ok := fr.expiry[key]
+	return ok && time.Now().After(deadline)
+}
+
+func TestRedisBackend(t *testing.T) {
+	fr := newFakeRedis(t, "secret")
+	defer fr.listener.Close()
+
+	rb := newRedisBackend(&RedisSpec{Address: fr.listener.Addr().String(), Password: "secret", DB: 1})
+	testBackend(t, rb)
+	fr.mutex.Lock()
+	_, ok := fr.values[defaultRedisKeyPrefix+"b"]
+	fr.mutex.Unlock()
+	if !ok {
+		t.Errorf("keys should have the prefix")
+	}
+	if len(rb.idle) == 0 {
+		t.Errorf("connections should be reused")
+	}
+	rb.close()
+	if err := rb.delete("b"); err == nil {
+		t.Errorf("closed backend should fail")
+	}
+
+	rb = newRedisBackend(&RedisSpec{Address: fr.listener.Addr().String(), Password: "wrong"})
+	defer rb.close()
+	if _, err := rb.load("a"); err == nil {
+		t.Errorf("wrong password should fail")
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	if Get("sessions") != nil {
+		t.Fatalf("store should be absent")
+	}
+
+	ss := &SessionStore{spec: &Spec{}, ttl: defaultTTL, backend: newMemoryBackend(nil)}
+	stores.m["sessions"] = ss
+	if Get("sessions") != ss {
+		t.Errorf("store should be got")
+	}
+
+	if err := ss.Save("a", map[string]string{"k": "v"}); err != nil {
+		t.Fatalf("save failed: %v", err)
+	}
+	if data, _ := ss.Load("a"); data["k"] != "v" {
+		t.Errorf("unexpected data %v", data)
+	}
+	if ss.loads != 1 || ss.saves != 1 {
+		t.Errorf("unexpected counters %d %d", ss.loads, ss.saves)
+	}
+
+	delete(stores.m, "sessions")
+	ss.backend.close()
+}
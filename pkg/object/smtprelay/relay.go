@@ -0,0 +1,275 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smtprelay
+
+import (
+	"crypto/tls"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/ratelimiter"
+	"github.com/megaease/easegress/pkg/util/sampler"
+)
+
+const (
+	// maxSenderLimiters is the max number of senders having their own
+	// rate limiters, the rest share a rate limiter.
+	maxSenderLimiters = 10000
+	otherSenders      = "~other"
+)
+
+type (
+	// relay checks and relays messages to the upstream mail server.
+	relay struct {
+		spec    *Spec
+		host    string
+		timeout time.Duration
+
+		recipients map[string]struct{}
+		domains    map[string]struct{}
+
+		limiterPolicy *ratelimiter.Policy
+		limiter       *ratelimiter.RateLimiter
+		limiters      map[string]*ratelimiter.RateLimiter
+
+		mutex              sync.Mutex
+		rateLimited        uint64
+		rejectedRecipients uint64
+		delivered          uint64
+		failed             uint64
+		latencyTotal       time.Duration
+		latencyMax         time.Duration
+		sampler            *sampler.DurationSampler
+	}
+
+	// DeliveryStatus contains the statistics of messages.
+	DeliveryStatus struct {
+		RateLimited        uint64 `yaml:"rateLimited"`
+		RejectedRecipients uint64 `yaml:"rejectedRecipients"`
+		Delivered          uint64 `yaml:"delivered"`
+		DeliveryFailures   uint64 `yaml:"deliveryFailures"`
+
+		// Latency contains the statistics of delivery latencies, in
+		// milliseconds.
+		Latency *LatencyStatus `yaml:"latency,omitempty"`
+	}
+
+	// LatencyStatus contains the statistics of latencies, in milliseconds.
+	LatencyStatus struct {
+		Mean float64 `yaml:"mean"`
+		Max  float64 `yaml:"max"`
+		P50  float64 `yaml:"p50"`
+		P95  float64 `yaml:"p95"`
+		P99  float64 `yaml:"p99"`
+	}
+)
+
+func newRelay(spec *Spec) *relay {
+	r := &relay{
+		spec:       spec,
+		timeout:    parseDuration(spec.Upstream.Timeout, defaultUpstreamTimeout),
+		recipients: map[string]struct{}{},
+		domains:    map[string]struct{}{},
+		sampler:    sampler.NewDurationSampler(),
+	}
+	r.host, _, _ = net.SplitHostPort(spec.Upstream.Addr)
+
+	for _, recipient := range spec.AllowedRecipients {
+		recipient = strings.ToLower(recipient)
+		if strings.HasPrefix(recipient, "@") {
+			r.domains[recipient[1:]] = struct{}{}
+		} else {
+			r.recipients[recipient] = struct{}{}
+		}
+	}
+
+	if rl := spec.RateLimit; rl != nil {
+		period := parseDuration(rl.Period, defaultRateLimitPeriod)
+		r.limiterPolicy = ratelimiter.NewPolicy(0, period, int(rl.Limit))
+		if rl.PerSender {
+			r.limiters = map[string]*ratelimiter.RateLimiter{}
+		} else {
+			r.limiter = ratelimiter.New(r.limiterPolicy)
+		}
+	}
+
+	return r
+}
+
+// allowRecipient returns whether the recipient is in the allowlist.
+func (r *relay) allowRecipient(recipient string) bool {
+	recipient = strings.ToLower(recipient)
+	if _, ok := r.recipients[recipient]; ok {
+		return true
+	}
+	if i := strings.LastIndexByte(recipient, '@'); i >= 0 {
+		if _, ok := r.domains[recipient[i+1:]]; ok {
+			return true
+		}
+	}
+
+	r.mutex.Lock()
+	r.rejectedRecipients++
+	r.mutex.Unlock()
+	return false
+}
+
+// allowMessage returns whether a message from the sender is allowed by
+// the rate limit.
+func (r *relay) allowMessage(sender string) bool {
+	if r.limiterPolicy == nil {
+		return true
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	limiter := r.limiter
+	if limiter == nil {
+		sender = strings.ToLower(sender)
+		limiter = r.limiters[sender]
+		if limiter == nil {
+			if len(r.limiters) >= maxSenderLimiters {
+				sender = otherSenders
+				limiter = r.limiters[sender]
+			}
+			if limiter == nil {
+				limiter = ratelimiter.New(r.limiterPolicy)
+				r.limiters[sender] = limiter
+			}
+		}
+	}
+
+	if permitted, _ := limiter.AcquirePermission(); !permitted {
+		r.rateLimited++
+		return false
+	}
+	return true
+}
+
+// deliver relays the message to the upstream mail server.
+func (r *relay) deliver(hostname, from string, to []string, msg []byte) error {
+	start := time.Now()
+	err := r.send(hostname, from, to, msg)
+	latency := time.Since(start)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if err != nil {
+		r.failed++
+		return err
+	}
+
+	r.delivered++
+	r.latencyTotal += latency
+	if latency > r.latencyMax {
+		r.latencyMax = latency
+	}
+	r.sampler.Update(latency)
+	return nil
+}
+
+func (r *relay) send(hostname, from string, to []string, msg []byte) error {
+	upstream := r.spec.Upstream
+	dialer := &net.Dialer{Timeout: r.timeout}
+
+	var conn net.Conn
+	var err error
+	if upstream.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", upstream.Addr, &tls.Config{ServerName: r.host})
+	} else {
+		conn, err = dialer.Dial("tcp", upstream.Addr)
+	}
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(r.timeout))
+
+	c, err := smtp.NewClient(conn, r.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if err = c.Hello(hostname); err != nil {
+		return err
+	}
+	if !upstream.TLS {
+		if ok, _ := c.Extension("STARTTLS"); ok {
+			if err = c.StartTLS(&tls.Config{ServerName: r.host}); err != nil {
+				return err
+			}
+		}
+	}
+	if upstream.Username != "" {
+		if err = c.Auth(smtp.PlainAuth("", upstream.Username, upstream.Password, r.host)); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(from); err != nil {
+		return err
+	}
+	for _, addr := range to {
+		if err = c.Rcpt(addr); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
+
+func (r *relay) status() *DeliveryStatus {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s := &DeliveryStatus{
+		RateLimited:        r.rateLimited,
+		RejectedRecipients: r.rejectedRecipients,
+		Delivered:          r.delivered,
+		DeliveryFailures:   r.failed,
+	}
+	if r.delivered != 0 {
+		percentiles := r.sampler.Percentiles()
+		s.Latency = &LatencyStatus{
+			Mean: float64(r.latencyTotal/time.Duration(r.delivered)) / float64(time.Millisecond),
+			Max:  float64(r.latencyMax) / float64(time.Millisecond),
+			P50:  percentiles[1],
+			P95:  percentiles[3],
+			P99:  percentiles[5],
+		}
+	}
+
+	return s
+}
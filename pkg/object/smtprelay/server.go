@@ -0,0 +1,435 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smtprelay
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/limitlistener"
+)
+
+const (
+	stateNil     stateType = "nil"
+	stateRunning stateType = "running"
+	stateFailed  stateType = "failed"
+	stateClosed  stateType = "closed"
+
+	checkFailedTimeout = 10 * time.Second
+)
+
+type (
+	stateType string
+
+	// Server accepts SMTP connections of internal services, and relays
+	// the messages to the upstream mail server.
+	Server struct {
+		name string
+		spec *Spec
+
+		hostname string
+		timeout  time.Duration
+		ipFilter *ipfilter.IPFilter
+		relay    *relay
+
+		mutex    sync.Mutex
+		state    stateType
+		err      error
+		listener *limitlistener.LimitListener
+
+		activeConns   int64
+		totalConns    uint64
+		rejectedConns uint64
+
+		// done is the channel for shutdowning this server.
+		done chan struct{}
+	}
+
+	// Status is the status of SMTPRelay.
+	Status struct {
+		State stateType `yaml:"state"`
+		Error string    `yaml:"error,omitempty"`
+
+		ActiveConnections   int64  `yaml:"activeConnections"`
+		TotalConnections    uint64 `yaml:"totalConnections"`
+		RejectedConnections uint64 `yaml:"rejectedConnections"`
+
+		*DeliveryStatus
+	}
+
+	// session is an SMTP session with a client.
+	session struct {
+		server   *Server
+		conn     net.Conn
+		text     *textproto.Conn
+		clientIP string
+
+		helo    string
+		hasFrom bool
+		from    string
+		to      []string
+	}
+)
+
+func newServer(name string, spec *Spec) *Server {
+	s := &Server{
+		name:     name,
+		spec:     spec,
+		hostname: spec.Hostname,
+		timeout:  parseDuration(spec.Timeout, defaultTimeout),
+		relay:    newRelay(spec),
+		state:    stateNil,
+		done:     make(chan struct{}),
+	}
+
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+
+	if spec.IPFilter != nil {
+		s.ipFilter = ipfilter.New(spec.IPFilter)
+	}
+
+	return s
+}
+
+func (s *Server) run() {
+	for {
+		if s.listen() {
+			return
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(checkFailedTimeout):
+		}
+	}
+}
+
+// listen starts listening and serving, it returns false if it failed to
+// listen and should retry.
+func (s *Server) listen() bool {
+	s.mutex.Lock()
+	select {
+	case <-s.done:
+		s.mutex.Unlock()
+		return true
+	default:
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", s.spec.Port))
+	if err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", s.name, s.spec.Port, err)
+		s.state, s.err = stateFailed, err
+		s.mutex.Unlock()
+		return false
+	}
+
+	s.listener = limitlistener.NewLimitListener(l, s.spec.MaxConnections)
+	s.state, s.err = stateRunning, nil
+	listener := s.listener
+	s.mutex.Unlock()
+
+	s.serve(listener)
+	return true
+}
+
+func (s *Server) serve(l net.Listener) {
+	var tempDelay time.Duration
+
+	for {
+		conn, err := l.Accept()
+		if err == nil {
+			tempDelay = 0
+			go s.handleConn(conn)
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if max := 1 * time.Second; tempDelay > max {
+				tempDelay = max
+			}
+			time.Sleep(tempDelay)
+			continue
+		}
+
+		logger.Errorf("%s accept failed: %v", s.name, err)
+		s.mutex.Lock()
+		s.state, s.err = stateFailed, err
+		s.mutex.Unlock()
+		return
+	}
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	atomic.AddInt64(&s.activeConns, 1)
+	atomic.AddUint64(&s.totalConns, 1)
+	defer atomic.AddInt64(&s.activeConns, -1)
+	defer conn.Close()
+
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if s.ipFilter != nil && !s.ipFilter.Allow(ip) {
+		atomic.AddUint64(&s.rejectedConns, 1)
+		return
+	}
+
+	ss := &session{
+		server:   s,
+		conn:     conn,
+		text:     textproto.NewConn(conn),
+		clientIP: ip,
+	}
+	ss.serve()
+}
+
+func (ss *session) reply(code int, format string, args ...interface{}) error {
+	return ss.text.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (ss *session) reset() {
+	ss.hasFrom, ss.from, ss.to = false, "", nil
+}
+
+// serve serves the commands of the client.
+// Reference: https://tools.ietf.org/html/rfc5321
+func (ss *session) serve() {
+	s := ss.server
+	if ss.reply(220, "%s ESMTP Easegress", s.hostname) != nil {
+		return
+	}
+
+	for {
+		ss.conn.SetDeadline(time.Now().Add(s.timeout))
+		line, err := ss.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			verb, arg = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch strings.ToUpper(verb) {
+		case "HELO":
+			ss.helo = arg
+			ss.reset()
+			err = ss.reply(250, "%s", s.hostname)
+		case "EHLO":
+			ss.helo = arg
+			ss.reset()
+			err = ss.text.PrintfLine("250-%s\r\n250-SIZE %d\r\n250 8BITMIME", s.hostname, s.spec.MaxMessageSize)
+		case "MAIL":
+			err = ss.handleMail(arg)
+		case "RCPT":
+			err = ss.handleRcpt(arg)
+		case "DATA":
+			err = ss.handleData()
+		case "RSET":
+			ss.reset()
+			err = ss.reply(250, "2.0.0 OK")
+		case "NOOP":
+			err = ss.reply(250, "2.0.0 OK")
+		case "VRFY":
+			err = ss.reply(252, "2.5.0 Cannot VRFY user")
+		case "QUIT":
+			ss.reply(221, "2.0.0 Bye")
+			return
+		default:
+			err = ss.reply(502, "5.5.1 Command not implemented")
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parsePath parses the path of MAIL and RCPT, e.g. "FROM:<a@b.com> SIZE=10",
+// it returns the address and the parameters.
+func parsePath(arg, prefix string) (string, map[string]string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", nil, false
+	}
+
+	arg = strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(arg, "<") {
+		return "", nil, false
+	}
+	end := strings.IndexByte(arg, '>')
+	if end < 0 {
+		return "", nil, false
+	}
+
+	params := map[string]string{}
+	for _, param := range strings.Fields(arg[end+1:]) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			params[strings.ToUpper(kv[0])] = kv[1]
+		} else {
+			params[strings.ToUpper(kv[0])] = ""
+		}
+	}
+
+	return arg[1:end], params, true
+}
+
+func (ss *session) handleMail(arg string) error {
+	if ss.helo == "" {
+		return ss.reply(503, "5.5.1 Send HELO or EHLO first")
+	}
+	if ss.hasFrom {
+		return ss.reply(503, "5.5.1 Nested MAIL command")
+	}
+
+	from, params, ok := parsePath(arg, "FROM:")
+	if !ok {
+		return ss.reply(501, "5.5.4 Syntax error in MAIL command")
+	}
+	if size, err := strconv.ParseUint(params["SIZE"], 10, 64); err == nil && size > uint64(ss.server.spec.MaxMessageSize) {
+		return ss.reply(552, "5.3.4 Message size exceeds the limit")
+	}
+
+	if !ss.server.relay.allowMessage(from) {
+		return ss.reply(451, "4.7.1 Rate limit exceeded, try again later")
+	}
+
+	ss.hasFrom, ss.from = true, from
+	return ss.reply(250, "2.1.0 OK")
+}
+
+func (ss *session) handleRcpt(arg string) error {
+	if !ss.hasFrom {
+		return ss.reply(503, "5.5.1 Send MAIL first")
+	}
+
+	to, _, ok := parsePath(arg, "TO:")
+	if !ok || to == "" {
+		return ss.reply(501, "5.5.4 Syntax error in RCPT command")
+	}
+	if uint32(len(ss.to)) >= ss.server.spec.MaxRecipients {
+		return ss.reply(452, "4.5.3 Too many recipients")
+	}
+	if !ss.server.relay.allowRecipient(to) {
+		return ss.reply(550, "5.7.1 Recipient not allowed")
+	}
+
+	ss.to = append(ss.to, to)
+	return ss.reply(250, "2.1.5 OK")
+}
+
+func (ss *session) handleData() error {
+	if len(ss.to) == 0 {
+		return ss.reply(503, "5.5.1 Send RCPT first")
+	}
+	if err := ss.reply(354, "Start mail input; end with <CRLF>.<CRLF>"); err != nil {
+		return err
+	}
+
+	s := ss.server
+	maxSize := int64(s.spec.MaxMessageSize)
+	dr := ss.text.DotReader()
+	data, err := ioutil.ReadAll(io.LimitReader(dr, maxSize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) > maxSize {
+		if _, err := io.Copy(ioutil.Discard, dr); err != nil {
+			return err
+		}
+		ss.reset()
+		return ss.reply(552, "5.3.4 Message size exceeds the limit")
+	}
+
+	received := fmt.Sprintf("Received: from %s (%s)\n\tby %s with ESMTP; %s\n",
+		ss.helo, ss.clientIP, s.hostname, time.Now().Format(time.RFC1123Z))
+	msg := append([]byte(received), data...)
+
+	from, to := ss.from, ss.to
+	ss.reset()
+
+	err = s.relay.deliver(s.hostname, from, to, msg)
+	if err == nil {
+		return ss.reply(250, "2.0.0 OK: relayed")
+	}
+
+	logger.Warnf("%s relay message from %s to %v failed: %v", s.name, from, to, err)
+	if te, ok := err.(*textproto.Error); ok && te.Code >= 500 {
+		return ss.reply(554, "5.0.0 Rejected by upstream: %s", te.Msg)
+	}
+	return ss.reply(451, "4.4.0 Relay failed, try again later")
+}
+
+// Status returns the status of the server.
+func (s *Server) Status() *Status {
+	s.mutex.Lock()
+	state, err := s.state, s.err
+	s.mutex.Unlock()
+
+	status := &Status{
+		State:               state,
+		ActiveConnections:   atomic.LoadInt64(&s.activeConns),
+		TotalConnections:    atomic.LoadUint64(&s.totalConns),
+		RejectedConnections: atomic.LoadUint64(&s.rejectedConns),
+		DeliveryStatus:      s.relay.status(),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	return status
+}
+
+// Close closes the listener, established connections are kept until
+// either side closes them.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	close(s.done)
+	s.state = stateClosed
+	if s.listener != nil {
+		s.listener.Close()
+	}
+}
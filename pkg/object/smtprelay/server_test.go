@@ -0,0 +1,215 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smtprelay
+
+import (
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func TestParsePath(t *testing.T) {
+	cases := []struct {
+		arg    string
+		addr   string
+		params map[string]string
+		ok     bool
+	}{
+		{arg: "FROM:<a@b.com>", addr: "a@b.com", params: map[string]string{}, ok: true},
+		{arg: "from: <a@b.com> size=10 BODY=8BITMIME", addr: "a@b.com", params: map[string]string{"SIZE": "10", "BODY": "8BITMIME"}, ok: true},
+		{arg: "FROM:<>", addr: "", params: map[string]string{}, ok: true},
+		{arg: "TO:<a@b.com>", ok: false},
+		{arg: "FROM:a@b.com", ok: false},
+		{arg: "FROM:<a@b.com", ok: false},
+	}
+
+	for _, c := range cases {
+		addr, params, ok := parsePath(c.arg, "FROM:")
+		if ok != c.ok {
+			t.Errorf("%q: ok should be %v", c.arg, c.ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if addr != c.addr {
+			t.Errorf("%q: address should be %q, but is %q", c.arg, c.addr, addr)
+		}
+		if len(params) != len(c.params) {
+			t.Errorf("%q: params should be %v, but are %v", c.arg, c.params, params)
+		}
+		for k, v := range c.params {
+			if params[k] != v {
+				t.Errorf("%q: param %s should be %q, but is %q", c.arg, k, v, params[k])
+			}
+		}
+	}
+}
+
+func TestAllowRecipient(t *testing.T) {
+	r := newRelay(&Spec{
+		AllowedRecipients: []string{"ops@example.com", "@megaease.com"},
+		Upstream:          &UpstreamSpec{Addr: "127.0.0.1:25"},
+	})
+
+	for _, to := range []string{"ops@example.com", "OPS@Example.com", "dev@megaease.com"} {
+		if !r.allowRecipient(to) {
+			t.Errorf("%s should be allowed", to)
+		}
+	}
+	for _, to := range []string{"dev@example.com", "dev@sub.megaease.com", "megaease.com"} {
+		if r.allowRecipient(to) {
+			t.Errorf("%s should not be allowed", to)
+		}
+	}
+
+	if s := r.status(); s.RejectedRecipients != 3 {
+		t.Errorf("rejected recipients should be 3, but is %d", s.RejectedRecipients)
+	}
+}
+
+func TestAllowMessage(t *testing.T) {
+	r := newRelay(&Spec{
+		Upstream:  &UpstreamSpec{Addr: "127.0.0.1:25"},
+		RateLimit: &RateLimitSpec{Limit: 2, Period: "1h", PerSender: true},
+	})
+
+	for i := 0; i < 2; i++ {
+		if !r.allowMessage("a@example.com") {
+			t.Errorf("message %d of a@example.com should be allowed", i)
+		}
+	}
+	if r.allowMessage("A@example.com") {
+		t.Errorf("message 2 of a@example.com should not be allowed")
+	}
+	if !r.allowMessage("b@example.com") {
+		t.Errorf("message 0 of b@example.com should be allowed")
+	}
+
+	if s := r.status(); s.RateLimited != 1 {
+		t.Errorf("rate limited should be 1, but is %d", s.RateLimited)
+	}
+}
+
+// fakeUpstream accepts one message and sends it to the returned channel.
+func fakeUpstream(t *testing.T) (string, chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	ch := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		text := textproto.NewConn(conn)
+		text.PrintfLine("220 upstream ESMTP")
+		for {
+			line, err := text.ReadLine()
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); cmd {
+			case "DATA":
+				text.PrintfLine("354 Go ahead")
+				data, _ := text.ReadDotBytes()
+				ch <- string(data)
+				text.PrintfLine("250 OK")
+			case "QUIT":
+				text.PrintfLine("221 Bye")
+				return
+			default:
+				text.PrintfLine("250 OK")
+			}
+		}
+	}()
+
+	return l.Addr().String(), ch
+}
+
+func TestRelay(t *testing.T) {
+	addr, ch := fakeUpstream(t)
+	s := newServer("smtp-relay", &Spec{
+		Hostname:          "relay.local",
+		MaxMessageSize:    1024,
+		MaxRecipients:     10,
+		AllowedRecipients: []string{"@example.com"},
+		Upstream:          &UpstreamSpec{Addr: addr},
+	})
+
+	client, server := net.Pipe()
+	go s.handleConn(server)
+
+	c, err := smtp.NewClient(client, "relay.local")
+	if err != nil {
+		t.Fatalf("new client failed: %v", err)
+	}
+	defer c.Close()
+
+	if err = c.Hello("localhost"); err != nil {
+		t.Fatalf("hello failed: %v", err)
+	}
+	if err = c.Mail("svc@internal"); err != nil {
+		t.Fatalf("mail failed: %v", err)
+	}
+	if err = c.Rcpt("someone@other.com"); err == nil {
+		t.Errorf("recipient someone@other.com should be rejected")
+	}
+	if err = c.Rcpt("ops@example.com"); err != nil {
+		t.Fatalf("rcpt failed: %v", err)
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("data failed: %v", err)
+	}
+	w.Write([]byte("Subject: test\r\n\r\nhello\r\n"))
+	if err = w.Close(); err != nil {
+		t.Fatalf("message should be relayed, but got: %v", err)
+	}
+	c.Quit()
+
+	msg := <-ch
+	if !strings.HasPrefix(msg, "Received: ") {
+		t.Errorf("message should start with a Received header: %q", msg)
+	}
+	if !strings.Contains(msg, "Subject: test\n\nhello\n") {
+		t.Errorf("message body mismatch: %q", msg)
+	}
+
+	status := s.Status()
+	if status.Delivered != 1 || status.DeliveryFailures != 0 || status.RejectedRecipients != 1 {
+		t.Errorf("unexpected status: %+v", status.DeliveryStatus)
+	}
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smtprelay
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of SMTPRelay.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of SMTPRelay.
+	Kind = "SMTPRelay"
+)
+
+func init() {
+	supervisor.Register(&SMTPRelay{})
+}
+
+type (
+	// SMTPRelay accepts mails from internal services, and relays them to
+	// the upstream mail server after the recipients are checked against
+	// the allowlist and the rate limits are applied.
+	SMTPRelay struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		server    *Server
+	}
+)
+
+// Category returns the category of SMTPRelay.
+func (sr *SMTPRelay) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of SMTPRelay.
+func (sr *SMTPRelay) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SMTPRelay.
+func (sr *SMTPRelay) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConnections: 1024,
+		MaxMessageSize: 10 * 1024 * 1024,
+		MaxRecipients:  100,
+		Timeout:        "5m",
+	}
+}
+
+// Init initializes SMTPRelay.
+func (sr *SMTPRelay) Init(superSpec *supervisor.Spec) {
+	sr.superSpec, sr.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	sr.reload()
+}
+
+// Inherit inherits previous generation of SMTPRelay.
+func (sr *SMTPRelay) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	sr.Init(superSpec)
+}
+
+func (sr *SMTPRelay) reload() {
+	sr.server = newServer(sr.superSpec.Name(), sr.spec)
+	go sr.server.run()
+}
+
+// Status returns the status of SMTPRelay.
+func (sr *SMTPRelay) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: sr.server.Status(),
+	}
+}
+
+// Close closes SMTPRelay.
+func (sr *SMTPRelay) Close() {
+	sr.server.Close()
+}
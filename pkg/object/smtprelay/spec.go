@@ -0,0 +1,109 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package smtprelay
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+)
+
+const (
+	defaultTimeout         = 5 * time.Minute
+	defaultUpstreamTimeout = 30 * time.Second
+	defaultRateLimitPeriod = time.Minute
+)
+
+type (
+	// Spec describes the SMTPRelay.
+	Spec struct {
+		Port uint16 `yaml:"port" jsonschema:"required,minimum=1"`
+		// Hostname is the name of the relay in greetings and Received
+		// headers, the default is the hostname of the node.
+		Hostname       string         `yaml:"hostname" jsonschema:"omitempty"`
+		MaxConnections uint32         `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
+		MaxMessageSize uint32         `yaml:"maxMessageSize" jsonschema:"omitempty,minimum=1"`
+		MaxRecipients  uint32         `yaml:"maxRecipients" jsonschema:"omitempty,minimum=1"`
+		Timeout        string         `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		IPFilter       *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
+		// AllowedRecipients are the recipients allowed, an entry is either
+		// an address or a domain starting with "@", which allows all the
+		// addresses of the domain.
+		AllowedRecipients []string       `yaml:"allowedRecipients" jsonschema:"required,uniqueItems=true"`
+		RateLimit         *RateLimitSpec `yaml:"rateLimit,omitempty" jsonschema:"omitempty"`
+		Upstream          *UpstreamSpec  `yaml:"upstream" jsonschema:"required"`
+	}
+
+	// RateLimitSpec describes the rate limit of messages.
+	RateLimitSpec struct {
+		Limit  uint32 `yaml:"limit" jsonschema:"required,minimum=1"`
+		Period string `yaml:"period" jsonschema:"omitempty,format=duration"`
+		// PerSender applies the limit to every envelope sender instead of
+		// all the messages.
+		PerSender bool `yaml:"perSender" jsonschema:"omitempty"`
+	}
+
+	// UpstreamSpec describes the upstream mail server.
+	UpstreamSpec struct {
+		Addr string `yaml:"addr" jsonschema:"required"`
+		// TLS connects to the server with implicit TLS, e.g. port 465,
+		// otherwise STARTTLS is used if the server supports it.
+		TLS      bool   `yaml:"tls" jsonschema:"omitempty"`
+		Username string `yaml:"username" jsonschema:"omitempty"`
+		Password string `yaml:"password" jsonschema:"omitempty"`
+		Timeout  string `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	for _, r := range spec.AllowedRecipients {
+		if strings.Count(r, "@") != 1 || strings.HasSuffix(r, "@") {
+			return fmt.Errorf("invalid allowed recipient %s", r)
+		}
+	}
+	return nil
+}
+
+// Validate validates UpstreamSpec.
+func (spec *UpstreamSpec) Validate() error {
+	if _, _, err := net.SplitHostPort(spec.Addr); err != nil {
+		return fmt.Errorf("invalid address %s: %v", spec.Addr, err)
+	}
+	if (spec.Username == "") != (spec.Password == "") {
+		return fmt.Errorf("username and password must be specified together")
+	}
+	return nil
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return d
+	}
+	return v
+}
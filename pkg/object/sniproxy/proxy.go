@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/limitlistener"
+)
+
+const (
+	stateNil     stateType = "nil"
+	stateRunning stateType = "running"
+	stateFailed  stateType = "failed"
+	stateClosed  stateType = "closed"
+
+	checkFailedTimeout = 10 * time.Second
+)
+
+type (
+	stateType string
+
+	// Proxy accepts TCP connections, routes them by the server name in
+	// the TLS ClientHello and passes them through to the upstream servers
+	// without terminating TLS.
+	Proxy struct {
+		name string
+		spec *Spec
+
+		router           *router
+		ipFilter         *ipfilter.IPFilter
+		handshakeTimeout time.Duration
+		connectTimeout   time.Duration
+
+		mutex    sync.Mutex
+		state    stateType
+		err      error
+		listener *limitlistener.LimitListener
+
+		activeConns   int64
+		totalConns    uint64
+		rejectedConns uint64
+		unmatched     uint64
+		dialFailures  uint64
+
+		// done is the channel for shutdowning this proxy.
+		done chan struct{}
+	}
+
+	// Status is the status of SNIProxy.
+	Status struct {
+		State stateType `yaml:"state"`
+		Error string    `yaml:"error,omitempty"`
+
+		ActiveConnections   int64  `yaml:"activeConnections"`
+		TotalConnections    uint64 `yaml:"totalConnections"`
+		RejectedConnections uint64 `yaml:"rejectedConnections"`
+		UnmatchedServerName uint64 `yaml:"unmatchedServerName"`
+		DialFailures        uint64 `yaml:"dialFailures"`
+	}
+)
+
+func newProxy(name string, spec *Spec) *Proxy {
+	p := &Proxy{
+		name:             name,
+		spec:             spec,
+		router:           newRouter(spec),
+		handshakeTimeout: parseDuration(spec.HandshakeTimeout, defaultHandshakeTimeout),
+		connectTimeout:   parseDuration(spec.ConnectTimeout, defaultConnectTimeout),
+		state:            stateNil,
+		done:             make(chan struct{}),
+	}
+
+	if spec.IPFilter != nil {
+		p.ipFilter = ipfilter.New(spec.IPFilter)
+	}
+
+	return p
+}
+
+func (p *Proxy) run() {
+	for {
+		if p.listen() {
+			return
+		}
+
+		select {
+		case <-p.done:
+			return
+		case <-time.After(checkFailedTimeout):
+		}
+	}
+}
+
+// listen starts listening and serving, it returns false if it failed to
+// listen and should retry.
+func (p *Proxy) listen() bool {
+	p.mutex.Lock()
+	select {
+	case <-p.done:
+		p.mutex.Unlock()
+		return true
+	default:
+	}
+
+	l, err := net.Listen("tcp", fmt.Sprintf(":%d", p.spec.Port))
+	if err != nil {
+		logger.Errorf("%s listen on port %d failed: %v", p.name, p.spec.Port, err)
+		p.state, p.err = stateFailed, err
+		p.mutex.Unlock()
+		return false
+	}
+
+	p.listener = limitlistener.NewLimitListener(l, p.spec.MaxConnections)
+	p.state, p.err = stateRunning, nil
+	listener := p.listener
+	p.mutex.Unlock()
+
+	p.serve(listener)
+	return true
+}
+
+func (p *Proxy) serve(l net.Listener) {
+	var tempDelay time.Duration
+
+	for {
+		conn, err := l.Accept()
+		if err == nil {
+			tempDelay = 0
+			go p.handleConn(conn)
+			continue
+		}
+
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if max := 1 * time.Second; tempDelay > max {
+				tempDelay = max
+			}
+			time.Sleep(tempDelay)
+			continue
+		}
+
+		logger.Errorf("%s accept failed: %v", p.name, err)
+		p.mutex.Lock()
+		p.state, p.err = stateFailed, err
+		p.mutex.Unlock()
+		return
+	}
+}
+
+func (p *Proxy) handleConn(conn net.Conn) {
+	atomic.AddInt64(&p.activeConns, 1)
+	atomic.AddUint64(&p.totalConns, 1)
+	defer atomic.AddInt64(&p.activeConns, -1)
+
+	clientAddr := conn.RemoteAddr()
+	if p.ipFilter != nil {
+		ip := clientAddr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		if !p.ipFilter.Allow(ip) {
+			atomic.AddUint64(&p.rejectedConns, 1)
+			conn.Close()
+			return
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(p.handshakeTimeout))
+	serverName, hello, err := readClientHello(conn)
+	if err != nil {
+		logger.Debugf("%s read client hello from %s failed: %v", p.name, clientAddr, err)
+		atomic.AddUint64(&p.rejectedConns, 1)
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	pool := p.router.route(serverName)
+	if pool == nil {
+		logger.Debugf("%s no pool for server name %q from %s", p.name, serverName, clientAddr)
+		atomic.AddUint64(&p.unmatched, 1)
+		conn.Close()
+		return
+	}
+
+	server := pool.next(clientAddr)
+	upstream, err := net.DialTimeout("tcp", server.Addr, p.connectTimeout)
+	if err != nil {
+		logger.Warnf("%s dial %s for server name %q failed: %v", p.name, server.Addr, serverName, err)
+		atomic.AddUint64(&p.dialFailures, 1)
+		conn.Close()
+		return
+	}
+
+	if _, err = upstream.Write(hello); err != nil {
+		logger.Warnf("%s write client hello to %s failed: %v", p.name, server.Addr, err)
+		upstream.Close()
+		conn.Close()
+		return
+	}
+
+	pipe(conn, upstream)
+}
+
+// pipe copies data between the two connections until both directions are
+// finished, the write side is closed as soon as its source reaches EOF.
+func pipe(client, upstream net.Conn) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	copyConn := func(dst, src net.Conn) {
+		defer wg.Done()
+		io.Copy(dst, src)
+		if cw, ok := dst.(interface{ CloseWrite() error }); !ok || cw.CloseWrite() != nil {
+			// NOTE: Without half close, the other direction can't be
+			// notified, so close both connections.
+			dst.Close()
+			src.Close()
+		}
+	}
+
+	go copyConn(upstream, client)
+	go copyConn(client, upstream)
+	wg.Wait()
+
+	client.Close()
+	upstream.Close()
+}
+
+// Status returns the status of the proxy.
+func (p *Proxy) Status() *Status {
+	p.mutex.Lock()
+	state, err := p.state, p.err
+	p.mutex.Unlock()
+
+	s := &Status{
+		State:               state,
+		ActiveConnections:   atomic.LoadInt64(&p.activeConns),
+		TotalConnections:    atomic.LoadUint64(&p.totalConns),
+		RejectedConnections: atomic.LoadUint64(&p.rejectedConns),
+		UnmatchedServerName: atomic.LoadUint64(&p.unmatched),
+		DialFailures:        atomic.LoadUint64(&p.dialFailures),
+	}
+	if err != nil {
+		s.Error = err.Error()
+	}
+
+	return s
+}
+
+// Close closes the listener, established connections are kept until
+// either side closes them.
+func (p *Proxy) Close() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	close(p.done)
+	p.state = stateClosed
+	if p.listener != nil {
+		p.listener.Close()
+	}
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func startProxy(t *testing.T, spec *Spec) (*Proxy, string) {
+	p := newProxy("test-sniproxy", spec)
+	go p.run()
+
+	for i := 0; i < 100; i++ {
+		p.mutex.Lock()
+		l := p.listener
+		p.mutex.Unlock()
+		if l != nil {
+			_, port, _ := net.SplitHostPort(l.Addr().String())
+			return p, "127.0.0.1:" + port
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("proxy failed to listen")
+	return nil, ""
+}
+
+func newUpstream(name string) *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s:%s", name, r.TLS.ServerName)
+	}))
+}
+
+func TestProxy(t *testing.T) {
+	logger.InitNop()
+
+	foo, bar := newUpstream("foo"), newUpstream("bar")
+	defer foo.Close()
+	defer bar.Close()
+
+	p, addr := startProxy(t, &Spec{
+		MaxConnections: 10,
+		Rules: []*Rule{
+			{
+				ServerNames: []string{"foo.example.com"},
+				Pool:        &PoolSpec{Servers: []*Server{{Addr: foo.Listener.Addr().String()}}},
+			},
+			{
+				ServerNames: []string{"*.bar.example.com"},
+				Pool:        &PoolSpec{Servers: []*Server{{Addr: bar.Listener.Addr().String()}}},
+			},
+		},
+	})
+	defer p.Close()
+
+	get := func(serverName string) (string, error) {
+		client := &http.Client{Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				ServerName:         serverName,
+				InsecureSkipVerify: true,
+			},
+		}}
+		defer client.CloseIdleConnections()
+
+		resp, err := client.Get("https://" + addr)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	if body, err := get("foo.example.com"); err != nil || body != "foo:foo.example.com" {
+		t.Errorf("unexpected result: %q, %v", body, err)
+	}
+	if body, err := get("x.bar.example.com"); err != nil || body != "bar:x.bar.example.com" {
+		t.Errorf("unexpected result: %q, %v", body, err)
+	}
+	if _, err := get("unknown.example.com"); err == nil {
+		t.Errorf("unmatched server name should fail")
+	}
+
+	status := p.Status()
+	if status.State != stateRunning || status.TotalConnections != 3 || status.UnmatchedServerName != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
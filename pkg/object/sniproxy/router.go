@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"math/rand"
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/megaease/easegress/pkg/util/hashtool"
+)
+
+type (
+	// router routes connections to pools by server names.
+	router struct {
+		exact       map[string]*pool
+		wildcards   []*wildcard
+		defaultPool *pool
+	}
+
+	wildcard struct {
+		suffix string
+		pool   *pool
+	}
+
+	pool struct {
+		count      uint64
+		weightsSum int
+		servers    []*Server
+		policy     string
+	}
+)
+
+func newRouter(spec *Spec) *router {
+	r := &router{exact: map[string]*pool{}}
+
+	for _, rule := range spec.Rules {
+		p := newPool(rule.Pool)
+		for _, name := range rule.ServerNames {
+			name = strings.ToLower(name)
+			if strings.HasPrefix(name, "*.") {
+				// NOTE: Keep the dot, so *.example.com doesn't match example.com.
+				r.wildcards = append(r.wildcards, &wildcard{suffix: name[1:], pool: p})
+			} else {
+				r.exact[name] = p
+			}
+		}
+	}
+
+	if spec.DefaultPool != nil {
+		r.defaultPool = newPool(spec.DefaultPool)
+	}
+
+	return r
+}
+
+// route returns the pool of the server name, the longest wildcard wins
+// if there are multiple matching wildcards.
+func (r *router) route(serverName string) *pool {
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if serverName == "" {
+		return r.defaultPool
+	}
+
+	if p, exists := r.exact[serverName]; exists {
+		return p
+	}
+
+	var matched *wildcard
+	for _, w := range r.wildcards {
+		if strings.HasSuffix(serverName, w.suffix) {
+			if matched == nil || len(w.suffix) > len(matched.suffix) {
+				matched = w
+			}
+		}
+	}
+	if matched != nil {
+		return matched.pool
+	}
+
+	return r.defaultPool
+}
+
+func newPool(spec *PoolSpec) *pool {
+	p := &pool{servers: spec.Servers, policy: PolicyRoundRobin}
+	if spec.LoadBalance != nil && spec.LoadBalance.Policy != "" {
+		p.policy = spec.LoadBalance.Policy
+	}
+	for _, s := range p.servers {
+		p.weightsSum += s.Weight
+	}
+	return p
+}
+
+// next returns the next server for the client address.
+func (p *pool) next(clientAddr net.Addr) *Server {
+	if len(p.servers) == 0 {
+		return nil
+	}
+
+	switch p.policy {
+	case PolicyRandom:
+		return p.servers[rand.Intn(len(p.servers))]
+	case PolicyWeightedRandom:
+		if p.weightsSum > 0 {
+			randomWeight := rand.Intn(p.weightsSum)
+			for _, server := range p.servers {
+				randomWeight -= server.Weight
+				if randomWeight < 0 {
+					return server
+				}
+			}
+		}
+		return p.servers[rand.Intn(len(p.servers))]
+	case PolicyIPHash:
+		ip := clientAddr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		sum32 := int(hashtool.Hash32(ip))
+		return p.servers[sum32%len(p.servers)]
+	}
+
+	count := atomic.AddUint64(&p.count, 1) - 1
+	return p.servers[int(count%uint64(len(p.servers)))]
+}
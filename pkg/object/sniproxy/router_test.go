@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	spec := &Spec{
+		Rules: []*Rule{
+			{
+				ServerNames: []string{"api.example.com"},
+				Pool:        &PoolSpec{Servers: []*Server{{Addr: "10.0.0.1:443"}}},
+			},
+			{
+				ServerNames: []string{"*.example.com"},
+				Pool:        &PoolSpec{Servers: []*Server{{Addr: "10.0.0.2:443"}}},
+			},
+			{
+				ServerNames: []string{"*.eu.example.com"},
+				Pool:        &PoolSpec{Servers: []*Server{{Addr: "10.0.0.3:443"}}},
+			},
+		},
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("validate failed: %v", err)
+	}
+
+	r := newRouter(spec)
+	cases := map[string]string{
+		"api.example.com":     "10.0.0.1:443",
+		"API.Example.com.":    "10.0.0.1:443",
+		"www.example.com":     "10.0.0.2:443",
+		"a.b.example.com":     "10.0.0.2:443",
+		"shop.eu.example.com": "10.0.0.3:443",
+	}
+	for name, addr := range cases {
+		p := r.route(name)
+		if p == nil {
+			t.Errorf("%s should match a pool", name)
+			continue
+		}
+		if got := p.next(nil).Addr; got != addr {
+			t.Errorf("%s should be routed to %s, but got %s", name, addr, got)
+		}
+	}
+
+	for _, name := range []string{"example.com", "example.org", ""} {
+		if r.route(name) != nil {
+			t.Errorf("%q should not match any pool", name)
+		}
+	}
+
+	spec.DefaultPool = &PoolSpec{Servers: []*Server{{Addr: "10.0.0.9:443"}}}
+	r = newRouter(spec)
+	if p := r.route(""); p == nil || p.next(nil).Addr != "10.0.0.9:443" {
+		t.Errorf("connections without SNI should go to default pool")
+	}
+}
+
+func TestPool(t *testing.T) {
+	servers := []*Server{{Addr: "10.0.0.1:443"}, {Addr: "10.0.0.2:443"}}
+
+	p := newPool(&PoolSpec{Servers: servers})
+	if p.next(nil) == p.next(nil) {
+		t.Errorf("round robin should pick different servers")
+	}
+
+	p = newPool(&PoolSpec{Servers: servers, LoadBalance: &LoadBalance{Policy: PolicyIPHash}})
+	addr := &net.TCPAddr{IP: net.ParseIP("192.168.1.1"), Port: 1000}
+	s := p.next(addr)
+	for i := 0; i < 10; i++ {
+		addr.Port++
+		if p.next(addr) != s {
+			t.Errorf("ip hash should pick the same server for the same ip")
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	pool := &PoolSpec{Servers: []*Server{{Addr: "10.0.0.1:443"}}}
+
+	if err := (&Spec{}).Validate(); err == nil {
+		t.Errorf("spec without rules and default pool should be invalid")
+	}
+
+	spec := &Spec{Rules: []*Rule{
+		{ServerNames: []string{"a.example.com"}, Pool: pool},
+		{ServerNames: []string{"A.example.com"}, Pool: pool},
+	}}
+	if err := spec.Validate(); err == nil {
+		t.Errorf("duplicated server names should be invalid")
+	}
+
+	for _, name := range []string{"", "*example.com", "a.*.example.com"} {
+		if err := (&Rule{ServerNames: []string{name}, Pool: pool}).Validate(); err == nil {
+			t.Errorf("server name %q should be invalid", name)
+		}
+	}
+
+	if err := (&PoolSpec{Servers: []*Server{{Addr: "10.0.0.1"}}}).Validate(); err == nil {
+		t.Errorf("server address without port should be invalid")
+	}
+
+	weighted := &PoolSpec{Servers: []*Server{{Addr: "10.0.0.1:443", Weight: 1}, {Addr: "10.0.0.2:443"}}}
+	if err := weighted.Validate(); err == nil {
+		t.Errorf("partial weights should be invalid")
+	}
+}
@@ -0,0 +1,68 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// errHelloRead aborts the handshake once the ClientHello has been read.
+var errHelloRead = errors.New("client hello read")
+
+// readOnlyConn feeds the TLS server with the client data and refuses to
+// write anything back, so the client never sees the aborted handshake.
+type readOnlyConn struct {
+	reader io.Reader
+}
+
+func (c readOnlyConn) Read(p []byte) (int, error)         { return c.reader.Read(p) }
+func (c readOnlyConn) Write(p []byte) (int, error)        { return 0, io.ErrClosedPipe }
+func (c readOnlyConn) Close() error                       { return nil }
+func (c readOnlyConn) LocalAddr() net.Addr                { return nil }
+func (c readOnlyConn) RemoteAddr() net.Addr               { return nil }
+func (c readOnlyConn) SetDeadline(t time.Time) error      { return nil }
+func (c readOnlyConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c readOnlyConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// readClientHello reads the ClientHello from the reader and returns the
+// server name in it, along with all bytes consumed from the reader which
+// must be replayed to the upstream. The returned server name is empty if
+// the client doesn't send SNI.
+func readClientHello(reader io.Reader) (string, []byte, error) {
+	buff := &bytes.Buffer{}
+	var hello *tls.ClientHelloInfo
+
+	err := tls.Server(readOnlyConn{reader: io.TeeReader(reader, buff)}, &tls.Config{
+		GetConfigForClient: func(info *tls.ClientHelloInfo) (*tls.Config, error) {
+			hello = new(tls.ClientHelloInfo)
+			*hello = *info
+			return nil, errHelloRead
+		},
+	}).Handshake()
+
+	if hello == nil {
+		return "", buff.Bytes(), err
+	}
+
+	return hello.ServerName, buff.Bytes(), nil
+}
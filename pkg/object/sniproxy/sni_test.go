@@ -0,0 +1,84 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func readHello(t *testing.T, serverName string) (string, []byte, []byte) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	written := &bytes.Buffer{}
+	go func() {
+		conn := tls.Client(&teeConn{Conn: client, buff: written}, &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true,
+		})
+		conn.Handshake()
+		client.Close()
+	}()
+
+	name, hello, err := readClientHello(server)
+	if err != nil {
+		t.Fatalf("read client hello failed: %v", err)
+	}
+	return name, hello, written.Bytes()
+}
+
+type teeConn struct {
+	net.Conn
+	buff *bytes.Buffer
+}
+
+func (c *teeConn) Write(p []byte) (int, error) {
+	c.buff.Write(p)
+	return c.Conn.Write(p)
+}
+
+func TestReadClientHello(t *testing.T) {
+	name, hello, written := readHello(t, "foo.example.com")
+	if name != "foo.example.com" {
+		t.Errorf("server name should be foo.example.com, but got %q", name)
+	}
+	if !bytes.HasPrefix(written, hello) {
+		t.Errorf("consumed bytes should be the prefix of the client data")
+	}
+
+	// IP addresses are not sent as SNI.
+	name, _, _ = readHello(t, "127.0.0.1")
+	if name != "" {
+		t.Errorf("server name should be empty, but got %q", name)
+	}
+}
+
+func TestReadClientHelloNotTLS(t *testing.T) {
+	client, server := net.Pipe()
+	go func() {
+		client.Write([]byte("GET / HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+		client.Close()
+	}()
+
+	if _, _, err := readClientHello(server); err == nil {
+		t.Errorf("read client hello should fail")
+	}
+}
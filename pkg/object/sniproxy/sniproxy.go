@@ -0,0 +1,92 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of SNIProxy.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of SNIProxy.
+	Kind = "SNIProxy"
+)
+
+func init() {
+	supervisor.Register(&SNIProxy{})
+}
+
+type (
+	// SNIProxy routes TLS connections to upstream servers by the server
+	// name in ClientHello, without terminating TLS.
+	SNIProxy struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		proxy     *Proxy
+	}
+)
+
+// Category returns the category of SNIProxy.
+func (sp *SNIProxy) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of SNIProxy.
+func (sp *SNIProxy) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SNIProxy.
+func (sp *SNIProxy) DefaultSpec() interface{} {
+	return &Spec{
+		MaxConnections:   10240,
+		HandshakeTimeout: "10s",
+		ConnectTimeout:   "5s",
+	}
+}
+
+// Init initializes SNIProxy.
+func (sp *SNIProxy) Init(superSpec *supervisor.Spec) {
+	sp.superSpec, sp.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	sp.reload()
+}
+
+// Inherit inherits previous generation of SNIProxy.
+func (sp *SNIProxy) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	sp.Init(superSpec)
+}
+
+func (sp *SNIProxy) reload() {
+	sp.proxy = newProxy(sp.superSpec.Name(), sp.spec)
+	go sp.proxy.run()
+}
+
+// Status returns the status of SNIProxy.
+func (sp *SNIProxy) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: sp.proxy.Status(),
+	}
+}
+
+// Close closes SNIProxy.
+func (sp *SNIProxy) Close() {
+	sp.proxy.Close()
+}
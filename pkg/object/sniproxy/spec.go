@@ -0,0 +1,146 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package sniproxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+)
+
+const (
+	// PolicyRoundRobin is the policy of round-robin.
+	PolicyRoundRobin = "roundRobin"
+	// PolicyRandom is the policy of random.
+	PolicyRandom = "random"
+	// PolicyWeightedRandom is the policy of weighted random.
+	PolicyWeightedRandom = "weightedRandom"
+	// PolicyIPHash is the policy of ip hash.
+	PolicyIPHash = "ipHash"
+
+	defaultHandshakeTimeout = 10 * time.Second
+	defaultConnectTimeout   = 5 * time.Second
+)
+
+type (
+	// Spec describes the SNIProxy.
+	Spec struct {
+		Port             uint16         `yaml:"port" jsonschema:"required,minimum=1"`
+		MaxConnections   uint32         `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
+		HandshakeTimeout string         `yaml:"handshakeTimeout" jsonschema:"omitempty,format=duration"`
+		ConnectTimeout   string         `yaml:"connectTimeout" jsonschema:"omitempty,format=duration"`
+		IPFilter         *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
+		Rules            []*Rule        `yaml:"rules" jsonschema:"omitempty"`
+		// DefaultPool handles the connections without SNI or matching none of rules.
+		DefaultPool *PoolSpec `yaml:"defaultPool,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Rule routes connections to a pool by server names.
+	Rule struct {
+		// ServerNames are the server names to match, a name starting with
+		// "*." matches all its subdomains.
+		ServerNames []string  `yaml:"serverNames" jsonschema:"required,uniqueItems=true"`
+		Pool        *PoolSpec `yaml:"pool" jsonschema:"required"`
+	}
+
+	// PoolSpec describes a pool of upstream servers.
+	PoolSpec struct {
+		Servers     []*Server    `yaml:"servers" jsonschema:"required"`
+		LoadBalance *LoadBalance `yaml:"loadBalance,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Server is an upstream server.
+	Server struct {
+		Addr   string `yaml:"addr" jsonschema:"required"`
+		Weight int    `yaml:"weight" jsonschema:"omitempty,minimum=0,maximum=100"`
+	}
+
+	// LoadBalance is load balance for multiple servers.
+	LoadBalance struct {
+		Policy string `yaml:"policy" jsonschema:"omitempty,enum=roundRobin,enum=random,enum=weightedRandom,enum=ipHash"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if len(spec.Rules) == 0 && spec.DefaultPool == nil {
+		return fmt.Errorf("both rules and defaultPool are empty")
+	}
+
+	names := map[string]struct{}{}
+	for _, rule := range spec.Rules {
+		for _, name := range rule.ServerNames {
+			name = strings.ToLower(name)
+			if _, exists := names[name]; exists {
+				return fmt.Errorf("server name %s is duplicated", name)
+			}
+			names[name] = struct{}{}
+		}
+	}
+
+	return nil
+}
+
+// Validate validates Rule.
+func (r *Rule) Validate() error {
+	for _, name := range r.ServerNames {
+		if name == "" || strings.Contains(name[1:], "*") || (name[0] == '*' && !strings.HasPrefix(name, "*.")) {
+			return fmt.Errorf("invalid server name: %q", name)
+		}
+	}
+	return nil
+}
+
+// Validate validates PoolSpec.
+func (p *PoolSpec) Validate() error {
+	serversGotWeight := 0
+	for _, server := range p.Servers {
+		if _, _, err := net.SplitHostPort(server.Addr); err != nil {
+			return fmt.Errorf("invalid server address %s: %v", server.Addr, err)
+		}
+		if server.Weight > 0 {
+			serversGotWeight++
+		}
+	}
+	if serversGotWeight > 0 && serversGotWeight < len(p.Servers) {
+		return fmt.Errorf("not all servers have weight(%d/%d)",
+			serversGotWeight, len(p.Servers))
+	}
+	if p.LoadBalance != nil && p.LoadBalance.Policy == PolicyWeightedRandom && serversGotWeight == 0 {
+		return fmt.Errorf("weightedRandom requires weights of servers")
+	}
+
+	return nil
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return d
+	}
+	return v
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslogserver
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const nilValue = "-"
+
+var (
+	severityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+	// utf8BOM marks the MSG as UTF-8 encoded, it is stripped.
+	utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+)
+
+// Record is a syslog message, it is sent to the sinks as JSON.
+type Record struct {
+	Facility       uint8                        `json:"facility"`
+	Severity       uint8                        `json:"severity"`
+	Timestamp      string                       `json:"timestamp,omitempty"`
+	Hostname       string                       `json:"hostname,omitempty"`
+	AppName        string                       `json:"appName,omitempty"`
+	ProcID         string                       `json:"procID,omitempty"`
+	MsgID          string                       `json:"msgID,omitempty"`
+	StructuredData map[string]map[string]string `json:"structuredData,omitempty"`
+	Message        string                       `json:"message"`
+
+	SourceIP   string            `json:"sourceIP"`
+	ReceivedAt string            `json:"receivedAt"`
+	Fields     map[string]string `json:"fields,omitempty"`
+}
+
+// parser parses a syslog message in the format of RFC5424:
+//
+//	<PRI>VERSION SP TIMESTAMP SP HOSTNAME SP APP-NAME SP PROCID SP MSGID SP STRUCTURED-DATA [SP MSG]
+//
+// Reference: https://tools.ietf.org/html/rfc5424#section-6
+type parser struct {
+	data []byte
+	pos  int
+}
+
+func parseRecord(data []byte) (*Record, error) {
+	p := &parser{data: data}
+	r := &Record{}
+
+	pri, err := p.parsePRI()
+	if err != nil {
+		return nil, err
+	}
+	r.Facility, r.Severity = uint8(pri/8), uint8(pri%8)
+
+	if version := p.field(); version != "1" {
+		return nil, fmt.Errorf("unsupported version %q", version)
+	}
+
+	headers := []*string{&r.Timestamp, &r.Hostname, &r.AppName, &r.ProcID, &r.MsgID}
+	for _, h := range headers {
+		if err := p.expectSpace(); err != nil {
+			return nil, err
+		}
+		if v := p.field(); v != nilValue {
+			*h = v
+		}
+	}
+
+	if err := p.expectSpace(); err != nil {
+		return nil, err
+	}
+	if r.StructuredData, err = p.parseStructuredData(); err != nil {
+		return nil, err
+	}
+
+	if p.pos < len(p.data) {
+		if err := p.expectSpace(); err != nil {
+			return nil, err
+		}
+		msg := bytes.TrimPrefix(p.data[p.pos:], utf8BOM)
+		if !utf8.Valid(msg) {
+			msg = bytes.ToValidUTF8(msg, []byte("�"))
+		}
+		r.Message = string(msg)
+	}
+
+	return r, nil
+}
+
+func (p *parser) parsePRI() (int, error) {
+	if len(p.data) == 0 || p.data[0] != '<' {
+		return 0, fmt.Errorf("missing PRI")
+	}
+
+	end := bytes.IndexByte(p.data, '>')
+	if end < 2 || end > 4 {
+		return 0, fmt.Errorf("invalid PRI")
+	}
+
+	pri, err := strconv.Atoi(string(p.data[1:end]))
+	if err != nil || pri > 191 {
+		return 0, fmt.Errorf("invalid PRI %q", p.data[1:end])
+	}
+
+	p.pos = end + 1
+	return pri, nil
+}
+
+// field returns the field ending at the next space or the end of data.
+func (p *parser) field() string {
+	start := p.pos
+	for p.pos < len(p.data) && p.data[p.pos] != ' ' {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+func (p *parser) expectSpace() error {
+	if p.pos >= len(p.data) || p.data[p.pos] != ' ' {
+		return fmt.Errorf("unexpected end of header at %d", p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *parser) parseStructuredData() (map[string]map[string]string, error) {
+	if p.pos < len(p.data) && p.data[p.pos] == '-' {
+		p.pos++
+		return nil, nil
+	}
+
+	sd := map[string]map[string]string{}
+	for p.pos < len(p.data) && p.data[p.pos] == '[' {
+		p.pos++
+
+		id := p.name()
+		if id == "" {
+			return nil, fmt.Errorf("missing SD-ID at %d", p.pos)
+		}
+		params := map[string]string{}
+
+		for {
+			if p.pos >= len(p.data) {
+				return nil, fmt.Errorf("unterminated SD-ELEMENT %s", id)
+			}
+			if p.data[p.pos] == ']' {
+				p.pos++
+				break
+			}
+			if err := p.expectSpace(); err != nil {
+				return nil, err
+			}
+
+			name := p.name()
+			if name == "" || p.pos+1 >= len(p.data) || p.data[p.pos] != '=' || p.data[p.pos+1] != '"' {
+				return nil, fmt.Errorf("invalid SD-PARAM of %s at %d", id, p.pos)
+			}
+			p.pos += 2
+
+			value, err := p.paramValue()
+			if err != nil {
+				return nil, err
+			}
+			params[name] = value
+		}
+
+		sd[id] = params
+	}
+
+	if len(sd) == 0 {
+		return nil, fmt.Errorf("invalid STRUCTURED-DATA at %d", p.pos)
+	}
+	return sd, nil
+}
+
+// name returns an SD-NAME, which ends at '=', ' ', ']' or '"'.
+func (p *parser) name() string {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '=' || c == ' ' || c == ']' || c == '"' {
+			break
+		}
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// paramValue returns the PARAM-VALUE ending at an unescaped '"', the '"',
+// '\' and ']' are escaped by '\'.
+func (p *parser) paramValue() (string, error) {
+	var sb strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+
+		switch c {
+		case '"':
+			return sb.String(), nil
+		case '\\':
+			if p.pos < len(p.data) {
+				if next := p.data[p.pos]; next == '"' || next == '\\' || next == ']' {
+					c = next
+					p.pos++
+				}
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return "", fmt.Errorf("unterminated PARAM-VALUE")
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslogserver
+
+import (
+	"testing"
+)
+
+func TestParseRecord(t *testing.T) {
+	msg := `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"][examplePriority@32473 class="high\]\"x\\"] ` + "\xEF\xBB\xBF" + `An application event log entry...`
+	r, err := parseRecord([]byte(msg))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+
+	if r.Facility != 20 || r.Severity != 5 {
+		t.Errorf("facility and severity should be 20 and 5, but are %d and %d", r.Facility, r.Severity)
+	}
+	if r.Timestamp != "2003-10-11T22:14:15.003Z" || r.Hostname != "mymachine.example.com" ||
+		r.AppName != "evntslog" || r.ProcID != "" || r.MsgID != "ID47" {
+		t.Errorf("unexpected header: %+v", r)
+	}
+	if r.StructuredData["exampleSDID@32473"]["eventID"] != "1011" {
+		t.Errorf("unexpected structured data: %v", r.StructuredData)
+	}
+	if v := r.StructuredData["examplePriority@32473"]["class"]; v != `high]"x\` {
+		t.Errorf("escaped param value should be unescaped, but is %q", v)
+	}
+	if r.Message != "An application event log entry..." {
+		t.Errorf("unexpected message: %q", r.Message)
+	}
+
+	r, err = parseRecord([]byte("<34>1 2003-10-11T22:14:15.003Z host su - - -"))
+	if err != nil {
+		t.Fatalf("parse failed: %v", err)
+	}
+	if r.StructuredData != nil || r.Message != "" {
+		t.Errorf("structured data and message should be empty: %+v", r)
+	}
+
+	invalid := []string{
+		"",
+		"no pri",
+		"<192>1 - - - - - -",
+		"<34>2 - - - - - -",
+		"<34>1 - - - - -",
+		"<34>1 - - - - - [id",
+		`<34>1 - - - - - [id a="b]`,
+		"<34>1 - - - - - -msg",
+	}
+	for _, msg := range invalid {
+		if _, err := parseRecord([]byte(msg)); err == nil {
+			t.Errorf("%q should be invalid", msg)
+		}
+	}
+}
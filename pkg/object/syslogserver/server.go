@@ -0,0 +1,431 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslogserver
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/limitlistener"
+)
+
+const (
+	stateNil     stateType = "nil"
+	stateRunning stateType = "running"
+	stateFailed  stateType = "failed"
+	stateClosed  stateType = "closed"
+
+	checkFailedTimeout = 10 * time.Second
+)
+
+type (
+	stateType string
+
+	// Server receives syslog messages, and forwards the records matching
+	// the filter to the sinks.
+	Server struct {
+		name      string
+		spec      *Spec
+		ipFilter  *ipfilter.IPFilter
+		filter    *filter
+		forwarder *forwarder
+
+		mutex      sync.Mutex
+		state      stateType
+		err        error
+		listener   net.Listener
+		packetConn net.PacketConn
+
+		activeConns   int64
+		totalConns    uint64
+		rejectedConns uint64
+		received      uint64
+		parseErrors   uint64
+		filtered      uint64
+
+		// done is the channel for shutdowning this server.
+		done chan struct{}
+	}
+
+	// Status is the status of SyslogServer.
+	Status struct {
+		State stateType `yaml:"state"`
+		Error string    `yaml:"error,omitempty"`
+
+		ActiveConnections   int64  `yaml:"activeConnections"`
+		TotalConnections    uint64 `yaml:"totalConnections"`
+		RejectedConnections uint64 `yaml:"rejectedConnections"`
+
+		Received    uint64 `yaml:"received"`
+		ParseErrors uint64 `yaml:"parseErrors"`
+		Filtered    uint64 `yaml:"filtered"`
+		Dropped     uint64 `yaml:"dropped"`
+
+		Sinks map[string]*SinkStatus `yaml:"sinks"`
+	}
+)
+
+func newServer(name string, spec *Spec) *Server {
+	s := &Server{
+		name:  name,
+		spec:  spec,
+		state: stateNil,
+		done:  make(chan struct{}),
+	}
+
+	if spec.IPFilter != nil {
+		s.ipFilter = ipfilter.New(spec.IPFilter)
+	}
+	if spec.Filter != nil {
+		s.filter = newFilter(spec.Filter)
+	}
+
+	var sinks []sink
+	if spec.Kafka != nil {
+		sinks = append(sinks, newKafkaSink(name, spec.Kafka))
+	}
+	if spec.Elasticsearch != nil {
+		sinks = append(sinks, newElasticsearchSink(spec.Elasticsearch))
+	}
+	s.forwarder = newForwarder(name, spec, sinks)
+
+	return s
+}
+
+func (s *Server) run() {
+	for {
+		if s.listen() {
+			return
+		}
+
+		select {
+		case <-s.done:
+			return
+		case <-time.After(checkFailedTimeout):
+		}
+	}
+}
+
+// listen starts listening and serving, it returns false if it failed to
+// listen and should retry.
+func (s *Server) listen() bool {
+	s.mutex.Lock()
+	select {
+	case <-s.done:
+		s.mutex.Unlock()
+		return true
+	default:
+	}
+
+	addr := fmt.Sprintf(":%d", s.spec.Port)
+	if s.spec.Protocol == protocolUDP {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return s.listenFailed(err)
+		}
+
+		s.packetConn = pc
+		s.state, s.err = stateRunning, nil
+		s.mutex.Unlock()
+
+		s.serveUDP(pc)
+		return true
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return s.listenFailed(err)
+	}
+
+	s.listener = limitlistener.NewLimitListener(l, s.spec.MaxConnections)
+	if s.spec.tlsEnabled() {
+		// NOTE: The spec has been validated.
+		tlsConfig, _ := s.spec.tlsConfig()
+		s.listener = tls.NewListener(s.listener, tlsConfig)
+	}
+	s.state, s.err = stateRunning, nil
+	listener := s.listener
+	s.mutex.Unlock()
+
+	s.serveTCP(listener)
+	return true
+}
+
+// listenFailed records the error, it must be called with the mutex locked,
+// and unlocks it.
+func (s *Server) listenFailed(err error) bool {
+	logger.Errorf("%s listen on %s port %d failed: %v", s.name, s.spec.Protocol, s.spec.Port, err)
+	s.state, s.err = stateFailed, err
+	s.mutex.Unlock()
+	return false
+}
+
+func (s *Server) serveTCP(l net.Listener) {
+	var tempDelay time.Duration
+
+	for {
+		conn, err := l.Accept()
+		if err == nil {
+			tempDelay = 0
+			go s.handleConn(conn)
+			continue
+		}
+
+		select {
+		case <-s.done:
+			return
+		default:
+		}
+
+		if ne, ok := err.(net.Error); ok && ne.Temporary() {
+			if tempDelay == 0 {
+				tempDelay = 5 * time.Millisecond
+			} else {
+				tempDelay *= 2
+			}
+			if max := 1 * time.Second; tempDelay > max {
+				tempDelay = max
+			}
+			time.Sleep(tempDelay)
+			continue
+		}
+
+		logger.Errorf("%s accept failed: %v", s.name, err)
+		s.setFailed(err)
+		return
+	}
+}
+
+func (s *Server) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, s.spec.MaxMessageSize)
+
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+
+			logger.Errorf("%s read failed: %v", s.name, err)
+			s.setFailed(err)
+			return
+		}
+
+		ip := addr.String()
+		if host, _, err := net.SplitHostPort(ip); err == nil {
+			ip = host
+		}
+		if s.ipFilter != nil && !s.ipFilter.Allow(ip) {
+			atomic.AddUint64(&s.rejectedConns, 1)
+			continue
+		}
+
+		s.handleMessage(buf[:n], ip)
+	}
+}
+
+func (s *Server) setFailed(err error) {
+	s.mutex.Lock()
+	s.state, s.err = stateFailed, err
+	s.mutex.Unlock()
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	atomic.AddInt64(&s.activeConns, 1)
+	atomic.AddUint64(&s.totalConns, 1)
+	defer atomic.AddInt64(&s.activeConns, -1)
+	defer conn.Close()
+
+	ip := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if s.ipFilter != nil && !s.ipFilter.Allow(ip) {
+		atomic.AddUint64(&s.rejectedConns, 1)
+		return
+	}
+
+	fr := newFrameReader(conn, int(s.spec.MaxMessageSize))
+	for {
+		msg, err := fr.next()
+		if err != nil {
+			if err != io.EOF {
+				logger.Debugf("%s read message from %s failed: %v", s.name, ip, err)
+			}
+			return
+		}
+		s.handleMessage(msg, ip)
+	}
+}
+
+// handleMessage parses the message, and forwards it if it matches the
+// filter, msg is only valid during the call.
+func (s *Server) handleMessage(msg []byte, ip string) {
+	atomic.AddUint64(&s.received, 1)
+
+	r, err := parseRecord(msg)
+	if err != nil {
+		atomic.AddUint64(&s.parseErrors, 1)
+		logger.Debugf("%s parse message from %s failed: %v", s.name, ip, err)
+		return
+	}
+
+	if s.filter != nil && !s.filter.match(r) {
+		atomic.AddUint64(&s.filtered, 1)
+		return
+	}
+
+	r.SourceIP = ip
+	r.ReceivedAt = time.Now().Format(time.RFC3339Nano)
+	if len(s.spec.Fields) > 0 {
+		r.Fields = s.spec.Fields
+	}
+
+	s.forwarder.enqueue(r)
+}
+
+// frameReader reads the messages of a TCP connection, both the octet
+// counting and the non-transparent (LF delimited) framing are supported.
+// Reference: https://tools.ietf.org/html/rfc6587#section-3.4
+type frameReader struct {
+	r       *bufio.Reader
+	maxSize int
+	buf     []byte
+}
+
+func newFrameReader(r io.Reader, maxSize int) *frameReader {
+	return &frameReader{
+		r:       bufio.NewReaderSize(r, maxSize),
+		maxSize: maxSize,
+	}
+}
+
+// next returns the next message, it is only valid before the next call.
+func (fr *frameReader) next() ([]byte, error) {
+	for {
+		c, err := fr.r.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+
+		if c[0] >= '1' && c[0] <= '9' {
+			return fr.nextOctetCounting()
+		}
+
+		line, err := fr.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return nil, fmt.Errorf("message exceeds %d bytes", fr.maxSize)
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			return nil, err
+		}
+
+		line = trimNewline(line)
+		if len(line) > 0 {
+			return line, nil
+		}
+	}
+}
+
+func (fr *frameReader) nextOctetCounting() ([]byte, error) {
+	s, err := fr.r.ReadSlice(' ')
+	if err != nil {
+		return nil, fmt.Errorf("read message length failed: %v", err)
+	}
+
+	n, err := strconv.Atoi(string(s[:len(s)-1]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid message length %q", s[:len(s)-1])
+	}
+	if n > fr.maxSize {
+		return nil, fmt.Errorf("message length %d exceeds %d bytes", n, fr.maxSize)
+	}
+
+	if cap(fr.buf) < n {
+		fr.buf = make([]byte, n)
+	}
+	fr.buf = fr.buf[:n]
+	if _, err := io.ReadFull(fr.r, fr.buf); err != nil {
+		return nil, err
+	}
+	return fr.buf, nil
+}
+
+func trimNewline(line []byte) []byte {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// Status returns the status of the server.
+func (s *Server) Status() *Status {
+	s.mutex.Lock()
+	state, err := s.state, s.err
+	s.mutex.Unlock()
+
+	status := &Status{
+		State:               state,
+		ActiveConnections:   atomic.LoadInt64(&s.activeConns),
+		TotalConnections:    atomic.LoadUint64(&s.totalConns),
+		RejectedConnections: atomic.LoadUint64(&s.rejectedConns),
+		Received:            atomic.LoadUint64(&s.received),
+		ParseErrors:         atomic.LoadUint64(&s.parseErrors),
+		Filtered:            atomic.LoadUint64(&s.filtered),
+		Dropped:             atomic.LoadUint64(&s.forwarder.dropped),
+		Sinks:               s.forwarder.status(),
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	return status
+}
+
+// Close closes the listener, established connections are kept until
+// either side closes them, the queued records are flushed in the
+// background.
+func (s *Server) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	close(s.done)
+	s.state = stateClosed
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	if s.packetConn != nil {
+		s.packetConn.Close()
+	}
+	s.forwarder.close()
+}
@@ -0,0 +1,213 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslogserver
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type mockSink struct {
+	mutex   sync.Mutex
+	records []*Record
+}
+
+func (ms *mockSink) name() string {
+	return "mock"
+}
+
+func (ms *mockSink) send(records []*Record) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ms.records = append(ms.records, records...)
+	return nil
+}
+
+func (ms *mockSink) close() {}
+
+func (ms *mockSink) count() int {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return len(ms.records)
+}
+
+func TestFrameReader(t *testing.T) {
+	data := "<34>1 - a - - - - one\n" +
+		"21 <34>1 - b - - - - two" +
+		"\r\n\n" +
+		"25 <34>1 - c - - - - three\nx" +
+		"<34>1 - d - - - - four"
+
+	fr := newFrameReader(strings.NewReader(data), 1024)
+	expected := []string{
+		"<34>1 - a - - - - one",
+		"<34>1 - b - - - - two",
+		"<34>1 - c - - - - three\nx",
+		"<34>1 - d - - - - four",
+	}
+	for _, want := range expected {
+		msg, err := fr.next()
+		if err != nil {
+			t.Fatalf("read %q failed: %v", want, err)
+		}
+		if string(msg) != want {
+			t.Errorf("message should be %q, but is %q", want, msg)
+		}
+	}
+	if _, err := fr.next(); err == nil {
+		t.Errorf("read should fail at the end")
+	}
+
+	fr = newFrameReader(strings.NewReader("2000 <34>1"), 1024)
+	if _, err := fr.next(); err == nil {
+		t.Errorf("message exceeding max size should fail")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f := newFilter(&FilterSpec{
+		Severity:      "warning",
+		AppNames:      []string{"nginx", "app"},
+		MessageRegexp: "error|timeout",
+	})
+
+	cases := []struct {
+		r     *Record
+		match bool
+	}{
+		{r: &Record{Severity: 3, AppName: "nginx", Message: "upstream timeout"}, match: true},
+		{r: &Record{Severity: 4, AppName: "app", Message: "error"}, match: true},
+		{r: &Record{Severity: 6, AppName: "app", Message: "error"}, match: false},
+		{r: &Record{Severity: 3, AppName: "sshd", Message: "error"}, match: false},
+		{r: &Record{Severity: 3, AppName: "app", Message: "ok"}, match: false},
+	}
+	for i, c := range cases {
+		if got := f.match(c.r); got != c.match {
+			t.Errorf("case %d: match should be %v", i, c.match)
+		}
+	}
+}
+
+func TestHandleConn(t *testing.T) {
+	sink := &mockSink{}
+	spec := &Spec{
+		MaxMessageSize: 1024,
+		QueueSize:      10,
+		BatchSize:      2,
+		FlushInterval:  "10ms",
+		Filter:         &FilterSpec{Severity: "err"},
+		Fields:         map[string]string{"env": "test"},
+	}
+	s := &Server{
+		name:      "syslog",
+		spec:      spec,
+		filter:    newFilter(spec.Filter),
+		forwarder: newForwarder("syslog", spec, []sink{sink}),
+		done:      make(chan struct{}),
+	}
+	defer s.forwarder.close()
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		s.handleConn(server)
+		close(done)
+	}()
+
+	w := bufio.NewWriter(client)
+	w.WriteString("<11>1 - host app - - - failed\n")
+	w.WriteString("<14>1 - host app - - - info\n")
+	w.WriteString("invalid\n")
+	w.WriteString("<10>1 - host app - - - critical\n")
+	w.Flush()
+	client.Close()
+	<-done
+
+	for i := 0; i < 100 && sink.count() < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	status := s.Status()
+	if status.Received != 4 || status.ParseErrors != 1 || status.Filtered != 1 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+	if sink.count() != 2 {
+		t.Fatalf("2 records should be sent, but got %d", sink.count())
+	}
+	r := sink.records[0]
+	if r.Message != "failed" || r.SourceIP == "" || r.Fields["env"] != "test" {
+		t.Errorf("unexpected record: %+v", r)
+	}
+}
+
+func TestElasticsearchSink(t *testing.T) {
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/_bulk" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if u, p, _ := r.BasicAuth(); u != "user" || p != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	}))
+	defer ts.Close()
+
+	es := newElasticsearchSink(&ElasticsearchSpec{
+		// The first server is down, the records should be sent to the
+		// second one.
+		Servers:  []string{"http://127.0.0.1:1", ts.URL + "/"},
+		Index:    "logs",
+		Username: "user",
+		Password: "pass",
+	})
+	defer es.close()
+
+	err := es.send([]*Record{{Message: "a"}, {Message: "b"}})
+	if err != nil {
+		t.Fatalf("send failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("bulk body should have 4 lines, but is %q", body)
+	}
+	if lines[0] != `{"index":{"_index":"logs"}}` || !strings.Contains(lines[3], `"message":"b"`) {
+		t.Errorf("unexpected bulk body: %q", body)
+	}
+}
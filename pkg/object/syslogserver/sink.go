@@ -0,0 +1,360 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslogserver
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+type (
+	// sink sends records to the storage, it is only called by the
+	// goroutine of forwarder, so it needs not to be thread safe.
+	sink interface {
+		name() string
+		send(records []*Record) error
+		close()
+	}
+
+	kafkaSink struct {
+		clientID string
+		spec     *KafkaSpec
+		producer sarama.AsyncProducer
+		done     chan struct{}
+	}
+
+	elasticsearchSink struct {
+		spec    *ElasticsearchSpec
+		client  *http.Client
+		next    int
+		header  []byte
+		servers []string
+	}
+
+	// forwarder queues the records and sends them to the sinks in
+	// batches.
+	forwarder struct {
+		name          string
+		sinks         []sink
+		batchSize     int
+		flushInterval time.Duration
+
+		queue   chan *Record
+		dropped uint64
+		stats   sync.Map // sink name -> *sinkStat
+
+		done   chan struct{}
+		closed chan struct{}
+	}
+
+	sinkStat struct {
+		sent    uint64
+		failed  uint64
+		lastErr atomic.Value // string
+	}
+
+	// SinkStatus is the status of a sink.
+	SinkStatus struct {
+		Sent      uint64 `yaml:"sent"`
+		Failed    uint64 `yaml:"failed"`
+		LastError string `yaml:"lastError,omitempty"`
+	}
+)
+
+func newKafkaSink(clientID string, spec *KafkaSpec) *kafkaSink {
+	return &kafkaSink{
+		clientID: clientID,
+		spec:     spec,
+		done:     make(chan struct{}),
+	}
+}
+
+func (ks *kafkaSink) name() string {
+	return "kafka"
+}
+
+func (ks *kafkaSink) getProducer() (sarama.AsyncProducer, error) {
+	if ks.producer != nil {
+		return ks.producer, nil
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = ks.clientID
+	config.Version = sarama.V0_10_2_0
+
+	producer, err := sarama.NewAsyncProducer(ks.spec.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("start sarama producer failed(brokers: %v): %v",
+			ks.spec.Brokers, err)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ks.done:
+				return
+			case err, ok := <-producer.Errors():
+				if !ok {
+					return
+				}
+				logger.Errorf("%s produce record to kafka failed: %v", ks.clientID, err)
+			}
+		}
+	}()
+
+	ks.producer = producer
+	return producer, nil
+}
+
+func (ks *kafkaSink) send(records []*Record) error {
+	producer, err := ks.getProducer()
+	if err != nil {
+		return err
+	}
+
+	for _, r := range records {
+		buff, err := jsoniter.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal record failed: %v", err)
+		}
+		producer.Input() <- &sarama.ProducerMessage{
+			Topic: ks.spec.Topic,
+			Key:   sarama.StringEncoder(r.Hostname),
+			Value: sarama.ByteEncoder(buff),
+		}
+	}
+	return nil
+}
+
+func (ks *kafkaSink) close() {
+	close(ks.done)
+	if ks.producer == nil {
+		return
+	}
+	if err := ks.producer.Close(); err != nil {
+		logger.Errorf("%s close kafka producer failed: %v", ks.clientID, err)
+	}
+}
+
+func newElasticsearchSink(spec *ElasticsearchSpec) *elasticsearchSink {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: spec.InsecureSkipVerify}
+
+	es := &elasticsearchSink{
+		spec: spec,
+		client: &http.Client{
+			Timeout:   parseDuration(spec.Timeout, defaultElasticsearchTimeout),
+			Transport: transport,
+		},
+	}
+
+	es.header, _ = jsoniter.Marshal(map[string]interface{}{
+		"index": map[string]string{"_index": spec.Index},
+	})
+	for _, s := range spec.Servers {
+		es.servers = append(es.servers, strings.TrimSuffix(s, "/")+"/_bulk")
+	}
+
+	return es
+}
+
+func (es *elasticsearchSink) name() string {
+	return "elasticsearch"
+}
+
+func (es *elasticsearchSink) send(records []*Record) error {
+	var body bytes.Buffer
+	for _, r := range records {
+		doc, err := jsoniter.Marshal(r)
+		if err != nil {
+			return fmt.Errorf("marshal record failed: %v", err)
+		}
+		body.Write(es.header)
+		body.WriteByte('\n')
+		body.Write(doc)
+		body.WriteByte('\n')
+	}
+
+	// Servers are chosen in round robin, the next one is tried if the
+	// current one fails.
+	var err error
+	for i := 0; i < len(es.servers); i++ {
+		server := es.servers[es.next]
+		es.next = (es.next + 1) % len(es.servers)
+
+		if err = es.bulk(server, body.Bytes()); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (es *elasticsearchSink) bulk(server string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, server, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create bulk request failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	if es.spec.Username != "" {
+		req.SetBasicAuth(es.spec.Username, es.spec.Password)
+	}
+
+	resp, err := es.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post bulk request to %s failed: %v", server, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("read bulk response of %s failed: %v", server, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s responded with status code %d", server, resp.StatusCode)
+	}
+
+	result := struct {
+		Errors bool `json:"errors"`
+	}{}
+	if jsoniter.Unmarshal(respBody, &result) == nil && result.Errors {
+		return fmt.Errorf("%s failed to index some records", server)
+	}
+	return nil
+}
+
+func (es *elasticsearchSink) close() {
+	es.client.CloseIdleConnections()
+}
+
+func newForwarder(name string, spec *Spec, sinks []sink) *forwarder {
+	f := &forwarder{
+		name:          name,
+		sinks:         sinks,
+		batchSize:     int(spec.BatchSize),
+		flushInterval: parseDuration(spec.FlushInterval, defaultFlushInterval),
+		queue:         make(chan *Record, spec.QueueSize),
+		done:          make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+
+	for _, s := range sinks {
+		f.stats.Store(s.name(), &sinkStat{})
+	}
+
+	go f.run()
+	return f
+}
+
+// enqueue queues the record, it is dropped if the queue is full.
+func (f *forwarder) enqueue(r *Record) {
+	select {
+	case f.queue <- r:
+	default:
+		atomic.AddUint64(&f.dropped, 1)
+	}
+}
+
+func (f *forwarder) run() {
+	defer close(f.closed)
+
+	ticker := time.NewTicker(f.flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Record, 0, f.batchSize)
+	for {
+		select {
+		case r := <-f.queue:
+			batch = append(batch, r)
+			if len(batch) < f.batchSize {
+				continue
+			}
+		case <-ticker.C:
+			if len(batch) == 0 {
+				continue
+			}
+		case <-f.done:
+			// Drain the queued records before closing the sinks.
+			for len(f.queue) > 0 {
+				batch = append(batch, <-f.queue)
+				if len(batch) == f.batchSize {
+					f.flush(batch)
+					batch = batch[:0]
+				}
+			}
+			if len(batch) > 0 {
+				f.flush(batch)
+			}
+			for _, s := range f.sinks {
+				s.close()
+			}
+			return
+		}
+
+		f.flush(batch)
+		batch = batch[:0]
+	}
+}
+
+func (f *forwarder) flush(batch []*Record) {
+	for _, s := range f.sinks {
+		v, _ := f.stats.Load(s.name())
+		stat := v.(*sinkStat)
+
+		if err := s.send(batch); err != nil {
+			logger.Errorf("%s send %d records to %s failed: %v", f.name, len(batch), s.name(), err)
+			atomic.AddUint64(&stat.failed, uint64(len(batch)))
+			stat.lastErr.Store(err.Error())
+			continue
+		}
+		atomic.AddUint64(&stat.sent, uint64(len(batch)))
+	}
+}
+
+func (f *forwarder) status() map[string]*SinkStatus {
+	status := map[string]*SinkStatus{}
+	f.stats.Range(func(key, value interface{}) bool {
+		stat := value.(*sinkStat)
+		s := &SinkStatus{
+			Sent:   atomic.LoadUint64(&stat.sent),
+			Failed: atomic.LoadUint64(&stat.failed),
+		}
+		s.LastError, _ = stat.lastErr.Load().(string)
+		status[key.(string)] = s
+		return true
+	})
+	return status
+}
+
+// close flushes the queued records in the background, and closes the
+// sinks after that.
+func (f *forwarder) close() {
+	close(f.done)
+}
@@ -0,0 +1,220 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslogserver
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/ipfilter"
+	"github.com/megaease/easegress/pkg/util/stringtool"
+)
+
+const (
+	protocolTCP = "tcp"
+	protocolUDP = "udp"
+
+	// maxUDPMessageSize is the max payload size of UDP datagrams.
+	maxUDPMessageSize = 65507
+
+	defaultFlushInterval        = time.Second
+	defaultElasticsearchTimeout = 10 * time.Second
+)
+
+type (
+	// Spec describes the SyslogServer.
+	Spec struct {
+		Port     uint16 `yaml:"port" jsonschema:"required,minimum=1"`
+		Protocol string `yaml:"protocol" jsonschema:"omitempty,enum=tcp,enum=udp"`
+		// CertBase64 and KeyBase64 enable TLS for the tcp protocol.
+		CertBase64     string         `yaml:"certBase64" jsonschema:"omitempty,format=base64"`
+		KeyBase64      string         `yaml:"keyBase64" jsonschema:"omitempty,format=base64"`
+		MaxConnections uint32         `yaml:"maxConnections" jsonschema:"omitempty,minimum=1"`
+		MaxMessageSize uint32         `yaml:"maxMessageSize" jsonschema:"omitempty,minimum=480"`
+		IPFilter       *ipfilter.Spec `yaml:"ipFilter,omitempty" jsonschema:"omitempty"`
+
+		Filter *FilterSpec `yaml:"filter,omitempty" jsonschema:"omitempty"`
+		// Fields are added to every record for enrichment.
+		Fields map[string]string `yaml:"fields" jsonschema:"omitempty"`
+
+		QueueSize     uint32 `yaml:"queueSize" jsonschema:"omitempty,minimum=1"`
+		BatchSize     uint32 `yaml:"batchSize" jsonschema:"omitempty,minimum=1"`
+		FlushInterval string `yaml:"flushInterval" jsonschema:"omitempty,format=duration"`
+
+		Kafka         *KafkaSpec         `yaml:"kafka,omitempty" jsonschema:"omitempty"`
+		Elasticsearch *ElasticsearchSpec `yaml:"elasticsearch,omitempty" jsonschema:"omitempty"`
+	}
+
+	// FilterSpec describes the conditions of the records to forward, all
+	// the non-empty conditions must be satisfied.
+	FilterSpec struct {
+		// Severity is the least severe level to forward, e.g. warning
+		// forwards warning, err, crit, alert and emerg.
+		Severity      string   `yaml:"severity" jsonschema:"omitempty,enum=emerg,enum=alert,enum=crit,enum=err,enum=warning,enum=notice,enum=info,enum=debug"`
+		Facilities    []uint8  `yaml:"facilities" jsonschema:"omitempty,uniqueItems=true"`
+		AppNames      []string `yaml:"appNames" jsonschema:"omitempty,uniqueItems=true"`
+		MessageRegexp string   `yaml:"messageRegexp" jsonschema:"omitempty,format=regexp"`
+	}
+
+	// KafkaSpec describes the Kafka sink, every record is sent as a JSON
+	// message keyed by its hostname.
+	KafkaSpec struct {
+		Brokers []string `yaml:"brokers" jsonschema:"required,uniqueItems=true"`
+		Topic   string   `yaml:"topic" jsonschema:"required"`
+	}
+
+	// ElasticsearchSpec describes the Elasticsearch sink, records are
+	// indexed by the bulk API.
+	ElasticsearchSpec struct {
+		// Servers are the URLs of the nodes, requests are load balanced
+		// among them in round robin, and retried on the next one on failure.
+		Servers  []string `yaml:"servers" jsonschema:"required,uniqueItems=true"`
+		Index    string   `yaml:"index" jsonschema:"required"`
+		Username string   `yaml:"username" jsonschema:"omitempty"`
+		Password string   `yaml:"password" jsonschema:"omitempty"`
+		Timeout  string   `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+		// InsecureSkipVerify skips the verification of server certificates.
+		InsecureSkipVerify bool `yaml:"insecureSkipVerify" jsonschema:"omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Kafka == nil && spec.Elasticsearch == nil {
+		return fmt.Errorf("both kafka and elasticsearch are empty")
+	}
+
+	if spec.tlsEnabled() {
+		if spec.Protocol == protocolUDP {
+			return fmt.Errorf("tls is not supported by udp")
+		}
+		if _, err := spec.tlsConfig(); err != nil {
+			return err
+		}
+	}
+
+	if spec.Protocol == protocolUDP && spec.MaxMessageSize > maxUDPMessageSize {
+		return fmt.Errorf("maxMessageSize of udp must not exceed %d", maxUDPMessageSize)
+	}
+
+	return nil
+}
+
+// Validate validates ElasticsearchSpec.
+func (spec *ElasticsearchSpec) Validate() error {
+	for _, s := range spec.Servers {
+		u, err := url.Parse(s)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid server %s", s)
+		}
+	}
+	if (spec.Username == "") != (spec.Password == "") {
+		return fmt.Errorf("username and password must be specified together")
+	}
+	return nil
+}
+
+func (spec *Spec) tlsEnabled() bool {
+	return spec.CertBase64 != "" || spec.KeyBase64 != ""
+}
+
+func (spec *Spec) tlsConfig() (*tls.Config, error) {
+	certPem, _ := base64.StdEncoding.DecodeString(spec.CertBase64)
+	keyPem, _ := base64.StdEncoding.DecodeString(spec.KeyBase64)
+	cert, err := tls.X509KeyPair(certPem, keyPem)
+	if err != nil {
+		return nil, fmt.Errorf("generate x509 key pair failed: %v", err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+// filter is the compiled FilterSpec.
+type filter struct {
+	maxSeverity uint8
+	facilities  []uint8
+	appNames    []string
+	re          *regexp.Regexp
+}
+
+func newFilter(spec *FilterSpec) *filter {
+	f := &filter{
+		maxSeverity: uint8(len(severityNames) - 1),
+		facilities:  spec.Facilities,
+		appNames:    spec.AppNames,
+	}
+
+	for i, name := range severityNames {
+		if name == spec.Severity {
+			f.maxSeverity = uint8(i)
+		}
+	}
+
+	if spec.MessageRegexp != "" {
+		// NOTE: The regexp has been validated by the jsonschema.
+		f.re = regexp.MustCompile(spec.MessageRegexp)
+	}
+
+	return f
+}
+
+func (f *filter) match(r *Record) bool {
+	if r.Severity > f.maxSeverity {
+		return false
+	}
+
+	if len(f.facilities) > 0 {
+		found := false
+		for _, facility := range f.facilities {
+			if facility == r.Facility {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.appNames) > 0 && !stringtool.StrInSlice(r.AppName, f.appNames) {
+		return false
+	}
+
+	if f.re != nil && !f.re.MatchString(r.Message) {
+		return false
+	}
+
+	return true
+}
+
+func parseDuration(s string, d time.Duration) time.Duration {
+	if s == "" {
+		return d
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", s, err)
+		return d
+	}
+	return v
+}
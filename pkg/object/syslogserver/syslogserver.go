@@ -0,0 +1,96 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package syslogserver
+
+import (
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of SyslogServer.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of SyslogServer.
+	Kind = "SyslogServer"
+)
+
+func init() {
+	supervisor.Register(&SyslogServer{})
+}
+
+type (
+	// SyslogServer receives syslog messages over TCP, UDP or TLS, and
+	// forwards the records to Kafka or Elasticsearch after filtering and
+	// enrichment.
+	SyslogServer struct {
+		superSpec *supervisor.Spec
+		spec      *Spec
+		server    *Server
+	}
+)
+
+// Category returns the category of SyslogServer.
+func (ss *SyslogServer) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of SyslogServer.
+func (ss *SyslogServer) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of SyslogServer.
+func (ss *SyslogServer) DefaultSpec() interface{} {
+	return &Spec{
+		Protocol:       protocolTCP,
+		MaxConnections: 1024,
+		MaxMessageSize: 8192,
+		QueueSize:      10000,
+		BatchSize:      500,
+		FlushInterval:  "1s",
+	}
+}
+
+// Init initializes SyslogServer.
+func (ss *SyslogServer) Init(superSpec *supervisor.Spec) {
+	ss.superSpec, ss.spec = superSpec, superSpec.ObjectSpec().(*Spec)
+	ss.reload()
+}
+
+// Inherit inherits previous generation of SyslogServer.
+func (ss *SyslogServer) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	previousGeneration.Close()
+	ss.Init(superSpec)
+}
+
+func (ss *SyslogServer) reload() {
+	ss.server = newServer(ss.superSpec.Name(), ss.spec)
+	go ss.server.run()
+}
+
+// Status returns the status of SyslogServer.
+func (ss *SyslogServer) Status() *supervisor.Status {
+	return &supervisor.Status{
+		ObjectStatus: ss.server.Status(),
+	}
+}
+
+// Close closes SyslogServer.
+func (ss *SyslogServer) Close() {
+	ss.server.Close()
+}
@@ -0,0 +1,254 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package usagemeter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	jsoniter "github.com/json-iterator/go"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+
+type (
+	// exporter exports usages, it is only called by the goroutine of
+	// UsageMeter, so it needs not to be thread safe.
+	exporter interface {
+		export(usages []*Usage) error
+		close()
+	}
+
+	// WebhookSpec is the spec of the webhook exporter, the usages are
+	// posted to the URL as a JSON array.
+	WebhookSpec struct {
+		URL     string            `yaml:"url" jsonschema:"required,format=url"`
+		Headers map[string]string `yaml:"headers" jsonschema:"omitempty"`
+		Timeout string            `yaml:"timeout" jsonschema:"omitempty,format=duration"`
+	}
+
+	// KafkaSpec is the spec of the Kafka exporter, every usage is sent as
+	// a JSON message.
+	KafkaSpec struct {
+		Brokers []string `yaml:"brokers" jsonschema:"required,uniqueItems=true"`
+		Topic   string   `yaml:"topic" jsonschema:"required"`
+	}
+
+	// CSVSpec is the spec of the CSV exporter, the usages are appended to
+	// the file.
+	CSVSpec struct {
+		// FileName is the name of the file, relative to the data dir.
+		FileName string `yaml:"fileName" jsonschema:"required"`
+	}
+
+	webhookExporter struct {
+		spec   *WebhookSpec
+		client *http.Client
+	}
+
+	kafkaExporter struct {
+		clientID string
+		spec     *KafkaSpec
+		producer sarama.AsyncProducer
+		done     chan struct{}
+	}
+
+	csvExporter struct {
+		path string
+	}
+)
+
+var csvHeader = []string{
+	"startTime", "endTime", "node", "server", "consumer",
+	"requests", "errors", "reqBytes", "respBytes",
+}
+
+// Validate validates WebhookSpec.
+func (spec *WebhookSpec) Validate() error {
+	if spec.Timeout != "" {
+		if _, err := time.ParseDuration(spec.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %s: %v", spec.Timeout, err)
+		}
+	}
+	return nil
+}
+
+func newWebhookExporter(spec *WebhookSpec) *webhookExporter {
+	timeout := defaultWebhookTimeout
+	if spec.Timeout != "" {
+		timeout, _ = time.ParseDuration(spec.Timeout)
+	}
+	return &webhookExporter{
+		spec:   spec,
+		client: &http.Client{Timeout: timeout, Transport: egressproxy.DefaultTransport},
+	}
+}
+
+func (we *webhookExporter) export(usages []*Usage) error {
+	body, err := jsoniter.Marshal(usages)
+	if err != nil {
+		return fmt.Errorf("marshal usages failed: %v", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, we.spec.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("create webhook request failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range we.spec.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := we.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("post usages to webhook failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (we *webhookExporter) close() {
+	we.client.CloseIdleConnections()
+}
+
+func newKafkaExporter(clientID string, spec *KafkaSpec) *kafkaExporter {
+	return &kafkaExporter{
+		clientID: clientID,
+		spec:     spec,
+		done:     make(chan struct{}),
+	}
+}
+
+func (ke *kafkaExporter) getProducer() (sarama.AsyncProducer, error) {
+	if ke.producer != nil {
+		return ke.producer, nil
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = ke.clientID
+	config.Version = sarama.V0_10_2_0
+
+	producer, err := sarama.NewAsyncProducer(ke.spec.Brokers, config)
+	if err != nil {
+		return nil, fmt.Errorf("start sarama producer failed(brokers: %v): %v",
+			ke.spec.Brokers, err)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-ke.done:
+				return
+			case err, ok := <-producer.Errors():
+				if !ok {
+					return
+				}
+				logger.Errorf("%s produce usage to kafka failed: %v", ke.clientID, err)
+			}
+		}
+	}()
+
+	ke.producer = producer
+	return producer, nil
+}
+
+func (ke *kafkaExporter) export(usages []*Usage) error {
+	producer, err := ke.getProducer()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range usages {
+		buff, err := jsoniter.Marshal(u)
+		if err != nil {
+			return fmt.Errorf("marshal usage failed: %v", err)
+		}
+		producer.Input() <- &sarama.ProducerMessage{
+			Topic: ke.spec.Topic,
+			Value: sarama.ByteEncoder(buff),
+		}
+	}
+	return nil
+}
+
+func (ke *kafkaExporter) close() {
+	close(ke.done)
+	if ke.producer == nil {
+		return
+	}
+	if err := ke.producer.Close(); err != nil {
+		logger.Errorf("%s close kafka producer failed: %v", ke.clientID, err)
+	}
+}
+
+func newCSVExporter(dataDir string, spec *CSVSpec) *csvExporter {
+	path := spec.FileName
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dataDir, path)
+	}
+	return &csvExporter{path: path}
+}
+
+func (ce *csvExporter) export(usages []*Usage) error {
+	f, err := os.OpenFile(ce.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("open %s failed: %v", ce.path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s failed: %v", ce.path, err)
+	}
+
+	w := csv.NewWriter(f)
+	if info.Size() == 0 {
+		w.Write(csvHeader)
+	}
+	for _, u := range usages {
+		w.Write([]string{
+			u.StartTime, u.EndTime, u.Node, u.Server, u.Consumer,
+			strconv.FormatUint(u.Requests, 10),
+			strconv.FormatUint(u.Errors, 10),
+			strconv.FormatUint(u.ReqBytes, 10),
+			strconv.FormatUint(u.RespBytes, 10),
+		})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("write %s failed: %v", ce.path, err)
+	}
+	return nil
+}
+
+func (ce *csvExporter) close() {}
@@ -0,0 +1,155 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package usagemeter
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/object/statussynccontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+type (
+	// meter turns the cumulative statistics of consumers into the usages
+	// since the last collection.
+	meter struct {
+		mutex sync.Mutex
+
+		lastTimestamp int64
+		last          map[usageKey]*counters
+	}
+
+	usageKey struct {
+		server   string
+		consumer string
+	}
+
+	counters struct {
+		requests  uint64
+		errors    uint64
+		reqBytes  uint64
+		respBytes uint64
+	}
+
+	// Usage is the usage of a consumer of an HTTPServer in a period.
+	Usage struct {
+		StartTime string `json:"startTime"`
+		EndTime   string `json:"endTime"`
+		Node      string `json:"node"`
+		// Server is in format <namespace>/<name>.
+		Server string `json:"server"`
+		// Consumer is in format [<tenant>/]<consumer or subject>.
+		Consumer  string `json:"consumer"`
+		Requests  uint64 `json:"requests"`
+		Errors    uint64 `json:"errors"`
+		ReqBytes  uint64 `json:"reqBytes"`
+		RespBytes uint64 `json:"respBytes"`
+	}
+)
+
+func newMeter() *meter {
+	return &meter{}
+}
+
+// collect returns the usages since the last collection, the first
+// collection only sets up the baseline.
+func (m *meter) collect(record *statussynccontroller.StatusesRecord, node string) []*Usage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if record.UnixTimestamp <= m.lastTimestamp {
+		return nil
+	}
+
+	current := map[usageKey]*counters{}
+	for _, status := range record.Statuses {
+		s, ok := status.ObjectStatus.(*trafficcontroller.StatusInSameNamespace)
+		if !ok {
+			continue
+		}
+		for name, server := range s.HTTPServers {
+			if server.Status == nil {
+				continue
+			}
+			for _, c := range server.Status.Consumers {
+				key := usageKey{server: s.Namespace + "/" + name, consumer: c.Consumer}
+				current[key] = newCounters(c.Status)
+			}
+		}
+	}
+
+	var usages []*Usage
+	if m.last != nil {
+		startTime := time.Unix(m.lastTimestamp, 0).Format(time.RFC3339)
+		endTime := time.Unix(record.UnixTimestamp, 0).Format(time.RFC3339)
+		for key, c := range current {
+			delta := c.sub(m.last[key])
+			if delta.requests == 0 {
+				continue
+			}
+			usages = append(usages, &Usage{
+				StartTime: startTime,
+				EndTime:   endTime,
+				Node:      node,
+				Server:    key.server,
+				Consumer:  key.consumer,
+				Requests:  delta.requests,
+				Errors:    delta.errors,
+				ReqBytes:  delta.reqBytes,
+				RespBytes: delta.respBytes,
+			})
+		}
+	}
+
+	sort.Slice(usages, func(i, j int) bool {
+		if usages[i].Server != usages[j].Server {
+			return usages[i].Server < usages[j].Server
+		}
+		return usages[i].Consumer < usages[j].Consumer
+	})
+
+	m.last, m.lastTimestamp = current, record.UnixTimestamp
+	return usages
+}
+
+func newCounters(s *httpstat.Status) *counters {
+	return &counters{
+		requests:  s.Count,
+		errors:    s.ErrCount,
+		reqBytes:  s.ReqSize,
+		respBytes: s.RespSize,
+	}
+}
+
+// sub returns c - prev, c itself is returned if the counters were reset,
+// e.g. the HTTPServer restarted.
+func (c *counters) sub(prev *counters) *counters {
+	if prev == nil || c.requests < prev.requests || c.errors < prev.errors ||
+		c.reqBytes < prev.reqBytes || c.respBytes < prev.respBytes {
+		return c
+	}
+	return &counters{
+		requests:  c.requests - prev.requests,
+		errors:    c.errors - prev.errors,
+		reqBytes:  c.reqBytes - prev.reqBytes,
+		respBytes: c.respBytes - prev.respBytes,
+	}
+}
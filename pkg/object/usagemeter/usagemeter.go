@@ -0,0 +1,235 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package usagemeter
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/object/statussynccontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+)
+
+const (
+	// Category is the category of UsageMeter.
+	Category = supervisor.CategoryBusinessController
+
+	// Kind is the kind of UsageMeter.
+	Kind = "UsageMeter"
+
+	defaultInterval = time.Minute
+	minInterval     = statussynccontroller.SyncStatusPaceInUnixSeconds * time.Second
+)
+
+func init() {
+	supervisor.Register(&UsageMeter{})
+}
+
+type (
+	// UsageMeter aggregates the usage of authenticated consumers of
+	// HTTPServers into periodic records, and exports them for billing.
+	UsageMeter struct {
+		super     *supervisor.Supervisor
+		superSpec *supervisor.Spec
+		spec      *Spec
+
+		ssc       *statussynccontroller.StatusSyncController
+		meter     *meter
+		exporters []exporter
+
+		mutex  sync.Mutex
+		status Status
+
+		done chan struct{}
+	}
+
+	// Spec describes the UsageMeter.
+	Spec struct {
+		// Interval is the interval of records, default is 1m.
+		Interval string       `yaml:"interval" jsonschema:"omitempty,format=duration"`
+		Webhook  *WebhookSpec `yaml:"webhook,omitempty" jsonschema:"omitempty"`
+		Kafka    *KafkaSpec   `yaml:"kafka,omitempty" jsonschema:"omitempty"`
+		CSV      *CSVSpec     `yaml:"csv,omitempty" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of UsageMeter.
+	Status struct {
+		Health string `yaml:"health"`
+		// LastExport is the time of the last export.
+		LastExport string `yaml:"lastExport,omitempty"`
+		// Records is the number of exported records.
+		Records uint64 `yaml:"records"`
+		// Error is the error of the last export.
+		Error string `yaml:"error,omitempty"`
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.Webhook == nil && spec.Kafka == nil && spec.CSV == nil {
+		return fmt.Errorf("none of webhook, kafka and csv is specified")
+	}
+
+	if spec.Interval != "" {
+		d, err := time.ParseDuration(spec.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid interval %s: %v", spec.Interval, err)
+		}
+		if d < minInterval {
+			return fmt.Errorf("interval must be at least %s", minInterval)
+		}
+	}
+
+	if spec.Webhook != nil {
+		if err := spec.Webhook.Validate(); err != nil {
+			return fmt.Errorf("webhook: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (spec *Spec) interval() time.Duration {
+	if spec.Interval == "" {
+		return defaultInterval
+	}
+	d, err := time.ParseDuration(spec.Interval)
+	if err != nil {
+		logger.Errorf("BUG: parse duration %s failed: %v", spec.Interval, err)
+		return defaultInterval
+	}
+	return d
+}
+
+// Category returns the category of UsageMeter.
+func (um *UsageMeter) Category() supervisor.ObjectCategory {
+	return Category
+}
+
+// Kind returns the kind of UsageMeter.
+func (um *UsageMeter) Kind() string {
+	return Kind
+}
+
+// DefaultSpec returns the default spec of UsageMeter.
+func (um *UsageMeter) DefaultSpec() interface{} {
+	return &Spec{}
+}
+
+// Init initializes UsageMeter.
+func (um *UsageMeter) Init(superSpec *supervisor.Spec) {
+	um.superSpec, um.spec, um.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	um.reload(newMeter())
+}
+
+// Inherit inherits previous generation of UsageMeter.
+func (um *UsageMeter) Inherit(superSpec *supervisor.Spec, previousGeneration supervisor.Object) {
+	// NOTE: The meter is inherited to keep the baseline of the counters,
+	// so no usage is lost or exported twice.
+	previous := previousGeneration.(*UsageMeter)
+	previous.Close()
+
+	um.superSpec, um.spec, um.super = superSpec, superSpec.ObjectSpec().(*Spec), superSpec.Super()
+	um.reload(previous.meter)
+}
+
+func (um *UsageMeter) reload(m *meter) {
+	ssc, exists := um.super.GetSystemController(statussynccontroller.Kind)
+	if !exists {
+		logger.Errorf("BUG: status sync controller not found")
+	}
+	um.ssc = ssc.Instance().(*statussynccontroller.StatusSyncController)
+
+	um.meter = m
+	um.exporters = nil
+	if um.spec.Webhook != nil {
+		um.exporters = append(um.exporters, newWebhookExporter(um.spec.Webhook))
+	}
+	if um.spec.Kafka != nil {
+		um.exporters = append(um.exporters, newKafkaExporter(um.superSpec.Name(), um.spec.Kafka))
+	}
+	if um.spec.CSV != nil {
+		um.exporters = append(um.exporters, newCSVExporter(um.super.Options().AbsDataDir, um.spec.CSV))
+	}
+
+	um.status.Health = "ready"
+	um.done = make(chan struct{})
+	go um.run()
+}
+
+func (um *UsageMeter) run() {
+	ticker := time.NewTicker(um.spec.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-um.done:
+			for _, e := range um.exporters {
+				e.close()
+			}
+			return
+		case <-ticker.C:
+			records := um.ssc.GetStatusesRecords()
+			if len(records) == 0 {
+				continue
+			}
+
+			usages := um.meter.collect(records[len(records)-1], um.super.Options().Name)
+			if len(usages) == 0 {
+				continue
+			}
+			um.export(usages)
+		}
+	}
+}
+
+func (um *UsageMeter) export(usages []*Usage) {
+	var errs []string
+	for _, e := range um.exporters {
+		if err := e.export(usages); err != nil {
+			logger.Errorf("%s export usages failed: %v", um.superSpec.Name(), err)
+			errs = append(errs, err.Error())
+		}
+	}
+
+	um.mutex.Lock()
+	defer um.mutex.Unlock()
+
+	um.status.LastExport = time.Now().Format(time.RFC3339)
+	um.status.Records += uint64(len(usages))
+	um.status.Error = ""
+	if len(errs) > 0 {
+		um.status.Error = fmt.Sprintf("%v", errs)
+	}
+}
+
+// Status returns the status of UsageMeter.
+func (um *UsageMeter) Status() *supervisor.Status {
+	um.mutex.Lock()
+	s := um.status
+	um.mutex.Unlock()
+
+	return &supervisor.Status{ObjectStatus: &s}
+}
+
+// Close closes UsageMeter.
+func (um *UsageMeter) Close() {
+	close(um.done)
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package usagemeter
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/object/httpserver"
+	"github.com/megaease/easegress/pkg/object/statussynccontroller"
+	"github.com/megaease/easegress/pkg/object/trafficcontroller"
+	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpstat"
+)
+
+func newRecord(timestamp int64, consumers map[string]uint64) *statussynccontroller.StatusesRecord {
+	status := &httpserver.Status{}
+	for name, count := range consumers {
+		status.Consumers = append(status.Consumers, &httpserver.ConsumerStatus{
+			Consumer: name,
+			Status: &httpstat.Status{
+				Count:    count,
+				ReqSize:  count * 10,
+				RespSize: count * 100,
+			},
+		})
+	}
+
+	return &statussynccontroller.StatusesRecord{
+		UnixTimestamp: timestamp,
+		Statuses: map[string]*supervisor.Status{
+			"default": {
+				ObjectStatus: &trafficcontroller.StatusInSameNamespace{
+					Namespace: "default",
+					HTTPServers: map[string]*trafficcontroller.HTTPServerStatus{
+						"server": {Status: status},
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestMeter(t *testing.T) {
+	m := newMeter()
+
+	if usages := m.collect(newRecord(100, map[string]uint64{"alice": 5}), "node"); len(usages) != 0 {
+		t.Fatalf("the first collection should only set up the baseline")
+	}
+
+	usages := m.collect(newRecord(160, map[string]uint64{"alice": 8, "bob": 2}), "node")
+	if len(usages) != 2 {
+		t.Fatalf("expected 2 usages, got %d", len(usages))
+	}
+	alice, bob := usages[0], usages[1]
+	if alice.Consumer != "alice" || alice.Requests != 3 || alice.ReqBytes != 30 || alice.RespBytes != 300 {
+		t.Errorf("unexpected usage of alice: %+v", alice)
+	}
+	if bob.Consumer != "bob" || bob.Requests != 2 || bob.Server != "default/server" {
+		t.Errorf("unexpected usage of bob: %+v", bob)
+	}
+
+	// The same record is collected only once.
+	if usages := m.collect(newRecord(160, map[string]uint64{"alice": 9}), "node"); len(usages) != 0 {
+		t.Errorf("the same record should not be collected again")
+	}
+
+	// Counters are reset, e.g. the server restarted.
+	usages = m.collect(newRecord(220, map[string]uint64{"alice": 1, "bob": 2}), "node")
+	if len(usages) != 1 || usages[0].Consumer != "alice" || usages[0].Requests != 1 {
+		t.Errorf("unexpected usages after reset: %+v", usages)
+	}
+}
+
+func TestCSVExporter(t *testing.T) {
+	dir := t.TempDir()
+	e := newCSVExporter(dir, &CSVSpec{FileName: "usage.csv"})
+	usage := &Usage{Node: "node", Server: "default/server", Consumer: "alice", Requests: 3}
+
+	for i := 0; i < 2; i++ {
+		if err := e.export([]*Usage{usage}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	buff, err := os.ReadFile(filepath.Join(dir, "usage.csv"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(buff)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected the header and 2 rows, got %d lines", len(lines))
+	}
+	if lines[0] != strings.Join(csvHeader, ",") {
+		t.Errorf("unexpected header: %s", lines[0])
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	if err := (&Spec{}).Validate(); err == nil {
+		t.Errorf("expect error for no exporters")
+	}
+	if err := (&Spec{Interval: "1s", CSV: &CSVSpec{FileName: "usage.csv"}}).Validate(); err == nil {
+		t.Errorf("expect error for too short interval")
+	}
+	if err := (&Spec{Interval: "1m", CSV: &CSVSpec{FileName: "usage.csv"}}).Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package websocketserver
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+const (
+	// DirectionClientToBackend is the direction of messages sent by clients.
+	DirectionClientToBackend = "clientToBackend"
+	// DirectionBackendToClient is the direction of messages sent by backends.
+	DirectionBackendToClient = "backendToClient"
+	// DirectionBoth means messages of both directions.
+	DirectionBoth = "both"
+
+	// ActionDrop drops the message silently.
+	ActionDrop = "drop"
+	// ActionClose closes the connection.
+	ActionClose = "close"
+)
+
+type (
+	// MessageFilterSpec describes the filter applied on each message
+	// after the connection is established.
+	MessageFilterSpec struct {
+		// MaxMessageSize is the maximum size of a message in bytes,
+		// the connection is closed if it is exceeded.
+		MaxMessageSize int                   `yaml:"maxMessageSize" jsonschema:"omitempty,minimum=0"`
+		RateLimit      *MessageRateLimitSpec `yaml:"rateLimit,omitempty" jsonschema:"omitempty"`
+		Rules          []*MessageRule        `yaml:"rules,omitempty" jsonschema:"omitempty"`
+	}
+
+	// MessageRateLimitSpec limits the rate of messages sent by a client
+	// in a single connection.
+	MessageRateLimitSpec struct {
+		Limit  int    `yaml:"limit" jsonschema:"required,minimum=1"`
+		Window string `yaml:"window" jsonschema:"required,format=duration"`
+		Action string `yaml:"action" jsonschema:"omitempty,enum=,enum=drop,enum=close"`
+	}
+
+	// MessageRule inspects text messages matching a regular expression,
+	// it could drop them, close the connection, or rewrite them.
+	MessageRule struct {
+		Direction string `yaml:"direction" jsonschema:"omitempty,enum=,enum=clientToBackend,enum=backendToClient,enum=both"`
+		Match     string `yaml:"match" jsonschema:"required,format=regexp"`
+		// RequiredHeaders are validated against headers of the handshake
+		// request, the action is applied only if the validation fails.
+		RequiredHeaders *httpheader.ValidatorSpec `yaml:"requiredHeaders,omitempty" jsonschema:"omitempty"`
+		Action          string                    `yaml:"action" jsonschema:"omitempty,enum=,enum=drop,enum=close"`
+		// Replace rewrites the matched parts of the message if Action is empty.
+		Replace string `yaml:"replace" jsonschema:"omitempty"`
+
+		re        *regexp.Regexp
+		validator *httpheader.Validator
+	}
+
+	// messageFilter is the runtime of MessageFilterSpec.
+	messageFilter struct {
+		spec   *MessageFilterSpec
+		window time.Duration
+	}
+
+	// connFilter is the per connection state of messageFilter.
+	connFilter struct {
+		mf     *messageFilter
+		header *httpheader.HTTPHeader
+
+		windowStart time.Time
+		count       int
+	}
+
+	// filterError is returned by connFilter to close the connection.
+	filterError struct {
+		code   int
+		reason string
+	}
+)
+
+func (e *filterError) Error() string {
+	return e.reason
+}
+
+// Validate validates MessageFilterSpec.
+func (spec *MessageFilterSpec) Validate() error {
+	if spec.RateLimit != nil {
+		d, err := time.ParseDuration(spec.RateLimit.Window)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid rate limit window: %s", spec.RateLimit.Window)
+		}
+	}
+
+	for _, r := range spec.Rules {
+		if _, err := regexp.Compile(r.Match); err != nil {
+			return fmt.Errorf("invalid match %s: %v", r.Match, err)
+		}
+		if r.Action == "" && r.Replace == "" {
+			return fmt.Errorf("rule %s: either action or replace should be specified", r.Match)
+		}
+	}
+	return nil
+}
+
+func newMessageFilter(spec *MessageFilterSpec) *messageFilter {
+	mf := &messageFilter{spec: spec}
+	if spec.RateLimit != nil {
+		mf.window, _ = time.ParseDuration(spec.RateLimit.Window)
+	}
+	for _, r := range spec.Rules {
+		r.re = regexp.MustCompile(r.Match)
+		if r.RequiredHeaders != nil {
+			r.validator = httpheader.NewValidator(r.RequiredHeaders)
+		}
+	}
+	return mf
+}
+
+func (mf *messageFilter) newConnFilter(header *httpheader.HTTPHeader) *connFilter {
+	return &connFilter{mf: mf, header: header}
+}
+
+func (r *MessageRule) matchDirection(direction string) bool {
+	switch r.Direction {
+	case "", DirectionClientToBackend:
+		return direction == DirectionClientToBackend
+	case DirectionBoth:
+		return true
+	default:
+		return r.Direction == direction
+	}
+}
+
+// filter filters a message, it returns the message to forward, or nil if
+// the message should be dropped, or an error if the connection should be
+// closed. It is called from a single goroutine for each direction, and
+// the rate limit state is only used by the client to backend direction.
+func (cf *connFilter) filter(direction string, msgType int, msg []byte) ([]byte, error) {
+	spec := cf.mf.spec
+
+	if spec.MaxMessageSize > 0 && len(msg) > spec.MaxMessageSize {
+		return nil, &filterError{
+			code:   websocket.CloseMessageTooBig,
+			reason: fmt.Sprintf("message size %d exceeds the limit %d", len(msg), spec.MaxMessageSize),
+		}
+	}
+
+	if spec.RateLimit != nil && direction == DirectionClientToBackend {
+		now := time.Now()
+		if now.Sub(cf.windowStart) >= cf.mf.window {
+			cf.windowStart, cf.count = now, 0
+		}
+		cf.count++
+		if cf.count > spec.RateLimit.Limit {
+			if spec.RateLimit.Action == ActionClose {
+				return nil, &filterError{code: websocket.ClosePolicyViolation, reason: "message rate limit exceeded"}
+			}
+			return nil, nil
+		}
+	}
+
+	if msgType != websocket.TextMessage {
+		return msg, nil
+	}
+
+	for _, r := range spec.Rules {
+		if !r.matchDirection(direction) || !r.re.Match(msg) {
+			continue
+		}
+		if r.validator != nil && r.validator.Validate(cf.header) == nil {
+			continue
+		}
+
+		switch r.Action {
+		case ActionDrop:
+			return nil, nil
+		case ActionClose:
+			return nil, &filterError{code: websocket.ClosePolicyViolation, reason: "message rejected"}
+		default:
+			msg = r.re.ReplaceAll(msg, []byte(r.Replace))
+		}
+	}
+
+	return msg, nil
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package websocketserver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestMessageFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	spec := &MessageFilterSpec{
+		MaxMessageSize: 64,
+		RateLimit:      &MessageRateLimitSpec{Limit: 3, Window: "1h"},
+		Rules: []*MessageRule{
+			{
+				Match: `"op":"subscribe"`,
+				RequiredHeaders: &httpheader.ValidatorSpec{
+					"X-Role": &httpheader.ValueValidator{Values: []string{"admin"}},
+				},
+				Action: ActionClose,
+			},
+			{
+				Direction: DirectionBackendToClient,
+				Match:     `secret`,
+				Replace:   `******`,
+			},
+		},
+	}
+	assert.Nil(spec.Validate())
+
+	mf := newMessageFilter(spec)
+	cf := mf.newConnFilter(httpheader.New(http.Header{}))
+
+	msg, err := cf.filter(DirectionClientToBackend, websocket.TextMessage, []byte(`{"op":"ping"}`))
+	assert.Nil(err)
+	assert.Equal(`{"op":"ping"}`, string(msg))
+
+	_, err = cf.filter(DirectionClientToBackend, websocket.TextMessage, []byte(`{"op":"subscribe"}`))
+	assert.NotNil(err)
+	assert.Equal(websocket.ClosePolicyViolation, err.(*filterError).code)
+
+	msg, err = cf.filter(DirectionBackendToClient, websocket.TextMessage, []byte(`the secret`))
+	assert.Nil(err)
+	assert.Equal(`the ******`, string(msg))
+
+	_, err = cf.filter(DirectionBackendToClient, websocket.BinaryMessage, make([]byte, 65))
+	assert.NotNil(err)
+	assert.Equal(websocket.CloseMessageTooBig, err.(*filterError).code)
+
+	// the 4th message sent by the client exceeds the rate limit and is dropped.
+	msg, err = cf.filter(DirectionClientToBackend, websocket.TextMessage, []byte(`a`))
+	assert.Nil(err)
+	assert.Equal(`a`, string(msg))
+	msg, err = cf.filter(DirectionClientToBackend, websocket.TextMessage, []byte(`b`))
+	assert.Nil(err)
+	assert.Nil(msg)
+
+	admin := http.Header{}
+	admin.Set("X-Role", "admin")
+	cf = mf.newConnFilter(httpheader.New(admin))
+	msg, err = cf.filter(DirectionClientToBackend, websocket.TextMessage, []byte(`{"op":"subscribe"}`))
+	assert.Nil(err)
+	assert.Equal(`{"op":"subscribe"}`, string(msg))
+
+	spec = &MessageFilterSpec{Rules: []*MessageRule{{Match: "a"}}}
+	assert.NotNil(spec.Validate())
+}
@@ -31,6 +31,7 @@ import (
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/supervisor"
+	"github.com/megaease/easegress/pkg/util/httpheader"
 )
 
 const (
@@ -84,6 +85,10 @@ type Proxy struct {
 	//  dialer contains options for connecting to the backend WebSocket server.
 	dialer *websocket.Dialer
 
+	// messageFilter filters messages of connections, it is nil if
+	// message filtering is not configured.
+	messageFilter *messageFilter
+
 	// done is the channel for shutdowning this proxy.
 	done chan struct{}
 }
@@ -107,8 +112,10 @@ func (p *Proxy) buildRequestURL(r *http.Request) *url.URL {
 	return &u
 }
 
-// passMsg passes websocket message from src to dst.
-func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan struct{}) {
+// passMsg passes websocket message from src to dst, messages are filtered
+// by cf if it is not nil.
+func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan struct{},
+	direction string, cf *connFilter) {
 	handle := func() bool {
 		msgType, msg, err := src.ReadMessage()
 		if err != nil {
@@ -122,6 +129,19 @@ func (p *Proxy) passMsg(src, dst *websocket.Conn, errc chan error, stop chan str
 			errc <- err
 			return false
 		}
+		if cf != nil && msgType != websocket.CloseMessage {
+			msg, err = cf.filter(direction, msgType, msg)
+			if err != nil {
+				fe := err.(*filterError)
+				src.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(fe.code, fe.reason))
+				dst.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				errc <- err
+				return false
+			}
+			if msg == nil {
+				return true
+			}
+		}
 		err = dst.WriteMessage(msgType, msg)
 		if err != nil {
 			errc <- err
@@ -166,6 +186,9 @@ func (p *Proxy) run() {
 	}
 	p.dialer = dialer
 	p.upgrader = defaultUpgrader
+	if spec.MessageFilter != nil {
+		p.messageFilter = newMessageFilter(spec.MessageFilter)
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", p.handle)
@@ -284,10 +307,15 @@ func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
 
 	defer close(stop)
 
+	var cf *connFilter
+	if p.messageFilter != nil {
+		cf = p.messageFilter.newConnFilter(httpheader.New(req.Header))
+	}
+
 	// pass msg from backend to client via WebSocket protocol.
-	go p.passMsg(connBackend, connClient, errBackend, stop)
+	go p.passMsg(connBackend, connClient, errBackend, stop, DirectionBackendToClient, cf)
 	// pass msg from client to backend via WebSocket protocol.
-	go p.passMsg(connClient, connBackend, errClient, stop)
+	go p.passMsg(connClient, connBackend, errClient, stop, DirectionClientToBackend, cf)
 
 	var errMsg string
 	select {
@@ -300,6 +328,10 @@ func (p *Proxy) handle(rw http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if e, ok := err.(*filterError); ok {
+		logger.Debugf("%s closes connection by message filter: %v", p.superSpec.Name(), e)
+		return
+	}
 	if e, ok := err.(*websocket.CloseError); !ok || e.Code == websocket.CloseAbnormalClosure {
 		logger.Errorf(errMsg, p.superSpec.Name(), p.backendURL.String(), err)
 	}
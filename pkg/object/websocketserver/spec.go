@@ -37,6 +37,8 @@ type (
 
 		WssCertBase64 string `yaml:"wssCertBase64" jsonschema:"omitempty,format=base64"`
 		WssKeyBase64  string `yaml:"wssKeyBase64" jsonschema:"omitempty,format=base64"`
+
+		MessageFilter *MessageFilterSpec `yaml:"messageFilter,omitempty" jsonschema:"omitempty"`
 	}
 )
 
@@ -61,6 +63,12 @@ func (spec *Spec) Validate() error {
 			return fmt.Errorf("invalid wssCertbase64 or wssKeybase64 with wss enable, spec: %#v", spec)
 		}
 	}
+
+	if spec.MessageFilter != nil {
+		if err := spec.MessageFilter.Validate(); err != nil {
+			return fmt.Errorf("invalid message filter: %v", err)
+		}
+	}
 	return nil
 }
 
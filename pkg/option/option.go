@@ -32,6 +32,9 @@ import (
 	yaml "gopkg.in/yaml.v2"
 
 	"github.com/megaease/easegress/pkg/common"
+	"github.com/megaease/easegress/pkg/util/dnsresolver"
+	"github.com/megaease/easegress/pkg/util/egressproxy"
+	"github.com/megaease/easegress/pkg/util/fips"
 	"github.com/megaease/easegress/pkg/version"
 )
 
@@ -95,6 +98,27 @@ type Options struct {
 	CPUProfileFile    string `yaml:"cpu-profile-file"`
 	MemoryProfileFile string `yaml:"memory-profile-file"`
 
+	// Secrets encryption, the token of Vault is read from
+	// environment variable VAULT_TOKEN.
+	SecretPassphraseFile string `yaml:"secret-passphrase-file"`
+	SecretVaultAddr      string `yaml:"secret-vault-addr"`
+	SecretVaultKey       string `yaml:"secret-vault-key"`
+
+	// FIPS restricts the cryptographic algorithms and settings to the
+	// FIPS 140-2 approved ones, it is always enabled for the builds with
+	// BoringCrypto.
+	FIPS bool `yaml:"fips"`
+
+	// DNSResolvers are the DNS-over-TLS or DNS-over-HTTPS resolvers of
+	// the lookups of the server itself, they replace the plaintext DNS.
+	DNSResolvers []string `yaml:"dns-resolvers"`
+
+	// EgressProxy is the forward proxy of the outbound requests, e.g.
+	// the ones to backends, webhooks and token endpoints, the hosts in
+	// EgressNoProxy are connected directly.
+	EgressProxy   string   `yaml:"egress-proxy"`
+	EgressNoProxy []string `yaml:"egress-no-proxy"`
+
 	// Prepare the items below in advance.
 	AbsHomeDir   string `yaml:"-"`
 	AbsDataDir   string `yaml:"-"`
@@ -161,11 +185,35 @@ func New() *Options {
 	opt.flags.StringVar(&opt.CPUProfileFile, "cpu-profile-file", "", "Path to the CPU profile file.")
 	opt.flags.StringVar(&opt.MemoryProfileFile, "memory-profile-file", "", "Path to the memory profile file.")
 
+	opt.flags.StringVar(&opt.SecretPassphraseFile, "secret-passphrase-file", "", "Path to the file containing the passphrase to encrypt secrets of objects in the config store.")
+	opt.flags.StringVar(&opt.SecretVaultAddr, "secret-vault-addr", "", "Address of the Vault server whose transit secrets engine encrypts secrets of objects in the config store, the token is read from VAULT_TOKEN.")
+	opt.flags.StringVar(&opt.SecretVaultKey, "secret-vault-key", "", "Name of the Vault transit key, used together with secret-vault-addr.")
+
+	opt.flags.BoolVar(&opt.FIPS, "fips", false, "Restrict the cryptographic algorithms and settings to the FIPS 140-2 approved ones, objects with non-compliant settings are rejected.")
+
+	opt.flags.StringSliceVar(&opt.DNSResolvers, "dns-resolvers", nil, "List of DNS-over-TLS (tls://<ip>[:port][?serverName=<name>]) or DNS-over-HTTPS (https://<ip>[:port]/<path>[?serverName=<name>]) resolvers for the lookups of the server itself, tried in order.")
+
+	opt.flags.StringVar(&opt.EgressProxy, "egress-proxy", "", "URL of the forward proxy (http, https or socks5) of the outbound requests, the environment variables HTTP_PROXY and HTTPS_PROXY are used if it is empty.")
+	opt.flags.StringSliceVar(&opt.EgressNoProxy, "egress-no-proxy", nil, "List of hosts connected directly without egress-proxy: * for all, IPs, CIDRs, and domains matching the subdomains too.")
+
 	opt.viper.BindPFlags(opt.flags)
 
 	return opt
 }
 
+// EgressProxySpec returns the spec of the global egress proxy, it is nil
+// if the egress proxy is not configured.
+func (opt *Options) EgressProxySpec() *egressproxy.Spec {
+	if opt.EgressProxy == "" {
+		return nil
+	}
+	return opt.egressProxySpec()
+}
+
+func (opt *Options) egressProxySpec() *egressproxy.Spec {
+	return &egressproxy.Spec{URL: opt.EgressProxy, NoProxy: opt.EgressNoProxy}
+}
+
 // YAML returns yaml string of option, need to be called after calling Parse.
 func (opt *Options) YAML() string {
 	return opt.yamlStr
@@ -391,6 +439,20 @@ func (opt *Options) validate() error {
 		return fmt.Errorf("invalid api-url: %v", err)
 	}
 
+	if opt.EgressProxy != "" {
+		if err := opt.egressProxySpec().Validate(); err != nil {
+			return fmt.Errorf("invalid egress-proxy: %v", err)
+		}
+	} else if len(opt.EgressNoProxy) != 0 {
+		return fmt.Errorf("egress-no-proxy requires egress-proxy")
+	}
+
+	for _, resolver := range opt.DNSResolvers {
+		if _, err := dnsresolver.Parse(resolver); err != nil {
+			return fmt.Errorf("invalid dns-resolvers: %v", err)
+		}
+	}
+
 	// dirs
 	if opt.HomeDir == "" {
 		return fmt.Errorf("empty home-dir")
@@ -407,6 +469,17 @@ func (opt *Options) validate() error {
 
 	// profile: nothing to validate
 
+	// secrets
+	if opt.SecretPassphraseFile != "" && opt.SecretVaultAddr != "" {
+		return fmt.Errorf("secret-passphrase-file and secret-vault-addr are both defined")
+	}
+	if (opt.SecretVaultAddr == "") != (opt.SecretVaultKey == "") {
+		return fmt.Errorf("secret-vault-addr and secret-vault-key must be defined together")
+	}
+	if (opt.FIPS || fips.BoringCrypto()) && opt.SecretPassphraseFile != "" {
+		return fmt.Errorf("secret-passphrase-file is not allowed in FIPS mode, scrypt is not approved, use secret-vault-addr instead")
+	}
+
 	// meta
 	if opt.Name == "" {
 		name, err := generateMemberName(opt.APIAddr)
@@ -21,29 +21,52 @@ import (
 
 	// Filters
 	_ "github.com/megaease/easegress/pkg/filter/apiaggregator"
+	_ "github.com/megaease/easegress/pkg/filter/awssigner"
+	_ "github.com/megaease/easegress/pkg/filter/bandwidthlimiter"
 	_ "github.com/megaease/easegress/pkg/filter/bridge"
 	_ "github.com/megaease/easegress/pkg/filter/circuitbreaker"
+	_ "github.com/megaease/easegress/pkg/filter/clientcertheader"
+	_ "github.com/megaease/easegress/pkg/filter/cloudevents"
 	_ "github.com/megaease/easegress/pkg/filter/connectcontrol"
 	_ "github.com/megaease/easegress/pkg/filter/corsadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/encodingadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/etag"
 	_ "github.com/megaease/easegress/pkg/filter/fallback"
+	_ "github.com/megaease/easegress/pkg/filter/graphql"
+	_ "github.com/megaease/easegress/pkg/filter/icap"
+	_ "github.com/megaease/easegress/pkg/filter/identitytoken"
 	_ "github.com/megaease/easegress/pkg/filter/meshadaptor"
 	_ "github.com/megaease/easegress/pkg/filter/mock"
+	_ "github.com/megaease/easegress/pkg/filter/multipartinspector"
+	_ "github.com/megaease/easegress/pkg/filter/natsbridge"
+	_ "github.com/megaease/easegress/pkg/filter/oauth2client"
+	_ "github.com/megaease/easegress/pkg/filter/oidc"
+	_ "github.com/megaease/easegress/pkg/filter/openapivalidator"
+	_ "github.com/megaease/easegress/pkg/filter/protobufvalidator"
 	_ "github.com/megaease/easegress/pkg/filter/proxy"
 	_ "github.com/megaease/easegress/pkg/filter/ratelimiter"
+	_ "github.com/megaease/easegress/pkg/filter/recorder"
 	_ "github.com/megaease/easegress/pkg/filter/remotefilter"
 	_ "github.com/megaease/easegress/pkg/filter/requestadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/requestsigner"
 	_ "github.com/megaease/easegress/pkg/filter/responseadaptor"
+	_ "github.com/megaease/easegress/pkg/filter/responseintegrity"
 	_ "github.com/megaease/easegress/pkg/filter/retryer"
+	_ "github.com/megaease/easegress/pkg/filter/session"
+	_ "github.com/megaease/easegress/pkg/filter/streamtransformer"
 	_ "github.com/megaease/easegress/pkg/filter/timelimiter"
 	_ "github.com/megaease/easegress/pkg/filter/validator"
 	_ "github.com/megaease/easegress/pkg/filter/wasmhost"
+	_ "github.com/megaease/easegress/pkg/filter/webdav"
 
 	// Objects
 	_ "github.com/megaease/easegress/pkg/object/autocertmanager"
 	_ "github.com/megaease/easegress/pkg/object/consulserviceregistry"
+	_ "github.com/megaease/easegress/pkg/object/dbproxy"
 	_ "github.com/megaease/easegress/pkg/object/easemonitormetrics"
 	_ "github.com/megaease/easegress/pkg/object/etcdserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/eurekaserviceregistry"
+	_ "github.com/megaease/easegress/pkg/object/forwardproxy"
 	_ "github.com/megaease/easegress/pkg/object/function"
 	_ "github.com/megaease/easegress/pkg/object/globalfilter"
 	_ "github.com/megaease/easegress/pkg/object/httppipeline"
@@ -54,7 +77,12 @@ import (
 	_ "github.com/megaease/easegress/pkg/object/nacosserviceregistry"
 	_ "github.com/megaease/easegress/pkg/object/pipeline"
 	_ "github.com/megaease/easegress/pkg/object/rawconfigtrafficcontroller"
+	_ "github.com/megaease/easegress/pkg/object/sessionstore"
+	_ "github.com/megaease/easegress/pkg/object/smtprelay"
+	_ "github.com/megaease/easegress/pkg/object/sniproxy"
+	_ "github.com/megaease/easegress/pkg/object/syslogserver"
 	_ "github.com/megaease/easegress/pkg/object/trafficcontroller"
+	_ "github.com/megaease/easegress/pkg/object/usagemeter"
 	_ "github.com/megaease/easegress/pkg/object/websocketserver"
 	_ "github.com/megaease/easegress/pkg/object/zookeeperserviceregistry"
 )
@@ -0,0 +1,58 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/fips"
+)
+
+// checkFIPS validates the objects in the config store in FIPS mode, the
+// server refuses to start if any of them is not compliant, rather than
+// serving without them silently.
+func (s *Supervisor) checkFIPS() {
+	if !fips.Enabled() {
+		return
+	}
+
+	prefix := s.cls.Layout().ConfigObjectPrefix()
+	kvs, err := s.cls.GetPrefix(prefix)
+	if err != nil {
+		panic(fmt.Errorf("get objects failed: %v", err))
+	}
+
+	var errs []string
+	for key, config := range kvs {
+		if _, err := s.NewSpec(config); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", strings.TrimPrefix(key, prefix), err))
+		}
+	}
+	if len(errs) == 0 {
+		return
+	}
+
+	sort.Strings(errs)
+	for _, e := range errs {
+		logger.Errorf("object not compliant with FIPS mode: %s", e)
+	}
+	panic(fmt.Errorf("%d objects not compliant with FIPS mode", len(errs)))
+}
@@ -0,0 +1,138 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package supervisor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"go.etcd.io/etcd/client/v3/concurrency"
+
+	"github.com/megaease/easegress/pkg/cluster"
+	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/util/secrets"
+)
+
+// initSecrets creates the box to seal and open the secrets of objects if
+// secrets encryption is enabled.
+func (s *Supervisor) initSecrets() {
+	kek, err := newKeyEncrypter(s.options)
+	if err != nil {
+		panic(fmt.Errorf("create key encrypter failed: %v", err))
+	}
+	if kek == nil {
+		return
+	}
+
+	dataKey, err := loadDataKey(s.cls, kek)
+	if err != nil {
+		panic(fmt.Errorf("load data key failed: %v", err))
+	}
+
+	s.secrets, err = secrets.New(dataKey)
+	if err != nil {
+		panic(fmt.Errorf("create secrets box failed: %v", err))
+	}
+}
+
+func newKeyEncrypter(opt *option.Options) (secrets.KeyEncrypter, error) {
+	switch {
+	case opt.SecretPassphraseFile != "":
+		data, err := os.ReadFile(opt.SecretPassphraseFile)
+		if err != nil {
+			return nil, err
+		}
+		pass := bytes.TrimSpace(data)
+		if len(pass) == 0 {
+			return nil, fmt.Errorf("empty passphrase in %s", opt.SecretPassphraseFile)
+		}
+		return secrets.NewPassphrase(pass), nil
+	case opt.SecretVaultAddr != "":
+		token := os.Getenv("VAULT_TOKEN")
+		if token == "" {
+			return nil, fmt.Errorf("empty VAULT_TOKEN")
+		}
+		return secrets.NewVaultTransit(opt.SecretVaultAddr, opt.SecretVaultKey, token), nil
+	default:
+		return nil, nil
+	}
+}
+
+// loadDataKey loads the data key from the cluster, the data key is
+// generated by the first member enabling secrets encryption and stored
+// in the cluster wrapped by the key encrypter.
+func loadDataKey(cls cluster.Cluster, kek secrets.KeyEncrypter) ([]byte, error) {
+	storeKey := cls.Layout().SecretDataKey()
+
+	value, err := cls.Get(storeKey)
+	if err != nil {
+		return nil, err
+	}
+	if value != nil {
+		return kek.Unwrap(*value)
+	}
+
+	dataKey, err := secrets.GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+	wrapped, err := kek.Wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// another member may have saved its data key in the meantime.
+	var existing string
+	err = cls.STM(func(stm concurrency.STM) error {
+		existing = stm.Get(storeKey)
+		if existing == "" {
+			stm.Put(storeKey, wrapped)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if existing != "" {
+		return kek.Unwrap(existing)
+	}
+
+	return dataKey, nil
+}
+
+// SealSecrets seals the secrets in the YAML config of an object before
+// saving it to the config store, the config is returned as is if secrets
+// encryption is not enabled.
+func (s *Supervisor) SealSecrets(yamlConfig string) (string, error) {
+	if s == nil || s.secrets == nil {
+		return yamlConfig, nil
+	}
+	return s.secrets.SealYAML(yamlConfig)
+}
+
+// openSecrets opens the sealed secrets in the YAML config of an object.
+func (s *Supervisor) openSecrets(yamlConfig string) (string, error) {
+	if !secrets.HasSealed(yamlConfig) {
+		return yamlConfig, nil
+	}
+	if s == nil || s.secrets == nil {
+		return "", fmt.Errorf("config contains encrypted secrets, but secrets encryption is not enabled")
+	}
+	return s.secrets.OpenYAML(yamlConfig)
+}
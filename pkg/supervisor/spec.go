@@ -78,6 +78,10 @@ func (s *Supervisor) NewSpec(yamlConfig string) (spec *Spec, err error) {
 		}
 	}()
 
+	yamlConfig, err = s.openSecrets(yamlConfig)
+	if err != nil {
+		panic(err)
+	}
 	yamlBuff := []byte(yamlConfig)
 
 	// Meta part.
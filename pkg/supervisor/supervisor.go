@@ -26,6 +26,7 @@ import (
 	"github.com/megaease/easegress/pkg/cluster"
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/option"
+	"github.com/megaease/easegress/pkg/util/secrets"
 )
 
 const watcherName = "__SUPERVISOR__"
@@ -35,6 +36,7 @@ type (
 	Supervisor struct {
 		options *option.Options
 		cls     cluster.Cluster
+		secrets *secrets.Box
 
 		// The scenario here satisfies the first common case:
 		// When the entry for a given key is only ever written once but read many times.
@@ -71,7 +73,12 @@ func loadInitialObjects(s *Supervisor, paths []string) map[string]string {
 			logger.Errorf("failed to create spec for initial object, path: %s, error: %v", path, e)
 			continue
 		}
-		objs[spec.Name()] = spec.YAMLConfig()
+		config, e := s.SealSecrets(spec.YAMLConfig())
+		if e != nil {
+			logger.Errorf("failed to seal secrets of initial object, path: %s, error: %v", path, e)
+			continue
+		}
+		objs[spec.Name()] = config
 	}
 	return objs
 }
@@ -87,6 +94,8 @@ func MustNew(opt *option.Options, cls cluster.Cluster) *Supervisor {
 		done:            make(chan struct{}),
 	}
 
+	s.initSecrets()
+	s.checkFIPS()
 	initObjs := loadInitialObjects(s, opt.InitialObjectConfigFiles)
 
 	s.objectRegistry = newObjectRegistry(s, initObjs)
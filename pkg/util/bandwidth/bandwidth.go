@@ -0,0 +1,166 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package bandwidth limits the rate of bytes transferred, by token
+// buckets shared among connections or requests.
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// chunkSize is the max size of a write waiting for the limiters once, so
+// large writes are smoothed.
+const chunkSize = 16 * 1024
+
+type (
+	// Limiter is a token bucket of bytes, the tokens could be negative,
+	// which is the debt paid by the following waits, so the average rate
+	// is kept without splitting large transfers.
+	Limiter struct {
+		mutex  sync.Mutex
+		rate   float64 // bytes per second
+		burst  float64
+		tokens float64
+		last   time.Time
+	}
+
+	limitedWriter struct {
+		ctx      context.Context
+		w        io.Writer
+		limiters []*Limiter
+	}
+
+	limitedReader struct {
+		ctx      context.Context
+		r        io.Reader
+		limiters []*Limiter
+	}
+)
+
+// NewLimiter creates a Limiter of bytesPerSecond, the burst is the max
+// bytes allowed at once after being idle, it is bytesPerSecond if zero.
+func NewLimiter(bytesPerSecond, burst int64) *Limiter {
+	if burst <= 0 {
+		burst = bytesPerSecond
+	}
+	return &Limiter{
+		rate:   float64(bytesPerSecond),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes n tokens, and returns the time to wait for them.
+func (l *Limiter) reserve(n int) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// Wait blocks until n bytes are allowed, or the context is done.
+func (l *Limiter) Wait(ctx context.Context, n int) error {
+	d := l.reserve(n)
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// WaitAll waits for n bytes of all limiters, the nil ones are skipped.
+func WaitAll(ctx context.Context, n int, limiters ...*Limiter) error {
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		if err := l.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewWriter returns the writer whose writes are limited by the limiters,
+// the nil ones are skipped. The writes fail once the context is done.
+func NewWriter(ctx context.Context, w io.Writer, limiters ...*Limiter) io.Writer {
+	return &limitedWriter{ctx: ctx, w: w, limiters: limiters}
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		if err := WaitAll(lw.ctx, len(chunk), lw.limiters...); err != nil {
+			return written, err
+		}
+
+		n, err := lw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// NewReader returns the reader whose reads are limited by the limiters,
+// the nil ones are skipped. The reads fail once the context is done.
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	return &limitedReader{ctx: ctx, r: r, limiters: limiters}
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > chunkSize {
+		p = p[:chunkSize]
+	}
+
+	n, err := lr.r.Read(p)
+	if n > 0 {
+		if werr := WaitAll(lr.ctx, n, lr.limiters...); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package bandwidth
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := NewLimiter(1000, 0)
+
+	// The burst is allowed at once.
+	if d := l.reserve(1000); d != 0 {
+		t.Errorf("burst should be allowed, got %v", d)
+	}
+	// The debt is paid by waiting.
+	if d := l.reserve(500); d < 400*time.Millisecond || d > 500*time.Millisecond {
+		t.Errorf("expect to wait about 500ms, got %v", d)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Wait(ctx, 1000); err == nil {
+		t.Errorf("wait should fail after the context is canceled")
+	}
+}
+
+func TestWriter(t *testing.T) {
+	l := NewLimiter(100*1024, 16*1024)
+	buf := &bytes.Buffer{}
+	w := NewWriter(context.Background(), buf, l, nil)
+
+	start := time.Now()
+	data := make([]byte, 36*1024)
+	n, err := w.Write(data)
+	if err != nil || n != len(data) {
+		t.Fatalf("write failed: %d, %v", n, err)
+	}
+	if buf.Len() != len(data) {
+		t.Errorf("expect %d bytes written, got %d", len(data), buf.Len())
+	}
+
+	// 16KiB burst, the other 20KiB takes about 200ms.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected elapsed time %v", elapsed)
+	}
+}
+
+func TestReader(t *testing.T) {
+	l := NewLimiter(100*1024, 16*1024)
+	r := NewReader(context.Background(), bytes.NewReader(make([]byte, 36*1024)), nil, l)
+
+	start := time.Now()
+	data, err := ioutil.ReadAll(r)
+	if err != nil || len(data) != 36*1024 {
+		t.Fatalf("read failed: %d, %v", len(data), err)
+	}
+
+	// 16KiB burst, the other 20KiB takes about 200ms.
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > time.Second {
+		t.Errorf("unexpected elapsed time %v", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r = NewReader(ctx, bytes.NewReader(make([]byte, 36*1024)), NewLimiter(1024, 0))
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Errorf("read should fail after the context is canceled")
+	}
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package clientip resolves the addresses of clients behind proxies, the
+// address of the header is trusted only if the request comes from the
+// trusted proxies, so it can't be spoofed by clients.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// HeaderXForwardedFor is the header X-Forwarded-For.
+	HeaderXForwardedFor = "X-Forwarded-For"
+	// HeaderXRealIP is the header X-Real-IP.
+	HeaderXRealIP = "X-Real-IP"
+	// HeaderForwarded is the header Forwarded of RFC 7239.
+	HeaderForwarded = "Forwarded"
+)
+
+type (
+	// Spec describes the resolution of client addresses.
+	Spec struct {
+		// TrustedProxies are the IPs or CIDRs of the trusted proxies.
+		TrustedProxies []string `yaml:"trustedProxies" jsonschema:"required,uniqueItems=true,format=ipcidr-array"`
+		// Header is the header containing the client address, one of
+		// X-Forwarded-For, X-Real-IP and Forwarded, the default is
+		// X-Forwarded-For.
+		Header string `yaml:"header" jsonschema:"omitempty,enum=,enum=X-Forwarded-For,enum=X-Real-IP,enum=Forwarded"`
+	}
+
+	// Resolver resolves the client addresses of requests.
+	Resolver struct {
+		header  string
+		proxies []*net.IPNet
+	}
+)
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if len(spec.TrustedProxies) == 0 {
+		return fmt.Errorf("trustedProxies is required")
+	}
+	for _, proxy := range spec.TrustedProxies {
+		if _, err := parseIPNet(proxy); err != nil {
+			return err
+		}
+	}
+
+	switch spec.Header {
+	case "", HeaderXForwardedFor, HeaderXRealIP, HeaderForwarded:
+		return nil
+	default:
+		return fmt.Errorf("invalid header %s", spec.Header)
+	}
+}
+
+// parseIPNet parses an IP or a CIDR, the IP is the CIDR of itself only.
+func parseIPNet(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(net.IPv4len*8, net.IPv4len*8)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(net.IPv6len*8, net.IPv6len*8)}, nil
+	}
+
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s is an invalid ip or cidr", s)
+	}
+	return ipNet, nil
+}
+
+// New creates a Resolver, the spec must be valid.
+func New(spec *Spec) *Resolver {
+	r := &Resolver{header: spec.Header}
+	if r.header == "" {
+		r.header = HeaderXForwardedFor
+	}
+
+	for _, proxy := range spec.TrustedProxies {
+		// NOTE: The spec has been validated.
+		ipNet, _ := parseIPNet(proxy)
+		r.proxies = append(r.proxies, ipNet)
+	}
+
+	return r
+}
+
+func (r *Resolver) trusted(ip net.IP) bool {
+	for _, proxy := range r.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns the address of the client. It is the peer address if
+// the peer is not a trusted proxy. Otherwise, the addresses in the header
+// are walked from the nearest one, and the first untrusted one is the
+// client, as only the trusted proxies append addresses faithfully.
+func (r *Resolver) Resolve(req *http.Request) string {
+	peer := remoteIP(req.RemoteAddr)
+	peerIP := net.ParseIP(peer)
+	if peerIP == nil || !r.trusted(peerIP) {
+		return peer
+	}
+
+	var addresses []string
+	switch r.header {
+	case HeaderXRealIP:
+		addresses = []string{strings.TrimSpace(req.Header.Get(HeaderXRealIP))}
+	case HeaderForwarded:
+		addresses = forwardedFor(req.Header.Values(HeaderForwarded))
+	default:
+		addresses = xForwardedFor(req.Header.Values(HeaderXForwardedFor))
+	}
+
+	client := peer
+	for i := len(addresses) - 1; i >= 0; i-- {
+		ip := net.ParseIP(addresses[i])
+		if ip == nil {
+			// NOTE: The address beyond the invalid one is untrustworthy,
+			// the last trusted proxy is the nearest known client.
+			break
+		}
+		client = ip.String()
+		if !r.trusted(ip) {
+			break
+		}
+	}
+
+	return client
+}
+
+// remoteIP returns the IP of the remote address.
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// xForwardedFor returns the addresses of X-Forwarded-For, the multiple
+// headers are one list.
+func xForwardedFor(values []string) []string {
+	var addresses []string
+	for _, value := range values {
+		for _, address := range strings.Split(value, ",") {
+			addresses = append(addresses, strings.TrimSpace(address))
+		}
+	}
+	return addresses
+}
+
+// forwardedFor returns the addresses of the for parameters of Forwarded,
+// e.g. for=192.0.2.43, for="[2001:db8:cafe::17]:4711". Elements without
+// the for parameter are the unknown addresses.
+func forwardedFor(values []string) []string {
+	var addresses []string
+	for _, value := range values {
+		for _, element := range strings.Split(value, ",") {
+			address := "unknown"
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					address = forwardedNode(kv[1])
+					break
+				}
+			}
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses
+}
+
+// forwardedNode returns the IP of the node of Forwarded, the quotes, the
+// brackets of IPv6 and the port are removed.
+func forwardedNode(node string) string {
+	node = strings.Trim(node, `"`)
+	if strings.HasPrefix(node, "[") {
+		if end := strings.Index(node, "]"); end > 0 {
+			return node[1:end]
+		}
+		return node
+	}
+	if i := strings.IndexByte(node, ':'); i >= 0 {
+		return node[:i]
+	}
+	return node
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clientip
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	spec := &Spec{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"}, Header: HeaderForwarded}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	invalid := []*Spec{
+		{},
+		{TrustedProxies: []string{"10.0.0.0/33"}},
+		{TrustedProxies: []string{"10.0.0.1"}, Header: "X-Client-IP"},
+	}
+	for _, spec := range invalid {
+		if err := spec.Validate(); err == nil {
+			t.Errorf("expect error for %+v", spec)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	newRequest := func(remoteAddr string, header http.Header) *http.Request {
+		return &http.Request{RemoteAddr: remoteAddr, Header: header}
+	}
+
+	xff := New(&Spec{TrustedProxies: []string{"10.0.0.0/8", "fd00::/8"}})
+	realIP := New(&Spec{TrustedProxies: []string{"10.0.0.1"}, Header: HeaderXRealIP})
+	forwarded := New(&Spec{TrustedProxies: []string{"10.0.0.0/8"}, Header: HeaderForwarded})
+
+	cases := []struct {
+		resolver *Resolver
+		req      *http.Request
+		expected string
+	}{
+		// Untrusted peers.
+		{xff, newRequest("1.2.3.4:5678", http.Header{"X-Forwarded-For": {"5.6.7.8"}}), "1.2.3.4"},
+		{realIP, newRequest("10.0.0.2:5678", http.Header{"X-Real-Ip": {"5.6.7.8"}}), "10.0.0.2"},
+		// The first untrusted address from the right.
+		{xff, newRequest("10.0.0.1:5678", http.Header{"X-Forwarded-For": {"9.9.9.9, 5.6.7.8, 10.0.0.2"}}), "5.6.7.8"},
+		{xff, newRequest("10.0.0.1:5678", http.Header{"X-Forwarded-For": {"9.9.9.9", "5.6.7.8,10.0.0.2"}}), "5.6.7.8"},
+		{xff, newRequest("[fd00::1]:5678", http.Header{"X-Forwarded-For": {"2001:db8::1"}}), "2001:db8::1"},
+		// All trusted.
+		{xff, newRequest("10.0.0.1:5678", http.Header{"X-Forwarded-For": {"10.0.0.3, 10.0.0.2"}}), "10.0.0.3"},
+		// No header.
+		{xff, newRequest("10.0.0.1:5678", http.Header{}), "10.0.0.1"},
+		// Invalid addresses.
+		{xff, newRequest("10.0.0.1:5678", http.Header{"X-Forwarded-For": {"5.6.7.8, garbage, 10.0.0.2"}}), "10.0.0.2"},
+		{realIP, newRequest("10.0.0.1:5678", http.Header{"X-Real-Ip": {"5.6.7.8"}}), "5.6.7.8"},
+		{realIP, newRequest("10.0.0.1:5678", http.Header{"X-Real-Ip": {"garbage"}}), "10.0.0.1"},
+		{forwarded, newRequest("10.0.0.1:5678", http.Header{"Forwarded": {`for=5.6.7.8;proto=https, for="[2001:db8::1]:4711", for=10.0.0.2:80`}}), "2001:db8::1"},
+		{forwarded, newRequest("10.0.0.1:5678", http.Header{"Forwarded": {"for=5.6.7.8", "for=unknown, proto=http"}}), "10.0.0.1"},
+		{forwarded, newRequest("10.0.0.1:5678", http.Header{"Forwarded": {"For=5.6.7.8"}}), "5.6.7.8"},
+	}
+
+	for i, c := range cases {
+		if ip := c.resolver.Resolve(c.req); ip != c.expected {
+			t.Errorf("case %d: expect %s, got %s", i, c.expected, ip)
+		}
+	}
+}
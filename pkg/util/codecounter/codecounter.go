@@ -59,3 +59,18 @@ func (cc *HTTPStatusCodeCounter) Codes() map[int]uint64 {
 	}
 	return codes
 }
+
+// CodesAndReset returns the codes and resets counters of all codes to
+// zero, it could be called concurrently with Count without losing counts.
+func (cc *HTTPStatusCodeCounter) CodesAndReset() map[int]uint64 {
+	codes := make(map[int]uint64)
+	for i := range cc.counter {
+		if atomic.LoadUint64(&cc.counter[i]) == 0 {
+			continue
+		}
+		if count := atomic.SwapUint64(&cc.counter[i], 0); count > 0 {
+			codes[i] = count
+		}
+	}
+	return codes
+}
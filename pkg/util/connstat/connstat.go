@@ -0,0 +1,372 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package connstat provides statistics of connections, including the TCP
+// connect time, TLS handshake duration, handshake failures and the ratio of
+// connection reuse, to separate network problems from application problems.
+package connstat
+
+import (
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http/httptrace"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/sampler"
+)
+
+// maxReasons is the max number of failure reasons, the reasons beyond it
+// are counted as "others".
+const maxReasons = 64
+
+type (
+	// ConnStat is the statistics tool for connections.
+	ConnStat struct {
+		mutex sync.Mutex
+
+		conns  uint64
+		reused uint64
+
+		handshakes uint64
+		connect    *durationStat
+		handshake  *durationStat
+
+		connectFailures   map[string]uint64
+		handshakeFailures map[string]uint64
+	}
+
+	durationStat struct {
+		count   uint64
+		total   time.Duration
+		max     time.Duration
+		sampler *sampler.DurationSampler
+	}
+
+	// DurationStatus contains the statistics of durations, in milliseconds.
+	DurationStatus struct {
+		Count uint64  `yaml:"count"`
+		Mean  float64 `yaml:"mean"`
+		Max   float64 `yaml:"max"`
+		P50   float64 `yaml:"p50"`
+		P95   float64 `yaml:"p95"`
+		P99   float64 `yaml:"p99"`
+	}
+
+	// Status contains all status generated by ConnStat.
+	Status struct {
+		Conns      uint64  `yaml:"conns"`
+		Reused     uint64  `yaml:"reused"`
+		ReuseRatio float64 `yaml:"reuseRatio"`
+
+		Connect         *DurationStatus   `yaml:"connect,omitempty"`
+		ConnectFailures map[string]uint64 `yaml:"connectFailures,omitempty"`
+
+		TLSHandshakes        uint64            `yaml:"tlsHandshakes"`
+		TLSHandshake         *DurationStatus   `yaml:"tlsHandshake,omitempty"`
+		TLSHandshakeFailures map[string]uint64 `yaml:"tlsHandshakeFailures,omitempty"`
+	}
+)
+
+// New creates a ConnStat.
+func New() *ConnStat {
+	return &ConnStat{
+		connect:           newDurationStat(),
+		handshake:         newDurationStat(),
+		connectFailures:   map[string]uint64{},
+		handshakeFailures: map[string]uint64{},
+	}
+}
+
+func newDurationStat() *durationStat {
+	return &durationStat{sampler: sampler.NewDurationSampler()}
+}
+
+func (ds *durationStat) update(d time.Duration) {
+	ds.count++
+	ds.total += d
+	if d > ds.max {
+		ds.max = d
+	}
+	ds.sampler.Update(d)
+}
+
+func (ds *durationStat) status() *DurationStatus {
+	if ds.count == 0 {
+		return nil
+	}
+
+	percentiles := ds.sampler.Percentiles()
+	return &DurationStatus{
+		Count: ds.count,
+		Mean:  float64(ds.total/time.Duration(ds.count)) / float64(time.Millisecond),
+		Max:   float64(ds.max) / float64(time.Millisecond),
+		P50:   percentiles[1],
+		P95:   percentiles[3],
+		P99:   percentiles[5],
+	}
+}
+
+func addReason(reasons map[string]uint64, reason string) {
+	if _, exists := reasons[reason]; !exists && len(reasons) >= maxReasons {
+		reason = "others"
+	}
+	reasons[reason]++
+}
+
+// StatConn records a connection got for a request.
+func (cs *ConnStat) StatConn(reused bool) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.conns++
+	if reused {
+		cs.reused++
+	}
+}
+
+// StatConnect records a TCP connect.
+func (cs *ConnStat) StatConnect(d time.Duration, err error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if err != nil {
+		addReason(cs.connectFailures, Reason(err))
+		return
+	}
+	cs.connect.update(d)
+}
+
+// StatTLSHandshake records a TLS handshake.
+func (cs *ConnStat) StatTLSHandshake(d time.Duration, err error) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	if err != nil {
+		addReason(cs.handshakeFailures, Reason(err))
+		return
+	}
+	cs.handshakes++
+	cs.handshake.update(d)
+}
+
+// StatTLSHandshakeSuccess records a successful TLS handshake whose duration
+// is unknown.
+func (cs *ConnStat) StatTLSHandshakeSuccess() {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	cs.handshakes++
+}
+
+// StatTLSHandshakeFailure records a failed TLS handshake with its reason.
+func (cs *ConnStat) StatTLSHandshakeFailure(reason string) {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	addReason(cs.handshakeFailures, reason)
+}
+
+// Status returns the status of ConnStat.
+func (cs *ConnStat) Status() *Status {
+	cs.mutex.Lock()
+	defer cs.mutex.Unlock()
+
+	s := &Status{
+		Conns:         cs.conns,
+		Reused:        cs.reused,
+		TLSHandshakes: cs.handshakes,
+		Connect:       cs.connect.status(),
+		TLSHandshake:  cs.handshake.status(),
+	}
+	if cs.conns > 0 {
+		s.ReuseRatio = float64(cs.reused) / float64(cs.conns)
+	}
+
+	if len(cs.connectFailures) > 0 {
+		s.ConnectFailures = make(map[string]uint64, len(cs.connectFailures))
+		for k, v := range cs.connectFailures {
+			s.ConnectFailures[k] = v
+		}
+	}
+	if len(cs.handshakeFailures) > 0 {
+		s.TLSHandshakeFailures = make(map[string]uint64, len(cs.handshakeFailures))
+		for k, v := range cs.handshakeFailures {
+			s.TLSHandshakeFailures[k] = v
+		}
+	}
+
+	return s
+}
+
+// WrapServerTLSConfig wraps the TLS config of a server to record the
+// successful handshakes.
+func (cs *ConnStat) WrapServerTLSConfig(tlsConfig *tls.Config) {
+	verify := tlsConfig.VerifyConnection
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(state); err != nil {
+				return err
+			}
+		}
+		cs.StatTLSHandshakeSuccess()
+		return nil
+	}
+}
+
+// ServerErrorLog creates a logger for http.Server.ErrorLog, it records the
+// TLS handshake errors reported by the server, and writes other messages
+// to w.
+func (cs *ConnStat) ServerErrorLog(w io.Writer) *log.Logger {
+	return log.New(&serverErrorWriter{cs: cs, w: w}, "", log.LstdFlags)
+}
+
+type serverErrorWriter struct {
+	cs *ConnStat
+	w  io.Writer
+}
+
+func (sew *serverErrorWriter) Write(p []byte) (int, error) {
+	msg := string(p)
+	idx := strings.Index(msg, tlsHandshakeErrorPrefix)
+	if idx < 0 {
+		return sew.w.Write(p)
+	}
+
+	// The message looks like: http: TLS handshake error from 1.2.3.4:5678: EOF
+	msg = msg[idx+len(tlsHandshakeErrorPrefix):]
+	if idx = strings.Index(msg, ": "); idx >= 0 {
+		msg = msg[idx+2:]
+	}
+	sew.cs.StatTLSHandshakeFailure(ReasonFromMessage(msg))
+	return len(p), nil
+}
+
+const tlsHandshakeErrorPrefix = "http: TLS handshake error from "
+
+// ClientTrace returns a ClientTrace which records the connection statistics
+// of a single request, a new one should be created for every request.
+func (cs *ConnStat) ClientTrace() *httptrace.ClientTrace {
+	var (
+		mutex          sync.Mutex
+		connectStarts  = map[string]time.Time{}
+		handshakeStart time.Time
+	)
+
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			cs.StatConn(info.Reused)
+		},
+		ConnectStart: func(network, addr string) {
+			mutex.Lock()
+			connectStarts[network+addr] = time.Now()
+			mutex.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			mutex.Lock()
+			start, exists := connectStarts[network+addr]
+			mutex.Unlock()
+			if exists {
+				cs.StatConnect(time.Since(start), err)
+			}
+		},
+		TLSHandshakeStart: func() {
+			mutex.Lock()
+			handshakeStart = time.Now()
+			mutex.Unlock()
+		},
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			mutex.Lock()
+			start := handshakeStart
+			mutex.Unlock()
+			if !start.IsZero() {
+				cs.StatTLSHandshake(time.Since(start), err)
+			}
+		},
+	}
+}
+
+// Reason returns a short reason of a connection or TLS handshake error.
+func Reason(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "eof"
+	case errors.Is(err, os.ErrDeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "connectionRefused"
+	case errors.Is(err, syscall.ECONNRESET):
+		return "connectionReset"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	}
+
+	var recordHeaderErr tls.RecordHeaderError
+	if errors.As(err, &recordHeaderErr) {
+		return "notTLS"
+	}
+
+	return ReasonFromMessage(err.Error())
+}
+
+// ReasonFromMessage returns a short reason from an error message.
+func ReasonFromMessage(msg string) string {
+	for _, r := range messageReasons {
+		if strings.Contains(msg, r.substr) {
+			return r.reason
+		}
+	}
+	return "others"
+}
+
+var messageReasons = []struct {
+	substr string
+	reason string
+}{
+	{"does not look like a TLS handshake", "notTLS"},
+	{"unsupported versions", "unsupportedVersion"},
+	{"protocol version not supported", "unsupportedVersion"},
+	{"no cipher suite supported", "noSharedCipher"},
+	{"handshake failure", "handshakeFailure"},
+	{"no certificates configured", "noCertificate"},
+	{"certificate required", "certificateRequired"},
+	{"unknown certificate authority", "unknownAuthority"},
+	{"certificate signed by unknown authority", "unknownAuthority"},
+	{"certificate has expired", "certificateExpired"},
+	{"expired certificate", "certificateExpired"},
+	{"certificate is valid for", "hostnameMismatch"},
+	{"bad certificate", "badCertificate"},
+	{"unknown certificate", "badCertificate"},
+	{"i/o timeout", "timeout"},
+	{"connection reset", "connectionReset"},
+	{"connection refused", "connectionRefused"},
+	{"broken pipe", "brokenPipe"},
+	{"EOF", "eof"},
+}
@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package connstat
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReason(t *testing.T) {
+	cases := []struct {
+		err    error
+		reason string
+	}{
+		{io.EOF, "eof"},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), "timeout"},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), "connectionRefused"},
+		{errors.New("tls: client offered only unsupported versions: [301]"), "unsupportedVersion"},
+		{errors.New("x509: certificate signed by unknown authority"), "unknownAuthority"},
+		{errors.New("something else"), "others"},
+	}
+
+	for _, c := range cases {
+		if got := Reason(c.err); got != c.reason {
+			t.Errorf("reason of %v: expect %s, got %s", c.err, c.reason, got)
+		}
+	}
+}
+
+func TestConnStat(t *testing.T) {
+	cs := New()
+
+	status := cs.Status()
+	if status.Conns != 0 || status.Connect != nil || status.TLSHandshake != nil {
+		t.Fatalf("expect empty status, got %+v", status)
+	}
+
+	cs.StatConn(false)
+	cs.StatConn(true)
+	cs.StatConn(true)
+	cs.StatConn(true)
+	cs.StatConnect(10*time.Millisecond, nil)
+	cs.StatConnect(0, syscall.ECONNREFUSED)
+	cs.StatTLSHandshake(20*time.Millisecond, nil)
+	cs.StatTLSHandshake(0, io.EOF)
+
+	status = cs.Status()
+	if status.Conns != 4 || status.Reused != 3 || status.ReuseRatio != 0.75 {
+		t.Errorf("unexpected conn status: %+v", status)
+	}
+	if status.Connect.Count != 1 || status.Connect.Max != 10 {
+		t.Errorf("unexpected connect status: %+v", status.Connect)
+	}
+	if status.ConnectFailures["connectionRefused"] != 1 {
+		t.Errorf("unexpected connect failures: %v", status.ConnectFailures)
+	}
+	if status.TLSHandshakes != 1 || status.TLSHandshake.Max != 20 {
+		t.Errorf("unexpected handshake status: %+v", status.TLSHandshake)
+	}
+	if status.TLSHandshakeFailures["eof"] != 1 {
+		t.Errorf("unexpected handshake failures: %v", status.TLSHandshakeFailures)
+	}
+}
+
+func TestServerErrorLog(t *testing.T) {
+	cs := New()
+	buff := bytes.NewBuffer(nil)
+	l := cs.ServerErrorLog(buff)
+
+	l.Printf("http: TLS handshake error from 127.0.0.1:5678: tls: first record does not look like a TLS handshake")
+	l.Printf("http: TLS handshake error from 127.0.0.1:5679: EOF")
+	l.Printf("http: panic serving 127.0.0.1:5680")
+
+	status := cs.Status()
+	if status.TLSHandshakeFailures["notTLS"] != 1 || status.TLSHandshakeFailures["eof"] != 1 {
+		t.Errorf("unexpected handshake failures: %v", status.TLSHandshakeFailures)
+	}
+	if !bytes.Contains(buff.Bytes(), []byte("panic serving")) {
+		t.Errorf("expect other messages to be written")
+	}
+}
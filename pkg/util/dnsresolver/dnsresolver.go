@@ -0,0 +1,325 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dnsresolver resolves the names looked up by the gateway itself,
+// e.g. the ones of backends, webhooks and identity providers, by
+// DNS-over-TLS (RFC 7858) or DNS-over-HTTPS (RFC 8484) resolvers, for the
+// environments where the plaintext DNS is blocked or untrusted.
+//
+// The resolvers are pinned by IP addresses, so reaching them requires no
+// plaintext lookup. They're tried in order, and the next one is used once
+// the current one fails.
+package dnsresolver
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	schemeTLS   = "tls"
+	schemeHTTPS = "https"
+
+	defaultTLSPort   = "853"
+	defaultHTTPSPort = "443"
+
+	dohContentType = "application/dns-message"
+	maxMessageSize = 65535
+
+	dialTimeout = 5 * time.Second
+)
+
+type (
+	// Resolver is a DNS-over-TLS or DNS-over-HTTPS resolver.
+	Resolver struct {
+		url     string
+		dot     bool
+		address string
+		// dohURL is the URL of DNS-over-HTTPS requests.
+		dohURL    string
+		tlsConfig *tls.Config
+		client    *http.Client
+	}
+
+	// resolvers are the resolvers tried in order.
+	resolvers struct {
+		list    []*Resolver
+		current uint32
+	}
+
+	// dohConn is the connection which the Go resolver writes the query to
+	// and reads the response from, in the format of DNS over TCP, the
+	// query is sent by a DNS-over-HTTPS request on the first read.
+	dohConn struct {
+		ctx       context.Context
+		resolver  *Resolver
+		onFailure func()
+
+		deadline time.Time
+		query    bytes.Buffer
+		response io.Reader
+	}
+
+	dohAddr string
+)
+
+// Parse parses the URL of a resolver, in the formats below, the server
+// name verifies the certificate of the resolver, the default is the IP:
+//
+//	tls://<ip>[:<port>][?serverName=<name>]
+//	https://<ip>[:<port>]/<path>[?serverName=<name>]
+func Parse(rawURL string) (*Resolver, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resolver{url: rawURL}
+	switch u.Scheme {
+	case schemeTLS:
+		r.dot = true
+		if u.Path != "" {
+			return nil, fmt.Errorf("%s: path is not allowed", rawURL)
+		}
+	case schemeHTTPS:
+	default:
+		return nil, fmt.Errorf("%s: scheme must be tls or https", rawURL)
+	}
+
+	host, port := u.Hostname(), u.Port()
+	if net.ParseIP(host) == nil {
+		return nil, fmt.Errorf("%s: host must be an IP address", rawURL)
+	}
+	if port == "" {
+		port = defaultHTTPSPort
+		if r.dot {
+			port = defaultTLSPort
+		}
+	}
+	r.address = net.JoinHostPort(host, port)
+
+	query := u.Query()
+	serverName := query.Get("serverName")
+	if serverName == "" {
+		serverName = host
+	}
+	r.tlsConfig = &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if !r.dot {
+		query.Del("serverName")
+		u.RawQuery = query.Encode()
+		u.Host = r.address
+		u.Fragment = ""
+		r.dohURL = u.String()
+		r.client = &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+					// NOTE: Always dial the pinned address.
+					return (&net.Dialer{Timeout: dialTimeout}).DialContext(ctx, network, r.address)
+				},
+				TLSClientConfig:     r.tlsConfig,
+				ForceAttemptHTTP2:   true,
+				MaxIdleConnsPerHost: 4,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		}
+	}
+
+	return r, nil
+}
+
+// String returns the URL of the resolver.
+func (r *Resolver) String() string {
+	return r.url
+}
+
+// Enable replaces the default resolver of the process with the resolvers,
+// it must be called before any lookup.
+func Enable(urls []string) error {
+	if len(urls) == 0 {
+		return fmt.Errorf("no resolver")
+	}
+
+	rs := &resolvers{}
+	for _, u := range urls {
+		r, err := Parse(u)
+		if err != nil {
+			return err
+		}
+		rs.list = append(rs.list, r)
+	}
+
+	net.DefaultResolver = &net.Resolver{
+		PreferGo: true,
+		Dial:     rs.dial,
+	}
+
+	return nil
+}
+
+// dial is the Dial of net.Resolver, the address of the plaintext name
+// server is ignored. The connection is never a net.PacketConn, so the Go
+// resolver talks to it in the format of DNS over TCP.
+func (rs *resolvers) dial(ctx context.Context, _, _ string) (net.Conn, error) {
+	var lastErr error
+	for range rs.list {
+		current := atomic.LoadUint32(&rs.current)
+		r := rs.list[current%uint32(len(rs.list))]
+
+		conn, err := r.dial(ctx, func() { rs.failover(current) })
+		if err == nil {
+			return conn, nil
+		}
+
+		lastErr = fmt.Errorf("%s: %v", r, err)
+		rs.failover(current)
+	}
+
+	return nil, lastErr
+}
+
+// failover moves to the next resolver if the current one is still the
+// failed one.
+func (rs *resolvers) failover(failed uint32) {
+	atomic.CompareAndSwapUint32(&rs.current, failed, failed+1)
+}
+
+func (r *Resolver) dial(ctx context.Context, onFailure func()) (net.Conn, error) {
+	if !r.dot {
+		return &dohConn{ctx: ctx, resolver: r, onFailure: onFailure}, nil
+	}
+
+	dialer := &tls.Dialer{
+		NetDialer: &net.Dialer{Timeout: dialTimeout},
+		Config:    r.tlsConfig,
+	}
+	return dialer.DialContext(ctx, "tcp", r.address)
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	if c.response != nil {
+		return 0, fmt.Errorf("write after the response")
+	}
+	return c.query.Write(b)
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.response == nil {
+		response, err := c.roundTrip()
+		if err != nil {
+			c.onFailure()
+			return 0, err
+		}
+		c.response = response
+	}
+
+	return c.response.Read(b)
+}
+
+// roundTrip sends the query by a DNS-over-HTTPS request, and returns the
+// response with the length prefix of DNS over TCP.
+func (c *dohConn) roundTrip() (io.Reader, error) {
+	query := c.query.Bytes()
+	if len(query) < 2 || int(binary.BigEndian.Uint16(query)) != len(query)-2 {
+		return nil, fmt.Errorf("invalid query")
+	}
+
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.resolver.dohURL, bytes.NewReader(query[2:]))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dohContentType)
+	req.Header.Set("Accept", dohContentType)
+
+	resp, err := c.resolver.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: status code %d", c.resolver, resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxMessageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxMessageSize {
+		return nil, fmt.Errorf("%s: response too large", c.resolver)
+	}
+
+	response := make([]byte, 2+len(body))
+	binary.BigEndian.PutUint16(response, uint16(len(body)))
+	copy(response[2:], body)
+
+	return bytes.NewReader(response), nil
+}
+
+func (c *dohConn) Close() error {
+	return nil
+}
+
+func (c *dohConn) LocalAddr() net.Addr {
+	return dohAddr("")
+}
+
+func (c *dohConn) RemoteAddr() net.Addr {
+	return dohAddr(c.resolver.url)
+}
+
+func (c *dohConn) SetDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetReadDeadline(t time.Time) error {
+	c.deadline = t
+	return nil
+}
+
+func (c *dohConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+func (a dohAddr) Network() string {
+	return schemeHTTPS
+}
+
+func (a dohAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,189 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dnsresolver
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	r, err := Parse("tls://1.1.1.1?serverName=cloudflare-dns.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !r.dot || r.address != "1.1.1.1:853" || r.tlsConfig.ServerName != "cloudflare-dns.com" {
+		t.Errorf("unexpected resolver: %+v", r)
+	}
+
+	r, err = Parse("https://[2606:4700:4700::1111]/dns-query?serverName=cloudflare-dns.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.dot || r.address != "[2606:4700:4700::1111]:443" {
+		t.Errorf("unexpected resolver: %+v", r)
+	}
+	if r.dohURL != "https://[2606:4700:4700::1111]:443/dns-query" {
+		t.Errorf("unexpected url: %s", r.dohURL)
+	}
+
+	r, err = Parse("tls://8.8.8.8:8853")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.address != "8.8.8.8:8853" || r.tlsConfig.ServerName != "8.8.8.8" {
+		t.Errorf("unexpected resolver: %+v", r)
+	}
+
+	for _, u := range []string{
+		"udp://1.1.1.1",
+		"tls://dns.google",
+		"https://dns.google/dns-query",
+		"tls://1.1.1.1/dns-query",
+		"://",
+	} {
+		if _, err := Parse(u); err == nil {
+			t.Errorf("expect error for %s", u)
+		}
+	}
+
+	if err := Enable(nil); err == nil {
+		t.Errorf("expect error for no resolver")
+	}
+}
+
+// answer answers A queries with 10.0.0.1, and others with no record.
+func answer(query []byte) []byte {
+	// Skip the name of the question.
+	end := 12
+	for query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+
+	resp := append([]byte{}, query[:end]...)
+	resp[2] |= 0x80 // QR
+	resp[3] = 0x80  // RA
+	binary.BigEndian.PutUint16(resp[6:], 0)
+	binary.BigEndian.PutUint16(resp[8:], 0)
+	binary.BigEndian.PutUint16(resp[10:], 0)
+
+	if binary.BigEndian.Uint16(query[end-4:]) == 1 {
+		binary.BigEndian.PutUint16(resp[6:], 1)
+		resp = append(resp, 0xc0, 0x0c, 0, 1, 0, 1, 0, 0, 0x0e, 0x10, 0, 4, 10, 0, 0, 1)
+	}
+
+	return resp
+}
+
+func lookup(t *testing.T, rs *resolvers) {
+	resolver := &net.Resolver{PreferGo: true, Dial: rs.dial}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	addrs, err := resolver.LookupHost(ctx, "backend.example.test.")
+	if err != nil {
+		t.Fatalf("lookup failed: %v", err)
+	}
+	if len(addrs) != 1 || addrs[0] != "10.0.0.1" {
+		t.Errorf("unexpected addresses: %v", addrs)
+	}
+}
+
+func TestDoH(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost || req.Header.Get("Content-Type") != dohContentType {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		query, _ := ioutil.ReadAll(req.Body)
+		w.Header().Set("Content-Type", dohContentType)
+		w.Write(answer(query))
+	}))
+	defer server.Close()
+
+	failed, err := Parse("https://127.0.0.1:1/dns-query")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r, err := Parse("https://" + server.Listener.Addr().String() + "/dns-query")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.client = server.Client()
+
+	// The failed one is skipped after the failure.
+	rs := &resolvers{list: []*Resolver{failed, r}}
+	lookup(t, rs)
+	if rs.current == 0 {
+		t.Errorf("should fail over to the next resolver")
+	}
+}
+
+func TestDoT(t *testing.T) {
+	server := httptest.NewUnstartedServer(nil)
+	server.StartTLS()
+	defer server.Close()
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", server.TLS)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for {
+					size := make([]byte, 2)
+					if _, err := io.ReadFull(conn, size); err != nil {
+						return
+					}
+					query := make([]byte, binary.BigEndian.Uint16(size))
+					if _, err := io.ReadFull(conn, query); err != nil {
+						return
+					}
+					resp := answer(query)
+					binary.BigEndian.PutUint16(size, uint16(len(resp)))
+					conn.Write(append(size, resp...))
+				}
+			}()
+		}
+	}()
+
+	r, err := Parse("tls://" + listener.Addr().String() + "?serverName=example.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	r.tlsConfig.RootCAs = server.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+
+	lookup(t, &resolvers{list: []*Resolver{r}})
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package egressproxy routes the outbound requests of the gateway, e.g.
+// the ones to backends, webhooks and token endpoints, through a forward
+// proxy, which is HTTP (CONNECT for HTTPS requests) or SOCKS5.
+//
+// The proxy is configured globally by the options of the server, or per
+// object, e.g. by the Proxy filter. Without them, the proxy is the one of
+// the environment variables HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+package egressproxy
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+type (
+	// Spec describes the egress proxy.
+	Spec struct {
+		// URL is the URL of the proxy, the scheme is http, https or
+		// socks5, the credentials are in the user info.
+		URL string `yaml:"url" jsonschema:"required,format=uri"`
+		// NoProxy are the hosts connected directly, in the format of
+		// NO_PROXY: "*" for all, IPs, CIDRs, and domains matching the
+		// subdomains too, a leading "." or "*." matches the subdomains
+		// only.
+		NoProxy []string `yaml:"noProxy" jsonschema:"omitempty,uniqueItems=true"`
+	}
+
+	// Proxy selects the proxy of requests.
+	Proxy struct {
+		url *url.URL
+
+		all     bool
+		ips     []net.IP
+		nets    []*net.IPNet
+		domains []string
+		// suffixes are the domains with the leading dot, which match
+		// the subdomains only.
+		suffixes []string
+	}
+)
+
+var (
+	global atomic.Value // *Proxy
+
+	// DefaultTransport is http.DefaultTransport using the global proxy,
+	// for the clients without their own transports.
+	DefaultTransport http.RoundTripper = newDefaultTransport()
+)
+
+func newDefaultTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = Global
+	return t
+}
+
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	u, err := url.Parse(spec.URL)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("invalid url %s: scheme must be http, https or socks5", spec.URL)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid url %s: host is required", spec.URL)
+	}
+
+	for _, host := range spec.NoProxy {
+		if host == "" {
+			return fmt.Errorf("empty host in noProxy")
+		}
+		if strings.Contains(host, "/") {
+			if _, _, err := net.ParseCIDR(host); err != nil {
+				return fmt.Errorf("invalid cidr %s in noProxy", host)
+			}
+		}
+	}
+
+	return nil
+}
+
+// New creates a Proxy, the spec must be valid.
+func New(spec *Spec) *Proxy {
+	// NOTE: The spec has been validated.
+	u, _ := url.Parse(spec.URL)
+	p := &Proxy{url: u}
+
+	for _, host := range spec.NoProxy {
+		host = strings.ToLower(strings.TrimSpace(host))
+		switch {
+		case host == "*":
+			p.all = true
+		case strings.Contains(host, "/"):
+			_, ipNet, _ := net.ParseCIDR(host)
+			p.nets = append(p.nets, ipNet)
+		case net.ParseIP(host) != nil:
+			p.ips = append(p.ips, net.ParseIP(host))
+		case strings.HasPrefix(host, "*."):
+			p.suffixes = append(p.suffixes, host[1:])
+		case strings.HasPrefix(host, "."):
+			p.suffixes = append(p.suffixes, host)
+		default:
+			p.domains = append(p.domains, host)
+		}
+	}
+
+	return p
+}
+
+// SetGlobal sets the global proxy, nil restores the proxy of the
+// environment variables.
+func SetGlobal(spec *Spec) error {
+	if spec == nil {
+		global.Store((*Proxy)(nil))
+		return nil
+	}
+
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	global.Store(New(spec))
+	return nil
+}
+
+// Global is the Proxy of http.Transport using the global proxy.
+func Global(req *http.Request) (*url.URL, error) {
+	if p, _ := global.Load().(*Proxy); p != nil {
+		return p.Func(req)
+	}
+	return http.ProxyFromEnvironment(req)
+}
+
+// ProxyFunc returns the Proxy of http.Transport, it uses the proxy of
+// the spec if it is not nil, and the global one otherwise.
+func ProxyFunc(spec *Spec) func(*http.Request) (*url.URL, error) {
+	if spec == nil {
+		return Global
+	}
+	return New(spec).Func
+}
+
+// Func is the Proxy of http.Transport, it returns nil for the requests
+// connected directly.
+func (p *Proxy) Func(req *http.Request) (*url.URL, error) {
+	if p.bypass(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return p.url, nil
+}
+
+// bypass returns whether the host is connected directly.
+func (p *Proxy) bypass(host string) bool {
+	if p.all {
+		return true
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		for _, x := range p.ips {
+			if x.Equal(ip) {
+				return true
+			}
+		}
+		for _, ipNet := range p.nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, domain := range p.domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	for _, suffix := range p.suffixes {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
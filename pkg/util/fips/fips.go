@@ -0,0 +1,150 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package fips restricts the cryptographic algorithms and settings to the
+// FIPS 140-2 approved ones.
+//
+// The FIPS mode is enabled by the option fips, or by building with
+// BoringCrypto (the build tag boringcrypto), in which case the crypto
+// libraries of Go are backed by the validated BoringCrypto module, and
+// the Go runtime enforces the TLS settings as well.
+package fips
+
+import (
+	"crypto/tls"
+	"fmt"
+	"sync/atomic"
+)
+
+// MinHMACKeySize is the min size of HMAC keys in bytes, keys shorter
+// than 112 bits are disallowed by NIST SP 800-131A.
+const MinHMACKeySize = 14
+
+var (
+	enabled int32
+
+	// cipherSuites are the approved cipher suites, TLS 1.2 only.
+	cipherSuites = []uint16{
+		tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+		tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+		tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	}
+
+	// curves are the approved elliptic curves.
+	curves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+)
+
+// Enable enables the FIPS mode, it must be called before creating any
+// objects.
+func Enable() {
+	atomic.StoreInt32(&enabled, 1)
+}
+
+// Enabled returns whether the FIPS mode is enabled, it is always enabled
+// for the builds with BoringCrypto.
+func Enabled() bool {
+	return boringCrypto || atomic.LoadInt32(&enabled) == 1
+}
+
+// BoringCrypto returns whether the binary is built with BoringCrypto.
+func BoringCrypto() bool {
+	return boringCrypto
+}
+
+// CheckHMACKey checks the size of the HMAC key.
+func CheckHMACKey(key []byte) error {
+	if Enabled() && len(key) < MinHMACKeySize {
+		return fmt.Errorf("HMAC keys shorter than %d bytes are not allowed in FIPS mode", MinHMACKeySize)
+	}
+	return nil
+}
+
+// CheckTLSVersions checks the TLS versions, zero means the default. Only
+// TLS 1.2 is allowed, as the cipher suites of TLS 1.3 are not
+// configurable.
+func CheckTLSVersions(minVersion, maxVersion uint16) error {
+	if !Enabled() {
+		return nil
+	}
+	if minVersion != 0 && minVersion != tls.VersionTLS12 {
+		return fmt.Errorf("only TLS 1.2 is allowed in FIPS mode")
+	}
+	if maxVersion != 0 && maxVersion != tls.VersionTLS12 {
+		return fmt.Errorf("only TLS 1.2 is allowed in FIPS mode")
+	}
+	return nil
+}
+
+// CheckCipherSuite checks the TLS cipher suite.
+func CheckCipherSuite(id uint16) error {
+	if !Enabled() {
+		return nil
+	}
+	for _, suite := range cipherSuites {
+		if suite == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("cipher suite %s is not allowed in FIPS mode", tls.CipherSuiteName(id))
+}
+
+// CheckCurve checks the elliptic curve.
+func CheckCurve(id tls.CurveID) error {
+	if !Enabled() {
+		return nil
+	}
+	for _, curve := range curves {
+		if curve == id {
+			return nil
+		}
+	}
+	return fmt.Errorf("curve %d is not allowed in FIPS mode", id)
+}
+
+// ApplyTLS restricts the TLS config to the approved settings, the
+// settings which are not approved are removed, and the approved ones
+// are used if nothing is left.
+func ApplyTLS(conf *tls.Config) {
+	if !Enabled() {
+		return
+	}
+
+	conf.MinVersion, conf.MaxVersion = tls.VersionTLS12, tls.VersionTLS12
+
+	suites := conf.CipherSuites[:0:0]
+	for _, id := range conf.CipherSuites {
+		if CheckCipherSuite(id) == nil {
+			suites = append(suites, id)
+		}
+	}
+	if len(suites) == 0 {
+		suites = append(suites, cipherSuites...)
+	}
+	conf.CipherSuites = suites
+
+	prefs := conf.CurvePreferences[:0:0]
+	for _, id := range conf.CurvePreferences {
+		if CheckCurve(id) == nil {
+			prefs = append(prefs, id)
+		}
+	}
+	if len(prefs) == 0 {
+		prefs = append(prefs, curves...)
+	}
+	conf.CurvePreferences = prefs
+}
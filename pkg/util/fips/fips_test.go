@@ -0,0 +1,121 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package fips
+
+import (
+	"crypto/tls"
+	"sync/atomic"
+	"testing"
+)
+
+func setEnabled(t *testing.T, on bool) {
+	if !on && boringCrypto {
+		t.Skip("FIPS mode is always enabled with BoringCrypto")
+	}
+	if on {
+		atomic.StoreInt32(&enabled, 1)
+	} else {
+		atomic.StoreInt32(&enabled, 0)
+	}
+	t.Cleanup(func() { atomic.StoreInt32(&enabled, 0) })
+}
+
+func TestDisabled(t *testing.T) {
+	setEnabled(t, false)
+
+	if Enabled() {
+		t.Fatalf("FIPS mode should be disabled")
+	}
+	if CheckHMACKey([]byte("short")) != nil ||
+		CheckTLSVersions(tls.VersionTLS10, tls.VersionTLS13) != nil ||
+		CheckCipherSuite(tls.TLS_CHACHA20_POLY1305_SHA256) != nil ||
+		CheckCurve(tls.X25519) != nil {
+		t.Errorf("nothing should be checked when FIPS mode is disabled")
+	}
+
+	conf := &tls.Config{}
+	ApplyTLS(conf)
+	if conf.MinVersion != 0 || conf.CipherSuites != nil || conf.CurvePreferences != nil {
+		t.Errorf("TLS config should not be changed")
+	}
+}
+
+func TestChecks(t *testing.T) {
+	setEnabled(t, true)
+
+	if CheckHMACKey([]byte("short")) == nil {
+		t.Errorf("short HMAC key should be rejected")
+	}
+	if err := CheckHMACKey([]byte("0123456789abcdef")); err != nil {
+		t.Errorf("long HMAC key should be allowed: %v", err)
+	}
+
+	if err := CheckTLSVersions(0, 0); err != nil {
+		t.Errorf("default versions should be allowed: %v", err)
+	}
+	if err := CheckTLSVersions(tls.VersionTLS12, tls.VersionTLS12); err != nil {
+		t.Errorf("TLS 1.2 should be allowed: %v", err)
+	}
+	if CheckTLSVersions(tls.VersionTLS11, 0) == nil || CheckTLSVersions(0, tls.VersionTLS13) == nil {
+		t.Errorf("TLS versions other than 1.2 should be rejected")
+	}
+
+	if err := CheckCipherSuite(tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256); err != nil {
+		t.Errorf("AES-GCM cipher suite should be allowed: %v", err)
+	}
+	if CheckCipherSuite(tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256) == nil {
+		t.Errorf("ChaCha20 cipher suite should be rejected")
+	}
+
+	if err := CheckCurve(tls.CurveP384); err != nil {
+		t.Errorf("P384 should be allowed: %v", err)
+	}
+	if CheckCurve(tls.X25519) == nil {
+		t.Errorf("X25519 should be rejected")
+	}
+}
+
+func TestApplyTLS(t *testing.T) {
+	setEnabled(t, true)
+
+	conf := &tls.Config{
+		MinVersion: tls.VersionTLS10,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
+	}
+	ApplyTLS(conf)
+
+	if conf.MinVersion != tls.VersionTLS12 || conf.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("only TLS 1.2 should be allowed, got %x-%x", conf.MinVersion, conf.MaxVersion)
+	}
+	if len(conf.CipherSuites) != 1 || conf.CipherSuites[0] != tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("unapproved cipher suites should be removed, got %v", conf.CipherSuites)
+	}
+	if len(conf.CurvePreferences) != 1 || conf.CurvePreferences[0] != tls.CurveP256 {
+		t.Errorf("unapproved curves should be removed, got %v", conf.CurvePreferences)
+	}
+
+	conf = &tls.Config{CurvePreferences: []tls.CurveID{tls.X25519}}
+	ApplyTLS(conf)
+	if len(conf.CipherSuites) != len(cipherSuites) || len(conf.CurvePreferences) != len(curves) {
+		t.Errorf("approved settings should be used, got %v, %v", conf.CipherSuites, conf.CurvePreferences)
+	}
+}
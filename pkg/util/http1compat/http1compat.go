@@ -0,0 +1,392 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package http1compat provides a listener to be compatible with ancient
+// HTTP/1.x clients. It records the original case of request header names,
+// which is lost in net/http, and adds the Host header to the requests
+// missing it. It must be placed above the TLS layer, if any.
+package http1compat
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	maxHeaderSize    = 1 << 20
+	maxChunkLineSize = 4096
+)
+
+type (
+	// Options are the options of the listener.
+	Options struct {
+		// PreserveHeaderCase records the original case of request header names.
+		PreserveHeaderCase bool
+		// AddMissingHost adds the Host header to the HTTP/1.x requests
+		// missing it, the value is the local address of the connection.
+		AddMissingHost bool
+	}
+
+	// Listener wraps a net.Listener to be compatible with ancient HTTP/1.x clients.
+	Listener struct {
+		net.Listener
+		opts *Options
+	}
+
+	parseState int
+
+	// conn parses the HTTP/1.x requests in the data read from the connection.
+	conn struct {
+		net.Conn
+		opts *Options
+		host string
+
+		state     parseState
+		line      []byte
+		remaining int64
+
+		in  []byte
+		out []byte
+
+		mutex sync.Mutex
+		names [][]string
+	}
+
+	connContextKey    struct{}
+	requestContextKey struct{}
+)
+
+const (
+	stateHeader parseState = iota
+	stateBody
+	stateChunkSize
+	stateChunkData
+	stateChunkDataEnd
+	stateTrailer
+	// statePassthrough stops parsing, for the protocol is switched or
+	// the data can't be parsed.
+	statePassthrough
+)
+
+// NewListener creates a Listener.
+func NewListener(l net.Listener, opts *Options) *Listener {
+	return &Listener{Listener: l, opts: opts}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: c, opts: l.opts, host: c.LocalAddr().String()}, nil
+}
+
+// ConnContext is used as the ConnContext of http.Server, it saves the
+// connection to the context.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if hc, ok := c.(*conn); ok {
+		return context.WithValue(ctx, connContextKey{}, hc)
+	}
+	return ctx
+}
+
+// BindRequest binds the original header names to the request, it must be
+// called exactly once for every request of a connection in order.
+func BindRequest(r *http.Request) *http.Request {
+	c, ok := r.Context().Value(connContextKey{}).(*conn)
+	if !ok {
+		return r
+	}
+
+	names := c.popNames()
+	if names == nil {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), requestContextKey{}, names))
+}
+
+// RestoreHeaderCase returns the header whose keys are in the original case
+// of the request bound to ctx. The header is returned directly if there's
+// nothing to restore, otherwise a new header is returned.
+func RestoreHeaderCase(ctx context.Context, header http.Header) http.Header {
+	names, ok := ctx.Value(requestContextKey{}).([]string)
+	if !ok {
+		return header
+	}
+
+	originals := map[string]string{}
+	for _, name := range names {
+		key := textproto.CanonicalMIMEHeaderKey(name)
+		if key == name {
+			continue
+		}
+		if _, exists := originals[key]; !exists {
+			originals[key] = name
+		}
+	}
+	if len(originals) == 0 {
+		return header
+	}
+
+	result := make(http.Header, len(header))
+	for k, v := range header {
+		if name, exists := originals[k]; exists {
+			k = name
+		}
+		result[k] = v
+	}
+	return result
+}
+
+func (c *conn) popNames() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if len(c.names) == 0 {
+		return nil
+	}
+	names := c.names[0]
+	c.names = c.names[1:]
+	return names
+}
+
+func (c *conn) pushNames(names []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.names = append(c.names, names)
+}
+
+// Read reads data from the connection and parses it.
+func (c *conn) Read(b []byte) (int, error) {
+	for len(c.out) == 0 {
+		n, err := c.Conn.Read(b)
+		if n > 0 {
+			c.parse(b[:n])
+		}
+		if len(c.out) == 0 {
+			if err != nil {
+				return 0, err
+			}
+			if n == 0 {
+				return 0, nil
+			}
+		}
+		if err != nil && len(c.out) > 0 {
+			break
+		}
+	}
+
+	n := copy(b, c.out)
+	c.out = c.out[n:]
+	if len(c.out) == 0 {
+		c.out = nil
+	}
+	return n, nil
+}
+
+// parse parses data and appends the result to c.out.
+func (c *conn) parse(data []byte) {
+	for len(data) > 0 {
+		switch c.state {
+		case statePassthrough:
+			c.out = append(c.out, data...)
+			return
+
+		case stateHeader:
+			c.in = append(c.in, data...)
+			data = nil
+
+			end, sepLen := headerEnd(c.in)
+			if end < 0 {
+				if len(c.in) > maxHeaderSize {
+					c.out = append(c.out, c.in...)
+					c.in, c.state = nil, statePassthrough
+				}
+				return
+			}
+
+			head, rest := c.in[:end+sepLen], c.in[end+sepLen:]
+			c.in = nil
+			c.handleHeader(head, end)
+			data = rest
+
+		case stateBody:
+			n := int64(len(data))
+			if n > c.remaining {
+				n = c.remaining
+			}
+			c.out = append(c.out, data[:n]...)
+			data = data[n:]
+			c.remaining -= n
+			if c.remaining == 0 {
+				c.state = stateHeader
+			}
+
+		case stateChunkData:
+			n := int64(len(data))
+			if n > c.remaining {
+				n = c.remaining
+			}
+			c.out = append(c.out, data[:n]...)
+			data = data[n:]
+			c.remaining -= n
+			if c.remaining == 0 {
+				c.state = stateChunkDataEnd
+			}
+
+		case stateChunkSize, stateChunkDataEnd, stateTrailer:
+			idx := bytes.IndexByte(data, '\n')
+			if idx < 0 {
+				c.line = append(c.line, data...)
+				c.out = append(c.out, data...)
+				if len(c.line) > maxChunkLineSize {
+					c.line, c.state = nil, statePassthrough
+				}
+				return
+			}
+
+			c.line = append(c.line, data[:idx]...)
+			c.out = append(c.out, data[:idx+1]...)
+			data = data[idx+1:]
+			line := strings.TrimSpace(string(c.line))
+			c.line = nil
+			c.handleChunkLine(line)
+		}
+	}
+}
+
+func (c *conn) handleChunkLine(line string) {
+	switch c.state {
+	case stateChunkSize:
+		if idx := strings.IndexByte(line, ';'); idx >= 0 {
+			line = strings.TrimSpace(line[:idx])
+		}
+		size, err := strconv.ParseInt(line, 16, 64)
+		switch {
+		case err != nil || size < 0:
+			c.state = statePassthrough
+		case size == 0:
+			c.state = stateTrailer
+		default:
+			c.state, c.remaining = stateChunkData, size
+		}
+	case stateChunkDataEnd:
+		c.state = stateChunkSize
+	case stateTrailer:
+		if line == "" {
+			c.state = stateHeader
+		}
+	}
+}
+
+// headerEnd returns the index of the end of the header and the length of
+// the separator, or -1 if the header is incomplete.
+func headerEnd(data []byte) (int, int) {
+	if idx := bytes.Index(data, []byte("\r\n\r\n")); idx >= 0 {
+		if idx2 := bytes.Index(data[:idx+2], []byte("\n\n")); idx2 >= 0 {
+			return idx2, 2
+		}
+		return idx, 4
+	}
+	if idx := bytes.Index(data, []byte("\n\n")); idx >= 0 {
+		return idx, 2
+	}
+	return -1, 0
+}
+
+// handleHeader handles the header of a request, head is the whole header
+// including the separator, and end is the index of the separator.
+func (c *conn) handleHeader(head []byte, end int) {
+	lines := strings.Split(string(head[:end]), "\n")
+
+	// Skip the empty lines before the request line, which are allowed.
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) == 0 {
+		c.out = append(c.out, head...)
+		return
+	}
+
+	requestLine := strings.Fields(lines[0])
+	method, version := "", ""
+	if len(requestLine) == 3 {
+		method, version = requestLine[0], requestLine[2]
+	}
+
+	var (
+		names         []string
+		hasHost       bool
+		chunked       bool
+		contentLength int64
+		upgrade       bool
+	)
+	for _, line := range lines[1:] {
+		idx := strings.IndexByte(line, ':')
+		if idx <= 0 {
+			continue
+		}
+		name := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		names = append(names, name)
+
+		switch strings.ToLower(name) {
+		case "host":
+			hasHost = true
+		case "transfer-encoding":
+			chunked = strings.Contains(strings.ToLower(value), "chunked")
+		case "content-length":
+			contentLength, _ = strconv.ParseInt(value, 10, 64)
+		case "upgrade":
+			upgrade = true
+		}
+	}
+
+	if c.opts.AddMissingHost && !hasHost && strings.HasPrefix(version, "HTTP/1.") {
+		sep := head[end:]
+		c.out = append(c.out, head[:end]...)
+		c.out = append(c.out, sep[:len(sep)/2]...)
+		c.out = append(c.out, "Host: "...)
+		c.out = append(c.out, c.host...)
+		c.out = append(c.out, sep...)
+	} else {
+		c.out = append(c.out, head...)
+	}
+
+	if c.opts.PreserveHeaderCase {
+		c.pushNames(names)
+	}
+
+	switch {
+	case upgrade || method == http.MethodConnect:
+		c.state = statePassthrough
+	case chunked:
+		c.state = stateChunkSize
+	case contentLength > 0:
+		c.state, c.remaining = stateBody, contentLength
+	default:
+		c.state = stateHeader
+	}
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http1compat
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	c := &conn{opts: &Options{PreserveHeaderCase: true, AddMissingHost: true}, host: "10.0.0.1:80"}
+
+	data := "POST /a HTTP/1.1\r\nhost: example.com\r\ncontent-LENGTH: 5\r\n\r\nhello" +
+		"POST /b HTTP/1.1\r\nTransfer-Encoding: chunked\r\nX-lower-case: 1\r\n\r\n" +
+		"3;ext=1\r\nabc\r\n0\r\nTrailer: x\r\n\r\n" +
+		"GET /c HTTP/1.0\n\n"
+
+	// Feed the data byte by byte to cover partial reads.
+	for i := 0; i < len(data); i++ {
+		c.parse([]byte{data[i]})
+	}
+
+	want := "POST /a HTTP/1.1\r\nhost: example.com\r\ncontent-LENGTH: 5\r\n\r\nhello" +
+		"POST /b HTTP/1.1\r\nTransfer-Encoding: chunked\r\nX-lower-case: 1\r\nHost: 10.0.0.1:80\r\n\r\n" +
+		"3;ext=1\r\nabc\r\n0\r\nTrailer: x\r\n\r\n" +
+		"GET /c HTTP/1.0\nHost: 10.0.0.1:80\n\n"
+	if string(c.out) != want {
+		t.Fatalf("want:\n%q\ngot:\n%q", want, c.out)
+	}
+
+	wantNames := [][]string{
+		{"host", "content-LENGTH"},
+		{"Transfer-Encoding", "X-lower-case"},
+		nil,
+	}
+	if fmt.Sprint(c.names) != fmt.Sprint(wantNames) {
+		t.Fatalf("want names %v, got %v", wantNames, c.names)
+	}
+	if c.state != stateHeader {
+		t.Fatalf("expect header state, got %d", c.state)
+	}
+}
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+
+	opts := &Options{PreserveHeaderCase: true, AddMissingHost: true}
+	srv := &http.Server{
+		ConnContext: ConnContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r = BindRequest(r)
+			body, _ := io.ReadAll(r.Body)
+			header := RestoreHeaderCase(r.Context(), r.Header)
+			var keys []string
+			for k := range header {
+				if strings.HasPrefix(strings.ToLower(k), "x-") {
+					keys = append(keys, k)
+				}
+			}
+			fmt.Fprintf(w, "%s %s %v", r.Host, body, keys)
+		}),
+	}
+	go srv.Serve(NewListener(l, opts))
+	defer srv.Close()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer c.Close()
+
+	fmt.Fprintf(c, "POST / HTTP/1.1\r\nx-device-id: 1\r\nContent-Length: 3\r\n\r\nabc")
+	fmt.Fprintf(c, "GET / HTTP/1.1\r\nHost: example.com\r\nX-DEVICE-TYPE: 2\r\n\r\n")
+
+	r := bufio.NewReader(c)
+	for _, want := range []string{
+		l.Addr().String() + " abc [x-device-id]",
+		"example.com  [X-DEVICE-TYPE]",
+	} {
+		resp, err := http.ReadResponse(r, nil)
+		if err != nil {
+			t.Fatalf("read response failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != want {
+			t.Errorf("want %q, got %q", want, body)
+		}
+	}
+}
@@ -19,7 +19,6 @@ package httpheader
 
 import (
 	"net/http"
-	"net/textproto"
 
 	"github.com/megaease/easegress/pkg/logger"
 	"github.com/megaease/easegress/pkg/util/texttemplate"
@@ -46,6 +45,13 @@ func New(src http.Header) *HTTPHeader {
 	return &HTTPHeader{h: src}
 }
 
+// NewPair creates the HTTPHeaders of a request and its response in one
+// allocation, as both of them are created for every request.
+func NewPair(req, resp http.Header) (*HTTPHeader, *HTTPHeader) {
+	pair := &[2]HTTPHeader{{h: req}, {h: resp}}
+	return &pair[0], &pair[1]
+}
+
 // Reset resets internal src http.Header.
 func (h *HTTPHeader) Reset(src http.Header) {
 	for key := range h.h {
@@ -59,10 +65,20 @@ func (h *HTTPHeader) Reset(src http.Header) {
 
 // Copy copies HTTPHeader to a whole new HTTPHeader.
 func (h *HTTPHeader) Copy() *HTTPHeader {
-	n := make(http.Header)
+	total := 0
+	for _, values := range h.h {
+		total += len(values)
+	}
+
+	// NOTE: The values of all keys share one slab to reduce allocations,
+	// the capacity of every slice is limited, so appending to one of them
+	// doesn't overwrite the others.
+	slab := make([]string, total)
+	n := make(http.Header, len(h.h))
 	for key, values := range h.h {
-		copyValues := make([]string, len(values))
+		copyValues := slab[:len(values):len(values)]
 		copy(copyValues, values)
+		slab = slab[len(values):]
 		n[key] = copyValues
 	}
 
@@ -81,28 +97,32 @@ func (h *HTTPHeader) SetRaw(header http.Header) {
 
 // Add adds the key value pair.
 func (h *HTTPHeader) Add(key, value string) {
-	h.h.Add(key, value)
+	key = CanonicalKey(key)
+	h.h[key] = append(h.h[key], value)
 }
 
 // Get gets the FIRST value by the key.
 func (h *HTTPHeader) Get(key string) string {
-	return h.h.Get(key)
+	values := h.h[CanonicalKey(key)]
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
 }
 
 // GetAll gets all values of the key.
 func (h *HTTPHeader) GetAll(key string) []string {
-	key = textproto.CanonicalMIMEHeaderKey(key)
-	return h.h[key]
+	return h.h[CanonicalKey(key)]
 }
 
 // Set the key value pair of headers.
 func (h *HTTPHeader) Set(key, value string) {
-	h.h.Set(key, value)
+	h.h[CanonicalKey(key)] = []string{value}
 }
 
 // Del deletes the key value pair by the key.
 func (h *HTTPHeader) Del(key string) {
-	h.h.Del(key)
+	delete(h.h, CanonicalKey(key))
 }
 
 // VisitAll call fn with every key value pair.
@@ -137,7 +157,7 @@ func (h *HTTPHeader) Length() int {
 func (h *HTTPHeader) AddFrom(src *HTTPHeader) {
 	for key, values := range src.h {
 		for _, value := range values {
-			h.h.Add(key, value)
+			h.Add(key, value)
 		}
 	}
 }
@@ -152,7 +172,7 @@ func (h *HTTPHeader) AddFromStd(src http.Header) {
 func (h *HTTPHeader) SetFrom(src *HTTPHeader) {
 	for key, values := range src.h {
 		for _, value := range values {
-			h.h.Set(key, value)
+			h.Set(key, value)
 		}
 	}
 }
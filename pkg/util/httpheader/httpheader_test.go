@@ -0,0 +1,192 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpheader
+
+import (
+	"net/http"
+	"net/textproto"
+	"testing"
+)
+
+func TestCanonicalKey(t *testing.T) {
+	keys := []string{
+		"", "a", "A", "content-type", "Content-Type", "CONTENT-TYPE",
+		"x-request-id", "X-REQUEST-ID", "etag", "ETag", "x_foo", "-foo",
+		"foo-", "foo--bar", "foo bar", "Foo bar", "x-ümlaut", "WWW-Authenticate",
+	}
+	for _, key := range keys {
+		// Twice for the interned ones.
+		for i := 0; i < 2; i++ {
+			want := textproto.CanonicalMIMEHeaderKey(key)
+			if got := CanonicalKey(key); got != want {
+				t.Errorf("canonical key of %q: want %q, got %q", key, want, got)
+			}
+		}
+	}
+}
+
+func TestCanonicalKeyAllocs(t *testing.T) {
+	CanonicalKey("x-custom-key")
+	allocs := testing.AllocsPerRun(100, func() {
+		CanonicalKey("x-custom-key")
+		CanonicalKey("content-type")
+		CanonicalKey("Content-Type")
+	})
+	if allocs != 0 {
+		t.Errorf("interned keys should not allocate, got %v allocs", allocs)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	h := New(http.Header{})
+	h.Add("x-foo", "1")
+	h.Add("X-FOO", "2")
+	h.Set("content-type", "text/plain")
+
+	if got := h.GetAll("x-foo"); len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("unexpected values: %v", got)
+	}
+	if h.Get("Content-Type") != "text/plain" || h.Std().Get("content-type") != "text/plain" {
+		t.Errorf("unexpected content type")
+	}
+	if h.Get("x-bar") != "" {
+		t.Errorf("absent key should be empty")
+	}
+
+	h.Del("X-Foo")
+	if len(h.Std()) != 1 {
+		t.Errorf("x-foo should be deleted")
+	}
+}
+
+func TestCopy(t *testing.T) {
+	h := New(http.Header{
+		"A": {"1", "2"},
+		"B": {"3"},
+		"C": {},
+	})
+	c := h.Copy()
+
+	if got := c.GetAll("a"); len(got) != 2 || got[0] != "1" || got[1] != "2" {
+		t.Errorf("unexpected values: %v", got)
+	}
+	if values, ok := c.Std()["C"]; !ok || len(values) != 0 {
+		t.Errorf("empty values should be copied")
+	}
+
+	// Appending to the values of a key doesn't overwrite the others.
+	c.Add("a", "x")
+	c.Add("c", "y")
+	c.Set("b", "z")
+	if c.Get("b") != "z" || len(c.GetAll("a")) != 3 || c.Get("c") != "y" {
+		t.Errorf("unexpected copy: %v", c.Std())
+	}
+	if len(h.GetAll("a")) != 2 || h.Get("b") != "3" || len(h.GetAll("c")) != 0 {
+		t.Errorf("the source should not be changed: %v", h.Std())
+	}
+}
+
+func TestNewPair(t *testing.T) {
+	reqHeader, respHeader := http.Header{}, http.Header{}
+	req, resp := NewPair(reqHeader, respHeader)
+	req.Set("x-a", "1")
+	resp.Set("x-b", "2")
+	if reqHeader.Get("X-A") != "1" || respHeader.Get("X-B") != "2" {
+		t.Errorf("headers should wrap their sources")
+	}
+	if reqHeader.Get("X-B") != "" || respHeader.Get("X-A") != "" {
+		t.Errorf("headers should not be mixed")
+	}
+}
+
+var (
+	sink        http.Header
+	sinkHeaders [2]*HTTPHeader
+)
+
+func benchmarkHeader() http.Header {
+	return http.Header{
+		"Accept":          {"*/*"},
+		"Accept-Encoding": {"gzip, deflate"},
+		"Content-Type":    {"application/json"},
+		"Cookie":          {"a=1", "b=2"},
+		"User-Agent":      {"benchmark"},
+		"X-Forwarded-For": {"10.0.0.1"},
+		"X-Request-Id":    {"abc"},
+	}
+}
+
+func BenchmarkStdSetGet(b *testing.B) {
+	b.ReportAllocs()
+	h := benchmarkHeader()
+	for i := 0; i < b.N; i++ {
+		h.Set("x-custom-key", "value")
+		h.Get("x-request-id")
+		h.Del("x-custom-key")
+	}
+}
+
+func BenchmarkSetGet(b *testing.B) {
+	b.ReportAllocs()
+	h := New(benchmarkHeader())
+	for i := 0; i < b.N; i++ {
+		h.Set("x-custom-key", "value")
+		h.Get("x-request-id")
+		h.Del("x-custom-key")
+	}
+}
+
+func BenchmarkStdCopy(b *testing.B) {
+	b.ReportAllocs()
+	h := benchmarkHeader()
+	for i := 0; i < b.N; i++ {
+		n := make(http.Header)
+		for key, values := range h {
+			copyValues := make([]string, len(values))
+			copy(copyValues, values)
+			n[key] = copyValues
+		}
+		sink = n
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	b.ReportAllocs()
+	h := New(benchmarkHeader())
+	for i := 0; i < b.N; i++ {
+		sink = h.Copy().Std()
+	}
+}
+
+// BenchmarkNew and BenchmarkNewPair benchmark creating the headers of
+// HTTPContext for every request.
+func BenchmarkNew(b *testing.B) {
+	b.ReportAllocs()
+	req, resp := benchmarkHeader(), http.Header{}
+	for i := 0; i < b.N; i++ {
+		sinkHeaders = [2]*HTTPHeader{New(req), New(resp)}
+	}
+}
+
+func BenchmarkNewPair(b *testing.B) {
+	b.ReportAllocs()
+	req, resp := benchmarkHeader(), http.Header{}
+	for i := 0; i < b.N; i++ {
+		sinkHeaders[0], sinkHeaders[1] = NewPair(req, resp)
+	}
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpheader
+
+import (
+	"net/textproto"
+	"sync"
+	"sync/atomic"
+)
+
+// maxInternedKeys bounds the number of interned keys, as the keys could
+// come from requests.
+const maxInternedKeys = 4096
+
+var (
+	internedKeys  sync.Map // map[string]string
+	internedCount int32
+)
+
+// commonKeys are the keys interned in advance, in their lower case.
+var commonKeys = []string{
+	"Accept", "Accept-Charset", "Accept-Encoding", "Accept-Language",
+	"Accept-Ranges", "Access-Control-Allow-Credentials",
+	"Access-Control-Allow-Headers", "Access-Control-Allow-Methods",
+	"Access-Control-Allow-Origin", "Access-Control-Expose-Headers",
+	"Access-Control-Max-Age", "Access-Control-Request-Headers",
+	"Access-Control-Request-Method", "Age", "Authorization",
+	"Cache-Control", "Connection", "Content-Disposition",
+	"Content-Encoding", "Content-Language", "Content-Length",
+	"Content-Type", "Cookie", "Date", "Etag", "Expires", "Forwarded",
+	"Host", "If-Match", "If-Modified-Since", "If-None-Match",
+	"Last-Modified", "Location", "Origin", "Pragma", "Range", "Referer",
+	"Retry-After", "Server", "Set-Cookie", "Strict-Transport-Security",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade", "User-Agent", "Vary",
+	"Via", "Www-Authenticate", "X-Forwarded-For", "X-Forwarded-Host",
+	"X-Forwarded-Proto", "X-Real-Ip", "X-Request-Id",
+}
+
+func init() {
+	for _, key := range commonKeys {
+		internedKeys.Store(toLower(key), key)
+	}
+	internedCount = int32(len(commonKeys))
+}
+
+func toLower(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// isCanonical returns whether the key is in the canonical format of
+// textproto.CanonicalMIMEHeaderKey, keys with invalid bytes are not
+// converted by it, so the result for them doesn't matter.
+func isCanonical(key string) bool {
+	upper := true
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if upper && 'a' <= c && c <= 'z' {
+			return false
+		}
+		if !upper && 'A' <= c && c <= 'Z' {
+			return false
+		}
+		upper = c == '-'
+	}
+	return true
+}
+
+// CanonicalKey returns the same result as textproto.CanonicalMIMEHeaderKey,
+// but the results of non-canonical keys are interned, so converting the
+// same key again doesn't allocate, e.g. the lower case keys in specs.
+func CanonicalKey(key string) string {
+	if isCanonical(key) {
+		return key
+	}
+	if canonical, ok := internedKeys.Load(key); ok {
+		return canonical.(string)
+	}
+
+	canonical := textproto.CanonicalMIMEHeaderKey(key)
+	if atomic.LoadInt32(&internedCount) < maxInternedKeys {
+		// NOTE: The key is copied, as it may refer to a larger buffer,
+		// e.g. the one of a request.
+		key = string([]byte(key))
+		if _, loaded := internedKeys.LoadOrStore(key, canonical); !loaded {
+			atomic.AddInt32(&internedCount, 1)
+		}
+	}
+	return canonical
+}
@@ -26,8 +26,14 @@ const (
 	KeyContentEncoding = "Content-Encoding"
 	// KeyContentLength is the key of Content-Length.
 	KeyContentLength = "Content-Length"
+	// KeyContentType is the key of Content-Type.
+	KeyContentType = "Content-Type"
 	// KeyVary is the key of Vary.
 	KeyVary = "Vary"
+	// KeyETag is the key of ETag.
+	KeyETag = "ETag"
+	// KeyIfNoneMatch is the key of If-None-Match.
+	KeyIfNoneMatch = "If-None-Match"
 
 	// KeyXForwardedFor is the key of X-Forwarded-For.
 	KeyXForwardedFor = "X-Forwarded-For"
@@ -19,6 +19,7 @@ package httpstat
 
 import (
 	"math"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -31,33 +32,69 @@ import (
 
 type (
 	// HTTPStat is the statistics tool for HTTP traffic.
+	//
+	// Stat is lock free, the counters are sharded and merged by Status,
+	// so recording doesn't serialize requests across cores. The rates
+	// are ticked by Status every tickInterval of wall time with the
+	// deltas of the counts, so they don't depend on how often Status is
+	// called.
 	HTTPStat struct {
-		mutex sync.RWMutex
+		// mutex serializes Status only.
+		mutex sync.Mutex
+
+		shards []statShard
+		mask   uint32
 
-		count  uint64
 		rate1  metrics.EWMA
 		rate5  metrics.EWMA
 		rate15 metrics.EWMA
 
-		errCount  uint64
 		errRate1  metrics.EWMA
 		errRate5  metrics.EWMA
 		errRate15 metrics.EWMA
 
-		m1ErrPercent  float64
-		m5ErrPercent  float64
-		m15ErrPercent float64
+		// lastTick is the time of the last tick of the rates,
+		// lastCount and lastErrCount are the counts at it.
+		lastTick     time.Time
+		lastCount    uint64
+		lastErrCount uint64
+
+		durationSampler *sampler.DurationSampler
+
+		// cc counts the codes without slots in shards.
+		cc *codecounter.HTTPStatusCodeCounter
+	}
+
+	// statShard is a shard of counters, which is padded to occupy its
+	// own cache lines.
+	statShard struct {
+		count    uint64
+		errCount uint64
 
 		total uint64
 		min   uint64
 		max   uint64
 
-		durationSampler *sampler.DurationSampler
-
 		reqSize  uint64
 		respSize uint64
 
-		cc *codecounter.HTTPStatusCodeCounter
+		reqSizeHistogram  sizeHistogram
+		respSizeHistogram sizeHistogram
+
+		// clientGone is the count of requests whose client disconnected
+		// before the response was completed.
+		clientGone uint64
+
+		codes [len(commonCodes)]uint64
+
+		_ [64]byte
+	}
+
+	// shardHint is the hint of the shard to use, the hints are cached in
+	// a sync.Pool, which is local to Ps, so goroutines running on
+	// different Ps get different hints in most cases.
+	shardHint struct {
+		index uint32
 	}
 
 	// Metric is the package of statistics at once.
@@ -66,6 +103,9 @@ type (
 		Duration   time.Duration
 		ReqSize    uint64
 		RespSize   uint64
+		// ClientGone is true if the client disconnected before the
+		// response was completed.
+		ClientGone bool
 	}
 
 	// Status contains all status generated by HTTPStat.
@@ -99,6 +139,16 @@ type (
 		ReqSize  uint64 `yaml:"reqSize"`
 		RespSize uint64 `yaml:"respSize"`
 
+		// ReqSizeHistogram and RespSizeHistogram are the counts of requests
+		// in size buckets since the last status, the upper bounds of the
+		// buckets are SizeBuckets, and the last one is for larger sizes.
+		ReqSizeHistogram  []uint64 `yaml:"reqSizeHistogram"`
+		RespSizeHistogram []uint64 `yaml:"respSizeHistogram"`
+
+		// ClientGone is the count of requests whose client disconnected
+		// before the response was completed.
+		ClientGone uint64 `yaml:"clientGone"`
+
 		Codes map[int]uint64 `yaml:"codes"`
 	}
 )
@@ -107,9 +157,69 @@ func (m *Metric) isErr() bool {
 	return m.StatusCode >= 400
 }
 
+const (
+	// tickInterval is the interval of the ticks of the rates, which is
+	// assumed by the EWMAs of go-metrics.
+	// https://github.com/rcrowley/go-metrics/blob/3113b8401b8a98917cde58f8bbd42a1b1c03b1fd/ewma.go#L98-L99
+	tickInterval = 5 * time.Second
+
+	// maxIdleTicks bounds the ticks to decay the rates after a long
+	// time without Status, the rates are nearly zero after an hour.
+	maxIdleTicks = 720
+)
+
+var nowFunc = time.Now
+
+// maxShards bounds the number of shards, to limit the memory of the
+// statistics of routes and paths.
+const maxShards = 16
+
+// commonCodes are the status codes counted in shards, the others are
+// counted by a shared counter.
+var commonCodes = [...]int{
+	200, 201, 202, 204, 206,
+	301, 302, 304, 307, 308,
+	400, 401, 403, 404, 405, 408, 409, 413, 429, 499,
+	500, 502, 503, 504,
+}
+
+// codeSlots maps status codes to their slots in shards, -1 means the
+// code has no slot.
+var codeSlots [1000]int8
+
+var (
+	shardHints     sync.Pool
+	nextShardIndex uint32
+)
+
+func init() {
+	for i := range codeSlots {
+		codeSlots[i] = -1
+	}
+	for i, code := range commonCodes {
+		codeSlots[code] = int8(i)
+	}
+
+	shardHints.New = func() interface{} {
+		return &shardHint{index: atomic.AddUint32(&nextShardIndex, 1)}
+	}
+}
+
+func shardCount() int {
+	n := 1
+	for n < runtime.GOMAXPROCS(0) && n < maxShards {
+		n <<= 1
+	}
+	return n
+}
+
 // New creates an HTTPStat.
 func New() *HTTPStat {
+	n := shardCount()
 	hs := &HTTPStat{
+		shards: make([]statShard, n),
+		mask:   uint32(n - 1),
+
 		rate1:  metrics.NewEWMA1(),
 		rate5:  metrics.NewEWMA5(),
 		rate15: metrics.NewEWMA15(),
@@ -118,135 +228,218 @@ func New() *HTTPStat {
 		errRate5:  metrics.NewEWMA5(),
 		errRate15: metrics.NewEWMA15(),
 
-		min:             math.MaxUint64,
+		lastTick: nowFunc(),
+
 		durationSampler: sampler.NewDurationSampler(),
 
 		cc: codecounter.New(),
 	}
 
+	for i := range hs.shards {
+		shard := &hs.shards[i]
+		shard.min = math.MaxUint64
+	}
+
 	return hs
 }
 
-// Stat stats the ctx.
-func (hs *HTTPStat) Stat(m *Metric) {
-	// Note: although this is a data update operation, we are using the RLock here,
-	// which means goroutines can execute this function concurrently, and contentions
-	// are handled by the atomic operations for each item.
-	//
-	// This lock is only a mutex for the 'Status' function below.
-	hs.mutex.RLock()
-	defer hs.mutex.RUnlock()
+func (hs *HTTPStat) shard() *statShard {
+	hint := shardHints.Get().(*shardHint)
+	shard := &hs.shards[hint.index&hs.mask]
+	shardHints.Put(hint)
+	return shard
+}
 
-	atomic.AddUint64(&hs.count, 1)
-	hs.rate1.Update(1)
-	hs.rate5.Update(1)
-	hs.rate15.Update(1)
+// Stat stats the ctx, it could be called concurrently.
+func (hs *HTTPStat) Stat(m *Metric) {
+	shard := hs.shard()
 
+	atomic.AddUint64(&shard.count, 1)
 	if m.isErr() {
-		atomic.AddUint64(&hs.errCount, 1)
-		hs.errRate1.Update(1)
-		hs.errRate5.Update(1)
-		hs.errRate15.Update(1)
+		atomic.AddUint64(&shard.errCount, 1)
 	}
 
 	duration := uint64(m.Duration.Milliseconds())
-	atomic.AddUint64(&hs.total, duration)
+	atomic.AddUint64(&shard.total, duration)
 	for {
-		min := atomic.LoadUint64(&hs.min)
+		min := atomic.LoadUint64(&shard.min)
 		if duration >= min {
 			break
 		}
-		if atomic.CompareAndSwapUint64(&hs.min, min, duration) {
+		if atomic.CompareAndSwapUint64(&shard.min, min, duration) {
 			break
 		}
 	}
 	for {
-		max := atomic.LoadUint64(&hs.max)
+		max := atomic.LoadUint64(&shard.max)
 		if duration <= max {
 			break
 		}
-		if atomic.CompareAndSwapUint64(&hs.max, max, duration) {
+		if atomic.CompareAndSwapUint64(&shard.max, max, duration) {
 			break
 		}
 	}
 
 	hs.durationSampler.Update(m.Duration)
 
-	atomic.AddUint64(&hs.reqSize, m.ReqSize)
-	atomic.AddUint64(&hs.respSize, m.RespSize)
+	atomic.AddUint64(&shard.reqSize, m.ReqSize)
+	atomic.AddUint64(&shard.respSize, m.RespSize)
+	shard.reqSizeHistogram.update(m.ReqSize)
+	shard.respSizeHistogram.update(m.RespSize)
 
-	hs.cc.Count(m.StatusCode)
+	if m.ClientGone {
+		atomic.AddUint64(&shard.clientGone, 1)
+	}
+
+	if m.StatusCode >= 0 && m.StatusCode < len(codeSlots) && codeSlots[m.StatusCode] >= 0 {
+		atomic.AddUint64(&shard.codes[codeSlots[m.StatusCode]], 1)
+	} else {
+		hs.cc.Count(m.StatusCode)
+	}
 }
 
-// Status returns HTTPStat Status, It assumes it is called every five seconds.
-// https://github.com/rcrowley/go-metrics/blob/3113b8401b8a98917cde58f8bbd42a1b1c03b1fd/ewma.go#L98-L99
+// Status returns HTTPStat Status, the codes, the size histograms and the
+// percentiles are reset by it.
 func (hs *HTTPStat) Status() *Status {
 	hs.mutex.Lock()
 	defer hs.mutex.Unlock()
 
-	hs.rate1.Tick()
-	hs.rate5.Tick()
-	hs.rate15.Tick()
-	hs.errRate1.Tick()
-	hs.errRate5.Tick()
-	hs.errRate15.Tick()
+	status := &Status{
+		Min:               math.MaxUint64,
+		ReqSizeHistogram:  make([]uint64, len(sizeBounds)+1),
+		RespSizeHistogram: make([]uint64, len(sizeBounds)+1),
+		Codes:             hs.cc.CodesAndReset(),
+	}
+
+	total := uint64(0)
+	for i := range hs.shards {
+		shard := &hs.shards[i]
 
-	m1, m5, m15 := hs.rate1.Rate(), hs.rate5.Rate(), hs.rate15.Rate()
-	m1Err, m5Err, m15Err := hs.errRate1.Rate(), hs.errRate5.Rate(), hs.errRate15.Rate()
-	m1ErrPercent, m5ErrPercent, m15ErrPercent := 0.0, 0.0, 0.0
-	if m1 > 0 {
-		m1ErrPercent = m1Err / m1
+		status.Count += atomic.LoadUint64(&shard.count)
+		status.ErrCount += atomic.LoadUint64(&shard.errCount)
+		total += atomic.LoadUint64(&shard.total)
+		if min := atomic.LoadUint64(&shard.min); min < status.Min {
+			status.Min = min
+		}
+		if max := atomic.LoadUint64(&shard.max); max > status.Max {
+			status.Max = max
+		}
+
+		status.ReqSize += atomic.LoadUint64(&shard.reqSize)
+		status.RespSize += atomic.LoadUint64(&shard.respSize)
+		shard.reqSizeHistogram.addAndReset(status.ReqSizeHistogram)
+		shard.respSizeHistogram.addAndReset(status.RespSizeHistogram)
+
+		status.ClientGone += atomic.LoadUint64(&shard.clientGone)
+
+		for j := range shard.codes {
+			if count := atomic.SwapUint64(&shard.codes[j], 0); count > 0 {
+				status.Codes[commonCodes[j]] += count
+			}
+		}
 	}
-	if m5 > 0 {
-		m1ErrPercent = m5Err / m5
+
+	// NOTE: The shards are read one by one while requests are being
+	// recorded, so the numbers may be slightly inconsistent, e.g. a
+	// request is counted in the total but not in the count.
+	if status.Count > 0 {
+		status.Mean = total / status.Count
+	} else {
+		status.Min = 0
+	}
+
+	hs.tick(status.Count, status.ErrCount)
+	status.M1, status.M5, status.M15 = hs.rate1.Rate(), hs.rate5.Rate(), hs.rate15.Rate()
+	status.M1Err, status.M5Err, status.M15Err = hs.errRate1.Rate(), hs.errRate5.Rate(), hs.errRate15.Rate()
+	if status.M1 > 0 {
+		status.M1ErrPercent = status.M1Err / status.M1
 	}
-	if m15 > 0 {
-		m1ErrPercent = m15Err / m15
+	if status.M5 > 0 {
+		status.M5ErrPercent = status.M5Err / status.M5
+	}
+	if status.M15 > 0 {
+		status.M15ErrPercent = status.M15Err / status.M15
 	}
 
-	percentiles := hs.durationSampler.Percentiles()
-	hs.durationSampler.Reset()
+	percentiles := hs.durationSampler.PercentilesAndReset()
+	status.P25 = percentiles[0]
+	status.P50 = percentiles[1]
+	status.P75 = percentiles[2]
+	status.P95 = percentiles[3]
+	status.P98 = percentiles[4]
+	status.P99 = percentiles[5]
+	status.P999 = percentiles[6]
 
-	codes := hs.cc.Codes()
-	hs.cc.Reset()
+	return status
+}
 
-	mean, min := uint64(0), uint64(0)
-	if hs.count > 0 {
-		mean = hs.total / hs.count
-		min = hs.min
+// tick ticks the rates for the intervals elapsed since the last tick, the
+// deltas of the counts go to the first one, and the others only decay the
+// rates. The rates are unchanged if no interval has elapsed.
+func (hs *HTTPStat) tick(count, errCount uint64) {
+	ticks := int(nowFunc().Sub(hs.lastTick) / tickInterval)
+	if ticks <= 0 {
+		return
 	}
-	status := &Status{
-		Count: hs.count,
-		M1:    m1,
-		M5:    m5,
-		M15:   m15,
-
-		ErrCount: hs.errCount,
-		M1Err:    m1Err,
-		M5Err:    m5Err,
-		M15Err:   m15Err,
-
-		M1ErrPercent:  m1ErrPercent,
-		M5ErrPercent:  m5ErrPercent,
-		M15ErrPercent: m15ErrPercent,
+	hs.lastTick = hs.lastTick.Add(time.Duration(ticks) * tickInterval)
+
+	hs.rate1.Update(int64(count - hs.lastCount))
+	hs.rate5.Update(int64(count - hs.lastCount))
+	hs.rate15.Update(int64(count - hs.lastCount))
+	hs.errRate1.Update(int64(errCount - hs.lastErrCount))
+	hs.errRate5.Update(int64(errCount - hs.lastErrCount))
+	hs.errRate15.Update(int64(errCount - hs.lastErrCount))
+	hs.lastCount, hs.lastErrCount = count, errCount
+
+	if ticks > maxIdleTicks {
+		ticks = maxIdleTicks
+	}
+	for i := 0; i < ticks; i++ {
+		hs.rate1.Tick()
+		hs.rate5.Tick()
+		hs.rate15.Tick()
+		hs.errRate1.Tick()
+		hs.errRate5.Tick()
+		hs.errRate15.Tick()
+	}
+}
 
-		Min:  min,
-		Mean: mean,
-		Max:  hs.max,
+// sizeBounds are the upper bounds (inclusive) of buckets of the size
+// histograms, in bytes.
+var sizeBounds = [...]uint64{
+	1 << 10,   // 1KB
+	4 << 10,   // 4KB
+	16 << 10,  // 16KB
+	64 << 10,  // 64KB
+	256 << 10, // 256KB
+	1 << 20,   // 1MB
+	4 << 20,   // 4MB
+	16 << 20,  // 16MB
+}
 
-		P25:  percentiles[0],
-		P50:  percentiles[1],
-		P75:  percentiles[2],
-		P95:  percentiles[3],
-		P98:  percentiles[4],
-		P99:  percentiles[5],
-		P999: percentiles[6],
+// SizeBuckets are the upper bounds (inclusive) of buckets of the size
+// histograms, in bytes.
+var SizeBuckets = sizeBounds[:]
 
-		ReqSize:  hs.reqSize,
-		RespSize: hs.respSize,
+// sizeHistogram is the histogram of sizes, the last bucket is for the
+// sizes larger than all SizeBuckets.
+type sizeHistogram [len(sizeBounds) + 1]uint64
 
-		Codes: codes,
+func (sh *sizeHistogram) update(size uint64) {
+	idx := len(sizeBounds)
+	for i, bound := range sizeBounds {
+		if size <= bound {
+			idx = i
+			break
+		}
 	}
+	atomic.AddUint64(&sh[idx], 1)
+}
 
-	return status
+// addAndReset adds the counts of all buckets to counts and resets them,
+// it could be called concurrently with update without losing counts.
+func (sh *sizeHistogram) addAndReset(counts []uint64) {
+	for i := range sh {
+		counts[i] += atomic.SwapUint64(&sh[i], 0)
+	}
 }
@@ -0,0 +1,156 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package httpstat
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHTTPStat(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	hs := New()
+
+	const goroutines, requests = 8, 1000
+	wg := &sync.WaitGroup{}
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				code := 200
+				switch j % 4 {
+				case 1:
+					code = 503
+				case 2:
+					code = 418
+				}
+				hs.Stat(&Metric{
+					StatusCode: code,
+					Duration:   time.Duration(i+1) * time.Millisecond,
+					ReqSize:    100,
+					RespSize:   2 << 10,
+					ClientGone: j == 0,
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	now = now.Add(tickInterval)
+	status := hs.Status()
+	total := uint64(goroutines * requests)
+	if status.Count != total || status.ErrCount != total/2 {
+		t.Errorf("unexpected counts: %d, %d", status.Count, status.ErrCount)
+	}
+	if status.Codes[200] != total/2 || status.Codes[503] != total/4 || status.Codes[418] != total/4 {
+		t.Errorf("unexpected codes: %v", status.Codes)
+	}
+	if status.Min != 1 || status.Max != goroutines {
+		t.Errorf("unexpected min %d and max %d", status.Min, status.Max)
+	}
+	if status.ReqSize != total*100 || status.RespSize != total*2<<10 {
+		t.Errorf("unexpected sizes: %d, %d", status.ReqSize, status.RespSize)
+	}
+	if status.ReqSizeHistogram[0] != total || status.RespSizeHistogram[1] != total {
+		t.Errorf("unexpected histograms: %v, %v", status.ReqSizeHistogram, status.RespSizeHistogram)
+	}
+	if status.ClientGone != goroutines {
+		t.Errorf("unexpected client gone: %d", status.ClientGone)
+	}
+	if status.M1 <= 0 || status.M1ErrPercent != 0.5 {
+		t.Errorf("unexpected rates: %v, %v", status.M1, status.M1ErrPercent)
+	}
+
+	// The codes, histograms and percentiles are reset by Status.
+	status = hs.Status()
+	if status.Count != total || len(status.Codes) != 0 || status.ReqSizeHistogram[0] != 0 || status.P50 != 9999999 {
+		t.Errorf("unexpected status after reset: %+v", status)
+	}
+}
+
+func TestHTTPStatEmpty(t *testing.T) {
+	status := New().Status()
+	if status.Count != 0 || status.Min != 0 || status.Mean != 0 || status.M1 != 0 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+}
+
+func TestHTTPStatRates(t *testing.T) {
+	now := time.Now()
+	nowFunc = func() time.Time { return now }
+	defer func() { nowFunc = time.Now }()
+
+	// The rates are the same no matter how often Status is called.
+	frequent, rare := New(), New()
+	for second := 1; second <= 120; second++ {
+		for i := 0; i < 10; i++ {
+			m := &Metric{StatusCode: 200}
+			if i == 0 {
+				m.StatusCode = 500
+			}
+			frequent.Stat(m)
+			rare.Stat(m)
+		}
+		now = now.Add(time.Second)
+
+		s1 := frequent.Status()
+		if second%10 == 3 {
+			// Calling Status again at the same time changes nothing.
+			if s := frequent.Status(); s.M1 != s1.M1 || s.M5Err != s1.M5Err {
+				t.Fatalf("rates changed by repeated Status: %v, %v", s, s1)
+			}
+		}
+		if second%5 != 0 {
+			continue
+		}
+
+		s2 := rare.Status()
+		if s1.M1 != s2.M1 || s1.M5 != s2.M5 || s1.M15 != s2.M15 ||
+			s1.M1Err != s2.M1Err || s1.M5Err != s2.M5Err || s1.M15Err != s2.M15Err {
+			t.Fatalf("rates differ at second %d: %+v, %+v", second, s1, s2)
+		}
+	}
+
+	status := rare.Status()
+	if status.M1 < 5 || status.M1 > 10 || status.M1ErrPercent < 0.099 || status.M1ErrPercent > 0.101 {
+		t.Errorf("unexpected rates: %v, %v", status.M1, status.M1ErrPercent)
+	}
+
+	// The rates decay after a long time without Status.
+	now = now.Add(time.Hour)
+	if status = rare.Status(); status.M1 > 0.001 {
+		t.Errorf("rates should decay: %v", status.M1)
+	}
+}
+
+func BenchmarkStat(b *testing.B) {
+	hs := New()
+	m := &Metric{StatusCode: 200, Duration: 10 * time.Millisecond, ReqSize: 100, RespSize: 1000}
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			hs.Stat(m)
+		}
+	})
+}
@@ -19,6 +19,7 @@ package limitlistener
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"sync"
 
@@ -99,3 +100,12 @@ func (l *limitListenerConn) Close() error {
 	l.releaseOnce.Do(l.release)
 	return err
 }
+
+// CloseWrite shuts down the writing side of the underlying connection if
+// it supports half close.
+func (l *limitListenerConn) CloseWrite() error {
+	if cw, ok := l.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return fmt.Errorf("%T doesn't support CloseWrite", l.Conn)
+}
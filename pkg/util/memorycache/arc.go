@@ -0,0 +1,219 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memorycache
+
+import (
+	"container/list"
+)
+
+type (
+	// arcStore is the store of ARC (Adaptive Replacement Cache) policy.
+	// t1 holds entries seen once recently, t2 holds entries seen at least
+	// twice recently, b1 and b2 are the ghost lists holding keys evicted
+	// from t1 and t2, and p is the target size of t1 adapted by ghost hits.
+	// Reference: https://www.usenix.org/legacy/events/fast03/tech/full_papers/megiddo/megiddo.pdf
+	arcStore struct {
+		capacity int
+		p        int
+
+		t1, t2, b1, b2 *arcList
+	}
+
+	arcList struct {
+		ll    *list.List
+		items map[string]*list.Element
+	}
+)
+
+func newARCList() *arcList {
+	return &arcList{ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (l *arcList) len() int {
+	return l.ll.Len()
+}
+
+func (l *arcList) get(key string) (*storeItem, bool) {
+	elem, ok := l.items[key]
+	if !ok {
+		return nil, false
+	}
+	return elem.Value.(*storeItem), true
+}
+
+func (l *arcList) pushFront(item *storeItem) {
+	l.items[item.key] = l.ll.PushFront(item)
+}
+
+func (l *arcList) moveToFront(key string) {
+	l.ll.MoveToFront(l.items[key])
+}
+
+func (l *arcList) remove(key string) {
+	if elem, ok := l.items[key]; ok {
+		l.ll.Remove(elem)
+		delete(l.items, key)
+	}
+}
+
+func (l *arcList) removeOldest() *storeItem {
+	elem := l.ll.Back()
+	if elem == nil {
+		return nil
+	}
+	item := elem.Value.(*storeItem)
+	l.ll.Remove(elem)
+	delete(l.items, item.key)
+	return item
+}
+
+func newARCStore(capacity int) *arcStore {
+	return &arcStore{
+		capacity: capacity,
+		t1:       newARCList(),
+		t2:       newARCList(),
+		b1:       newARCList(),
+		b2:       newARCList(),
+	}
+}
+
+func (s *arcStore) get(key string) (*cacheEntry, bool) {
+	if item, ok := s.t1.get(key); ok {
+		s.t1.remove(key)
+		s.t2.pushFront(item)
+		return item.entry, true
+	}
+
+	if item, ok := s.t2.get(key); ok {
+		s.t2.moveToFront(key)
+		return item.entry, true
+	}
+
+	return nil, false
+}
+
+func (s *arcStore) set(key string, entry *cacheEntry) int {
+	if item, ok := s.t1.get(key); ok {
+		item.entry = entry
+		s.t1.remove(key)
+		s.t2.pushFront(item)
+		return 0
+	}
+
+	if item, ok := s.t2.get(key); ok {
+		item.entry = entry
+		s.t2.moveToFront(key)
+		return 0
+	}
+
+	item := &storeItem{key: key, entry: entry}
+	evicted := 0
+
+	if _, ok := s.b1.get(key); ok {
+		// Recency is more important, grow the target size of t1.
+		delta := 1
+		if s.b1.len() < s.b2.len() {
+			delta = s.b2.len() / s.b1.len()
+		}
+		s.p = minInt(s.p+delta, s.capacity)
+
+		if s.t1.len()+s.t2.len() >= s.capacity {
+			evicted += s.replace(false)
+		}
+		s.b1.remove(key)
+		s.t2.pushFront(item)
+		return evicted
+	}
+
+	if _, ok := s.b2.get(key); ok {
+		// Frequency is more important, shrink the target size of t1.
+		delta := 1
+		if s.b2.len() < s.b1.len() {
+			delta = s.b1.len() / s.b2.len()
+		}
+		s.p = maxInt(s.p-delta, 0)
+
+		if s.t1.len()+s.t2.len() >= s.capacity {
+			evicted += s.replace(true)
+		}
+		s.b2.remove(key)
+		s.t2.pushFront(item)
+		return evicted
+	}
+
+	if s.t1.len()+s.t2.len() >= s.capacity {
+		evicted += s.replace(false)
+	}
+
+	if s.b1.len() > s.capacity-s.p {
+		s.b1.removeOldest()
+	}
+	if s.b2.len() > s.p {
+		s.b2.removeOldest()
+	}
+
+	s.t1.pushFront(item)
+	return evicted
+}
+
+// replace evicts an entry from t1 or t2 to the ghost lists.
+func (s *arcStore) replace(b2ContainsKey bool) int {
+	t1Len := s.t1.len()
+	if t1Len > 0 && (t1Len > s.p || (t1Len == s.p && b2ContainsKey)) {
+		if item := s.t1.removeOldest(); item != nil {
+			s.b1.pushFront(&storeItem{key: item.key})
+			return 1
+		}
+		return 0
+	}
+
+	if item := s.t2.removeOldest(); item != nil {
+		s.b2.pushFront(&storeItem{key: item.key})
+		return 1
+	}
+	if item := s.t1.removeOldest(); item != nil {
+		s.b1.pushFront(&storeItem{key: item.key})
+		return 1
+	}
+	return 0
+}
+
+func (s *arcStore) remove(key string) {
+	s.t1.remove(key)
+	s.t2.remove(key)
+	s.b1.remove(key)
+	s.b2.remove(key)
+}
+
+func (s *arcStore) len() int {
+	return s.t1.len() + s.t2.len()
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
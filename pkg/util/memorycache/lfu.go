@@ -0,0 +1,136 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memorycache
+
+import (
+	"container/list"
+)
+
+type (
+	// lfuStore is the store of LFU policy, all operations are O(1).
+	// Entries with the same frequency are evicted in LRU order.
+	lfuStore struct {
+		capacity int
+		items    map[string]*lfuItem
+		freqs    map[int]*list.List
+		minFreq  int
+	}
+
+	lfuItem struct {
+		storeItem
+		freq int
+		elem *list.Element
+	}
+)
+
+func newLFUStore(capacity int) *lfuStore {
+	return &lfuStore{
+		capacity: capacity,
+		items:    map[string]*lfuItem{},
+		freqs:    map[int]*list.List{},
+	}
+}
+
+func (s *lfuStore) touch(item *lfuItem) {
+	l := s.freqs[item.freq]
+	l.Remove(item.elem)
+	if l.Len() == 0 {
+		delete(s.freqs, item.freq)
+		if s.minFreq == item.freq {
+			s.minFreq++
+		}
+	}
+
+	item.freq++
+	item.elem = s.list(item.freq).PushFront(item)
+}
+
+func (s *lfuStore) list(freq int) *list.List {
+	l := s.freqs[freq]
+	if l == nil {
+		l = list.New()
+		s.freqs[freq] = l
+	}
+	return l
+}
+
+func (s *lfuStore) get(key string) (*cacheEntry, bool) {
+	item, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.touch(item)
+	return item.entry, true
+}
+
+func (s *lfuStore) set(key string, entry *cacheEntry) int {
+	if item, ok := s.items[key]; ok {
+		item.entry = entry
+		s.touch(item)
+		return 0
+	}
+
+	evicted := 0
+	for len(s.items) >= s.capacity {
+		l := s.freqs[s.minFreq]
+		victim := l.Back().Value.(*lfuItem)
+		s.removeItem(victim)
+		evicted++
+	}
+
+	item := &lfuItem{storeItem: storeItem{key: key, entry: entry}, freq: 1}
+	item.elem = s.list(1).PushFront(item)
+	s.items[key] = item
+	s.minFreq = 1
+
+	return evicted
+}
+
+func (s *lfuStore) removeItem(item *lfuItem) {
+	l := s.freqs[item.freq]
+	l.Remove(item.elem)
+	delete(s.items, item.key)
+
+	if l.Len() > 0 {
+		return
+	}
+
+	delete(s.freqs, item.freq)
+	if s.minFreq != item.freq || len(s.items) == 0 {
+		return
+	}
+
+	// NOTE: It only happens when removing, evicting always leaves the
+	// store with a new item of frequency 1.
+	s.minFreq = 0
+	for freq := range s.freqs {
+		if s.minFreq == 0 || freq < s.minFreq {
+			s.minFreq = freq
+		}
+	}
+}
+
+func (s *lfuStore) remove(key string) {
+	if item, ok := s.items[key]; ok {
+		s.removeItem(item)
+	}
+}
+
+func (s *lfuStore) len() int {
+	return len(s.items)
+}
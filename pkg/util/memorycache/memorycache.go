@@ -19,7 +19,11 @@ package memorycache
 
 import (
 	"bytes"
+	"fmt"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	cache "github.com/patrickmn/go-cache"
@@ -33,14 +37,29 @@ import (
 const (
 	cleanupIntervalFactor = 2
 	cleanupIntervalMin    = 1 * time.Minute
+
+	defaultCollapseTimeout = 5 * time.Second
 )
 
 type (
 	// MemoryCache is an utility MemoryCache.
 	MemoryCache struct {
-		spec *Spec
+		spec       *Spec
+		expiration time.Duration
 
+		// cache is used when MaxEntries is zero, otherwise store is used.
 		cache *cache.Cache
+		mutex sync.Mutex
+		store store
+
+		collapseTimeout time.Duration
+		inflightMutex   sync.Mutex
+		inflight        map[string]chan struct{}
+
+		hits      uint64
+		misses    uint64
+		evictions uint64
+		collapsed uint64
 	}
 
 	// Spec describes the MemoryCache.
@@ -49,15 +68,67 @@ type (
 		MaxEntryBytes uint32   `yaml:"maxEntryBytes" jsonschema:"required,minimum=1"`
 		Codes         []int    `yaml:"codes" jsonschema:"required,minItems=1,uniqueItems=true,format=httpcode-array"`
 		Methods       []string `yaml:"methods" jsonschema:"required,minItems=1,uniqueItems=true,format=httpmethod-array"`
+
+		// MaxEntries is the max number of entries, zero means no limit.
+		MaxEntries uint32 `yaml:"maxEntries" jsonschema:"omitempty"`
+		// EvictionPolicy decides which entry to evict when the number of
+		// entries reaches MaxEntries, the default is lru.
+		EvictionPolicy string `yaml:"evictionPolicy" jsonschema:"omitempty,enum=,enum=lru,enum=lfu,enum=arc"`
+
+		// CollapseForwarding collapses concurrent identical cacheable
+		// requests missing the cache into a single origin fetch, others
+		// wait for it and are served from the cache it fills.
+		CollapseForwarding bool `yaml:"collapseForwarding" jsonschema:"omitempty"`
+		// CollapseTimeout is the max duration to wait for the origin fetch,
+		// the waiting requests go to the origin by themselves after it.
+		CollapseTimeout string `yaml:"collapseTimeout" jsonschema:"omitempty,format=duration"`
+
+		// TTLHeader is the response header with which backends override
+		// the expiration of entries, e.g. X-Gateway-Cache-TTL, its value
+		// is in seconds or a duration like 5m, and the responses with a
+		// zero TTL are not stored. The header is removed from responses.
+		TTLHeader string `yaml:"ttlHeader" jsonschema:"omitempty"`
+	}
+
+	// Status is the status of MemoryCache.
+	Status struct {
+		EvictionPolicy string  `yaml:"evictionPolicy,omitempty"`
+		Entries        int     `yaml:"entries"`
+		MaxEntries     uint32  `yaml:"maxEntries,omitempty"`
+		FillRatio      float64 `yaml:"fillRatio,omitempty"`
+		Hits           uint64  `yaml:"hits"`
+		Misses         uint64  `yaml:"misses"`
+		HitRatio       float64 `yaml:"hitRatio"`
+		Evictions      uint64  `yaml:"evictions"`
+		Collapsed      uint64  `yaml:"collapsed,omitempty"`
 	}
 
 	cacheEntry struct {
 		statusCode int
 		header     *httpheader.HTTPHeader
 		body       []byte
+		expireAt   time.Time
 	}
 )
 
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.EvictionPolicy != "" && spec.MaxEntries == 0 {
+		return fmt.Errorf("evictionPolicy requires maxEntries")
+	}
+	if spec.CollapseTimeout != "" && !spec.CollapseForwarding {
+		return fmt.Errorf("collapseTimeout requires collapseForwarding")
+	}
+	return nil
+}
+
+func (spec *Spec) evictionPolicy() string {
+	if spec.EvictionPolicy == "" {
+		return EvictionPolicyLRU
+	}
+	return spec.EvictionPolicy
+}
+
 // New creates a MemoryCache.
 func New(spec *Spec) *MemoryCache {
 	expiration, err := time.ParseDuration(spec.Expiration)
@@ -66,16 +137,103 @@ func New(spec *Spec) *MemoryCache {
 		expiration = 10 * time.Second
 	}
 
+	mc := &MemoryCache{
+		spec:       spec,
+		expiration: expiration,
+	}
+
+	if spec.CollapseForwarding {
+		mc.inflight = map[string]chan struct{}{}
+		mc.collapseTimeout = defaultCollapseTimeout
+		if spec.CollapseTimeout != "" {
+			mc.collapseTimeout, err = time.ParseDuration(spec.CollapseTimeout)
+			if err != nil {
+				logger.Errorf("BUG: parse duration %s failed: %v", spec.CollapseTimeout, err)
+				mc.collapseTimeout = defaultCollapseTimeout
+			}
+		}
+	}
+
+	if spec.MaxEntries > 0 {
+		mc.store = newStore(spec.evictionPolicy(), int(spec.MaxEntries))
+		return mc
+	}
+
 	cleanupInterval := expiration * cleanupIntervalFactor
 	if cleanupInterval < cleanupIntervalMin {
 		cleanupInterval = cleanupIntervalMin
 	}
-	cache := cache.New(expiration, cleanupInterval)
+	mc.cache = cache.New(expiration, cleanupInterval)
+
+	return mc
+}
+
+func (mc *MemoryCache) get(key string) (*cacheEntry, bool) {
+	if mc.store == nil {
+		v, ok := mc.cache.Get(key)
+		if !ok {
+			return nil, false
+		}
+		return v.(*cacheEntry), true
+	}
+
+	mc.mutex.Lock()
+	defer mc.mutex.Unlock()
+
+	entry, ok := mc.store.get(key)
+	if !ok {
+		return nil, false
+	}
+	if entry.expired(time.Now()) {
+		mc.store.remove(key)
+		return nil, false
+	}
+	return entry, true
+}
+
+func (mc *MemoryCache) set(key string, entry *cacheEntry, expiration time.Duration) {
+	if mc.store == nil {
+		mc.cache.Set(key, entry, expiration)
+		return
+	}
 
-	return &MemoryCache{
-		spec:  spec,
-		cache: cache,
+	entry.expireAt = time.Now().Add(expiration)
+
+	mc.mutex.Lock()
+	evicted := mc.store.set(key, entry)
+	mc.mutex.Unlock()
+
+	if evicted > 0 {
+		atomic.AddUint64(&mc.evictions, uint64(evicted))
+	}
+}
+
+// Status returns the status of MemoryCache.
+func (mc *MemoryCache) Status() *Status {
+	s := &Status{
+		Hits:      atomic.LoadUint64(&mc.hits),
+		Misses:    atomic.LoadUint64(&mc.misses),
+		Evictions: atomic.LoadUint64(&mc.evictions),
+		Collapsed: atomic.LoadUint64(&mc.collapsed),
 	}
+	if total := s.Hits + s.Misses; total > 0 {
+		s.HitRatio = float64(s.Hits) / float64(total)
+	}
+
+	if mc.store == nil {
+		s.Entries = mc.cache.ItemCount()
+		return s
+	}
+
+	mc.mutex.Lock()
+	s.Entries = mc.store.len()
+	mc.mutex.Unlock()
+
+	s.EvictionPolicy = mc.spec.evictionPolicy()
+	s.MaxEntries = mc.spec.MaxEntries
+	s.FillRatio = float64(s.Entries) / float64(s.MaxEntries)
+
+	return s
 }
 
 func (mc *MemoryCache) key(ctx context.HTTPContext) string {
@@ -105,22 +263,100 @@ func (mc *MemoryCache) Load(ctx context.HTTPContext) (loaded bool) {
 		}
 	}
 
-	v, ok := mc.cache.Get(mc.key(ctx))
+	key := mc.key(ctx)
+	entry, ok := mc.get(key)
+	if !ok && mc.inflight != nil {
+		entry, ok = mc.collapse(ctx, key)
+	}
+
+	if !ok {
+		atomic.AddUint64(&mc.misses, 1)
+		return false
+	}
+
+	atomic.AddUint64(&mc.hits, 1)
+	w.SetStatusCode(entry.statusCode)
+	w.Header().AddFrom(entry.header)
+	w.SetBody(bytes.NewReader(entry.body))
+	ctx.AddTag("cacheLoad")
+
+	return true
+}
+
+// collapse makes the first request missing the cache the leader to fetch
+// from the origin, and makes the others wait for the leader to finish and
+// load the cache again.
+func (mc *MemoryCache) collapse(ctx context.HTTPContext, key string) (*cacheEntry, bool) {
+	mc.inflightMutex.Lock()
+	done, exists := mc.inflight[key]
+	if !exists {
+		done = make(chan struct{})
+		mc.inflight[key] = done
+	}
+	mc.inflightMutex.Unlock()
+
+	if !exists {
+		// NOTE: Finish functions run after the response body is flushed,
+		// so the cache has been filled if the response is cacheable.
+		ctx.OnFinish(func() {
+			mc.inflightMutex.Lock()
+			delete(mc.inflight, key)
+			mc.inflightMutex.Unlock()
+			close(done)
+		})
+		return nil, false
+	}
+
+	timer := time.NewTimer(mc.collapseTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+	case <-timer.C:
+		ctx.AddTag("cacheCollapseTimeout")
+		return nil, false
+	}
+
+	entry, ok := mc.get(key)
 	if ok {
-		entry := v.(*cacheEntry)
-		w.SetStatusCode(entry.statusCode)
-		w.Header().AddFrom(entry.header)
-		w.SetBody(bytes.NewReader(entry.body))
-		ctx.AddTag("cacheLoad")
+		atomic.AddUint64(&mc.collapsed, 1)
+		ctx.AddTag("cacheCollapsed")
 	}
+	return entry, ok
+}
 
-	return ok
+// parseTTL parses the value of the TTL header, which is in seconds or a
+// duration.
+func parseTTL(value string) (time.Duration, bool) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return d, true
+	}
+	return 0, false
 }
 
 // Store tries to store cache for HTTPContext.
 func (mc *MemoryCache) Store(ctx context.HTTPContext) {
 	r, w := ctx.Request(), ctx.Response()
 
+	expiration := mc.expiration
+	if name := mc.spec.TTLHeader; name != "" {
+		if value := w.Header().Get(name); value != "" {
+			// NOTE: It's removed before the header is copied to the entry.
+			w.Header().Del(name)
+			if ttl, ok := parseTTL(value); ok {
+				expiration = ttl
+			} else {
+				ctx.AddTag(stringtool.Cat("invalid cache ttl: ", value))
+			}
+		}
+	}
+	if expiration <= 0 {
+		return
+	}
+
 	matchMethod := false
 	for _, method := range mc.spec.Methods {
 		if r.Method() == method {
@@ -171,7 +407,7 @@ func (mc *MemoryCache) Store(ctx context.HTTPContext) {
 
 		entry.body = append(entry.body, body...)
 		if complete {
-			mc.cache.SetDefault(key, entry)
+			mc.set(key, entry, expiration)
 			ctx.AddTag("cacheStore")
 		}
 
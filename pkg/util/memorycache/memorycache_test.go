@@ -0,0 +1,286 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memorycache
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+	"github.com/megaease/easegress/pkg/util/httpheader"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+type mockedContext struct {
+	*contexttest.MockedHTTPContext
+	flush context.BodyFlushFunc
+	body  io.Reader
+	tags  []string
+	mutex sync.Mutex
+}
+
+func newContext(path string) *mockedContext {
+	ctx := &mockedContext{MockedHTTPContext: &contexttest.MockedHTTPContext{}}
+	reqHeader := httpheader.New(http.Header{})
+	respHeader := httpheader.New(http.Header{})
+
+	ctx.MockedRequest.MockedMethod = func() string { return http.MethodGet }
+	ctx.MockedRequest.MockedScheme = func() string { return "http" }
+	ctx.MockedRequest.MockedHost = func() string { return "example.com" }
+	ctx.MockedRequest.MockedPath = func() string { return path }
+	ctx.MockedRequest.MockedHeader = func() *httpheader.HTTPHeader { return reqHeader }
+
+	ctx.MockedResponse.MockedStatusCode = func() int { return http.StatusOK }
+	ctx.MockedResponse.MockedHeader = func() *httpheader.HTTPHeader { return respHeader }
+	ctx.MockedResponse.MockedSetBody = func(body io.Reader) { ctx.body = body }
+	ctx.MockedResponse.MockedOnFlushBody = func(fn context.BodyFlushFunc) { ctx.flush = fn }
+	ctx.MockedAddTag = func(tag string) {
+		ctx.mutex.Lock()
+		ctx.tags = append(ctx.tags, tag)
+		ctx.mutex.Unlock()
+	}
+
+	return ctx
+}
+
+func (ctx *mockedContext) respond(mc *MemoryCache, body string) {
+	mc.Store(ctx)
+	if ctx.flush != nil {
+		ctx.flush([]byte(body), true)
+	}
+	ctx.Finish()
+}
+
+func (ctx *mockedContext) hasTag(tag string) bool {
+	ctx.mutex.Lock()
+	defer ctx.mutex.Unlock()
+	for _, t := range ctx.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func newSpec() *Spec {
+	return &Spec{
+		Expiration:    "10s",
+		MaxEntryBytes: 1024,
+		Codes:         []int{http.StatusOK},
+		Methods:       []string{http.MethodGet},
+	}
+}
+
+func TestEviction(t *testing.T) {
+	spec := newSpec()
+	spec.MaxEntries = 1
+	spec.EvictionPolicy = EvictionPolicyLFU
+	mc := New(spec)
+
+	for _, path := range []string{"/a", "/b"} {
+		ctx := newContext(path)
+		if mc.Load(ctx) {
+			t.Fatalf("%s should not be loaded", path)
+		}
+		ctx.respond(mc, "hello")
+	}
+
+	if !mc.Load(newContext("/b")) {
+		t.Errorf("/b should be loaded")
+	}
+	if mc.Load(newContext("/a")) {
+		t.Errorf("/a should be evicted")
+	}
+
+	s := mc.Status()
+	if s.EvictionPolicy != EvictionPolicyLFU || s.Entries != 1 || s.FillRatio != 1 {
+		t.Errorf("unexpected fill status: %+v", s)
+	}
+	if s.Hits != 1 || s.Misses != 3 || s.HitRatio != 0.25 || s.Evictions != 1 {
+		t.Errorf("unexpected hit status: %+v", s)
+	}
+}
+
+func TestExpiration(t *testing.T) {
+	spec := newSpec()
+	spec.Expiration = "10ms"
+	spec.MaxEntries = 10
+	mc := New(spec)
+
+	ctx := newContext("/a")
+	mc.Load(ctx)
+	ctx.respond(mc, "hello")
+	if !mc.Load(newContext("/a")) {
+		t.Fatalf("/a should be loaded")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if mc.Load(newContext("/a")) {
+		t.Errorf("/a should be expired")
+	}
+	if mc.Status().Entries != 0 {
+		t.Errorf("expired entry should be removed")
+	}
+}
+
+func TestTTLHeader(t *testing.T) {
+	for _, maxEntries := range []uint32{0, 10} {
+		spec := newSpec()
+		spec.MaxEntries = maxEntries
+		spec.TTLHeader = "X-Gateway-Cache-TTL"
+		mc := New(spec)
+
+		respond := func(path, ttl string) *mockedContext {
+			ctx := newContext(path)
+			ctx.Response().Header().Set(spec.TTLHeader, ttl)
+			ctx.respond(mc, "hello")
+			if ctx.Response().Header().Get(spec.TTLHeader) != "" {
+				t.Errorf("ttl header should be removed")
+			}
+			return ctx
+		}
+
+		respond("/short", "10ms")
+		respond("/long", "60")
+		respond("/none", "0")
+		if ctx := respond("/invalid", "soon"); !ctx.hasTag("invalid cache ttl: soon") {
+			t.Errorf("invalid ttl should be tagged")
+		}
+
+		if mc.Load(newContext("/none")) {
+			t.Errorf("response with zero ttl should not be stored")
+		}
+		if !mc.Load(newContext("/invalid")) {
+			t.Errorf("response with invalid ttl should be stored with the expiration")
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		if mc.Load(newContext("/short")) {
+			t.Errorf("/short should be expired")
+		}
+		if !mc.Load(newContext("/long")) {
+			t.Errorf("/long should be loaded")
+		}
+	}
+}
+
+func TestCollapseForwarding(t *testing.T) {
+	spec := newSpec()
+	spec.CollapseForwarding = true
+	mc := New(spec)
+
+	leader := newContext("/a")
+	if mc.Load(leader) {
+		t.Fatalf("leader should not be loaded")
+	}
+
+	const waiters = 10
+	loaded := make(chan bool, waiters)
+	var wg sync.WaitGroup
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := newContext("/a")
+			loaded <- mc.Load(ctx) && ctx.hasTag("cacheCollapsed")
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if len(loaded) != 0 {
+		t.Fatalf("waiters should wait for the leader")
+	}
+
+	leader.respond(mc, "hello")
+	wg.Wait()
+	close(loaded)
+
+	for ok := range loaded {
+		if !ok {
+			t.Errorf("waiter should be served from the cache")
+		}
+	}
+	if s := mc.Status(); s.Collapsed != waiters {
+		t.Errorf("want %d collapsed, got %d", waiters, s.Collapsed)
+	}
+
+	// The leader finished, a new request is served from the cache directly.
+	ctx := newContext("/a")
+	if !mc.Load(ctx) || ctx.hasTag("cacheCollapsed") {
+		t.Errorf("request should be served from the cache directly")
+	}
+}
+
+func TestCollapseForwardingUncacheable(t *testing.T) {
+	spec := newSpec()
+	spec.CollapseForwarding = true
+	spec.CollapseTimeout = "20ms"
+	mc := New(spec)
+
+	leader := newContext("/a")
+	mc.Load(leader)
+
+	done := make(chan bool)
+	go func() {
+		done <- mc.Load(newContext("/a"))
+	}()
+
+	// The response is not cacheable, the waiter goes to the origin.
+	leader.MockedResponse.MockedStatusCode = func() int { return http.StatusInternalServerError }
+	leader.respond(mc, "error")
+	if <-done {
+		t.Errorf("waiter should not be loaded")
+	}
+
+	// The leader never finishes, the waiter goes to the origin after timeout.
+	leader = newContext("/a")
+	mc.Load(leader)
+	ctx := newContext("/a")
+	start := time.Now()
+	if mc.Load(ctx) {
+		t.Errorf("waiter should not be loaded")
+	}
+	if time.Since(start) < 20*time.Millisecond || !ctx.hasTag("cacheCollapseTimeout") {
+		t.Errorf("waiter should wait until timeout")
+	}
+}
+
+func TestSpecValidate(t *testing.T) {
+	spec := newSpec()
+	spec.EvictionPolicy = EvictionPolicyARC
+	if spec.Validate() == nil {
+		t.Errorf("evictionPolicy without maxEntries should fail")
+	}
+
+	spec = newSpec()
+	spec.CollapseTimeout = "1s"
+	if spec.Validate() == nil {
+		t.Errorf("collapseTimeout without collapseForwarding should fail")
+	}
+}
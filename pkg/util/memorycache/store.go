@@ -0,0 +1,117 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memorycache
+
+import (
+	"container/list"
+	"time"
+)
+
+const (
+	// EvictionPolicyLRU evicts the least recently used entry.
+	EvictionPolicyLRU = "lru"
+	// EvictionPolicyLFU evicts the least frequently used entry.
+	EvictionPolicyLFU = "lfu"
+	// EvictionPolicyARC is the adaptive replacement cache, which balances
+	// between recency and frequency.
+	EvictionPolicyARC = "arc"
+)
+
+type (
+	// store is a bounded store of cache entries, it is not goroutine-safe.
+	store interface {
+		get(key string) (*cacheEntry, bool)
+		// set sets the entry and returns the number of evicted entries.
+		set(key string, entry *cacheEntry) int
+		remove(key string)
+		len() int
+	}
+
+	storeItem struct {
+		key   string
+		entry *cacheEntry
+	}
+)
+
+func newStore(policy string, capacity int) store {
+	switch policy {
+	case EvictionPolicyLFU:
+		return newLFUStore(capacity)
+	case EvictionPolicyARC:
+		return newARCStore(capacity)
+	}
+	return newLRUStore(capacity)
+}
+
+func (e *cacheEntry) expired(now time.Time) bool {
+	return !e.expireAt.IsZero() && now.After(e.expireAt)
+}
+
+// lruStore is the store of LRU policy.
+type lruStore struct {
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+func newLRUStore(capacity int) *lruStore {
+	return &lruStore{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+func (s *lruStore) get(key string) (*cacheEntry, bool) {
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.ll.MoveToFront(elem)
+	return elem.Value.(*storeItem).entry, true
+}
+
+func (s *lruStore) set(key string, entry *cacheEntry) int {
+	if elem, ok := s.items[key]; ok {
+		elem.Value.(*storeItem).entry = entry
+		s.ll.MoveToFront(elem)
+		return 0
+	}
+
+	evicted := 0
+	for s.ll.Len() >= s.capacity {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*storeItem).key)
+		evicted++
+	}
+
+	s.items[key] = s.ll.PushFront(&storeItem{key: key, entry: entry})
+	return evicted
+}
+
+func (s *lruStore) remove(key string) {
+	if elem, ok := s.items[key]; ok {
+		s.ll.Remove(elem)
+		delete(s.items, key)
+	}
+}
+
+func (s *lruStore) len() int {
+	return s.ll.Len()
+}
@@ -0,0 +1,173 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package memorycache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func entryOf(code int) *cacheEntry {
+	return &cacheEntry{statusCode: code}
+}
+
+func TestLRUStore(t *testing.T) {
+	s := newStore(EvictionPolicyLRU, 2)
+
+	s.set("a", entryOf(1))
+	s.set("b", entryOf(2))
+	s.get("a")
+	if evicted := s.set("c", entryOf(3)); evicted != 1 {
+		t.Fatalf("want 1 eviction, got %d", evicted)
+	}
+
+	if _, ok := s.get("b"); ok {
+		t.Errorf("b should be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := s.get(key); !ok {
+			t.Errorf("%s should be kept", key)
+		}
+	}
+
+	s.set("a", entryOf(10))
+	if e, _ := s.get("a"); e.statusCode != 10 {
+		t.Errorf("a should be updated")
+	}
+	if s.len() != 2 {
+		t.Errorf("want 2 entries, got %d", s.len())
+	}
+
+	s.remove("a")
+	if _, ok := s.get("a"); ok || s.len() != 1 {
+		t.Errorf("a should be removed")
+	}
+}
+
+func TestLFUStore(t *testing.T) {
+	s := newStore(EvictionPolicyLFU, 3)
+
+	s.set("a", entryOf(1))
+	s.set("b", entryOf(2))
+	s.set("c", entryOf(3))
+	for i := 0; i < 3; i++ {
+		s.get("a")
+		s.get("c")
+	}
+	s.get("b")
+
+	// b has the lowest frequency although it's the most recently used.
+	if evicted := s.set("d", entryOf(4)); evicted != 1 {
+		t.Fatalf("want 1 eviction, got %d", evicted)
+	}
+	if _, ok := s.get("b"); ok {
+		t.Errorf("b should be evicted")
+	}
+
+	// d and e have the same frequency, the least recently used one goes.
+	s.set("e", entryOf(5))
+	if _, ok := s.get("d"); ok {
+		t.Errorf("d should be evicted")
+	}
+	for _, key := range []string{"a", "c", "e"} {
+		if _, ok := s.get(key); !ok {
+			t.Errorf("%s should be kept", key)
+		}
+	}
+
+	s.remove("e")
+	s.set("f", entryOf(6))
+	s.set("g", entryOf(7))
+	if _, ok := s.get("f"); ok {
+		t.Errorf("f should be evicted")
+	}
+	if s.len() != 3 {
+		t.Errorf("want 3 entries, got %d", s.len())
+	}
+}
+
+func TestARCStore(t *testing.T) {
+	s := newStore(EvictionPolicyARC, 4)
+
+	// Hot keys are accessed twice and move to t2.
+	for _, key := range []string{"hot1", "hot2"} {
+		s.set(key, entryOf(1))
+		s.get(key)
+	}
+
+	// A long-tail scan must not flush the hot keys, which is what
+	// makes plain LRU thrash.
+	for i := 0; i < 100; i++ {
+		s.set(fmt.Sprintf("tail%d", i), entryOf(2))
+	}
+
+	for _, key := range []string{"hot1", "hot2"} {
+		if _, ok := s.get(key); !ok {
+			t.Errorf("%s should be kept", key)
+		}
+	}
+	if s.len() != 4 {
+		t.Errorf("want 4 entries, got %d", s.len())
+	}
+
+	as := s.(*arcStore)
+	if as.b1.len()+as.b2.len() > 2*as.capacity {
+		t.Errorf("ghost lists grow unbounded: %d", as.b1.len()+as.b2.len())
+	}
+
+	// A ghost hit brings the key back.
+	s.set("tail0", entryOf(3))
+	if e, ok := s.get("tail0"); !ok || e.statusCode != 3 {
+		t.Errorf("tail0 should be stored")
+	}
+
+	s.remove("tail0")
+	if _, ok := s.get("tail0"); ok {
+		t.Errorf("tail0 should be removed")
+	}
+}
+
+func TestStoreEvictionCount(t *testing.T) {
+	for _, policy := range []string{EvictionPolicyLRU, EvictionPolicyLFU, EvictionPolicyARC} {
+		s := newStore(policy, 10)
+		evicted := 0
+		for i := 0; i < 100; i++ {
+			evicted += s.set(fmt.Sprintf("key%d", i), entryOf(i))
+		}
+		if s.len() != 10 {
+			t.Errorf("%s: want 10 entries, got %d", policy, s.len())
+		}
+		if evicted != 90 {
+			t.Errorf("%s: want 90 evictions, got %d", policy, evicted)
+		}
+	}
+}
+
+func TestCacheEntryExpired(t *testing.T) {
+	now := time.Now()
+	if (&cacheEntry{}).expired(now) {
+		t.Errorf("entry without expireAt should not expire")
+	}
+	if !(&cacheEntry{expireAt: now.Add(-time.Second)}).expired(now) {
+		t.Errorf("entry should expire")
+	}
+	if (&cacheEntry{expireAt: now.Add(time.Second)}).expired(now) {
+		t.Errorf("entry should not expire")
+	}
+}
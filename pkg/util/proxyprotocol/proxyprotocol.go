@@ -0,0 +1,340 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package proxyprotocol parses the PROXY protocol headers sent by L4 load
+// balancers, so the addresses of connections are the ones of clients.
+// Reference: https://www.haproxy.org/download/2.4/doc/proxy-protocol.txt
+package proxyprotocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxV1HeaderLen is the max length of a v1 header including CRLF.
+	maxV1HeaderLen = 107
+
+	v2HeaderLen = 16
+	// maxV2AddressLen is the max length of the addresses and TLVs, it is
+	// smaller than the one of the protocol, which is 65535.
+	maxV2AddressLen = 4096
+)
+
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+var errClosed = errors.New("listener closed")
+
+type (
+	// Listener wraps a net.Listener to parse the PROXY protocol headers
+	// of accepted connections. The headers are parsed in background, so
+	// slow clients don't block the accepting of others.
+	Listener struct {
+		net.Listener
+		timeout time.Duration
+
+		results chan *acceptResult
+		done    chan struct{}
+		once    sync.Once
+	}
+
+	acceptResult struct {
+		conn net.Conn
+		err  error
+	}
+
+	// conn is a connection whose PROXY protocol header has been read.
+	conn struct {
+		net.Conn
+		r          *bufio.Reader
+		remoteAddr net.Addr
+		localAddr  net.Addr
+	}
+)
+
+// NewListener creates a Listener, connections which don't send a valid
+// header within timeout are closed.
+func NewListener(l net.Listener, timeout time.Duration) *Listener {
+	pl := &Listener{
+		Listener: l,
+		timeout:  timeout,
+		results:  make(chan *acceptResult),
+		done:     make(chan struct{}),
+	}
+	go pl.acceptLoop()
+	return pl
+}
+
+func (l *Listener) acceptLoop() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if !l.deliver(&acceptResult{err: err}) {
+					return
+				}
+				continue
+			}
+			// The error is returned by all following calls of Accept.
+			for l.deliver(&acceptResult{err: err}) {
+			}
+			return
+		}
+
+		go l.handshake(c)
+	}
+}
+
+// deliver delivers the result to Accept, it returns false if the listener
+// is closed.
+func (l *Listener) deliver(result *acceptResult) bool {
+	select {
+	case l.results <- result:
+		return true
+	case <-l.done:
+		if result.conn != nil {
+			result.conn.Close()
+		}
+		return false
+	}
+}
+
+func (l *Listener) handshake(c net.Conn) {
+	pc, err := newConn(c, l.timeout)
+	if err != nil {
+		// NOTE: The connection is dropped, but it is not an error of
+		// the listener.
+		c.Close()
+		return
+	}
+	l.deliver(&acceptResult{conn: pc})
+}
+
+// Accept waits for and returns the next connection whose header has been
+// parsed.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case result := <-l.results:
+		return result.conn, result.err
+	case <-l.done:
+		return nil, errClosed
+	}
+}
+
+// Close closes the listener.
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+func newConn(c net.Conn, timeout time.Duration) (*conn, error) {
+	if timeout > 0 {
+		c.SetReadDeadline(time.Now().Add(timeout))
+	}
+
+	pc := &conn{Conn: c, r: bufio.NewReader(c)}
+	if err := pc.readHeader(); err != nil {
+		return nil, err
+	}
+
+	if timeout > 0 {
+		c.SetReadDeadline(time.Time{})
+	}
+	return pc, nil
+}
+
+// Read reads data following the header.
+func (c *conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// RemoteAddr returns the address of the client, or the address of the
+// peer if the header doesn't carry addresses.
+func (c *conn) RemoteAddr() net.Addr {
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the client, or the local
+// address if the header doesn't carry addresses.
+func (c *conn) LocalAddr() net.Addr {
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+func (c *conn) readHeader() error {
+	b, err := c.r.Peek(1)
+	if err != nil {
+		return err
+	}
+
+	switch b[0] {
+	case 'P':
+		return c.readV1Header()
+	case '\r':
+		return c.readV2Header()
+	default:
+		return fmt.Errorf("no proxy protocol header")
+	}
+}
+
+// readV1Header reads the header: PROXY TCP4|TCP6|UNKNOWN src dst sport dport\r\n.
+func (c *conn) readV1Header() error {
+	var line []byte
+	for {
+		b, err := c.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= maxV1HeaderLen {
+			return fmt.Errorf("v1 header too long")
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return fmt.Errorf("invalid v1 header")
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return fmt.Errorf("invalid v1 header")
+	}
+
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil
+	case "TCP4", "TCP6":
+	default:
+		return fmt.Errorf("invalid v1 protocol %s", fields[1])
+	}
+
+	if len(fields) != 6 {
+		return fmt.Errorf("invalid v1 header")
+	}
+	src, err := parseV1Addr(fields[2], fields[4])
+	if err != nil {
+		return err
+	}
+	dst, err := parseV1Addr(fields[3], fields[5])
+	if err != nil {
+		return err
+	}
+	if (src.IP.To4() != nil) != (fields[1] == "TCP4") {
+		return fmt.Errorf("address family mismatch")
+	}
+
+	c.remoteAddr, c.localAddr = src, dst
+	return nil
+}
+
+func parseV1Addr(ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, fmt.Errorf("invalid ip %s", ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %s", port)
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+// readV2Header reads the binary header.
+func (c *conn) readV2Header() error {
+	header := make([]byte, v2HeaderLen)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header[:12], v2Signature) {
+		return fmt.Errorf("invalid v2 signature")
+	}
+
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 {
+		return fmt.Errorf("invalid v2 version %d", version)
+	}
+
+	length := int(binary.BigEndian.Uint16(header[14:]))
+	if length > maxV2AddressLen {
+		return fmt.Errorf("v2 addresses too long")
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return err
+	}
+
+	switch command {
+	case 0x0:
+		// LOCAL: the connection is established by the proxy itself,
+		// e.g. health checks, the real addresses are used.
+		return nil
+	case 0x1:
+	default:
+		return fmt.Errorf("invalid v2 command %d", command)
+	}
+
+	family, transport := header[13]>>4, header[13]&0x0f
+	if transport != 0x1 && transport != 0x2 {
+		// UNSPEC, the addresses are ignored.
+		return nil
+	}
+
+	var ipLen int
+	switch family {
+	case 0x1:
+		ipLen = net.IPv4len
+	case 0x2:
+		ipLen = net.IPv6len
+	default:
+		// UNSPEC or UNIX, the addresses are ignored.
+		return nil
+	}
+
+	if len(data) < ipLen*2+4 {
+		return fmt.Errorf("v2 addresses too short")
+	}
+	srcIP := net.IP(append([]byte(nil), data[:ipLen]...))
+	dstIP := net.IP(append([]byte(nil), data[ipLen:ipLen*2]...))
+	srcPort := int(binary.BigEndian.Uint16(data[ipLen*2:]))
+	dstPort := int(binary.BigEndian.Uint16(data[ipLen*2+2:]))
+
+	if transport == 0x1 {
+		c.remoteAddr = &net.TCPAddr{IP: srcIP, Port: srcPort}
+		c.localAddr = &net.TCPAddr{IP: dstIP, Port: dstPort}
+	} else {
+		c.remoteAddr = &net.UDPAddr{IP: srcIP, Port: srcPort}
+		c.localAddr = &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return nil
+}
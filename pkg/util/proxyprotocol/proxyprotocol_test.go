@@ -0,0 +1,185 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package proxyprotocol
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func listen(t *testing.T, timeout time.Duration) *Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	return NewListener(ln, timeout)
+}
+
+// send dials the listener, writes the header and the payload, and returns
+// the accepted connection.
+func send(t *testing.T, l *Listener, header []byte) (net.Conn, net.Conn) {
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	c.Write(append(header, "hello"...))
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		ch <- result{conn, err}
+	}()
+
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			t.Fatalf("accept failed: %v", r.err)
+		}
+		return c, r.conn
+	case <-time.After(time.Second):
+		c.Close()
+		return nil, nil
+	}
+}
+
+func checkConn(t *testing.T, conn net.Conn, remote, local string) {
+	if conn == nil {
+		t.Fatalf("connection should be accepted")
+	}
+	defer conn.Close()
+
+	if remote != "" && conn.RemoteAddr().String() != remote {
+		t.Errorf("remote address should be %s, got %s", remote, conn.RemoteAddr())
+	}
+	if local != "" && conn.LocalAddr().String() != local {
+		t.Errorf("local address should be %s, got %s", local, conn.LocalAddr())
+	}
+
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Errorf("payload should follow the header, got %q: %v", buf, err)
+	}
+}
+
+func v2Header(command, family byte, addrs []byte) []byte {
+	header := append([]byte(nil), v2Signature...)
+	header = append(header, 0x20|command, family, 0, 0)
+	binary.BigEndian.PutUint16(header[14:], uint16(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestV1(t *testing.T) {
+	l := listen(t, time.Second)
+	defer l.Close()
+
+	c, conn := send(t, l, []byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324 443\r\n"))
+	defer c.Close()
+	checkConn(t, conn, "192.168.1.1:56324", "10.0.0.1:443")
+
+	c, conn = send(t, l, []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"))
+	defer c.Close()
+	checkConn(t, conn, "[2001:db8::1]:56324", "[2001:db8::2]:443")
+
+	c, conn = send(t, l, []byte("PROXY UNKNOWN\r\n"))
+	defer c.Close()
+	checkConn(t, conn, c.LocalAddr().String(), "")
+}
+
+func TestV2(t *testing.T) {
+	l := listen(t, time.Second)
+	defer l.Close()
+
+	addrs := []byte{192, 168, 1, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	// A TLV follows the addresses.
+	addrs = append(addrs, 0x04, 0x00, 0x01, 0x00)
+	c, conn := send(t, l, v2Header(0x1, 0x11, addrs))
+	defer c.Close()
+	checkConn(t, conn, "192.168.1.1:56324", "10.0.0.1:443")
+
+	addrs = make([]byte, 36)
+	copy(addrs, net.ParseIP("2001:db8::1"))
+	copy(addrs[16:], net.ParseIP("2001:db8::2"))
+	binary.BigEndian.PutUint16(addrs[32:], 56324)
+	binary.BigEndian.PutUint16(addrs[34:], 443)
+	c, conn = send(t, l, v2Header(0x1, 0x21, addrs))
+	defer c.Close()
+	checkConn(t, conn, "[2001:db8::1]:56324", "[2001:db8::2]:443")
+
+	// LOCAL keeps the real addresses.
+	c, conn = send(t, l, v2Header(0x0, 0x00, nil))
+	defer c.Close()
+	checkConn(t, conn, c.LocalAddr().String(), "")
+}
+
+func TestInvalidHeader(t *testing.T) {
+	l := listen(t, 200*time.Millisecond)
+	defer l.Close()
+
+	headers := [][]byte{
+		[]byte("GET / HTTP/1.1\r\n"),
+		[]byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324\r\n"),
+		[]byte("PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n"),
+		[]byte("PROXY TCP4 192.168.1.1 10.0.0.1 99999 443\r\n"),
+		v2Header(0x2, 0x11, nil),
+		v2Header(0x1, 0x11, []byte{192, 168, 1, 1}),
+		// Incomplete header, closed by timeout.
+		[]byte("PROXY TCP4"),
+	}
+
+	for i, header := range headers {
+		c, err := net.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatalf("dial failed: %v", err)
+		}
+		c.Write(header)
+
+		// The connection is closed by the listener.
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			t.Errorf("header %d: connection should be dropped", i)
+		}
+		c.Close()
+	}
+
+	// A slow client doesn't block others.
+	slow, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer slow.Close()
+	c, conn := send(t, l, []byte("PROXY TCP4 192.168.1.1 10.0.0.1 56324 443\r\n"))
+	defer c.Close()
+	checkConn(t, conn, "192.168.1.1:56324", "")
+}
+
+func TestClose(t *testing.T) {
+	l := listen(t, time.Second)
+	l.Close()
+
+	if _, err := l.Accept(); err == nil {
+		t.Errorf("accept should fail after close")
+	}
+}
@@ -197,6 +197,58 @@ func (rl *RateLimiter) AcquireNPermission(n int) (bool, time.Duration) {
 	return rl.acquirePermission(n)
 }
 
+// Quota returns the number of permissions per refresh period, the number of
+// permissions remaining in the current period, and the duration until the
+// current period ends.
+func (rl *RateLimiter) Quota() (limit int, remaining int, reset time.Duration) {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	now := nowFunc()
+	limit = rl.policy.LimitForPeriod
+	elapsed := now.Sub(rl.startTime)
+	reset = rl.policy.LimitRefreshPeriod - elapsed%rl.policy.LimitRefreshPeriod
+
+	if rl.state == StateDisabled {
+		return limit, limit, reset
+	}
+
+	cycle := int(elapsed / rl.policy.LimitRefreshPeriod)
+	tokens := rl.tokens - (cycle-rl.cycle)*rl.policy.LimitForPeriod
+	if tokens < 0 {
+		tokens = 0
+	}
+	if tokens < limit {
+		remaining = limit - tokens
+	}
+	return limit, remaining, reset
+}
+
+// RetryAfter returns the duration until a permission could be acquired
+// without waiting, it counts the permissions reserved in the later periods
+// besides the ones of the current period.
+func (rl *RateLimiter) RetryAfter() time.Duration {
+	rl.lock.Lock()
+	defer rl.lock.Unlock()
+
+	elapsed := nowFunc().Sub(rl.startTime)
+	reset := rl.policy.LimitRefreshPeriod - elapsed%rl.policy.LimitRefreshPeriod
+	if rl.state == StateDisabled {
+		return 0
+	}
+
+	cycle := int(elapsed / rl.policy.LimitRefreshPeriod)
+	tokens := rl.tokens - (cycle-rl.cycle)*rl.policy.LimitForPeriod
+	if tokens < rl.policy.LimitForPeriod {
+		return 0
+	}
+
+	// The permissions of the current period and the next ones are used up
+	// until the period of the token after the reserved ones.
+	periods := tokens / rl.policy.LimitForPeriod
+	return reset + time.Duration(periods-1)*rl.policy.LimitRefreshPeriod
+}
+
 // WaitPermission waits a permission from the rate limiter
 // returns true if the request is permitted and false if timed out
 func (rl *RateLimiter) WaitPermission() bool {
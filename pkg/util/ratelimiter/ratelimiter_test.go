@@ -244,3 +244,66 @@ func TestRateLimiterN(t *testing.T) {
 	}
 	limiter.SetState(StateDisabled)
 }
+
+func TestQuota(t *testing.T) {
+	policy := NewPolicy(0, 10*time.Millisecond, 5)
+	limiter := New(policy)
+
+	limit, remaining, reset := limiter.Quota()
+	if limit != 5 || remaining != 5 || reset != 10*time.Millisecond {
+		t.Errorf("unexpected quota: %d, %d, %v", limit, remaining, reset)
+	}
+
+	for i := 0; i < 3; i++ {
+		limiter.AcquirePermission()
+	}
+	now = now.Add(4 * time.Millisecond)
+	limit, remaining, reset = limiter.Quota()
+	if limit != 5 || remaining != 2 || reset != 6*time.Millisecond {
+		t.Errorf("unexpected quota: %d, %d, %v", limit, remaining, reset)
+	}
+
+	limiter.AcquireNPermission(2)
+	if _, remaining, _ = limiter.Quota(); remaining != 0 {
+		t.Errorf("remaining should be 0, but got %d", remaining)
+	}
+
+	now = now.Add(6 * time.Millisecond)
+	if _, remaining, _ = limiter.Quota(); remaining != 5 {
+		t.Errorf("remaining should be 5, but got %d", remaining)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	policy := NewPolicy(20*time.Millisecond, 10*time.Millisecond, 5)
+	limiter := New(policy)
+
+	if d := limiter.RetryAfter(); d != 0 {
+		t.Errorf("retry after should be 0, but got %v", d)
+	}
+
+	// The permissions of the current and the next 2 periods are reserved.
+	now = now.Add(4 * time.Millisecond)
+	for i := 0; i < 15; i++ {
+		if permitted, _ := limiter.AcquirePermission(); !permitted {
+			t.Fatalf("permission %d should be permitted", i)
+		}
+	}
+	if permitted, _ := limiter.AcquirePermission(); permitted {
+		t.Fatalf("AcquirePermission should fail")
+	}
+
+	d := limiter.RetryAfter()
+	if d != 26*time.Millisecond {
+		t.Errorf("retry after should be 26ms, but got %v", d)
+	}
+
+	now = now.Add(d - time.Millisecond)
+	if limiter.RetryAfter() <= 0 {
+		t.Errorf("permissions should still be reserved before retry after")
+	}
+	now = now.Add(time.Millisecond)
+	if permitted, wait := limiter.AcquirePermission(); !permitted || wait != 0 {
+		t.Errorf("permission after retry after should not wait, got %v %v", permitted, wait)
+	}
+}
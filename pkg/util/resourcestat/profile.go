@@ -0,0 +1,225 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resourcestat
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+)
+
+// The field numbers of the messages in profile.proto of pprof, only the
+// ones needed by the accounting are decoded.
+const (
+	profileSample      = 2
+	profileStringTable = 6
+
+	sampleValue = 2
+	sampleLabel = 3
+
+	labelKey = 1
+	labelStr = 2
+
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+type (
+	profileSampleRecord struct {
+		values []int64
+		labels [][2]int64
+	}
+
+	// cpuProfile is the result of decoding a CPU profile.
+	cpuProfile struct {
+		// usage is the CPU nanoseconds of the labeled samples.
+		usage map[Key]int64
+		// total is the CPU nanoseconds of all samples.
+		total int64
+	}
+
+	protoField struct {
+		num      int
+		wireType int
+		varint   uint64
+		bytes    []byte
+	}
+)
+
+// parseCPUProfile decodes a gzipped CPU profile written by
+// runtime/pprof, and sums up the CPU time by the labels of the samples.
+func parseCPUProfile(data []byte) (*cpuProfile, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("decompress profile failed: %v", err)
+	}
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompress profile failed: %v", err)
+	}
+
+	var samples []*profileSampleRecord
+	var strs []string
+	err = walkProto(data, func(f *protoField) error {
+		switch {
+		case f.num == profileSample && f.wireType == wireBytes:
+			sample, err := parseSample(f.bytes)
+			if err != nil {
+				return err
+			}
+			samples = append(samples, sample)
+		case f.num == profileStringTable && f.wireType == wireBytes:
+			strs = append(strs, string(f.bytes))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(index int64) string {
+		if index < 0 || index >= int64(len(strs)) {
+			return ""
+		}
+		return strs[index]
+	}
+
+	p := &cpuProfile{usage: map[Key]int64{}}
+	for _, sample := range samples {
+		// NOTE: The values of CPU profiles are [samples/count, cpu/nanoseconds].
+		if len(sample.values) < 2 {
+			continue
+		}
+		nanos := sample.values[1]
+		p.total += nanos
+
+		var key Key
+		for _, label := range sample.labels {
+			switch str(label[0]) {
+			case labelServer:
+				key.Server = str(label[1])
+			case labelRoute:
+				key.Route = str(label[1])
+			case labelTenant:
+				key.Tenant = str(label[1])
+			}
+		}
+		if key.Server == "" {
+			continue
+		}
+		p.usage[key] += nanos
+	}
+
+	return p, nil
+}
+
+func parseSample(data []byte) (*profileSampleRecord, error) {
+	sample := &profileSampleRecord{}
+	err := walkProto(data, func(f *protoField) error {
+		switch f.num {
+		case sampleValue:
+			if f.wireType == wireVarint {
+				sample.values = append(sample.values, int64(f.varint))
+				return nil
+			}
+			// Packed repeated values.
+			buff := f.bytes
+			for len(buff) > 0 {
+				v, n := binary.Uvarint(buff)
+				if n <= 0 {
+					return fmt.Errorf("invalid packed sample value")
+				}
+				sample.values = append(sample.values, int64(v))
+				buff = buff[n:]
+			}
+		case sampleLabel:
+			if f.wireType != wireBytes {
+				return nil
+			}
+			var label [2]int64
+			err := walkProto(f.bytes, func(f *protoField) error {
+				switch f.num {
+				case labelKey:
+					label[0] = int64(f.varint)
+				case labelStr:
+					label[1] = int64(f.varint)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			sample.labels = append(sample.labels, label)
+		}
+		return nil
+	})
+
+	return sample, err
+}
+
+// walkProto calls fn for every field of the protobuf encoded message.
+func walkProto(data []byte, fn func(f *protoField) error) error {
+	f := &protoField{}
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return fmt.Errorf("invalid protobuf tag")
+		}
+		data = data[n:]
+
+		*f = protoField{num: int(tag >> 3), wireType: int(tag & 7)}
+		switch f.wireType {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("invalid protobuf varint of field %d", f.num)
+			}
+			data = data[n:]
+		case wireFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("invalid protobuf fixed64 of field %d", f.num)
+			}
+			f.varint = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case wireFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("invalid protobuf fixed32 of field %d", f.num)
+			}
+			f.varint = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return fmt.Errorf("invalid protobuf length of field %d", f.num)
+			}
+			f.bytes = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return fmt.Errorf("unsupported protobuf wire type %d of field %d", f.wireType, f.num)
+		}
+
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
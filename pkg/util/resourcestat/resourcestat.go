@@ -0,0 +1,306 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package resourcestat estimates the CPU time and the memory allocations
+// of requests by routes and tenants, via sampled CPU profiling.
+//
+// The goroutine handling a request is labeled with its server, route
+// and tenant, the profiler records the labels of every sample, so the CPU
+// time could be attributed to them. The profiler runs for a short window
+// in every sampling interval to keep the overhead low, and the usage is
+// scaled up by the ratio of the interval to the window. The allocated
+// bytes are not attributed by the profiler, they're distributed to the
+// labels in proportion to the CPU time in the same window, which is a
+// reasonable approximation for a gateway. Thus the numbers are estimations
+// for telling which APIs and tenants cost the most, not for billing.
+package resourcestat
+
+import (
+	"bytes"
+	stdcontext "context"
+	"runtime/metrics"
+	"runtime/pprof"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+const (
+	labelServer = "eg.server"
+	labelRoute  = "eg.route"
+	labelTenant = "eg.tenant"
+
+	keyLabels = "resourceLabels"
+
+	metricAllocs = "/gc/heap/allocs:bytes"
+)
+
+type (
+	// Key identifies the usage of a route of a server, the tenant is
+	// empty for anonymous requests.
+	Key struct {
+		Server string
+		Route  string
+		Tenant string
+	}
+
+	// Usage is the estimated resource usage.
+	Usage struct {
+		CPUTime    time.Duration
+		AllocBytes uint64
+	}
+
+	// Status is the status of the resource usage of a route and tenant.
+	Status struct {
+		Route  string `yaml:"route"`
+		Tenant string `yaml:"tenant,omitempty"`
+		// CPUSeconds is the estimated CPU time since the accounting enabled.
+		CPUSeconds float64 `yaml:"cpuSeconds"`
+		// AllocBytes is the estimated allocated memory since the accounting enabled.
+		AllocBytes uint64 `yaml:"allocBytes"`
+	}
+
+	sampler struct {
+		interval time.Duration
+		window   time.Duration
+
+		mutex sync.Mutex
+		refs  int
+		done  chan struct{}
+		usage map[Key]*Usage
+	}
+
+	labels struct {
+		server string
+		route  string
+		tenant string
+	}
+)
+
+var globalSampler = newSampler(time.Minute, 10*time.Second)
+
+func newSampler(interval, window time.Duration) *sampler {
+	return &sampler{
+		interval: interval,
+		window:   window,
+		usage:    map[Key]*Usage{},
+	}
+}
+
+// Acquire starts the sampling if it's not running, every Acquire must be
+// paired with a Release.
+func Acquire() {
+	globalSampler.acquire()
+}
+
+// Release stops the sampling if nobody needs it.
+func Release() {
+	globalSampler.release()
+}
+
+// ServerStatus returns the resource usage of the server, sorted by the
+// CPU time in descending order.
+func ServerStatus(server string) []*Status {
+	return globalSampler.serverStatus(server)
+}
+
+// RemoveServer removes the resource usage of the server.
+func RemoveServer(server string) {
+	globalSampler.removeServer(server)
+}
+
+// Start labels the goroutine handling the request with the server and the
+// route, the returned function must be called after handling the request.
+func Start(ctx context.HTTPContext, server, route string) func() {
+	l := &labels{server: server, route: route}
+	ctx.SetKV(keyLabels, l)
+	pprof.SetGoroutineLabels(l.context())
+	return func() {
+		pprof.SetGoroutineLabels(stdcontext.Background())
+	}
+}
+
+// LabelTenant labels the goroutine handling the request with the tenant of
+// the authenticated identity, it does nothing if the request is not
+// accounted or its tenant is unknown.
+func LabelTenant(ctx context.HTTPContext) {
+	l, ok := ctx.GetKV(keyLabels).(*labels)
+	if !ok {
+		return
+	}
+	identity := context.GetIdentity(ctx)
+	if identity == nil || identity.Tenant == l.tenant {
+		return
+	}
+	l.tenant = identity.Tenant
+	pprof.SetGoroutineLabels(l.context())
+}
+
+func (l *labels) context() stdcontext.Context {
+	kvs := []string{labelServer, l.server, labelRoute, l.route}
+	if l.tenant != "" {
+		kvs = append(kvs, labelTenant, l.tenant)
+	}
+	return pprof.WithLabels(stdcontext.Background(), pprof.Labels(kvs...))
+}
+
+func (s *sampler) acquire() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.refs++
+	if s.refs == 1 {
+		s.done = make(chan struct{})
+		go s.run(s.done)
+	}
+}
+
+func (s *sampler) release() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.refs == 0 {
+		logger.Errorf("BUG: release resource sampler without acquiring")
+		return
+	}
+	s.refs--
+	if s.refs == 0 {
+		close(s.done)
+		s.done = nil
+	}
+}
+
+func (s *sampler) run(done chan struct{}) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			s.sample(done)
+		}
+	}
+}
+
+// sample profiles the CPU for a window and accounts the usage.
+func (s *sampler) sample(done chan struct{}) {
+	buff := &bytes.Buffer{}
+	allocs := readAllocs()
+
+	// NOTE: Only one CPU profile could run at a time, the window is
+	// skipped if there's another one, e.g. the one of --cpu-profile-file.
+	err := pprof.StartCPUProfile(buff)
+	if err != nil {
+		logger.Warnf("start cpu profile for resource accounting failed, skipped: %v", err)
+		return
+	}
+
+	start := time.Now()
+	timer := time.NewTimer(s.window)
+	select {
+	case <-timer.C:
+	case <-done:
+		timer.Stop()
+		pprof.StopCPUProfile()
+		return
+	}
+	pprof.StopCPUProfile()
+	elapsed := time.Since(start)
+	allocs = readAllocs() - allocs
+
+	p, err := parseCPUProfile(buff.Bytes())
+	if err != nil {
+		logger.Errorf("parse cpu profile for resource accounting failed: %v", err)
+		return
+	}
+
+	s.account(p, allocs, float64(s.interval)/float64(elapsed))
+}
+
+// account accumulates the usage of the profile, scale is the ratio of
+// the sampling interval to the profiling window.
+func (s *sampler) account(p *cpuProfile, allocs uint64, scale float64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key, nanos := range p.usage {
+		u := s.usage[key]
+		if u == nil {
+			u = &Usage{}
+			s.usage[key] = u
+		}
+		u.CPUTime += time.Duration(float64(nanos) * scale)
+		if p.total > 0 {
+			u.AllocBytes += uint64(float64(allocs) * float64(nanos) / float64(p.total) * scale)
+		}
+	}
+}
+
+func (s *sampler) serverStatus(server string) []*Status {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var result []*Status
+	for key, u := range s.usage {
+		if key.Server != server {
+			continue
+		}
+		result = append(result, &Status{
+			Route:      key.Route,
+			Tenant:     key.Tenant,
+			CPUSeconds: u.CPUTime.Seconds(),
+			AllocBytes: u.AllocBytes,
+		})
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CPUSeconds != result[j].CPUSeconds {
+			return result[i].CPUSeconds > result[j].CPUSeconds
+		}
+		if result[i].Route != result[j].Route {
+			return result[i].Route < result[j].Route
+		}
+		return result[i].Tenant < result[j].Tenant
+	})
+
+	return result
+}
+
+func (s *sampler) removeServer(server string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.usage {
+		if key.Server == server {
+			delete(s.usage, key)
+		}
+	}
+}
+
+func readAllocs() uint64 {
+	samples := []metrics.Sample{{Name: metricAllocs}}
+	metrics.Read(samples)
+	if samples[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return samples[0].Value.Uint64()
+}
@@ -0,0 +1,186 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package resourcestat
+
+import (
+	"bytes"
+	stdcontext "context"
+	"os"
+	"runtime/pprof"
+	"testing"
+	"time"
+
+	"github.com/megaease/easegress/pkg/context"
+	"github.com/megaease/easegress/pkg/context/contexttest"
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestMain(m *testing.M) {
+	logger.InitNop()
+	code := m.Run()
+	os.Exit(code)
+}
+
+func burnCPU(d time.Duration) int {
+	n := 0
+	for start := time.Now(); time.Since(start) < d; {
+		for i := 0; i < 10000; i++ {
+			n += i * i
+		}
+	}
+	return n
+}
+
+func TestParseCPUProfile(t *testing.T) {
+	buff := &bytes.Buffer{}
+	if err := pprof.StartCPUProfile(buff); err != nil {
+		t.Skipf("start cpu profile failed: %v", err)
+	}
+	labels := pprof.Labels(labelServer, "server", labelRoute, "route", labelTenant, "tenant")
+	pprof.Do(stdcontext.Background(), labels, func(stdcontext.Context) {
+		burnCPU(500 * time.Millisecond)
+	})
+	pprof.StopCPUProfile()
+
+	p, err := parseCPUProfile(buff.Bytes())
+	if err != nil {
+		t.Fatalf("parse cpu profile failed: %v", err)
+	}
+	nanos := p.usage[Key{Server: "server", Route: "route", Tenant: "tenant"}]
+	if nanos <= 0 {
+		t.Fatalf("no cpu time accounted to the labels: %v", p.usage)
+	}
+	if nanos > p.total {
+		t.Errorf("accounted cpu time %d exceeds the total %d", nanos, p.total)
+	}
+
+	if _, err := parseCPUProfile([]byte("not a profile")); err == nil {
+		t.Errorf("parse invalid profile should fail")
+	}
+}
+
+func TestWalkProto(t *testing.T) {
+	// Field 1 varint 150, field 2 bytes "ab".
+	data := []byte{0x08, 0x96, 0x01, 0x12, 0x02, 'a', 'b'}
+	var fields []protoField
+	err := walkProto(data, func(f *protoField) error {
+		fields = append(fields, *f)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk failed: %v", err)
+	}
+	if len(fields) != 2 || fields[0].varint != 150 || string(fields[1].bytes) != "ab" {
+		t.Errorf("unexpected fields: %+v", fields)
+	}
+
+	if err := walkProto([]byte{0x12, 0x05, 'a'}, func(*protoField) error { return nil }); err == nil {
+		t.Errorf("walk truncated message should fail")
+	}
+}
+
+func TestAccount(t *testing.T) {
+	s := newSampler(time.Minute, 10*time.Second)
+	p := &cpuProfile{
+		usage: map[Key]int64{
+			{Server: "s1", Route: "r1"}:               int64(300 * time.Millisecond),
+			{Server: "s1", Route: "r2", Tenant: "t1"}: int64(100 * time.Millisecond),
+			{Server: "s2", Route: "r1"}:               int64(100 * time.Millisecond),
+		},
+		total: int64(time.Second),
+	}
+	s.account(p, 1000, 6)
+
+	status := s.serverStatus("s1")
+	if len(status) != 2 {
+		t.Fatalf("want 2 status, got %d", len(status))
+	}
+	if status[0].Route != "r1" || status[0].CPUSeconds != 1.8 || status[0].AllocBytes != 1800 {
+		t.Errorf("unexpected status: %+v", status[0])
+	}
+	if status[1].Route != "r2" || status[1].Tenant != "t1" || status[1].AllocBytes != 600 {
+		t.Errorf("unexpected status: %+v", status[1])
+	}
+
+	s.account(p, 1000, 6)
+	if status := s.serverStatus("s2"); len(status) != 1 || status[0].CPUSeconds != 1.2 {
+		t.Errorf("unexpected status: %+v", status)
+	}
+
+	s.removeServer("s1")
+	if status := s.serverStatus("s1"); len(status) != 0 {
+		t.Errorf("usage of s1 should be removed")
+	}
+}
+
+func TestAcquireRelease(t *testing.T) {
+	s := newSampler(time.Hour, time.Second)
+	s.acquire()
+	s.acquire()
+	done := s.done
+	s.release()
+	if s.done == nil {
+		t.Fatalf("sampler should be running")
+	}
+	s.release()
+	if s.done != nil {
+		t.Fatalf("sampler should be stopped")
+	}
+	select {
+	case <-done:
+	default:
+		t.Errorf("done should be closed")
+	}
+	// Release without acquiring doesn't panic.
+	s.release()
+}
+
+func TestLabels(t *testing.T) {
+	kvs := map[string]interface{}{}
+	ctx := &contexttest.MockedHTTPContext{
+		MockedSetKV: func(key string, value interface{}) { kvs[key] = value },
+		MockedGetKV: func(key string) interface{} { return kvs[key] },
+	}
+
+	// Not accounted.
+	LabelTenant(ctx)
+
+	finish := Start(ctx, "server", "route")
+	defer finish()
+
+	l := kvs[keyLabels].(*labels)
+	LabelTenant(ctx)
+	if l.tenant != "" {
+		t.Errorf("tenant should be empty without identity")
+	}
+
+	context.SetIdentity(ctx, "jwt", "alice").Tenant = "t1"
+	LabelTenant(ctx)
+	if l.tenant != "t1" {
+		t.Errorf("want tenant t1, got %q", l.tenant)
+	}
+
+	got := map[string]string{}
+	pprof.ForLabels(l.context(), func(key, value string) bool {
+		got[key] = value
+		return true
+	})
+	if got[labelServer] != "server" || got[labelRoute] != "route" || got[labelTenant] != "t1" {
+		t.Errorf("unexpected labels: %v", got)
+	}
+}
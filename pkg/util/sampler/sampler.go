@@ -25,7 +25,8 @@ import (
 type (
 	// DurationSampler is the sampler for sampling duration.
 	DurationSampler struct {
-		count     uint64
+		// NOTE: There's no total count, it's summed up from the slots
+		// on reading, so updating doesn't contend on a shared counter.
 		durations []uint32
 	}
 
@@ -74,7 +75,6 @@ func (ds *DurationSampler) Update(d time.Duration) {
 		d -= bound
 		idx += s.slots
 	}
-	atomic.AddUint64(&ds.count, 1)
 	atomic.AddUint32(&ds.durations[idx], 1)
 }
 
@@ -83,21 +83,40 @@ func (ds *DurationSampler) Reset() {
 	for i := 0; i < len(ds.durations); i++ {
 		ds.durations[i] = 0
 	}
-	ds.count = 0
 }
 
 // Percentiles returns 7 metrics by order:
 // P25, P50, P75, P95, P98, P99, P999
 func (ds *DurationSampler) Percentiles() []float64 {
+	return computePercentiles(ds.durations)
+}
+
+// PercentilesAndReset returns the same as Percentiles, and resets the
+// sampler. Unlike calling Percentiles and Reset, it could be called
+// concurrently with Update without losing samples.
+func (ds *DurationSampler) PercentilesAndReset() []float64 {
+	durations := make([]uint32, len(ds.durations))
+	for i := range ds.durations {
+		durations[i] = atomic.SwapUint32(&ds.durations[i], 0)
+	}
+	return computePercentiles(durations)
+}
+
+func computePercentiles(durations []uint32) []float64 {
 	percentiles := []float64{0.25, 0.5, 0.75, 0.95, 0.98, 0.99, 0.999}
 
+	sum := uint64(0)
+	for _, n := range durations {
+		sum += uint64(n)
+	}
+
 	result := make([]float64, len(percentiles))
-	count, total := uint64(0), float64(ds.count)
+	count, total := uint64(0), float64(sum)
 	di, pi := 0, 0
 	base := time.Duration(0)
 	for _, s := range segments {
 		for i := 0; i < s.slots; i++ {
-			count += uint64(ds.durations[di])
+			count += uint64(durations[di])
 			di++
 			p := float64(count) / total
 			for p >= percentiles[pi] {
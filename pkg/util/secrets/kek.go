@@ -0,0 +1,197 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+type (
+	// KeyEncrypter wraps and unwraps the data key, the wrapped data key
+	// is the only thing stored together with the sealed values.
+	KeyEncrypter interface {
+		Wrap(dataKey []byte) (string, error)
+		Unwrap(wrapped string) ([]byte, error)
+	}
+
+	passphrase struct {
+		passphrase []byte
+	}
+
+	vaultTransit struct {
+		addr   string
+		key    string
+		token  string
+		client *http.Client
+	}
+)
+
+const (
+	passphrasePrefix = "scrypt:"
+	saltSize         = 16
+
+	// parameters recommended by the scrypt package.
+	scryptN = 32768
+	scryptR = 8
+	scryptP = 1
+)
+
+// NewPassphrase creates a KeyEncrypter which derives the key encryption
+// key from a passphrase.
+func NewPassphrase(pass []byte) KeyEncrypter {
+	return &passphrase{passphrase: pass}
+}
+
+func (p *passphrase) box(salt []byte) (*Box, error) {
+	key, err := scrypt.Key(p.passphrase, salt, scryptN, scryptR, scryptP, DataKeySize)
+	if err != nil {
+		return nil, err
+	}
+	return New(key)
+}
+
+func (p *passphrase) Wrap(dataKey []byte) (string, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	box, err := p.box(salt)
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := box.Seal(base64.StdEncoding.EncodeToString(dataKey))
+	if err != nil {
+		return "", err
+	}
+	return passphrasePrefix + base64.StdEncoding.EncodeToString(salt) + ":" + sealed, nil
+}
+
+func (p *passphrase) Unwrap(wrapped string) ([]byte, error) {
+	if !strings.HasPrefix(wrapped, passphrasePrefix) {
+		return nil, fmt.Errorf("data key is not wrapped by a passphrase")
+	}
+
+	fields := strings.SplitN(wrapped[len(passphrasePrefix):], ":", 2)
+	if len(fields) != 2 {
+		return nil, fmt.Errorf("invalid wrapped data key")
+	}
+	salt, err := base64.StdEncoding.DecodeString(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped data key: %v", err)
+	}
+
+	box, err := p.box(salt)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := box.Open(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key failed, the passphrase may be wrong: %v", err)
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+// NewVaultTransit creates a KeyEncrypter which wraps the data key with
+// the transit secrets engine of HashiCorp Vault, so the key encryption
+// key never leaves Vault.
+func NewVaultTransit(addr, key, token string) KeyEncrypter {
+	return &vaultTransit{
+		addr:   strings.TrimSuffix(addr, "/"),
+		key:    key,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (v *vaultTransit) call(op string, req map[string]string) (map[string]interface{}, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("%s/v1/transit/%s/%s", v.addr, op, v.key)
+	httpReq, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("X-Vault-Token", v.token)
+
+	resp, err := v.client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("vault transit %s failed, status code: %d, body: %s", op, resp.StatusCode, body)
+	}
+
+	var result struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("vault transit %s failed: %v", op, err)
+	}
+	return result.Data, nil
+}
+
+func (v *vaultTransit) Wrap(dataKey []byte) (string, error) {
+	data, err := v.call("encrypt", map[string]string{
+		"plaintext": base64.StdEncoding.EncodeToString(dataKey),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	ciphertext, _ := data["ciphertext"].(string)
+	if ciphertext == "" {
+		return "", fmt.Errorf("vault transit encrypt returned no ciphertext")
+	}
+	return ciphertext, nil
+}
+
+func (v *vaultTransit) Unwrap(wrapped string) ([]byte, error) {
+	if !strings.HasPrefix(wrapped, "vault:") {
+		return nil, fmt.Errorf("data key is not wrapped by vault")
+	}
+
+	data, err := v.call("decrypt", map[string]string{"ciphertext": wrapped})
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, _ := data["plaintext"].(string)
+	return base64.StdEncoding.DecodeString(plaintext)
+}
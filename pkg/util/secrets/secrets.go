@@ -0,0 +1,114 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package secrets encrypts the secret fields of object specs, so that
+// private keys and credentials are never stored in plain text.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+const (
+	// sealedPrefix is the prefix of a sealed value, the version is
+	// included to allow changing the algorithm in the future.
+	sealedPrefix = "enc:v1:"
+
+	// DataKeySize is the size of the data key, AES-256 is used.
+	DataKeySize = 32
+)
+
+// Box seals and opens secret values with a data key.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// GenerateDataKey generates a random data key.
+func GenerateDataKey() ([]byte, error) {
+	key := make([]byte, DataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// New creates a Box with the data key.
+func New(dataKey []byte) (*Box, error) {
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != DataKeySize {
+		return nil, fmt.Errorf("invalid key size %d, %d is required", len(key), DataKeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// IsSealed returns whether the value is sealed.
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal seals the value, a sealed value is returned as is.
+func (b *Box) Seal(value string) (string, error) {
+	if IsSealed(value) {
+		return value, nil
+	}
+
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	data := b.aead.Seal(nonce, nonce, []byte(value), nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// Open opens the sealed value, a value which is not sealed is returned
+// as is.
+func (b *Box) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	data, err := base64.StdEncoding.DecodeString(value[len(sealedPrefix):])
+	if err != nil {
+		return "", fmt.Errorf("decode sealed value failed: %v", err)
+	}
+
+	size := b.aead.NonceSize()
+	if len(data) < size {
+		return "", fmt.Errorf("sealed value too short")
+	}
+	plain, err := b.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return "", fmt.Errorf("open sealed value failed: %v", err)
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newBox(t *testing.T) *Box {
+	key, err := GenerateDataKey()
+	if err != nil {
+		t.Fatalf("generate data key failed: %v", err)
+	}
+	box, err := New(key)
+	if err != nil {
+		t.Fatalf("create box failed: %v", err)
+	}
+	return box
+}
+
+func TestSealOpen(t *testing.T) {
+	if _, err := New([]byte("short")); err == nil {
+		t.Errorf("short key should fail")
+	}
+
+	box := newBox(t)
+	sealed, err := box.Seal("my-secret")
+	if err != nil {
+		t.Fatalf("seal failed: %v", err)
+	}
+	if !IsSealed(sealed) || strings.Contains(sealed, "my-secret") {
+		t.Errorf("value is not sealed: %s", sealed)
+	}
+	if again, _ := box.Seal(sealed); again != sealed {
+		t.Errorf("sealed value should not be sealed again")
+	}
+
+	opened, err := box.Open(sealed)
+	if err != nil || opened != "my-secret" {
+		t.Errorf("open should return my-secret, got %q, %v", opened, err)
+	}
+	if opened, _ := box.Open("plain"); opened != "plain" {
+		t.Errorf("plain value should be returned as is")
+	}
+
+	if _, err = newBox(t).Open(sealed); err == nil {
+		t.Errorf("open with another key should fail")
+	}
+	if _, err = box.Open(sealedPrefix + "AAAA"); err == nil {
+		t.Errorf("open truncated value should fail")
+	}
+}
+
+const yamlConfig = `name: server
+kind: HTTPServer
+port: 443
+https: true
+keys:
+  example.com: private-key
+certs:
+  example.com: public-cert
+debug:
+  token: debug-token-1234
+mqtt:
+- name: cert1
+  cert: cert-data
+  key: key-data
+rules:
+- headers:
+  - key: X-Token
+    values:
+    - a
+`
+
+func TestSealYAML(t *testing.T) {
+	box := newBox(t)
+
+	sealed, err := box.SealYAML(yamlConfig)
+	if err != nil {
+		t.Fatalf("seal yaml failed: %v", err)
+	}
+	for _, s := range []string{"private-key", "debug-token-1234", "key-data"} {
+		if strings.Contains(sealed, s) {
+			t.Errorf("%s is not sealed:\n%s", s, sealed)
+		}
+	}
+	for _, s := range []string{"public-cert", "cert-data", "key: X-Token", "port: 443"} {
+		if !strings.Contains(sealed, s) {
+			t.Errorf("%s should not be sealed:\n%s", s, sealed)
+		}
+	}
+	if !HasSealed(sealed) || HasSealed(yamlConfig) {
+		t.Errorf("HasSealed returns wrong result")
+	}
+
+	opened, err := box.OpenYAML(sealed)
+	if err != nil {
+		t.Fatalf("open yaml failed: %v", err)
+	}
+	if opened != yamlConfig {
+		t.Errorf("opened yaml should be:\n%s\ngot:\n%s", yamlConfig, opened)
+	}
+
+	if _, err = newBox(t).OpenYAML(sealed); err == nil {
+		t.Errorf("open yaml with another key should fail")
+	}
+}
+
+func TestPassphrase(t *testing.T) {
+	dataKey, _ := GenerateDataKey()
+
+	kek := NewPassphrase([]byte("passphrase"))
+	wrapped, err := kek.Wrap(dataKey)
+	if err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+
+	key, err := kek.Unwrap(wrapped)
+	if err != nil || string(key) != string(dataKey) {
+		t.Errorf("unwrap should return the data key, got %v", err)
+	}
+
+	if _, err = NewPassphrase([]byte("wrong")).Unwrap(wrapped); err == nil {
+		t.Errorf("unwrap with wrong passphrase should fail")
+	}
+	if _, err = kek.Unwrap("vault:v1:abc"); err == nil {
+		t.Errorf("unwrap vault wrapped key should fail")
+	}
+}
+
+func TestVaultTransit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+
+		data := map[string]string{}
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/eg":
+			data["ciphertext"] = "vault:v1:" + req["plaintext"]
+		case "/v1/transit/decrypt/eg":
+			data["plaintext"] = strings.TrimPrefix(req["ciphertext"], "vault:v1:")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+	}))
+	defer srv.Close()
+
+	dataKey, _ := GenerateDataKey()
+
+	kek := NewVaultTransit(srv.URL+"/", "eg", "token")
+	wrapped, err := kek.Wrap(dataKey)
+	if err != nil {
+		t.Fatalf("wrap failed: %v", err)
+	}
+	if !strings.HasPrefix(wrapped, "vault:v1:") {
+		t.Errorf("wrapped key should be returned by vault, got %s", wrapped)
+	}
+
+	key, err := kek.Unwrap(wrapped)
+	if err != nil || string(key) != string(dataKey) {
+		t.Errorf("unwrap should return the data key, got %v", err)
+	}
+
+	if _, err = NewVaultTransit(srv.URL, "eg", "bad").Wrap(dataKey); err == nil {
+		t.Errorf("wrap with bad token should fail")
+	}
+	if _, err = kek.Unwrap("scrypt:abc"); err == nil {
+		t.Errorf("unwrap passphrase wrapped key should fail")
+	}
+}
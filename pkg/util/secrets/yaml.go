@@ -0,0 +1,132 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package secrets
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// secretFields are the names of spec fields holding secrets, all string
+// values under these fields are sealed, including the values of nested
+// maps and lists.
+var secretFields = map[string]bool{
+	"keyBase64":       true,
+	"wssKeyBase64":    true,
+	"keys":            true,
+	"privateKey":      true,
+	"password":        true,
+	"token":           true,
+	"secret":          true,
+	"clientSecret":    true,
+	"accessKeySecret": true,
+	"accessKeys":      true,
+	"secretAccessKey": true,
+	"sessionToken":    true,
+}
+
+// isSecretField returns whether the field of the map holds a secret.
+func isSecretField(m yaml.MapSlice, name string) bool {
+	if secretFields[name] {
+		return true
+	}
+
+	// field 'key' is a private key only if it is paired with a 'cert'.
+	if name != "key" {
+		return false
+	}
+	for _, item := range m {
+		if k, _ := item.Key.(string); k == "cert" {
+			return true
+		}
+	}
+	return false
+}
+
+// HasSealed returns whether the YAML config contains sealed values.
+func HasSealed(config string) bool {
+	return strings.Contains(config, sealedPrefix)
+}
+
+// SealYAML seals the values of the secret fields in the YAML config.
+func (b *Box) SealYAML(config string) (string, error) {
+	return b.walkYAML(config, func(v string, secret bool) (string, error) {
+		if !secret || v == "" {
+			return v, nil
+		}
+		return b.Seal(v)
+	})
+}
+
+// OpenYAML opens all sealed values in the YAML config.
+func (b *Box) OpenYAML(config string) (string, error) {
+	if !HasSealed(config) {
+		return config, nil
+	}
+	return b.walkYAML(config, func(v string, secret bool) (string, error) {
+		return b.Open(v)
+	})
+}
+
+func (b *Box) walkYAML(config string, fn func(v string, secret bool) (string, error)) (string, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal([]byte(config), &doc); err != nil {
+		return "", fmt.Errorf("unmarshal yaml failed: %v", err)
+	}
+
+	v, err := walk(doc, false, fn)
+	if err != nil {
+		return "", err
+	}
+
+	buff, err := yaml.Marshal(v)
+	if err != nil {
+		return "", fmt.Errorf("marshal yaml failed: %v", err)
+	}
+	return string(buff), nil
+}
+
+func walk(v interface{}, secret bool, fn func(v string, secret bool) (string, error)) (interface{}, error) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		for i := range v {
+			name, _ := v[i].Key.(string)
+			value, err := walk(v[i].Value, secret || isSecretField(v, name), fn)
+			if err != nil {
+				return nil, err
+			}
+			v[i].Value = value
+		}
+		return v, nil
+	case []interface{}:
+		for i := range v {
+			value, err := walk(v[i], secret, fn)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = value
+		}
+		return v, nil
+	case string:
+		return fn(v, secret)
+	default:
+		return v, nil
+	}
+}
@@ -112,6 +112,9 @@ type (
 		headerHoisting *HeaderHoisting
 		ttl            time.Duration
 		excludeBody    bool
+		// singleEscapePath escapes the path only once when building the
+		// canonical URI, which is required by AWS S3.
+		singleEscapePath bool
 		// accessKeyID & accessKeySecret are for signing
 		accessKeyID     string
 		accessKeySecret string
@@ -174,13 +177,15 @@ func init() {
 	}
 }
 
-func buildCanonicalURI(u *url.URL) string {
+func buildCanonicalURI(u *url.URL, singleEscape bool) string {
 	const hex = "0123456789ABCDEF"
 
 	var uri string
 
 	if len(u.Opaque) > 0 {
 		uri = "/" + strings.Join(strings.Split(u.Opaque, "/")[3:], "/")
+	} else if singleEscape {
+		uri = u.Path
 	} else {
 		uri = u.EscapedPath()
 	}
@@ -215,6 +220,13 @@ func (signer *Signer) ExcludeBody(exclude bool) *Signer {
 	return signer
 }
 
+// SingleEscapePath is an option function for Signer to escape the path only
+// once when building the canonical URI, AWS S3 requires this.
+func (signer *Signer) SingleEscapePath(single bool) *Signer {
+	signer.singleEscapePath = single
+	return signer
+}
+
 // IgnoreHeader is an option function for Signer to add ignored headers
 func (signer *Signer) IgnoreHeader(headers ...string) *Signer {
 	for _, h := range headers {
@@ -252,6 +264,23 @@ func (signer *Signer) SetCredential(accessKeyID string, accessKeySecret string)
 	return signer
 }
 
+// AWSLiteral returns the literals of AWS Signature Version 4, the scopes of
+// the signing context should be the region and the service.
+func AWSLiteral() *Literal {
+	return &Literal{
+		ScopeSuffix:      "aws4_request",
+		AlgorithmName:    "X-Amz-Algorithm",
+		AlgorithmValue:   "AWS4-HMAC-SHA256",
+		SignedHeaders:    "X-Amz-SignedHeaders",
+		Signature:        "X-Amz-Signature",
+		Date:             "X-Amz-Date",
+		Expires:          "X-Amz-Expires",
+		Credential:       "X-Amz-Credential",
+		ContentSHA256:    "X-Amz-Content-Sha256",
+		SigningKeyPrefix: "AWS4",
+	}
+}
+
 var defaultLiteral = &Literal{
 	ScopeSuffix:      "megaease_request",
 	AlgorithmName:    "X-Me-Algorithm",
@@ -265,6 +294,12 @@ var defaultLiteral = &Literal{
 	SigningKeyPrefix: "ME",
 }
 
+// DefaultLiteral returns a copy of the default literals.
+func DefaultLiteral() *Literal {
+	literal := *defaultLiteral
+	return &literal
+}
+
 // New creates a new signer
 func New() *Signer {
 	signer := &Signer{
@@ -340,7 +375,8 @@ func (ctx *SigningContext) getCanonicalQuery(u *url.URL) string {
 	for _, v := range ctx.Query {
 		sort.Strings(v)
 	}
-	return ctx.Query.Encode()
+	// spaces must be encoded as %20 instead of +
+	return strings.Replace(ctx.Query.Encode(), "+", "%20", -1)
 }
 
 // for each str in strs
@@ -525,7 +561,7 @@ func (ctx *SigningContext) hashCanonicalRequest(req *http.Request) string {
 	buf.WriteString(req.Method)
 	buf.WriteByte('\n')
 
-	buf.WriteString(buildCanonicalURI(req.URL))
+	buf.WriteString(buildCanonicalURI(req.URL, ctx.singleEscapePath))
 	buf.WriteByte('\n')
 
 	buf.WriteString(ctx.getCanonicalQuery(req.URL))
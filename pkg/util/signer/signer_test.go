@@ -20,6 +20,7 @@ package signer
 import (
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"testing"
@@ -348,3 +349,31 @@ func BenchmarkVerify(b *testing.B) {
 		signer.Verify(req)
 	}
 }
+
+func TestAWSLiteral(t *testing.T) {
+	// The example of https://docs.aws.amazon.com/general/latest/gr/sigv4-create-string-to-sign.html
+	signer := New().SetLiteral(AWSLiteral()).SetCredential("AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+	req, _ := http.NewRequest(http.MethodGet, "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	ts := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	if err := signer.NewContext(ts, "us-east-1", "iam").Sign(req); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("authorization should be:\n%s\nbut is:\n%s", expected, got)
+	}
+}
+
+func TestSingleEscapePath(t *testing.T) {
+	u, _ := url.Parse("https://bucket.s3.amazonaws.com/a%20b/c.txt")
+
+	if uri := buildCanonicalURI(u, false); uri != "/a%2520b/c.txt" {
+		t.Errorf("path should be escaped twice, but got %s", uri)
+	}
+	if uri := buildCanonicalURI(u, true); uri != "/a%20b/c.txt" {
+		t.Errorf("path should be escaped once, but got %s", uri)
+	}
+}
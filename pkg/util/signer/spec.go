@@ -17,7 +17,12 @@
 
 package signer
 
-import "time"
+import (
+	"fmt"
+	"time"
+
+	"github.com/megaease/easegress/pkg/util/fips"
+)
 
 // Spec defines the configuration of a Signer
 type Spec struct {
@@ -32,6 +37,21 @@ type Spec struct {
 	// TODO: AccessKeys is used as an internal access key store, but an external store is also needed
 }
 
+// Validate validates Spec.
+func (spec *Spec) Validate() error {
+	if spec.AccessKeySecret != "" {
+		if err := fips.CheckHMACKey([]byte(spec.AccessKeySecret)); err != nil {
+			return fmt.Errorf("accessKeySecret: %v", err)
+		}
+	}
+	for id, secret := range spec.AccessKeys {
+		if err := fips.CheckHMACKey([]byte(secret)); err != nil {
+			return fmt.Errorf("accessKeys %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
 type idSecretMap map[string]string
 
 func (m idSecretMap) GetSecret(id string) (string, bool) {
@@ -0,0 +1,123 @@
+//go:build linux
+// +build linux
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpoption
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const extendedOptionsSupported = true
+
+func setsockopt(conn syscall.Conn, level, opt, value int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), level, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func setFastOpen(l net.Listener, queueLength int) error {
+	conn, ok := l.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("listener %T is not a socket", l)
+	}
+	return setsockopt(conn, unix.IPPROTO_TCP, unix.TCP_FASTOPEN, queueLength)
+}
+
+func setKeepAliveProbes(conn *net.TCPConn, interval time.Duration, count int) error {
+	if err := conn.SetKeepAlive(true); err != nil {
+		return err
+	}
+	if interval > 0 {
+		secs := int(interval / time.Second)
+		if err := setsockopt(conn, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, secs); err != nil {
+			return err
+		}
+	}
+	if count > 0 {
+		if err := setsockopt(conn, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setBacklog sets the backlog of the listener by listening again, which
+// updates the backlog of the listening socket on Linux.
+func setBacklog(l net.Listener, backlog int) error {
+	conn, ok := l.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("listener %T is not a socket", l)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = unix.Listen(int(fd), backlog)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func setBuffers(c syscall.RawConn, readBuffer, writeBuffer int) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if readBuffer > 0 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF, readBuffer)
+		}
+		if sockErr == nil && writeBuffer > 0 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_SNDBUF, writeBuffer)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+func setReusePort(c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_REUSEPORT, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
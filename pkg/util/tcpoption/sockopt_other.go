@@ -0,0 +1,51 @@
+//go:build !linux
+// +build !linux
+
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpoption
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+const extendedOptionsSupported = false
+
+func setFastOpen(l net.Listener, queueLength int) error {
+	return fmt.Errorf("TCP fast open is not supported on this platform")
+}
+
+func setKeepAliveProbes(conn *net.TCPConn, interval time.Duration, count int) error {
+	return fmt.Errorf("keep-alive interval and count are not supported on this platform")
+}
+
+func setBacklog(l net.Listener, backlog int) error {
+	return fmt.Errorf("backlog is not supported on this platform")
+}
+
+// setBuffers does nothing, the buffers are set on the accepted connections.
+func setBuffers(c syscall.RawConn, readBuffer, writeBuffer int) error {
+	return nil
+}
+
+func setReusePort(c syscall.RawConn) error {
+	return fmt.Errorf("SO_REUSEPORT is not supported on this platform")
+}
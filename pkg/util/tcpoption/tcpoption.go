@@ -0,0 +1,216 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tcpoption applies TCP options to listeners and the connections
+// accepted by them.
+package tcpoption
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+type (
+	// Spec describes the TCP options.
+	Spec struct {
+		// NoDelay sets TCP_NODELAY, Go enables it by default.
+		NoDelay *bool `yaml:"noDelay,omitempty" jsonschema:"omitempty"`
+		// KeepAliveIdle is the idle time before sending keep-alive
+		// probes, KeepAliveInterval is the interval between probes,
+		// and KeepAliveCount is the number of unacknowledged probes
+		// before closing the connection.
+		KeepAliveIdle     string `yaml:"keepAliveIdle" jsonschema:"omitempty,format=duration"`
+		KeepAliveInterval string `yaml:"keepAliveInterval" jsonschema:"omitempty,format=duration"`
+		KeepAliveCount    uint16 `yaml:"keepAliveCount" jsonschema:"omitempty"`
+		// FastOpen is the queue length of pending TCP Fast Open requests,
+		// 0 disables TCP Fast Open.
+		FastOpen uint32 `yaml:"fastOpen" jsonschema:"omitempty"`
+		// ReadBuffer and WriteBuffer are the sizes of the socket buffers
+		// in bytes, 0 means the system default. They are set on the
+		// listening socket too, so the accepted connections inherit them
+		// from the handshake, when the TCP window scale is negotiated.
+		ReadBuffer  uint32 `yaml:"readBuffer" jsonschema:"omitempty"`
+		WriteBuffer uint32 `yaml:"writeBuffer" jsonschema:"omitempty"`
+		// Backlog is the length of the queue of connections waiting to be
+		// accepted, 0 means the system default, which is capped by
+		// net.core.somaxconn on Linux.
+		Backlog uint32 `yaml:"backlog" jsonschema:"omitempty"`
+		// ReusePort sets SO_REUSEPORT, so multiple processes or servers
+		// could listen on the same port, and the kernel balances the
+		// connections among them.
+		ReusePort bool `yaml:"reusePort" jsonschema:"omitempty"`
+	}
+
+	// Listener applies the TCP options to the accepted connections.
+	Listener struct {
+		net.Listener
+		spec *Spec
+
+		keepAliveIdle     time.Duration
+		keepAliveInterval time.Duration
+	}
+)
+
+// Validate validates the Spec.
+func (spec *Spec) Validate() error {
+	if _, err := parseDuration(spec.KeepAliveIdle); err != nil {
+		return fmt.Errorf("invalid keepAliveIdle: %v", err)
+	}
+	if _, err := parseDuration(spec.KeepAliveInterval); err != nil {
+		return fmt.Errorf("invalid keepAliveInterval: %v", err)
+	}
+	if spec.FastOpen > 0 || spec.KeepAliveCount > 0 || spec.KeepAliveInterval != "" || spec.ReusePort || spec.Backlog > 0 {
+		if !extendedOptionsSupported {
+			return fmt.Errorf("fastOpen, keepAliveInterval, keepAliveCount, reusePort and backlog are not supported on this platform")
+		}
+	}
+	return nil
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("%s is less than 1s", s)
+	}
+	return d, nil
+}
+
+// Listen creates a listener with the options which must be set before
+// binding, like SO_REUSEPORT, they are applied by the Control function of
+// net.ListenConfig.
+func Listen(network, address string, spec *Spec) (net.Listener, error) {
+	lc := &net.ListenConfig{Control: spec.control}
+	return lc.Listen(context.Background(), network, address)
+}
+
+// control is the Control function of net.ListenConfig, it sets the
+// options of the socket before binding.
+func (spec *Spec) control(network, address string, c syscall.RawConn) error {
+	if spec.ReusePort {
+		if err := setReusePort(c); err != nil {
+			return err
+		}
+	}
+	if spec.ReadBuffer > 0 || spec.WriteBuffer > 0 {
+		if err := setBuffers(c, int(spec.ReadBuffer), int(spec.WriteBuffer)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewListener wraps the listener to apply the TCP options, the options
+// of the listener itself, like TCP Fast Open and the backlog, are applied
+// immediately, so they take effect on the inherited listeners too.
+func NewListener(l net.Listener, spec *Spec) *Listener {
+	tl := &Listener{Listener: l, spec: spec}
+	tl.keepAliveIdle, _ = parseDuration(spec.KeepAliveIdle)
+	tl.keepAliveInterval, _ = parseDuration(spec.KeepAliveInterval)
+
+	if spec.FastOpen > 0 {
+		if err := setFastOpen(l, int(spec.FastOpen)); err != nil {
+			logger.Warnf("set TCP fast open failed: %v", err)
+		}
+	}
+	if spec.Backlog > 0 {
+		if err := setBacklog(l, int(spec.Backlog)); err != nil {
+			logger.Warnf("set backlog failed: %v", err)
+		}
+	}
+	if spec.ReadBuffer > 0 || spec.WriteBuffer > 0 {
+		if err := setListenerBuffers(l, int(spec.ReadBuffer), int(spec.WriteBuffer)); err != nil {
+			logger.Warnf("set buffers of listener failed: %v", err)
+		}
+	}
+
+	return tl
+}
+
+func setListenerBuffers(l net.Listener, readBuffer, writeBuffer int) error {
+	conn, ok := l.(syscall.Conn)
+	if !ok {
+		return fmt.Errorf("listener %T is not a socket", l)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return setBuffers(raw, readBuffer, writeBuffer)
+}
+
+// Accept accepts a connection and applies the TCP options to it.
+func (l *Listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if tc, ok := conn.(*net.TCPConn); ok {
+		if err := l.apply(tc); err != nil {
+			logger.Warnf("apply TCP options to connection from %s failed: %v", conn.RemoteAddr(), err)
+		}
+	}
+
+	return conn, nil
+}
+
+func (l *Listener) apply(conn *net.TCPConn) error {
+	if l.spec.NoDelay != nil {
+		if err := conn.SetNoDelay(*l.spec.NoDelay); err != nil {
+			return err
+		}
+	}
+
+	if l.keepAliveIdle > 0 {
+		if err := conn.SetKeepAlive(true); err != nil {
+			return err
+		}
+		// NOTE: It sets both the idle time and the interval.
+		if err := conn.SetKeepAlivePeriod(l.keepAliveIdle); err != nil {
+			return err
+		}
+	}
+	if l.keepAliveInterval > 0 || l.spec.KeepAliveCount > 0 {
+		if err := setKeepAliveProbes(conn, l.keepAliveInterval, int(l.spec.KeepAliveCount)); err != nil {
+			return err
+		}
+	}
+
+	if l.spec.ReadBuffer > 0 {
+		if err := conn.SetReadBuffer(int(l.spec.ReadBuffer)); err != nil {
+			return err
+		}
+	}
+	if l.spec.WriteBuffer > 0 {
+		if err := conn.SetWriteBuffer(int(l.spec.WriteBuffer)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
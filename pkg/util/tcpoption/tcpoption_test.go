@@ -0,0 +1,147 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tcpoption
+
+import (
+	"net"
+	"testing"
+
+	"github.com/megaease/easegress/pkg/logger"
+)
+
+func TestValidate(t *testing.T) {
+	spec := &Spec{KeepAliveIdle: "30s"}
+	if err := spec.Validate(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	spec = &Spec{KeepAliveIdle: "100ms"}
+	if err := spec.Validate(); err == nil {
+		t.Errorf("expect error for keepAliveIdle less than 1s")
+	}
+
+	spec = &Spec{KeepAliveInterval: "abc"}
+	if err := spec.Validate(); err == nil {
+		t.Errorf("expect error for invalid keepAliveInterval")
+	}
+}
+
+func TestListener(t *testing.T) {
+	logger.InitNop()
+
+	noDelay := false
+	spec := &Spec{
+		NoDelay:       &noDelay,
+		KeepAliveIdle: "30s",
+		ReadBuffer:    64 * 1024,
+		WriteBuffer:   64 * 1024,
+	}
+	if extendedOptionsSupported {
+		spec.KeepAliveInterval = "5s"
+		spec.KeepAliveCount = 3
+		spec.FastOpen = 16
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	tl := NewListener(l, spec)
+	defer tl.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			conn.Write([]byte("x"))
+			conn.Close()
+		}
+	}()
+
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := tl.apply(conn.(*net.TCPConn)); err != nil {
+		t.Errorf("apply options failed: %v", err)
+	}
+
+	buf := make([]byte, 1)
+	if _, err := conn.Read(buf); err != nil || buf[0] != 'x' {
+		t.Errorf("unexpected read result %q, error %v", buf, err)
+	}
+}
+
+func TestListenReusePort(t *testing.T) {
+	if !extendedOptionsSupported {
+		t.Skip("SO_REUSEPORT is not supported")
+	}
+
+	spec := &Spec{ReusePort: true}
+	l1, err := Listen("tcp", "127.0.0.1:0", spec)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	defer l1.Close()
+
+	l2, err := Listen("tcp", l1.Addr().String(), spec)
+	if err != nil {
+		t.Fatalf("listen on the same port failed: %v", err)
+	}
+	l2.Close()
+
+	// The port is exclusive without SO_REUSEPORT.
+	l3, err := Listen("tcp", l1.Addr().String(), &Spec{})
+	if err == nil {
+		l3.Close()
+		t.Errorf("listen on the same port should fail without reusePort")
+	}
+}
+
+func TestListenOptions(t *testing.T) {
+	logger.InitNop()
+
+	spec := &Spec{ReadBuffer: 128 * 1024, WriteBuffer: 128 * 1024}
+	if extendedOptionsSupported {
+		spec.Backlog = 16
+	}
+	if err := spec.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	l, err := Listen("tcp", "127.0.0.1:0", spec)
+	if err != nil {
+		t.Fatalf("listen failed: %v", err)
+	}
+	tl := NewListener(l, spec)
+	defer tl.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		if err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := tl.Accept()
+	if err != nil {
+		t.Fatalf("accept failed: %v", err)
+	}
+	conn.Close()
+}
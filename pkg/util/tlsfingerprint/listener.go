@@ -0,0 +1,135 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tlsfingerprint
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+type (
+	// Listener wraps a net.Listener to compute fingerprints of TLS clients,
+	// it must be placed under the TLS layer.
+	Listener struct {
+		net.Listener
+		conns sync.Map // remote address -> *conn
+	}
+
+	// conn records the data read from the connection until the ClientHello
+	// is parsed.
+	conn struct {
+		net.Conn
+		l *Listener
+
+		buff        []byte
+		done        bool
+		fingerprint atomic.Value // *Fingerprint
+	}
+
+	contextKey struct{}
+)
+
+// NewListener creates a Listener.
+func NewListener(l net.Listener) *Listener {
+	return &Listener{Listener: l}
+}
+
+// Accept waits for and returns the next connection to the listener.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	fc := &conn{Conn: c, l: l}
+	if key := connKey(c); key != "" {
+		l.conns.Store(key, fc)
+	}
+	return fc, nil
+}
+
+// connKey returns the key of the connection, it is empty for the unix
+// sockets, whose remote addresses are not unique, so their fingerprints
+// are not available rather than mixed up.
+func connKey(c net.Conn) string {
+	addr := c.RemoteAddr()
+	if addr == nil || addr.Network() == "unix" {
+		return ""
+	}
+	if key := addr.String(); key != "@" {
+		return key
+	}
+	return ""
+}
+
+// ConnContext is used as the ConnContext of http.Server, it saves the
+// connection to the context so that the fingerprint could be retrieved
+// by FromContext after the TLS handshake.
+func (l *Listener) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	key := connKey(c)
+	if key == "" {
+		return ctx
+	}
+	v, ok := l.conns.Load(key)
+	if !ok {
+		return ctx
+	}
+	return context.WithValue(ctx, contextKey{}, v)
+}
+
+// FromContext returns the fingerprint of the client, it returns nil if the
+// fingerprint is not available.
+func FromContext(ctx context.Context) *Fingerprint {
+	c, ok := ctx.Value(contextKey{}).(*conn)
+	if !ok {
+		return nil
+	}
+	fp, _ := c.fingerprint.Load().(*Fingerprint)
+	return fp
+}
+
+// Read reads data from the connection, and parses the ClientHello from
+// the data until it succeeds or fails.
+func (c *conn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.done || n == 0 {
+		return n, err
+	}
+
+	c.buff = append(c.buff, b[:n]...)
+	fp, e := Parse(c.buff)
+	if e == errIncomplete && len(c.buff) <= maxClientHelloLen+recordHeaderLen*4 {
+		return n, err
+	}
+
+	if e == nil {
+		c.fingerprint.Store(fp)
+	}
+	c.done, c.buff = true, nil
+	return n, err
+}
+
+// Close closes the connection.
+func (c *conn) Close() error {
+	if key := connKey(c); key != "" {
+		c.l.conns.Delete(key)
+	}
+	return c.Conn.Close()
+}
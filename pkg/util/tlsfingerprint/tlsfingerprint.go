@@ -0,0 +1,360 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tlsfingerprint computes JA3 and JA4 fingerprints of TLS clients
+// from the raw ClientHello message.
+package tlsfingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	recordTypeHandshake     = 22
+	handshakeTypeClientHelo = 1
+	recordHeaderLen         = 5
+	handshakeHeaderLen      = 4
+
+	extServerName          = 0x0000
+	extSupportedGroups     = 0x000a
+	extECPointFormats      = 0x000b
+	extSignatureAlgorithms = 0x000d
+	extALPN                = 0x0010
+	extSupportedVersions   = 0x002b
+
+	// maxClientHelloLen limits the size of ClientHello to be parsed.
+	maxClientHelloLen = 16 * 1024
+)
+
+// errIncomplete is returned when more data is required to parse the ClientHello.
+var errIncomplete = fmt.Errorf("incomplete client hello")
+
+type (
+	// Fingerprint is the fingerprint of a TLS client.
+	Fingerprint struct {
+		// JA3 is the MD5 hash of the JA3 string.
+		JA3 string
+		// JA3String is the raw JA3 string before hashing.
+		JA3String string
+		// JA4 is the JA4 fingerprint (the JA4_a_b_c form).
+		JA4 string
+	}
+
+	clientHello struct {
+		version             uint16
+		ciphers             []uint16
+		extensions          []uint16
+		curves              []uint16
+		points              []uint8
+		signatureAlgorithms []uint16
+		supportedVersions   []uint16
+		alpn                []string
+		hasSNI              bool
+	}
+
+	reader struct {
+		data []byte
+		err  error
+	}
+)
+
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = fmt.Errorf("malformed client hello")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) u8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *reader) u16() int {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<8 | int(b[1])
+}
+
+func (r *reader) u24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+func (r *reader) u16List(n int) []uint16 {
+	b := r.bytes(n)
+	var list []uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		list = append(list, uint16(b[i])<<8|uint16(b[i+1]))
+	}
+	return list
+}
+
+// handshakeMessage extracts the first handshake message from TLS records,
+// the message could span multiple records.
+func handshakeMessage(data []byte) ([]byte, error) {
+	var msg []byte
+	for {
+		if len(data) < recordHeaderLen {
+			return nil, errIncomplete
+		}
+		if data[0] != recordTypeHandshake {
+			return nil, fmt.Errorf("not a tls handshake record")
+		}
+		length := int(data[3])<<8 | int(data[4])
+		if len(data) < recordHeaderLen+length {
+			return nil, errIncomplete
+		}
+		msg = append(msg, data[recordHeaderLen:recordHeaderLen+length]...)
+		data = data[recordHeaderLen+length:]
+
+		if len(msg) < handshakeHeaderLen {
+			continue
+		}
+		if msg[0] != handshakeTypeClientHelo {
+			return nil, fmt.Errorf("not a client hello message")
+		}
+		msgLen := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+		if msgLen > maxClientHelloLen {
+			return nil, fmt.Errorf("client hello too large")
+		}
+		if len(msg) >= handshakeHeaderLen+msgLen {
+			return msg[:handshakeHeaderLen+msgLen], nil
+		}
+	}
+}
+
+func parseClientHello(data []byte) (*clientHello, error) {
+	msg, err := handshakeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &reader{data: msg}
+	r.bytes(handshakeHeaderLen)
+
+	ch := &clientHello{}
+	ch.version = uint16(r.u16())
+	r.bytes(32)     // random
+	r.bytes(r.u8()) // session id
+	ch.ciphers = r.u16List(r.u16())
+	r.bytes(r.u8()) // compression methods
+
+	if r.err == nil && len(r.data) > 0 {
+		exts := &reader{data: r.bytes(r.u16())}
+		for exts.err == nil && len(exts.data) > 0 {
+			typ := uint16(exts.u16())
+			ext := &reader{data: exts.bytes(exts.u16())}
+			ch.extensions = append(ch.extensions, typ)
+
+			switch typ {
+			case extServerName:
+				ch.hasSNI = true
+			case extSupportedGroups:
+				ch.curves = ext.u16List(ext.u16())
+			case extECPointFormats:
+				ch.points = ext.bytes(ext.u8())
+			case extSignatureAlgorithms:
+				ch.signatureAlgorithms = ext.u16List(ext.u16())
+			case extALPN:
+				protos := &reader{data: ext.bytes(ext.u16())}
+				for protos.err == nil && len(protos.data) > 0 {
+					ch.alpn = append(ch.alpn, string(protos.bytes(protos.u8())))
+				}
+			case extSupportedVersions:
+				ch.supportedVersions = ext.u16List(ext.u8())
+			}
+		}
+		if exts.err != nil {
+			return nil, exts.err
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return ch, nil
+}
+
+// Parse parses TLS records containing a ClientHello message and computes
+// its fingerprint.
+func Parse(data []byte) (*Fingerprint, error) {
+	ch, err := parseClientHello(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ja3 := ch.ja3String()
+	sum := md5.Sum([]byte(ja3))
+	return &Fingerprint{
+		JA3:       hex.EncodeToString(sum[:]),
+		JA3String: ja3,
+		JA4:       ch.ja4(),
+	}, nil
+}
+
+func joinUint16(list []uint16, sep string, format func(uint16) string) string {
+	var sb strings.Builder
+	for _, v := range list {
+		if isGREASE(v) {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(sep)
+		}
+		sb.WriteString(format(v))
+	}
+	return sb.String()
+}
+
+func decimal(v uint16) string {
+	return strconv.Itoa(int(v))
+}
+
+func hex4(v uint16) string {
+	return fmt.Sprintf("%04x", v)
+}
+
+func (ch *clientHello) ja3String() string {
+	points := make([]string, len(ch.points))
+	for i, p := range ch.points {
+		points[i] = strconv.Itoa(int(p))
+	}
+
+	return strings.Join([]string{
+		decimal(ch.version),
+		joinUint16(ch.ciphers, "-", decimal),
+		joinUint16(ch.extensions, "-", decimal),
+		joinUint16(ch.curves, "-", decimal),
+		strings.Join(points, "-"),
+	}, ",")
+}
+
+func withoutGREASE(list []uint16) []uint16 {
+	result := make([]uint16, 0, len(list))
+	for _, v := range list {
+		if !isGREASE(v) {
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+func truncatedSHA256(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func (ch *clientHello) ja4() string {
+	// the highest version in supported_versions takes precedence.
+	version := ch.version
+	if versions := withoutGREASE(ch.supportedVersions); len(versions) > 0 {
+		version = 0
+		for _, v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+	}
+
+	var versionStr string
+	switch version {
+	case 0x0304:
+		versionStr = "13"
+	case 0x0303:
+		versionStr = "12"
+	case 0x0302:
+		versionStr = "11"
+	case 0x0301:
+		versionStr = "10"
+	case 0x0300:
+		versionStr = "s3"
+	default:
+		versionStr = "00"
+	}
+
+	sni := "i"
+	if ch.hasSNI {
+		sni = "d"
+	}
+
+	ciphers := withoutGREASE(ch.ciphers)
+	extensions := withoutGREASE(ch.extensions)
+
+	alpn := "00"
+	if len(ch.alpn) > 0 && len(ch.alpn[0]) > 0 {
+		first := ch.alpn[0]
+		alpn = string(first[0]) + string(first[len(first)-1])
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s", versionStr, sni,
+		minInt(len(ciphers), 99), minInt(len(extensions), 99), alpn)
+
+	sort.Slice(ciphers, func(i, j int) bool { return ciphers[i] < ciphers[j] })
+	b := truncatedSHA256(joinUint16(ciphers, ",", hex4))
+
+	var sortedExts []uint16
+	for _, e := range extensions {
+		if e != extServerName && e != extALPN {
+			sortedExts = append(sortedExts, e)
+		}
+	}
+	sort.Slice(sortedExts, func(i, j int) bool { return sortedExts[i] < sortedExts[j] })
+	c := joinUint16(sortedExts, ",", hex4)
+	if len(ch.signatureAlgorithms) > 0 {
+		c += "_" + joinUint16(ch.signatureAlgorithms, ",", hex4)
+	}
+	if len(sortedExts) == 0 {
+		c = ""
+	}
+
+	return a + "_" + b + "_" + truncatedSHA256(c)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
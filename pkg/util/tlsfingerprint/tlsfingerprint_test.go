@@ -0,0 +1,212 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tlsfingerprint
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureClientHello returns the raw ClientHello records sent by a Go TLS client.
+func captureClientHello(t *testing.T, config *tls.Config) []byte {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	go func() {
+		tls.Client(client, config).Handshake()
+		client.Close()
+	}()
+
+	buff := make([]byte, 0, 4096)
+	for {
+		b := make([]byte, 1024)
+		n, err := server.Read(b)
+		buff = append(buff, b[:n]...)
+		if _, e := handshakeMessage(buff); e != errIncomplete || err != nil {
+			return buff
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	data := captureClientHello(t, &tls.Config{
+		ServerName: "www.megaease.com",
+		NextProtos: []string{"h2", "http/1.1"},
+	})
+
+	fp, err := Parse(data)
+	if err != nil {
+		t.Fatalf("parse client hello failed: %v", err)
+	}
+
+	if len(fp.JA3) != 32 {
+		t.Errorf("invalid ja3 hash: %s", fp.JA3)
+	}
+	if !strings.HasPrefix(fp.JA3String, "771,") {
+		t.Errorf("invalid ja3 string: %s", fp.JA3String)
+	}
+
+	parts := strings.Split(fp.JA4, "_")
+	if len(parts) != 3 || len(parts[1]) != 12 || len(parts[2]) != 12 {
+		t.Fatalf("invalid ja4: %s", fp.JA4)
+	}
+	if !strings.HasPrefix(parts[0], "t13d") || !strings.HasSuffix(parts[0], "h2") {
+		t.Errorf("invalid ja4_a: %s", parts[0])
+	}
+
+	noSNI, err := Parse(captureClientHello(t, &tls.Config{InsecureSkipVerify: true}))
+	if err != nil {
+		t.Fatalf("parse client hello failed: %v", err)
+	}
+	if !strings.HasPrefix(noSNI.JA4, "t13i") || !strings.HasSuffix(strings.Split(noSNI.JA4, "_")[0], "00") {
+		t.Errorf("invalid ja4: %s", noSNI.JA4)
+	}
+
+	if _, err = Parse(data[:len(data)-1]); err != errIncomplete {
+		t.Errorf("expected errIncomplete, got %v", err)
+	}
+	if _, err = Parse([]byte("GET / HTTP/1.1\r\n")); err == nil {
+		t.Errorf("expected error for non-tls data")
+	}
+}
+
+func TestGREASE(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !isGREASE(v) {
+			t.Errorf("%04x should be GREASE", v)
+		}
+	}
+	for _, v := range []uint16{0x0a1a, 0x1301, 0x0000} {
+		if isGREASE(v) {
+			t.Errorf("%04x should not be GREASE", v)
+		}
+	}
+}
+
+func TestListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(ln)
+	defer l.Close()
+
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			return
+		}
+		tls.Client(c, &tls.Config{ServerName: "localhost"}).Handshake()
+		c.Close()
+	}()
+
+	c, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx := l.ConnContext(context.Background(), c)
+	if FromContext(ctx) != nil {
+		t.Errorf("fingerprint should not be available before handshake")
+	}
+
+	// the handshake fails because the server has no certificate, but the
+	// fingerprint is available after the ClientHello is read.
+	tls.Server(c, &tls.Config{}).Handshake()
+	if fp := FromContext(ctx); fp == nil || !strings.HasPrefix(fp.JA4, "t13d") {
+		t.Errorf("invalid fingerprint: %+v", fp)
+	}
+}
+
+func TestListenerUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tlsfingerprint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewListener(ln)
+	defer l.Close()
+
+	// Two clients connect concurrently, with different ClientHellos.
+	for _, serverName := range []string{"a.example.com", "b.example.com"} {
+		go func(serverName string) {
+			c, err := net.Dial("unix", path)
+			if err != nil {
+				return
+			}
+			tls.Client(c, &tls.Config{ServerName: serverName}).Handshake()
+			c.Close()
+		}(serverName)
+	}
+
+	var conns [2]net.Conn
+	var ctxs [2]context.Context
+	for i := range conns {
+		c, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		conns[i], ctxs[i] = c, l.ConnContext(context.Background(), c)
+	}
+
+	done := make(chan struct{})
+	for _, c := range conns {
+		go func(c net.Conn) {
+			tls.Server(c, &tls.Config{}).Handshake()
+			done <- struct{}{}
+		}(c)
+	}
+	<-done
+	<-done
+
+	// The remote addresses of the clients are the same, so fingerprints
+	// are not available rather than mixed up.
+	for i, ctx := range ctxs {
+		if fp := FromContext(ctx); fp != nil {
+			t.Errorf("client %d: fingerprint should not be available, got %+v", i, fp)
+		}
+	}
+
+	// Closing one connection doesn't affect the other one.
+	conns[0].Close()
+	if _, err := conns[1].Write(nil); err != nil {
+		t.Errorf("the other connection should not be affected: %v", err)
+	}
+	count := 0
+	l.conns.Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	if count != 0 {
+		t.Errorf("unix socket connections should not be tracked, got %d", count)
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package tokencache caches the tokens fetched from token services until
+// they are about to expire.
+package tokencache
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// refreshWindow is the max time before the expiration to refresh
+	// tokens, it is half of the lifetime for short-lived tokens.
+	refreshWindow = 5 * time.Minute
+
+	// retryInterval is the interval to retry the failed refresh while the
+	// token is still valid.
+	retryInterval = 10 * time.Second
+
+	// defaultLifetime is used if the lifetime of a token is unknown.
+	defaultLifetime = 10 * time.Minute
+
+	maxResponseSize = 64 * 1024
+)
+
+type (
+	// Token is a token with its expiry.
+	Token struct {
+		Value  string
+		Expiry time.Time
+	}
+
+	// Source fetches new tokens.
+	Source interface {
+		Fetch() (*Token, error)
+	}
+
+	// Cache caches the token of the source until it is about to expire.
+	Cache struct {
+		source Source
+
+		mutex      sync.Mutex
+		token      *Token
+		refreshAt  time.Time
+		refreshing chan struct{}
+		err        error
+	}
+)
+
+// New creates a Cache.
+func New(source Source) *Cache {
+	return &Cache{source: source}
+}
+
+// Get returns the cached token. The token about to expire is refreshed
+// in the background while it is still returned, and the callers wait for
+// the only fetch if there's no valid token.
+func (c *Cache) Get() (string, error) {
+	c.mutex.Lock()
+
+	now := time.Now()
+	t := c.token
+	if t != nil && now.Before(c.refreshAt) {
+		c.mutex.Unlock()
+		return t.Value, nil
+	}
+
+	done := c.refresh()
+	if t != nil && now.Before(t.Expiry) {
+		c.mutex.Unlock()
+		return t.Value, nil
+	}
+	c.mutex.Unlock()
+
+	<-done
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != nil && time.Now().Before(c.token.Expiry) {
+		return c.token.Value, nil
+	}
+	if c.err != nil {
+		return "", c.err
+	}
+	return "", fmt.Errorf("token expired")
+}
+
+// refresh starts fetching a new token if there isn't a fetch, and returns
+// the channel closed after the fetch, the caller must hold the lock.
+func (c *Cache) refresh() chan struct{} {
+	if c.refreshing != nil {
+		return c.refreshing
+	}
+
+	done := make(chan struct{})
+	c.refreshing = done
+
+	go func() {
+		t, err := c.source.Fetch()
+		now := time.Now()
+
+		c.mutex.Lock()
+		c.err = err
+		if err == nil {
+			c.token = t
+			c.refreshAt = refreshTime(now, t.Expiry)
+		} else if c.token != nil {
+			// NOTE: Don't fetch for every request while the refresh
+			// keeps failing.
+			c.refreshAt = now.Add(retryInterval)
+		}
+		c.refreshing = nil
+		c.mutex.Unlock()
+
+		close(done)
+	}()
+
+	return done
+}
+
+// refreshTime returns the time to refresh the token fetched at now, it is
+// refreshWindow before the expiry, or half of the lifetime for the token
+// whose lifetime is shorter than twice of refreshWindow.
+func refreshTime(now, expiry time.Time) time.Time {
+	window := expiry.Sub(now) / 2
+	if window > refreshWindow {
+		window = refreshWindow
+	} else if window < 0 {
+		window = 0
+	}
+	return expiry.Add(-window)
+}
+
+// Invalidate removes the cached token if it is the value, e.g. it is
+// rejected by the server, so a new one is fetched next time.
+func (c *Cache) Invalidate(value string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.token != nil && c.token.Value == value {
+		c.token = nil
+	}
+}
+
+// Expiry returns the expiry of the token, it is from the exp claim if the
+// token is a JWT, or from expiresIn in seconds otherwise.
+func Expiry(value string, expiresIn int64) time.Time {
+	now := time.Now()
+
+	parts := strings.Split(value, ".")
+	if len(parts) == 3 {
+		payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+		if err == nil {
+			claims := struct {
+				Exp int64 `json:"exp"`
+			}{}
+			if json.Unmarshal(payload, &claims) == nil && claims.Exp > 0 {
+				return time.Unix(claims.Exp, 0)
+			}
+		}
+	}
+
+	if expiresIn > 0 {
+		return now.Add(time.Duration(expiresIn) * time.Second)
+	}
+	return now.Add(defaultLifetime)
+}
+
+// Do sends the request to the token service, and returns the body of the
+// response, non-200 responses are errors.
+func Do(client *http.Client, req *http.Request) ([]byte, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
@@ -0,0 +1,249 @@
+/*
+ * Copyright (c) 2017, MegaEase
+ * All rights reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tokencache
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newJWT(exp time.Time) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(`{"alg":"RS256"}`)) + "." +
+		enc.EncodeToString([]byte(fmt.Sprintf(`{"aud":"test","exp":%d}`, exp.Unix()))) + ".sig"
+}
+
+func TestExpiry(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	if got := Expiry(newJWT(exp), 0); !got.Equal(exp) {
+		t.Errorf("expiry should be %v, got %v", exp, got)
+	}
+
+	got := Expiry("opaque", 60)
+	if d := time.Until(got); d < 50*time.Second || d > time.Minute {
+		t.Errorf("expiry should be from expiresIn, got %v", d)
+	}
+
+	got = Expiry("opaque", 0)
+	if d := time.Until(got); d < defaultLifetime-time.Second || d > defaultLifetime {
+		t.Errorf("expiry should be the default, got %v", d)
+	}
+}
+
+type countingSource struct {
+	mutex sync.Mutex
+	count int
+	token *Token
+	err   error
+	// block blocks the fetches until it is closed if it is not nil.
+	block chan struct{}
+}
+
+func (s *countingSource) Fetch() (*Token, error) {
+	s.mutex.Lock()
+	block := s.block
+	s.mutex.Unlock()
+	if block != nil {
+		<-block
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.count++
+	if s.token == nil {
+		return nil, s.err
+	}
+	t := *s.token
+	return &t, s.err
+}
+
+func (s *countingSource) set(token *Token, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.token, s.err = token, err
+}
+
+func (s *countingSource) fetched() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.count
+}
+
+// wait waits for the refresh in the background.
+func (c *Cache) wait() {
+	c.mutex.Lock()
+	done := c.refreshing
+	c.mutex.Unlock()
+	if done != nil {
+		<-done
+	}
+}
+
+// expireIn changes the expiry of the cached token.
+func (c *Cache) expireIn(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.token.Expiry = time.Now().Add(d)
+	c.refreshAt = refreshTime(time.Now().Add(-time.Hour), c.token.Expiry)
+}
+
+func TestCache(t *testing.T) {
+	s := &countingSource{token: &Token{Value: "a", Expiry: time.Now().Add(time.Hour)}}
+	c := New(s)
+
+	for i := 0; i < 3; i++ {
+		if v, err := c.Get(); err != nil || v != "a" {
+			t.Fatalf("unexpected token %s: %v", v, err)
+		}
+	}
+	if s.fetched() != 1 {
+		t.Errorf("token should be cached, fetched %d times", s.fetched())
+	}
+
+	// Only the current token is invalidated.
+	c.Invalidate("b")
+	c.Get()
+	if s.fetched() != 1 {
+		t.Errorf("token should not be invalidated")
+	}
+	c.Invalidate("a")
+	c.Get()
+	if s.fetched() != 2 {
+		t.Errorf("token should be fetched again after invalidation")
+	}
+
+	// The token about to expire is still used if the refresh fails, and
+	// the refresh is not retried for every request.
+	c.expireIn(time.Minute)
+	s.set(nil, fmt.Errorf("failed"))
+	if v, err := c.Get(); err != nil || v != "a" {
+		t.Errorf("cached token should be used: %v", err)
+	}
+	c.wait()
+	c.Get()
+	c.wait()
+	if s.fetched() != 3 {
+		t.Errorf("token should be fetched once, fetched %d times", s.fetched()-2)
+	}
+
+	c.expireIn(-time.Minute)
+	if _, err := c.Get(); err == nil {
+		t.Errorf("expired token should not be used")
+	}
+}
+
+func TestCacheShortLived(t *testing.T) {
+	s := &countingSource{token: &Token{Value: "a", Expiry: time.Now().Add(2 * time.Minute)}}
+	c := New(s)
+
+	for i := 0; i < 10; i++ {
+		if v, err := c.Get(); err != nil || v != "a" {
+			t.Fatalf("unexpected token %s: %v", v, err)
+		}
+	}
+	c.wait()
+	if s.fetched() != 1 {
+		t.Errorf("short-lived token should be fetched once, fetched %d times", s.fetched())
+	}
+
+	refreshAt := refreshTime(time.Now(), time.Now().Add(2*time.Minute))
+	if d := time.Until(refreshAt); d < 55*time.Second || d > time.Minute {
+		t.Errorf("token should be refreshed at half of its lifetime, but in %v", d)
+	}
+}
+
+func TestCacheConcurrentRefresh(t *testing.T) {
+	s := &countingSource{
+		token: &Token{Value: "a", Expiry: time.Now().Add(time.Hour)},
+		block: make(chan struct{}),
+	}
+	c := New(s)
+
+	// The callers without a valid token wait for the only fetch.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Get(); err != nil || v != "a" {
+				t.Errorf("unexpected token %s: %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(s.block)
+	wg.Wait()
+	if s.fetched() != 1 {
+		t.Fatalf("token should be fetched once, fetched %d times", s.fetched())
+	}
+
+	// The token about to expire is served while it is being refreshed.
+	block := make(chan struct{})
+	s.mutex.Lock()
+	s.block = block
+	s.token = &Token{Value: "b", Expiry: time.Now().Add(time.Hour)}
+	s.mutex.Unlock()
+	c.expireIn(time.Minute)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := c.Get(); err != nil || v != "a" {
+				t.Errorf("unexpected token %s: %v", v, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(block)
+	c.wait()
+	if v, _ := c.Get(); v != "b" || s.fetched() != 2 {
+		t.Errorf("token should be refreshed once, got %s after %d fetches", v, s.fetched())
+	}
+}
+
+func TestDo(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "not found")
+			return
+		}
+		fmt.Fprint(w, "token")
+	}))
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/token", nil)
+	body, err := Do(server.Client(), req)
+	if err != nil || string(body) != "token" {
+		t.Errorf("unexpected body %s: %v", body, err)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, server.URL+"/other", nil)
+	_, err = Do(server.Client(), req)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expect not found, got %v", err)
+	}
+}
@@ -28,6 +28,11 @@ import (
 
 type (
 	// TopN is the statistics tool for HTTP traffic.
+	//
+	// Stat doesn't lock on known paths, the statistics of patterns are
+	// kept in a sync.Map and recorded lock free by HTTPStat. Only the
+	// paths missing in the cache of URLClusterAnalyzer lock it to learn
+	// their patterns.
 	TopN struct {
 		m   sync.Map
 		n   int
@@ -27,13 +27,23 @@ import (
 const (
 	maxValues = 20
 	maxLayers = 256
+
+	// cacheShards is the number of shards of the cache, every shard has
+	// its own lock, so looking up the cache doesn't serialize requests.
+	cacheShards = 16
+	cacheSize   = 4096
 )
 
 // URLClusterAnalyzer is url cluster analyzer.
+//
+// The patterns of known paths are looked up in the sharded cache. Only
+// the cache misses, i.e. the paths not seen recently, lock the tree of
+// fields to learn the pattern, and the trees of paths of different
+// numbers of layers have their own locks.
 type URLClusterAnalyzer struct {
-	slots []*field `yaml:"slots"`
-	mutex *sync.Mutex
-	cache *lru.Cache
+	slots   []*field `yaml:"slots"`
+	mutexes []sync.Mutex
+	caches  [cacheShards]*lru.Cache
 }
 
 type field struct {
@@ -58,11 +68,12 @@ func newField(name string) *field {
 
 // New creates a URLClusterAnalyzer.
 func New() *URLClusterAnalyzer {
-	cache, _ := lru.New(4098)
 	u := &URLClusterAnalyzer{
-		mutex: &sync.Mutex{},
-		slots: make([]*field, maxLayers),
-		cache: cache,
+		slots:   make([]*field, maxLayers),
+		mutexes: make([]sync.Mutex, maxLayers),
+	}
+	for i := range u.caches {
+		u.caches[i], _ = lru.New(cacheSize / cacheShards)
 	}
 
 	for i := 0; i < maxLayers; i++ {
@@ -79,7 +90,8 @@ func (u *URLClusterAnalyzer) GetPattern(urlPath string) string {
 	if urlPath == "" {
 		return ""
 	}
-	if val, ok := u.cache.Get(urlPath); ok {
+	cache := u.cache(urlPath)
+	if val, ok := cache.Get(urlPath); ok {
 		return val.(string)
 	}
 
@@ -94,12 +106,12 @@ func (u *URLClusterAnalyzer) GetPattern(urlPath string) string {
 	if len(values) >= maxLayers {
 		pos = maxLayers - 1
 	}
+	u.mutexes[pos].Lock()
+	defer u.mutexes[pos].Unlock()
+
 	currField := u.slots[pos]
 	currPattern := currField.pattern
 
-	u.mutex.Lock()
-	defer u.mutex.Unlock()
-
 LOOP:
 	for i := 0; i < len(values); i++ {
 
@@ -140,7 +152,18 @@ LOOP:
 		currField = newF
 	}
 
-	u.cache.Add(urlPath, currPattern)
+	cache.Add(urlPath, currPattern)
 
 	return currPattern
 }
+
+// cache returns the shard of the cache for the path, by the FNV-1a hash
+// of the path.
+func (u *URLClusterAnalyzer) cache(urlPath string) *lru.Cache {
+	h := uint32(2166136261)
+	for i := 0; i < len(urlPath); i++ {
+		h ^= uint32(urlPath[i])
+		h *= 16777619
+	}
+	return u.caches[h%cacheShards]
+}